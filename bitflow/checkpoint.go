@@ -0,0 +1,162 @@
+package bitflow
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+// Checkpointable can be implemented by stateful SampleProcessors to allow persisting their internal state
+// and restoring it after a restart. See CheckpointCoordinator.
+type Checkpointable interface {
+	// SaveState writes the current internal state to the given writer.
+	SaveState(w io.Writer) error
+
+	// LoadState restores the internal state from data previously written by SaveState.
+	LoadState(r io.Reader) error
+}
+
+type checkpointEntry struct {
+	Name  string
+	State []byte
+}
+
+// CheckpointCoordinator is a SampleProcessor that periodically persists the state of a number of Checkpointable
+// steps to a file, and restores their state from that file when started. It should be the first processor
+// in a pipeline, while the Checkpointable steps follow it. Samples are forwarded synchronously, so no sample
+// is being processed by the following steps while a checkpoint is stored, as long as all steps between the
+// CheckpointCoordinator and a Checkpointable step also forward synchronously. Steps that process samples in other
+// goroutines, like the DecouplingProcessor or a BatchProcessor with a WorkerPool, break this guarantee: a checkpoint
+// of a step following them can be stored concurrently to processing a sample, and can miss samples that are still queued.
+// A final checkpoint is stored when the CheckpointCoordinator is closed.
+type CheckpointCoordinator struct {
+	NoopProcessor
+	File     string
+	Interval time.Duration
+	Steps    []Checkpointable
+
+	lastCheckpoint time.Time
+	lock           sync.Mutex
+}
+
+// NewCheckpointCoordinator creates a CheckpointCoordinator for all Checkpointable steps among the given processors.
+func NewCheckpointCoordinator(file string, interval time.Duration, processors []SampleProcessor) *CheckpointCoordinator {
+	coordinator := &CheckpointCoordinator{
+		File:     file,
+		Interval: interval,
+	}
+	for _, processor := range processors {
+		if step, ok := processor.(Checkpointable); ok {
+			coordinator.Steps = append(coordinator.Steps, step)
+		}
+	}
+	return coordinator
+}
+
+// Start restores the state of all steps, if the checkpoint file exists.
+func (c *CheckpointCoordinator) Start(wg *sync.WaitGroup) golib.StopChan {
+	if err := c.Restore(); err != nil {
+		return golib.NewStoppedChan(err)
+	}
+	c.lastCheckpoint = time.Now()
+	return c.NoopProcessor.Start(wg)
+}
+
+func (c *CheckpointCoordinator) Sample(sample *Sample, header *Header) error {
+	if c.Interval > 0 && time.Since(c.lastCheckpoint) >= c.Interval {
+		if err := c.Checkpoint(); err != nil {
+			log.Errorf("%v: %v", c, err)
+		}
+	}
+	return c.NoopProcessor.Sample(sample, header)
+}
+
+func (c *CheckpointCoordinator) Close() {
+	if err := c.Checkpoint(); err != nil {
+		log.Errorf("%v: %v", c, err)
+	}
+	c.NoopProcessor.Close()
+}
+
+func (c *CheckpointCoordinator) String() string {
+	return fmt.Sprintf("Checkpoint %v step(s) to %v every %v", len(c.Steps), c.File, c.Interval)
+}
+
+func (c *CheckpointCoordinator) stepName(i int, step Checkpointable) string {
+	return fmt.Sprintf("%v: %v", i, step)
+}
+
+// Checkpoint stores the state of all steps. The checkpoint file is replaced atomically.
+func (c *CheckpointCoordinator) Checkpoint() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lastCheckpoint = time.Now()
+	entries := make([]checkpointEntry, len(c.Steps))
+	for i, step := range c.Steps {
+		var buf bytes.Buffer
+		if err := step.SaveState(&buf); err != nil {
+			return fmt.Errorf("Failed to save state of %v: %v", step, err)
+		}
+		entries[i] = checkpointEntry{Name: c.stepName(i, step), State: buf.Bytes()}
+	}
+
+	tmpFile := c.File + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(entries)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, c.File)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write checkpoint file %v: %v", c.File, err)
+	}
+	return nil
+}
+
+// Restore loads the state of all steps from the checkpoint file. Nothing is done, if the file does not exist.
+// Steps without a matching entry in the checkpoint file keep their initial state.
+func (c *CheckpointCoordinator) Restore() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	file, err := os.Open(c.File)
+	if os.IsNotExist(err) {
+		log.Printf("%v: No checkpoint file found, starting with empty state", c)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close() // Drop error
+	var entries []checkpointEntry
+	if err := gob.NewDecoder(file).Decode(&entries); err != nil {
+		return fmt.Errorf("Failed to read checkpoint file %v: %v", c.File, err)
+	}
+	states := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		states[entry.Name] = entry.State
+	}
+	for i, step := range c.Steps {
+		name := c.stepName(i, step)
+		state, ok := states[name]
+		if !ok {
+			log.Warnf("%v: No checkpointed state for step %v", c, name)
+			continue
+		}
+		if err := step.LoadState(bytes.NewReader(state)); err != nil {
+			return fmt.Errorf("Failed to restore state of %v: %v", step, err)
+		}
+	}
+	log.Printf("%v: Restored state of %v step(s) from %v", c, len(c.Steps), c.File)
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/antongulenko/golib"
 	log "github.com/sirupsen/logrus"
@@ -32,6 +33,7 @@ const (
 	TextFormat      = MarshallingFormat("text")
 	CsvFormat       = MarshallingFormat("csv")
 	BinaryFormat    = MarshallingFormat("bin")
+	JsonFormat      = MarshallingFormat("json")
 
 	tcp_download_retry_interval = 1000 * time.Millisecond
 	tcp_dial_timeout            = 2000 * time.Millisecond
@@ -40,6 +42,7 @@ const (
 var (
 	stdTransportTarget = "-"
 	binaryFileSuffix   = ".bin"
+	jsonFileSuffix     = ".json"
 )
 
 var DefaultEndpointFactory = EndpointFactory{
@@ -75,11 +78,39 @@ type EndpointFactory struct {
 	// File input/output flags
 
 	FlagInputFilesRobust  bool
+	FlagDeadLetter        string
+	FlagFilesRecursive    bool
 	FlagOutputFilesClean  bool
 	FlagIoBuffer          int
 	FlagFilesKeepAlive    bool
 	FlagFilesAppend       bool
 	FlagFileVanishedCheck time.Duration
+	FlagFilesFsync        bool
+	FlagFilesFsyncPeriod  time.Duration
+	FlagFilesMaxSize      int64
+	FlagOutputMetadata    bool
+	FlagHeaderInterval    time.Duration
+	FlagOutputsDropErrors bool
+
+	// CSV input flags, see CsvMarshaller
+
+	FlagCsvTimeColumn string
+	FlagCsvTagsColumn string
+	FlagCsvRowTime    time.Duration
+
+	// CSV input/output flags, see CsvMarshaller
+
+	FlagCsvSeparator  string
+	FlagCsvQuote      bool
+	FlagCsvIntegers   bool
+	FlagCsvTimeFormat string
+
+	FlagValueCountPolicy string
+	FlagMaxLineLength    int
+
+	// Binary output flags, see BinaryMarshaller
+
+	FlagBinaryTagDictionary int
 
 	// TCP input/output flags
 
@@ -87,12 +118,22 @@ type EndpointFactory struct {
 	FlagTcpConnectionLimit    uint
 	FlagInputTcpAcceptLimit   uint
 	FlagTcpSourceDropErrors   bool
+	FlagTcpSourceGapTag       string
+	FlagTcpSourceRetryJitter  float64
+	FlagTcpSourceRetryMax     int
 	FlagTcpLogReceivedData    bool
+	FlagTcpPoolSize           int
+	FlagTcpHealthCheck        time.Duration
+	FlagTcpReconnect          bool
 
 	// Parallel marshalling/unmarshalling flags
 
 	FlagParallelHandler ParallelSampleHandler
 
+	// PipelineDescription is stored in the output metadata, if enabled through FlagOutputMetadata.
+	// It is not set by command line flags.
+	PipelineDescription string
+
 	// CustomDataSources can be filled by client code before EndpointFactory.CreateInput or similar
 	// methods to allow creation of custom data sources. The map key is a short name of the data source
 	// that can be used in URL endpoint descriptions. The parameter for the function will be
@@ -107,6 +148,14 @@ type EndpointFactory struct {
 	// map keys and values.
 	CustomDataSinks map[EndpointType]func(string) (SampleProcessor, error)
 
+	// CustomDefaultFormats allows custom data sinks registered in CustomDataSinks to receive a Marshaller.
+	// For the endpoint types in this map, a format can be specified in URL endpoint descriptions, e.g.:
+	//   csv+db://localhost:5555
+	// If no format is specified, the map value is used as default format. If the created sink implements
+	// MarshallingSampleOutput, the Marshaller for the format is passed to SetMarshaller().
+	// Without an entry in this map, specifying a format for a custom endpoint type is an error.
+	CustomDefaultFormats map[EndpointType]MarshallingFormat
+
 	// Marshallers can be filled by client code before EndpointFactory.CreateOutput or similar
 	// methods to allow custom marshalling formats in output files, network connections and so on.
 	Marshallers map[MarshallingFormat]func() Marshaller
@@ -129,6 +178,7 @@ func NewEndpointFactory() *EndpointFactory {
 func (f *EndpointFactory) Clear() {
 	f.CustomDataSources = make(map[EndpointType]func(string) (SampleSource, error))
 	f.CustomDataSinks = make(map[EndpointType]func(string) (SampleProcessor, error))
+	f.CustomDefaultFormats = make(map[EndpointType]MarshallingFormat)
 	f.Marshallers = make(map[MarshallingFormat]func() Marshaller)
 	f.CustomGeneralFlags = nil
 	f.CustomInputFlags = nil
@@ -139,6 +189,7 @@ func RegisterDefaults(factory *EndpointFactory) {
 	RegisterBuiltinMarshallers(factory)
 	RegisterConsoleBoxOutput(factory)
 	RegisterEmptyInputOutput(factory)
+	RegisterLogOutput(factory)
 }
 
 func RegisterEmptyInputOutput(factory *EndpointFactory) {
@@ -160,6 +211,42 @@ func RegisterBuiltinMarshallers(factory *EndpointFactory) {
 	factory.Marshallers[BinaryFormat] = func() Marshaller {
 		return BinaryMarshaller{}
 	}
+	factory.Marshallers[JsonFormat] = func() Marshaller {
+		return JsonMarshaller{}
+	}
+}
+
+func (f *EndpointFactory) csvMarshaller() CsvMarshaller {
+	separator, _ := ParseCsvSeparator(f.FlagCsvSeparator) // The error is checked in CreateInput() and CreateOutput()
+	return CsvMarshaller{
+		TimeColumn:    f.FlagCsvTimeColumn,
+		TagsColumn:    f.FlagCsvTagsColumn,
+		TimeFormat:    f.FlagCsvTimeFormat,
+		RowTime:       f.FlagCsvRowTime,
+		Separator:     separator,
+		QuoteFields:   f.FlagCsvQuote,
+		IntegerValues: f.FlagCsvIntegers,
+		MaxLineLength: f.FlagMaxLineLength,
+	}
+}
+
+// ParseCsvSeparator parses the value of the -csv-separator flag. The value must be a single character, or one of
+// the strings '\t' and 'tab' for the tab character. An empty string results in 0, which selects the default CsvSeparator.
+func ParseCsvSeparator(separator string) (rune, error) {
+	switch separator {
+	case "":
+		return 0, nil
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(separator)
+	if size != len(separator) || r == utf8.RuneError {
+		return 0, fmt.Errorf("The CSV separator must be a single character, received '%v'", separator)
+	}
+	if r == CsvNewline || r == CsvQuote {
+		return 0, fmt.Errorf("Illegal CSV separator: %q", r)
+	}
+	return r, nil
 }
 
 func (f *EndpointFactory) ParseParameters(params map[string]string) (err error) {
@@ -184,6 +271,11 @@ func (f *EndpointFactory) ParseParameters(params map[string]string) (err error)
 			*target, err = strconv.Atoi(strVal)
 		}
 	}
+	int64Param := func(target *int64, name string) {
+		if strVal := get(name); strVal != "" {
+			*target, err = strconv.ParseInt(strVal, 10, 64)
+		}
+	}
 	uintParam := func(target *uint, name string) {
 		if strVal := get(name); strVal != "" {
 			val, parseErr := strconv.ParseUint(strVal, 10, 64)
@@ -191,6 +283,11 @@ func (f *EndpointFactory) ParseParameters(params map[string]string) (err error)
 			*target = uint(val)
 		}
 	}
+	floatParam := func(target *float64, name string) {
+		if strVal := get(name); strVal != "" {
+			*target, err = strconv.ParseFloat(strVal, 64)
+		}
+	}
 	durationParam := func(target *time.Duration, name string) {
 		if strVal := get(name); strVal != "" {
 			*target, err = time.ParseDuration(strVal)
@@ -202,15 +299,39 @@ func (f *EndpointFactory) ParseParameters(params map[string]string) (err error)
 	intParam(&f.FlagIoBuffer, "files-buf")
 	uintParam(&f.FlagTcpConnectionLimit, "tcp-limit")
 	boolParam(&f.FlagTcpLogReceivedData, "tcp-log-received")
+	intParam(&f.FlagTcpPoolSize, "tcp-pool-size")
+	durationParam(&f.FlagTcpHealthCheck, "tcp-health-check")
+	boolParam(&f.FlagTcpReconnect, "tcp-reconnect")
 	intParam(&f.FlagParallelHandler.ParallelParsers, "par")
 	intParam(&f.FlagParallelHandler.BufferedSamples, "buf")
 	boolParam(&f.FlagFilesKeepAlive, "files-keep-alive")
 	boolParam(&f.FlagInputFilesRobust, "files-robust")
+	strParam(&f.FlagDeadLetter, "dead-letter")
+	boolParam(&f.FlagFilesRecursive, "files-recursive")
 	uintParam(&f.FlagInputTcpAcceptLimit, "listen-limit")
 	boolParam(&f.FlagTcpSourceDropErrors, "tcp-drop-err")
+	strParam(&f.FlagTcpSourceGapTag, "tcp-gap-tag")
+	floatParam(&f.FlagTcpSourceRetryJitter, "tcp-retry-jitter")
+	intParam(&f.FlagTcpSourceRetryMax, "tcp-retry-max")
 	uintParam(&f.FlagOutputTcpListenBuffer, "listen-buffer")
 	boolParam(&f.FlagFilesAppend, "files-append")
 	durationParam(&f.FlagFileVanishedCheck, "files-check-output")
+	boolParam(&f.FlagFilesFsync, "files-fsync")
+	durationParam(&f.FlagFilesFsyncPeriod, "files-fsync-interval")
+	int64Param(&f.FlagFilesMaxSize, "files-max-size")
+	boolParam(&f.FlagOutputMetadata, "output-metadata")
+	durationParam(&f.FlagHeaderInterval, "header-interval")
+	boolParam(&f.FlagOutputsDropErrors, "outputs-drop-err")
+	strParam(&f.FlagCsvTimeColumn, "csv-time-col")
+	strParam(&f.FlagCsvTagsColumn, "csv-tags-col")
+	strParam(&f.FlagCsvTimeFormat, "csv-time-format")
+	durationParam(&f.FlagCsvRowTime, "csv-row-time")
+	strParam(&f.FlagCsvSeparator, "csv-separator")
+	boolParam(&f.FlagCsvQuote, "csv-quote")
+	boolParam(&f.FlagCsvIntegers, "csv-int")
+	strParam(&f.FlagValueCountPolicy, "value-count-policy")
+	intParam(&f.FlagMaxLineLength, "max-line-length")
+	intParam(&f.FlagBinaryTagDictionary, "binary-tag-dictionary")
 
 	if err == nil && len(params) > 0 {
 		err = fmt.Errorf("Unexpected parameters for EndpointFactory: %v", params)
@@ -240,6 +361,15 @@ func (f *EndpointFactory) RegisterGeneralFlagsTo(fs *flag.FlagSet) {
 	fs.IntVar(&f.FlagParallelHandler.ParallelParsers, "par", f.FlagParallelHandler.ParallelParsers, "Parallel goroutines used for (un)marshalling samples")
 	fs.IntVar(&f.FlagParallelHandler.BufferedSamples, "buf", f.FlagParallelHandler.BufferedSamples, "Number of samples buffered when (un)marshalling.")
 
+	// CSV
+	fs.StringVar(&f.FlagCsvSeparator, "csv-separator", f.FlagCsvSeparator, "Separator character for reading and writing CSV data, e.g. ';' or '\\t' (default ','). "+
+		"When set, input data is always read as CSV.")
+	fs.BoolVar(&f.FlagCsvQuote, "csv-quote", f.FlagCsvQuote, "Enclose CSV fields containing the separator in quotes when writing (RFC 4180), and unquote such fields when reading. "+
+		"When set, input data is always read as CSV.")
+	fs.BoolVar(&f.FlagCsvIntegers, "csv-int", f.FlagCsvIntegers, "Write integral CSV values as plain integers instead of using the exponent notation for large values, e.g. for large counters.")
+	fs.StringVar(&f.FlagCsvTimeFormat, "csv-time-format", f.FlagCsvTimeFormat, "Write and parse CSV timestamps with the given Go time layout, '"+CsvRFC3339TimeFormat+"' for RFC 3339 (ISO 8601) timestamps, "+
+		"or '"+CsvUnixTimeFormat+"' for seconds since the Unix epoch. By default, the format of parsed timestamps is detected automatically.")
+
 	// Custom
 	for _, factoryFunc := range f.CustomGeneralFlags {
 		factoryFunc(fs)
@@ -249,10 +379,23 @@ func (f *EndpointFactory) RegisterGeneralFlagsTo(fs *flag.FlagSet) {
 // RegisterInputFlagsTo registers flags that configure aspects of data input.
 func (f *EndpointFactory) RegisterInputFlagsTo(fs *flag.FlagSet) {
 	fs.StringVar(&f.FlagSourceTag, "source-tag", f.FlagSourceTag, "Add the data source (e.g. input file, TCP endpoint, ...) as the given tag to each read sample.")
-	fs.BoolVar(&f.FlagFilesKeepAlive, "files-keep-alive", f.FlagFilesKeepAlive, "Do not shut down after all files have been read. Useful in combination with -listen-buffer.")
+	fs.BoolVar(&f.FlagFilesKeepAlive, "files-keep-alive", f.FlagFilesKeepAlive, "Do not shut down after all files have been read. Useful in combination with -listen-buffer. "+
+		"For standard input, wait for more data after reaching the end of the input. In a terminal, Ctrl-D does not stop the input in that case.")
 	fs.BoolVar(&f.FlagInputFilesRobust, "files-robust", f.FlagInputFilesRobust, "When encountering errors while reading files, print warnings instead of failing.")
+	fs.StringVar(&f.FlagDeadLetter, "dead-letter", f.FlagDeadLetter, "Skip input samples that cannot be parsed and append their raw data, the error and the data source as JSON lines to the given file.")
+	fs.BoolVar(&f.FlagFilesRecursive, "files-recursive", f.FlagFilesRecursive, "When reading input directories, also read the files in all subdirectories.")
 	fs.UintVar(&f.FlagInputTcpAcceptLimit, "listen-limit", f.FlagInputTcpAcceptLimit, "Limit number of simultaneous TCP connections accepted for incoming data.")
 	fs.BoolVar(&f.FlagTcpSourceDropErrors, "tcp-drop-err", f.FlagTcpSourceDropErrors, "Don't print errors when establishing active TCP input connection fails")
+	fs.StringVar(&f.FlagCsvTimeColumn, "csv-time-col", f.FlagCsvTimeColumn, "Read CSV input with a custom column layout, taking the timestamps from the given column (name or 0-based index). All other columns except -csv-tags-col are read as metrics.")
+	fs.StringVar(&f.FlagCsvTagsColumn, "csv-tags-col", f.FlagCsvTagsColumn, "With -csv-time-col or -csv-row-time, read the tags from the given CSV column (name or 0-based index).")
+	fs.DurationVar(&f.FlagCsvRowTime, "csv-row-time", f.FlagCsvRowTime, "Read CSV input without a time column. The timestamps are synthesized from the row order, starting at the Unix epoch and increasing by the given duration.")
+	fs.StringVar(&f.FlagValueCountPolicy, "value-count-policy", f.FlagValueCountPolicy, "Handling of input samples with more or fewer values than header fields. "+
+		"'strict' (default): fail, 'pad': pad missing values with NaN, 'truncate': drop surplus values, 'adjust': pad or truncate. Adjusting samples logs a warning.")
+	fs.IntVar(&f.FlagMaxLineLength, "max-line-length", f.FlagMaxLineLength, "Maximum number of bytes of one line of CSV or JSON input, or of one header field or tag string of binary input. "+
+		"Longer lines fail the input stream, to protect against unbounded memory growth with corrupt data (default "+strconv.Itoa(DefaultMaxLineLength)+").")
+	fs.StringVar(&f.FlagTcpSourceGapTag, "tcp-gap-tag", f.FlagTcpSourceGapTag, "When an active TCP input connection is re-established, set the given tag on the first received sample. The tag value is the duration of the connection gap.")
+	fs.Float64Var(&f.FlagTcpSourceRetryJitter, "tcp-retry-jitter", f.FlagTcpSourceRetryJitter, "Randomly vary the interval between attempts to (re-)establish active TCP input connections by up to the given fraction, e.g. 0.2 for +/- 20%.")
+	fs.IntVar(&f.FlagTcpSourceRetryMax, "tcp-retry-max", f.FlagTcpSourceRetryMax, "Stop the active TCP input after the given number of consecutive failed connection retries (unlimited by default).")
 	for _, factoryFunc := range f.CustomInputFlags {
 		factoryFunc(fs)
 	}
@@ -263,7 +406,19 @@ func (f *EndpointFactory) RegisterOutputFlagsTo(fs *flag.FlagSet) {
 	fs.UintVar(&f.FlagOutputTcpListenBuffer, "listen-buffer", f.FlagOutputTcpListenBuffer, "When listening for outgoing connections, store a number of samples in a ring buffer that will be delivered first to all established connections.")
 	fs.BoolVar(&f.FlagFilesAppend, "files-append", f.FlagFilesAppend, "For file output, do no create new files by incrementing the suffix and append to existing files.")
 	fs.DurationVar(&f.FlagFileVanishedCheck, "files-check-output", f.FlagFileVanishedCheck, "For file output, check if the output file vanished or changed in regular intervals. Reopen the file in that case.")
+	fs.BoolVar(&f.FlagFilesFsync, "files-fsync", f.FlagFilesFsync, "For file output, call fsync() after writing data, so that it survives a power loss. Reduces the throughput, see -files-fsync-interval.")
+	fs.DurationVar(&f.FlagFilesFsyncPeriod, "files-fsync-interval", f.FlagFilesFsyncPeriod, "With -files-fsync, call fsync() at most once per interval instead of after every write. Files are always synced before closing.")
+	fs.Int64Var(&f.FlagFilesMaxSize, "files-max-size", f.FlagFilesMaxSize, "For file output, open the next file (with an incremented suffix) when the current file reaches the given size in bytes. Cannot be combined with -files-append.")
+	fs.BoolVar(&f.FlagOutputMetadata, "output-metadata", f.FlagOutputMetadata, "Add provenance metadata (bitflow version, pipeline, start time) as tags to the first sample of every output file or stream.")
+	fs.DurationVar(&f.FlagHeaderInterval, "header-interval", f.FlagHeaderInterval, "For CSV and binary output, repeat the header of long-lived output streams (e.g. TCP connections) in the given interval, so that receivers can recover after joining in the middle of a stream. 0 disables repeating the header.")
+	fs.BoolVar(&f.FlagOutputsDropErrors, "outputs-drop-err", f.FlagOutputsDropErrors, "When writing to multiple outputs, log the errors of a failed output and continue with the remaining outputs, instead of stopping.")
 	fs.BoolVar(&f.FlagTcpLogReceivedData, "tcp-log-received", f.FlagTcpLogReceivedData, "For all TCP output connections, log received data, which is usually not expected.")
+	fs.IntVar(&f.FlagBinaryTagDictionary, "binary-tag-dictionary", f.FlagBinaryTagDictionary, "For binary output, send every distinct tag string only once and reference it by an id in the following samples. "+
+		"The value limits the number of distinct tag strings per header, further tag strings are sent inline. 0 disables the tag dictionary.")
+	fs.IntVar(&f.FlagTcpPoolSize, "tcp-pool-size", f.FlagTcpPoolSize, "For TCP output to multiple comma-separated endpoints, limit the number of simultaneously open connections. Samples are distributed among the open connections. <= 0 means connecting to all endpoints.")
+	fs.DurationVar(&f.FlagTcpHealthCheck, "tcp-health-check", f.FlagTcpHealthCheck, "For TCP output to multiple comma-separated endpoints, interval for evicting failed connections and reconnecting to missing endpoints (default "+DefaultTcpHealthCheckInterval.String()+")")
+	fs.BoolVar(&f.FlagTcpReconnect, "tcp-reconnect", f.FlagTcpReconnect, "For TCP output to a single endpoint, retry failed connections with an exponential backoff instead of failing. "+
+		"Samples are buffered while disconnected and sent after reconnecting.")
 	for _, factoryFunc := range f.CustomOutputFlags {
 		factoryFunc(fs)
 	}
@@ -271,10 +426,16 @@ func (f *EndpointFactory) RegisterOutputFlagsTo(fs *flag.FlagSet) {
 
 // Writer returns an instance of SampleReader, configured by the values stored in the EndpointFactory.
 func (f *EndpointFactory) Reader(um Unmarshaller) SampleReader {
-	return SampleReader{
+	reader := SampleReader{
 		ParallelSampleHandler: f.FlagParallelHandler,
 		Unmarshaller:          um,
+		ValueCountPolicy:      ValueCountPolicy(f.FlagValueCountPolicy),
+		MaxLineLength:         f.FlagMaxLineLength,
 	}
+	if f.FlagDeadLetter != "" {
+		reader.DeadLetters = &DeadLetterFile{Path: f.FlagDeadLetter}
+	}
+	return reader
 }
 
 // CreateInput creates a SampleSource object based on the given input endpoint descriptions
@@ -282,6 +443,12 @@ func (f *EndpointFactory) Reader(um Unmarshaller) SampleReader {
 func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 	var result SampleSource
 	inputType := UndefinedEndpoint
+	if _, err := ParseValueCountPolicy(f.FlagValueCountPolicy); err != nil {
+		return nil, err
+	}
+	if _, err := ParseCsvSeparator(f.FlagCsvSeparator); err != nil {
+		return nil, err
+	}
 	for _, input := range inputs {
 		endpoint, err := f.ParseEndpointDescription(input, false)
 		if err != nil {
@@ -291,7 +458,12 @@ func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 			return nil, fmt.Errorf("Format cannot be specified for data input: %v", input)
 		}
 		if result == nil {
-			reader := f.Reader(nil) // nil as Unmarshaller makes the SampleSource auto-detect the format
+			var um Unmarshaller // nil as Unmarshaller makes the SampleSource auto-detect the format
+			if csv := f.csvMarshaller(); csv.customLayout() || csv.customSyntax() {
+				// Foreign CSV data cannot be auto-detected
+				um = csv
+			}
+			reader := f.Reader(um)
 			if f.FlagSourceTag != "" {
 				reader.Handler = sourceTagger(f.FlagSourceTag)
 			}
@@ -300,6 +472,7 @@ func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 			case StdEndpoint:
 				source := NewConsoleSource()
 				source.Reader = reader
+				source.KeepAlive = f.FlagFilesKeepAlive
 				result = source
 			case TcpEndpoint, HttpEndpoint:
 				source := &TCPSource{
@@ -308,6 +481,9 @@ func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 					RetryInterval: tcp_download_retry_interval,
 					DialTimeout:   tcp_dial_timeout,
 					UseHTTP:       endpoint.Type == HttpEndpoint,
+					GapTag:        f.FlagTcpSourceGapTag,
+					RetryJitter:   f.FlagTcpSourceRetryJitter,
+					RetryMax:      f.FlagTcpSourceRetryMax,
 				}
 				source.TcpConnLimit = f.FlagTcpConnectionLimit
 				source.Reader = reader
@@ -324,6 +500,7 @@ func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 					IoBuffer:  f.FlagIoBuffer,
 					Robust:    f.FlagInputFilesRobust,
 					KeepAlive: f.FlagFilesKeepAlive,
+					Recursive: f.FlagFilesRecursive,
 				}
 				source.Reader = reader
 				result = source
@@ -366,7 +543,10 @@ func (f *EndpointFactory) CreateInput(inputs ...string) (SampleSource, error) {
 
 // Writer returns an instance of SampleWriter, configured by the values stored in the EndpointFactory.
 func (f *EndpointFactory) Writer() SampleWriter {
-	return SampleWriter{f.FlagParallelHandler}
+	return SampleWriter{
+		ParallelSampleHandler: f.FlagParallelHandler,
+		HeaderInterval:        f.FlagHeaderInterval,
+	}
 }
 
 // CreateInput creates a SampleSink object based on the given output endpoint description
@@ -377,8 +557,11 @@ func (f *EndpointFactory) CreateOutput(output string) (SampleProcessor, error) {
 	if err != nil {
 		return nil, err
 	}
+	if _, err := ParseCsvSeparator(f.FlagCsvSeparator); err != nil {
+		return nil, err
+	}
 	var marshaller Marshaller
-	if format := endpoint.OutputFormat(); format != UndefinedFormat {
+	if format := f.outputFormat(endpoint); format != UndefinedFormat {
 		marshaller, err = f.CreateMarshaller(format)
 		if err != nil {
 			return nil, err
@@ -403,20 +586,38 @@ func (f *EndpointFactory) CreateOutput(output string) (SampleProcessor, error) {
 			CleanFiles:        f.FlagOutputFilesClean,
 			Append:            f.FlagFilesAppend,
 			VanishedFileCheck: f.FlagFileVanishedCheck,
+			Fsync:             f.FlagFilesFsync,
+			FsyncInterval:     f.FlagFilesFsyncPeriod,
+			MaxFileSize:       f.FlagFilesMaxSize,
 		}
 		marshallingSink = &sink.AbstractMarshallingSampleOutput
 		resultSink = sink
 	case TcpEndpoint:
-		sink := &TCPSink{
-			Endpoint:    endpoint.Target,
-			DialTimeout: tcp_dial_timeout,
-		}
-		sink.TcpConnLimit = f.FlagTcpConnectionLimit
-		if f.FlagTcpLogReceivedData {
-			sink.LogReceivedTraffic = log.ErrorLevel
+		if strings.Contains(endpoint.Target, ",") {
+			sink := &TCPPoolSink{
+				Endpoints:           strings.Split(endpoint.Target, ","),
+				PoolSize:            f.FlagTcpPoolSize,
+				HealthCheckInterval: f.FlagTcpHealthCheck,
+				DialTimeout:         tcp_dial_timeout,
+			}
+			if f.FlagTcpLogReceivedData {
+				sink.LogReceivedTraffic = log.ErrorLevel
+			}
+			marshallingSink = &sink.AbstractMarshallingSampleOutput
+			resultSink = sink
+		} else {
+			sink := &TCPSink{
+				Endpoint:    endpoint.Target,
+				DialTimeout: tcp_dial_timeout,
+				Reconnect:   f.FlagTcpReconnect,
+			}
+			sink.TcpConnLimit = f.FlagTcpConnectionLimit
+			if f.FlagTcpLogReceivedData {
+				sink.LogReceivedTraffic = log.ErrorLevel
+			}
+			marshallingSink = &sink.AbstractMarshallingSampleOutput
+			resultSink = sink
 		}
-		marshallingSink = &sink.AbstractMarshallingSampleOutput
-		resultSink = sink
 	case TcpListenEndpoint:
 		sink := &TCPListenerSink{
 			Endpoint:        endpoint.Target,
@@ -452,6 +653,9 @@ func (f *EndpointFactory) CreateOutput(output string) (SampleProcessor, error) {
 			if factoryErr != nil {
 				return nil, fmt.Errorf("Error creating '%v' output: %v", endpoint.Type, factoryErr)
 			}
+			if output, ok := resultSink.(MarshallingSampleOutput); ok && marshaller != nil {
+				output.SetMarshaller(marshaller)
+			}
 		} else {
 			return nil, errors.New("Unknown output endpoint type: " + string(endpoint.Type))
 		}
@@ -459,16 +663,66 @@ func (f *EndpointFactory) CreateOutput(output string) (SampleProcessor, error) {
 	if marshallingSink != nil {
 		marshallingSink.SetMarshaller(marshaller)
 		marshallingSink.Writer = f.Writer()
+		if f.FlagOutputMetadata {
+			marshallingSink.Writer.Metadata = NewOutputMetadata(f.PipelineDescription)
+		}
 	}
 	return resultSink, nil
 }
 
+// CreateOutputs creates a SampleProcessor that forwards all samples to all given output endpoints, see CreateOutput.
+// Every output is configured individually, so the outputs can use different marshalling formats.
+// A single output endpoint is returned directly. With multiple outputs, a MultiSampleOutput is returned,
+// and FlagOutputsDropErrors configures whether a failed output stops the MultiSampleOutput.
+func (f *EndpointFactory) CreateOutputs(outputs ...string) (SampleProcessor, error) {
+	if len(outputs) == 0 {
+		return nil, errors.New("No outputs specified")
+	} else if len(outputs) == 1 {
+		return f.CreateOutput(outputs[0])
+	}
+	result := &MultiSampleOutput{
+		DropFailedOutputs: f.FlagOutputsDropErrors,
+	}
+	for _, output := range outputs {
+		sink, err := f.CreateOutput(output)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create output %v: %v", output, err)
+		}
+		result.Outputs = append(result.Outputs, sink)
+	}
+	return result, nil
+}
+
+// outputFormat returns the output format of the endpoint, including the default formats of custom endpoint types.
+func (f *EndpointFactory) outputFormat(endpoint EndpointDescription) MarshallingFormat {
+	format := endpoint.OutputFormat()
+	if format == UndefinedFormat && endpoint.IsCustomType {
+		format = f.CustomDefaultFormats[endpoint.Type]
+	}
+	return format
+}
+
 func (f *EndpointFactory) CreateMarshaller(format MarshallingFormat) (Marshaller, error) {
 	factory, ok := f.Marshallers[format]
 	if !ok {
 		return nil, fmt.Errorf("Unknown marshaller format: %v", format)
 	}
-	return factory(), nil
+	return f.configureMarshaller(factory()), nil
+}
+
+// configureMarshaller applies the flags of the receiving factory to the builtin marshallers. The marshaller
+// factories must not read the flags themselves, because they are shared between copies of an EndpointFactory,
+// and the flags are usually parsed into one of the copies.
+func (f *EndpointFactory) configureMarshaller(marshaller Marshaller) Marshaller {
+	switch m := marshaller.(type) {
+	case CsvMarshaller:
+		return f.csvMarshaller()
+	case BinaryMarshaller:
+		m.TagDictionary = f.FlagBinaryTagDictionary
+		m.MaxLineLength = f.FlagMaxLineLength
+		return m
+	}
+	return marshaller
 }
 
 // IsConsoleOutput returns true if the given processor will output to the standard output when started.
@@ -499,7 +753,8 @@ func (e EndpointDescription) OutputFormat() MarshallingFormat {
 }
 
 // DefaultOutputFormat returns the default MarshallingFormat that should be used when sending
-// data to the described endpoint, if no format is specified by the user.
+// data to the described endpoint, if no format is specified by the user. For custom endpoint types,
+// the result is UndefinedFormat, see EndpointFactory.CustomDefaultFormats.
 func (e EndpointDescription) DefaultOutputFormat() MarshallingFormat {
 	switch e.Type {
 	case TcpEndpoint, TcpListenEndpoint:
@@ -508,6 +763,9 @@ func (e EndpointDescription) DefaultOutputFormat() MarshallingFormat {
 		if strings.HasSuffix(e.Target, binaryFileSuffix) {
 			return BinaryFormat
 		}
+		if strings.HasSuffix(e.Target, jsonFileSuffix) {
+			return JsonFormat
+		}
 		return CsvFormat
 	case HttpEndpoint:
 		return CsvFormat
@@ -592,7 +850,9 @@ func (f *EndpointFactory) ParseUrlEndpointDescription(endpoint string) (res Endp
 		}
 	}
 	if res.IsCustomType && res.Format != UndefinedFormat {
-		err = fmt.Errorf("Cannot define the data format for transport '%v'", res.Type)
+		if _, hasFormat := f.CustomDefaultFormats[res.Type]; !hasFormat {
+			err = fmt.Errorf("Cannot define the data format for transport '%v'", res.Type)
+		}
 	}
 	return
 }
@@ -2,6 +2,7 @@ package bitflow
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"testing"
 	"time"
@@ -60,6 +61,7 @@ func (suite *PipelineTestSuite) TestGuessEndpoint() {
 	compare("xxx.csv", CsvFormat, FileEndpoint)
 	compare("xxx.xxx.xxx", CsvFormat, FileEndpoint)
 	compare("xxx.bin", BinaryFormat, FileEndpoint)
+	compare("xxx.json", JsonFormat, FileEndpoint)
 
 	// TCP endpoints
 	compare(":8888", BinaryFormat, TcpListenEndpoint)
@@ -115,6 +117,8 @@ func (suite *PipelineTestSuite) TestUrlEndpoint() {
 		compare("bin+"+s+"://xxx.bin", BinaryFormat, BinaryFormat, typ, "xxx.bin")
 		compare(s+"+text://xxx.csv", TextFormat, TextFormat, typ, "xxx.csv")
 		compare("text+"+s+"://xxx.bin", TextFormat, TextFormat, typ, "xxx.bin")
+		compare(s+"+json://xxx.csv", JsonFormat, JsonFormat, typ, "xxx.csv")
+		compare("json+"+s+"://xxx.bin", JsonFormat, JsonFormat, typ, "xxx.bin")
 	}
 	checkFormat := func(format MarshallingFormat) {
 		s := string(format) + "://"
@@ -136,6 +140,7 @@ func (suite *PipelineTestSuite) TestUrlEndpoint() {
 	checkFormat(BinaryFormat)
 	checkFormat(CsvFormat)
 	checkFormat(TextFormat)
+	checkFormat(JsonFormat)
 
 	// Test StdEndpoint
 	compare("std://-", UndefinedFormat, TextFormat, StdEndpoint, "-")
@@ -271,6 +276,24 @@ func (suite *PipelineTestSuite) Test_input_std() {
 	suite.Equal(expected, source)
 }
 
+func (suite *PipelineTestSuite) Test_input_max_line_length() {
+	factory := suite.make_factory()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	factory.RegisterInputFlagsTo(fs)
+	suite.NoError(fs.Parse([]string{"-max-line-length", "1024", "-csv-time-col", "t"}))
+	source, err := factory.CreateInput("-")
+	suite.NoError(err)
+	reader := source.(*ReaderSource).Reader
+	suite.Equal(1024, reader.MaxLineLength)
+	suite.Equal(1024, reader.Unmarshaller.(CsvMarshaller).MaxLineLength)
+
+	factory = suite.make_factory()
+	suite.NoError(factory.ParseParameters(map[string]string{"max-line-length": "2048"}))
+	source, err = factory.CreateInput("-")
+	suite.NoError(err)
+	suite.Equal(2048, source.(*ReaderSource).Reader.MaxLineLength)
+}
+
 func (suite *PipelineTestSuite) Test_input_multiple() {
 	test := func(input1, input2 string, inputs ...string) {
 		factory := suite.make_factory()
@@ -315,6 +338,34 @@ func (suite *PipelineTestSuite) Test_custom_endpoint_errors() {
 	suite.Nil(sink)
 }
 
+func (suite *PipelineTestSuite) Test_custom_endpoint_formats() {
+	factory := suite.make_factory()
+	testEndpointType := EndpointType("testendpoint")
+	factory.CustomDataSinks[testEndpointType] = func(target string) (SampleProcessor, error) {
+		return NewConsoleSink(), nil
+	}
+
+	_, err := factory.CreateOutput("csv+testendpoint://x")
+	suite.EqualError(err, "Cannot define the data format for transport 'testendpoint'")
+
+	// Without a default format, the sink only receives a Marshaller, if a format is specified
+	factory.CustomDefaultFormats[testEndpointType] = UndefinedFormat
+	sink, err := factory.CreateOutput("testendpoint://x")
+	suite.NoError(err)
+	suite.Nil(sink.(*WriterSink).Marshaller)
+	sink, err = factory.CreateOutput("csv+testendpoint://x")
+	suite.NoError(err)
+	suite.IsType(CsvMarshaller{}, sink.(*WriterSink).Marshaller)
+
+	factory.CustomDefaultFormats[testEndpointType] = BinaryFormat
+	sink, err = factory.CreateOutput("testendpoint://x")
+	suite.NoError(err)
+	suite.IsType(BinaryMarshaller{}, sink.(*WriterSink).Marshaller)
+	sink, err = factory.CreateOutput("testendpoint+text://x")
+	suite.NoError(err)
+	suite.IsType(TextMarshaller{}, sink.(*WriterSink).Marshaller)
+}
+
 func (suite *PipelineTestSuite) Test_input_multiple_listener() {
 	factory := suite.make_factory()
 	source, err := factory.CreateInput(":123", ":456")
@@ -0,0 +1,98 @@
+package fork
+
+import (
+	"fmt"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// CardinalityPolicySkip makes the CardinalityGuard skip keys that exceed the limit. Such samples are treated
+	// like samples without matching subpipelines: they bypass the subpipelines of the fork.
+	CardinalityPolicySkip = "skip"
+
+	// CardinalityPolicyOverflow makes the CardinalityGuard route all keys that exceed the limit to one shared overflow key.
+	// For tag template distributors, the overflow key is built by replacing all tags in the template with CardinalityOverflowValue.
+	CardinalityPolicyOverflow = "overflow"
+
+	CardinalityOverflowValue = "overflow"
+)
+
+// CardinalityGuard limits the number of distinct keys that a distributor creates subpipelines for.
+// This prevents an explosion of subpipelines or output files, for example when a tag template accidentally
+// contains a tag with a unique value for every sample. When more than Limit distinct keys occur, a warning is logged and
+// all further new keys are handled according to Policy (CardinalityPolicySkip by default). Keys that were seen
+// before the limit was reached continue to be routed normally. A Limit <= 0 disables the guard.
+type CardinalityGuard struct {
+	Limit  int
+	Policy string
+
+	keys     map[string]bool
+	rejected int
+}
+
+// CheckCardinalityPolicy returns an error, if the given policy is not valid.
+func CheckCardinalityPolicy(policy string) error {
+	switch policy {
+	case "", CardinalityPolicySkip, CardinalityPolicyOverflow:
+		return nil
+	}
+	return fmt.Errorf("Unknown cardinality policy '%v', must be %v or %v", policy, CardinalityPolicySkip, CardinalityPolicyOverflow)
+}
+
+// Check returns the key that should be used for routing instead of the given key. The overflowKey function is only invoked
+// with CardinalityPolicyOverflow. If the result is false, the key should not be routed to any subpipeline.
+func (g *CardinalityGuard) Check(key string, overflowKey func() string) (string, bool) {
+	if g.Limit <= 0 || g.keys[key] {
+		return key, true
+	}
+	if len(g.keys) < g.Limit {
+		if g.keys == nil {
+			g.keys = make(map[string]bool)
+		}
+		g.keys[key] = true
+		return key, true
+	}
+	g.rejected++
+	if g.rejected == 1 {
+		log.Warnf("Number of distinct fork keys exceeds the limit of %v (new key: '%v'), further new keys are handled with policy '%v'",
+			g.Limit, key, g.policy())
+	}
+	if g.policy() == CardinalityPolicyOverflow {
+		return overflowKey(), true
+	}
+	return "", false
+}
+
+// Active returns true, if the number of distinct keys exceeded the limit.
+func (g *CardinalityGuard) Active() bool {
+	return g.rejected > 0
+}
+
+// NumKeys returns the number of distinct keys that were accepted so far.
+func (g *CardinalityGuard) NumKeys() int {
+	return len(g.keys)
+}
+
+func (g *CardinalityGuard) policy() string {
+	if g.Policy == "" {
+		return CardinalityPolicySkip
+	}
+	return g.Policy
+}
+
+func (g *CardinalityGuard) String() string {
+	if g.Limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(", max %v keys (%v)", g.Limit, g.policy())
+}
+
+// overflowTemplateKey resolves the template with all tags replaced by CardinalityOverflowValue
+func overflowTemplateKey(template bitflow.TagTemplate) func() string {
+	return func() string {
+		template.MissingValue = CardinalityOverflowValue
+		return template.Resolve(new(bitflow.Sample))
+	}
+}
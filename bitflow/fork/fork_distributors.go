@@ -1,6 +1,7 @@
 package fork
 
 import (
+	"container/list"
 	"fmt"
 	"regexp"
 	"sort"
@@ -177,17 +178,25 @@ type RegexDistributor struct {
 	ExactMatch bool // Key patterns must match exactly, no glob (*) processing
 	RegexMatch bool // Overrides ExactMatch -> treat key patterns as regexes
 
+	// DefaultKey optionally selects an entry of Pipelines that receives all samples with keys that do not match
+	// any other pattern. The default pipeline itself is not selected through pattern matching. If DefaultKey is empty,
+	// samples without matching pipelines bypass the fork.
+	DefaultKey string
+
 	regexCache        map[string]*regexp.Regexp
 	cache             PipelineCache
 	wildcardPipelines PipelineCache // This extra cache is only for implementing ContainedStringers()
 }
 
 func (d *RegexDistributor) Init() error {
+	if _, ok := d.Pipelines[d.DefaultKey]; d.DefaultKey != "" && !ok {
+		return fmt.Errorf("The default subpipeline key '%v' is not defined", d.DefaultKey)
+	}
 	// Initialize the pipeline cache used for ContainedStringers(). Also report early errors.
 	for key := range d.Pipelines {
 		_, err := d.wildcardPipelines.getPipelines(key, func(key string) ([]*bitflow.SamplePipeline, error) {
 			// Strictly build the pipelines for the available keys
-			return d.doBuild(key, false, false)
+			return d.doBuild(key, false, false, "")
 		})
 		if err != nil {
 			return err
@@ -211,13 +220,17 @@ func (d *RegexDistributor) getPipelines(key string) ([]Subpipeline, error) {
 }
 
 func (d *RegexDistributor) build(key string) ([]*bitflow.SamplePipeline, error) {
-	return d.doBuild(key, d.RegexMatch, !d.ExactMatch)
+	res, err := d.doBuild(key, d.RegexMatch, !d.ExactMatch, d.DefaultKey)
+	if err == nil && len(res) == 0 && d.DefaultKey != "" {
+		res, err = d.Pipelines[d.DefaultKey]()
+	}
+	return res, err
 }
 
-func (d *RegexDistributor) doBuild(key string, allowRegex bool, allowGlob bool) ([]*bitflow.SamplePipeline, error) {
+func (d *RegexDistributor) doBuild(key string, allowRegex bool, allowGlob bool, skipPattern string) ([]*bitflow.SamplePipeline, error) {
 	var res []*bitflow.SamplePipeline
 	for wildcardKey, builderFunc := range d.Pipelines {
-		if d.matches(key, wildcardKey, allowRegex, allowGlob) {
+		if wildcardKey != skipPattern && d.matches(key, wildcardKey, allowRegex, allowGlob) {
 			newPipelines, err := builderFunc()
 			if err != nil {
 				return res, err
@@ -271,10 +284,15 @@ func (d *GenericDistributor) String() string {
 type TagDistributor struct {
 	RegexDistributor
 	bitflow.TagTemplate
+	Guard CardinalityGuard // Optionally limits the number of distinct resolved keys
 }
 
 func (d *TagDistributor) Distribute(sample *bitflow.Sample, _ *bitflow.Header) ([]Subpipeline, error) {
-	return d.getPipelines(d.Resolve(sample))
+	key, ok := d.Guard.Check(d.Resolve(sample), overflowTemplateKey(d.TagTemplate))
+	if !ok {
+		return nil, nil
+	}
+	return d.getPipelines(key)
 }
 
 func (d *TagDistributor) String() string {
@@ -284,35 +302,123 @@ func (d *TagDistributor) String() string {
 	} else if d.ExactMatch {
 		matchMode = "exact"
 	}
-	return fmt.Sprintf("tag template (%v matching): %v", matchMode, d.Template)
+	if d.DefaultKey != "" {
+		matchMode += ", default " + d.DefaultKey
+	}
+	return fmt.Sprintf("tag template (%v matching%v): %v", matchMode, d.Guard.String(), d.Template)
 }
 
 var _ Distributor = new(MultiFileDistributor)
 
+// FileOutputRule overrides the configuration of the files created by a MultiFileDistributor,
+// if the file name matches the glob Pattern.
+type FileOutputRule struct {
+	Pattern   string
+	Format    bitflow.MarshallingFormat    // Optional, overrides the format derived from the file name
+	Configure func(sink *bitflow.FileSink) // Optional, modifies further parameters of the file output
+}
+
 type MultiFileDistributor struct {
 	bitflow.TagTemplate
 	PipelineCache
 	Config             bitflow.FileSink // Configuration parameters in this field will be used for file outputs
 	ExtendSubpipelines func(fileName string, pipe *bitflow.SamplePipeline)
+
+	// Rules optionally override the configuration of individual files. The first rule matching the file name is applied.
+	Rules []FileOutputRule
+
+	// FormatTag optionally defines a tag that contains the output format for every file. The tag value is evaluated
+	// for the first sample of every file, and overrides the format defined in Rules.
+	FormatTag string
+
+	// Guard optionally limits the number of distinct output files
+	Guard CardinalityGuard
+
+	// MaxOpenFiles can be set to > 0 to limit the number of files that are open at the same time. When a sample for
+	// another file arrives, the least recently used file is flushed and closed (see bitflow.FileSink.CloseFile), and reopened
+	// in append mode when it receives the next sample. This allows writing more files than the limit of open file descriptors.
+	MaxOpenFiles int
+
+	sinks     map[string]*bitflow.FileSink
+	openFiles *list.List // File names, the most recently used first
+	openIndex map[string]*list.Element
 }
 
 func (b *MultiFileDistributor) Distribute(sample *bitflow.Sample, _ *bitflow.Header) ([]Subpipeline, error) {
-	return b.getPipelines(b.Resolve(sample), b.build)
+	key, ok := b.Guard.Check(b.Resolve(sample), overflowTemplateKey(b.TagTemplate))
+	if !ok {
+		return nil, nil
+	}
+	res, err := b.getPipelines(key, func(fileName string) ([]*bitflow.SamplePipeline, error) {
+		return b.build(fileName, sample)
+	})
+	if err == nil && b.MaxOpenFiles > 0 {
+		err = b.useFile(key)
+	}
+	return res, err
+}
+
+// useFile marks the file as most recently used, and closes the least recently used files exceeding MaxOpenFiles.
+// The files are closed synchronously, before the sample is forwarded to the file of the given key.
+func (b *MultiFileDistributor) useFile(fileName string) error {
+	if b.openFiles == nil {
+		b.openFiles = list.New()
+		b.openIndex = make(map[string]*list.Element)
+	}
+	if elem, ok := b.openIndex[fileName]; ok {
+		b.openFiles.MoveToFront(elem)
+		return nil
+	}
+	b.openIndex[fileName] = b.openFiles.PushFront(fileName)
+	for b.openFiles.Len() > b.MaxOpenFiles {
+		closed := b.openFiles.Remove(b.openFiles.Back()).(string)
+		delete(b.openIndex, closed)
+		if sink, ok := b.sinks[closed]; ok {
+			if err := sink.CloseFile(); err != nil {
+				return fmt.Errorf("Failed to close output file %v: %v", closed, err)
+			}
+		}
+	}
+	return nil
 }
 
 func (b *MultiFileDistributor) String() string {
-	return "Output to files: " + b.Template
+	res := "Output to files" + b.Guard.String()
+	if b.MaxOpenFiles > 0 {
+		res += fmt.Sprintf(" (max %v open)", b.MaxOpenFiles)
+	}
+	return res + ": " + b.Template
 }
 
-func (b *MultiFileDistributor) build(fileName string) ([]*bitflow.SamplePipeline, error) {
+func (b *MultiFileDistributor) build(fileName string, sample *bitflow.Sample) ([]*bitflow.SamplePipeline, error) {
 	fileOut := b.Config
 	fileOut.Filename = fileName
 	format := bitflow.EndpointDescription{Target: fileName, Type: bitflow.FileEndpoint}.DefaultOutputFormat()
+	for _, rule := range b.Rules {
+		if glob.Glob(rule.Pattern, fileName) {
+			if rule.Format != bitflow.UndefinedFormat {
+				format = rule.Format
+			}
+			if rule.Configure != nil {
+				rule.Configure(&fileOut)
+			}
+			break
+		}
+	}
+	if b.FormatTag != "" {
+		if tagFormat := sample.Tag(b.FormatTag); tagFormat != "" {
+			format = bitflow.MarshallingFormat(tagFormat)
+		}
+	}
 	var err error
 	fileOut.Marshaller, err = bitflow.DefaultEndpointFactory.CreateMarshaller(format)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Failed to create marshaller for output file %v: %v", fileName, err)
+	}
+	if b.sinks == nil {
+		b.sinks = make(map[string]*bitflow.FileSink)
 	}
+	b.sinks[fileName] = &fileOut
 	pipe := (new(bitflow.SamplePipeline)).Add(&fileOut)
 	if extend := b.ExtendSubpipelines; extend != nil {
 		extend(fileName, pipe)
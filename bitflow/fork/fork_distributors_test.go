@@ -1,7 +1,14 @@
 package fork
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/stretchr/testify/require"
@@ -70,3 +77,188 @@ func (suite *distributorsTestSuite) TestTagTemplateDistributor() {
 	test("c", "c", pipeA, pipeB)
 	test("cxx", "")
 }
+
+func (suite *distributorsTestSuite) TestTagDistributorDefaultPipeline() {
+	h := &bitflow.Header{Fields: []string{"a"}}
+	pipeA := new(bitflow.SamplePipeline)
+	pipeOther := new(bitflow.SamplePipeline)
+	dist := &TagDistributor{RegexDistributor: RegexDistributor{ExactMatch: true, DefaultKey: "other"}}
+	dist.Template = "${host}"
+	dist.Pipelines = map[string]func() ([]*bitflow.SamplePipeline, error){
+		"a": func() ([]*bitflow.SamplePipeline, error) {
+			return []*bitflow.SamplePipeline{pipeA}, nil
+		},
+		"other": func() ([]*bitflow.SamplePipeline, error) {
+			return []*bitflow.SamplePipeline{pipeOther}, nil
+		},
+	}
+	suite.NoError(dist.Init())
+
+	test := func(host string, expectedPipe *bitflow.SamplePipeline) {
+		s := &bitflow.Sample{Values: []bitflow.Value{1}}
+		s.SetTag("host", host)
+		res, err := dist.Distribute(s, h)
+		suite.NoError(err)
+		suite.Len(res, 1)
+		suite.Equal(host, res[0].Key)
+		suite.Equal(expectedPipe, res[0].Pipe)
+	}
+	test("a", pipeA)
+	test("b", pipeOther)
+	test("", pipeOther)
+	test("ab", pipeOther)
+
+	dist.DefaultKey = "missing"
+	suite.Error(dist.Init())
+}
+func (suite *distributorsTestSuite) TestMultiFileDistributorFormats() {
+	dir, err := ioutil.TempDir("", "bitflow-multi-file-")
+	suite.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+
+	dist := &MultiFileDistributor{
+		Rules: []FileOutputRule{
+			{Pattern: "*bulk*", Format: bitflow.BinaryFormat},
+			{Pattern: "*", Format: bitflow.CsvFormat},
+		},
+		FormatTag: "format",
+	}
+	dist.Template = filepath.Join(dir, "${branch}.out")
+	dist.Config.Writer.ParallelSampleHandler = bitflow.ParallelSampleHandler{BufferedSamples: 5, ParallelParsers: 1}
+
+	h := &bitflow.Header{Fields: []string{"a", "b"}}
+	expectedFormats := map[string]string{
+		"bulk":        "timB",  // Binary by rule
+		"sample":      "time,", // CSV by rule
+		"tagged_bulk": "time,", // CSV by tag, overriding the rules
+	}
+	var wg sync.WaitGroup
+	for _, branch := range []string{"bulk", "sample", "tagged_bulk"} {
+		s := &bitflow.Sample{Values: []bitflow.Value{1, 2}, Time: time.Now()}
+		s.SetTag("branch", branch)
+		if branch == "tagged_bulk" {
+			s.SetTag("format", string(bitflow.CsvFormat))
+		}
+		res, err := dist.Distribute(s, h)
+		suite.NoError(err)
+		suite.Len(res, 1)
+		suite.Len(res[0].Pipe.Processors, 1)
+		sink := res[0].Pipe.Processors[0]
+		sink.SetSink(new(bitflow.DroppingSampleProcessor))
+		sink.Start(&wg)
+		suite.NoError(sink.Sample(s, h))
+		sink.Close()
+	}
+	wg.Wait()
+
+	for branch, expectedStart := range expectedFormats {
+		data, err := ioutil.ReadFile(filepath.Join(dir, branch+".out"))
+		suite.NoError(err)
+		suite.True(strings.HasPrefix(string(data), expectedStart), "File of branch %v should start with %q, but is: %q", branch, expectedStart, string(data))
+	}
+}
+
+func (suite *distributorsTestSuite) TestMultiFileDistributorMaxOpenFiles() {
+	dir, err := ioutil.TempDir("", "bitflow-multi-file-")
+	suite.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+
+	dist := &MultiFileDistributor{MaxOpenFiles: 2}
+	dist.Template = filepath.Join(dir, "${branch}.csv")
+	dist.Config.Writer.ParallelSampleHandler = bitflow.ParallelSampleHandler{BufferedSamples: 5, ParallelParsers: 1}
+
+	h := &bitflow.Header{Fields: []string{"a"}}
+	branches := []string{"a", "b", "c", "d", "e"}
+	started := make(map[bitflow.SampleProcessor]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		s := &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i)}, Time: time.Now()}
+		s.SetTag("branch", branches[i%len(branches)])
+		res, err := dist.Distribute(s, h)
+		suite.NoError(err)
+		suite.Len(res, 1)
+		sink := res[0].Pipe.Processors[0]
+		if !started[sink] {
+			started[sink] = true
+			sink.SetSink(new(bitflow.DroppingSampleProcessor))
+			sink.Start(&wg)
+		}
+		suite.NoError(sink.Sample(s, h))
+	}
+	suite.Equal(2, dist.openFiles.Len())
+	for sink := range started {
+		sink.Close()
+	}
+	wg.Wait()
+
+	for i, branch := range branches {
+		data, err := ioutil.ReadFile(filepath.Join(dir, branch+".csv"))
+		suite.NoError(err)
+		var values []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if !strings.HasPrefix(line, "time") {
+				values = append(values, line[strings.LastIndexByte(line, ',')+1:])
+			}
+		}
+		expected := []string{strconv.Itoa(i), strconv.Itoa(i + 5), strconv.Itoa(i + 10), strconv.Itoa(i + 15)}
+		suite.Equal(expected, values, "branch %v", branch)
+	}
+	suite.Len(started, len(branches))
+}
+
+func (suite *distributorsTestSuite) TestCardinalityGuard() {
+	h := &bitflow.Header{Fields: []string{"a"}}
+	sample := func(id int) *bitflow.Sample {
+		s := &bitflow.Sample{Values: []bitflow.Value{1}}
+		s.SetTag("id", strconv.Itoa(id))
+		return s
+	}
+	newDistributor := func(policy string) *TagDistributor {
+		dist := &TagDistributor{Guard: CardinalityGuard{Limit: 3, Policy: policy}}
+		dist.Template = "key-${id}"
+		dist.Pipelines = map[string]func() ([]*bitflow.SamplePipeline, error){
+			"*": func() ([]*bitflow.SamplePipeline, error) {
+				return []*bitflow.SamplePipeline{new(bitflow.SamplePipeline)}, nil
+			},
+		}
+		suite.NoError(dist.Init())
+		return dist
+	}
+
+	dist := newDistributor(CardinalityPolicySkip)
+	for i := 0; i < 10; i++ {
+		res, err := dist.Distribute(sample(i), h)
+		suite.NoError(err)
+		if i < 3 {
+			suite.Len(res, 1)
+			suite.Equal("key-"+strconv.Itoa(i), res[0].Key)
+			suite.False(dist.Guard.Active())
+		} else {
+			suite.Empty(res, "key %v exceeds the limit", i)
+			suite.True(dist.Guard.Active())
+		}
+	}
+	suite.Equal(3, dist.Guard.NumKeys())
+	res, err := dist.Distribute(sample(1), h)
+	suite.NoError(err)
+	suite.Len(res, 1, "known keys must still be routed")
+
+	dist = newDistributor(CardinalityPolicyOverflow)
+	var overflowPipe *bitflow.SamplePipeline
+	for i := 0; i < 10; i++ {
+		res, err := dist.Distribute(sample(i), h)
+		suite.NoError(err)
+		suite.Len(res, 1)
+		if i >= 3 {
+			suite.Equal("key-"+CardinalityOverflowValue, res[0].Key)
+			if overflowPipe == nil {
+				overflowPipe = res[0].Pipe
+			}
+			suite.True(overflowPipe == res[0].Pipe, "all overflowing keys must share one subpipeline")
+		}
+	}
+	suite.Equal(3, dist.Guard.NumKeys())
+
+	suite.NoError(CheckCardinalityPolicy(CardinalityPolicyOverflow))
+	suite.Error(CheckCardinalityPolicy("invalid"))
+}
@@ -3,17 +3,26 @@ package fork
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/antongulenko/golib"
 	"github.com/bitflow-stream/go-bitflow/bitflow"
+	log "github.com/sirupsen/logrus"
 )
 
 type MultiMetricSource struct {
 	MultiPipeline
 	bitflow.AbstractSampleProcessor
 
+	// Ordered enables merging the samples of all sub pipelines in the order of their timestamps.
+	// MaxLateness and IdleTimeout configure the WatermarkMerger used in that case.
+	Ordered     bool
+	MaxLateness time.Duration
+	IdleTimeout time.Duration
+
 	pipelines        []*bitflow.SamplePipeline
 	stoppedPipelines int
+	merger           *WatermarkMerger
 }
 
 func (in *MultiMetricSource) Add(subPipeline *bitflow.SamplePipeline) {
@@ -33,11 +42,27 @@ func (in *MultiMetricSource) AddSource(source bitflow.SampleSource, steps ...bit
 func (in *MultiMetricSource) Start(wg *sync.WaitGroup) golib.StopChan {
 	stopChan := golib.NewStopChan()
 	signalClose := func() {
+		if in.merger != nil {
+			if err := in.merger.Stop(); err != nil {
+				log.Errorf("[%v]: %v", in, err)
+			}
+		}
 		in.CloseSinkParallel(wg)
 		stopChan.Stop()
 	}
 
 	in.MultiPipeline.Init(in.GetSink(), signalClose, wg)
+	if in.Ordered {
+		in.merger = &WatermarkMerger{
+			MaxLateness: in.MaxLateness,
+			IdleTimeout: in.IdleTimeout,
+			Outgoing:    in.GetSink(),
+		}
+		for _, pipe := range in.pipelines {
+			pipe.Add(in.merger.NewInput())
+		}
+		in.merger.Start(wg)
+	}
 	for i, pipe := range in.pipelines {
 		in.start(i, pipe)
 	}
@@ -60,6 +85,9 @@ func (in *MultiMetricSource) Close() {
 }
 
 func (in *MultiMetricSource) String() string {
+	if in.Ordered {
+		return fmt.Sprintf("Ordered Multi Input (len %v, max lateness %v, idle timeout %v)", len(in.pipelines), in.MaxLateness, in.IdleTimeout)
+	}
 	return fmt.Sprintf("Multi Input (len %v)", len(in.pipelines))
 }
 
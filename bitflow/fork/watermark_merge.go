@@ -0,0 +1,186 @@
+package fork
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	log "github.com/sirupsen/logrus"
+)
+
+// WatermarkMerger merges the samples of multiple inputs into one stream that is ordered by the sample timestamps.
+// Every input must deliver its own samples in order. The samples are buffered until every input has advanced past
+// their timestamp (the watermark), and are then forwarded to Outgoing in order.
+//
+// Two mechanisms prevent one slow input from holding back the output indefinitely: if MaxLateness is > 0, a sample is
+// forwarded at the latest when any input has delivered a sample that is newer by MaxLateness. If IdleTimeout is > 0,
+// an input that did not deliver any samples for that duration is ignored when computing the watermark, until it
+// delivers the next sample. Samples that arrive after newer samples have already been forwarded are forwarded
+// immediately, so the output order can only be violated by samples that are later than the configured bounds.
+type WatermarkMerger struct {
+	MaxLateness time.Duration
+	IdleTimeout time.Duration
+	Outgoing    bitflow.SampleProcessor
+
+	inputs   []*WatermarkInput
+	buffer   timestampHeap
+	maxTime  time.Time
+	lastTime time.Time
+	lock     sync.Mutex
+	stopped  golib.StopChan
+
+	// LateSamples counts the samples that were forwarded out of order
+	LateSamples int
+}
+
+// NewInput creates a new input for the receiving WatermarkMerger. The returned SampleProcessor should be the last
+// step of an input pipeline. It does not forward any samples, but passes them to the WatermarkMerger.
+func (m *WatermarkMerger) NewInput() *WatermarkInput {
+	input := &WatermarkInput{merger: m, index: len(m.inputs), lastReceived: time.Now()}
+	m.inputs = append(m.inputs, input)
+	return input
+}
+
+// Start starts a background routine that regularly checks for idle inputs, if IdleTimeout is configured.
+func (m *WatermarkMerger) Start(wg *sync.WaitGroup) {
+	m.stopped = golib.NewStopChan()
+	if m.IdleTimeout > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m.stopped.WaitTimeout(m.IdleTimeout / 2) {
+				m.lock.Lock()
+				err := m.emit(false)
+				m.lock.Unlock()
+				if err != nil {
+					log.Errorf("%v: %v", m, err)
+				}
+			}
+		}()
+	}
+}
+
+// Stop forwards all remaining buffered samples and stops the background routine.
+func (m *WatermarkMerger) Stop() error {
+	m.stopped.Stop()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.emit(true)
+}
+
+func (m *WatermarkMerger) String() string {
+	return fmt.Sprintf("Watermark merger (%v inputs, max lateness %v, idle timeout %v)", len(m.inputs), m.MaxLateness, m.IdleTimeout)
+}
+
+func (m *WatermarkMerger) push(input *WatermarkInput, sample *bitflow.Sample, header *bitflow.Header) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	input.lastReceived = time.Now()
+	if sample.Time.After(input.watermark) {
+		input.watermark = sample.Time
+	}
+	if sample.Time.After(m.maxTime) {
+		m.maxTime = sample.Time
+	}
+	if sample.Time.Before(m.lastTime) {
+		m.LateSamples++
+		log.Debugf("%v: Forwarding late sample from input %v (time %v, already forwarded %v)", m, input.index, sample.Time, m.lastTime)
+		return m.Outgoing.Sample(sample, header)
+	}
+	heap.Push(&m.buffer, bitflow.SampleAndHeader{Sample: sample, Header: header})
+	return m.emit(false)
+}
+
+func (m *WatermarkMerger) closeInput(input *WatermarkInput) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	input.closed = true
+	return m.emit(false)
+}
+
+// watermark returns the time up to which all samples can be forwarded. Must be called while holding the lock.
+func (m *WatermarkMerger) watermark() time.Time {
+	var watermark time.Time
+	first := true
+	now := time.Now()
+	for _, input := range m.inputs {
+		if input.closed || (m.IdleTimeout > 0 && now.Sub(input.lastReceived) >= m.IdleTimeout) {
+			continue
+		}
+		if first || input.watermark.Before(watermark) {
+			watermark = input.watermark
+			first = false
+		}
+	}
+	if first {
+		// All inputs are closed or idle
+		watermark = m.maxTime
+	}
+	if m.MaxLateness > 0 {
+		if latenessBound := m.maxTime.Add(-m.MaxLateness); latenessBound.After(watermark) {
+			watermark = latenessBound
+		}
+	}
+	return watermark
+}
+
+// emit forwards all buffered samples up to the current watermark, or all samples if flushAll is true.
+// Must be called while holding the lock.
+func (m *WatermarkMerger) emit(flushAll bool) error {
+	watermark := m.watermark()
+	for len(m.buffer) > 0 && (flushAll || !m.buffer[0].Time.After(watermark)) {
+		next := heap.Pop(&m.buffer).(bitflow.SampleAndHeader)
+		m.lastTime = next.Time
+		if err := m.Outgoing.Sample(next.Sample, next.Header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatermarkInput is one input of a WatermarkMerger, created by WatermarkMerger.NewInput.
+type WatermarkInput struct {
+	bitflow.NoopProcessor
+	merger       *WatermarkMerger
+	index        int
+	watermark    time.Time
+	lastReceived time.Time
+	closed       bool
+}
+
+func (in *WatermarkInput) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	return in.merger.push(in, sample, header)
+}
+
+func (in *WatermarkInput) Close() {
+	if err := in.merger.closeInput(in); err != nil {
+		log.Errorf("%v: %v", in.merger, err)
+	}
+	in.NoopProcessor.Close()
+}
+
+func (in *WatermarkInput) String() string {
+	return fmt.Sprintf("Watermark merger input %v", in.index)
+}
+
+// timestampHeap implements heap.Interface and sorts the samples by their timestamp
+type timestampHeap []bitflow.SampleAndHeader
+
+func (h timestampHeap) Len() int           { return len(h) }
+func (h timestampHeap) Less(i, j int) bool { return h[i].Time.Before(h[j].Time) }
+func (h timestampHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *timestampHeap) Push(x interface{}) {
+	*h = append(*h, x.(bitflow.SampleAndHeader))
+}
+
+func (h *timestampHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,94 @@
+package fork
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+type timeCollector struct {
+	bitflow.DroppingSampleProcessor
+	times []int64
+}
+
+func (c *timeCollector) Sample(sample *bitflow.Sample, _ *bitflow.Header) error {
+	c.times = append(c.times, sample.Time.Unix())
+	return nil
+}
+
+func _pushAt(t *testing.T, input *WatermarkInput, seconds ...int64) {
+	header := &bitflow.Header{Fields: []string{"a"}}
+	for _, sec := range seconds {
+		testAssert.NoError(t, input.Sample(&bitflow.Sample{Time: time.Unix(sec, 0), Values: []bitflow.Value{1}}, header))
+	}
+}
+
+func TestWatermarkMergerOrdering(t *testing.T) {
+	assert := testAssert.New(t)
+	out := new(timeCollector)
+	merger := &WatermarkMerger{Outgoing: out}
+	a, b := merger.NewInput(), merger.NewInput()
+	var wg sync.WaitGroup
+	merger.Start(&wg)
+
+	// Input a runs ahead, input b delivers its samples late
+	_pushAt(t, a, 1, 3, 5)
+	assert.Empty(out.times, "no samples should be forwarded before all inputs delivered data")
+	_pushAt(t, b, 2)
+	assert.Equal([]int64{1, 2}, out.times)
+	_pushAt(t, a, 7)
+	_pushAt(t, b, 4, 6)
+	assert.Equal([]int64{1, 2, 3, 4, 5, 6}, out.times)
+	_pushAt(t, b, 8)
+	assert.Equal([]int64{1, 2, 3, 4, 5, 6, 7}, out.times)
+
+	// Closing an input releases the samples of the other inputs
+	a.Close()
+	assert.Equal([]int64{1, 2, 3, 4, 5, 6, 7, 8}, out.times)
+	b.Close()
+	assert.NoError(merger.Stop())
+	wg.Wait()
+	assert.Equal(0, merger.LateSamples)
+}
+
+func TestWatermarkMergerMaxLateness(t *testing.T) {
+	assert := testAssert.New(t)
+	out := new(timeCollector)
+	merger := &WatermarkMerger{Outgoing: out, MaxLateness: 3 * time.Second}
+	a, b := merger.NewInput(), merger.NewInput()
+	var wg sync.WaitGroup
+	merger.Start(&wg)
+
+	// Input b is silent, so only the lateness bound releases samples
+	_pushAt(t, a, 1, 2, 3, 4, 5)
+	assert.Equal([]int64{1, 2}, out.times)
+
+	// A sample older than the already forwarded samples is forwarded immediately
+	_pushAt(t, b, 1)
+	assert.Equal([]int64{1, 2, 1}, out.times)
+	assert.Equal(1, merger.LateSamples)
+
+	assert.NoError(merger.Stop())
+	wg.Wait()
+	assert.Equal([]int64{1, 2, 1, 3, 4, 5}, out.times)
+}
+
+func TestWatermarkMergerIdleInput(t *testing.T) {
+	assert := testAssert.New(t)
+	out := new(timeCollector)
+	merger := &WatermarkMerger{Outgoing: out, IdleTimeout: 50 * time.Millisecond}
+	a, _ := merger.NewInput(), merger.NewInput()
+	var wg sync.WaitGroup
+	merger.Start(&wg)
+
+	_pushAt(t, a, 1, 2)
+	time.Sleep(200 * time.Millisecond)
+	merger.lock.Lock()
+	assert.Equal([]int64{1, 2}, out.times, "the silent input should not hold back the samples after the idle timeout")
+	merger.lock.Unlock()
+	assert.NoError(merger.Stop())
+	wg.Wait()
+}
@@ -2,6 +2,7 @@ package bitflow
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -26,6 +27,15 @@ type Marshaller interface {
 	WriteSample(sample *Sample, header *Header, withTags bool, output io.Writer) error
 }
 
+// StatefulMarshaller can be implemented by Marshallers that keep state between the samples written
+// to one output stream. SampleWriter calls NewStreamMarshaller for every opened output stream and marshals
+// the samples of that stream sequentially in the order they are written. If NewStreamMarshaller returns nil,
+// the receiving Marshaller is used without restrictions.
+type StatefulMarshaller interface {
+	Marshaller
+	NewStreamMarshaller() Marshaller
+}
+
 // Unmarshaller is an interface for reading Samples and Headers from byte streams.
 // The byte streams can be anything including files, network connections, console output,
 // or in-memory byte buffers.
@@ -82,10 +92,45 @@ type BidiMarshaller interface {
 type UnmarshalledHeader struct {
 	Header
 	HasTags bool
+
+	csvColumns        *csvColumns // Only set by CsvMarshaller for non-default column layouts
+	jsonPendingSample []byte      // Only set by JsonMarshaller, when the header was derived from a sample
+
+	binaryTagDictionary [][]byte // Only used by BinaryMarshaller, see BinaryMarshaller.TagDictionary
+}
+
+// DefaultMaxLineLength is the maximum number of bytes that is read while searching for a delimiter
+// (e.g. a CSV line or a field name in a binary header), if no other limit is configured. It protects
+// against unbounded memory growth when reading corrupt data.
+var DefaultMaxLineLength = 16 * 1024 * 1024
+
+// LineTooLongError is returned when reading data from an input stream, if a delimiter could not be
+// found within the configured maximum line length.
+type LineTooLongError struct {
+	MaxLength int
 }
 
-func readUntil(reader *bufio.Reader, delimiter byte) (data []byte, err error) {
-	data, err = reader.ReadBytes(delimiter)
+func (e LineTooLongError) Error() string {
+	return fmt.Sprintf("Bitflow: line exceeds the maximum length of %v bytes", e.MaxLength)
+}
+
+// readUntil reads until the delimiter is found, similar to bufio.Reader.ReadBytes. If maxLength is
+// exceeded (DefaultMaxLineLength, if maxLength <= 0), a LineTooLongError is returned.
+func readUntil(reader *bufio.Reader, delimiter byte, maxLength int) (data []byte, err error) {
+	if maxLength <= 0 {
+		maxLength = DefaultMaxLineLength
+	}
+	for {
+		var chunk []byte
+		chunk, err = reader.ReadSlice(delimiter)
+		if len(data)+len(chunk) > maxLength {
+			return nil, LineTooLongError{MaxLength: maxLength}
+		}
+		data = append(data, chunk...)
+		if err != bufio.ErrBufferFull {
+			break
+		}
+	}
 	if err == io.EOF {
 		if len(data) > 0 && data[len(data)-1] != delimiter {
 			err = io.ErrUnexpectedEOF
@@ -123,10 +168,20 @@ func checkHeaderField(field string) error {
 	return nil
 }
 
-func detectFormat(input *bufio.Reader) (Unmarshaller, error) {
+// DetectFormat peeks at the first bytes of the given input stream (without consuming them) and returns an
+// Unmarshaller for the detected format. CSV, binary and JSON data can be detected. Data written in the
+// text format (e.g. by the console output) is detected as well, but leads to an error, because it cannot be parsed.
+// An error is also returned for empty input, or input that is too short to determine the format.
+func DetectFormat(input *bufio.Reader) (Unmarshaller, error) {
 	peeked, err := input.Peek(detect_format_peek)
 	if err == bufio.ErrBufferFull {
 		err = errors.New("IO buffer is too small to auto-detect input stream format")
+	} else if err == io.EOF {
+		if len(peeked) == 0 {
+			err = errors.New("Cannot auto-detect format of empty input stream")
+		} else {
+			err = fmt.Errorf("Cannot auto-detect format of input stream, the input is too short: %q", peeked)
+		}
 	}
 	if err != nil {
 		return nil, err
@@ -141,16 +196,53 @@ func DetectFormatFrom(start string) (Unmarshaller, error) {
 		return nil, fmt.Errorf("Cannot auto-detect format of stream based on '%v', need %v characters", start, detect_format_peek)
 	}
 
-	switch start {
-	case csv_time_col:
+	switch {
+	case start == csv_time_col:
 		return new(CsvMarshaller), nil
-	case binary_time_col:
+	case start == binary_time_col:
 		return new(BinaryMarshaller), nil
+	case start[0] == '{':
+		return new(JsonMarshaller), nil
+	case isTextFormat(start):
+		return nil, errors.New("The input stream is in the text format, which cannot be parsed. Use the csv, bin or json format instead")
 	default:
 		return nil, errors.New("Failed to auto-detect format of stream starting with: " + start)
 	}
 }
 
+// isTextFormat returns true, if the given string starts like the output of the TextMarshaller: either
+// with the line of TextMarshallerHeaderChar characters, or directly with the year of the timestamp.
+func isTextFormat(start string) bool {
+	if start[0] == TextMarshallerHeaderChar {
+		return true
+	}
+	for _, c := range start {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// marshalFieldMetadata encodes the metadata of the header fields as a JSON array, containing one object
+// (or null) for every field. The result does not contain newline characters.
+func marshalFieldMetadata(header *Header) ([]byte, error) {
+	return json.Marshal(header.FieldMetadata)
+}
+
+// parseFieldMetadata parses the result of marshalFieldMetadata and stores it in the header.
+func parseFieldMetadata(data []byte, header *Header) error {
+	var metadata []map[string]string
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return fmt.Errorf("Failed to parse header field metadata: %v", err)
+	}
+	if len(metadata) != len(header.Fields) {
+		return fmt.Errorf("Header field metadata contains %v entries, but the header contains %v fields", len(metadata), len(header.Fields))
+	}
+	header.FieldMetadata = metadata
+	return nil
+}
+
 // WriteCascade is a helper type for more concise Write code by avoiding error
 // checks on every Write() invocation. Multiple Write calls can be cascaded
 // without intermediate checks for errors. The trade-off/overhead are additional
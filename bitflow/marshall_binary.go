@@ -19,6 +19,15 @@ const (
 	// not collide with binary_time_col.
 	binary_sample_start = "X"
 
+	// Start of a sample that references its tags through an entry of the tag dictionary,
+	// and start of a new tag dictionary entry. See BinaryMarshaller.TagDictionary.
+	binary_dict_sample_start = "D"
+	binary_dict_entry_start  = "T"
+	dictIdBytes              = 4
+
+	// Start of the optional field metadata following the header. See BinaryMarshaller.
+	binary_metadata_start = "M"
+
 	// BinarySeparator is the character separating fields in the marshalled output
 	// of BinaryMarshaller. Every field is marshalled on a separate line.
 	BinarySeparator = '\n'
@@ -43,7 +52,31 @@ const (
 // of big-endian double-precision values, 8 bytes each. Since the number of metrics
 // is known from the header, the number of bytes for one sample is given as
 // 8 * number of metrics.
+//
+// If the header contains field metadata (see Header.FieldMetadata), e.g. the units of the metrics, the empty line
+// after the header is followed by the byte 'M' and a newline-terminated JSON array, containing an object of attributes
+// (or null) for every metric. Readers that do not support the metadata cannot parse such data, so it is only written
+// for headers with field metadata.
+//
+// If TagDictionary is > 0, repeating tag strings are not marshalled for every sample. Instead, every
+// new tag string is marshalled once as a dictionary entry, starting with the byte 'T', followed by
+// a big-endian uint32 id and the newline-terminated tag string. The following samples with the same tags
+// start with the byte 'D' instead of 'X', and contain the 4-byte id instead of the tag string.
+// The dictionary is kept for the entire output stream, including header changes. When the dictionary
+// contains TagDictionary entries, samples with new tag strings are marshalled with inline tags. The dictionary requires the samples of an
+// output stream to be marshalled sequentially, see StatefulMarshaller.
 type BinaryMarshaller struct {
+	TagDictionary int
+
+	// MaxLineLength limits the length of the header fields and tag strings when reading (DefaultMaxLineLength if not set).
+	// Longer data results in a LineTooLongError.
+	MaxLineLength int
+
+	dictionary *binaryTagDictionary
+}
+
+type binaryTagDictionary struct {
+	ids map[string]uint32
 }
 
 // String implements the Marshaller interface.
@@ -51,6 +84,16 @@ func (BinaryMarshaller) String() string {
 	return "binary"
 }
 
+// NewStreamMarshaller implements the StatefulMarshaller interface. If TagDictionary is > 0, it
+// returns a copy of the receiver with a new, empty tag dictionary. Otherwise, it returns nil.
+func (m BinaryMarshaller) NewStreamMarshaller() Marshaller {
+	if m.TagDictionary <= 0 {
+		return nil
+	}
+	m.dictionary = new(binaryTagDictionary)
+	return m
+}
+
 // WriteHeader implements the Marshaller interface by writing a newline-separated
 // list of header field strings and an additional empty line.
 func (BinaryMarshaller) WriteHeader(header *Header, withTags bool, writer io.Writer) error {
@@ -69,12 +112,25 @@ func (BinaryMarshaller) WriteHeader(header *Header, withTags bool, writer io.Wri
 		w.WriteByte(BinarySeparator)
 	}
 	w.WriteByte(BinarySeparator)
+	if header.HasFieldMetadata() {
+		metadata, err := marshalFieldMetadata(header)
+		if err != nil {
+			return err
+		}
+		w.WriteStr(binary_metadata_start)
+		w.Write(metadata)
+		w.WriteByte(BinarySeparator)
+	}
 	return w.Err
 }
 
 // WriteSample implements the Marshaller interface by writing the Sample out in a
 // dense binary format. See the BinaryMarshaller godoc for information on the format.
 func (m BinaryMarshaller) WriteSample(sample *Sample, header *Header, withTags bool, writer io.Writer) error {
+	if withTags && m.dictionary != nil {
+		return m.writeDictionarySample(sample, header, writer)
+	}
+
 	// Special bytes preceding each sample
 	if _, err := writer.Write([]byte(binary_sample_start)); err != nil {
 		return err
@@ -98,6 +154,10 @@ func (m BinaryMarshaller) WriteSample(sample *Sample, header *Header, withTags b
 		}
 	}
 
+	return m.writeValues(sample, writer)
+}
+
+func (BinaryMarshaller) writeValues(sample *Sample, writer io.Writer) error {
 	// Values as big-endian double precision
 	for _, value := range sample.Values {
 		valBits := math.Float64bits(float64(value))
@@ -110,6 +170,47 @@ func (m BinaryMarshaller) WriteSample(sample *Sample, header *Header, withTags b
 	return nil
 }
 
+func (m BinaryMarshaller) writeDictionarySample(sample *Sample, header *Header, writer io.Writer) error {
+	dict := m.dictionary
+	if dict.ids == nil {
+		dict.ids = make(map[string]uint32)
+	}
+	tags := sample.TagString()
+	id, ok := dict.ids[tags]
+	if !ok {
+		if len(dict.ids) >= m.TagDictionary {
+			// The dictionary is full, fall back to inline tags
+			return BinaryMarshaller{}.WriteSample(sample, header, true, writer)
+		}
+		id = uint32(len(dict.ids))
+		dict.ids[tags] = id
+		entry := make([]byte, 0, len(binary_dict_entry_start)+dictIdBytes+len(tags)+1)
+		entry = append(entry, binary_dict_entry_start...)
+		entry = appendDictId(entry, id)
+		entry = append(entry, tags...)
+		entry = append(entry, BinarySeparator)
+		if _, err := writer.Write(entry); err != nil {
+			return err
+		}
+	}
+
+	start := make([]byte, 0, len(binary_dict_sample_start)+timeBytes+dictIdBytes)
+	start = append(start, binary_dict_sample_start...)
+	start = append(start, make([]byte, timeBytes)...)
+	binary.BigEndian.PutUint64(start[len(binary_dict_sample_start):], uint64(sample.Time.UnixNano()))
+	start = appendDictId(start, id)
+	if _, err := writer.Write(start); err != nil {
+		return err
+	}
+	return m.writeValues(sample, writer)
+}
+
+func appendDictId(data []byte, id uint32) []byte {
+	var idBytes [dictIdBytes]byte
+	binary.BigEndian.PutUint32(idBytes[:], id)
+	return append(data, idBytes[:]...)
+}
+
 // Read implements the Unmarshaller interface. It peeks a few bytes from the input stream
 // to decide if the stream contains a header or a sample. In case of a header, Read() continues
 // reading until an empty line and parse the data to a header instance. In case of a sample,
@@ -131,19 +232,34 @@ func (m BinaryMarshaller) Read(reader *bufio.Reader, previousHeader *Unmarshalle
 
 	switch {
 	case bytes.HasPrefix([]byte(binary_time_col), start):
-		return m.readHeader(reader)
+		header, data, err := m.readHeader(reader)
+		if header != nil {
+			// The tag dictionary is valid for the entire stream
+			header.binaryTagDictionary = previousHeader.binaryTagDictionary
+		}
+		return header, data, err
 	case bytes.Equal(start, []byte(binary_sample_start)):
 		_, _ = reader.Discard(len(start)) // No error
 		data, err := m.readSampleData(previousHeader, reader)
 		return nil, data, err
+	case bytes.Equal(start, []byte(binary_dict_entry_start)):
+		_, _ = reader.Discard(len(start)) // No error
+		if err := m.readDictionaryEntry(previousHeader, reader); err != nil {
+			return nil, nil, err
+		}
+		return m.Read(reader, previousHeader)
+	case bytes.Equal(start, []byte(binary_dict_sample_start)):
+		_, _ = reader.Discard(len(start)) // No error
+		data, err := m.readDictionarySampleData(previousHeader, reader)
+		return nil, data, err
 	default:
 		return nil, nil, fmt.Errorf("Bitflow binary protocol error, unexpected: %s. Expected %s or %s.",
 			start, binary_sample_start, binary_time_col[:len(binary_sample_start)])
 	}
 }
 
-func (BinaryMarshaller) readHeader(reader *bufio.Reader) (*UnmarshalledHeader, []byte, error) {
-	name, err := readUntil(reader, BinarySeparator)
+func (m BinaryMarshaller) readHeader(reader *bufio.Reader) (*UnmarshalledHeader, []byte, error) {
+	name, err := readUntil(reader, BinarySeparator, m.MaxLineLength)
 	if err != nil {
 		if len(name) > 0 {
 			// EOF unexpected here: at least one empty line is needed
@@ -160,8 +276,11 @@ func (BinaryMarshaller) readHeader(reader *bufio.Reader) (*UnmarshalledHeader, [
 	header := new(UnmarshalledHeader)
 	first := true
 	for {
-		nameBytes, err := readUntil(reader, BinarySeparator)
+		nameBytes, err := readUntil(reader, BinarySeparator, m.MaxLineLength)
 		if len(nameBytes) == 1 {
+			if err == nil {
+				err = readBinaryFieldMetadata(reader, header, m.MaxLineLength)
+			}
 			// This may return io.EOF
 			return header, nil, err
 		}
@@ -179,7 +298,62 @@ func (BinaryMarshaller) readHeader(reader *bufio.Reader) (*UnmarshalledHeader, [
 	}
 }
 
-func (BinaryMarshaller) readSampleData(header *UnmarshalledHeader, input *bufio.Reader) ([]byte, error) {
+// readBinaryFieldMetadata reads the field metadata following the header, if present.
+func readBinaryFieldMetadata(reader *bufio.Reader, header *UnmarshalledHeader, maxLength int) error {
+	start, err := reader.Peek(len(binary_metadata_start))
+	if err != nil || string(start) != binary_metadata_start {
+		// Errors are returned when reading the next sample
+		return nil
+	}
+	_, _ = reader.Discard(len(start)) // No error
+	metadata, err := readUntil(reader, BinarySeparator, maxLength)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	return parseFieldMetadata(metadata[:len(metadata)-1], &header.Header)
+}
+
+func (m BinaryMarshaller) readDictionaryEntry(header *UnmarshalledHeader, input *bufio.Reader) error {
+	idBytes := make([]byte, dictIdBytes)
+	if _, err := io.ReadFull(input, idBytes); err != nil {
+		return unexpectedEOF(err)
+	}
+	tags, err := readUntil(input, BinarySeparator, m.MaxLineLength)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if id := binary.BigEndian.Uint32(idBytes); int(id) != len(header.binaryTagDictionary) {
+		return fmt.Errorf("Bitflow binary protocol error, unexpected tag dictionary id %v (expected %v)", id, len(header.binaryTagDictionary))
+	}
+	header.binaryTagDictionary = append(header.binaryTagDictionary, tags[:len(tags)-1])
+	return nil
+}
+
+// readDictionarySampleData reads a sample that references its tags through the tag dictionary,
+// and returns the same data as readSampleData would for the sample with inline tags.
+func (BinaryMarshaller) readDictionarySampleData(header *UnmarshalledHeader, input *bufio.Reader) ([]byte, error) {
+	if !header.HasTags {
+		return nil, errors.New("Bitflow binary protocol error, received sample with tag dictionary id for header without tags")
+	}
+	valueLen := valBytes * len(header.Fields)
+	data := make([]byte, timeBytes+dictIdBytes+valueLen)
+	if _, err := io.ReadFull(input, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	id := binary.BigEndian.Uint32(data[timeBytes:])
+	if int(id) >= len(header.binaryTagDictionary) {
+		return nil, fmt.Errorf("Bitflow binary protocol error, undefined tag dictionary id %v", id)
+	}
+	tags := header.binaryTagDictionary[id]
+	result := make([]byte, 0, timeBytes+len(tags)+1+valueLen)
+	result = append(result, data[:timeBytes]...)
+	result = append(result, tags...)
+	result = append(result, BinarySeparator)
+	result = append(result, data[timeBytes+dictIdBytes:]...)
+	return result, nil
+}
+
+func (m BinaryMarshaller) readSampleData(header *UnmarshalledHeader, input *bufio.Reader) ([]byte, error) {
 	valueLen := valBytes * len(header.Fields)
 	minLen := timeBytes + valueLen
 	data := make([]byte, minLen)
@@ -197,7 +371,7 @@ func (BinaryMarshaller) readSampleData(header *UnmarshalledHeader, input *bufio.
 			_, err := io.ReadFull(input, result[minLen:])
 			return result, unexpectedEOF(err)
 		} else {
-			tagRest, err := readUntil(input, BinarySeparator)
+			tagRest, err := readUntil(input, BinarySeparator, m.MaxLineLength)
 			if err != nil {
 				return nil, unexpectedEOF(err)
 			}
@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -27,8 +29,27 @@ const (
 	// CsvDateFormat is the format used by CsvMarshaller to marshall the timestamp
 	// of samples.
 	CsvDateFormat = "2006-01-02 15:04:05.999999999"
+
+	// CsvUnixTimeFormat can be used as CsvMarshaller.TimeFormat to write and parse timestamps given as (fractional) seconds since the Unix epoch.
+	CsvUnixTimeFormat = "unix"
+
+	// CsvRFC3339TimeFormat can be used as CsvMarshaller.TimeFormat to write and parse RFC 3339 (ISO 8601) timestamps with nanosecond precision.
+	CsvRFC3339TimeFormat = "rfc3339"
+
+	// CsvQuote is used by CsvMarshaller to enclose fields, if QuoteFields is set.
+	CsvQuote = '"'
+
+	// csv_metadata_prefix starts the optional comment line following a header line, which contains the field metadata.
+	csv_metadata_prefix = "#metadata "
 )
 
+// quotedCsvTagEscaper is used instead of TagStringEscaper when quoting CSV fields, so that tag keys and values
+// can contain the separator character.
+var quotedCsvTagEscaper = strings.NewReplacer(
+	tag_equals, tag_replacement,
+	tag_separator, tag_replacement,
+	string(CsvNewline), tag_replacement)
+
 // CsvMarshaller marshals Headers and Samples to a CSV format.
 //
 // Every header is marshalled as a comma-separated CSV header line.
@@ -44,12 +65,83 @@ const (
 //
 // Every CSV line must be terminated by a newline character (including the last line in a file).
 //
+// Separator can be set to use a different character than CsvSeparator for separating fields, e.g. ';' or '\t'.
+// If QuoteFields is set, metric names and tags containing the separator or the quote character are enclosed in
+// quotes according to RFC 4180, and quoted fields are unquoted when reading. Otherwise, metric names must not contain the
+// separator, and the separator is replaced in tags. Newline characters are not allowed in any field. The same settings must be
+// used for writing and reading the data.
+//
+// If the header contains field metadata (see Header.FieldMetadata), e.g. the units of the metrics, the header line is followed
+// by a comment line starting with "#metadata ", followed by a JSON array containing an object of attributes (or null) for every metric.
+// Readers that do not support the metadata line cannot parse such data, so it is only written for headers with field metadata.
+//
 // CsvMarshaller can deal with multiple header declarations in the same file or
 // data stream. A line that begins with the string "time" is assumed to start a new header,
 // since samples usually start with a timestamp, which cannot be formatted as "time".
 //
-// There are no configuration options for CsvMarshaller.
+// When reading, lines longer than MaxLineLength bytes (DefaultMaxLineLength if not set) are
+// rejected with a LineTooLongError.
+//
+// The remaining fields allow reading CSV data with a different column layout, e.g. produced by
+// other tools. If TimeColumn is set, or RowTime is > 0, the header line can have arbitrary columns.
+// Columns are identified by their name or, if no column has that name, by their 0-based index.
+// All columns except the time and tags columns are parsed as metrics. In this mode, a line is
+// assumed to start a new header, if its first field equals the first column name of the current header.
+// Writing is not affected by these fields, except for TimeFormat.
 type CsvMarshaller struct {
+	MaxLineLength int
+
+	// Separator is the character separating fields. The default is CsvSeparator.
+	Separator rune
+
+	// QuoteFields enables quoting fields that contain the separator, see the documentation of CsvMarshaller.
+	QuoteFields bool
+
+	// IntegerValues enables writing integral values within the int64 range as plain integers, e.g. 12345678901234567890
+	// instead of 1.2345678901234567e+19. This avoids the exponent notation for large counters, which some importers
+	// cannot parse. The written integers are read back to the exact same float64 values.
+	IntegerValues bool
+
+	// TimeColumn is the name or index of the column containing the timestamps.
+	TimeColumn string
+
+	// TagsColumn is the name or index of the column containing the tags (optional).
+	TagsColumn string
+
+	// TimeFormat is the layout for writing and parsing the timestamps (see time.Parse), or one of CsvUnixTimeFormat
+	// and CsvRFC3339TimeFormat. Timestamps are written in UTC. By default, the timestamps are written with CsvDateFormat,
+	// and parsed timestamps can be in CsvDateFormat, RFC 3339 format, or given as seconds since the Unix epoch.
+	TimeFormat string
+
+	// RowTime can be set to synthesize timestamps from the row order, instead of reading them from TimeColumn.
+	// The first row after every header receives the timestamp RowTimeStart (the Unix epoch by default),
+	// and the timestamps of the following rows increase by RowTime.
+	RowTime      time.Duration
+	RowTimeStart time.Time
+}
+
+type csvColumns struct {
+	firstColumn string
+	time        int // -1 when synthesizing timestamps
+	tags        int // -1 when there is no tags column
+	values      []int
+	row         int64 // Only accessed sequentially in Read()
+}
+
+func (c CsvMarshaller) customLayout() bool {
+	return c.TimeColumn != "" || c.RowTime > 0
+}
+
+// customSyntax returns true if the data is not written and read with the default separator and without quoting.
+func (c CsvMarshaller) customSyntax() bool {
+	return c.separator() != CsvSeparator || c.QuoteFields
+}
+
+func (c CsvMarshaller) separator() rune {
+	if c.Separator == 0 {
+		return CsvSeparator
+	}
+	return c.Separator
 }
 
 // String implements the Marshaller interface.
@@ -58,43 +150,177 @@ func (CsvMarshaller) String() string {
 }
 
 // WriteHeader implements the Marshaller interface by printing a CSV header line.
-func (CsvMarshaller) WriteHeader(header *Header, withTags bool, writer io.Writer) error {
+func (c CsvMarshaller) WriteHeader(header *Header, withTags bool, writer io.Writer) error {
+	// Check all fields before writing anything, to avoid leaving a partial header line
+	for _, name := range header.Fields {
+		if err := c.checkHeaderField(name); err != nil {
+			return err
+		}
+	}
+	sep := string(c.separator())
 	w := WriteCascade{Writer: writer}
 	w.WriteStr(csv_time_col)
 	if withTags {
-		w.WriteByte(CsvSeparator)
+		w.WriteStr(sep)
 		w.WriteStr(tags_col)
 	}
 	for _, name := range header.Fields {
-		if err := checkHeaderField(name); err != nil {
+		w.WriteStr(sep)
+		w.WriteStr(c.quote(name))
+	}
+	w.WriteStr(string(CsvNewline))
+	if header.HasFieldMetadata() {
+		metadata, err := marshalFieldMetadata(header)
+		if err != nil {
 			return err
 		}
-		w.WriteByte(CsvSeparator)
-		w.WriteStr(name)
+		w.WriteStr(csv_metadata_prefix)
+		w.Write(metadata)
+		w.WriteStr(string(CsvNewline))
 	}
-	w.WriteStr(string(CsvNewline))
 	return w.Err
 }
 
 // WriteSample implements the Marshaller interface by writing a CSV line.
-func (CsvMarshaller) WriteSample(sample *Sample, header *Header, withTags bool, writer io.Writer) error {
+func (c CsvMarshaller) WriteSample(sample *Sample, header *Header, withTags bool, writer io.Writer) error {
+	sep := string(c.separator())
 	w := WriteCascade{Writer: writer}
-	w.WriteStr(sample.Time.UTC().Format(CsvDateFormat))
+	w.WriteStr(c.formatTime(sample.Time))
 	if withTags {
-		tags := sample.TagString()
-		w.WriteByte(CsvSeparator)
+		var tags string
+		if c.QuoteFields {
+			tags = c.quote(sample.tagString(quotedCsvTagEscaper))
+		} else {
+			tags = sample.TagString()
+			if sep != string(CsvSeparator) {
+				tags = strings.Replace(tags, sep, tag_replacement, -1)
+			}
+		}
+		w.WriteStr(sep)
 		w.WriteStr(tags)
 	}
 	for _, value := range sample.Values {
-		w.WriteByte(CsvSeparator)
-		w.WriteAny(value)
+		w.WriteStr(sep)
+		w.WriteStr(c.formatValue(value))
 	}
 	w.WriteStr(string(CsvNewline))
 	return w.Err
 }
 
-func splitCsvLine(line []byte) []string {
-	return strings.Split(string(line), string(CsvSeparator))
+// formatValue formats integral values as plain integers, if IntegerValues is set.
+// All other values are formatted like Value.String().
+func (c CsvMarshaller) formatValue(value Value) string {
+	if c.IntegerValues {
+		// float64(math.MaxInt64) is rounded to 2^63, which is outside the int64 range. Negative zero keeps its sign.
+		if v := float64(value); v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 && !(v == 0 && math.Signbit(v)) {
+			return strconv.FormatInt(int64(v), 10)
+		}
+	}
+	return value.String()
+}
+
+// parseValue parses a metric value. If IntegerValues is set, plain integers are parsed as int64 before converting them
+// to float64, so that values written by formatValue are restored exactly.
+func (c CsvMarshaller) parseValue(field string) (Value, error) {
+	if c.IntegerValues {
+		if intVal, err := strconv.ParseInt(field, 10, 64); err == nil {
+			return Value(intVal), nil
+		}
+	}
+	val, err := strconv.ParseFloat(field, 64)
+	return Value(val), err
+}
+
+func (c CsvMarshaller) checkHeaderField(field string) error {
+	if c.separator() == CsvSeparator && !c.QuoteFields {
+		return checkHeaderField(field)
+	}
+	if field == "" {
+		return errors.New("Header fields cannot be empty")
+	}
+	illegal := string(CsvNewline)
+	if !c.QuoteFields {
+		illegal += string(c.separator())
+	}
+	if strings.ContainsAny(field, illegal) {
+		return fmt.Errorf("Header field '%s' contains illegal characters", field)
+	}
+	return nil
+}
+
+// quote encloses the field in quotes, if QuoteFields is set and the field contains the separator or the quote character.
+func (c CsvMarshaller) quote(field string) string {
+	if !c.QuoteFields || !strings.ContainsAny(field, string([]rune{c.separator(), CsvQuote})) {
+		return field
+	}
+	quote := string(CsvQuote)
+	return quote + strings.Replace(field, quote, quote+quote, -1) + quote
+}
+
+func (c CsvMarshaller) splitLine(line []byte) ([]string, error) {
+	if !c.QuoteFields {
+		return strings.Split(string(line), string(c.separator())), nil
+	}
+	return splitQuotedCsvLine(string(line), c.separator())
+}
+
+// splitQuotedCsvLine splits the line at every separator that is not enclosed in quotes, and unquotes the resulting fields.
+func splitQuotedCsvLine(line string, sep rune) ([]string, error) {
+	var fields []string
+	for {
+		if len(line) == 0 || line[0] != CsvQuote {
+			index := strings.IndexRune(line, sep)
+			if index < 0 {
+				return append(fields, line), nil
+			}
+			fields = append(fields, line[:index])
+			line = line[index+utf8.RuneLen(sep):]
+			continue
+		}
+
+		var field strings.Builder
+		i := 1
+		for {
+			end := strings.IndexByte(line[i:], CsvQuote)
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated quoted CSV field: %v", line)
+			}
+			field.WriteString(line[i : i+end])
+			i += end + 1
+			if i < len(line) && line[i] == CsvQuote {
+				// Escaped quote character
+				field.WriteByte(CsvQuote)
+				i++
+			} else {
+				break
+			}
+		}
+		fields = append(fields, field.String())
+		line = line[i:]
+		if len(line) == 0 {
+			return fields, nil
+		}
+		if r, size := utf8.DecodeRuneInString(line); r != sep {
+			return nil, fmt.Errorf("Unexpected character after quoted CSV field: %q", r)
+		} else {
+			line = line[size:]
+		}
+	}
+}
+
+// firstField returns the unquoted first field of the line
+func (c CsvMarshaller) firstField(line []byte) (string, error) {
+	if c.QuoteFields && len(line) > 0 && line[0] == CsvQuote {
+		fields, err := c.splitLine(line)
+		if err != nil {
+			return "", err
+		}
+		return fields[0], nil
+	}
+	if index := bytes.IndexRune(line, c.separator()); index >= 0 {
+		return string(line[:index]), nil
+	}
+	return string(line), nil // Only one field
 }
 
 // Read implements the Unmarshaller interface by reading CSV line from the input stream.
@@ -102,7 +328,7 @@ func splitCsvLine(line []byte) []string {
 // In case of a header, the CSV fields are split and parsed to a Header instance.
 // In case of a Sample, the data for the line is returned without parsing it.
 func (c CsvMarshaller) Read(reader *bufio.Reader, previousHeader *UnmarshalledHeader) (*UnmarshalledHeader, []byte, error) {
-	line, err := readUntil(reader, CsvNewline)
+	line, err := readUntil(reader, CsvNewline, c.MaxLineLength)
 	if err == io.EOF {
 		if len(line) == 0 {
 			return nil, nil, err
@@ -116,29 +342,54 @@ func (c CsvMarshaller) Read(reader *bufio.Reader, previousHeader *UnmarshalledHe
 	} else if len(line) > 0 {
 		line = line[:len(line)-1] // Strip newline char
 	}
-	index := bytes.Index(line, []byte{CsvSeparator})
-	var firstField string
-	if index < 0 {
-		firstField = string(line) // Only one field
-	} else {
-		firstField = string(line[:index])
+	firstField, splitErr := c.firstField(line)
+	if splitErr != nil {
+		return nil, nil, splitErr
 	}
 
+	if c.customLayout() {
+		return c.readCustomLayout(line, firstField, previousHeader, err)
+	}
 	switch {
 	case previousHeader == nil:
 		if checkErr := checkFirstField(csv_time_col, firstField); checkErr != nil {
 			return nil, nil, checkErr
 		}
-		return c.parseHeader(line), nil, err
+		return c.parseHeaderWithMetadata(reader, line, err)
 	case firstField == csv_time_col:
-		return c.parseHeader(line), nil, err
+		return c.parseHeaderWithMetadata(reader, line, err)
 	default:
 		return nil, line, err
 	}
 }
 
-func (CsvMarshaller) parseHeader(line []byte) *UnmarshalledHeader {
-	fields := splitCsvLine(line)
+// parseHeaderWithMetadata parses the header line and reads the following field metadata line, if present.
+func (c CsvMarshaller) parseHeaderWithMetadata(reader *bufio.Reader, line []byte, readErr error) (*UnmarshalledHeader, []byte, error) {
+	header, data, err := c.parseHeader(line, readErr)
+	if err != nil || header == nil {
+		return header, data, err
+	}
+	prefix, peekErr := reader.Peek(len(csv_metadata_prefix))
+	if peekErr != nil || string(prefix) != csv_metadata_prefix {
+		// Errors are returned when reading the next line
+		return header, data, err
+	}
+	metadata, metadataErr := readUntil(reader, CsvNewline, c.MaxLineLength)
+	if metadataErr != nil && metadataErr != io.EOF {
+		return nil, nil, metadataErr
+	}
+	metadata = bytes.TrimSuffix(metadata[len(csv_metadata_prefix):], []byte{CsvNewline})
+	if parseErr := parseFieldMetadata(metadata, &header.Header); parseErr != nil {
+		return nil, nil, parseErr
+	}
+	return header, data, metadataErr
+}
+
+func (c CsvMarshaller) parseHeader(line []byte, readErr error) (*UnmarshalledHeader, []byte, error) {
+	fields, err := c.splitLine(line)
+	if err != nil {
+		return nil, nil, err
+	}
 	if WarnObsoleteBinaryFormat && len(fields) == 1 {
 		log.Warnln("CSV header contains only time field. This might be the old binary format, " +
 			"use the 'old_binary_format' tag from the go-bitflow-pipeline repository.")
@@ -155,14 +406,227 @@ func (CsvMarshaller) parseHeader(line []byte) *UnmarshalledHeader {
 	if len(header.Fields) == 0 {
 		header.Fields = nil
 	}
-	return header
+	return header, nil, readErr
+}
+
+func (c CsvMarshaller) readCustomLayout(line []byte, firstField string, previousHeader *UnmarshalledHeader, err error) (*UnmarshalledHeader, []byte, error) {
+	if previousHeader == nil || previousHeader.csvColumns == nil || firstField == previousHeader.csvColumns.firstColumn {
+		header, headerErr := c.parseCustomHeader(line)
+		if headerErr != nil {
+			return nil, nil, headerErr
+		}
+		return header, nil, err
+	}
+	if columns := previousHeader.csvColumns; columns.time < 0 {
+		// Prepend the row number, which is needed for the timestamp when parsing the sample in parallel
+		rowLine := make([]byte, 0, len(line)+21)
+		rowLine = strconv.AppendInt(rowLine, columns.row, 10)
+		rowLine = append(rowLine, string(c.separator())...)
+		line = append(rowLine, line...)
+		columns.row++
+	}
+	return nil, line, err
+}
+
+func (c CsvMarshaller) parseCustomHeader(line []byte) (*UnmarshalledHeader, error) {
+	fields, err := c.splitLine(line)
+	if err != nil {
+		return nil, err
+	}
+	columns := &csvColumns{
+		firstColumn: fields[0],
+		time:        -1,
+		tags:        -1,
+	}
+	if c.RowTime <= 0 {
+		if columns.time, err = findCsvColumn(c.TimeColumn, fields); err != nil {
+			return nil, err
+		}
+	}
+	if c.TagsColumn != "" {
+		if columns.tags, err = findCsvColumn(c.TagsColumn, fields); err != nil {
+			return nil, err
+		}
+		if columns.tags == columns.time {
+			return nil, fmt.Errorf("The CSV time and tags columns must be different (column %v)", columns.tags)
+		}
+	}
+	header := &UnmarshalledHeader{
+		HasTags:    columns.tags >= 0,
+		csvColumns: columns,
+	}
+	for i, field := range fields {
+		if i != columns.time && i != columns.tags {
+			columns.values = append(columns.values, i)
+			header.Fields = append(header.Fields, field)
+		}
+	}
+	return header, nil
+}
+
+// findCsvColumn returns the index of the column with the given name, or the column index given as number.
+func findCsvColumn(column string, fields []string) (int, error) {
+	for i, field := range fields {
+		if field == column {
+			return i, nil
+		}
+	}
+	if index, err := strconv.Atoi(column); err == nil && index >= 0 && index < len(fields) {
+		return index, nil
+	}
+	return -1, fmt.Errorf("CSV header does not contain column '%v': %v", column, fields)
+}
+
+func (c CsvMarshaller) formatTime(t time.Time) string {
+	t = t.UTC()
+	switch c.TimeFormat {
+	case "":
+		return t.Format(CsvDateFormat)
+	case CsvUnixTimeFormat:
+		return formatCsvUnixTime(t)
+	case CsvRFC3339TimeFormat:
+		return t.Format(time.RFC3339Nano)
+	default:
+		return t.Format(c.TimeFormat)
+	}
+}
+
+func (c CsvMarshaller) parseTime(field string) (time.Time, error) {
+	switch c.TimeFormat {
+	case "":
+		// Auto-detect the format, starting with the default format
+		if t, err := time.Parse(CsvDateFormat, field); err == nil {
+			return t, nil
+		}
+		if t, err := time.Parse(time.RFC3339Nano, field); err == nil {
+			return t, nil
+		}
+		if t, err := parseCsvUnixTime(field); err == nil {
+			return t, nil
+		}
+		return time.Time{}, fmt.Errorf("Failed to parse CSV timestamp '%v', expected the format '%v', RFC 3339, or seconds since the Unix epoch", field, CsvDateFormat)
+	case CsvUnixTimeFormat:
+		return parseCsvUnixTime(field)
+	case CsvRFC3339TimeFormat:
+		return time.Parse(time.RFC3339Nano, field)
+	default:
+		return time.Parse(c.TimeFormat, field)
+	}
+}
+
+// formatCsvUnixTime formats the seconds since the Unix epoch, without losing the nanosecond precision.
+func formatCsvUnixTime(t time.Time) string {
+	nanos := t.UnixNano()
+	sign := ""
+	if nanos < 0 {
+		sign = "-"
+		nanos = -nanos
+	}
+	seconds, fraction := nanos/int64(time.Second), nanos%int64(time.Second)
+	if fraction == 0 {
+		return sign + strconv.FormatInt(seconds, 10)
+	}
+	return sign + strconv.FormatInt(seconds, 10) + "." + strings.TrimRight(fmt.Sprintf("%09d", fraction), "0")
+}
+
+// parseCsvUnixTime parses (fractional) seconds since the Unix epoch. Decimal numbers with up to 9 fractional digits
+// are parsed exactly, other numbers (e.g. in exponent notation) are parsed as float64 values.
+func parseCsvUnixTime(field string) (time.Time, error) {
+	secondsStr, fractionStr := field, ""
+	if index := strings.IndexByte(field, '.'); index >= 0 {
+		secondsStr, fractionStr = field[:index], field[index+1:]
+	}
+	seconds, err := strconv.ParseInt(secondsStr, 10, 64)
+	var fraction uint64
+	if err == nil && fractionStr != "" {
+		if len(fractionStr) > 9 {
+			err = errors.New("Too many fractional digits")
+		} else {
+			fraction, err = strconv.ParseUint(fractionStr+strings.Repeat("0", 9-len(fractionStr)), 10, 64)
+		}
+	}
+	if err != nil {
+		floatSeconds, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(floatSeconds*float64(time.Second))), nil
+	}
+	nanos := int64(fraction)
+	if strings.HasPrefix(secondsStr, "-") {
+		nanos = -nanos
+	}
+	return time.Unix(seconds, nanos), nil
+}
+
+func (c CsvMarshaller) parseCustomSample(header *UnmarshalledHeader, minValueCapacity int, data []byte) (*Sample, error) {
+	fields, err := c.splitLine(data)
+	if err != nil {
+		return nil, err
+	}
+	columns := header.csvColumns
+	capacity := len(columns.values)
+	if minValueCapacity > capacity {
+		capacity = minValueCapacity
+	}
+	sample := &Sample{
+		Values: make([]Value, len(columns.values), capacity),
+	}
+	if columns.time < 0 {
+		row, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		fields = fields[1:]
+		start := c.RowTimeStart
+		if start.IsZero() {
+			start = time.Unix(0, 0)
+		}
+		sample.Time = start.Add(time.Duration(row) * c.RowTime)
+	}
+	if expected := len(columns.values) + countColumns(columns); len(fields) != expected {
+		return nil, fmt.Errorf("CSV line has %v fields, but the header has %v columns", len(fields), expected)
+	}
+	if columns.time >= 0 {
+		if sample.Time, err = c.parseTime(fields[columns.time]); err != nil {
+			return nil, err
+		}
+	}
+	if columns.tags >= 0 {
+		if err = sample.ParseTagString(fields[columns.tags]); err != nil {
+			return nil, err
+		}
+	}
+	for i, index := range columns.values {
+		if sample.Values[i], err = c.parseValue(fields[index]); err != nil {
+			return nil, err
+		}
+	}
+	return sample, nil
+}
+
+// countColumns returns the number of time and tags columns
+func countColumns(columns *csvColumns) (res int) {
+	if columns.time >= 0 {
+		res++
+	}
+	if columns.tags >= 0 {
+		res++
+	}
+	return
 }
 
 // ParseSample implements the Unmarshaller interface by parsing a CSV line.
-func (CsvMarshaller) ParseSample(header *UnmarshalledHeader, minValueCapacity int, data []byte) (sample *Sample, err error) {
-	fields := splitCsvLine(data)
+func (c CsvMarshaller) ParseSample(header *UnmarshalledHeader, minValueCapacity int, data []byte) (sample *Sample, err error) {
+	if header.csvColumns != nil {
+		return c.parseCustomSample(header, minValueCapacity, data)
+	}
+	fields, err := c.splitLine(data)
+	if err != nil {
+		return
+	}
 	var t time.Time
-	t, err = time.Parse(CsvDateFormat, fields[0])
+	t, err = c.parseTime(fields[0])
 	if err != nil {
 		return
 	}
@@ -188,11 +652,11 @@ func (CsvMarshaller) ParseSample(header *UnmarshalledHeader, minValueCapacity in
 	}
 
 	for _, field := range fields[start:] {
-		var val float64
-		if val, err = strconv.ParseFloat(field, 64); err != nil {
+		var val Value
+		if val, err = c.parseValue(field); err != nil {
 			return
 		}
-		sample.Values = append(sample.Values, Value(val))
+		sample.Values = append(sample.Values, val)
 	}
 	return sample, nil
 }
@@ -0,0 +1,324 @@
+package bitflow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	// JsonNewline terminates every JSON object written by JsonMarshaller.
+	JsonNewline = '\n'
+
+	// JsonDateFormat is the format used by JsonMarshaller to marshall the timestamp of samples.
+	JsonDateFormat = time.RFC3339Nano
+
+	json_header_key = "header"
+	json_time_key   = "time"
+	json_tags_key   = "tags"
+	json_values_key = "values"
+)
+
+// JsonMarshaller marshals Headers and Samples to newline-delimited JSON objects.
+//
+// Every sample is marshalled to one JSON object on a separate line:
+//
+//	{"time":"2006-01-02T15:04:05.999999999Z","tags":{"key":"value"},"values":{"field1":1.5,"field2":0}}
+//
+// The time is formatted with JsonDateFormat in the UTC timezone. The tags object is only present, if the header
+// declares tags. Values that cannot be represented in JSON (NaN and infinity) are marshalled as null.
+//
+// Every header is marshalled to an object containing the list of fields and a flag for the tags:
+//
+//	{"header":["field1","field2"],"tags":true}
+//
+// When reading, the header objects are optional, which allows reading JSON data produced by other tools.
+// If a sample contains values or tags that are not declared in the current header, a new header is created
+// from the union of the previous header fields and the keys found in the sample. Fields that are missing in
+// a sample are filled with zeros, null values are read as NaN.
+//
+// When reading, lines longer than MaxLineLength bytes (DefaultMaxLineLength if not set) are
+// rejected with a LineTooLongError.
+type JsonMarshaller struct {
+	MaxLineLength int
+}
+
+type jsonValue struct {
+	name  string
+	value Value
+}
+
+// jsonValues preserves the order of the keys in a JSON object, which determines the order of
+// header fields that are synthesized from samples.
+type jsonValues []jsonValue
+
+func (values *jsonValues) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil {
+		return err
+	} else if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("Expected JSON object for sample values, but found: %v", token)
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		name, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("Expected string key in JSON object, but found: %v", token)
+		}
+		if token, err = decoder.Token(); err != nil {
+			return err
+		}
+		val := Value(math.NaN())
+		switch token := token.(type) {
+		case float64:
+			val = Value(token)
+		case nil:
+		default:
+			return fmt.Errorf("Expected number or null for value '%v', but found: %v", name, token)
+		}
+		*values = append(*values, jsonValue{name: name, value: val})
+	}
+	return nil
+}
+
+type jsonLine struct {
+	Header *[]string        `json:"header"`
+	Time   string           `json:"time"`
+	Tags   *json.RawMessage `json:"tags"`
+	Values jsonValues       `json:"values"`
+}
+
+func (line *jsonLine) isHeader() bool {
+	return line.Header != nil
+}
+
+// String implements the Marshaller interface.
+func (JsonMarshaller) String() string {
+	return "JSON"
+}
+
+// WriteHeader implements the Marshaller interface by writing a JSON object with the header fields.
+func (JsonMarshaller) WriteHeader(header *Header, withTags bool, writer io.Writer) error {
+	w := WriteCascade{Writer: writer}
+	w.WriteStr(`{"` + json_header_key + `":[`)
+	for i, name := range header.Fields {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		writeJsonString(&w, name)
+	}
+	w.WriteStr(`],"` + json_tags_key + `":` + strconv.FormatBool(withTags) + "}")
+	w.WriteByte(JsonNewline)
+	return w.Err
+}
+
+// WriteSample implements the Marshaller interface by writing a JSON object with the time, tags and values of the sample.
+func (JsonMarshaller) WriteSample(sample *Sample, header *Header, withTags bool, writer io.Writer) error {
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("Cannot marshall sample with %v values for header with %v fields", len(sample.Values), len(header.Fields))
+	}
+	w := WriteCascade{Writer: writer}
+	w.WriteStr(`{"` + json_time_key + `":`)
+	writeJsonString(&w, sample.Time.UTC().Format(JsonDateFormat))
+	if withTags {
+		w.WriteStr(`,"` + json_tags_key + `":{`)
+		for i, tag := range sample.SortedTags() {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			writeJsonString(&w, tag.Key)
+			w.WriteByte(':')
+			writeJsonString(&w, tag.Value)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteStr(`,"` + json_values_key + `":{`)
+	for i, value := range sample.Values {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		writeJsonString(&w, header.Fields[i])
+		w.WriteByte(':')
+		if f := float64(value); math.IsNaN(f) || math.IsInf(f, 0) {
+			w.WriteStr("null")
+		} else {
+			w.WriteStr(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	}
+	w.WriteStr("}}")
+	w.WriteByte(JsonNewline)
+	return w.Err
+}
+
+func writeJsonString(w *WriteCascade, str string) {
+	if w.Err == nil {
+		var data []byte
+		data, w.Err = json.Marshal(str)
+		w.Write(data)
+	}
+}
+
+// Read implements the Unmarshaller interface by reading one line from the input stream and decoding the contained
+// JSON object. Header objects are parsed to a new Header. If a sample object does not fit the previous header,
+// a new Header is derived from it and the sample is returned by the next invocation of Read.
+func (m JsonMarshaller) Read(reader *bufio.Reader, previousHeader *UnmarshalledHeader) (*UnmarshalledHeader, []byte, error) {
+	if previousHeader != nil && previousHeader.jsonPendingSample != nil {
+		data := previousHeader.jsonPendingSample
+		previousHeader.jsonPendingSample = nil
+		return nil, data, nil
+	}
+	data, err := readUntil(reader, JsonNewline, m.MaxLineLength)
+	if err == io.EOF {
+		if len(data) == 0 {
+			return nil, nil, err
+		}
+	} else if err != nil {
+		return nil, nil, err
+	} else if len(data) == 1 {
+		return nil, nil, errors.New("Empty JSON line")
+	} else {
+		data = data[:len(data)-1] // Strip newline char
+	}
+
+	var line jsonLine
+	if decodeErr := json.Unmarshal(data, &line); decodeErr != nil {
+		return nil, nil, fmt.Errorf("Failed to decode JSON line: %v", decodeErr)
+	}
+	if line.isHeader() {
+		header := &UnmarshalledHeader{
+			HasTags: line.Tags != nil && string(*line.Tags) == "true",
+		}
+		if len(*line.Header) > 0 {
+			header.Fields = *line.Header
+		}
+		for _, field := range header.Fields {
+			if field == "" {
+				return nil, nil, errors.New("Header fields cannot be empty")
+			}
+		}
+		return header, nil, err
+	}
+	if header := m.deriveHeader(&line, previousHeader); header != nil {
+		// The sample data is returned by the next call to Read(), so the EOF must not be reported now
+		header.jsonPendingSample = data
+		return header, nil, nil
+	}
+	return nil, data, err
+}
+
+// deriveHeader returns a new header, if the given sample does not fit the previous header, or nil otherwise.
+func (JsonMarshaller) deriveHeader(line *jsonLine, previousHeader *UnmarshalledHeader) *UnmarshalledHeader {
+	hasTags := line.Tags != nil
+	if previousHeader == nil {
+		header := &UnmarshalledHeader{HasTags: hasTags}
+		for _, value := range line.Values {
+			header.Fields = append(header.Fields, value.name)
+		}
+		return header
+	}
+	if line.Values.matches(previousHeader.Fields) && (previousHeader.HasTags || !hasTags) {
+		return nil
+	}
+	known := jsonFieldIndices(previousHeader.Fields)
+	header := &UnmarshalledHeader{
+		HasTags: hasTags || previousHeader.HasTags,
+		Header:  Header{Fields: append([]string(nil), previousHeader.Fields...)},
+	}
+	for _, value := range line.Values {
+		if _, ok := known[value.name]; !ok {
+			known[value.name] = len(header.Fields)
+			header.Fields = append(header.Fields, value.name)
+		}
+	}
+	return header
+}
+
+// matches returns true, if all values are contained in the given fields
+func (values jsonValues) matches(fields []string) bool {
+	if len(values) <= len(fields) {
+		inOrder := true
+		for i, value := range values {
+			if fields[i] != value.name {
+				inOrder = false
+				break
+			}
+		}
+		if inOrder {
+			return true
+		}
+	}
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field] = true
+	}
+	for _, value := range values {
+		if !known[value.name] {
+			return false
+		}
+	}
+	return true
+}
+
+func jsonFieldIndices(fields []string) map[string]int {
+	indices := make(map[string]int, len(fields))
+	for i, field := range fields {
+		indices[field] = i
+	}
+	return indices
+}
+
+// ParseSample implements the Unmarshaller interface by decoding a JSON object. Values that are not contained
+// in the sample are set to zero.
+func (JsonMarshaller) ParseSample(header *UnmarshalledHeader, minValueCapacity int, data []byte) (*Sample, error) {
+	var line jsonLine
+	if err := json.Unmarshal(data, &line); err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON sample: %v", err)
+	}
+	t, err := time.Parse(JsonDateFormat, line.Time)
+	if err != nil {
+		return nil, err
+	}
+	sample := &Sample{Time: t}
+
+	capacity := len(header.Fields)
+	if minValueCapacity > capacity {
+		capacity = minValueCapacity
+	}
+	if capacity > 0 {
+		sample.Values = make([]Value, len(header.Fields), capacity)
+	}
+	var indices map[string]int
+	for i, value := range line.Values {
+		index := i
+		if i >= len(header.Fields) || header.Fields[i] != value.name {
+			if indices == nil {
+				indices = jsonFieldIndices(header.Fields)
+			}
+			var ok bool
+			if index, ok = indices[value.name]; !ok {
+				return nil, fmt.Errorf("JSON sample contains value '%v', which is not defined in the header", value.name)
+			}
+		}
+		sample.Values[index] = value.value
+	}
+
+	if line.Tags != nil && header.HasTags {
+		var tags map[string]string
+		if err := json.Unmarshal(*line.Tags, &tags); err != nil {
+			return nil, fmt.Errorf("Failed to decode JSON sample tags: %v", err)
+		}
+		for key, value := range tags {
+			sample.SetTag(key, value)
+		}
+	}
+	return sample, nil
+}
@@ -0,0 +1,128 @@
+package bitflow
+
+import (
+	"bufio"
+	"io"
+)
+
+// The types in this file support code that was written against the old github.com/antongulenko/go-bitflow package.
+// The marshalled data formats did not change between the package generations: both write the 'tags' column
+// into the header, if the following samples contain tags, and data written by one generation can be read by the other.
+// Only the Go API changed: in the old package, the HasTags flag was part of the Header and was not passed to
+// the Marshaller separately. To migrate, replace the import paths and use NewLegacyMarshaller, NewLegacyUnmarshaller
+// or WrapLegacyMarshaller where old and new code have to be combined. The legacy types can then be removed step by step.
+
+// LegacyHeader is the header type of the old package generation, which contained the HasTags flag.
+type LegacyHeader struct {
+	Fields  []string
+	HasTags bool
+
+	unmarshalled *UnmarshalledHeader // Set by LegacyUnmarshaller, keeps the parsing state of the Unmarshaller
+}
+
+// NewLegacyHeader converts a header to the old package generation.
+func NewLegacyHeader(header *Header, hasTags bool) *LegacyHeader {
+	return &LegacyHeader{Fields: header.Fields, HasTags: hasTags}
+}
+
+// Header converts the header to the current package generation.
+func (h *LegacyHeader) Header() *Header {
+	if h.unmarshalled != nil {
+		return &h.unmarshalled.Header
+	}
+	return &Header{Fields: h.Fields}
+}
+
+// Unmarshalled returns the UnmarshalledHeader corresponding to the header, including the HasTags flag.
+func (h *LegacyHeader) Unmarshalled() *UnmarshalledHeader {
+	if h.unmarshalled == nil {
+		h.unmarshalled = &UnmarshalledHeader{Header: Header{Fields: h.Fields}, HasTags: h.HasTags}
+	}
+	return h.unmarshalled
+}
+
+// LegacyMarshaller is the Marshaller interface of the old package generation.
+type LegacyMarshaller interface {
+	String() string
+	WriteHeader(header *LegacyHeader, output io.Writer) error
+	WriteSample(sample *Sample, header *LegacyHeader, output io.Writer) error
+}
+
+// LegacyUnmarshaller is the Unmarshaller interface of the old package generation.
+type LegacyUnmarshaller interface {
+	String() string
+	Read(input *bufio.Reader, previousHeader *LegacyHeader) (newHeader *LegacyHeader, sampleData []byte, err error)
+	ParseSample(header *LegacyHeader, minValueCapacity int, data []byte) (*Sample, error)
+}
+
+// NewLegacyMarshaller adapts a Marshaller to the interface of the old package generation.
+func NewLegacyMarshaller(m Marshaller) LegacyMarshaller {
+	return legacyMarshaller{m}
+}
+
+// NewLegacyUnmarshaller adapts an Unmarshaller to the interface of the old package generation.
+func NewLegacyUnmarshaller(u Unmarshaller) LegacyUnmarshaller {
+	return legacyUnmarshaller{u}
+}
+
+// WrapLegacyMarshaller adapts a Marshaller implemented for the old package generation, so it can be used
+// with the current package, e.g. in a SampleWriter.
+func WrapLegacyMarshaller(m LegacyMarshaller) Marshaller {
+	return wrappedLegacyMarshaller{m}
+}
+
+type legacyMarshaller struct {
+	m Marshaller
+}
+
+func (l legacyMarshaller) String() string {
+	return l.m.String()
+}
+
+func (l legacyMarshaller) WriteHeader(header *LegacyHeader, output io.Writer) error {
+	return l.m.WriteHeader(header.Header(), header.HasTags, output)
+}
+
+func (l legacyMarshaller) WriteSample(sample *Sample, header *LegacyHeader, output io.Writer) error {
+	return l.m.WriteSample(sample, header.Header(), header.HasTags, output)
+}
+
+type legacyUnmarshaller struct {
+	u Unmarshaller
+}
+
+func (l legacyUnmarshaller) String() string {
+	return l.u.String()
+}
+
+func (l legacyUnmarshaller) Read(input *bufio.Reader, previousHeader *LegacyHeader) (*LegacyHeader, []byte, error) {
+	var previous *UnmarshalledHeader
+	if previousHeader != nil {
+		previous = previousHeader.Unmarshalled()
+	}
+	header, data, err := l.u.Read(input, previous)
+	if header == nil {
+		return nil, data, err
+	}
+	return &LegacyHeader{Fields: header.Fields, HasTags: header.HasTags, unmarshalled: header}, data, err
+}
+
+func (l legacyUnmarshaller) ParseSample(header *LegacyHeader, minValueCapacity int, data []byte) (*Sample, error) {
+	return l.u.ParseSample(header.Unmarshalled(), minValueCapacity, data)
+}
+
+type wrappedLegacyMarshaller struct {
+	m LegacyMarshaller
+}
+
+func (w wrappedLegacyMarshaller) String() string {
+	return w.m.String()
+}
+
+func (w wrappedLegacyMarshaller) WriteHeader(header *Header, withTags bool, output io.Writer) error {
+	return w.m.WriteHeader(NewLegacyHeader(header, withTags), output)
+}
+
+func (w wrappedLegacyMarshaller) WriteSample(sample *Sample, header *Header, withTags bool, output io.Writer) error {
+	return w.m.WriteSample(sample, NewLegacyHeader(header, withTags), output)
+}
@@ -0,0 +1,107 @@
+package bitflow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+func (suite *MarshallerTestSuite) readLegacy(u LegacyUnmarshaller, data []byte) ([]*LegacyHeader, [][]*Sample) {
+	rdr := bufio.NewReader(bytes.NewReader(data))
+	var headers []*LegacyHeader
+	var samples [][]*Sample
+	var header *LegacyHeader
+	for {
+		newHeader, data, err := u.Read(rdr, header)
+		if err == io.EOF {
+			break
+		}
+		suite.NoError(err)
+		if newHeader != nil {
+			header = newHeader
+			headers = append(headers, header)
+			samples = append(samples, nil)
+		} else {
+			sample, err := u.ParseSample(header, 0, data)
+			suite.NoError(err)
+			samples[len(samples)-1] = append(samples[len(samples)-1], sample)
+		}
+	}
+	return headers, samples
+}
+
+func (suite *MarshallerTestSuite) testLegacyRoundTrip(m BidiMarshaller) {
+	legacy := NewLegacyMarshaller(m)
+	wrapped := WrapLegacyMarshaller(legacy)
+	var buf, legacyBuf, wrappedBuf bytes.Buffer
+	for i, header := range suite.headers {
+		suite.write(m, &buf, header, suite.samples[i])
+		legacyHeader := NewLegacyHeader(&header.Header, header.HasTags)
+		suite.NoError(legacy.WriteHeader(legacyHeader, &legacyBuf))
+		suite.NoError(wrapped.WriteHeader(&header.Header, header.HasTags, &wrappedBuf))
+		for _, sample := range suite.samples[i] {
+			suite.NoError(legacy.WriteSample(sample, legacyHeader, &legacyBuf))
+			suite.NoError(wrapped.WriteSample(sample, &header.Header, header.HasTags, &wrappedBuf))
+		}
+	}
+	// Both generations produce the same data
+	suite.Equal(buf.Bytes(), legacyBuf.Bytes())
+	suite.Equal(buf.Bytes(), wrappedBuf.Bytes())
+
+	// Data written through the old API is read by the current Unmarshaller
+	rdr := bufio.NewReader(bytes.NewReader(legacyBuf.Bytes()))
+	for i, header := range suite.headers {
+		suite.testRead(m, rdr, header, suite.samples[i])
+	}
+
+	// Data written by the current Marshaller is read through the old API
+	headers, samples := suite.readLegacy(NewLegacyUnmarshaller(m), buf.Bytes())
+	suite.Len(headers, len(suite.headers))
+	for i, header := range headers {
+		suite.Equal(suite.headers[i].Fields, header.Fields)
+		suite.Equal(suite.headers[i].HasTags, header.HasTags)
+		suite.Equal(suite.headers[i].Fields, header.Header().Fields)
+		suite.Len(samples[i], len(suite.samples[i]))
+		for j, sample := range samples[i] {
+			suite.compareSamples(suite.samples[i][j], sample, cap(sample.Values))
+		}
+	}
+}
+
+func (suite *MarshallerTestSuite) TestLegacyCsvMarshaller() {
+	suite.testLegacyRoundTrip(new(CsvMarshaller))
+}
+
+func (suite *MarshallerTestSuite) TestLegacyBinaryMarshaller() {
+	suite.testLegacyRoundTrip(new(BinaryMarshaller))
+}
+
+func (suite *MarshallerTestSuite) TestReadLegacyData() {
+	timestamp := time.Date(2017, 3, 4, 5, 6, 7, 8, time.UTC)
+	check := func(m Unmarshaller, data []byte) {
+		headers, samples := suite.readLegacy(NewLegacyUnmarshaller(m), data)
+		suite.Len(headers, 1)
+		suite.Equal([]string{"a", "b"}, headers[0].Fields)
+		suite.True(headers[0].HasTags)
+		suite.Len(samples[0], 1)
+		suite.True(timestamp.Equal(samples[0][0].Time))
+		suite.Equal([]Value{1.5, 2}, samples[0][0].Values)
+		suite.Equal(map[string]string{"host": "x", "vm": "y"}, samples[0][0].TagMap())
+	}
+
+	// Data as written by the old package generation
+	check(new(CsvMarshaller), []byte("time,tags,a,b\n2017-03-04 05:06:07.000000008,host=x vm=y,1.5,2\n"))
+
+	data := []byte("timB\ntags\na\nb\n\nX")
+	data = append(data, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(data[len(data)-8:], uint64(timestamp.UnixNano()))
+	data = append(data, "host=x vm=y\n"...)
+	for _, val := range []float64{1.5, 2} {
+		data = append(data, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(data[len(data)-8:], math.Float64bits(val))
+	}
+	check(new(BinaryMarshaller), data)
+}
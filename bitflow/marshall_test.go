@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"math"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -84,6 +87,99 @@ func (suite *MarshallerTestSuite) TestCsvMarshallerMulti() {
 	suite.testAllHeaders(new(CsvMarshaller))
 }
 
+func (suite *MarshallerTestSuite) TestCsvMarshallerSeparator() {
+	suite.testIndividualHeaders(CsvMarshaller{Separator: ';'})
+	suite.testAllHeaders(CsvMarshaller{Separator: '\t'})
+}
+
+func (suite *MarshallerTestSuite) TestCsvMarshallerQuoted() {
+	suite.testIndividualHeaders(CsvMarshaller{QuoteFields: true})
+	suite.testAllHeaders(CsvMarshaller{Separator: ';', QuoteFields: true})
+}
+
+func (suite *MarshallerTestSuite) TestCsvQuotedRoundTrip() {
+	for _, m := range []CsvMarshaller{{QuoteFields: true}, {Separator: ';', QuoteFields: true}} {
+		header := &Header{Fields: []string{"a,b", "c;d", `e"f`, "g"}}
+		sample := &Sample{Time: time.Unix(1000, 0), Values: []Value{1, 2, 3, 4}}
+		sample.SetTag("key", "x,y;z")
+		sample.SetTag("other", `"quoted"`)
+
+		var buf bytes.Buffer
+		suite.NoError(m.WriteHeader(header, true, &buf))
+		suite.NoError(m.WriteSample(sample, header, true, &buf))
+		headers, samples := suite.readCsv(m, buf.String())
+		suite.Len(headers, 1)
+		suite.Equal(header.Fields, headers[0].Fields)
+		suite.True(headers[0].HasTags)
+		suite.Len(samples, 1)
+		suite.Equal(sample.Values, samples[0].Values)
+		suite.Equal(sample.TagMap(), samples[0].TagMap())
+		suite.True(sample.Time.Equal(samples[0].Time))
+	}
+}
+
+func (suite *MarshallerTestSuite) TestCsvSeparatorWithoutQuoting() {
+	m := CsvMarshaller{Separator: ';'}
+	var buf bytes.Buffer
+	suite.Error(m.WriteHeader(&Header{Fields: []string{"a;b"}}, false, &buf))
+	suite.NoError(m.WriteHeader(&Header{Fields: []string{"a,b"}}, true, &buf))
+
+	sample := &Sample{Time: time.Unix(1000, 0), Values: []Value{1}}
+	sample.SetTag("key", "x;y")
+	suite.NoError(m.WriteSample(sample, &Header{Fields: []string{"a,b"}}, true, &buf))
+	headers, samples := suite.readCsv(m, buf.String())
+	suite.Len(headers, 1)
+	suite.Equal([]string{"a,b"}, headers[0].Fields)
+	suite.Len(samples, 1)
+	suite.Equal(map[string]string{"key": "x_y"}, samples[0].TagMap())
+}
+
+func (suite *MarshallerTestSuite) TestCsvIntegerValues() {
+	header := &Header{Fields: []string{"a", "b", "c", "d", "e", "f"}}
+	values := []Value{1 << 60, -(1 << 62), 12345678901234567890, 1.5, 0, Value(math.Inf(1))}
+	sample := &Sample{Time: time.Unix(1000, 0), Values: values}
+
+	var buf bytes.Buffer
+	suite.NoError(CsvMarshaller{}.WriteSample(sample, header, false, &buf))
+	suite.Contains(buf.String(), "e+18")
+
+	m := CsvMarshaller{IntegerValues: true}
+	buf.Reset()
+	suite.NoError(m.WriteHeader(header, false, &buf))
+	suite.NoError(m.WriteSample(sample, header, false, &buf))
+	suite.True(strings.HasSuffix(buf.String(), ",1152921504606846976,-4611686018427387904,1.2345678901234567e+19,1.5,0,+Inf\n"), buf.String())
+
+	_, samples := suite.readCsv(m, buf.String())
+	suite.Len(samples, 1)
+	suite.Equal(values, samples[0].Values)
+}
+
+func (suite *MarshallerTestSuite) TestSplitQuotedCsvLine() {
+	fields, err := splitQuotedCsvLine(`a,"b,c","d""e",,""`, ',')
+	suite.NoError(err)
+	suite.Equal([]string{"a", "b,c", `d"e`, "", ""}, fields)
+	fields, err = splitQuotedCsvLine("a\t\"b\tc\"", '\t')
+	suite.NoError(err)
+	suite.Equal([]string{"a", "b\tc"}, fields)
+
+	_, err = splitQuotedCsvLine(`a,"b`, ',')
+	suite.Error(err, "unterminated quote")
+	_, err = splitQuotedCsvLine(`"a"b,c`, ',')
+	suite.Error(err, "characters after closing quote")
+}
+
+func (suite *MarshallerTestSuite) TestParseCsvSeparator() {
+	for input, expected := range map[string]rune{"": 0, ";": ';', "\\t": '\t', "tab": '\t', "|": '|'} {
+		sep, err := ParseCsvSeparator(input)
+		suite.NoError(err)
+		suite.Equal(expected, sep, "input %v", input)
+	}
+	for _, input := range []string{";;", "\n", `"`} {
+		_, err := ParseCsvSeparator(input)
+		suite.Error(err, "input %v", input)
+	}
+}
+
 func (suite *MarshallerTestSuite) TestBinaryMarshallerSingle() {
 	suite.testIndividualHeaders(new(BinaryMarshaller))
 }
@@ -92,6 +188,14 @@ func (suite *MarshallerTestSuite) TestBinaryMarshallerMulti() {
 	suite.testAllHeaders(new(BinaryMarshaller))
 }
 
+func (suite *MarshallerTestSuite) TestJsonMarshallerSingle() {
+	suite.testIndividualHeaders(new(JsonMarshaller))
+}
+
+func (suite *MarshallerTestSuite) TestJsonMarshallerMulti() {
+	suite.testAllHeaders(new(JsonMarshaller))
+}
+
 type failingBuf struct {
 	err error
 }
@@ -116,3 +220,418 @@ func (suite *MarshallerTestSuite) TestCsvEOF() {
 func (suite *MarshallerTestSuite) TestBinaryEOF() {
 	suite.testEOF(new(BinaryMarshaller))
 }
+
+func (suite *MarshallerTestSuite) TestJsonEOF() {
+	suite.testEOF(new(JsonMarshaller))
+}
+
+func (suite *MarshallerTestSuite) TestFieldMetadataRoundTrip() {
+	header := &Header{Fields: []string{"mem", "cpu", "count"}}
+	suite.True(header.SetFieldUnit("mem", "bytes"))
+	suite.True(header.SetFieldDescription("mem", "Used memory, including caches"))
+	suite.True(header.SetFieldUnit("cpu", "percent"))
+	suite.False(header.SetFieldUnit("missing", "seconds"))
+	sample := &Sample{Time: time.Unix(100, 0), Values: []Value{1, 2, 3}}
+	sample.SetTag("a", "b")
+
+	for _, m := range []BidiMarshaller{new(CsvMarshaller), new(BinaryMarshaller)} {
+		var buf bytes.Buffer
+		suite.NoError(m.WriteHeader(header, true, &buf))
+		suite.NoError(m.WriteSample(sample, header, true, &buf))
+		suite.NoError(m.WriteHeader(&Header{Fields: header.Fields}, false, &buf))
+
+		rdr := bufio.NewReader(&buf)
+		readHeader, data, err := m.Read(rdr, nil)
+		suite.NoError(err, "marshaller %v", m)
+		suite.Nil(data)
+		suite.True(header.Equals(&readHeader.Header), "marshaller %v", m)
+		unit, _ := readHeader.GetFieldMetadata(0, FieldMetadataUnit)
+		suite.Equal("bytes", unit)
+		description, _ := readHeader.GetFieldMetadata(0, FieldMetadataDescription)
+		suite.Equal("Used memory, including caches", description)
+		_, ok := readHeader.GetFieldMetadata(2, FieldMetadataUnit)
+		suite.False(ok)
+
+		_, data, err = m.Read(rdr, readHeader)
+		suite.NoError(err)
+		readSample, err := m.ParseSample(readHeader, 0, data)
+		suite.NoError(err)
+		suite.Equal(sample.Values, readSample.Values)
+		suite.Equal("b", readSample.Tag("a"))
+
+		// Headers without metadata are marshalled as before
+		plainHeader, _, err := m.Read(rdr, readHeader)
+		suite.NoError(err)
+		suite.Equal(header.Fields, plainHeader.Fields)
+		suite.False(plainHeader.HasFieldMetadata())
+	}
+
+	_, _, err := new(CsvMarshaller).Read(bufio.NewReader(strings.NewReader("time,a\n#metadata [{}, {}]\n")), nil)
+	suite.EqualError(err, "Header field metadata contains 2 entries, but the header contains 1 fields")
+}
+
+func (suite *MarshallerTestSuite) TestDetectFormat() {
+	header, samples := suite.headers[0], suite.samples[0]
+	for _, m := range []BidiMarshaller{new(CsvMarshaller), new(BinaryMarshaller), new(JsonMarshaller)} {
+		var buf bytes.Buffer
+		suite.write(m, &buf, header, samples)
+		rdr := bufio.NewReader(&buf)
+		um, err := DetectFormat(rdr)
+		suite.NoError(err)
+		suite.IsType(m, um)
+
+		// The detection must not consume any data
+		readHeader, _, err := um.Read(rdr, nil)
+		suite.NoError(err)
+		suite.NotNil(readHeader)
+	}
+
+	var buf bytes.Buffer
+	suite.NoError(TextMarshaller{}.WriteSample(samples[0], &header.Header, true, &buf))
+	_, err := DetectFormat(bufio.NewReader(&buf))
+	suite.EqualError(err, "The input stream is in the text format, which cannot be parsed. Use the csv, bin or json format instead")
+	_, err = DetectFormat(bufio.NewReader(strings.NewReader("2019-01-01 10:00:00 a = 1\n")))
+	suite.Error(err)
+
+	_, err = DetectFormat(bufio.NewReader(strings.NewReader("")))
+	suite.EqualError(err, "Cannot auto-detect format of empty input stream")
+	_, err = DetectFormat(bufio.NewReader(strings.NewReader("ti")))
+	suite.EqualError(err, `Cannot auto-detect format of input stream, the input is too short: "ti"`)
+	_, err = DetectFormat(bufio.NewReader(strings.NewReader("abcdef")))
+	suite.EqualError(err, "Failed to auto-detect format of stream starting with: abcd")
+}
+
+func (suite *MarshallerTestSuite) TestTextMarshallerAlignment() {
+	header := &Header{Fields: []string{"a", "a-very-long-metric-name"}}
+	m := TextMarshaller{Columns: 1, MaxWidth: 10, Precision: 2}.NewStreamMarshaller()
+	valueLines := func(values ...Value) []string {
+		var buf bytes.Buffer
+		suite.NoError(m.WriteSample(&Sample{Values: values, Time: time.Now()}, header, false, &buf))
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		return lines[1:]
+	}
+
+	suite.Equal([]string{
+		"a          =  1.00",
+		"a-very-... = 10.50",
+	}, valueLines(1, 10.5))
+	suite.Equal([]string{
+		"a          =   2.00",
+		"a-very-... = 100.00",
+	}, valueLines(2, 100))
+	// The values stay aligned to the widest value seen so far
+	suite.Equal([]string{
+		"a          =   3.00",
+		"a-very-... =   4.00",
+	}, valueLines(3, 4))
+
+	// A new header resets the value width
+	header = &Header{Fields: []string{"x"}}
+	suite.Equal([]string{"x = 5.00"}, valueLines(5))
+
+	// Without stream state, the values are aligned within the sample and the default precision is used
+	var buf bytes.Buffer
+	suite.NoError(TextMarshaller{Columns: 1}.WriteSample(&Sample{Values: []Value{1, 22}}, &Header{Fields: []string{"a", "bb"}}, false, &buf))
+	suite.Equal([]string{"a  =  1.0000", "bb = 22.0000"}, strings.Split(strings.TrimSpace(buf.String()), "\n")[1:])
+}
+
+type endlessBuf struct {
+}
+
+func (endlessBuf) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 'x'
+	}
+	return len(b), nil
+}
+
+func (suite *MarshallerTestSuite) TestCsvMaxLineLength() {
+	m := CsvMarshaller{MaxLineLength: 1024}
+	rdr := bufio.NewReader(io.MultiReader(strings.NewReader("time,a\n"), endlessBuf{}))
+	header, data, err := m.Read(rdr, nil)
+	suite.NoError(err)
+	suite.Nil(data)
+	suite.Equal([]string{"a"}, header.Fields)
+
+	header, data, err = m.Read(rdr, header)
+	suite.Nil(header)
+	suite.Nil(data)
+	suite.Equal(LineTooLongError{MaxLength: 1024}, err)
+
+	// Lines up to the maximum length are accepted
+	line := "time," + strings.Repeat("a", 1018) + "\n"
+	suite.Len(line, 1024)
+	header, _, err = m.Read(bufio.NewReader(strings.NewReader(line)), nil)
+	suite.NoError(err)
+	suite.Equal([]string{strings.Repeat("a", 1018)}, header.Fields)
+}
+
+func (suite *MarshallerTestSuite) readCsv(m CsvMarshaller, input string) ([]*UnmarshalledHeader, []*Sample) {
+	rdr := bufio.NewReader(strings.NewReader(input))
+	var headers []*UnmarshalledHeader
+	var samples []*Sample
+	var header *UnmarshalledHeader
+	for {
+		newHeader, data, err := m.Read(rdr, header)
+		if err == io.EOF {
+			break
+		}
+		suite.NoError(err)
+		if newHeader != nil {
+			header = newHeader
+			headers = append(headers, header)
+		} else {
+			sample, err := m.ParseSample(header, 0, data)
+			suite.NoError(err)
+			samples = append(samples, sample)
+		}
+	}
+	return headers, samples
+}
+
+func (suite *MarshallerTestSuite) TestCsvCustomLayout() {
+	m := CsvMarshaller{TimeColumn: "ts", TagsColumn: "labels", TimeFormat: CsvUnixTimeFormat}
+	headers, samples := suite.readCsv(m, "a,ts,labels,b\n1.5,1000.5,x=y,2\n3,1001,,4\n")
+	suite.Len(headers, 1)
+	suite.Equal([]string{"a", "b"}, headers[0].Fields)
+	suite.True(headers[0].HasTags)
+	suite.Len(samples, 2)
+	suite.Equal([]Value{1.5, 2}, samples[0].Values)
+	suite.Equal(time.Unix(1000, int64(500*time.Millisecond)).UnixNano(), samples[0].Time.UnixNano())
+	suite.Equal(map[string]string{"x": "y"}, samples[0].TagMap())
+	suite.Equal([]Value{3, 4}, samples[1].Values)
+	suite.Equal(time.Unix(1001, 0).UnixNano(), samples[1].Time.UnixNano())
+	suite.Empty(samples[1].TagMap())
+
+	// Columns given by index, default time format, repeated header line
+	m = CsvMarshaller{TimeColumn: "1"}
+	headers, samples = suite.readCsv(m, "a,date\n1,2019-01-01 10:00:00\na,date,b\n2,2019-01-01 10:00:01,3\n")
+	suite.Len(headers, 2)
+	suite.Equal([]string{"a"}, headers[0].Fields)
+	suite.Equal([]string{"a", "b"}, headers[1].Fields)
+	suite.False(headers[0].HasTags)
+	suite.Len(samples, 2)
+	suite.Equal([]Value{1}, samples[0].Values)
+	suite.Equal([]Value{2, 3}, samples[1].Values)
+	suite.True(time.Date(2019, 1, 1, 10, 0, 1, 0, time.UTC).Equal(samples[1].Time))
+
+	_, _, err := CsvMarshaller{TimeColumn: "missing"}.Read(bufio.NewReader(strings.NewReader("a,b\n")), nil)
+	suite.Error(err)
+	_, err = m.ParseSample(headers[1], 0, []byte("1,2019-01-01 10:00:01"))
+	suite.Error(err, "too few fields")
+}
+
+func (suite *MarshallerTestSuite) TestCsvRowTime() {
+	start := time.Unix(5000, 0)
+	m := CsvMarshaller{RowTime: time.Second, RowTimeStart: start}
+	headers, samples := suite.readCsv(m, "x,y\n1,2\n3,4\n5,6\n")
+	suite.Len(headers, 1)
+	suite.Equal([]string{"x", "y"}, headers[0].Fields)
+	suite.Len(samples, 3)
+	for i, sample := range samples {
+		suite.Equal([]Value{Value(2*i + 1), Value(2*i + 2)}, sample.Values)
+		suite.True(start.Add(time.Duration(i)*time.Second).Equal(sample.Time), "sample %v has time %v", i, sample.Time)
+	}
+}
+
+func (suite *MarshallerTestSuite) TestCsvTimeFormat() {
+	header := &Header{Fields: []string{"a"}}
+	timestamp := time.Date(2019, 3, 4, 10, 11, 12, 123456789, time.UTC)
+	sample := &Sample{Time: timestamp, Values: []Value{1}}
+	for format, expected := range map[string]string{
+		"":                   "2019-03-04 10:11:12.123456789",
+		CsvRFC3339TimeFormat: "2019-03-04T10:11:12.123456789Z",
+		CsvUnixTimeFormat:    "1551694272.123456789",
+		time.RFC3339:         "2019-03-04T10:11:12Z",
+	} {
+		m := CsvMarshaller{TimeFormat: format}
+		var buf bytes.Buffer
+		suite.NoError(m.WriteHeader(header, false, &buf))
+		suite.NoError(m.WriteSample(sample, header, false, &buf))
+		suite.Equal("time,a\n"+expected+",1\n", buf.String())
+
+		// Read back with the same format, and with auto-detection
+		for _, readFormat := range []string{format, ""} {
+			_, samples := suite.readCsv(CsvMarshaller{TimeFormat: readFormat}, buf.String())
+			suite.Len(samples, 1)
+			expectedTime := timestamp
+			if format == time.RFC3339 {
+				expectedTime = timestamp.Truncate(time.Second)
+			}
+			suite.True(expectedTime.Equal(samples[0].Time), "format %v, read format %v: %v", format, readFormat, samples[0].Time)
+		}
+	}
+
+	// Fractional seconds are parsed exactly, also before the Unix epoch
+	for field, expected := range map[string]time.Time{
+		"1.5":   time.Unix(1, int64(500*time.Millisecond)),
+		"-1.5":  time.Unix(-1, -int64(500*time.Millisecond)),
+		"1e3":   time.Unix(1000, 0),
+		"1000.": time.Unix(1000, 0),
+	} {
+		t, err := parseCsvUnixTime(field)
+		suite.NoError(err)
+		suite.True(expected.Equal(t), "%v parsed as %v", field, t)
+	}
+	suite.Equal("-1.5", formatCsvUnixTime(time.Unix(-1, -int64(500*time.Millisecond))))
+
+	_, err := CsvMarshaller{}.ParseSample(&UnmarshalledHeader{Header: *header}, 0, []byte("yesterday,1"))
+	suite.EqualError(err, "Failed to parse CSV timestamp 'yesterday', expected the format '2006-01-02 15:04:05.999999999', RFC 3339, or seconds since the Unix epoch")
+}
+
+func (suite *MarshallerTestSuite) TestBinaryMaxLineLength() {
+	oldMax := DefaultMaxLineLength
+	defer func() {
+		DefaultMaxLineLength = oldMax
+	}()
+	DefaultMaxLineLength = 1024
+
+	rdr := bufio.NewReader(io.MultiReader(strings.NewReader(binary_time_col+"\n"), endlessBuf{}))
+	header, data, err := new(BinaryMarshaller).Read(rdr, nil)
+	suite.NotNil(header) // Partially parsed header
+	suite.Nil(data)
+	suite.Equal(LineTooLongError{MaxLength: 1024}, err)
+}
+
+func (suite *MarshallerTestSuite) TestBinaryMaxLineLengthField() {
+	rdr := bufio.NewReader(io.MultiReader(strings.NewReader(binary_time_col+"\n"), endlessBuf{}))
+	header, data, err := BinaryMarshaller{MaxLineLength: 512}.Read(rdr, nil)
+	suite.NotNil(header)
+	suite.Nil(data)
+	suite.Equal(LineTooLongError{MaxLength: 512}, err)
+}
+
+func (suite *MarshallerTestSuite) TestJsonWithoutHeader() {
+	input := `{"time":"2019-01-01T10:00:00Z","values":{"a":1,"b":2}}
+{"time":"2019-01-01T10:00:01Z","values":{"b":3}}
+{"time":"2019-01-01T10:00:02Z","tags":{"x":"y"},"values":{"c":4,"a":5}}
+{"time":"2019-01-01T10:00:03Z","values":{"a":null}}
+`
+	um, err := DetectFormatFrom(input[:detect_format_peek])
+	suite.NoError(err)
+	suite.IsType(new(JsonMarshaller), um)
+
+	rdr := bufio.NewReader(strings.NewReader(input))
+	var headers []*UnmarshalledHeader
+	var samples []*Sample
+	var header *UnmarshalledHeader
+	for {
+		newHeader, data, err := um.Read(rdr, header)
+		if err == io.EOF {
+			break
+		}
+		suite.NoError(err)
+		if newHeader != nil {
+			header = newHeader
+			headers = append(headers, header)
+		} else {
+			sample, err := um.ParseSample(header, 0, data)
+			suite.NoError(err)
+			samples = append(samples, sample)
+		}
+	}
+
+	suite.Len(headers, 2)
+	suite.Equal([]string{"a", "b"}, headers[0].Fields)
+	suite.False(headers[0].HasTags)
+	suite.Equal([]string{"a", "b", "c"}, headers[1].Fields)
+	suite.True(headers[1].HasTags)
+
+	suite.Len(samples, 4)
+	suite.Equal([]Value{1, 2}, samples[0].Values)
+	suite.Equal([]Value{0, 3}, samples[1].Values)
+	suite.Equal([]Value{5, 0, 4}, samples[2].Values)
+	suite.Equal(map[string]string{"x": "y"}, samples[2].TagMap())
+	suite.True(math.IsNaN(float64(samples[3].Values[0])))
+	suite.Empty(samples[3].TagMap())
+	suite.True(time.Date(2019, 1, 1, 10, 0, 2, 0, time.UTC).Equal(samples[2].Time))
+}
+
+func (suite *MarshallerTestSuite) TestBinaryTagDictionaryMulti() {
+	m := BinaryMarshaller{TagDictionary: 100}.NewStreamMarshaller().(BinaryMarshaller)
+	var buf bytes.Buffer
+	for i, header := range suite.headers {
+		suite.write(m, &buf, header, suite.samples[i])
+	}
+
+	// The tag dictionary spans header changes, so the previous header must be passed when reading a new one
+	rdr := bufio.NewReader(&buf)
+	var previousHeader *UnmarshalledHeader
+	for i, expectedHeader := range suite.headers {
+		header, data, err := m.Read(rdr, previousHeader)
+		suite.NoError(err)
+		suite.Nil(data)
+		suite.compareUnmarshalledHeaders(expectedHeader, header)
+		for _, expectedSample := range suite.samples[i] {
+			_, data, err := m.Read(rdr, header)
+			suite.NoError(err)
+			capacity := len(expectedSample.Values)
+			sample, err := m.ParseSample(header, capacity, data)
+			suite.NoError(err)
+			suite.compareSamples(expectedSample, sample, capacity)
+		}
+		previousHeader = header
+	}
+	_, err := rdr.ReadByte()
+	suite.Error(err)
+}
+
+var repeatingTagSets = []string{"host=a service=web", "host=b service=web", "host=a service=db"}
+
+func (suite *MarshallerTestSuite) writeRepeatingTags(m Marshaller, header *Header, numSamples int) []byte {
+	var buf bytes.Buffer
+	suite.NoError(m.WriteHeader(header, true, &buf))
+	for i := 0; i < numSamples; i++ {
+		sample := &Sample{Values: []Value{Value(i), Value(-i)}, Time: time.Unix(int64(i), 0)}
+		suite.NoError(sample.ParseTagString(repeatingTagSets[i%len(repeatingTagSets)]))
+		if i == numSamples-1 {
+			sample.SetTag("novel", "x")
+		}
+		suite.NoError(m.WriteSample(sample, header, true, &buf))
+	}
+	return buf.Bytes()
+}
+
+func (suite *MarshallerTestSuite) checkRepeatingTags(data []byte, numSamples int) {
+	var m BinaryMarshaller
+	rdr := bufio.NewReader(bytes.NewReader(data))
+	header, _, err := m.Read(rdr, nil)
+	suite.NoError(err)
+	suite.True(header.HasTags)
+	for i := 0; i < numSamples; i++ {
+		newHeader, data, err := m.Read(rdr, header)
+		suite.NoError(err)
+		suite.Nil(newHeader)
+		sample, err := m.ParseSample(header, 0, data)
+		suite.NoError(err)
+		suite.Equal([]Value{Value(i), Value(-i)}, sample.Values)
+		suite.Equal(int64(i), sample.Time.Unix())
+		expected := new(Sample)
+		suite.NoError(expected.ParseTagString(repeatingTagSets[i%len(repeatingTagSets)]))
+		if i == numSamples-1 {
+			expected.SetTag("novel", "x")
+		}
+		suite.Equal(expected.TagMap(), sample.TagMap(), "sample %v", i)
+	}
+	_, _, err = m.Read(rdr, header)
+	suite.Equal(io.EOF, err)
+}
+
+func (suite *MarshallerTestSuite) TestBinaryTagDictionary() {
+	header := &Header{Fields: []string{"a", "b"}}
+	numSamples := 30
+	inlineData := suite.writeRepeatingTags(BinaryMarshaller{}, header, numSamples)
+	dictData := suite.writeRepeatingTags(BinaryMarshaller{TagDictionary: 100}.NewStreamMarshaller(), header, numSamples)
+	suite.True(len(dictData) < len(inlineData)*3/4, "tag dictionary should reduce the size (%v vs %v bytes)", len(dictData), len(inlineData))
+
+	suite.checkRepeatingTags(inlineData, numSamples)
+	suite.checkRepeatingTags(dictData, numSamples)
+
+	// A full dictionary falls back to inline tags for new tag sets
+	limitedData := suite.writeRepeatingTags(BinaryMarshaller{TagDictionary: 2}.NewStreamMarshaller(), header, numSamples)
+	suite.True(len(limitedData) > len(dictData))
+	suite.checkRepeatingTags(limitedData, numSamples)
+
+	// The dictionary must be disabled by default
+	suite.Nil(BinaryMarshaller{}.NewStreamMarshaller())
+}
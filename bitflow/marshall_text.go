@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 
 	"github.com/antongulenko/golib"
 )
@@ -20,6 +21,14 @@ const (
 	// TextMarshallerHeaderChar is used as fill-character in the header line
 	// preceding each sample marshalled by TextMarshaller.
 	TextMarshallerHeaderChar = '='
+
+	// TextMarshallerDefaultPrecision is the default number of decimal places
+	// of the values printed by TextMarshaller.
+	TextMarshallerDefaultPrecision = 4
+
+	// TextMarshallerEllipsis replaces the end of metric names that are
+	// truncated because of TextMarshaller.MaxWidth.
+	TextMarshallerEllipsis = "..."
 )
 
 // TextMarshaller marshals Headers and Samples to a human readable test format.
@@ -28,6 +37,9 @@ const (
 // the timestamp and tags. Afterwards, all values are printed in a aligned table
 // in a key = value format. The width of the header line, the number of columns
 // in the table, and the spacing between the columns in the table can be configured.
+// The metric names are padded to the longest name in the header, and the values
+// are padded to the widest value printed so far for the current header, so that
+// the values of consecutive samples line up vertically.
 type TextMarshaller struct {
 
 	// TextWidths sets the width of the header line and value table.
@@ -50,6 +62,29 @@ type TextMarshaller struct {
 	// If true, assume the output is a TTY and try to obtain the TextWidth from
 	// the operating system.
 	AssumeStdout bool
+
+	// MaxWidth can be set to > 0 to limit the length of the printed metric names.
+	// Longer names are truncated and end with TextMarshallerEllipsis.
+	MaxWidth int
+
+	// Precision sets the number of decimal places of the printed values. If <= 0, the
+	// default value TextMarshallerDefaultPrecision will be used.
+	Precision int
+
+	widths *textValueWidths
+}
+
+// textValueWidths stores the widest value printed for the current header of one output stream.
+type textValueWidths struct {
+	header *Header
+	width  int
+}
+
+// NewStreamMarshaller implements the StatefulMarshaller interface. The returned copy
+// pads the values to the widest value printed so far within the current header.
+func (m TextMarshaller) NewStreamMarshaller() Marshaller {
+	m.widths = new(textValueWidths)
+	return m
 }
 
 // String implements the Marshaller interface.
@@ -70,11 +105,7 @@ func (m TextMarshaller) WriteSample(sample *Sample, header *Header, withTags boo
 	if withTags {
 		headerStr = fmt.Sprintf("%s (%s)", headerStr, sample.TagString())
 	}
-	lines := make([]string, 0, len(sample.Values))
-	for i, value := range sample.Values {
-		line := fmt.Sprintf("%s = %.4f", header.Fields[i], value)
-		lines = append(lines, line)
-	}
+	lines := m.formatLines(sample, header)
 
 	textWidth, columnWidths := m.calculateWidths(lines, writer)
 	if err := m.writeHeader(headerStr, textWidth, writer); err != nil {
@@ -83,6 +114,52 @@ func (m TextMarshaller) WriteSample(sample *Sample, header *Header, withTags boo
 	return m.writeLines(lines, columnWidths, writer)
 }
 
+func (m TextMarshaller) formatLines(sample *Sample, header *Header) []string {
+	precision := m.Precision
+	if precision <= 0 {
+		precision = TextMarshallerDefaultPrecision
+	}
+	names := make([]string, len(sample.Values))
+	values := make([]string, len(sample.Values))
+	nameWidth, valueWidth := 0, 0
+	for i, value := range sample.Values {
+		names[i] = m.truncateName(header.Fields[i])
+		values[i] = strconv.FormatFloat(float64(value), 'f', precision, 64)
+		if len(names[i]) > nameWidth {
+			nameWidth = len(names[i])
+		}
+		if len(values[i]) > valueWidth {
+			valueWidth = len(values[i])
+		}
+	}
+	if widths := m.widths; widths != nil {
+		if !widths.header.Equals(header) {
+			widths.header = header
+			widths.width = 0
+		}
+		if valueWidth > widths.width {
+			widths.width = valueWidth
+		}
+		valueWidth = widths.width
+	}
+
+	lines := make([]string, len(sample.Values))
+	for i := range sample.Values {
+		lines[i] = fmt.Sprintf("%-*s = %*s", nameWidth, names[i], valueWidth, values[i])
+	}
+	return lines
+}
+
+func (m TextMarshaller) truncateName(name string) string {
+	if m.MaxWidth <= 0 || len(name) <= m.MaxWidth {
+		return name
+	}
+	if m.MaxWidth <= len(TextMarshallerEllipsis) {
+		return name[:m.MaxWidth]
+	}
+	return name[:m.MaxWidth-len(TextMarshallerEllipsis)] + TextMarshallerEllipsis
+}
+
 func (m TextMarshaller) calculateWidths(lines []string, writer io.Writer) (textWidth int, columnWidths []int) {
 	spacing := m.Spacing
 	if spacing <= 0 {
@@ -0,0 +1,58 @@
+package bitflow
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Version is the version of the bitflow library that is recorded in the output metadata.
+// It can be overwritten at build time with the linker flag -X github.com/bitflow-stream/go-bitflow/bitflow.Version=<version>.
+var Version = "dev"
+
+const (
+	// OutputMetadataTagPrefix is prepended to all keys of the output metadata, when
+	// storing them as tags in the first sample of an output stream.
+	OutputMetadataTagPrefix = "bitflow-"
+
+	VersionMetadata  = "version"
+	PipelineMetadata = "pipeline"
+	StartMetadata    = "start"
+)
+
+// NewOutputMetadata returns the default provenance metadata for output streams: the library version,
+// the current time and, if non-empty, the given pipeline description.
+func NewOutputMetadata(pipeline string) map[string]string {
+	metadata := map[string]string{
+		VersionMetadata: Version,
+		StartMetadata:   time.Now().Format(time.RFC3339Nano),
+	}
+	if pipeline != "" {
+		metadata[PipelineMetadata] = pipeline
+	}
+	return metadata
+}
+
+// AddOutputMetadata stores the given metadata as tags in the given sample. The tag keys receive the
+// OutputMetadataTagPrefix and the values are URL-encoded, so they survive the tag encoding of all marshallers.
+func AddOutputMetadata(sample *Sample, metadata map[string]string) {
+	for key, value := range metadata {
+		sample.SetTag(OutputMetadataTagPrefix+key, url.QueryEscape(value))
+	}
+}
+
+// GetOutputMetadata extracts the metadata stored by AddOutputMetadata. The result is empty, if the sample
+// does not contain any metadata.
+func GetOutputMetadata(sample *Sample) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for key, value := range sample.TagMap() {
+		if strings.HasPrefix(key, OutputMetadataTagPrefix) {
+			decoded, err := url.QueryUnescape(value)
+			if err != nil {
+				return nil, err
+			}
+			metadata[key[len(OutputMetadataTagPrefix):]] = decoded
+		}
+	}
+	return metadata, nil
+}
@@ -31,6 +31,14 @@ var (
 		string(CsvNewline), tag_replacement)
 )
 
+const (
+	// FieldMetadataUnit is the metadata attribute containing the unit of a field, e.g. bytes or percent. See Header.SetFieldUnit.
+	FieldMetadataUnit = "unit"
+
+	// FieldMetadataDescription is the metadata attribute containing a textual description of a field. See Header.SetFieldDescription.
+	FieldMetadataDescription = "description"
+)
+
 // Value is a type alias for float64 and defines the type for metric values.
 type Value float64
 
@@ -45,16 +53,108 @@ func (v Value) String() string {
 type Header struct {
 	// Fields defines the names of the metrics of samples belonging to this header.
 	Fields []string
+
+	// FieldIds optionally assigns a stable identity to every field, which is preserved when fields are
+	// reordered, renamed or removed. If set, it has the same length as Fields, and FieldIds[i] is the index
+	// of Fields[i] in OriginalFields. This "schema-stable" mode is enabled by the stable_schema step and
+	// allows restoring the original order and names of the fields later in the pipeline.
+	// The field ids are not marshalled, so they are only valid within one pipeline.
+	FieldIds []int
+
+	// OriginalFields contains the field names at the time the FieldIds were assigned. It is shared between
+	// all headers derived from the same header and must not be modified.
+	OriginalFields []string
+
+	// FieldMetadata optionally contains attributes describing the fields, like the unit or the type of a metric.
+	// If set, it has the same length as Fields, and FieldMetadata[i] contains the attributes of Fields[i] (or nil).
+	// Like the field ids, the metadata is preserved by CloneMapped, but not by Clone. The maps are shared between
+	// all headers derived from the same header and must not be modified. Use SetFieldMetadata to change the metadata.
+	FieldMetadata []map[string]string
 }
 
 // Clone creates a copy of the Header receiver, using a new string-array as
-// the header fields.
+// the header fields. Since the new fields cannot be associated with the old ones,
+// the field ids are not copied. Use CloneMapped to preserve them.
 func (h *Header) Clone(newFields []string) *Header {
 	return &Header{
 		Fields: newFields,
 	}
 }
 
+// CloneMapped creates a copy of the Header receiver, using a new string-array as the header fields,
+// where newFields[i] is derived from the field at index indices[i] of the receiver. If the receiver has
+// field ids, they are carried over to the new fields accordingly. The indices must have the same length as newFields.
+func (h *Header) CloneMapped(newFields []string, indices []int) *Header {
+	res := h.Clone(newFields)
+	if h.HasFieldIds() {
+		res.OriginalFields = h.OriginalFields
+		res.FieldIds = make([]int, len(indices))
+		for i, index := range indices {
+			res.FieldIds[i] = h.FieldIds[index]
+		}
+	}
+	if h.HasFieldMetadata() {
+		res.FieldMetadata = make([]map[string]string, len(indices))
+		for i, index := range indices {
+			res.FieldMetadata[i] = h.FieldMetadata[index]
+		}
+	}
+	return res
+}
+
+// HasFieldMetadata returns true, if the header contains metadata attributes for its fields (see FieldMetadata).
+func (h *Header) HasFieldMetadata() bool {
+	return h.FieldMetadata != nil && len(h.FieldMetadata) == len(h.Fields)
+}
+
+// GetFieldMetadata returns the metadata attribute with the given key for the field at the given index.
+// The second return value is false, if the attribute is not set.
+func (h *Header) GetFieldMetadata(index int, key string) (string, bool) {
+	if !h.HasFieldMetadata() || index < 0 || index >= len(h.Fields) {
+		return "", false
+	}
+	value, ok := h.FieldMetadata[index][key]
+	return value, ok
+}
+
+// SetFieldMetadata sets a metadata attribute of all fields with the given name, and returns false if the header
+// does not contain the field. The attributes of the field are copied before modifying them, but the header itself is modified,
+// so this should only be done before the header is passed to other steps.
+func (h *Header) SetFieldMetadata(field, key, value string) bool {
+	found := false
+	for i, name := range h.Fields {
+		if name != field {
+			continue
+		}
+		if !h.HasFieldMetadata() {
+			h.FieldMetadata = make([]map[string]string, len(h.Fields))
+		}
+		attributes := make(map[string]string, len(h.FieldMetadata[i])+1)
+		for k, v := range h.FieldMetadata[i] {
+			attributes[k] = v
+		}
+		attributes[key] = value
+		h.FieldMetadata[i] = attributes
+		found = true
+	}
+	return found
+}
+
+// SetFieldUnit sets the FieldMetadataUnit attribute of all fields with the given name, see SetFieldMetadata.
+func (h *Header) SetFieldUnit(field, unit string) bool {
+	return h.SetFieldMetadata(field, FieldMetadataUnit, unit)
+}
+
+// SetFieldDescription sets the FieldMetadataDescription attribute of all fields with the given name, see SetFieldMetadata.
+func (h *Header) SetFieldDescription(field, description string) bool {
+	return h.SetFieldMetadata(field, FieldMetadataDescription, description)
+}
+
+// HasFieldIds returns true, if the header assigns a stable identity to each field (see FieldIds).
+func (h *Header) HasFieldIds() bool {
+	return h.FieldIds != nil && len(h.FieldIds) == len(h.Fields)
+}
+
 // String returns a human-readable string-representation of the header, including
 // all meta-data and field names.
 func (h *Header) String() string {
@@ -195,7 +295,11 @@ func (sample *Sample) NumTags() (l int) {
 //
 // Example:
 //   tag1=value1 tag2=value2
-func (sample *Sample) TagString() (res string) {
+func (sample *Sample) TagString() string {
+	return sample.tagString(TagStringEscaper)
+}
+
+func (sample *Sample) tagString(escaper *strings.Replacer) (res string) {
 	sample.lockRead(func() {
 		var b bytes.Buffer
 		started := false
@@ -204,9 +308,9 @@ func (sample *Sample) TagString() (res string) {
 			if started {
 				b.Write([]byte(tag_separator))
 			}
-			b.Write([]byte(escapeTagString(key)))
+			b.Write([]byte(escaper.Replace(key)))
 			b.Write([]byte(tag_equals))
-			b.Write([]byte(escapeTagString(value)))
+			b.Write([]byte(escaper.Replace(value)))
 			started = true
 		}
 		res = b.String()
@@ -214,10 +318,6 @@ func (sample *Sample) TagString() (res string) {
 	return
 }
 
-func escapeTagString(str string) string {
-	return TagStringEscaper.Replace(str)
-}
-
 func EncodeTags(tags map[string]string) string {
 	var s Sample
 	for key, value := range tags {
@@ -342,7 +442,37 @@ func (h *Header) Equals(other *Header) bool {
 	case h == nil || other == nil:
 		return false
 	}
-	return golib.EqualStrings(h.Fields, other.Fields)
+	return golib.EqualStrings(h.Fields, other.Fields) && equalFieldIds(h, other) && equalFieldMetadata(h, other)
+}
+
+func equalFieldMetadata(h, other *Header) bool {
+	if !h.HasFieldMetadata() || !other.HasFieldMetadata() {
+		return h.HasFieldMetadata() == other.HasFieldMetadata()
+	}
+	for i, attributes := range h.FieldMetadata {
+		otherAttributes := other.FieldMetadata[i]
+		if len(attributes) != len(otherAttributes) {
+			return false
+		}
+		for key, value := range attributes {
+			if otherValue, ok := otherAttributes[key]; !ok || otherValue != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func equalFieldIds(h, other *Header) bool {
+	if len(h.FieldIds) != len(other.FieldIds) {
+		return false
+	}
+	for i, id := range h.FieldIds {
+		if id != other.FieldIds[i] {
+			return false
+		}
+	}
+	return len(h.FieldIds) == 0 || golib.EqualStrings(h.OriginalFields, other.OriginalFields)
 }
 
 // SampleMetadata is a helper type containing the timestamp and the tags of a Sample.
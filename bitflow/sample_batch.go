@@ -17,17 +17,73 @@ type BatchProcessor struct {
 
 	Steps []BatchProcessingStep
 
+	// ProcessEmptyBatches can be set to true to keep executing steps after a step returned an empty batch, as long as
+	// the remaining steps implement EmptyBatchProcessingStep and accept empty batches. By default, the remaining steps are skipped.
+	ProcessEmptyBatches bool
+
 	FlushTimeout                time.Duration // If > 0, flush when no new samples are received for the given duration. The wall-time is used for this (not sample timestamps)
 	SampleTimestampFlushTimeout time.Duration // If > 0, flush when a sample is received with a timestamp jump bigger than this
 	lastAutoFlushError          error
 	lastSample                  time.Time // Wall time when receiving last sample
 	lastSampleTimestamp         time.Time // Timestamp of last sample
 
+	// FlushSampleCount can be set to flush every time the number of buffered samples reaches the given value, regardless
+	// of the header. It can be combined with the other flush triggers, the first trigger that fires flushes the batch.
+	FlushSampleCount int
+
+	// WindowOverlap can be set to process overlapping (sliding) windows of samples. After flushing a batch, the last
+	// WindowOverlap samples are retained as the start of the next batch, instead of being discarded. Combined with
+	// FlushSampleCount, the window advances by FlushSampleCount - WindowOverlap samples. The retained samples are copied
+	// before executing the batch steps, so modifications by the steps do not affect the next batch.
+	// Only the flushes triggered by FlushSampleCount retain samples. Batches that are flushed due to a header change, a tag change,
+	// a timestamp jump, after FlushTimeout, or when closing, do not retain any samples.
+	WindowOverlap int
+
 	FlushTags     []string // If set, flush every time any of these tags change
 	lastFlushTags []string
 	flushHeader   *Header
 	flushTrigger  *golib.TimeoutCond // Used to trigger flush and to notify about finished flush. Relies on Sample()/Close() being synchronized externally.
 	flushError    error
+
+	// WorkerPool can be set to execute the flushes triggered by incoming samples asynchronously, so that the
+	// sample-ingesting goroutine is not blocked. The batches of one BatchProcessor are still flushed in order.
+	// Flushes on Close() and after FlushTimeout wait for all pending asynchronous flushes. Errors of asynchronous
+	// flushes are returned when the next sample arrives. Defaults to DefaultBatchWorkerPool.
+	WorkerPool      *BatchWorkerPool
+	lastAsyncFlush  chan struct{}
+	asyncFlushError error
+	asyncLock       sync.Mutex
+}
+
+// DefaultBatchWorkerPool is used by all BatchProcessors that do not define their own WorkerPool.
+// If it is nil, batches are flushed synchronously.
+var DefaultBatchWorkerPool *BatchWorkerPool
+
+// BatchWorkerPool limits the number of batches that are processed concurrently by BatchProcessors.
+type BatchWorkerPool struct {
+	slots chan struct{}
+}
+
+// NewBatchWorkerPool creates a BatchWorkerPool that executes at most the given number of flushes in parallel.
+func NewBatchWorkerPool(size int) *BatchWorkerPool {
+	if size < 1 {
+		size = 1
+	}
+	return &BatchWorkerPool{slots: make(chan struct{}, size)}
+}
+
+// Run executes the given task, after waiting for a free slot in the pool.
+func (pool *BatchWorkerPool) Run(task func()) {
+	pool.slots <- struct{}{}
+	defer func() {
+		<-pool.slots
+	}()
+	task()
+}
+
+// Size returns the maximum number of parallel tasks.
+func (pool *BatchWorkerPool) Size() int {
+	return cap(pool.slots)
 }
 
 type BatchProcessingStep interface {
@@ -40,6 +96,15 @@ type ResizingBatchProcessingStep interface {
 	OutputSampleSize(sampleSize int) int
 }
 
+// EmptyBatchProcessingStep can be implemented by steps that can process batches without any samples, e.g. to emit a
+// placeholder sample. Empty batches are only passed to such steps if ProcessEmptyBatches is set in the BatchProcessor
+// and AcceptsEmptyBatches() returns true. Of the built-in steps, the sample sorter, the sample shuffler and the
+// time bucket aggregator accept empty batches (and return them unchanged).
+type EmptyBatchProcessingStep interface {
+	BatchProcessingStep
+	AcceptsEmptyBatches() bool
+}
+
 func (p *BatchProcessor) OutputSampleSize(sampleSize int) int {
 	for _, step := range p.Steps {
 		if step, ok := step.(ResizingBatchProcessingStep); ok {
@@ -64,14 +129,21 @@ func (p *BatchProcessor) ContainedStringers() []fmt.Stringer {
 
 func (p *BatchProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
 	p.flushTrigger = golib.NewTimeoutCond(new(sync.Mutex))
+	if p.WorkerPool == nil {
+		p.WorkerPool = DefaultBatchWorkerPool
+	}
 	wg.Add(1)
 	go p.loopFlush(wg)
 	return p.NoopProcessor.Start(wg)
 }
 
 func (p *BatchProcessor) Sample(sample *Sample, header *Header) (err error) {
+	// The flush routine reads the last header concurrently when flushing after FlushTimeout
+	p.flushTrigger.L.Lock()
 	oldHeader := p.checker.LastHeader
-	flush := p.checker.InitializedHeaderChanged(header)
+	headerChanged := p.checker.InitializedHeaderChanged(header)
+	p.flushTrigger.L.Unlock()
+	flush := headerChanged
 	if len(p.FlushTags) > 0 {
 		values := make([]string, len(p.FlushTags))
 		for i, tag := range p.FlushTags {
@@ -89,30 +161,136 @@ func (p *BatchProcessor) Sample(sample *Sample, header *Header) (err error) {
 		p.lastSampleTimestamp = sample.Time
 	}
 	if flush {
-		err = p.triggerFlush(oldHeader, false)
+		err = p.flush(oldHeader, false)
+	}
+	if err == nil {
+		err = p.takeAsyncFlushError()
 	}
 	if p.FlushTimeout > 0 {
+		p.flushTrigger.L.Lock()
 		p.lastSample = time.Now()
 		if err == nil {
 			err = p.lastAutoFlushError
 		}
 		p.lastAutoFlushError = nil
+		p.flushTrigger.L.Unlock()
 	}
+
+	// The flush routine can access the samples concurrently when flushing after FlushTimeout
+	p.flushTrigger.L.Lock()
 	p.samples = append(p.samples, sample)
+	countReached := p.FlushSampleCount > 0 && len(p.samples) >= p.FlushSampleCount
+	p.flushTrigger.L.Unlock()
+	if countReached && err == nil {
+		err = p.flush(header, true)
+	}
+	return
+}
+
+// flush is called while processing an incoming sample. If retain is set, the samples defined by WindowOverlap
+// are kept as the start of the next batch.
+func (p *BatchProcessor) flush(header *Header, retain bool) (err error) {
+	var retained []*Sample
+	if retain {
+		retained = p.overlappingSamples()
+	}
+	if p.WorkerPool != nil {
+		p.flushAsync(header)
+	} else {
+		err = p.triggerFlush(header, false)
+	}
+	if len(retained) > 0 {
+		p.flushTrigger.L.Lock()
+		p.samples = append(retained, p.samples...)
+		p.flushTrigger.L.Unlock()
+	}
 	return
 }
 
+// overlappingSamples returns copies of the last WindowOverlap buffered samples
+func (p *BatchProcessor) overlappingSamples() []*Sample {
+	p.flushTrigger.L.Lock()
+	defer p.flushTrigger.L.Unlock()
+	num := p.WindowOverlap
+	if num > len(p.samples) {
+		num = len(p.samples)
+	}
+	if num <= 0 {
+		return nil
+	}
+	res := make([]*Sample, num)
+	for i, sample := range p.samples[len(p.samples)-num:] {
+		res[i] = sample.DeepClone()
+	}
+	return res
+}
+
 func (p *BatchProcessor) Close() {
 	defer p.NoopProcessor.Close()
 	header := p.checker.LastHeader
 	if header == nil {
 		log.Warnln(p.String(), "received no samples")
 	}
+	p.waitForAsyncFlushes()
+	if err := p.takeAsyncFlushError(); err != nil {
+		p.Error(err)
+	}
 	if err := p.triggerFlush(header, true); err != nil {
 		p.Error(err)
 	}
 }
 
+// flushAsync hands the currently batched samples to the WorkerPool. The flush waits for the previous asynchronous flush
+// of this BatchProcessor to finish, so the order of the batches is preserved. The waiting does not occupy a slot in the pool.
+func (p *BatchProcessor) flushAsync(header *Header) {
+	// The flush routine accesses the samples and the last asynchronous flush concurrently when flushing after FlushTimeout
+	p.flushTrigger.L.Lock()
+	samples := p.samples
+	p.samples = nil
+	previous := p.lastAsyncFlush
+	done := make(chan struct{})
+	p.lastAsyncFlush = done
+	p.flushTrigger.L.Unlock()
+	go func() {
+		defer close(done)
+		if previous != nil {
+			<-previous
+		}
+		p.WorkerPool.Run(func() {
+			if err := p.flushSamples(samples, header); err != nil {
+				log.Errorf("%v: Error during asynchronous flush (will be returned when next sample arrives): %v", p, err)
+				p.asyncLock.Lock()
+				p.asyncFlushError = fmt.Errorf("Error during previous asynchronous flush: %v", err)
+				p.asyncLock.Unlock()
+			}
+		})
+	}()
+}
+
+func (p *BatchProcessor) waitForAsyncFlushes() {
+	p.flushTrigger.L.Lock()
+	last := p.lastAsyncFlush
+	p.flushTrigger.L.Unlock()
+	if last != nil {
+		<-last
+	}
+}
+
+// waitForAsyncFlushesLocked must be called while holding flushTrigger.L. The asynchronous flushes do not acquire the lock.
+func (p *BatchProcessor) waitForAsyncFlushesLocked() {
+	if last := p.lastAsyncFlush; last != nil {
+		<-last
+	}
+}
+
+func (p *BatchProcessor) takeAsyncFlushError() error {
+	p.asyncLock.Lock()
+	defer p.asyncLock.Unlock()
+	err := p.asyncFlushError
+	p.asyncFlushError = nil
+	return err
+}
+
 func (p *BatchProcessor) triggerFlush(header *Header, shutdown bool) error {
 	p.flushTrigger.L.Lock()
 	defer p.flushTrigger.L.Unlock()
@@ -145,6 +323,7 @@ func (p *BatchProcessor) waitAndExecuteFlush() bool {
 	}
 	if p.flushHeader == nil && !p.shutdown {
 		// Automatic flush after timeout
+		p.waitForAsyncFlushesLocked()
 		err := p.executeFlush(p.checker.LastHeader)
 		if err != nil {
 			log.Errorf("%v: Error during automatic flush (will be returned when next sample arrives): %v", p, err)
@@ -172,6 +351,13 @@ func (p *BatchProcessor) executeFlush(header *Header) error {
 		return nil
 	}
 	p.samples = nil // Allow garbage collection
+	return p.flushSamples(samples, header)
+}
+
+func (p *BatchProcessor) flushSamples(samples []*Sample, header *Header) error {
+	if len(samples) == 0 || header == nil {
+		return nil
+	}
 	if samples, header, err := p.executeSteps(samples, header); err != nil {
 		return err
 	} else {
@@ -194,7 +380,7 @@ func (p *BatchProcessor) executeSteps(samples []*Sample, header *Header) ([]*Sam
 	if len(p.Steps) > 0 {
 		log.Debugln("Executing", len(p.Steps), "batch processing step(s)")
 		for i, step := range p.Steps {
-			if len(samples) == 0 {
+			if len(samples) == 0 && !p.acceptsEmptyBatch(step) {
 				log.Warnln("Cannot execute remaining", len(p.Steps)-i, "batch step(s) because the batch with", len(header.Fields), "has no samples")
 				break
 			} else {
@@ -204,12 +390,23 @@ func (p *BatchProcessor) executeSteps(samples []*Sample, header *Header) ([]*Sam
 				if err != nil {
 					return nil, nil, err
 				}
+				if header == nil {
+					return nil, nil, fmt.Errorf("Batch processing step %v returned nil-header", step)
+				}
 			}
 		}
 	}
 	return samples, header, nil
 }
 
+func (p *BatchProcessor) acceptsEmptyBatch(step BatchProcessingStep) bool {
+	if !p.ProcessEmptyBatches {
+		return false
+	}
+	emptyStep, ok := step.(EmptyBatchProcessingStep)
+	return ok && emptyStep.AcceptsEmptyBatches()
+}
+
 func (p *BatchProcessor) String() string {
 	extra := "s"
 	if len(p.Steps) == 1 {
@@ -225,6 +422,18 @@ func (p *BatchProcessor) String() string {
 	if p.SampleTimestampFlushTimeout > 0 {
 		flushed += fmt.Sprintf(", flushed when sample timestamp difference over %v", p.SampleTimestampFlushTimeout)
 	}
+	if p.FlushSampleCount > 0 {
+		flushed += fmt.Sprintf(", flushed every %v samples", p.FlushSampleCount)
+	}
+	if p.WindowOverlap > 0 {
+		flushed += fmt.Sprintf(", windows overlapping by %v samples", p.WindowOverlap)
+	}
+	if p.ProcessEmptyBatches {
+		flushed += ", processing empty batches"
+	}
+	if p.WorkerPool != nil {
+		flushed += fmt.Sprintf(", flushed asynchronously with %v workers", p.WorkerPool.Size())
+	}
 	return fmt.Sprintf("BatchProcessor (%v step%s%s)", len(p.Steps), extra, flushed)
 }
 
@@ -239,7 +448,10 @@ func (p *BatchProcessor) MergeProcessor(other SampleProcessor) bool {
 
 func (p *BatchProcessor) compatibleParameters(other *BatchProcessor) bool {
 	if (other.FlushTimeout != 0 && other.FlushTimeout != p.FlushTimeout) ||
-		(other.SampleTimestampFlushTimeout != 0 && other.SampleTimestampFlushTimeout != p.SampleTimestampFlushTimeout) {
+		(other.SampleTimestampFlushTimeout != 0 && other.SampleTimestampFlushTimeout != p.SampleTimestampFlushTimeout) ||
+		(other.FlushSampleCount != 0 && other.FlushSampleCount != p.FlushSampleCount) ||
+		(other.WindowOverlap != 0 && other.WindowOverlap != p.WindowOverlap) ||
+		(other.ProcessEmptyBatches && !p.ProcessEmptyBatches) {
 		return false
 	}
 	if len(other.FlushTags) == 0 {
@@ -254,6 +466,7 @@ type SimpleBatchProcessingStep struct {
 	Description          string
 	Process              func(header *Header, samples []*Sample) (*Header, []*Sample, error)
 	OutputSampleSizeFunc func(sampleSize int) int
+	EmptyBatches         bool // Return value of AcceptsEmptyBatches()
 }
 
 func (s *SimpleBatchProcessingStep) ProcessBatch(header *Header, samples []*Sample) (*Header, []*Sample, error) {
@@ -278,3 +491,7 @@ func (s *SimpleBatchProcessingStep) OutputSampleSize(sampleSize int) int {
 	}
 	return sampleSize
 }
+
+func (s *SimpleBatchProcessingStep) AcceptsEmptyBatches() bool {
+	return s.EmptyBatches
+}
@@ -0,0 +1,372 @@
+package bitflow
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+// concurrencyTrackingStep records how many batches are processed at the same time. The first expected batches
+// wait for each other, so they only finish quickly when they are executed in parallel.
+type concurrencyTrackingStep struct {
+	lock       sync.Mutex
+	running    int
+	maxRunning int
+	started    int
+	expected   int
+	allStarted chan struct{}
+}
+
+func (s *concurrencyTrackingStep) ProcessBatch(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+	s.lock.Lock()
+	s.running++
+	s.started++
+	if s.running > s.maxRunning {
+		s.maxRunning = s.running
+	}
+	if s.started == s.expected {
+		close(s.allStarted)
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-s.allStarted:
+	case <-time.After(time.Second):
+	}
+
+	s.lock.Lock()
+	s.running--
+	s.lock.Unlock()
+	for _, sample := range samples {
+		sample.SetTag("processed", "true")
+	}
+	return header, samples, nil
+}
+
+func (s *concurrencyTrackingStep) String() string {
+	return "concurrency tracking step"
+}
+
+type batchOutputCollector struct {
+	DroppingSampleProcessor
+	samples []*Sample
+}
+
+func (c *batchOutputCollector) Sample(sample *Sample, _ *Header) error {
+	c.samples = append(c.samples, sample)
+	return nil
+}
+
+func TestBatchProcessorParallelFlush(t *testing.T) {
+	assert := testAssert.New(t)
+	const branches = 3
+	step := &concurrencyTrackingStep{expected: branches, allStarted: make(chan struct{})}
+	pool := NewBatchWorkerPool(branches)
+
+	var wg sync.WaitGroup
+	processors := make([]*BatchProcessor, branches)
+	outputs := make([]*batchOutputCollector, branches)
+	for i := range processors {
+		processors[i] = &BatchProcessor{
+			Steps:      []BatchProcessingStep{step},
+			FlushTags:  []string{"batch"},
+			WorkerPool: pool,
+		}
+		outputs[i] = new(batchOutputCollector)
+		processors[i].SetSink(outputs[i])
+		processors[i].Start(&wg)
+	}
+
+	// Every branch receives two batches. The flush of the first batch is triggered by the first sample of the second batch.
+	header := &Header{Fields: []string{"val"}}
+	start := time.Now()
+	for batch := 0; batch < 2; batch++ {
+		for i, proc := range processors {
+			for j := 0; j < 3; j++ {
+				sample := &Sample{Values: []Value{Value(i*100 + batch*10 + j)}}
+				sample.SetTag("batch", strconv.Itoa(batch))
+				assert.NoError(proc.Sample(sample, header))
+			}
+		}
+	}
+	for _, proc := range processors {
+		proc.Close()
+	}
+	wg.Wait()
+
+	assert.True(time.Since(start) < time.Second, "the first batches of all branches should be processed in parallel")
+	assert.Equal(branches, step.maxRunning)
+	for i, out := range outputs {
+		assert.Len(out.samples, 6)
+		for k, sample := range out.samples {
+			batch, j := k/3, k%3
+			assert.Equal(Value(i*100+batch*10+j), sample.Values[0], "branch %v, sample %v", i, k)
+			assert.Equal("true", sample.Tag("processed"))
+		}
+	}
+}
+
+func TestBatchWorkerPoolLimit(t *testing.T) {
+	assert := testAssert.New(t)
+	pool := NewBatchWorkerPool(2)
+	assert.Equal(2, pool.Size())
+
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool.Run(func() {
+				lock.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				lock.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				lock.Lock()
+				running--
+				lock.Unlock()
+			})
+		}()
+	}
+	wg.Wait()
+	assert.Equal(2, maxRunning)
+}
+
+// TestBatchProcessorAsyncFlushTimeout interleaves asynchronous flushes with flushes after FlushTimeout.
+// It is mainly useful when running with the race detector.
+func TestBatchProcessorAsyncFlushTimeout(t *testing.T) {
+	assert := testAssert.New(t)
+	proc := &BatchProcessor{
+		FlushTimeout: 20 * time.Millisecond,
+		FlushTags:    []string{"batch"},
+		WorkerPool:   NewBatchWorkerPool(2),
+	}
+	out := new(batchOutputCollector)
+	proc.SetSink(out)
+	var wg sync.WaitGroup
+	proc.Start(&wg)
+
+	// Every other batch is flushed after the timeout, the remaining batches are flushed asynchronously when the tag changes
+	header := &Header{Fields: []string{"val"}}
+	for batch := 0; batch < 10; batch++ {
+		for j := 0; j < 5; j++ {
+			sample := &Sample{Values: []Value{Value(batch*10 + j)}}
+			sample.SetTag("batch", strconv.Itoa(batch))
+			assert.NoError(proc.Sample(sample, header))
+		}
+		if batch%2 == 1 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	proc.Close()
+	wg.Wait()
+
+	if assert.Len(out.samples, 50) {
+		for i, sample := range out.samples {
+			assert.Equal(Value(i/5*10+i%5), sample.Values[0], "sample %v", i)
+		}
+	}
+}
+
+func TestBatchProcessorEmptyBatches(t *testing.T) {
+	for _, processEmpty := range []bool{true, false} {
+		testBatchProcessorEmptyBatches(t, processEmpty)
+	}
+}
+
+func testBatchProcessorEmptyBatches(t *testing.T, processEmpty bool) {
+	assert := testAssert.New(t)
+	filter := &SimpleBatchProcessingStep{
+		Description: "drop negative values",
+		Process: func(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+			var result []*Sample
+			for _, sample := range samples {
+				if sample.Values[0] >= 0 {
+					result = append(result, sample)
+				}
+			}
+			return header, result, nil
+		},
+	}
+	placeholder := &SimpleBatchProcessingStep{
+		Description:  "placeholder for empty batches",
+		EmptyBatches: true,
+		Process: func(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+			if len(samples) == 0 {
+				sample := &Sample{Values: []Value{-1}}
+				sample.SetTag("placeholder", "true")
+				samples = append(samples, sample)
+			}
+			return header, samples, nil
+		},
+	}
+	var wg sync.WaitGroup
+	proc := &BatchProcessor{
+		Steps:               []BatchProcessingStep{filter, placeholder},
+		FlushTags:           []string{"batch"},
+		ProcessEmptyBatches: processEmpty,
+	}
+	out := new(batchOutputCollector)
+	proc.SetSink(out)
+	proc.Start(&wg)
+
+	header := &Header{Fields: []string{"val"}}
+	for i, value := range []Value{1, 2, -3, -4, 5} {
+		sample := &Sample{Values: []Value{value}}
+		sample.SetTag("batch", strconv.Itoa(i/2)) // The second batch becomes empty after filtering
+		assert.NoError(proc.Sample(sample, header))
+	}
+	proc.Close()
+	wg.Wait()
+
+	var values []Value
+	for _, sample := range out.samples {
+		values = append(values, sample.Values[0])
+	}
+	if processEmpty {
+		assert.Equal([]Value{1, 2, -1, 5}, values)
+		assert.Equal("true", out.samples[2].Tag("placeholder"))
+	} else {
+		assert.Equal([]Value{1, 2, 5}, values)
+	}
+}
+
+func TestBatchProcessorFlushSampleCount(t *testing.T) {
+	assert := testAssert.New(t)
+	var batchSizes []int
+	step := &SimpleBatchProcessingStep{
+		Process: func(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+			batchSizes = append(batchSizes, len(samples))
+			return header, samples, nil
+		},
+	}
+	var wg sync.WaitGroup
+	proc := &BatchProcessor{
+		Steps:            []BatchProcessingStep{step},
+		FlushTags:        []string{"batch"},
+		FlushSampleCount: 3,
+	}
+	out := new(batchOutputCollector)
+	proc.SetSink(out)
+	proc.Start(&wg)
+
+	// The tag change flushes the second batch before it reaches the sample count
+	header := &Header{Fields: []string{"val"}}
+	tags := []string{"a", "a", "a", "a", "b", "b", "b", "b"}
+	for i, tag := range tags {
+		sample := &Sample{Values: []Value{Value(i)}}
+		sample.SetTag("batch", tag)
+		assert.NoError(proc.Sample(sample, header))
+	}
+	assert.Len(out.samples, 7, "samples flushed before closing")
+	proc.Close()
+	wg.Wait()
+
+	assert.Equal([]int{3, 1, 3, 1}, batchSizes)
+	assert.Len(out.samples, len(tags))
+}
+
+func TestBatchProcessorWindowOverlap(t *testing.T) {
+	assert := testAssert.New(t)
+	var windows [][]Value
+	step := &SimpleBatchProcessingStep{
+		Process: func(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+			var window []Value
+			for _, sample := range samples {
+				window = append(window, sample.Values[0])
+				sample.Values[0] = -1 // Must not affect the samples retained for the next window
+			}
+			windows = append(windows, window)
+			return header, samples, nil
+		},
+	}
+	var wg sync.WaitGroup
+	proc := &BatchProcessor{
+		Steps:            []BatchProcessingStep{step},
+		FlushTags:        []string{"batch"},
+		FlushSampleCount: 4,
+		WindowOverlap:    2,
+	}
+	out := new(batchOutputCollector)
+	proc.SetSink(out)
+	proc.Start(&wg)
+
+	header := &Header{Fields: []string{"val"}}
+	for i := 0; i < 10; i++ {
+		assert.NoError(proc.Sample(&Sample{Values: []Value{Value(i)}}, header))
+	}
+	// The header change flushes the retained samples and does not retain any samples for the next window
+	otherHeader := &Header{Fields: []string{"other"}}
+	for i := 100; i < 102; i++ {
+		assert.NoError(proc.Sample(&Sample{Values: []Value{Value(i)}}, otherHeader))
+	}
+	proc.Close()
+	wg.Wait()
+
+	assert.Equal([][]Value{
+		{0, 1, 2, 3},
+		{2, 3, 4, 5},
+		{4, 5, 6, 7},
+		{6, 7, 8, 9},
+		{8, 9},
+		{100, 101},
+	}, windows)
+	assert.Len(out.samples, 20) // Every window is forwarded entirely, including the retained samples
+}
+
+func TestBatchProcessorWindowOverlapFlushTriggers(t *testing.T) {
+	test := func(proc *BatchProcessor, makeSample func(i int) *Sample) [][]Value {
+		var windows [][]Value
+		proc.Steps = []BatchProcessingStep{&SimpleBatchProcessingStep{
+			Process: func(header *Header, samples []*Sample) (*Header, []*Sample, error) {
+				var window []Value
+				for _, sample := range samples {
+					window = append(window, sample.Values[0])
+				}
+				windows = append(windows, window)
+				return header, samples, nil
+			},
+		}}
+		proc.FlushSampleCount = 4
+		proc.WindowOverlap = 2
+		var wg sync.WaitGroup
+		proc.SetSink(new(batchOutputCollector))
+		proc.Start(&wg)
+		header := &Header{Fields: []string{"val"}}
+		for i := 0; i < 8; i++ {
+			testAssert.NoError(t, proc.Sample(makeSample(i), header))
+		}
+		proc.Close()
+		wg.Wait()
+		return windows
+	}
+
+	// The tag and timestamp changes before the 6th sample start a new window without any overlap
+	expected := [][]Value{
+		{0, 1, 2, 3},
+		{2, 3, 4},
+		{5, 6, 7},
+	}
+	windows := test(&BatchProcessor{FlushTags: []string{"batch"}}, func(i int) *Sample {
+		sample := &Sample{Values: []Value{Value(i)}}
+		sample.SetTag("batch", strconv.Itoa(i/5))
+		return sample
+	})
+	testAssert.Equal(t, expected, windows, "tag change")
+
+	windows = test(&BatchProcessor{SampleTimestampFlushTimeout: time.Minute}, func(i int) *Sample {
+		timestamp := time.Unix(int64(i), 0)
+		if i >= 5 {
+			timestamp = timestamp.Add(time.Hour)
+		}
+		return &Sample{Values: []Value{Value(i)}, Time: timestamp}
+	})
+	testAssert.Equal(t, expected, windows, "timestamp jump")
+}
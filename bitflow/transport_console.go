@@ -4,6 +4,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/antongulenko/golib"
 	log "github.com/sirupsen/logrus"
@@ -56,6 +57,10 @@ func (sink *WriterSink) Sample(sample *Sample, header *Header) error {
 	return sink.AbstractMarshallingSampleOutput.Sample(err, sample, header)
 }
 
+// DefaultKeepAlivePollInterval is the interval used by ReaderSource to check for new data after reaching the end
+// of the input, if KeepAlive is set.
+const DefaultKeepAlivePollInterval = 200 * time.Millisecond
+
 // ReaderSource implements the SampleSource interface by reading Headers and
 // Samples from an arbitrary io.ReadCloser instance. An instance of SampleReader is used
 // to read the data in parallel.
@@ -64,7 +69,16 @@ type ReaderSource struct {
 	Input       io.ReadCloser
 	Description string
 
-	stream *SampleInputStream
+	// KeepAlive makes the ReaderSource wait for more data when reaching the end of the input, instead of
+	// closing. Reading is retried every KeepAlivePollInterval (default DefaultKeepAlivePollInterval),
+	// until Close() is called. Useful for inputs that are fed intermittently, like named pipes or files that
+	// are appended to. Note that with KeepAlive, an end of input typed in a terminal (Ctrl-D) does not stop
+	// the ReaderSource, it must be stopped through Close() (e.g. by an interrupt signal).
+	KeepAlive             bool
+	KeepAlivePollInterval time.Duration
+
+	stream    *SampleInputStream
+	keepAlive golib.StopChan
 }
 
 // NewConsoleSource creates a SampleSource that reads from the standard input.
@@ -83,7 +97,19 @@ func (source *ReaderSource) String() string {
 // Start implements the SampleSource interface by starting a SampleInputStream
 // instance that reads from the given io.ReadCloser.
 func (source *ReaderSource) Start(wg *sync.WaitGroup) golib.StopChan {
-	source.stream = source.Reader.Open(source.Input, source.GetSink())
+	input := source.Input
+	if source.KeepAlive {
+		if source.KeepAlivePollInterval <= 0 {
+			source.KeepAlivePollInterval = DefaultKeepAlivePollInterval
+		}
+		source.keepAlive = golib.NewStopChan()
+		input = &keepAliveReader{
+			ReadCloser:   input,
+			pollInterval: source.KeepAlivePollInterval,
+			stopper:      source.keepAlive,
+		}
+	}
+	source.stream = source.Reader.Open(input, source.GetSink())
 	return golib.WaitErrFunc(wg, func() error {
 		defer source.CloseSinkParallel(wg)
 		err := source.stream.ReadNamedSamples(source.Description)
@@ -100,8 +126,31 @@ func (source *ReaderSource) Close() {
 	// TODO closing the os.Stdin stream does not cause the current Read()
 	// invocation to return... This data source will hang until stdin is closed
 	// from the outside, or the program is stopped forcefully.
+	source.keepAlive.Stop()
 	err := source.stream.Close()
 	if err != nil && !IsFileClosedError(err) {
 		log.Errorf("%v: error closing output: %v", source, err)
 	}
 }
+
+// keepAliveReader retries reading from the underlying reader after reaching EOF, until the stopper is stopped.
+type keepAliveReader struct {
+	io.ReadCloser
+	pollInterval time.Duration
+	stopper      golib.StopChan
+}
+
+func (r *keepAliveReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.ReadCloser.Read(b)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if !r.stopper.WaitTimeout(r.pollInterval) {
+			return 0, io.EOF
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package bitflow
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetterHandler receives input data that could not be parsed. If a DeadLetterHandler is configured in
+// a SampleReader, unparseable samples do not stop the input stream. Instead, the raw data of the sample is passed
+// to the DeadLetterHandler, together with the parsing error and the source of the data (e.g. the file name),
+// and the stream continues with the next sample. The data parameter can be nil, if the error is not associated with
+// an individual sample, e.g. when FileSource skips the remainder of a file in Robust mode.
+// HandleDeadLetter is called sequentially, in the order the data was read from the input stream.
+// A non-nil error makes the input stream fail.
+type DeadLetterHandler interface {
+	HandleDeadLetter(data []byte, err error, source string) error
+}
+
+// DeadLetterRecord is the JSON object written by DeadLetterFile for every unparseable piece of input data.
+// The Data field is marshalled as a base64 string, so that binary data is preserved.
+type DeadLetterRecord struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Error  string    `json:"error"`
+	Data   []byte    `json:"data,omitempty"`
+}
+
+// DeadLetterFile implements DeadLetterHandler by appending every dead letter as a JSON-encoded DeadLetterRecord
+// to the file at Path, one record per line. The file is created if necessary. Since dead letters are expected to
+// be rare, the file is opened and closed for every record, so a DeadLetterFile does not have to be closed.
+type DeadLetterFile struct {
+	Path string
+
+	lock sync.Mutex
+}
+
+// HandleDeadLetter implements the DeadLetterHandler interface.
+func (f *DeadLetterFile) HandleDeadLetter(data []byte, err error, source string) error {
+	line, marshalErr := json.Marshal(DeadLetterRecord{
+		Time:   time.Now(),
+		Source: source,
+		Error:  err.Error(),
+		Data:   data,
+	})
+	if marshalErr != nil {
+		return marshalErr
+	}
+	line = append(line, '\n')
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	file, openErr := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if openErr != nil {
+		return openErr
+	}
+	_, writeErr := file.Write(line)
+	if closeErr := file.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	return writeErr
+}
+
+// String returns a description of the receiving DeadLetterFile.
+func (f *DeadLetterFile) String() string {
+	return "dead letter file " + f.Path
+}
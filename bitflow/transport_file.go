@@ -12,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -239,8 +240,17 @@ type FileSource struct {
 	// For every Filename, the FileSource will not only read the file itself,
 	// but also for all files that belong to the same FileGroup, as returned by:
 	//   NewFileGroup(filename).AllFiles()
+	//
+	// Entries can also be glob patterns (see filepath.Match), or directories. Globs and
+	// directories are expanded when starting the FileSource, and the resulting files are read
+	// in lexicographical order. A directory is expanded to the files it contains, including
+	// the files in all subdirectories if Recursive is set. Expanded files are not extended to their FileGroups.
+	// If a glob pattern does not match any file, an error is returned, or a warning is logged if Robust is set.
 	FileNames []string
 
+	// Recursive can be set to true to also read the files in the subdirectories of directories given in FileNames.
+	Recursive bool
+
 	// ReadFileGroups can be set to true to extend the input files to the associated
 	// file groups. For an input file named 'data.bin', all files named 'data-[0-9]+.bin'
 	// will be read as well. The file group for 'data' is 'data-[0-9]+', the file
@@ -249,7 +259,8 @@ type FileSource struct {
 
 	// Robust can be set to true to allow errors when reading or parsing files,
 	// and only print Warnings instead. This is useful if the files to be parsed
-	// are mostly valid, but have garbage at the end.
+	// are mostly valid, but have garbage at the end. If Reader.DeadLetters is set, the errors
+	// are also passed to the DeadLetterHandler.
 	Robust bool
 
 	// IoBuffer configures the buffer size for read files. It should be large enough
@@ -292,20 +303,10 @@ func (source *FileSource) String() string {
 // until all configured files have been opened.
 func (source *FileSource) Start(wg *sync.WaitGroup) golib.StopChan {
 	source.closed = golib.NewStopChan()
-	var files []string
-	if source.ReadFileGroups {
-		for _, filename := range source.FileNames {
-			group := NewFileGroup(filename)
-			if groupFiles, err := group.AllFiles(); err != nil {
-				source.CloseSinkParallel(wg)
-				return golib.NewStoppedChan(err)
-			} else {
-				files = append(files, groupFiles...)
-			}
-		}
-	} else {
-		files = make([]string, len(source.FileNames))
-		copy(files, source.FileNames)
+	files, err := source.expandFiles()
+	if err != nil {
+		source.CloseSinkParallel(wg)
+		return golib.NewStoppedChan(err)
 	}
 	if len(files) == 0 {
 		source.CloseSinkParallel(wg)
@@ -317,6 +318,79 @@ func (source *FileSource) Start(wg *sync.WaitGroup) golib.StopChan {
 	return source.closed
 }
 
+func (source *FileSource) expandFiles() ([]string, error) {
+	var files []string
+	for _, filename := range source.FileNames {
+		if isGlobPattern(filename) {
+			matches, err := filepath.Glob(filename)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid glob pattern '%v': %v", filename, err)
+			}
+			if len(matches) == 0 {
+				if !source.Robust {
+					return nil, fmt.Errorf("No files match the pattern '%v'", filename)
+				}
+				log.WithField("pattern", filename).Warnln("No files match the pattern")
+			}
+			var expanded []string
+			for _, match := range matches {
+				if expanded, err = source.expandDirectory(match, expanded); err != nil {
+					return nil, err
+				}
+			}
+			sort.Strings(expanded)
+			files = append(files, expanded...)
+		} else if info, err := os.Stat(filename); err == nil && info.IsDir() {
+			expanded, err := source.expandDirectory(filename, nil)
+			if err != nil {
+				return nil, err
+			}
+			sort.Strings(expanded)
+			files = append(files, expanded...)
+		} else if source.ReadFileGroups {
+			group := NewFileGroup(filename)
+			groupFiles, err := group.AllFiles()
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, groupFiles...)
+		} else {
+			files = append(files, filename)
+		}
+	}
+	return files, nil
+}
+
+// expandDirectory appends the given file to the result. If the file is a directory, its contents are appended instead,
+// including all subdirectories if Recursive is set.
+func (source *FileSource) expandDirectory(filename string, result []string) ([]string, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return append(result, filename), nil
+	}
+	err = filepath.Walk(filename, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case info.IsDir():
+			if path != filename && !source.Recursive {
+				return filepath.SkipDir
+			}
+		case info.Mode().IsRegular():
+			result = append(result, path)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func isGlobPattern(filename string) bool {
+	return strings.ContainsAny(filename, "*?[")
+}
+
 func (source *FileSource) readFilesKeepAlive(wg *sync.WaitGroup, files []string) {
 	if wg != nil {
 		wg.Add(1)
@@ -358,6 +432,11 @@ func (source *FileSource) readFiles(files []string) error {
 		} else if err != nil {
 			if source.Robust {
 				log.WithFields(log.Fields{"file": filename}).Warnln("Error reading file:", err)
+				if deadLetters := source.Reader.DeadLetters; deadLetters != nil {
+					if dlErr := deadLetters.HandleDeadLetter(nil, err, filename); dlErr != nil {
+						log.WithFields(log.Fields{"file": filename}).Errorln("Error storing dead letter:", dlErr)
+					}
+				}
 				continue
 			} else {
 				return err
@@ -453,8 +532,16 @@ type FileSink struct {
 	CleanFiles bool
 
 	// Append can be set to true to make the FileSink append data to a file, if it exists.
+	// Append cannot be combined with MaxFileSize.
 	Append bool
 
+	// MaxFileSize can be set to > 0 to rotate the output files by size. When the number of bytes written to
+	// the current file reaches MaxFileSize, the file is closed and the next file is opened, using the same
+	// incrementing suffix as for new headers. Every file starts with a header, so the files can be parsed independently.
+	// Since samples are marshalled and written asynchronously, the files can exceed the limit by a few samples.
+	// With CleanFiles, all files of the rotation from previous runs are deleted at startup.
+	MaxFileSize int64
+
 	// VanishedFileCheck can be set to > 0 to enable a periodic check, if the currently opened
 	// output file is still available under the same file path as it was opened. The check will
 	// be performed whenever a sample is to be written and the last check is older than the given
@@ -463,7 +550,18 @@ type FileSink struct {
 	// the VanishedFileCheck leads to the file be recreated, which could be the more expected behavior.
 	VanishedFileCheck time.Duration
 
+	// Fsync can be set to true to make sure that written data survives a crash of the operating system or a power loss.
+	// If FsyncInterval is 0, every write to the output file is followed by a call to fsync(), otherwise fsync() is called
+	// at most once per FsyncInterval. The file is always synced before it is closed, and the directory is synced after creating a new file.
+	// Note that data is only written to the file when the IoBuffer is full, so IoBuffer also affects how much data can be lost.
+	// Syncing after every write can reduce the throughput drastically, especially with a small IoBuffer, since every
+	// fsync() call blocks until the data reaches the storage device.
+	Fsync         bool
+	FsyncInterval time.Duration
+
 	checker               HeaderChecker
+	reopen                bool  // Set by CloseFile()
+	written               int64 // Bytes written to the current file, accessed atomically
 	group                 FileGroup
 	file_num              int
 	stream                *SampleOutputStream
@@ -484,6 +582,9 @@ func (sink *FileSink) String() string {
 func (sink *FileSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
 	log.WithFields(log.Fields{"file": sink.Filename, "format": sink.Marshaller}).Println("Writing samples")
 	sink.closed = golib.NewStopChan()
+	if sink.Append && sink.MaxFileSize > 0 {
+		return golib.NewStoppedChan(errors.New("FileSink: Append and MaxFileSize cannot be combined"))
+	}
 	sink.group = NewFileGroup(sink.Filename)
 	if sink.CleanFiles {
 		if err := sink.group.DeleteFiles(); err != nil {
@@ -512,7 +613,31 @@ func (sink *FileSink) Close() {
 	})
 }
 
-func (sink *FileSink) openNextFile() (err error) {
+// CloseFile flushes and closes the currently open file without closing the FileSink. The next sample reopens
+// the same file in append mode, unless the header changed, which opens the next file as usual. The reopened file
+// repeats the header before the following samples. This allows limiting the number of open files when many FileSinks
+// are active at the same time, see fork.MultiFileDistributor. CloseFile must not be called concurrently with Sample.
+func (sink *FileSink) CloseFile() error {
+	if sink.stream == nil {
+		return nil
+	}
+	err := sink.flush()
+	sink.stream = nil
+	sink.reopen = true
+	return err
+}
+
+func (sink *FileSink) openNextFile() error {
+	return sink.openFile(sink.openNextNewFile, false)
+}
+
+func (sink *FileSink) reopenFile() error {
+	return sink.openFile(func() (*os.File, error) {
+		return os.OpenFile(sink.currentFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	}, true)
+}
+
+func (sink *FileSink) openFile(open func() (*os.File, error), reopen bool) (err error) {
 	sink.closed.IfElseStopped(func() {
 		err = errors.New(sink.String() + " is closed")
 	}, func() {
@@ -520,7 +645,7 @@ func (sink *FileSink) openNextFile() (err error) {
 			return
 		}
 		var file *os.File
-		file, err = sink.openNextNewFile()
+		file, err = open()
 		if err == nil {
 			sink.currentFile = file.Name()
 			if sink.VanishedFileCheck > 0 {
@@ -530,15 +655,39 @@ func (sink *FileSink) openNextFile() (err error) {
 				}
 				sink.currentIno = stat.Sys().(*syscall.Stat_t).Ino
 			}
+			var output io.WriteCloser = file
+			if err == nil && sink.Fsync {
+				if err = syncDirectory(filepath.Dir(file.Name())); err == nil {
+					output = &SyncingWriteCloser{File: file, Interval: sink.FsyncInterval}
+				}
+			}
 			if err == nil {
-				sink.stream = sink.Writer.OpenBuffered(file, sink.Marshaller, sink.IoBuffer)
-				log.WithField("file", file.Name()).Println("Opened file")
+				sink.openStream(output)
+				if !reopen {
+					log.WithField("file", file.Name()).Println("Opened file")
+				} else if stat, statErr := file.Stat(); statErr == nil {
+					// Continue counting the size of the existing file
+					atomic.StoreInt64(&sink.written, stat.Size())
+				}
 			}
 		}
 	})
 	return
 }
 
+func (sink *FileSink) openStream(output io.WriteCloser) {
+	if sink.MaxFileSize <= 0 {
+		sink.stream = sink.Writer.OpenBuffered(output, sink.Marshaller, sink.IoBuffer)
+		return
+	}
+	// Count the bytes before the buffer, so that buffered data is included in the file size
+	if sink.IoBuffer > 0 {
+		output = NewBufferedWriteCloser(output, sink.IoBuffer)
+	}
+	atomic.StoreInt64(&sink.written, 0)
+	sink.stream = sink.Writer.Open(&countingWriteCloser{WriteCloser: output, count: &sink.written}, sink.Marshaller)
+}
+
 func (sink *FileSink) openNextNewFile() (*os.File, error) {
 	if sink.Append {
 		file, err := os.OpenFile(sink.Filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
@@ -553,12 +702,23 @@ func (sink *FileSink) openNextNewFile() (*os.File, error) {
 
 // Sample writes a Sample to the current open file.
 func (sink *FileSink) Sample(sample *Sample, header *Header) error {
-	openNewFile := sink.checker.HeaderChanged(header) || sink.stream == nil
+	headerChanged := sink.checker.HeaderChanged(header)
+	openNewFile := headerChanged || sink.stream == nil
 	if !openNewFile && sink.VanishedFileCheck > 0 {
 		openNewFile = sink.checkOutputFile()
 	}
+	if !openNewFile && sink.MaxFileSize > 0 && atomic.LoadInt64(&sink.written) >= sink.MaxFileSize {
+		openNewFile = true
+	}
 	if openNewFile {
-		if err := sink.openNextFile(); err != nil {
+		var err error
+		if sink.reopen && !headerChanged {
+			err = sink.reopenFile()
+		} else {
+			err = sink.openNextFile()
+		}
+		sink.reopen = false
+		if err != nil {
 			return err
 		}
 	}
@@ -566,6 +726,74 @@ func (sink *FileSink) Sample(sample *Sample, header *Header) error {
 	return sink.AbstractMarshallingSampleOutput.Sample(err, sample, header)
 }
 
+// countingWriteCloser atomically adds the number of written bytes to the count field
+type countingWriteCloser struct {
+	io.WriteCloser
+	count *int64
+}
+
+func (w *countingWriteCloser) Write(data []byte) (int, error) {
+	n, err := w.WriteCloser.Write(data)
+	atomic.AddInt64(w.count, int64(n))
+	return n, err
+}
+
+// SyncedFile is the subset of the *os.File methods required by SyncingWriteCloser.
+type SyncedFile interface {
+	io.WriteCloser
+	Sync() error
+}
+
+// SyncingWriteCloser calls Sync() on the wrapped file after writing data. If Interval is > 0, Sync() is
+// called at most once per Interval. The file is always synced before it is closed.
+type SyncingWriteCloser struct {
+	File     SyncedFile
+	Interval time.Duration
+
+	lastSync time.Time
+	now      func() time.Time // Can be replaced in tests
+}
+
+func (w *SyncingWriteCloser) Write(data []byte) (int, error) {
+	n, err := w.File.Write(data)
+	if err == nil {
+		now := w.currentTime()
+		if w.Interval <= 0 || now.Sub(w.lastSync) >= w.Interval {
+			w.lastSync = now
+			err = w.File.Sync()
+		}
+	}
+	return n, err
+}
+
+func (w *SyncingWriteCloser) Close() error {
+	err := w.File.Sync()
+	if closeErr := w.File.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (w *SyncingWriteCloser) currentTime() time.Time {
+	if w.now != nil {
+		return w.now()
+	}
+	return time.Now()
+}
+
+// syncDirectory makes sure that newly created directory entries are persisted
+func syncDirectory(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func (sink *FileSink) checkOutputFile() (openNewFile bool) {
 	now := time.Now()
 	if now.Sub(sink.lastVanishedFileCheck) > sink.VanishedFileCheck {
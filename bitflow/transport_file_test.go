@@ -1,6 +1,8 @@
 package bitflow
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -8,8 +10,10 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	testAssert "github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -159,3 +163,181 @@ func (suite *FileTestSuite) TestFilesAllCsv() {
 func (suite *FileTestSuite) TestFilesAllBinary() {
 	suite.testAllHeaders(new(BinaryMarshaller))
 }
+
+type syncCountingFile struct {
+	bytes.Buffer
+	syncs  int
+	closed bool
+}
+
+func (f *syncCountingFile) Sync() error {
+	f.syncs++
+	return nil
+}
+
+func (f *syncCountingFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestSyncingWriteCloserEveryWrite(t *testing.T) {
+	assert := testAssert.New(t)
+	file := new(syncCountingFile)
+	w := &SyncingWriteCloser{File: file}
+	for i := 0; i < 3; i++ {
+		_, err := w.Write([]byte("data"))
+		assert.NoError(err)
+		assert.Equal(i+1, file.syncs)
+	}
+	assert.NoError(w.Close())
+	assert.Equal(4, file.syncs)
+	assert.True(file.closed)
+	assert.Equal("datadatadata", file.String())
+}
+
+func TestSyncingWriteCloserInterval(t *testing.T) {
+	assert := testAssert.New(t)
+	file := new(syncCountingFile)
+	now := time.Unix(1000, 0)
+	w := &SyncingWriteCloser{File: file, Interval: 2 * time.Second, now: func() time.Time { return now }}
+
+	expectedSyncs := []int{1, 1, 1, 2, 2}
+	for i, offset := range []time.Duration{0, 1, 1, 3, 4} {
+		now = time.Unix(1000, 0).Add(offset * time.Second)
+		_, err := w.Write([]byte("data"))
+		assert.NoError(err)
+		assert.Equal(expectedSyncs[i], file.syncs, "write %v", i)
+	}
+	assert.NoError(w.Close())
+	assert.Equal(3, file.syncs, "the file must be synced before closing")
+}
+
+func (suite *FileTestSuite) TestFileSinkMaxFileSize() {
+	testFile := suite.getTestFile(new(CsvMarshaller))
+	group := NewFileGroup(testFile)
+	defer func() {
+		suite.NoError(group.DeleteFiles())
+	}()
+
+	out := &FileSink{
+		Filename:    testFile,
+		CleanFiles:  true,
+		MaxFileSize: 500,
+	}
+	out.SetMarshaller(new(CsvMarshaller))
+	out.SetSink(new(DroppingSampleProcessor))
+	out.Writer.ParallelSampleHandler = ParallelSampleHandler{ParallelParsers: 1, BufferedSamples: 1}
+	var wg sync.WaitGroup
+	ch := out.Start(&wg)
+	header := &Header{Fields: []string{"a", "b"}}
+	numSamples := 200
+	for i := 0; i < numSamples; i++ {
+		suite.NoError(out.Sample(&Sample{Time: time.Unix(int64(i), 0), Values: []Value{Value(i), 1}}, header))
+	}
+	out.Close()
+	wg.Wait()
+	ch.Wait()
+	suite.NoError(ch.Err())
+
+	files, err := group.AllFiles()
+	suite.NoError(err)
+	suite.True(len(files) > 1, "expected multiple files, got %v", files)
+	lines := 0
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		suite.NoError(err)
+		fileLines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		suite.Equal("time,tags,a,b", fileLines[0], "every file must start with a header: %v", file)
+		lines += len(fileLines) - 1
+	}
+	suite.Equal(numSamples, lines)
+}
+
+func (suite *FileTestSuite) TestFileSinkMaxFileSizeAppend() {
+	out := &FileSink{
+		Filename:    suite.getTestFile(new(CsvMarshaller)),
+		Append:      true,
+		MaxFileSize: 500,
+	}
+	out.SetSink(new(DroppingSampleProcessor))
+	ch := out.Start(new(sync.WaitGroup))
+	suite.Error(ch.Err())
+}
+
+func TestFileSourceExpandFiles(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-expand-")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+	assert.NoError(os.Mkdir(path.Join(dir, "sub"), 0755))
+	for _, name := range []string{"b.csv", "a.csv", "x.txt", "sub/c.csv"} {
+		assert.NoError(ioutil.WriteFile(path.Join(dir, name), []byte("time\n"), 0644))
+	}
+	expand := func(recursive, robust bool, fileNames ...string) ([]string, error) {
+		source := &FileSource{FileNames: fileNames, Recursive: recursive, Robust: robust}
+		files, err := source.expandFiles()
+		for i, file := range files {
+			files[i] = strings.TrimPrefix(file, dir+"/")
+		}
+		return files, err
+	}
+
+	files, err := expand(false, false, path.Join(dir, "*.csv"))
+	assert.NoError(err)
+	assert.Equal([]string{"a.csv", "b.csv"}, files)
+
+	files, err = expand(false, false, dir)
+	assert.NoError(err)
+	assert.Equal([]string{"a.csv", "b.csv", "x.txt"}, files)
+
+	files, err = expand(true, false, dir, path.Join(dir, "x.txt"))
+	assert.NoError(err)
+	assert.Equal([]string{"a.csv", "b.csv", "sub/c.csv", "x.txt", "x.txt"}, files)
+
+	_, err = expand(false, false, path.Join(dir, "*.json"))
+	assert.Error(err)
+	files, err = expand(false, true, path.Join(dir, "*.json"), path.Join(dir, "s*"))
+	assert.NoError(err)
+	assert.Equal([]string{"sub/c.csv"}, files)
+}
+
+func (suite *FileTestSuite) TestFileSourceDeadLetters() {
+	input := path.Join(suite.dir, "dead-letter-input.csv")
+	deadLetters := path.Join(suite.dir, "dead-letters.json")
+	defer os.Remove(input)       // Drop error
+	defer os.Remove(deadLetters) // Drop error
+	data := "time,tags,a,b\n" +
+		"2020-01-01 00:00:01,,1,2\n" +
+		"2020-01-01 00:00:02,,3,xxx\n" +
+		"2020-01-01 00:00:03,,5,6\n"
+	suite.NoError(ioutil.WriteFile(input, []byte(data), 0644))
+
+	source := &FileSource{FileNames: []string{input}, IoBuffer: 1024}
+	source.Reader.ParallelSampleHandler = parallel_handler
+	source.Reader.Unmarshaller = new(CsvMarshaller)
+	source.Reader.DeadLetters = &DeadLetterFile{Path: deadLetters}
+	sink := new(collectingSampleSink)
+	source.SetSink(sink)
+	var wg sync.WaitGroup
+	ch := source.Start(&wg)
+	wg.Wait()
+	source.Close()
+	ch.Wait()
+	suite.NoError(ch.Err())
+
+	// The good records flow normally
+	suite.Len(sink.samples, 2)
+	suite.Equal([]Value{1, 2}, sink.samples[0].Values)
+	suite.Equal([]Value{5, 6}, sink.samples[1].Values)
+
+	// The corrupt record is stored in the dead letter file
+	content, err := ioutil.ReadFile(deadLetters)
+	suite.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	suite.Len(lines, 1)
+	var record DeadLetterRecord
+	suite.NoError(json.Unmarshal([]byte(lines[0]), &record))
+	suite.Equal(input, record.Source)
+	suite.Equal("2020-01-01 00:00:02,,3,xxx", string(record.Data))
+	suite.NotEmpty(record.Error)
+}
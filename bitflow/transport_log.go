@@ -0,0 +1,100 @@
+package bitflow
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	LogEndpoint = EndpointType("log")
+
+	// DefaultLogSinkMaxValues is the default number of values logged for every sample by the LogSink.
+	DefaultLogSinkMaxValues = 20
+)
+
+// RegisterLogOutput registers the LogSink as custom output for endpoints like log://info.
+// The endpoint target is the log level of the sample log messages.
+func RegisterLogOutput(factory *EndpointFactory) {
+	factory.CustomDataSinks[LogEndpoint] = func(target string) (SampleProcessor, error) {
+		level, err := log.ParseLevel(target)
+		if err != nil {
+			return nil, err
+		}
+		if level <= log.FatalLevel {
+			return nil, fmt.Errorf("Transport '%v' does not support the log level '%v'", LogEndpoint, target)
+		}
+		return &LogSink{Level: level}, nil
+	}
+}
+
+// LogSink implements the SampleProcessor interface by writing every sample as structured log message
+// through the logrus logger. This integrates the samples with the regular log output, e.g. for debugging
+// or log aggregation. Every sample is logged with the given Level. The log fields contain the timestamp, tags,
+// and a compact summary of the values. To keep the log messages short, only the first MaxValues values are
+// included, followed by the number of omitted values. If MaxValues is <= 0, DefaultLogSinkMaxValues is used.
+// Every new header is logged with the info level, including the list of fields.
+type LogSink struct {
+	AbstractSampleOutput
+	Level     log.Level
+	MaxValues int
+
+	checker HeaderChecker
+}
+
+// String implements the SampleSink interface.
+func (sink *LogSink) String() string {
+	return fmt.Sprintf("Log samples (level %v)", sink.Level)
+}
+
+// Start implements the SampleSink interface. No goroutines are started.
+func (sink *LogSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
+	return
+}
+
+// Close implements the SampleSink interface.
+func (sink *LogSink) Close() {
+	sink.CloseSink()
+}
+
+// Sample implements the SampleSink interface by logging the sample.
+func (sink *LogSink) Sample(sample *Sample, header *Header) error {
+	if sink.checker.HeaderChanged(header) {
+		log.WithField("fields", header.Fields).Infof("%v: New header with %v field(s)", sink, len(header.Fields))
+	}
+	if log.IsLevelEnabled(sink.Level) {
+		log.WithFields(log.Fields{
+			"sample_time": sample.Time.Format(TextMarshallerDateFormat),
+			"tags":        sample.TagString(),
+			"values":      sink.summarizeValues(sample, header),
+		}).Log(sink.Level, "Sample")
+	}
+	return sink.AbstractSampleOutput.Sample(nil, sample, header)
+}
+
+func (sink *LogSink) summarizeValues(sample *Sample, header *Header) string {
+	maxValues := sink.MaxValues
+	if maxValues <= 0 {
+		maxValues = DefaultLogSinkMaxValues
+	}
+	var buf bytes.Buffer
+	for i, value := range sample.Values {
+		if i >= maxValues {
+			fmt.Fprintf(&buf, " ... (%v more)", len(sample.Values)-i)
+			break
+		}
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		if i < len(header.Fields) {
+			buf.WriteString(header.Fields[i])
+			buf.WriteByte('=')
+		}
+		buf.WriteString(strconv.FormatFloat(float64(value), 'g', 6, 64))
+	}
+	return buf.String()
+}
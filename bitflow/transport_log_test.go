@@ -0,0 +1,36 @@
+package bitflow
+
+import (
+	"math"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestLogSink(t *testing.T) {
+	assert := testAssert.New(t)
+	factory := NewEndpointFactory()
+
+	sink, err := factory.CreateOutput("log://debug")
+	assert.NoError(err)
+	if assert.IsType(new(LogSink), sink) {
+		assert.Equal(log.DebugLevel, sink.(*LogSink).Level)
+	}
+	_, err = factory.CreateOutput("log://verbose")
+	assert.Error(err)
+	_, err = factory.CreateOutput("log://panic")
+	assert.EqualError(err, "Error creating 'log' output: Transport 'log' does not support the log level 'panic'")
+	_, err = factory.CreateOutput("csv+log://info")
+	assert.EqualError(err, "Cannot define the data format for transport 'log'")
+
+	logSink := &LogSink{Level: log.InfoLevel, MaxValues: 3}
+	header := &Header{Fields: []string{"a", "b", "c", "d", "e"}}
+	sample := &Sample{Values: []Value{1, 0.5, 1234567.8, Value(math.NaN()), 5}}
+	assert.Equal("a=1 b=0.5 c=1.23457e+06 ... (2 more)", logSink.summarizeValues(sample, header))
+	sample.Values = sample.Values[:2]
+	assert.Equal("a=1 b=0.5", logSink.summarizeValues(sample, header))
+
+	logSink.DontForwardSamples = true
+	assert.NoError(logSink.Sample(sample, header))
+}
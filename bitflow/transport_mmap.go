@@ -0,0 +1,158 @@
+package bitflow
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// MmapBinaryFile provides random access to the samples of a file in the binary format (see BinaryMarshaller)
+// by mapping the file into memory. Samples can be read by their index, or by a time range, without reading
+// the file from the start. This requires all samples to have the same size, so the file must contain exactly one header,
+// the header must not include tags, and the tag dictionary must not be used. Reading a time range requires the samples
+// to be sorted by their timestamps.
+//
+// The samples returned by ReadSample, ReadRange and ReadTimeRange do not reference the mapped memory,
+// so they stay valid after Close. All methods can be called concurrently, except Close.
+type MmapBinaryFile struct {
+	Filename string
+	Header   *Header
+
+	data       []byte
+	start      int // Offset of the first sample
+	sampleSize int
+	numSamples int
+}
+
+// OpenMmapBinaryFile maps the given binary file into memory and parses its header.
+// The returned MmapBinaryFile must be closed to release the mapped memory.
+func OpenMmapBinaryFile(filename string) (*MmapBinaryFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // Drop error, the mapping stays valid after closing the file
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, fmt.Errorf("Cannot map empty file %v", filename)
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to map %v into memory: %v", filename, err)
+	}
+	result := &MmapBinaryFile{Filename: filename, data: data}
+	if err := result.readHeader(); err != nil {
+		_ = syscall.Munmap(data) // Drop error
+		return nil, fmt.Errorf("Failed to read %v: %v", filename, err)
+	}
+	return result, nil
+}
+
+func (f *MmapBinaryFile) readHeader() error {
+	input := bytes.NewReader(f.data)
+	reader := bufio.NewReader(input)
+	header, _, err := BinaryMarshaller{}.readHeader(reader)
+	if err != nil {
+		return err
+	}
+	if header.HasTags {
+		return errors.New("Samples with tags do not have a fixed size, random access is not supported")
+	}
+	f.Header = &header.Header
+	f.start = len(f.data) - input.Len() - reader.Buffered()
+	f.sampleSize = len(binary_sample_start) + timeBytes + valBytes*len(header.Fields)
+	samplesLen := len(f.data) - f.start
+	if samplesLen%f.sampleSize != 0 {
+		return fmt.Errorf("The size of the sample data (%v bytes) is not a multiple of the sample size (%v bytes). "+
+			"The file might contain multiple headers or an incomplete sample", samplesLen, f.sampleSize)
+	}
+	f.numSamples = samplesLen / f.sampleSize
+	// Only check the first and last sample, instead of scanning the entire file
+	for _, i := range []int{0, f.numSamples - 1} {
+		if i >= 0 && i < f.numSamples && !bytes.HasPrefix(f.data[f.start+i*f.sampleSize:], []byte(binary_sample_start)) {
+			return fmt.Errorf("Bitflow binary protocol error, expected sample start at sample %v", i)
+		}
+	}
+	return nil
+}
+
+// NumSamples returns the number of samples in the file.
+func (f *MmapBinaryFile) NumSamples() int {
+	return f.numSamples
+}
+
+// ReadSample parses the sample at the given index.
+func (f *MmapBinaryFile) ReadSample(index int) (*Sample, error) {
+	if index < 0 || index >= f.numSamples {
+		return nil, fmt.Errorf("Sample index %v out of range, %v contains %v samples", index, f.Filename, f.numSamples)
+	}
+	// Skip the sample start, ParseSample copies the values out of the mapped memory
+	offset := f.start + index*f.sampleSize + len(binary_sample_start)
+	header := &UnmarshalledHeader{Header: *f.Header}
+	return BinaryMarshaller{}.ParseSample(header, 0, f.data[offset:offset+f.sampleSize-len(binary_sample_start)])
+}
+
+// ReadRange parses the samples in the index range [from, to).
+func (f *MmapBinaryFile) ReadRange(from, to int) ([]*Sample, error) {
+	if from < 0 || to > f.numSamples || from > to {
+		return nil, fmt.Errorf("Sample range [%v, %v) out of range, %v contains %v samples", from, to, f.Filename, f.numSamples)
+	}
+	samples := make([]*Sample, 0, to-from)
+	for i := from; i < to; i++ {
+		sample, err := f.ReadSample(i)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, nil
+}
+
+// SampleTime returns the timestamp of the sample at the given index, without parsing the values.
+// The index must be valid.
+func (f *MmapBinaryFile) SampleTime(index int) time.Time {
+	offset := f.start + index*f.sampleSize + len(binary_sample_start)
+	return time.Unix(0, int64(binary.BigEndian.Uint64(f.data[offset:offset+timeBytes])))
+}
+
+// SearchTime returns the index of the first sample with a timestamp not before the given time, or NumSamples(),
+// if all samples are older. The samples must be sorted by their timestamps.
+func (f *MmapBinaryFile) SearchTime(t time.Time) int {
+	return sort.Search(f.numSamples, func(i int) bool {
+		return !f.SampleTime(i).Before(t)
+	})
+}
+
+// ReadTimeRange parses all samples with timestamps in the range [from, to), using a binary search on the timestamps.
+// The samples must be sorted by their timestamps.
+func (f *MmapBinaryFile) ReadTimeRange(from, to time.Time) ([]*Sample, error) {
+	start := f.SearchTime(from)
+	end := f.SearchTime(to)
+	if end < start {
+		end = start
+	}
+	return f.ReadRange(start, end)
+}
+
+// Close releases the mapped memory. No other methods must be called afterwards.
+func (f *MmapBinaryFile) Close() error {
+	if f.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(f.data)
+	f.data = nil
+	return err
+}
+
+func (f *MmapBinaryFile) String() string {
+	return fmt.Sprintf("Memory-mapped binary file %v (%v samples)", f.Filename, f.numSamples)
+}
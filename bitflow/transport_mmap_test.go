@@ -0,0 +1,106 @@
+package bitflow
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func writeBinaryTestFile(t *testing.T, filename string, header *Header, withTags bool, numSamples int) {
+	assert := testAssert.New(t)
+	var buf bytes.Buffer
+	var m BinaryMarshaller
+	assert.NoError(m.WriteHeader(header, withTags, &buf))
+	for i := 0; i < numSamples; i++ {
+		sample := &Sample{Time: time.Unix(int64(1000+i), 0), Values: []Value{Value(i), Value(-i), 0.5}}
+		assert.NoError(m.WriteSample(sample, header, withTags, &buf))
+	}
+	assert.NoError(ioutil.WriteFile(filename, buf.Bytes(), 0644))
+}
+
+func TestMmapBinaryFile(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-mmap")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	header := &Header{Fields: []string{"a", "b", "c"}}
+	header.SetFieldUnit("a", "bytes")
+	filename := filepath.Join(dir, "data.bin")
+	writeBinaryTestFile(t, filename, header, false, 100)
+
+	file, err := OpenMmapBinaryFile(filename)
+	assert.NoError(err)
+	defer func() {
+		assert.NoError(file.Close())
+	}()
+	assert.Equal(100, file.NumSamples())
+	assert.True(header.Equals(file.Header))
+
+	sample, err := file.ReadSample(42)
+	assert.NoError(err)
+	assert.Equal(time.Unix(1042, 0), sample.Time)
+	assert.Equal([]Value{42, -42, 0.5}, sample.Values)
+	_, err = file.ReadSample(100)
+	assert.Error(err)
+	_, err = file.ReadSample(-1)
+	assert.Error(err)
+
+	samples, err := file.ReadRange(98, 100)
+	assert.NoError(err)
+	assert.Len(samples, 2)
+	assert.Equal(Value(99), samples[1].Values[0])
+	_, err = file.ReadRange(99, 101)
+	assert.Error(err)
+
+	assert.Equal(10, file.SearchTime(time.Unix(1010, 0)))
+	assert.Equal(11, file.SearchTime(time.Unix(1010, 1)))
+	assert.Equal(0, file.SearchTime(time.Unix(0, 0)))
+	assert.Equal(100, file.SearchTime(time.Unix(2000, 0)))
+	samples, err = file.ReadTimeRange(time.Unix(1010, 500), time.Unix(1015, 0))
+	assert.NoError(err)
+	assert.Len(samples, 4)
+	assert.Equal(time.Unix(1011, 0), samples[0].Time)
+	assert.Equal(time.Unix(1014, 0), samples[3].Time)
+	samples, err = file.ReadTimeRange(time.Unix(1015, 0), time.Unix(1010, 0))
+	assert.NoError(err)
+	assert.Empty(samples)
+}
+
+func TestMmapBinaryFileErrors(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-mmap")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	header := &Header{Fields: []string{"a", "b", "c"}}
+
+	tagged := filepath.Join(dir, "tagged.bin")
+	writeBinaryTestFile(t, tagged, header, true, 10)
+	_, err = OpenMmapBinaryFile(tagged)
+	assert.Error(err)
+
+	truncated := filepath.Join(dir, "truncated.bin")
+	writeBinaryTestFile(t, truncated, header, false, 10)
+	data, err := ioutil.ReadFile(truncated)
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(truncated, data[:len(data)-3], 0644))
+	_, err = OpenMmapBinaryFile(truncated)
+	assert.Error(err)
+
+	empty := filepath.Join(dir, "empty.bin")
+	assert.NoError(ioutil.WriteFile(empty, nil, 0644))
+	_, err = OpenMmapBinaryFile(empty)
+	assert.Error(err)
+
+	headerOnly := filepath.Join(dir, "header.bin")
+	writeBinaryTestFile(t, headerOnly, header, false, 0)
+	file, err := OpenMmapBinaryFile(headerOnly)
+	assert.NoError(err)
+	assert.Equal(0, file.NumSamples())
+	assert.NoError(file.Close())
+}
@@ -0,0 +1,149 @@
+package bitflow
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+// MultiSampleOutput is a SampleProcessor that forwards every sample to multiple outputs, for example to write the same
+// samples to a file and a TCP connection. All outputs are started and closed together with the MultiSampleOutput.
+// Afterwards, the samples are forwarded to the subsequent processing step, like in other output implementations.
+//
+// The samples are not copied, so the outputs must not modify them. Errors of the individual outputs are aggregated.
+// By default, an error in any output stops the MultiSampleOutput. If DropFailedOutputs is set, the error is
+// logged instead, and the failed output does not receive any further samples.
+type MultiSampleOutput struct {
+	NoopProcessor
+	Outputs           []SampleProcessor
+	DropFailedOutputs bool
+
+	lock       sync.Mutex
+	failed     []bool
+	stopChans  []golib.StopChan
+	closeMutex sync.Mutex
+	closed     bool
+}
+
+// Start implements the SampleProcessor interface. It starts all outputs and observes their state.
+func (out *MultiSampleOutput) Start(wg *sync.WaitGroup) golib.StopChan {
+	result := out.NoopProcessor.Start(wg)
+	out.failed = make([]bool, len(out.Outputs))
+	out.stopChans = make([]golib.StopChan, len(out.Outputs))
+	for i, output := range out.Outputs {
+		// The outputs forward the samples themselves, so drop them at this point
+		output.SetSink(new(DroppingSampleProcessor))
+		out.stopChans[i] = output.Start(wg)
+		if !out.stopChans[i].IsNil() {
+			wg.Add(1)
+			go out.observeOutput(wg, i)
+		}
+	}
+	return result
+}
+
+func (out *MultiSampleOutput) observeOutput(wg *sync.WaitGroup, index int) {
+	defer wg.Done()
+	stopChan := out.stopChans[index]
+	stopChan.Wait()
+	if err := stopChan.Err(); err != nil && !out.isClosed() {
+		if err = out.outputFailed(index, err); err != nil {
+			out.Error(err)
+		}
+	}
+}
+
+// outputFailed marks the output as failed and returns the error that should be reported to the caller.
+func (out *MultiSampleOutput) outputFailed(index int, err error) error {
+	out.lock.Lock()
+	alreadyFailed := out.failed[index]
+	out.failed[index] = true
+	out.lock.Unlock()
+	if alreadyFailed {
+		return nil
+	}
+	err = fmt.Errorf("Output %v failed: %v", out.Outputs[index], err)
+	if out.DropFailedOutputs {
+		log.Errorf("[%v]: %v", out, err)
+		return nil
+	}
+	return err
+}
+
+func (out *MultiSampleOutput) isFailed(index int) bool {
+	out.lock.Lock()
+	defer out.lock.Unlock()
+	return out.failed[index]
+}
+
+func (out *MultiSampleOutput) isClosed() bool {
+	out.closeMutex.Lock()
+	defer out.closeMutex.Unlock()
+	return out.closed
+}
+
+// Sample implements the SampleProcessor interface. It forwards the sample to all outputs that did not fail,
+// and afterwards to the subsequent processing step.
+func (out *MultiSampleOutput) Sample(sample *Sample, header *Header) error {
+	var errors golib.MultiError
+	for i, output := range out.Outputs {
+		if out.isFailed(i) {
+			continue
+		}
+		if err := output.Sample(sample, header); err != nil {
+			errors.Add(out.outputFailed(i, err))
+		}
+	}
+	if err := errors.NilOrError(); err != nil {
+		return err
+	}
+	return out.NoopProcessor.Sample(sample, header)
+}
+
+// Close implements the SampleProcessor interface. It closes all outputs in parallel, waits for them to finish,
+// and closes the subsequent processing step afterwards. Errors that occur while closing the outputs are handled like other errors.
+func (out *MultiSampleOutput) Close() {
+	out.closeMutex.Lock()
+	out.closed = true
+	out.closeMutex.Unlock()
+
+	var wg sync.WaitGroup
+	errors := make([]error, len(out.Outputs))
+	for i, output := range out.Outputs {
+		wg.Add(1)
+		go func(i int, output SampleProcessor) {
+			defer wg.Done()
+			output.Close()
+			if stopChan := out.stopChans[i]; !stopChan.IsNil() {
+				stopChan.Wait()
+				if err := stopChan.Err(); err != nil {
+					errors[i] = out.outputFailed(i, err)
+				}
+			}
+		}(i, output)
+	}
+	wg.Wait()
+	var multiErr golib.MultiError
+	for _, err := range errors {
+		multiErr.Add(err)
+	}
+	if err := multiErr.NilOrError(); err != nil {
+		out.Error(err)
+	}
+	out.CloseSink()
+}
+
+func (out *MultiSampleOutput) String() string {
+	return fmt.Sprintf("Multiple outputs (%v)", len(out.Outputs))
+}
+
+// ContainedStringers implements the StringerContainer interface.
+func (out *MultiSampleOutput) ContainedStringers() []fmt.Stringer {
+	res := make([]fmt.Stringer, len(out.Outputs))
+	for i, output := range out.Outputs {
+		res[i] = output
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package bitflow
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+type failingSampleOutput struct {
+	collectingSampleSink
+}
+
+func (s *failingSampleOutput) Sample(sample *Sample, header *Header) error {
+	s.collectingSampleSink.Sample(sample, header) // Drop error
+	return errors.New("output failed")
+}
+
+func TestCreateOutputs(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-multi-output")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	csvFile := filepath.Join(dir, "out.csv")
+	binFile := filepath.Join(dir, "out.bin")
+
+	factory := NewEndpointFactory()
+	single, err := factory.CreateOutputs(csvFile)
+	assert.NoError(err)
+	assert.IsType(new(FileSink), single)
+	_, err = factory.CreateOutputs(csvFile, "abc://x")
+	assert.Error(err)
+
+	sink, err := factory.CreateOutputs(csvFile, binFile)
+	assert.NoError(err)
+	multi, ok := sink.(*MultiSampleOutput)
+	assert.True(ok)
+	assert.Len(multi.Outputs, 2)
+	assert.IsType(CsvMarshaller{}, multi.Outputs[0].(*FileSink).Marshaller)
+	assert.IsType(BinaryMarshaller{}, multi.Outputs[1].(*FileSink).Marshaller)
+
+	out := new(collectingSampleSink)
+	multi.SetSink(out)
+	var wg sync.WaitGroup
+	stopChan := multi.Start(&wg)
+	header := &Header{Fields: []string{"a", "b"}}
+	for i := 0; i < 3; i++ {
+		assert.NoError(multi.Sample(&Sample{Time: time.Unix(int64(i), 0), Values: []Value{Value(i), 1}}, header))
+	}
+	multi.Close()
+	wg.Wait()
+	assert.NoError(stopChan.Err())
+	assert.Len(out.samples, 3)
+
+	csvData, err := ioutil.ReadFile(csvFile)
+	assert.NoError(err)
+	assert.Len(strings.Split(strings.TrimSpace(string(csvData)), "\n"), 4)
+	binData, err := ioutil.ReadFile(binFile)
+	assert.NoError(err)
+	assert.NotEmpty(binData)
+}
+
+func TestMultiSampleOutputFailure(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &Header{Fields: []string{"a"}}
+
+	for _, drop := range []bool{false, true} {
+		failing := new(failingSampleOutput)
+		working := new(collectingSampleSink)
+		multi := &MultiSampleOutput{
+			Outputs:           []SampleProcessor{failing, working},
+			DropFailedOutputs: drop,
+		}
+		out := new(collectingSampleSink)
+		multi.SetSink(out)
+		var wg sync.WaitGroup
+		multi.Start(&wg)
+
+		err := multi.Sample(&Sample{Values: []Value{1}}, header)
+		if drop {
+			assert.NoError(err)
+		} else {
+			assert.EqualError(err, "Output "+failing.String()+" failed: output failed")
+		}
+		assert.NoError(multi.Sample(&Sample{Values: []Value{2}}, header), "the failed output must not receive further samples")
+		multi.Close()
+		wg.Wait()
+
+		assert.Len(failing.samples, 1)
+		assert.Len(working.samples, 2)
+		if drop {
+			assert.Len(out.samples, 2)
+		} else {
+			assert.Len(out.samples, 1)
+		}
+	}
+}
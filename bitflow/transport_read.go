@@ -2,7 +2,9 @@ package bitflow
 
 import (
 	"bufio"
+	"fmt"
 	"io"
+	"math"
 	"sync"
 
 	"github.com/antongulenko/golib"
@@ -11,6 +13,58 @@ import (
 
 const MinimumInputIoBuffer = 16 // Needed for auto-detecting stream format
 
+// ValueCountPolicy defines how a SampleReader handles parsed samples with a number of values that differs from
+// the number of fields in the current header.
+type ValueCountPolicy string
+
+const (
+	// ValueCountStrict makes the input stream fail when receiving a sample with a wrong number of values.
+	ValueCountStrict = ValueCountPolicy("strict")
+
+	// ValueCountPad pads samples with too few values with NaN. Samples with too many values make the input stream fail.
+	ValueCountPad = ValueCountPolicy("pad")
+
+	// ValueCountTruncate drops surplus values of samples with too many values. Samples with too few values make the input stream fail.
+	ValueCountTruncate = ValueCountPolicy("truncate")
+
+	// ValueCountAdjust pads samples with too few values with NaN and truncates samples with too many values.
+	ValueCountAdjust = ValueCountPolicy("adjust")
+)
+
+// ParseValueCountPolicy returns the ValueCountPolicy with the given name. The empty string is parsed to ValueCountStrict.
+func ParseValueCountPolicy(name string) (ValueCountPolicy, error) {
+	switch policy := ValueCountPolicy(name); policy {
+	case "":
+		return ValueCountStrict, nil
+	case ValueCountStrict, ValueCountPad, ValueCountTruncate, ValueCountAdjust:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("Unknown value count policy '%v', must be one of %v, %v, %v, %v",
+			name, ValueCountStrict, ValueCountPad, ValueCountTruncate, ValueCountAdjust)
+	}
+}
+
+// Apply checks the number of values in the given sample against the number of header fields,
+// and pads or truncates the values, if allowed by the receiving policy. The returned bool indicates
+// whether the sample was modified. An error is returned, if the number of values does not match and cannot be adjusted.
+func (policy ValueCountPolicy) Apply(sample *Sample, header *Header) (bool, error) {
+	numValues, numFields := len(sample.Values), len(header.Fields)
+	switch {
+	case numValues < numFields && (policy == ValueCountPad || policy == ValueCountAdjust):
+		for len(sample.Values) < numFields {
+			sample.Values = append(sample.Values, Value(math.NaN()))
+		}
+		return true, nil
+	case numValues > numFields && (policy == ValueCountTruncate || policy == ValueCountAdjust):
+		sample.Values = sample.Values[:numFields]
+		return true, nil
+	case numValues != numFields:
+		return false, fmt.Errorf("Unexpected number of values in sample: %v, expected %v", numValues, numFields)
+	default:
+		return false, nil
+	}
+}
+
 // SampleReader is used to read Headers and Samples from an io.Reader,
 // parallelizing the reading and parsing procedures. The parallelization
 // must be configured through the ParallelSampleHandler parameters before starting
@@ -28,6 +82,18 @@ type SampleReader struct {
 	// to automatically determine the format of the incoming data and create
 	// a fitting Unmarshaller instance accordingly.
 	Unmarshaller Unmarshaller
+
+	// ValueCountPolicy defines how parsed samples with a wrong number of values are handled.
+	// The empty value behaves like ValueCountStrict.
+	ValueCountPolicy ValueCountPolicy
+
+	// DeadLetters can be set to skip samples that cannot be parsed, instead of failing the input stream.
+	// The raw data of skipped samples is passed to the DeadLetterHandler for later inspection.
+	DeadLetters DeadLetterHandler
+
+	// MaxLineLength limits the length of lines and delimited fields read by automatically detected Unmarshallers,
+	// see CsvMarshaller.MaxLineLength. Configured Unmarshallers are not modified.
+	MaxLineLength int
 }
 
 // ReadSampleHandler defines a hook for modifying unmarshalled Samples.
@@ -61,6 +127,7 @@ type SampleInputStream struct {
 	header           *UnmarshalledHeader // Header received from the input stream
 	outHeader        *Header             // Header after modified by the ReadSampleHandler
 	sink             SampleSink
+	adjustedWarning  sync.Once
 }
 
 // Open creates an input stream reading from the given io.ReadCloser and writing
@@ -97,10 +164,11 @@ func (r *SampleReader) OpenBuffered(input io.ReadCloser, sink SampleSink, bufSiz
 // created this SampleInputStream. The source string will be used for the HandleSample() method.
 func (stream *SampleInputStream) ReadSamples(source string) (int, error) {
 	if stream.um == nil {
-		if um, err := detectFormat(stream.reader); err != nil {
+		if um, err := DetectFormat(stream.reader); err != nil {
 			return 0, err
 		} else {
 			stream.um = um
+			stream.limitLineLength()
 		}
 	}
 
@@ -112,13 +180,25 @@ func (stream *SampleInputStream) ReadSamples(source string) (int, error) {
 
 	// Forward parsed samples
 	stream.wg.Add(1)
-	go stream.sinkSamples()
+	go stream.sinkSamples(source)
 
 	stream.readData(source)
 	stream.wg.Wait()
 	return stream.num_samples, stream.getErrorNoEOF()
 }
 
+func (stream *SampleInputStream) limitLineLength() {
+	maxLength := stream.sampleReader.MaxLineLength
+	switch um := stream.um.(type) {
+	case *CsvMarshaller:
+		um.MaxLineLength = maxLength
+	case *BinaryMarshaller:
+		um.MaxLineLength = maxLength
+	case *JsonMarshaller:
+		um.MaxLineLength = maxLength
+	}
+}
+
 // ReadNamedSamples calls ReadSamples with the given source string, and prints
 // some additional logging information. It is a convenience function for different
 // implementations of SampleSource.
@@ -252,6 +332,9 @@ func (stream *SampleInputStream) updateHeader(header *UnmarshalledHeader, source
 		stream.outHeader.Fields = make([]string, numFields)
 		copy(stream.outHeader.Fields, header.Fields)
 	}
+	if header.HasFieldMetadata() {
+		stream.outHeader.FieldMetadata = header.FieldMetadata
+	}
 }
 
 func (stream *SampleInputStream) parseSamples(source string) {
@@ -264,9 +347,18 @@ func (stream *SampleInputStream) parseSamples(source string) {
 func (stream *SampleInputStream) parseOne(source string, sample *bufferedIncomingSample) {
 	defer sample.notifyDone()
 	numValues := RequiredValues(len(sample.inHeader.Fields), stream.sink)
-	if parsedSample, err := stream.um.ParseSample(sample.inHeader, numValues, sample.data); err != nil {
-		stream.addError(err)
-		sample.ParserError = true
+	parsedSample, err := stream.um.ParseSample(sample.inHeader, numValues, sample.data)
+	if err == nil {
+		err = stream.checkValueCount(source, parsedSample, &sample.inHeader.Header)
+	}
+	if err != nil {
+		if stream.sampleReader.DeadLetters != nil {
+			// Forwarded to the DeadLetterHandler in sinkSamples(), to preserve the order
+			sample.deadLetterError = err
+		} else {
+			stream.addError(err)
+			sample.ParserError = true
+		}
 		return
 	} else {
 		if handler := stream.sampleReader.Handler; handler != nil {
@@ -276,7 +368,20 @@ func (stream *SampleInputStream) parseOne(source string, sample *bufferedIncomin
 	}
 }
 
-func (stream *SampleInputStream) sinkSamples() {
+func (stream *SampleInputStream) checkValueCount(source string, sample *Sample, header *Header) error {
+	numValues := len(sample.Values)
+	adjusted, err := stream.sampleReader.ValueCountPolicy.Apply(sample, header)
+	if adjusted {
+		stream.adjustedWarning.Do(func() {
+			log.WithFields(log.Fields{"format": stream.um, "source": source}).Warnf(
+				"Adjusted sample with %v values to %v header fields (policy %v). Further adjusted samples are not logged.",
+				numValues, len(header.Fields), stream.sampleReader.ValueCountPolicy)
+		})
+	}
+	return err
+}
+
+func (stream *SampleInputStream) sinkSamples(source string) {
 	defer stream.wg.Done()
 	for sample := range stream.outgoing {
 		sample.waitDone()
@@ -284,6 +389,14 @@ func (stream *SampleInputStream) sinkSamples() {
 			// The first parser error makes the input stream stop.
 			return
 		}
+		if sample.deadLetterError != nil {
+			log.WithFields(log.Fields{"format": stream.um, "source": source}).Warnln("Skipping unparseable sample:", sample.deadLetterError)
+			if err := stream.sampleReader.DeadLetters.HandleDeadLetter(sample.data, sample.deadLetterError, source); err != nil {
+				stream.addError(fmt.Errorf("Failed to store unparseable sample in %v: %v", stream.sampleReader.DeadLetters, err))
+				return
+			}
+			continue
+		}
 		if err := stream.sink.Sample(sample.sample, sample.outHeader); err != nil {
 			stream.addError(err)
 			return
@@ -296,7 +409,8 @@ func (stream *SampleInputStream) sinkSamples() {
 
 type bufferedIncomingSample struct {
 	bufferedSample
-	ParserError bool
-	inHeader    *UnmarshalledHeader
-	outHeader   *Header
+	ParserError     bool
+	deadLetterError error
+	inHeader        *UnmarshalledHeader
+	outHeader       *Header
 }
@@ -309,6 +309,15 @@ func (b *outputSampleBuffer) next(l *sampleListLink) *sampleListLink {
 	return l.next
 }
 
+// takeAll removes all samples from the buffer and returns the first link of the removed list.
+func (b *outputSampleBuffer) takeAll() (*sampleListLink, uint) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+	first, size := b.first, b.size
+	b.first, b.last, b.size = nil, nil, 0
+	return first, size
+}
+
 func (b *outputSampleBuffer) closeBuffer() {
 	b.cond.L.Lock()
 	defer b.cond.L.Unlock()
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net"
 	"os"
 	"strconv"
@@ -189,11 +191,22 @@ func (conn *TcpWriteConn) printErr(err error) {
 	}
 }
 
+const (
+	DefaultTcpSinkRetryInterval    = 500 * time.Millisecond
+	DefaultTcpSinkMaxRetryInterval = 30 * time.Second
+	DefaultTcpSinkReconnectBuffer  = 1000
+)
+
 // TCPSink implements SampleSink by sending the received Headers and Samples
 // to a given remote TCP endpoint. Every time it receives a Header or a Sample,
 // it checks whether a TCP connection is already established. If so, it sends
 // the data on the existing connection. Otherwise, it tries to connect to the
 // configured endpoint and sends the data there, if the connection is successful.
+//
+// By default, a failed connection attempt makes the Sample() method return an error.
+// If Reconnect is set, failed connection attempts are instead retried with an exponential
+// backoff, and the samples received in the meantime are buffered and sent after the
+// connection is re-established.
 type TCPSink struct {
 	// AbstractTcpSink contains different configuration options regarding the
 	// marshalling and writing of data to the remote TCP connection.
@@ -205,9 +218,28 @@ type TCPSink struct {
 	// DialTimeout can be set to time out automatically when connecting to a remote TCP endpoint
 	DialTimeout time.Duration
 
+	// Reconnect enables retrying failed connections instead of returning errors from Sample().
+	Reconnect bool
+
+	// RetryInterval is the time to wait after the first failed connection attempt, when Reconnect is set.
+	// The interval is doubled after every further failed attempt, up to MaxRetryInterval.
+	// Defaults to DefaultTcpSinkRetryInterval and DefaultTcpSinkMaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// BufferedSamples is the number of samples that are kept in a ring buffer while the connection
+	// is down, when Reconnect is set. The buffered samples are sent first after the connection is
+	// re-established, older samples are dropped when the buffer is full.
+	// Defaults to DefaultTcpSinkReconnectBuffer.
+	BufferedSamples uint
+
 	conn    *TcpWriteConn
 	stopped golib.StopChan
 	wg      *sync.WaitGroup
+
+	buf           outputSampleBuffer
+	retryInterval time.Duration
+	nextRetry     time.Time
 }
 
 // String implements the SampleSink interface.
@@ -223,6 +255,24 @@ func (sink *TCPSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
 	log.WithField("format", sink.Marshaller).Println("Sending data to", sink.Endpoint)
 	sink.stopped = golib.NewStopChan()
 	sink.wg = wg
+	if sink.Reconnect {
+		if sink.RetryInterval <= 0 {
+			sink.RetryInterval = DefaultTcpSinkRetryInterval
+		}
+		if sink.MaxRetryInterval < sink.RetryInterval {
+			sink.MaxRetryInterval = DefaultTcpSinkMaxRetryInterval
+			if sink.MaxRetryInterval < sink.RetryInterval {
+				sink.MaxRetryInterval = sink.RetryInterval
+			}
+		}
+		if sink.BufferedSamples == 0 {
+			sink.BufferedSamples = DefaultTcpSinkReconnectBuffer
+		}
+		sink.buf = outputSampleBuffer{
+			Capacity: sink.BufferedSamples,
+			cond:     sync.NewCond(new(sync.Mutex)),
+		}
+	}
 	return
 }
 
@@ -243,8 +293,12 @@ func (sink *TCPSink) Close() {
 
 // Sample implements the SampleSink interface. If a connection is already established,
 // the Sample is directly sent through it. Otherwise, a new connection is established,
-// and the sample is sent there.
+// and the sample is sent there. If Reconnect is set and no connection can be established,
+// the sample is buffered instead.
 func (sink *TCPSink) Sample(sample *Sample, header *Header) error {
+	if sink.Reconnect {
+		return sink.AbstractSampleOutput.Sample(sink.reconnectingSample(sample, header), sample, header)
+	}
 	conn, err := sink.getOutputConnection()
 	if err == nil {
 		conn.Sample(sample, header)
@@ -296,6 +350,76 @@ func (sink *TCPSink) assertConnection() error {
 	return nil
 }
 
+func (sink *TCPSink) reconnectingSample(sample *Sample, header *Header) (err error) {
+	closeSink := false
+	sink.stopped.IfElseStopped(func() {
+		err = fmt.Errorf("TCP sink to %v already closed", sink.Endpoint)
+	}, func() {
+		if sink.conn != nil && !sink.conn.IsRunning() {
+			closeSink = sink.connectionLost()
+		}
+		if sink.conn == nil && !closeSink && !time.Now().Before(sink.nextRetry) {
+			if dialErr := sink.assertConnection(); dialErr != nil {
+				sink.scheduleRetry(dialErr)
+			} else {
+				sink.retryInterval = 0
+				sink.replayBuffer()
+			}
+		}
+		if sink.conn.IsRunning() {
+			sink.conn.Sample(sample, header)
+			if sink.conn.IsRunning() {
+				return
+			}
+			closeSink = sink.connectionLost()
+		}
+		sink.buf.add(sample, header)
+	})
+	if closeSink {
+		sink.Close()
+	}
+	return
+}
+
+// connectionLost cleans up a failed connection and schedules an immediate reconnect.
+// Returns true, if the TCPConnCounter does not allow further connections.
+func (sink *TCPSink) connectionLost() bool {
+	sink.closeConnection()
+	sink.nextRetry = time.Time{}
+	return !sink.countConnectionClosed()
+}
+
+func (sink *TCPSink) scheduleRetry(cause error) {
+	if sink.retryInterval == 0 {
+		sink.retryInterval = sink.RetryInterval
+	} else {
+		sink.retryInterval *= 2
+		if sink.retryInterval > sink.MaxRetryInterval {
+			sink.retryInterval = sink.MaxRetryInterval
+		}
+	}
+	sink.nextRetry = time.Now().Add(sink.retryInterval)
+	log.WithField("endpoint", sink.Endpoint).Warnf("Failed to connect (%v), buffering samples and retrying in %v", cause, sink.retryInterval)
+}
+
+// replayBuffer sends all buffered samples through the current connection. If the connection fails,
+// the remaining samples are put back into the buffer.
+func (sink *TCPSink) replayBuffer() {
+	link, num := sink.buf.takeAll()
+	if num > 0 {
+		sink.conn.log.Println("Connection established, sending", num, "buffered samples")
+	}
+	for ; link != nil; link = link.next {
+		sink.conn.Sample(link.sample, link.header)
+		if !sink.conn.IsRunning() {
+			break
+		}
+	}
+	for ; link != nil; link = link.next {
+		sink.buf.add(link.sample, link.header)
+	}
+}
+
 // TCPSource implements the SampleSource interface by connecting to a list of remote TCP
 // endpoints and downloading Header and Sample data from there. A background goroutine continuously
 // tries to establish the required TCP connections and reads data from it whenever a connection
@@ -319,6 +443,16 @@ type TCPSource struct {
 	// or failed connection attempt.
 	RetryInterval time.Duration
 
+	// RetryJitter randomly varies every RetryInterval by up to the given fraction, e.g. 0.2 for +/- 20%.
+	// This avoids that many sources reconnect to a failed server at the same time. Values are limited to 0..1.
+	RetryJitter float64
+
+	// RetryMax can be set to > 0 to limit the number of consecutive connection attempts that are retried
+	// after a failed attempt to connect to a remote endpoint. When the limit is exceeded, the TCPSource stops
+	// without an error. A successful connection resets the number of failed attempts.
+	// Every remote endpoint in RemoteAddrs is connected in parallel and counts its failed attempts separately.
+	RetryMax int
+
 	// DialTimeout can be set to time out automatically when connecting to a remote TCP endpoint
 	DialTimeout time.Duration
 
@@ -327,6 +461,11 @@ type TCPSource struct {
 	// send an HTTP request.
 	UseHTTP bool
 
+	// GapTag can be set to mark connection outages in the received data. If this is non-empty, the first sample
+	// received after re-establishing a connection to a remote endpoint receives this tag. The tag value is
+	// the duration between closing the previous connection and receiving that sample.
+	GapTag string
+
 	downloadTasks []*tcpDownloadTask
 	downloadSink  SampleSink
 }
@@ -362,10 +501,12 @@ func (source *TCPSource) Start(wg *sync.WaitGroup) golib.StopChan {
 		source.downloadSink = source.GetSink()
 	}
 	tasks := make(golib.TaskGroup, 0, len(source.RemoteAddrs))
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for _, remote := range source.RemoteAddrs {
 		task := &tcpDownloadTask{
 			source: source,
 			remote: remote,
+			random: rand.New(rand.NewSource(random.Int63())),
 		}
 		source.downloadTasks = append(source.downloadTasks, task)
 		tasks.Add(task)
@@ -387,8 +528,8 @@ func (source *TCPSource) Close() {
 	}
 }
 
-func (source *TCPSource) startStream(conn io.ReadCloser) *SampleInputStream {
-	return source.Reader.Open(conn, source.downloadSink)
+func (source *TCPSource) startStream(conn io.ReadCloser, sink SampleSink) *SampleInputStream {
+	return source.Reader.Open(conn, sink)
 }
 
 // ====================== Internal types ======================
@@ -398,6 +539,13 @@ type tcpDownloadTask struct {
 	remote   string
 	loopTask *golib.LoopTask
 	stream   *SampleInputStream
+
+	// Time when the last connection was closed, used for GapTag
+	disconnected time.Time
+
+	// Only accessed by the loop goroutine
+	failures int
+	random   *rand.Rand
 }
 
 func (task *tcpDownloadTask) Start(wg *sync.WaitGroup) golib.StopChan {
@@ -408,28 +556,58 @@ func (task *tcpDownloadTask) Start(wg *sync.WaitGroup) golib.StopChan {
 				if task.source.PrintErrors {
 					log.WithField("remote", task.remote).Errorln("Error downloading data:", err)
 				}
+				task.failures++
+				if max := task.source.RetryMax; max > 0 && task.failures > max {
+					log.WithField("remote", task.remote).Warnf("Giving up after %v failed connection attempts", task.failures)
+					return golib.StopLoopTask
+				}
 			} else {
+				task.failures = 0
 				task.handleConnection(conn, remote)
 			}
-			stop.WaitTimeout(task.source.RetryInterval)
+			stop.WaitTimeout(task.retryInterval())
 			return nil
 		},
 	}
 	return task.loopTask.Start(wg)
 }
 
+// retryInterval returns the RetryInterval, randomly varied by the RetryJitter.
+func (task *tcpDownloadTask) retryInterval() time.Duration {
+	interval := task.source.RetryInterval
+	jitter := math.Min(task.source.RetryJitter, 1)
+	if jitter <= 0 || task.random == nil {
+		return interval
+	}
+	factor := 1 + jitter*(2*task.random.Float64()-1)
+	return time.Duration(float64(interval) * factor)
+}
+
 func (task *tcpDownloadTask) handleConnection(conn io.ReadCloser, remote string) {
 	task.loopTask.IfNotStopped(func() {
-		task.stream = task.source.startStream(conn)
+		task.stream = task.source.startStream(conn, task.outputSink())
 	})
 	if !task.loopTask.Stopped() {
 		task.stream.ReadTcpSamples(conn, remote, task.isConnectionClosed)
+		task.disconnected = time.Now()
 		if !task.source.countConnectionClosed() {
 			task.source.Close()
 		}
 	}
 }
 
+func (task *tcpDownloadTask) outputSink() SampleSink {
+	sink := task.source.downloadSink
+	if task.source.GapTag != "" && !task.disconnected.IsZero() {
+		sink = &gapTaggingSink{
+			Out:          sink,
+			Tag:          task.source.GapTag,
+			Disconnected: task.disconnected,
+		}
+	}
+	return sink
+}
+
 func (task *tcpDownloadTask) Stop() {
 	task.loopTask.StopFunc(func() {
 		_ = task.stream.Close() // Ignore error
@@ -452,6 +630,25 @@ func (task *tcpDownloadTask) dial() (io.ReadCloser, string, error) {
 	}
 }
 
+// gapTaggingSink sets a tag on the first received sample. The tag value is the time passed since the Disconnected time.
+// It is only used by a single goroutine reading from a SampleInputStream, so no synchronization is needed.
+type gapTaggingSink struct {
+	Out          SampleSink
+	Tag          string
+	Disconnected time.Time
+	tagged       bool
+}
+
+func (s *gapTaggingSink) Sample(sample *Sample, header *Header) error {
+	if !s.tagged {
+		s.tagged = true
+		gap := time.Now().Sub(s.Disconnected)
+		log.WithField("gap", gap).Println("Received first sample after reconnecting")
+		sample.SetTag(s.Tag, gap.String())
+	}
+	return s.Out.Sample(sample, header)
+}
+
 func dialTcp(endpoint string, timeout time.Duration) (*net.TCPConn, string, error) {
 	conn, err := net.DialTimeout("tcp", endpoint, timeout)
 	if err != nil {
@@ -0,0 +1,207 @@
+package bitflow
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultTcpHealthCheckInterval = 2 * time.Second
+
+// TCPPoolSink sends the received Headers and Samples to multiple remote TCP endpoints.
+// It maintains a pool of persistent connections to at most PoolSize of the configured
+// Endpoints. Samples are distributed round-robin among the healthy connections. When writing
+// to a connection fails, it is evicted from the pool. A background routine regularly
+// reconnects to the evicted and not yet connected endpoints, until the pool is full again.
+type TCPPoolSink struct {
+	// AbstractTcpSink contains different configuration options regarding the
+	// marshalling and writing of data to the remote TCP connections.
+	AbstractTcpSink
+
+	// Endpoints are the target TCP endpoints to connect to for sending marshalled data.
+	Endpoints []string
+
+	// PoolSize limits the number of simultaneously open connections. If it is <= 0,
+	// connections to all Endpoints are maintained.
+	PoolSize int
+
+	// HealthCheckInterval defines how often failed connections are evicted and missing connections
+	// are re-established. Defaults to DefaultTcpHealthCheckInterval.
+	HealthCheckInterval time.Duration
+
+	// DialTimeout can be set to time out automatically when connecting to a remote TCP endpoint
+	DialTimeout time.Duration
+
+	targets []*tcpPoolTarget
+	next    int
+	stats   TCPPoolStats
+	lock    sync.Mutex
+	stopped golib.StopChan
+	wg      *sync.WaitGroup
+}
+
+// TCPPoolStats contains statistics about the connections of a TCPPoolSink.
+type TCPPoolStats struct {
+	Targets    int // Number of configured endpoints
+	Healthy    int // Number of currently open connections
+	Evictions  int // Total number of connections that were evicted after failing
+	Reconnects int // Total number of connections that have been established
+}
+
+func (s TCPPoolStats) String() string {
+	return fmt.Sprintf("%v/%v connections healthy (%v evictions, %v connections established)", s.Healthy, s.Targets, s.Evictions, s.Reconnects)
+}
+
+type tcpPoolTarget struct {
+	endpoint string
+	conn     *TcpWriteConn
+}
+
+// String implements the SampleSink interface.
+func (sink *TCPPoolSink) String() string {
+	return fmt.Sprintf("TCP pool sink to %v (pool size %v)", strings.Join(sink.Endpoints, ", "), sink.poolSize())
+}
+
+// Start implements the SampleSink interface. It establishes the initial connections and starts a
+// background routine performing the health checks.
+func (sink *TCPPoolSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
+	sink.connCounterDescription = sink
+	sink.Protocol = "TCP"
+	log.WithField("format", sink.Marshaller).Println("Sending data to", len(sink.Endpoints), "endpoint(s) with a pool size of", sink.poolSize())
+	sink.stopped = golib.NewStopChan()
+	sink.wg = wg
+	sink.targets = make([]*tcpPoolTarget, len(sink.Endpoints))
+	for i, endpoint := range sink.Endpoints {
+		sink.targets[i] = &tcpPoolTarget{endpoint: endpoint}
+	}
+	sink.stats.Targets = len(sink.targets)
+	sink.checkHealth()
+
+	interval := sink.HealthCheckInterval
+	if interval <= 0 {
+		interval = DefaultTcpHealthCheckInterval
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for sink.stopped.WaitTimeout(interval) {
+			sink.checkHealth()
+		}
+	}()
+	return
+}
+
+// Close implements the SampleSink interface. It closes all connections in the pool and stops the health checks.
+func (sink *TCPPoolSink) Close() {
+	sink.stopped.StopFunc(func() {
+		sink.lock.Lock()
+		for _, target := range sink.targets {
+			target.conn.Close()
+			target.conn = nil
+		}
+		sink.stats.Healthy = 0
+		sink.lock.Unlock()
+		sink.CloseSink()
+	})
+}
+
+// Stats returns the current statistics of the connection pool.
+func (sink *TCPPoolSink) Stats() TCPPoolStats {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return sink.stats
+}
+
+// Sample implements the SampleSink interface. The sample is sent to the next healthy connection.
+// If sending fails, the connection is evicted and the next healthy connection is tried.
+func (sink *TCPPoolSink) Sample(sample *Sample, header *Header) error {
+	var err error
+	sink.stopped.IfElseStopped(func() {
+		err = fmt.Errorf("%v already closed", sink)
+	}, func() {
+		err = sink.sendToPool(sample, header)
+	})
+	return sink.AbstractSampleOutput.Sample(err, sample, header)
+}
+
+func (sink *TCPPoolSink) sendToPool(sample *Sample, header *Header) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	for range sink.targets {
+		target := sink.targets[sink.next%len(sink.targets)]
+		sink.next++
+		if !target.conn.IsRunning() {
+			continue
+		}
+		target.conn.Sample(sample, header)
+		if target.conn.IsRunning() {
+			return nil
+		}
+		sink.evict(target)
+	}
+	return fmt.Errorf("%v: No healthy connection available", sink)
+}
+
+// checkHealth evicts failed connections and tries to fill up the pool with new connections.
+func (sink *TCPPoolSink) checkHealth() {
+	sink.lock.Lock()
+	var candidates []*tcpPoolTarget
+	healthy := 0
+	for _, target := range sink.targets {
+		if target.conn != nil && !target.conn.IsRunning() {
+			sink.evict(target)
+		}
+		if target.conn.IsRunning() {
+			healthy++
+		} else {
+			candidates = append(candidates, target)
+		}
+	}
+	sink.stats.Healthy = healthy
+	missing := sink.poolSize() - healthy
+	sink.lock.Unlock()
+
+	// Dial without holding the lock, so that samples can still be sent to the healthy connections
+	for _, target := range candidates {
+		if missing <= 0 || sink.stopped.Stopped() {
+			return
+		}
+		conn, remote, err := dialTcp(target.endpoint, sink.DialTimeout)
+		if err != nil {
+			log.WithField("remote", target.endpoint).Debugln("Failed to connect to pool target:", err)
+			continue
+		}
+		sink.lock.Lock()
+		if sink.stopped.Stopped() {
+			_ = conn.Close() // Drop error
+		} else {
+			target.conn = sink.OpenWriteConn(sink.wg, remote, conn)
+			sink.stats.Healthy++
+			sink.stats.Reconnects++
+			missing--
+		}
+		sink.lock.Unlock()
+	}
+}
+
+// evict must be called while holding the lock
+func (sink *TCPPoolSink) evict(target *tcpPoolTarget) {
+	log.WithField("remote", target.endpoint).Warnln("Evicting failed connection from TCP pool")
+	target.conn.Close()
+	target.conn = nil
+	sink.stats.Evictions++
+	if sink.stats.Healthy > 0 {
+		sink.stats.Healthy--
+	}
+}
+
+func (sink *TCPPoolSink) poolSize() int {
+	if sink.PoolSize <= 0 || sink.PoolSize > len(sink.Endpoints) {
+		return len(sink.Endpoints)
+	}
+	return sink.PoolSize
+}
@@ -1,6 +1,12 @@
 package bitflow
 
 import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -233,3 +239,317 @@ func (suite *TcpListenerTestSuite) TestTcpListenerSourceError() {
 	suite.IsType(new(SourceTaskWrapper), task)
 	suite.Equal(l, task.(*SourceTaskWrapper).SampleSource)
 }
+
+type collectingSampleSink struct {
+	DroppingSampleProcessor
+	samples []*Sample
+}
+
+func (s *collectingSampleSink) Sample(sample *Sample, _ *Header) error {
+	s.samples = append(s.samples, sample)
+	return nil
+}
+
+func (suite *TcpListenerTestSuite) TestTcpSourceGapTag() {
+	listener, err := net.Listen("tcp", "localhost:7879")
+	suite.NoError(err)
+	defer listener.Close() // Drop error
+
+	// Serve one sample per connection and close each connection immediately afterwards
+	header := &Header{Fields: []string{"a"}}
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var m CsvMarshaller
+			_ = m.WriteHeader(header, true, conn)
+			_ = m.WriteSample(&Sample{Time: time.Now(), Values: []Value{Value(i)}}, header, true, conn)
+			_ = conn.Close()
+		}
+	}()
+
+	s := &TCPSource{
+		RemoteAddrs:   []string{"localhost:7879"},
+		RetryInterval: 50 * time.Millisecond,
+		DialTimeout:   tcp_dial_timeout,
+		GapTag:        "gap",
+	}
+	s.TcpConnLimit = 2
+	s.Reader.ParallelSampleHandler = parallel_handler
+	sink := new(collectingSampleSink)
+
+	var group golib.TaskGroup
+	(&SamplePipeline{
+		Source:     s,
+		Processors: []SampleProcessor{sink},
+	}).Construct(&group)
+	group.Add(&golib.TimeoutTask{DumpGoroutines: false, Timeout: 2 * time.Second})
+	_, numErrs := group.WaitAndStop(1 * time.Second)
+	suite.Equal(0, numErrs, "number of errors")
+
+	suite.Len(sink.samples, 2)
+	suite.False(sink.samples[0].HasTag("gap"))
+	suite.True(sink.samples[1].HasTag("gap"))
+	gap, err := time.ParseDuration(sink.samples[1].Tag("gap"))
+	suite.NoError(err)
+	suite.True(gap >= s.RetryInterval, "gap %v should be at least the retry interval", gap)
+}
+
+func (suite *TcpListenerTestSuite) TestTcpSourceRetryMax() {
+	// Nothing listens on the port, so all connection attempts fail
+	s := &TCPSource{
+		RemoteAddrs:   []string{"localhost:7884"},
+		RetryInterval: 20 * time.Millisecond,
+		RetryJitter:   0.5,
+		RetryMax:      3,
+		DialTimeout:   tcp_dial_timeout,
+	}
+	s.Reader.ParallelSampleHandler = parallel_handler
+	sink := new(collectingSampleSink)
+
+	var group golib.TaskGroup
+	(&SamplePipeline{
+		Source:     s,
+		Processors: []SampleProcessor{sink},
+	}).Construct(&group)
+	start := time.Now()
+	group.Add(&golib.TimeoutTask{DumpGoroutines: false, Timeout: 2 * time.Second})
+	task, numErrs := group.WaitAndStop(1 * time.Second)
+	suite.Equal(0, numErrs, "number of errors")
+	suite.IsType(new(SourceTaskWrapper), task, "the source should stop before the timeout")
+	suite.True(time.Since(start) >= 3*s.RetryInterval/2, "the source stopped too early")
+	suite.Empty(sink.samples)
+}
+
+func (suite *TcpListenerTestSuite) TestTcpSourceRetryJitter() {
+	task := &tcpDownloadTask{
+		source: &TCPSource{RetryInterval: time.Second},
+		random: rand.New(rand.NewSource(1)),
+	}
+	suite.Equal(time.Second, task.retryInterval())
+
+	task.source.RetryJitter = 0.2
+	different := false
+	for i := 0; i < 100; i++ {
+		interval := task.retryInterval()
+		suite.True(interval >= 800*time.Millisecond && interval <= 1200*time.Millisecond, "interval out of range: %v", interval)
+		different = different || interval != time.Second
+	}
+	suite.True(different)
+}
+
+// flappingTcpTarget accepts connections and discards all received data until it is stopped
+type flappingTcpTarget struct {
+	listener net.Listener
+	conns    []net.Conn
+	lock     sync.Mutex
+}
+
+func (t *flappingTcpTarget) start(endpoint string) error {
+	listener, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		return err
+	}
+	t.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.lock.Lock()
+			t.conns = append(t.conns, conn)
+			t.lock.Unlock()
+			go func() {
+				_, _ = io.Copy(ioutil.Discard, conn) // Drop error
+			}()
+		}
+	}()
+	return nil
+}
+
+func (t *flappingTcpTarget) stop() {
+	_ = t.listener.Close() // Drop error
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, conn := range t.conns {
+		_ = conn.Close() // Drop error
+	}
+	t.conns = nil
+}
+
+func (suite *TcpListenerTestSuite) waitForPool(sink *TCPPoolSink, cond func(stats TCPPoolStats) bool, sendSamples bool) {
+	header := &Header{Fields: []string{"a"}}
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond(sink.Stats()) {
+		if time.Now().After(deadline) {
+			suite.Fail("Timed out waiting for TCP pool", "Stats: %v", sink.Stats())
+			return
+		}
+		if sendSamples {
+			_ = sink.Sample(&Sample{Time: time.Now(), Values: []Value{1}}, header) // Drop error
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (suite *TcpListenerTestSuite) TestTcpPoolSinkFlappingTarget() {
+	// Suppress warnings about evicted connections
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+	log.SetLevel(log.ErrorLevel)
+
+	stable := new(flappingTcpTarget)
+	suite.NoError(stable.start("localhost:7880"))
+	defer stable.stop()
+	flapping := new(flappingTcpTarget)
+	suite.NoError(flapping.start("localhost:7881"))
+
+	sink := &TCPPoolSink{
+		Endpoints:           []string{"localhost:7880", "localhost:7881"},
+		HealthCheckInterval: 50 * time.Millisecond,
+		DialTimeout:         tcp_dial_timeout,
+	}
+	sink.Writer.ParallelSampleHandler = parallel_handler
+	sink.SetMarshaller(new(CsvMarshaller))
+	sink.SetSink(new(DroppingSampleProcessor))
+	var wg sync.WaitGroup
+	sink.Start(&wg)
+	suite.Equal(TCPPoolStats{Targets: 2, Healthy: 2, Reconnects: 2}, sink.Stats())
+
+	// The failed connection is evicted when sending samples, while the stable connection keeps working
+	flapping.stop()
+	suite.waitForPool(sink, func(stats TCPPoolStats) bool {
+		return stats.Evictions >= 1
+	}, true)
+	stats := sink.Stats()
+	suite.Equal(1, stats.Healthy)
+	suite.Equal(1, stats.Evictions)
+	suite.NoError(sink.Sample(&Sample{Time: time.Now(), Values: []Value{1}}, &Header{Fields: []string{"a"}}))
+
+	// The health check re-adds the target after it comes back
+	suite.NoError(flapping.start("localhost:7881"))
+	defer flapping.stop()
+	suite.waitForPool(sink, func(stats TCPPoolStats) bool {
+		return stats.Healthy == 2
+	}, false)
+	suite.Equal(3, sink.Stats().Reconnects)
+
+	sink.Close()
+	wg.Wait()
+	suite.Equal(0, sink.Stats().Healthy)
+}
+
+func (suite *TcpListenerTestSuite) TestTcpSinkReconnect() {
+	// Suppress warnings about failed connection attempts
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+	log.SetLevel(log.ErrorLevel)
+
+	sink := &TCPSink{
+		Endpoint:         "localhost:7882",
+		DialTimeout:      tcp_dial_timeout,
+		Reconnect:        true,
+		RetryInterval:    10 * time.Millisecond,
+		MaxRetryInterval: 20 * time.Millisecond,
+		BufferedSamples:  3,
+	}
+	sink.Writer.ParallelSampleHandler = parallel_handler
+	sink.SetMarshaller(new(CsvMarshaller))
+	sink.SetSink(new(DroppingSampleProcessor))
+	var wg sync.WaitGroup
+	sink.Start(&wg)
+
+	// Without a running target, samples are buffered instead of returning errors
+	header := &Header{Fields: []string{"a"}}
+	for i := 0; i < 5; i++ {
+		suite.NoError(sink.Sample(&Sample{Time: time.Now(), Values: []Value{Value(i)}}, header))
+		time.Sleep(25 * time.Millisecond) // Wait for the next connection attempt
+	}
+	suite.Equal(20*time.Millisecond, sink.retryInterval, "the retry interval should be limited")
+
+	listener, err := net.Listen("tcp", "localhost:7882")
+	suite.NoError(err)
+	defer listener.Close() // Drop error
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		data, _ := ioutil.ReadAll(conn) // Drop error
+		received <- string(data)
+	}()
+
+	// After the retry interval, the buffered samples are sent before the new sample
+	time.Sleep(50 * time.Millisecond)
+	suite.NoError(sink.Sample(&Sample{Time: time.Now(), Values: []Value{5}}, header))
+	sink.Close()
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(<-received), "\n")
+	suite.Len(lines, 5, "header and 4 samples")
+	for i, line := range lines[1:] {
+		suite.True(strings.HasSuffix(line, ","+strconv.Itoa(i+2)), "sample line %v: %v", i, line)
+	}
+}
+
+func (suite *TcpListenerTestSuite) TestTcpSinkHeaderInterval() {
+	listener, err := net.Listen("tcp", "localhost:7883")
+	suite.NoError(err)
+	defer listener.Close() // Drop error
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- ""
+			return
+		}
+		data, _ := ioutil.ReadAll(conn) // Drop error
+		received <- string(data)
+	}()
+
+	sink := &TCPSink{
+		Endpoint:    "localhost:7883",
+		DialTimeout: tcp_dial_timeout,
+	}
+	sink.Writer.ParallelSampleHandler = parallel_handler
+	sink.Writer.HeaderInterval = 50 * time.Millisecond
+	sink.SetMarshaller(new(CsvMarshaller))
+	sink.SetSink(new(DroppingSampleProcessor))
+	var wg sync.WaitGroup
+	sink.Start(&wg)
+
+	// All samples are sent over one connection, the header is repeated after every pause
+	header := &Header{Fields: []string{"a"}}
+	for i := 0; i < 6; i++ {
+		suite.NoError(sink.Sample(&Sample{Time: time.Now(), Values: []Value{Value(i)}}, header))
+		if i%2 == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	sink.Close()
+	wg.Wait()
+
+	data := <-received
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	suite.Len(lines, 9, "6 samples and 3 headers")
+	suite.Equal(3, strings.Count(data, "time,tags,a\n"), "number of headers")
+
+	// The data stays readable, the repeated headers are recognized by the unmarshaller
+	read := new(collectingSampleSink)
+	reader := SampleReader{ParallelSampleHandler: parallel_handler, Unmarshaller: new(CsvMarshaller)}
+	_, err = reader.Open(ioutil.NopCloser(strings.NewReader(data)), read).ReadSamples("test")
+	suite.NoError(err)
+	suite.Len(read.samples, 6)
+}
+
+func (suite *TcpListenerTestSuite) TestHeaderRepeatable() {
+	suite.True(HeaderRepeatable(CsvMarshaller{}))
+	suite.True(HeaderRepeatable(new(BinaryMarshaller)))
+	suite.False(HeaderRepeatable(TextMarshaller{}))
+	suite.False(HeaderRepeatable(JsonMarshaller{}))
+}
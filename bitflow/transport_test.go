@@ -3,7 +3,14 @@ package bitflow
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/antongulenko/golib"
 	"github.com/stretchr/testify/suite"
@@ -123,6 +130,56 @@ func (suite *TransportStreamTestSuite) TestTransport_BinaryMarshallerMulti() {
 	suite.testAllHeaders(new(BinaryMarshaller))
 }
 
+func (suite *TransportStreamTestSuite) testOutputMetadata(m BidiMarshaller) {
+	metadata := NewOutputMetadata("input -> avg() -> output, with special=chars")
+	buf := closingBuffer{
+		suite: suite,
+	}
+	writer := SampleWriter{
+		ParallelSampleHandler: parallel_handler,
+		Metadata:              metadata,
+	}
+	stream := writer.Open(&buf, m)
+	header := &Header{Fields: []string{"a", "b"}}
+	samples := []*Sample{
+		{Values: []Value{1, 2}, Time: suite.nextTimestamp()},
+		{Values: []Value{3, 4}, Time: suite.nextTimestamp()},
+	}
+	samples[0].SetTag("x", "y")
+	for _, sample := range samples {
+		suite.NoError(stream.Sample(sample, header))
+	}
+	suite.NoError(stream.Close())
+	suite.Equal(map[string]string{"x": "y"}, samples[0].TagMap(), "original sample must not be modified")
+
+	sink := new(collectingSampleSink)
+	reader := SampleReader{
+		ParallelSampleHandler: parallel_handler,
+		Unmarshaller:          m,
+	}
+	num, err := reader.Open(&countingBuf{data: buf.Bytes()}, sink).ReadSamples("test")
+	suite.NoError(err)
+	suite.Equal(2, num)
+
+	readMetadata, err := GetOutputMetadata(sink.samples[0])
+	suite.NoError(err)
+	suite.Equal(metadata, readMetadata)
+	suite.Equal("y", sink.samples[0].Tag("x"))
+	suite.Equal([]Value{1, 2}, sink.samples[0].Values)
+
+	readMetadata, err = GetOutputMetadata(sink.samples[1])
+	suite.NoError(err)
+	suite.Empty(readMetadata)
+}
+
+func (suite *TransportStreamTestSuite) TestTransport_CsvOutputMetadata() {
+	suite.testOutputMetadata(new(CsvMarshaller))
+}
+
+func (suite *TransportStreamTestSuite) TestTransport_BinaryOutputMetadata() {
+	suite.testOutputMetadata(new(BinaryMarshaller))
+}
+
 func (suite *TransportStreamTestSuite) TestAllocateSample() {
 	var pipe SamplePipeline
 	pipe.
@@ -149,6 +206,135 @@ func (suite *TransportStreamTestSuite) TestAllocateSample() {
 	suite.Equal(22, size)
 }
 
+func (suite *TransportStreamTestSuite) readValueCountPolicy(policy ValueCountPolicy, line string) ([]Value, error) {
+	reader := SampleReader{
+		ParallelSampleHandler: parallel_handler,
+		Unmarshaller:          CsvMarshaller{},
+		ValueCountPolicy:      policy,
+	}
+	sink := new(collectingSampleSink)
+	input := "time,a,b\n" + line + "\n"
+	stream := reader.Open(ioutil.NopCloser(strings.NewReader(input)), sink)
+	num, err := stream.ReadSamples("test")
+	if err != nil {
+		return nil, err
+	}
+	suite.Equal(1, num)
+	suite.Len(sink.samples, 1)
+	return sink.samples[0].Values, nil
+}
+
+func (suite *TransportStreamTestSuite) TestMaxLineLengthAutoDetected() {
+	for _, start := range []string{"time,a\n", binary_time_col + "\n"} {
+		reader := SampleReader{
+			ParallelSampleHandler: parallel_handler,
+			MaxLineLength:         1024,
+		}
+		input := ioutil.NopCloser(io.MultiReader(strings.NewReader(start), endlessBuf{}))
+		_, err := reader.Open(input, new(collectingSampleSink)).ReadSamples("test")
+		suite.Error(err, "input %q", start)
+		suite.Contains(err.Error(), LineTooLongError{MaxLength: 1024}.Error(), "input %q", start)
+	}
+}
+
+func (suite *TransportStreamTestSuite) TestValueCountPolicy() {
+	const short = "2019-01-01 10:00:00,1"
+	const long = "2019-01-01 10:00:00,1,2,3"
+	const correct = "2019-01-01 10:00:00,1,2"
+
+	for _, policy := range []ValueCountPolicy{"", ValueCountStrict, ValueCountPad, ValueCountTruncate, ValueCountAdjust} {
+		values, err := suite.readValueCountPolicy(policy, correct)
+		suite.NoError(err, "policy %v", policy)
+		suite.Equal([]Value{1, 2}, values, "policy %v", policy)
+
+		values, err = suite.readValueCountPolicy(policy, short)
+		if policy == ValueCountPad || policy == ValueCountAdjust {
+			suite.NoError(err, "policy %v", policy)
+			suite.Len(values, 2)
+			suite.Equal(Value(1), values[0])
+			suite.True(math.IsNaN(float64(values[1])), "policy %v", policy)
+		} else {
+			suite.Error(err, "policy %v", policy)
+		}
+
+		values, err = suite.readValueCountPolicy(policy, long)
+		if policy == ValueCountTruncate || policy == ValueCountAdjust {
+			suite.NoError(err, "policy %v", policy)
+			suite.Equal([]Value{1, 2}, values, "policy %v", policy)
+		} else {
+			suite.Error(err, "policy %v", policy)
+		}
+	}
+
+	policy, err := ParseValueCountPolicy("")
+	suite.NoError(err)
+	suite.Equal(ValueCountStrict, policy)
+	_, err = ParseValueCountPolicy("invalid")
+	suite.Error(err)
+}
+
+type notifyingSampleSink struct {
+	DroppingSampleProcessor
+	received chan *Sample
+}
+
+func (s *notifyingSampleSink) Sample(sample *Sample, _ *Header) error {
+	s.received <- sample
+	return nil
+}
+
+func (suite *TransportStreamTestSuite) receiveSample(sink *notifyingSampleSink) *Sample {
+	select {
+	case sample := <-sink.received:
+		return sample
+	case <-time.After(2 * time.Second):
+		suite.FailNow("Timed out waiting for sample")
+		return nil
+	}
+}
+
+func (suite *TransportStreamTestSuite) TestReaderSourceKeepAlive() {
+	file, err := ioutil.TempFile("", "bitflow-keep-alive-")
+	suite.NoError(err)
+	defer os.Remove(file.Name()) // Drop error
+	defer file.Close()           // Drop error
+	write := func(data string) {
+		_, err := file.WriteString(data)
+		suite.NoError(err)
+	}
+	write("time,a\n2019-01-01 10:00:00,1\n")
+
+	input, err := os.Open(file.Name())
+	suite.NoError(err)
+	source := &ReaderSource{
+		Input:                 input,
+		Description:           "test",
+		KeepAlive:             true,
+		KeepAlivePollInterval: 10 * time.Millisecond,
+	}
+	source.Reader = SampleReader{
+		ParallelSampleHandler: parallel_handler,
+		Unmarshaller:          CsvMarshaller{},
+	}
+	sink := &notifyingSampleSink{received: make(chan *Sample, 10)}
+	source.SetSink(sink)
+	var wg sync.WaitGroup
+	source.Start(&wg)
+	suite.Equal([]Value{1}, suite.receiveSample(sink).Values)
+
+	// The source keeps waiting after reaching the end of the input, and continues with appended data
+	time.Sleep(50 * time.Millisecond)
+	write("2019-01-01 10:00:01,2\n")
+	suite.Equal([]Value{2}, suite.receiveSample(sink).Values)
+	time.Sleep(50 * time.Millisecond)
+	write("2019-01-01 10:00:02,3\n")
+	suite.Equal([]Value{3}, suite.receiveSample(sink).Values)
+
+	source.Close()
+	wg.Wait()
+	suite.Empty(sink.received)
+}
+
 var _ ResizingSampleProcessor = new(resizingTestSink)
 
 type resizingTestSink struct {
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/antongulenko/golib"
 )
@@ -19,6 +20,28 @@ import (
 // to output streams, like FileSink or TCPSink.
 type SampleWriter struct {
 	ParallelSampleHandler
+
+	// Metadata can be set to store provenance information in every opened output stream.
+	// The entries are added as tags to the first sample written to each stream (see AddOutputMetadata).
+	Metadata map[string]string
+
+	// HeaderInterval can be set to periodically repeat the current header in long-lived output streams,
+	// even if it did not change. This allows receivers that joined in the middle of a stream (e.g. after
+	// being buffered by a middlebox) to recover. The header is repeated before the first sample that is written
+	// after the interval expired. Only the CSV and binary formats support repeated headers (see HeaderRepeatable),
+	// other formats ignore this setting.
+	HeaderInterval time.Duration
+}
+
+// HeaderRepeatable returns true, if the given Marshaller produces data that can be unmarshalled
+// when the same header is written multiple times within one stream. This is the case for the CSV and
+// binary formats, because their Unmarshallers recognize a new header between any two samples.
+func HeaderRepeatable(m Marshaller) bool {
+	switch m.(type) {
+	case CsvMarshaller, *CsvMarshaller, BinaryMarshaller, *BinaryMarshaller:
+		return true
+	}
+	return false
 }
 
 // SampleOutputStream represents one open output stream that marshals and writes
@@ -33,6 +56,9 @@ type SampleOutputStream struct {
 	writer         io.WriteCloser
 	marshaller     Marshaller
 	marshallBuffer int
+	metadata       map[string]string
+	metadataOnce   sync.Once
+	headerInterval time.Duration
 }
 
 // BufferedWriteCloser is a helper type that wraps a bufio.Writer around a
@@ -79,9 +105,17 @@ func (w *SampleWriter) OpenBuffered(writer io.WriteCloser, marshaller Marshaller
 // Marshalling and writing is done in separate routines, as configured in the SampleWriter
 // configuration parameters.
 func (w *SampleWriter) Open(writer io.WriteCloser, marshaller Marshaller) *SampleOutputStream {
+	parallel := w.ParallelParsers
+	if stateful, ok := marshaller.(StatefulMarshaller); ok {
+		if streamMarshaller := stateful.NewStreamMarshaller(); streamMarshaller != nil {
+			marshaller = streamMarshaller
+			parallel = 1
+		}
+	}
 	stream := &SampleOutputStream{
 		writer:     writer,
 		marshaller: marshaller,
+		metadata:   w.Metadata,
 		incoming:   make(chan *bufferedOutputSample, w.BufferedSamples),
 		outgoing:   make(chan *bufferedOutputSample, w.BufferedSamples),
 		parallelSampleStream: parallelSampleStream{
@@ -89,7 +123,11 @@ func (w *SampleWriter) Open(writer io.WriteCloser, marshaller Marshaller) *Sampl
 		},
 	}
 
-	for i := 0; i < w.ParallelParsers || i < 1; i++ {
+	if HeaderRepeatable(marshaller) {
+		stream.headerInterval = w.HeaderInterval
+	}
+
+	for i := 0; i < parallel || i < 1; i++ {
 		stream.wg.Add(1)
 		go stream.marshall()
 	}
@@ -113,6 +151,13 @@ func (stream *SampleOutputStream) Sample(sample *Sample, header *Header) error {
 	if stream.hasError() {
 		return stream.getErrorNoEOF()
 	}
+	if len(stream.metadata) > 0 {
+		stream.metadataOnce.Do(func() {
+			// Avoid modifying the sample, since it is also forwarded to other processors
+			sample = sample.Clone()
+			AddOutputMetadata(sample, stream.metadata)
+		})
+	}
 	bufferedSample := &bufferedOutputSample{
 		header: header,
 		bufferedSample: bufferedSample{
@@ -178,6 +223,7 @@ func (stream *SampleOutputStream) marshallOne(sample *bufferedOutputSample) {
 func (stream *SampleOutputStream) flush() {
 	defer stream.wg.Done()
 	var checker HeaderChecker
+	var lastHeader time.Time
 	// TODO possible leak: errors in the output writer are only detected when a sample
 	// is written. When no more samples come into this stream, errors will not be detected and
 	// this SampleOutputStream will linger around. Only solution would be to periodically check
@@ -191,7 +237,8 @@ func (stream *SampleOutputStream) flush() {
 		if stream.hasError() {
 			break
 		}
-		if checker.HeaderChanged(sample.header) {
+		if checker.HeaderChanged(sample.header) || (stream.headerInterval > 0 && time.Since(lastHeader) >= stream.headerInterval) {
+			lastHeader = time.Now()
 			if err := stream.marshaller.WriteHeader(sample.header, true, stream.writer); stream.addError(err) {
 				break
 			}
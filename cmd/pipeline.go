@@ -6,9 +6,11 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/antongulenko/golib"
 	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
 	"github.com/bitflow-stream/go-bitflow/script/plugin"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	"github.com/bitflow-stream/go-bitflow/script/script"
@@ -26,6 +28,15 @@ type CmdPipelineBuilder struct {
 	printCapabilities bool
 	useOldScript      bool
 	pluginPaths       golib.StringSlice
+
+	checkpointFile     string
+	checkpointInterval time.Duration
+
+	mergeOrdered     bool
+	mergeMaxLateness time.Duration
+	mergeIdleTimeout time.Duration
+
+	batchWorkers int
 }
 
 func (c *CmdPipelineBuilder) RegisterFlags() {
@@ -34,6 +45,12 @@ func (c *CmdPipelineBuilder) RegisterFlags() {
 	flag.BoolVar(&c.printCapabilities, "capabilities", false, "Print the capabilities of this pipeline in JSON form and exit.")
 	flag.BoolVar(&c.useOldScript, "old", false, "Use the old script parser for processing the input script.")
 	flag.Var(&c.pluginPaths, "p", "Plugins to load for additional functionality")
+	flag.StringVar(&c.checkpointFile, "checkpoint", "", "Periodically store the state of stateful processing steps in the given file, and restore it on startup. Only applies to top-level steps of the pipeline.")
+	flag.DurationVar(&c.checkpointInterval, "checkpoint-interval", 1*time.Minute, "Interval for storing the state of processing steps, when -checkpoint is set")
+	flag.BoolVar(&c.mergeOrdered, "merge-ordered", false, "When merging multiple input pipelines, buffer the samples and forward them ordered by their timestamps.")
+	flag.DurationVar(&c.mergeMaxLateness, "merge-max-lateness", 0, "With -merge-ordered, forward buffered samples at the latest when a sample that is newer by the given duration was received. 0 means no bound.")
+	flag.DurationVar(&c.mergeIdleTimeout, "merge-idle-timeout", 5*time.Second, "With -merge-ordered, ignore input pipelines that did not deliver samples for the given duration. 0 means waiting indefinitely.")
+	flag.IntVar(&c.batchWorkers, "batch-workers", 0, "Flush batches asynchronously, using a shared pool with the given number of workers. Allows batches in different forked pipelines to be processed in parallel. 0 means flushing synchronously.")
 
 	c.ProcessorRegistry = reg.NewProcessorRegistry()
 	c.Endpoints.RegisterGeneralFlagsTo(flag.CommandLine)
@@ -56,12 +73,28 @@ func (c *CmdPipelineBuilder) BuildPipeline(script string) (*bitflow.SamplePipeli
 		return nil, nil
 	}
 
+	c.Endpoints.PipelineDescription = script
+	if c.batchWorkers > 0 {
+		bitflow.DefaultBatchWorkerPool = bitflow.NewBatchWorkerPool(c.batchWorkers)
+	}
 	make_pipeline := make_pipeline_new
 	if c.useOldScript {
 		log.Println("Running using Go-only script implementation")
 		make_pipeline = make_pipeline_old
 	}
-	return make_pipeline(c.ProcessorRegistry, script)
+	pipe, err := make_pipeline(c.ProcessorRegistry, script)
+	if err == nil && pipe != nil && c.mergeOrdered {
+		if multiInput, ok := pipe.Source.(*fork.MultiMetricSource); ok {
+			multiInput.Ordered = true
+			multiInput.MaxLateness = c.mergeMaxLateness
+			multiInput.IdleTimeout = c.mergeIdleTimeout
+		}
+	}
+	if err == nil && pipe != nil && c.checkpointFile != "" {
+		coordinator := bitflow.NewCheckpointCoordinator(c.checkpointFile, c.checkpointInterval, pipe.Processors)
+		pipe.Processors = append([]bitflow.SampleProcessor{coordinator}, pipe.Processors...)
+	}
+	return pipe, err
 }
 
 func (c *CmdPipelineBuilder) PrintPipeline(pipe *bitflow.SamplePipeline) *bitflow.SamplePipeline {
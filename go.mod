@@ -1,31 +1,42 @@
 module github.com/bitflow-stream/go-bitflow
 
+go 1.16
+
 require (
 	github.com/Knetic/govaluate v3.0.0+incompatible
+	github.com/Shopify/sarama v1.21.0
 	github.com/aclements/go-moremath v0.0.0-20180329182055-b1aff36309c7 // indirect
 	github.com/antlr/antlr4 v0.0.0-20190207013812-1c6c62afc7cb
 	github.com/antongulenko/go-onlinestats v0.0.0-20160514060630-5ff69410145c
 	github.com/antongulenko/golearn v0.0.0-20180917161504-d3c9efc653e9
 	github.com/antongulenko/golib v0.0.9
+	github.com/aws/aws-sdk-go v1.30.19
 	github.com/bugsnag/bugsnag-go v1.4.0
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/gin-gonic/gin v1.3.0
 	github.com/go-ini/ini v1.41.0
 	github.com/gonum/blas v0.0.0-20181208220705-f22b278b28ac // indirect
 	github.com/gopherjs/gopherjs v0.0.0-20181103185306-d547d1d9531e // indirect
 	github.com/gorilla/mux v1.7.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/jtolds/gls v4.2.1+incompatible // indirect
 	github.com/ktye/fft v0.0.0-20160109133121-5beb24bb6a43
 	github.com/lucasb-eyer/go-colorful v0.0.0-20181028223441-12d3b2882a08
+	github.com/mattn/go-sqlite3 v1.10.0
+	github.com/mochi-co/mqtt v1.0.5
 	github.com/ryanuber/go-glob v1.0.0
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.3.0
 	github.com/smartystreets/assertions v0.0.0-20190215210624-980c5ac6f3ac // indirect
 	github.com/smartystreets/goconvey v0.0.0-20181108003508-044398e4856c // indirect
-	github.com/stretchr/testify v1.3.0
-	github.com/xlab/handysort v0.0.0-20150421192137-fb3537ed64a1 // indirect
+	github.com/stretchr/testify v1.7.0
+	github.com/xitongsys/parquet-go v1.5.4
+	github.com/xitongsys/parquet-go-source v0.0.0-20201108113611-f372b7d813be
 	gonum.org/v1/gonum v0.0.0-20190215220711-70a1e933af10
 	gonum.org/v1/netlib v0.0.0-20190119082159-9be13e02fd56 // indirect
 	gonum.org/v1/plot v0.0.0-20190211101258-b99b24273ab4
+	google.golang.org/grpc v1.38.0
+	gopkg.in/DATA-DOG/go-sqlmock.v1 v1.3.0 // indirect
 	gopkg.in/ini.v1 v1.41.0 // indirect
 	vbom.ml/util v0.0.0-20180919145318-efcd4e0f9787
 )
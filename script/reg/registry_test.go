@@ -3,8 +3,10 @@ package reg
 // TODO implement tests
 
 import (
+	"flag"
 	"testing"
 
+	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
@@ -31,6 +33,41 @@ func (suite *processorRegistryTestSuite) TestGivenRegisteredStep_whenGetStep_ret
 
 }
 
+func (suite *processorRegistryTestSuite) parseEndpointFlags(args ...string) ProcessorRegistry {
+	registry := NewProcessorRegistry()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	registry.Endpoints.RegisterGeneralFlagsTo(fs)
+	registry.Endpoints.RegisterInputFlagsTo(fs)
+	registry.Endpoints.RegisterOutputFlagsTo(fs)
+	suite.NoError(fs.Parse(args))
+	return registry
+}
+
+func (suite *processorRegistryTestSuite) TestGivenBinaryFlags_whenCreateOutput_configureMarshaller() {
+	registry := suite.parseEndpointFlags("-binary-tag-dictionary", "100")
+
+	marshaller, err := registry.Endpoints.CreateMarshaller(bitflow.BinaryFormat)
+	suite.NoError(err)
+	suite.Equal(bitflow.BinaryMarshaller{TagDictionary: 100}, marshaller)
+
+	sink, err := registry.Endpoints.CreateOutput("std+bin://-")
+	suite.NoError(err)
+	suite.Equal(bitflow.BinaryMarshaller{TagDictionary: 100}, sink.(*bitflow.WriterSink).Marshaller)
+}
+
+func (suite *processorRegistryTestSuite) TestGivenCsvFlags_whenCreateOutput_configureMarshaller() {
+	registry := suite.parseEndpointFlags("-csv-separator", ";", "-csv-quote", "-csv-int", "-csv-time-format", bitflow.CsvRFC3339TimeFormat)
+
+	sink, err := registry.Endpoints.CreateOutput("std+csv://-")
+	suite.NoError(err)
+	csv, ok := sink.(*bitflow.WriterSink).Marshaller.(bitflow.CsvMarshaller)
+	suite.True(ok)
+	suite.Equal(';', csv.Separator)
+	suite.True(csv.QuoteFields)
+	suite.True(csv.IntegerValues)
+	suite.Equal(bitflow.CsvRFC3339TimeFormat, csv.TimeFormat)
+}
+
 /*
 
 type pipeTestSuite struct {
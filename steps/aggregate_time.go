@@ -0,0 +1,157 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	TimeBucketMean = "mean"
+	TimeBucketMax  = "max"
+	TimeBucketMin  = "min"
+	TimeBucketLast = "last"
+	TimeBucketSum  = "sum"
+)
+
+func RegisterTimeBucketAggregator(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("aggregate_time",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &TimeBucketAggregator{
+				Interval: reg.DurationParam(params, "interval", 0, false, &err),
+				Func:     reg.StrParam(params, "func", TimeBucketMean, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			if step.Interval <= 0 {
+				return reg.ParameterError("interval", errors.New("Must be > 0"))
+			}
+			switch step.Func {
+			case TimeBucketMean, TimeBucketMax, TimeBucketMin, TimeBucketLast, TimeBucketSum:
+			default:
+				return reg.ParameterError("func", fmt.Errorf("Must be one of %v, %v, %v, %v, %v", TimeBucketMean, TimeBucketMax, TimeBucketMin, TimeBucketLast, TimeBucketSum))
+			}
+			p.Batch(step)
+			return
+		},
+		"Group the samples of a batch into buckets of the given time interval, aligned to multiples of the interval since the Unix epoch, "+
+			"and output one sample per bucket. The values are aggregated with the given func: mean (default), max, min, last or sum. "+
+			"NaN values are ignored. The output samples have the timestamp of the start of the bucket and the tags of the first sample in the bucket. Empty buckets are skipped.",
+		reg.RequiredParams("interval"), reg.OptionalParams("func"), reg.SupportBatch())
+}
+
+// TimeBucketAggregator downsamples a batch by grouping the samples into buckets of the width Interval, and
+// aggregating the values of every bucket with the function defined by Func. NaN values are ignored; metrics
+// without any other values in a bucket are NaN. The output samples are sorted by their timestamp, which is
+// the start of the bucket.
+type TimeBucketAggregator struct {
+	Interval time.Duration
+	Func     string
+}
+
+type timeBucket struct {
+	start  int64 // Nanoseconds since the Unix epoch
+	sample *bitflow.Sample
+	values []float64
+	counts []int
+	last   []time.Time
+}
+
+func (agg *TimeBucketAggregator) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	buckets := make(map[int64]*timeBucket)
+	for _, sample := range samples {
+		if len(sample.Values) != len(header.Fields) {
+			return nil, nil, fmt.Errorf("%v: Sample has %v values, but header has %v fields", agg, len(sample.Values), len(header.Fields))
+		}
+		start := agg.bucketStart(sample.Time)
+		bucket, ok := buckets[start]
+		if !ok {
+			bucket = agg.newBucket(start, sample)
+			buckets[start] = bucket
+		}
+		agg.add(bucket, sample)
+	}
+
+	result := make([]*bitflow.Sample, 0, len(buckets))
+	for _, bucket := range buckets {
+		sample := bucket.sample
+		sample.Time = time.Unix(0, bucket.start)
+		for i, val := range bucket.values {
+			if bucket.counts[i] == 0 {
+				val = math.NaN()
+			} else if agg.Func == TimeBucketMean {
+				val /= float64(bucket.counts[i])
+			}
+			sample.Values[i] = bitflow.Value(val)
+		}
+		result = append(result, sample)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return header, result, nil
+}
+
+func (agg *TimeBucketAggregator) bucketStart(t time.Time) int64 {
+	nanos := t.UnixNano()
+	remainder := nanos % int64(agg.Interval)
+	if remainder < 0 {
+		remainder += int64(agg.Interval)
+	}
+	return nanos - remainder
+}
+
+func (agg *TimeBucketAggregator) newBucket(start int64, sample *bitflow.Sample) *timeBucket {
+	bucket := &timeBucket{
+		start:  start,
+		sample: sample, // The first sample of every bucket is reused for the output
+		values: make([]float64, len(sample.Values)),
+		counts: make([]int, len(sample.Values)),
+	}
+	if agg.Func == TimeBucketLast {
+		bucket.last = make([]time.Time, len(sample.Values))
+	}
+	return bucket
+}
+
+func (agg *TimeBucketAggregator) add(bucket *timeBucket, sample *bitflow.Sample) {
+	for i, value := range sample.Values {
+		val := float64(value)
+		if math.IsNaN(val) {
+			continue
+		}
+		first := bucket.counts[i] == 0
+		bucket.counts[i]++
+		switch agg.Func {
+		case TimeBucketMean, TimeBucketSum:
+			bucket.values[i] += val
+		case TimeBucketMax:
+			if first || val > bucket.values[i] {
+				bucket.values[i] = val
+			}
+		case TimeBucketMin:
+			if first || val < bucket.values[i] {
+				bucket.values[i] = val
+			}
+		case TimeBucketLast:
+			if first || !sample.Time.Before(bucket.last[i]) {
+				bucket.values[i] = val
+				bucket.last[i] = sample.Time
+			}
+		}
+	}
+}
+
+func (agg *TimeBucketAggregator) AcceptsEmptyBatches() bool {
+	return true
+}
+
+func (agg *TimeBucketAggregator) String() string {
+	return fmt.Sprintf("Aggregate time buckets of %v (%v)", agg.Interval, agg.Func)
+}
@@ -0,0 +1,61 @@
+package steps
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _timeBucketSamples() []*bitflow.Sample {
+	base := time.Unix(600, 0) // Aligned to 1 minute
+	offsets := []time.Duration{130 * time.Second, 5 * time.Second, 59 * time.Second, 190 * time.Second, 140 * time.Second}
+	values := [][]bitflow.Value{{10, 1}, {1, 2}, {3, bitflow.Value(math.NaN())}, {5, 6}, {20, 3}}
+	samples := make([]*bitflow.Sample, len(offsets))
+	for i, offset := range offsets {
+		samples[i] = &bitflow.Sample{Time: base.Add(offset), Values: values[i]}
+		samples[i].SetTag("index", string(rune('a'+i)))
+	}
+	return samples
+}
+
+func TestTimeBucketAggregator(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"x", "y"}}
+	expected := map[string][][]bitflow.Value{
+		TimeBucketMean: {{2, 2}, {15, 2}, {5, 6}},
+		TimeBucketMax:  {{3, 2}, {20, 3}, {5, 6}},
+		TimeBucketMin:  {{1, 2}, {10, 1}, {5, 6}},
+		TimeBucketLast: {{3, 2}, {20, 3}, {5, 6}},
+		TimeBucketSum:  {{4, 2}, {30, 4}, {5, 6}},
+	}
+	for function, expectedValues := range expected {
+		agg := &TimeBucketAggregator{Interval: time.Minute, Func: function}
+		outHeader, samples, err := agg.ProcessBatch(header, _timeBucketSamples())
+		assert.NoError(err)
+		assert.Equal(header, outHeader)
+		assert.Len(samples, 3, "function %v", function)
+		for i, sample := range samples {
+			assert.Equal(expectedValues[i], sample.Values, "function %v, bucket %v", function, i)
+		}
+		// The bucket at 660 is empty and skipped
+		assert.Equal([]int64{600, 720, 780}, []int64{samples[0].Time.Unix(), samples[1].Time.Unix(), samples[2].Time.Unix()})
+		assert.Equal([]string{"b", "a", "d"}, []string{samples[0].Tag("index"), samples[1].Tag("index"), samples[2].Tag("index")})
+	}
+}
+
+func TestTimeBucketAggregatorEmpty(t *testing.T) {
+	assert := testAssert.New(t)
+	agg := &TimeBucketAggregator{Interval: time.Minute, Func: TimeBucketMean}
+	header := &bitflow.Header{Fields: []string{"x"}}
+	_, samples, err := agg.ProcessBatch(header, nil)
+	assert.NoError(err)
+	assert.Empty(samples)
+
+	samples = []*bitflow.Sample{{Time: time.Unix(0, 0), Values: []bitflow.Value{bitflow.Value(math.NaN())}}}
+	_, samples, err = agg.ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.True(math.IsNaN(float64(samples[0].Values[0])))
+}
@@ -0,0 +1,138 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AnomalyEventStartTag = "anomaly-start"
+	AnomalyEventEndTag   = "anomaly-end"
+	AnomalyEventCountTag = "anomaly-count"
+)
+
+func RegisterAnomalyCoalescer(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("coalesce_anomalies",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &AnomalyCoalescer{
+				Cooldown:     reg.DurationParam(params, "cooldown", 0, false, &err),
+				NodeTag:      reg.StrParam(params, "node-tag", "", true, &err),
+				StateTag:     reg.StrParam(params, "state-tag", DefaultAnomalyStateTag, true, &err),
+				AnomalyValue: reg.StrParam(params, "anomaly-value", DefaultAnomalyStateValue, true, &err),
+			}
+			if err == nil && step.Cooldown <= 0 {
+				err = reg.ParameterError("cooldown", errors.New("Must be positive"))
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		"Coalesce anomalous samples into events. A sample is anomalous when its state-tag has the value anomaly-value. Consecutive anomalous samples are suppressed "+
+			"and replaced by one event sample, which is emitted when no further anomalous sample arrived within the cooldown. The event sample has the values and tags "+
+			"of the first anomalous sample, the timestamp of the last one, and the additional tags "+AnomalyEventStartTag+", "+AnomalyEventEndTag+" and "+AnomalyEventCountTag+". "+
+			"Other samples are forwarded unchanged. When node-tag is given, every value of that tag has separate events.",
+		reg.RequiredParams("cooldown"), reg.OptionalParams("node-tag", "state-tag", "anomaly-value"))
+}
+
+// AnomalyCoalescer reduces bursts of anomalous samples to single events. Samples with the StateTag value AnomalyValue
+// are not forwarded, but collected into an event, until no anomalous sample arrived for the Cooldown duration. Then, one
+// sample representing the event is emitted: it contains the values and tags of the first sample of the event, the timestamp
+// of the last sample, and the tags AnomalyEventStartTag and AnomalyEventEndTag (both formatted as RFC 3339), and AnomalyEventCountTag.
+// The cooldown is evaluated based on the timestamps of the incoming samples, and all remaining events are emitted when the step is closed.
+// Samples that are not anomalous are forwarded unchanged. If NodeTag is set, every value of that tag has separate events.
+type AnomalyCoalescer struct {
+	bitflow.NoopProcessor
+	Cooldown     time.Duration
+	NodeTag      string
+	StateTag     string
+	AnomalyValue string
+
+	events map[string]*anomalyEvent
+}
+
+type anomalyEvent struct {
+	bitflow.SampleAndHeader
+	start time.Time
+	end   time.Time
+	count int
+}
+
+func (c *AnomalyCoalescer) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if c.events == nil {
+		c.events = make(map[string]*anomalyEvent)
+	}
+	if err := c.emitEvents(sample.Time); err != nil {
+		return err
+	}
+	if sample.Tag(c.StateTag) != c.AnomalyValue {
+		return c.NoopProcessor.Sample(sample, header)
+	}
+
+	var node string
+	if c.NodeTag != "" {
+		node = sample.Tag(c.NodeTag)
+	}
+	if event, ok := c.events[node]; ok {
+		event.count++
+		if sample.Time.After(event.end) {
+			event.end = sample.Time
+		}
+	} else {
+		c.events[node] = &anomalyEvent{
+			SampleAndHeader: bitflow.SampleAndHeader{Sample: sample, Header: header},
+			start:           sample.Time,
+			end:             sample.Time,
+			count:           1,
+		}
+	}
+	return nil
+}
+
+// emitEvents emits all events that ended more than Cooldown before the given time. A zero time emits all events.
+func (c *AnomalyCoalescer) emitEvents(now time.Time) error {
+	var nodes []string
+	for node, event := range c.events {
+		if now.IsZero() || now.Sub(event.end) > c.Cooldown {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		event := c.events[node]
+		delete(c.events, node)
+		sample := event.Sample
+		sample.Time = event.end
+		sample.SetTag(AnomalyEventStartTag, event.start.Format(time.RFC3339Nano))
+		sample.SetTag(AnomalyEventEndTag, event.end.Format(time.RFC3339Nano))
+		sample.SetTag(AnomalyEventCountTag, strconv.Itoa(event.count))
+		if err := c.NoopProcessor.Sample(sample, event.Header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *AnomalyCoalescer) Close() {
+	defer c.CloseSink()
+	if err := c.emitEvents(time.Time{}); err != nil {
+		err = fmt.Errorf("Error flushing anomaly events: %v", err)
+		log.Errorln(err)
+		c.Error(err)
+	}
+}
+
+func (c *AnomalyCoalescer) String() string {
+	res := fmt.Sprintf("Coalesce %v=%v with cooldown %v", c.StateTag, c.AnomalyValue, c.Cooldown)
+	if c.NodeTag != "" {
+		res += " (per " + c.NodeTag + ")"
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestAnomalyCoalescer(t *testing.T) {
+	assert := testAssert.New(t)
+	c := &AnomalyCoalescer{
+		Cooldown:     5 * time.Second,
+		NodeTag:      "node",
+		StateTag:     DefaultAnomalyStateTag,
+		AnomalyValue: DefaultAnomalyStateValue,
+	}
+	out := new(testSampleCollector)
+	c.SetSink(out)
+	c.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"x"}}
+	start := time.Unix(1000, 0)
+	send := func(offset int, node, state string) {
+		sample := &bitflow.Sample{Time: start.Add(time.Duration(offset) * time.Second), Values: []bitflow.Value{bitflow.Value(offset)}}
+		sample.SetTag("node", node)
+		sample.SetTag(DefaultAnomalyStateTag, state)
+		assert.NoError(c.Sample(sample, header))
+	}
+
+	// A burst of anomalies of node a, interleaved with normal samples, and one anomaly of node b
+	for i := 0; i < 10; i++ {
+		send(i, "a", DefaultAnomalyStateValue)
+	}
+	send(10, "a", "normal")
+	send(13, "a", DefaultAnomalyStateValue)
+	send(14, "a", "normal")
+	send(16, "b", DefaultAnomalyStateValue)
+	assert.Len(out.samples, 2)
+
+	send(19, "a", "normal") // More than 5 seconds after the last anomaly of node a
+	assert.Len(out.samples, 4)
+	c.Close()
+	assert.Len(out.samples, 5)
+
+	event := out.samples[2]
+	assert.Equal("a", event.Tag("node"))
+	assert.Equal([]bitflow.Value{0}, event.Values)
+	assert.Equal("11", event.Tag(AnomalyEventCountTag))
+	assert.Equal(start.Format(time.RFC3339Nano), event.Tag(AnomalyEventStartTag))
+	assert.Equal(start.Add(13*time.Second).Format(time.RFC3339Nano), event.Tag(AnomalyEventEndTag))
+	assert.Equal(start.Add(13*time.Second), event.Time)
+
+	assert.Equal("normal", out.samples[3].Tag(DefaultAnomalyStateTag))
+	event = out.samples[4]
+	assert.Equal("b", event.Tag("node"))
+	assert.Equal("1", event.Tag(AnomalyEventCountTag))
+}
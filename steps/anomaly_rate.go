@@ -0,0 +1,138 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	DefaultAnomalyStateTag   = "state"
+	DefaultAnomalyStateValue = "anomaly"
+	DefaultAnomalyRateMetric = "anomaly_rate"
+)
+
+func RegisterAnomalyRate(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("anomaly_rate",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &AnomalyRate{
+				NodeTag:      reg.StrParam(params, "node-tag", "", true, &err),
+				StateTag:     reg.StrParam(params, "state-tag", DefaultAnomalyStateTag, true, &err),
+				AnomalyValue: reg.StrParam(params, "anomaly-value", DefaultAnomalyStateValue, true, &err),
+				Metric:       reg.StrParam(params, "metric", DefaultAnomalyRateMetric, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			window := params["window"]
+			if step.WindowSize, err = strconv.Atoi(window); err != nil {
+				if step.WindowTime, err = time.ParseDuration(window); err != nil {
+					return reg.ParameterError("window", errors.New("Must be a number of samples or a duration: "+window))
+				}
+			}
+			if step.WindowSize <= 0 && step.WindowTime <= 0 {
+				return reg.ParameterError("window", errors.New("Must be positive"))
+			}
+			p.Add(step)
+			return
+		},
+		"Append a metric with the fraction of anomalous samples within a sliding window. The window is either a number of samples or a duration. "+
+			"A sample is anomalous when its state-tag has the value anomaly-value. When node-tag is given, the rate is computed separately for every value of that tag.",
+		reg.RequiredParams("window"), reg.OptionalParams("node-tag", "state-tag", "anomaly-value", "metric"))
+}
+
+// AnomalyRate appends the fraction of anomalous samples among the recent samples as a new metric.
+// The window contains either the last WindowSize samples, or all samples within WindowTime before the current sample.
+// A sample is counted as anomalous, if the value of its StateTag equals AnomalyValue. If NodeTag is set, every
+// value of that tag has a separate window.
+type AnomalyRate struct {
+	bitflow.NoopProcessor
+	WindowSize   int
+	WindowTime   time.Duration
+	NodeTag      string
+	StateTag     string
+	AnomalyValue string
+	Metric       string
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	windows   map[string]*anomalyWindow
+}
+
+type anomalyWindow struct {
+	times     []time.Time
+	anomalous []bool
+	anomalies int
+}
+
+func (w *anomalyWindow) push(t time.Time, anomalous bool, size int, duration time.Duration) float64 {
+	w.times = append(w.times, t)
+	w.anomalous = append(w.anomalous, anomalous)
+	if anomalous {
+		w.anomalies++
+	}
+	drop := 0
+	if size > 0 && len(w.times) > size {
+		drop = len(w.times) - size
+	}
+	if duration > 0 {
+		start := t.Add(-duration)
+		for drop < len(w.times)-1 && !w.times[drop].After(start) {
+			drop++
+		}
+	}
+	for _, dropped := range w.anomalous[:drop] {
+		if dropped {
+			w.anomalies--
+		}
+	}
+	w.times = w.times[drop:]
+	w.anomalous = w.anomalous[drop:]
+	return float64(w.anomalies) / float64(len(w.times))
+}
+
+func (r *AnomalyRate) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if r.checker.HeaderChanged(header) {
+		r.outHeader = header.Clone(append(header.Fields[:len(header.Fields):len(header.Fields)], r.Metric))
+	}
+	if r.windows == nil {
+		r.windows = make(map[string]*anomalyWindow)
+	}
+	var node string
+	if r.NodeTag != "" {
+		node = sample.Tag(r.NodeTag)
+	}
+	window, ok := r.windows[node]
+	if !ok {
+		window = new(anomalyWindow)
+		r.windows[node] = window
+	}
+	rate := window.push(sample.Time, sample.Tag(r.StateTag) == r.AnomalyValue, r.WindowSize, r.WindowTime)
+
+	values := sample.Values
+	if !sample.Resize(len(values) + 1) {
+		copy(sample.Values, values)
+	}
+	sample.Values[len(values)] = bitflow.Value(rate)
+	return r.NoopProcessor.Sample(sample, r.outHeader)
+}
+
+func (r *AnomalyRate) OutputSampleSize(sampleSize int) int {
+	return sampleSize + 1
+}
+
+func (r *AnomalyRate) String() string {
+	window := fmt.Sprintf("%v samples", r.WindowSize)
+	if r.WindowSize <= 0 {
+		window = r.WindowTime.String()
+	}
+	res := fmt.Sprintf("Rate of %v=%v within %v as %v", r.StateTag, r.AnomalyValue, window, r.Metric)
+	if r.NodeTag != "" {
+		res += " (per " + r.NodeTag + ")"
+	}
+	return res
+}
@@ -0,0 +1,59 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _runAnomalyRate(step *AnomalyRate, nodes []string, states []string) *testSampleCollector {
+	out := new(testSampleCollector)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+	header := &bitflow.Header{Fields: []string{"x"}}
+	start := time.Unix(1000, 0)
+	for i, state := range states {
+		sample := &bitflow.Sample{Time: start.Add(time.Duration(i) * time.Second), Values: []bitflow.Value{1}}
+		sample.SetTag("node", nodes[i%len(nodes)])
+		if state != "" {
+			sample.SetTag(DefaultAnomalyStateTag, state)
+		}
+		_ = step.Sample(sample, header) // Drop error
+	}
+	return out
+}
+
+func _anomalyRates(out *testSampleCollector) []bitflow.Value {
+	res := make([]bitflow.Value, len(out.samples))
+	for i, sample := range out.samples {
+		res[i] = sample.Values[1]
+	}
+	return res
+}
+
+func TestAnomalyRateCountWindow(t *testing.T) {
+	assert := testAssert.New(t)
+	step := &AnomalyRate{WindowSize: 4, StateTag: DefaultAnomalyStateTag, AnomalyValue: DefaultAnomalyStateValue, Metric: DefaultAnomalyRateMetric}
+	out := _runAnomalyRate(step, []string{"a"}, []string{"", "anomaly", "anomaly", "normal", "", "", "anomaly"})
+	assert.Equal([]string{"x", DefaultAnomalyRateMetric}, out.headers[0].Fields)
+	assert.Equal([]bitflow.Value{0, 0.5, 2.0 / 3, 0.5, 0.5, 0.25, 0.25}, _anomalyRates(out))
+}
+
+func TestAnomalyRateTimeWindow(t *testing.T) {
+	assert := testAssert.New(t)
+	// Samples are one second apart, so the window contains the current and the previous sample
+	step := &AnomalyRate{WindowTime: 2 * time.Second, StateTag: DefaultAnomalyStateTag, AnomalyValue: DefaultAnomalyStateValue, Metric: DefaultAnomalyRateMetric}
+	out := _runAnomalyRate(step, []string{"a"}, []string{"anomaly", "", "", "anomaly", "anomaly"})
+	assert.Equal([]bitflow.Value{1, 0.5, 0, 0.5, 1}, _anomalyRates(out))
+}
+
+func TestAnomalyRatePerNode(t *testing.T) {
+	assert := testAssert.New(t)
+	step := &AnomalyRate{WindowSize: 2, NodeTag: "node", StateTag: DefaultAnomalyStateTag, AnomalyValue: DefaultAnomalyStateValue, Metric: DefaultAnomalyRateMetric}
+	// Node a is always anomalous, node b never
+	out := _runAnomalyRate(step, []string{"a", "b"}, []string{"anomaly", "", "anomaly", "", "anomaly", ""})
+	assert.Equal([]bitflow.Value{1, 0, 1, 0, 1, 0}, _anomalyRates(out))
+}
@@ -1,6 +1,8 @@
 package steps
 
 import (
+	"errors"
+
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 )
@@ -9,13 +11,30 @@ func RegisterGenericBatch(b reg.ProcessorRegistry) {
 	b.RegisterAnalysisParamsErr("batch",
 		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
 			timeout := reg.DurationParam(params, "timeout", 0, true, &err)
+			processEmpty := reg.BoolParam(params, "process-empty", false, true, &err)
+			flushCount := reg.IntParam(params, "flush-count", 0, true, &err)
+			overlap := reg.IntParam(params, "overlap", 0, true, &err)
+			if err == nil && flushCount < 0 {
+				err = reg.ParameterError("flush-count", errors.New("must not be negative"))
+			}
+			if err == nil && (overlap < 0 || (overlap > 0 && overlap >= flushCount)) {
+				err = reg.ParameterError("overlap", errors.New("must not be negative and must be smaller than flush-count"))
+			}
 			if err == nil {
 				p.Add(&bitflow.BatchProcessor{
-					FlushTags:    []string{params["tag"]},
-					FlushTimeout: timeout,
+					FlushTags:           []string{params["tag"]},
+					FlushTimeout:        timeout,
+					FlushSampleCount:    flushCount,
+					WindowOverlap:       overlap,
+					ProcessEmptyBatches: processEmpty,
 				})
 			}
 			return
 		},
-		"Collect samples and flush them on different events (wall time/sample time/tag change/number of samples). Affects the follow-up analysis step, if it is also a batch analysis", reg.RequiredParams("tag"), reg.OptionalParams("timeout"))
+		"Collect samples and flush them on different events (wall time/sample time/tag change/number of samples). Affects the follow-up analysis step, if it is also a batch analysis. "+
+			"With flush-count, the batch is additionally flushed whenever it contains the given number of samples. "+
+			"With overlap, the given number of samples at the end of each batch is kept as the start of the next batch (sliding windows). "+
+			"Only the flushes triggered by flush-count keep these samples, so overlap requires flush-count. Flushes due to a tag or header change, the timeout, or the end of the input start an empty batch. "+
+			"With process-empty=true, batch steps that support it (sort, shuffle, aggregate_time) are still executed when a previous step removed all samples of the batch.",
+		reg.RequiredParams("tag"), reg.OptionalParams("timeout", "flush-count", "overlap", "process-empty"))
 }
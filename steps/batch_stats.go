@@ -0,0 +1,172 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	BatchStatMin    = "min"
+	BatchStatMax    = "max"
+	BatchStatMean   = "mean"
+	BatchStatMedian = "median"
+	BatchStatStddev = "stddev"
+	BatchStatCount  = "count"
+
+	// DefaultBatchStats is the default value of the 'stats' parameter of the batch_stats step.
+	DefaultBatchStats = "min,max,mean,median,p95,p99,stddev,count"
+)
+
+func RegisterBatchStatsAggregator(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("batch_stats",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			stats := reg.StrParam(params, "stats", DefaultBatchStats, true, &err)
+			if err != nil {
+				return
+			}
+			step, err := NewBatchStatsAggregator(strings.Split(stats, ","))
+			if err != nil {
+				return reg.ParameterError("stats", err)
+			}
+			p.Batch(step)
+			return
+		},
+		"Collapse a batch into one sample containing statistics of every metric. The output fields are named <metric>_<stat>. "+
+			"The stats parameter is a comma-separated list of min, max, mean, median, stddev, count and percentiles like p95 or p99.9 "+
+			"(default "+DefaultBatchStats+"). NaN values are ignored. The output sample has the timestamp and tags of the first sample in the batch.",
+		reg.OptionalParams("stats"), reg.SupportBatch())
+}
+
+// BatchStatsAggregator replaces a batch with a single sample, which contains the statistics listed in Stats for every metric.
+// The output fields are named <metric>_<stat>, ordered by metric and then by the order of Stats. Supported statistics are
+// BatchStatMin, BatchStatMax, BatchStatMean, BatchStatMedian, BatchStatStddev (sample standard deviation), BatchStatCount
+// (number of non-NaN values), and percentiles in the form pNN, e.g. p95 or p99.9. Percentiles are linearly interpolated between
+// the closest ranks. NaN values are ignored, and metrics without any other values result in NaN statistics (and a count of 0).
+// The output sample receives the timestamp and tags of the first sample in the batch. Empty batches are forwarded unchanged.
+type BatchStatsAggregator struct {
+	Stats []string
+
+	percentiles []float64 // Percentile in the range [0, 1] for every stat, or -1 for other stats
+}
+
+// NewBatchStatsAggregator returns a BatchStatsAggregator computing the given statistics, or an error if a statistic is unknown.
+func NewBatchStatsAggregator(stats []string) (*BatchStatsAggregator, error) {
+	agg := &BatchStatsAggregator{Stats: make([]string, 0, len(stats))}
+	for _, stat := range stats {
+		stat = strings.TrimSpace(stat)
+		percentile := -1.0
+		switch stat {
+		case BatchStatMin, BatchStatMax, BatchStatMean, BatchStatStddev, BatchStatCount:
+		case BatchStatMedian:
+			percentile = 0.5
+		default:
+			value, err := strconv.ParseFloat(strings.TrimPrefix(stat, "p"), 64)
+			if !strings.HasPrefix(stat, "p") || err != nil || value < 0 || value > 100 {
+				return nil, fmt.Errorf("Unknown statistic '%v', must be one of %v, %v, %v, %v, %v, %v or a percentile like p95",
+					stat, BatchStatMin, BatchStatMax, BatchStatMean, BatchStatMedian, BatchStatStddev, BatchStatCount)
+			}
+			percentile = value / 100
+		}
+		agg.Stats = append(agg.Stats, stat)
+		agg.percentiles = append(agg.percentiles, percentile)
+	}
+	if len(agg.Stats) == 0 {
+		return nil, errors.New("No statistics defined")
+	}
+	return agg, nil
+}
+
+func (agg *BatchStatsAggregator) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	outFields := make([]string, 0, agg.OutputSampleSize(len(header.Fields)))
+	for _, field := range header.Fields {
+		for _, stat := range agg.Stats {
+			outFields = append(outFields, field+"_"+stat)
+		}
+	}
+	outValues := make([]bitflow.Value, 0, len(outFields))
+	values := make([]float64, 0, len(samples))
+	for i := range header.Fields {
+		values = values[:0]
+		for _, sample := range samples {
+			if len(sample.Values) != len(header.Fields) {
+				return nil, nil, fmt.Errorf("%v: Sample has %v values, but header has %v fields", agg, len(sample.Values), len(header.Fields))
+			}
+			if val := float64(sample.Values[i]); !math.IsNaN(val) {
+				values = append(values, val)
+			}
+		}
+		sort.Float64s(values)
+		for j, stat := range agg.Stats {
+			outValues = append(outValues, bitflow.Value(agg.compute(stat, agg.percentiles[j], values)))
+		}
+	}
+
+	out := &bitflow.Sample{Values: outValues}
+	out.CopyMetadataFrom(samples[0])
+	return header.Clone(outFields), []*bitflow.Sample{out}, nil
+}
+
+// compute returns the given statistic of the sorted values
+func (agg *BatchStatsAggregator) compute(stat string, percentile float64, sorted []float64) float64 {
+	if stat == BatchStatCount {
+		return float64(len(sorted))
+	}
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	if percentile >= 0 {
+		return sortedPercentile(sorted, percentile)
+	}
+	switch stat {
+	case BatchStatMin:
+		return sorted[0]
+	case BatchStatMax:
+		return sorted[len(sorted)-1]
+	}
+	sum := 0.0
+	for _, val := range sorted {
+		sum += val
+	}
+	mean := sum / float64(len(sorted))
+	if stat == BatchStatMean {
+		return mean
+	}
+	// BatchStatStddev
+	if len(sorted) < 2 {
+		return 0
+	}
+	squares := 0.0
+	for _, val := range sorted {
+		squares += (val - mean) * (val - mean)
+	}
+	return math.Sqrt(squares / float64(len(sorted)-1))
+}
+
+func (agg *BatchStatsAggregator) OutputSampleSize(sampleSize int) int {
+	return sampleSize * len(agg.Stats)
+}
+
+func (agg *BatchStatsAggregator) String() string {
+	return fmt.Sprintf("Batch statistics (%v)", strings.Join(agg.Stats, ", "))
+}
+
+// sortedPercentile returns the given percentile (in the range [0, 1]) of the sorted, non-empty values.
+// The result is linearly interpolated between the two closest values.
+func sortedPercentile(sorted []float64, percentile float64) float64 {
+	rank := percentile * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (sorted[lower+1]-sorted[lower])*(rank-float64(lower))
+}
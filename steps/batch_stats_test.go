@@ -0,0 +1,53 @@
+package steps
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestBatchStatsAggregator(t *testing.T) {
+	assert := testAssert.New(t)
+	agg, err := NewBatchStatsAggregator([]string{"min", "max", "mean", "median", "p95", "stddev", "count"})
+	assert.NoError(err)
+	assert.Equal(14, agg.OutputSampleSize(2))
+
+	header := &bitflow.Header{Fields: []string{"x", "y"}}
+	nan := bitflow.Value(math.NaN())
+	values := [][]bitflow.Value{{4, nan}, {1, nan}, {3, 5}, {2, nan}, {5, nan}}
+	samples := make([]*bitflow.Sample, len(values))
+	for i, vals := range values {
+		samples[i] = &bitflow.Sample{Time: time.Unix(int64(100+i), 0), Values: vals}
+		samples[i].SetTag("index", string(rune('a'+i)))
+	}
+
+	outHeader, out, err := agg.ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal([]string{
+		"x_min", "x_max", "x_mean", "x_median", "x_p95", "x_stddev", "x_count",
+		"y_min", "y_max", "y_mean", "y_median", "y_p95", "y_stddev", "y_count",
+	}, outHeader.Fields)
+	assert.Len(out, 1)
+	assert.Equal([]bitflow.Value{1, 5, 3, 3, 4.8, bitflow.Value(math.Sqrt(2.5)), 5, 5, 5, 5, 5, 5, 0, 1}, out[0].Values)
+	assert.Equal(time.Unix(100, 0), out[0].Time)
+	assert.Equal("a", out[0].Tag("index"))
+
+	// Empty batches and metrics without values
+	_, out, err = agg.ProcessBatch(header, nil)
+	assert.NoError(err)
+	assert.Empty(out)
+	_, out, err = agg.ProcessBatch(&bitflow.Header{Fields: []string{"z"}}, []*bitflow.Sample{{Values: []bitflow.Value{nan}}})
+	assert.NoError(err)
+	for _, value := range out[0].Values[:6] {
+		assert.True(math.IsNaN(float64(value)))
+	}
+	assert.Equal(bitflow.Value(0), out[0].Values[6])
+
+	for _, invalid := range [][]string{{"p101"}, {"p"}, {"avg"}, {}} {
+		_, err = NewBatchStatsAggregator(invalid)
+		assert.Error(err, "stats %v", invalid)
+	}
+}
@@ -6,8 +6,15 @@ import (
 	"github.com/bitflow-stream/go-bitflow/script/plugin"
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 	"github.com/bitflow-stream/go-bitflow/steps"
+	"github.com/bitflow-stream/go-bitflow/steps/grpc"
+	"github.com/bitflow-stream/go-bitflow/steps/kafka"
 	"github.com/bitflow-stream/go-bitflow/steps/math"
+	"github.com/bitflow-stream/go-bitflow/steps/mqtt"
+	"github.com/bitflow-stream/go-bitflow/steps/parquet"
 	"github.com/bitflow-stream/go-bitflow/steps/plot"
+	"github.com/bitflow-stream/go-bitflow/steps/s3"
+	"github.com/bitflow-stream/go-bitflow/steps/sqlite"
+	"github.com/bitflow-stream/go-bitflow/steps/websocket"
 )
 
 // This plugin is automatically loaded by the bitflow-pipeline tool, there is no need to actually compile
@@ -35,12 +42,17 @@ func (p *pluginImpl) Init(b reg.ProcessorRegistry) error {
 	steps.RegisterSleep(b)
 	steps.RegisterForks(b)
 	steps.RegisterExpression(b)
+	steps.RegisterComputeMetrics(b)
+	steps.RegisterMetricRatios(b)
+	steps.RegisterEvalStep(b)
 	steps.RegisterSubprocessRunner(b)
 	steps.RegisterMergeHeaders(b)
 	steps.RegisterGenericBatch(b)
 	steps.RegisterDecouple(b)
 	steps.RegisterDropErrorsStep(b)
 	steps.RegisterResendStep(b)
+	steps.RegisterHeartbeat(b)
+	steps.RegisterThroughputMonitor(b)
 	steps.RegisterFillUpStep(b)
 	steps.RegisterPipelineRateSynchronizer(b)
 	steps.RegisterSubpipelineStreamMerger(b)
@@ -53,6 +65,16 @@ func (p *pluginImpl) Init(b reg.ProcessorRegistry) error {
 	steps.RegisterOutputFiles(b)
 	steps.RegisterGraphiteOutput(b)
 	steps.RegisterOpentsdbOutput(b)
+	steps.RegisterPrometheusRemoteWrite(b)
+	steps.RegisterPrometheusScrapeEndpoint(b)
+	parquet.RegisterParquetOutput(b)
+	parquet.RegisterParquetEndpoints(b)
+	mqtt.RegisterMqttEndpoints(b)
+	s3.RegisterS3Endpoints(b)
+	kafka.RegisterKafkaEndpoints(b)
+	sqlite.RegisterSqliteEndpoints(b)
+	websocket.RegisterWebSocketEndpoints(b)
+	grpc.RegisterGrpcEndpoints(b)
 
 	// Logging, output metadata
 	steps.RegisterStoreStats(b)
@@ -67,14 +89,30 @@ func (p *pluginImpl) Init(b reg.ProcessorRegistry) error {
 	math.RegisterRMS(b)
 	math.RegisterLinearRegression(b)
 	math.RegisterLinearRegressionBruteForce(b)
+	math.RegisterDetrend(b)
+	math.RegisterSavitzkyGolay(b)
+	math.RegisterImputation(b)
+	math.RegisterEnvelope(b)
+	math.RegisterDistance(b)
+	math.RegisterKnnClassifier(b)
+	math.RegisterValueHistogramOverTime(b)
 	math.RegisterPCA(b)
 	math.RegisterPCAStore(b)
 	math.RegisterPCALoad(b)
 	math.RegisterPCALoadStream(b)
+	math.RegisterPCAProjection(b)
+	math.RegisterPCAReconstructionError(b)
+	math.RegisterMahalanobis(b)
 	math.RegisterMinMaxScaling(b)
 	math.RegisterStandardizationScaling(b)
+	math.RegisterRowStandardization(b)
 	math.RegisterAggregateAvg(b)
 	math.RegisterAggregateSlope(b)
+	math.RegisterCumulativeSum(b)
+	math.RegisterMovingAverage(b)
+	math.RegisterFirFilter(b)
+	math.RegisterSeasonalDecomposition(b)
+	math.RegisterPeriodDetection(b)
 
 	// Filter samples
 	steps.RegisterFilterExpression(b)
@@ -83,15 +121,30 @@ func (p *pluginImpl) Init(b reg.ProcessorRegistry) error {
 	steps.RegisterSkipHead(b)
 	math.RegisterConvexHull(b)
 	steps.RegisterDuplicateTimestampFilter(b)
+	steps.RegisterDeduplicateProcessor(b)
+	steps.RegisterRateLimiter(b)
+	steps.RegisterTimeSnapper(b)
+	steps.RegisterTimeBucketAggregator(b)
+	steps.RegisterDerivative(b)
+	steps.RegisterLinearResampler(b)
+	steps.RegisterMetricSource(b)
+	steps.RegisterRangeValidator(b)
+	steps.RegisterClampProcessor(b)
+	steps.RegisterWinsorizer(b)
+	steps.RegisterCalibration(b)
+	steps.RegisterReferenceDiff(b)
 
 	// Reorder samples
 	math.RegisterConvexHullSort(b)
 	steps.RegisterSampleShuffler(b)
 	steps.RegisterSampleSorter(b)
+	steps.RegisterReorderBuffer(b)
+	steps.RegisterLookahead(b)
 
 	// Metadata
 	steps.RegisterSetCurrentTime(b)
 	steps.RegisterTaggingProcessor(b)
+	steps.RegisterTagEnricher(b)
 	steps.RegisterHttpTagger(b)
 	steps.RegisterPauseTagger(b)
 
@@ -100,14 +153,25 @@ func (p *pluginImpl) Init(b reg.ProcessorRegistry) error {
 	steps.RegisterStripMetrics(b)
 	steps.RegisterMetricMapper(b)
 	steps.RegisterMetricRenamer(b)
+	steps.RegisterFieldNamer(b)
+	steps.RegisterSchemaStableMode(b)
 	steps.RegisterIncludeMetricsFilter(b)
 	steps.RegisterExcludeMetricsFilter(b)
+	steps.RegisterMetadataFilter(b)
 	steps.RegisterVarianceMetricsFilter(b)
+	steps.RegisterOutlierFilter(b)
+	steps.RegisterBatchStatsAggregator(b)
 	steps.RegisterMetricSplitter(b)
+	steps.RegisterMetricPartitioner(b)
 
 	// Special
 	math.RegisterSphere(b)
 	steps.RegisterAppendTimeDifference(b)
+	steps.RegisterAnomalyRate(b)
+	steps.RegisterCompleteness(b)
+	steps.RegisterEwmaAnomalyLabeler(b)
+	steps.RegisterAnomalyCoalescer(b)
+	steps.RegisterThresholdCrossing(b)
 
 	return nil
 }
@@ -0,0 +1,139 @@
+package steps
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterCalibration(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("calibrate",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			file := reg.StrParam(params, "table", "", false, &err)
+			if err != nil {
+				return
+			}
+			table, err := LoadCalibrationTable(file)
+			if err != nil {
+				return reg.ParameterError("table", err)
+			}
+			p.Add(&Calibrator{Table: table})
+			return
+		},
+		"Apply a linear calibration (value * scale + offset) to the metrics contained in the calibration table. Other metrics are forwarded unchanged. "+
+			"The table is loaded from a JSON file (an object mapping metric names to objects with the keys 'scale' and 'offset'), "+
+			"or a CSV file with the columns metric, scale and offset (the first line is the header line). A missing scale defaults to 1, a missing offset to 0.",
+		reg.RequiredParams("table"))
+}
+
+// Calibration defines the linear transformation value * Scale + Offset.
+type Calibration struct {
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+func (c Calibration) Apply(val bitflow.Value) bitflow.Value {
+	return bitflow.Value(float64(val)*c.Scale + c.Offset)
+}
+
+// Calibrator applies the Calibration stored in the Table for a metric name to the values of all matching metrics.
+// Metrics without an entry in the Table are forwarded unchanged.
+type Calibrator struct {
+	bitflow.NoopProcessor
+	Table map[string]Calibration
+
+	checker      bitflow.HeaderChecker
+	calibrations map[int]Calibration
+}
+
+func (c *Calibrator) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if c.checker.HeaderChanged(header) {
+		c.calibrations = make(map[int]Calibration)
+		for i, field := range header.Fields {
+			if calibration, ok := c.Table[field]; ok {
+				c.calibrations[i] = calibration
+			}
+		}
+	}
+	for i, calibration := range c.calibrations {
+		sample.Values[i] = calibration.Apply(sample.Values[i])
+	}
+	return c.NoopProcessor.Sample(sample, header)
+}
+
+func (c *Calibrator) String() string {
+	return fmt.Sprintf("Calibrate metrics (%v table entries)", len(c.Table))
+}
+
+// LoadCalibrationTable loads the calibration table for the Calibrator. Files with the extension .json must contain
+// an object mapping every metric name to an object with the keys "scale" and "offset". All other files are parsed as CSV
+// with the columns metric, scale and offset, and a header line. Missing or empty scales default to 1, offsets to 0.
+func LoadCalibrationTable(filename string) (map[string]Calibration, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // Drop error
+
+	table := make(map[string]Calibration)
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		var entries map[string]struct {
+			Scale  *float64 `json:"scale"`
+			Offset *float64 `json:"offset"`
+		}
+		if err := json.NewDecoder(file).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("Failed to parse %v: %v", filename, err)
+		}
+		for metric, entry := range entries {
+			calibration := Calibration{Scale: 1}
+			if entry.Scale != nil {
+				calibration.Scale = *entry.Scale
+			}
+			if entry.Offset != nil {
+				calibration.Offset = *entry.Offset
+			}
+			table[metric] = calibration
+		}
+		return table, nil
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %v: %v", filename, err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("Missing header line in " + filename)
+	}
+	for line, record := range records[1:] {
+		if len(record) < 2 || len(record) > 3 {
+			return nil, fmt.Errorf("%v line %v: Expected 2 or 3 columns (metric, scale, offset), but got %v", filename, line+2, len(record))
+		}
+		metric := record[0]
+		if _, ok := table[metric]; ok {
+			return nil, fmt.Errorf("Duplicate metric '%v' in %v", metric, filename)
+		}
+		calibration := Calibration{Scale: 1}
+		if record[1] != "" {
+			if calibration.Scale, err = strconv.ParseFloat(record[1], 64); err != nil {
+				return nil, fmt.Errorf("%v line %v: Failed to parse scale: %v", filename, line+2, err)
+			}
+		}
+		if len(record) > 2 && record[2] != "" {
+			if calibration.Offset, err = strconv.ParseFloat(record[2], 64); err != nil {
+				return nil, fmt.Errorf("%v line %v: Failed to parse offset: %v", filename, line+2, err)
+			}
+		}
+		table[metric] = calibration
+	}
+	return table, nil
+}
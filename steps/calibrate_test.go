@@ -0,0 +1,67 @@
+package steps
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestLoadCalibrationTable(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-calibrate")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	expected := map[string]Calibration{
+		"temp":     {Scale: 0.1, Offset: -40},
+		"pressure": {Scale: 2},
+		"humidity": {Scale: 1, Offset: 5},
+	}
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		assert.NoError(ioutil.WriteFile(filename, []byte(content), 0644))
+		return filename
+	}
+
+	table, err := LoadCalibrationTable(write("table.csv", "metric,scale,offset\ntemp,0.1,-40\npressure,2\nhumidity,,5\n"))
+	assert.NoError(err)
+	assert.Equal(expected, table)
+	table, err = LoadCalibrationTable(write("table.json", `{"temp": {"scale": 0.1, "offset": -40}, "pressure": {"scale": 2}, "humidity": {"offset": 5}}`))
+	assert.NoError(err)
+	assert.Equal(expected, table)
+
+	_, err = LoadCalibrationTable(write("duplicate.csv", "metric,scale,offset\ntemp,1,0\ntemp,2,0\n"))
+	assert.Error(err)
+	_, err = LoadCalibrationTable(write("invalid.csv", "metric,scale,offset\ntemp,x,0\n"))
+	assert.Error(err)
+	_, err = LoadCalibrationTable(filepath.Join(dir, "missing.csv"))
+	assert.Error(err)
+}
+
+func TestCalibrator(t *testing.T) {
+	assert := testAssert.New(t)
+	c := &Calibrator{Table: map[string]Calibration{
+		"temp":     {Scale: 0.5, Offset: -40},
+		"pressure": {Scale: 2},
+	}}
+	out := new(testSampleCollector)
+	c.SetSink(out)
+	c.Start(new(sync.WaitGroup))
+
+	header1 := &bitflow.Header{Fields: []string{"temp", "other", "pressure"}}
+	header2 := &bitflow.Header{Fields: []string{"pressure", "temp"}}
+	assert.NoError(c.Sample(&bitflow.Sample{Values: []bitflow.Value{100, 3, 10}}, header1))
+	assert.NoError(c.Sample(&bitflow.Sample{Values: []bitflow.Value{20, 7, 1}}, header1))
+	assert.NoError(c.Sample(&bitflow.Sample{Values: []bitflow.Value{10, 100}}, header2))
+	c.Close()
+
+	assert.Len(out.samples, 3)
+	assert.Equal([]bitflow.Value{10, 3, 20}, out.samples[0].Values)
+	assert.Equal([]bitflow.Value{-30, 7, 2}, out.samples[1].Values)
+	assert.Equal([]bitflow.Value{20, 10}, out.samples[2].Values)
+}
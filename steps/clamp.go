@@ -0,0 +1,81 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterClampProcessor(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("clamp",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			_, replaceInvalid := params["nan"]
+			clamp := &ClampProcessor{
+				ReplaceInvalid: replaceInvalid,
+				Fill:           reg.FloatParam(params, "nan", 0, true, &err),
+				Min:            reg.FloatParam(params, "min", math.Inf(-1), true, &err),
+				Max:            reg.FloatParam(params, "max", math.Inf(1), true, &err),
+			}
+			if err == nil && clamp.Min > clamp.Max {
+				err = reg.ParameterError("min", errors.New("Must not be larger than max"))
+			}
+			if err == nil {
+				p.Add(clamp)
+			}
+			return
+		},
+		"Replace NaN and infinite values with the value of the nan parameter (if given), and limit all values to the range between min and max (both optional). "+
+			"Without the nan parameter, infinite values are limited to min and max, and NaN values are forwarded unchanged.",
+		reg.OptionalParams("nan", "min", "max"))
+}
+
+// ClampProcessor replaces invalid values (NaN and +/-Inf) with Fill, if ReplaceInvalid is set, and limits all values
+// to the range [Min, Max]. Set Min to math.Inf(-1) and Max to math.Inf(1) to disable the respective bound.
+// Samples are only copied if at least one value is changed. Samples without changed values are forwarded without
+// any allocation.
+type ClampProcessor struct {
+	bitflow.NoopProcessor
+	Min            float64
+	Max            float64
+	ReplaceInvalid bool
+	Fill           float64
+}
+
+func (c *ClampProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	copied := false
+	for i, val := range sample.Values {
+		if res, changed := c.clamp(float64(val)); changed {
+			if !copied {
+				// Avoid modifying the sample, since it might also be forwarded to other processors
+				sample = sample.DeepClone()
+				copied = true
+			}
+			sample.Values[i] = bitflow.Value(res)
+		}
+	}
+	return c.NoopProcessor.Sample(sample, header)
+}
+
+func (c *ClampProcessor) clamp(val float64) (float64, bool) {
+	if c.ReplaceInvalid && (math.IsNaN(val) || math.IsInf(val, 0)) {
+		return c.Fill, true
+	}
+	if val < c.Min {
+		return c.Min, true
+	}
+	if val > c.Max {
+		return c.Max, true
+	}
+	return val, false
+}
+
+func (c *ClampProcessor) String() string {
+	res := fmt.Sprintf("Clamp values to [%v, %v]", c.Min, c.Max)
+	if c.ReplaceInvalid {
+		res += fmt.Sprintf(", replace NaN and Inf with %v", c.Fill)
+	}
+	return res
+}
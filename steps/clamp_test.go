@@ -0,0 +1,57 @@
+package steps
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestClampProcessor(t *testing.T) {
+	assert := testAssert.New(t)
+	c := &ClampProcessor{Min: -1, Max: 10, ReplaceInvalid: true, Fill: 0}
+	out := new(testSampleCollector)
+	c.SetSink(out)
+	c.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b", "c"}}
+	valid := &bitflow.Sample{Values: []bitflow.Value{1, -1, 10}}
+	invalid := &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(math.NaN()), bitflow.Value(math.Inf(1)), 20}}
+	invalid.SetTag("key", "value")
+	low := &bitflow.Sample{Values: []bitflow.Value{-5, bitflow.Value(math.Inf(-1)), 3}}
+	for _, sample := range []*bitflow.Sample{valid, invalid, low} {
+		assert.NoError(c.Sample(sample, header))
+	}
+	c.Close()
+
+	assert.Len(out.samples, 3)
+	assert.True(valid == out.samples[0], "unchanged sample must be forwarded without copying")
+	assert.Equal([]bitflow.Value{1, -1, 10}, out.samples[0].Values)
+	assert.Equal([]bitflow.Value{0, 0, 10}, out.samples[1].Values)
+	assert.Equal([]bitflow.Value{-1, 0, 3}, out.samples[2].Values)
+	assert.Equal("value", out.samples[1].Tag("key"))
+
+	// Input samples are not modified
+	assert.True(math.IsNaN(float64(invalid.Values[0])))
+	assert.Equal(bitflow.Value(20), invalid.Values[2])
+	assert.Equal(bitflow.Value(-5), low.Values[0])
+}
+
+func TestClampProcessorWithoutFill(t *testing.T) {
+	assert := testAssert.New(t)
+	c := &ClampProcessor{Min: 0, Max: 1}
+	out := new(testSampleCollector)
+	c.SetSink(out)
+	c.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b", "c"}}
+	assert.NoError(c.Sample(&bitflow.Sample{Values: []bitflow.Value{bitflow.Value(math.NaN()), bitflow.Value(math.Inf(1)), bitflow.Value(math.Inf(-1))}}, header))
+	c.Close()
+
+	assert.Len(out.samples, 1)
+	values := out.samples[0].Values
+	assert.True(math.IsNaN(float64(values[0])))
+	assert.Equal([]bitflow.Value{1, 0}, values[1:])
+}
@@ -0,0 +1,118 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	DefaultCompletenessThreshold = 1.0
+	DefaultCompletenessTag       = "incomplete"
+
+	CompletenessMetric = "completeness"
+	ObservedMetric     = "observed"
+	ExpectedMetric     = "expected"
+)
+
+func RegisterCompleteness(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("completeness",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &CompletenessCounter{
+				Interval:  reg.DurationParam(params, "interval", 0, false, &err),
+				Window:    reg.DurationParam(params, "window", 0, false, &err),
+				Threshold: reg.FloatParam(params, "threshold", DefaultCompletenessThreshold, true, &err),
+				Tag:       reg.StrParam(params, "tag", DefaultCompletenessTag, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			if step.Interval <= 0 {
+				return reg.ParameterError("interval", errors.New("Must be > 0"))
+			}
+			if step.Window < step.Interval {
+				return reg.ParameterError("window", fmt.Errorf("Must be >= interval (%v)", step.Interval))
+			}
+			if step.Tag == "" {
+				return reg.ParameterError("tag", errors.New("Must not be empty"))
+			}
+			p.Batch(step)
+			return
+		},
+		"Replace a batch with one sample per time window, containing the metrics '"+ObservedMetric+"' (number of samples in the window), "+
+			"'"+ExpectedMetric+"' (window divided by the expected sample interval) and '"+CompletenessMetric+"' (observed divided by expected). "+
+			"The windows are aligned to multiples of the window duration since the Unix epoch, windows without samples are included. "+
+			"Windows with a completeness below the threshold (default "+fmt.Sprint(DefaultCompletenessThreshold)+") receive the tag '"+DefaultCompletenessTag+"=true' "+
+			"(configurable through the tag parameter). The output samples have the start time of the window and the tags of the first sample in the batch.",
+		reg.RequiredParams("interval", "window"), reg.OptionalParams("threshold", "tag"), reg.SupportBatch())
+}
+
+// CompletenessCounter measures the fraction of expected samples that are present in a batch, for example to quantify
+// gaps in the data. Samples are expected to arrive every Interval. The batch is divided into time windows of the duration
+// Window, aligned to multiples of Window since the Unix epoch, starting with the window of the first sample and ending
+// with the window of the last sample. Empty batches are forwarded unchanged, other batches are replaced with one sample
+// per window, including windows without any samples. The samples contain the number of observed samples in the window,
+// the expected number of samples (Window / Interval), and the completeness (observed / expected).
+// Note that the first and last window might only be partially covered by the batch, which reduces their completeness.
+// The completeness can also exceed 1, if the samples arrive faster than expected.
+//
+// Windows with a completeness below the Threshold receive the Tag with the value "true". The output samples have the
+// start time of their window and the tags of the first sample in the batch. The samples must be sorted by time.
+type CompletenessCounter struct {
+	Interval  time.Duration
+	Window    time.Duration
+	Threshold float64
+	Tag       string
+}
+
+func (c *CompletenessCounter) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	first := c.windowStart(samples[0].Time)
+	last := c.windowStart(samples[len(samples)-1].Time)
+	if last.Before(first) {
+		return nil, nil, fmt.Errorf("%v: The samples in the batch are not sorted by time", c)
+	}
+	counts := make([]int, int(last.Sub(first)/c.Window)+1)
+	for _, sample := range samples {
+		index := int(c.windowStart(sample.Time).Sub(first) / c.Window)
+		if index < 0 || index >= len(counts) {
+			return nil, nil, fmt.Errorf("%v: The samples in the batch are not sorted by time", c)
+		}
+		counts[index]++
+	}
+
+	expected := float64(c.Window) / float64(c.Interval)
+	result := make([]*bitflow.Sample, len(counts))
+	for i, count := range counts {
+		completeness := float64(count) / expected
+		out := &bitflow.Sample{
+			Values: []bitflow.Value{bitflow.Value(count), bitflow.Value(expected), bitflow.Value(completeness)},
+		}
+		out.CopyMetadataFrom(samples[0])
+		out.Time = first.Add(time.Duration(i) * c.Window)
+		if completeness < c.Threshold {
+			out.SetTag(c.Tag, "true")
+		}
+		result[i] = out
+	}
+	return &bitflow.Header{Fields: []string{ObservedMetric, ExpectedMetric, CompletenessMetric}}, result, nil
+}
+
+// windowStart returns the start of the window containing the given time.
+func (c *CompletenessCounter) windowStart(t time.Time) time.Time {
+	nanos := t.UnixNano()
+	remainder := nanos % int64(c.Window)
+	if remainder < 0 {
+		remainder += int64(c.Window)
+	}
+	return time.Unix(0, nanos-remainder)
+}
+
+func (c *CompletenessCounter) String() string {
+	return fmt.Sprintf("Completeness of samples every %v in windows of %v (threshold %v, tag %v)", c.Interval, c.Window, c.Threshold, c.Tag)
+}
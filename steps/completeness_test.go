@@ -0,0 +1,52 @@
+package steps
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestCompletenessCounter(t *testing.T) {
+	assert := testAssert.New(t)
+	c := &CompletenessCounter{Interval: time.Second, Window: 10 * time.Second, Threshold: 0.9, Tag: DefaultCompletenessTag}
+	header := &bitflow.Header{Fields: []string{"a"}}
+
+	// First window complete, second window with every other sample missing, third window empty, one sample in the fourth window
+	start := time.Unix(1000, 0)
+	var samples []*bitflow.Sample
+	add := func(offset time.Duration) {
+		sample := &bitflow.Sample{Time: start.Add(offset), Values: []bitflow.Value{1}}
+		sample.SetTag("host", "h1")
+		samples = append(samples, sample)
+	}
+	for i := 0; i < 10; i++ {
+		add(time.Duration(i) * time.Second)
+	}
+	for i := 10; i < 20; i += 2 {
+		add(time.Duration(i) * time.Second)
+	}
+	add(35 * time.Second)
+
+	outHeader, out, err := c.ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal([]string{ObservedMetric, ExpectedMetric, CompletenessMetric}, outHeader.Fields)
+	assert.Len(out, 4)
+	expected := [][]bitflow.Value{{10, 10, 1}, {5, 10, 0.5}, {0, 10, 0}, {1, 10, 0.1}}
+	for i, sample := range out {
+		assert.Equal(expected[i], sample.Values, "window %v", i)
+		assert.Equal(start.Add(time.Duration(i)*10*time.Second), sample.Time)
+		assert.Equal("h1", sample.Tag("host"))
+		assert.Equal(i > 0, sample.HasTag(DefaultCompletenessTag), "window %v", i)
+	}
+
+	// Windows are aligned to the Unix epoch
+	_, out, err = c.ProcessBatch(header, []*bitflow.Sample{{Time: time.Unix(1005, 0)}, {Time: time.Unix(1012, 0)}})
+	assert.NoError(err)
+	assert.Len(out, 2)
+	assert.Equal(time.Unix(1000, 0), out[0].Time)
+
+	_, _, err = c.ProcessBatch(header, []*bitflow.Sample{{Time: time.Unix(1012, 0)}, {Time: time.Unix(1005, 0)}})
+	assert.Error(err)
+}
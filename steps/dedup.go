@@ -0,0 +1,130 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultDedupEpsilon is the default relative tolerance for comparing the values of samples in the dedup step.
+const DefaultDedupEpsilon = 1e-9
+
+func RegisterDeduplicateProcessor(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("dedup",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &DeduplicateProcessor{
+				Tag:     reg.StrParam(params, "tag", "", true, &err),
+				Window:  reg.DurationParam(params, "window", 0, true, &err),
+				Epsilon: reg.FloatParam(params, "epsilon", DefaultDedupEpsilon, true, &err),
+			}
+			if err == nil && step.Epsilon < 0 {
+				err = reg.ParameterError("epsilon", errors.New("Must not be negative"))
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		"Drop samples that are exact duplicates of the preceding sample, i.e. they have the same header, timestamp, tags and values. "+
+			fmt.Sprintf("Values are compared with the relative tolerance epsilon (default %v). When tag is given, every value of that tag is compared separately. ", DefaultDedupEpsilon)+
+			"When window is given, samples are only compared with preceding samples that arrived within that duration.",
+		reg.OptionalParams("tag", "window", "epsilon"))
+}
+
+// DeduplicateProcessor drops samples that duplicate the previous sample. A sample is a duplicate, if the header, the timestamp,
+// the tags and the values are equal to the preceding sample. If Tag is set, every value of that tag has its own preceding sample,
+// so duplicates can be detected in interleaved streams. Two values are considered equal, if their difference is at most
+// Epsilon multiplied with the larger absolute value (or with 1, for values smaller than 1), so that rounding errors from re-marshalling
+// the values do not matter. NaN values are equal to each other. If Window is > 0, a sample is only compared to the preceding sample,
+// if that arrived (in wall-clock time) at most Window earlier. The number of dropped samples is logged when the step is closed.
+type DeduplicateProcessor struct {
+	bitflow.NoopProcessor
+	Tag     string
+	Window  time.Duration
+	Epsilon float64
+
+	previous map[string]*dedupSample
+	dropped  int
+}
+
+type dedupSample struct {
+	bitflow.SampleAndHeader
+	tags    map[string]string
+	arrival time.Time
+}
+
+func (d *DeduplicateProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if d.previous == nil {
+		d.previous = make(map[string]*dedupSample)
+	}
+	var key string
+	if d.Tag != "" {
+		key = sample.Tag(d.Tag)
+	}
+	now := time.Now()
+	tags := sample.TagMap()
+	previous, ok := d.previous[key]
+	if ok && (d.Window <= 0 || now.Sub(previous.arrival) <= d.Window) && d.isDuplicate(previous, sample, header, tags) {
+		previous.arrival = now
+		d.dropped++
+		return nil
+	}
+	d.previous[key] = &dedupSample{
+		SampleAndHeader: bitflow.SampleAndHeader{Sample: sample.DeepClone(), Header: header},
+		tags:            tags,
+		arrival:         now,
+	}
+	return d.NoopProcessor.Sample(sample, header)
+}
+
+func (d *DeduplicateProcessor) isDuplicate(previous *dedupSample, sample *bitflow.Sample, header *bitflow.Header, tags map[string]string) bool {
+	if !previous.Sample.Time.Equal(sample.Time) || len(previous.Sample.Values) != len(sample.Values) || len(previous.tags) != len(tags) {
+		return false
+	}
+	if previous.Header != header && !previous.Header.Equals(header) {
+		return false
+	}
+	for key, value := range tags {
+		if previousValue, ok := previous.tags[key]; !ok || previousValue != value {
+			return false
+		}
+	}
+	for i, value := range sample.Values {
+		if !d.equalValues(float64(previous.Sample.Values[i]), float64(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *DeduplicateProcessor) equalValues(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	if a == b {
+		return true // Also handles infinite values
+	}
+	scale := math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+	return math.Abs(a-b) <= d.Epsilon*scale
+}
+
+func (d *DeduplicateProcessor) Close() {
+	log.Printf("%v: Dropped %v duplicate sample(s)", d, d.dropped)
+	d.NoopProcessor.Close()
+}
+
+func (d *DeduplicateProcessor) String() string {
+	res := "Drop duplicate samples"
+	if d.Tag != "" {
+		res += " (per " + d.Tag + ")"
+	}
+	if d.Window > 0 {
+		res += fmt.Sprintf(" within %v", d.Window)
+	}
+	return res
+}
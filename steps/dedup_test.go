@@ -0,0 +1,52 @@
+package steps
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicateProcessor(t *testing.T) {
+	assert := testAssert.New(t)
+	d := &DeduplicateProcessor{Tag: "host", Epsilon: DefaultDedupEpsilon}
+	out := new(testSampleCollector)
+	d.SetSink(out)
+	d.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	start := time.Unix(1000, 0)
+	send := func(offset int, host string, a, b float64, h *bitflow.Header) {
+		sample := &bitflow.Sample{Time: start.Add(time.Duration(offset) * time.Second), Values: []bitflow.Value{bitflow.Value(a), bitflow.Value(b)}}
+		sample.SetTag("host", host)
+		assert.NoError(d.Sample(sample, h))
+	}
+
+	send(0, "x", 1, math.NaN(), header)
+	send(0, "y", 1, math.NaN(), header)                           // Other identity
+	send(0, "x", 1+1e-12, math.NaN(), header)                     // Duplicate within epsilon
+	send(0, "x", 1.1, math.NaN(), header)                         // Different value
+	send(0, "x", 1.1, math.NaN(), header)                         // Duplicate
+	send(1, "x", 1.1, math.NaN(), header)                         // Different timestamp
+	send(1, "y", 1, math.NaN(), header)                           // Different timestamp for y
+	send(1, "y", 1, math.NaN(), header.Clone([]string{"a", "b"})) // Equal header
+	send(1, "y", 1, math.NaN(), &bitflow.Header{Fields: []string{"c", "d"}})
+	d.Close()
+
+	assert.Len(out.samples, 6)
+	assert.Equal(3, d.dropped)
+	assert.Equal(bitflow.Value(1.1), out.samples[2].Values[0])
+
+	// With a window, older samples are not compared
+	d = &DeduplicateProcessor{Window: time.Nanosecond}
+	out = new(testSampleCollector)
+	d.SetSink(out)
+	sample := &bitflow.Sample{Time: start, Values: []bitflow.Value{1}}
+	assert.NoError(d.Sample(sample, header))
+	time.Sleep(time.Millisecond)
+	assert.NoError(d.Sample(sample, header))
+	assert.Len(out.samples, 2)
+}
@@ -0,0 +1,122 @@
+package steps
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	EnrichMissingSkip    = "skip"
+	EnrichMissingDefault = "default"
+
+	// EnrichDefaultKey is the key of the table row that is used for missing keys with EnrichMissingDefault.
+	EnrichDefaultKey = "*"
+)
+
+func RegisterTagEnricher(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("enrich",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			file := reg.StrParam(params, "table", "", false, &err)
+			key := reg.StrParam(params, "key", "", false, &err)
+			onMissing := reg.StrParam(params, "on-missing", EnrichMissingSkip, true, &err)
+			if err != nil {
+				return
+			}
+			table, err := LoadEnrichmentTable(file)
+			if err != nil {
+				return reg.ParameterError("table", err)
+			}
+			switch onMissing {
+			case EnrichMissingSkip:
+			case EnrichMissingDefault:
+				if _, ok := table[EnrichDefaultKey]; !ok {
+					return reg.ParameterError("on-missing", fmt.Errorf("The table does not contain the default key '%v'", EnrichDefaultKey))
+				}
+			default:
+				return reg.ParameterError("on-missing", fmt.Errorf("Must be %v or %v", EnrichMissingSkip, EnrichMissingDefault))
+			}
+			p.Add(&TagEnricher{KeyTag: key, Table: table, OnMissing: onMissing})
+			return
+		},
+		"Set additional tags on every sample, looked up in a static table by the value of the given key tag. The table is loaded from a JSON file "+
+			"(an object mapping every key to an object of tags), or a CSV file (the first column contains the keys, the other columns contain the tags, named by the header line). "+
+			"Samples with keys that are not in the table are left unchanged (on-missing="+EnrichMissingSkip+"), or receive the tags of the table row with the key '"+EnrichDefaultKey+"' (on-missing="+EnrichMissingDefault+").",
+		reg.RequiredParams("table", "key"), reg.OptionalParams("on-missing"))
+}
+
+// TagEnricher uses the value of the KeyTag to look up additional tags in the Table, and sets them on every sample.
+// If the KeyTag is missing, or its value is not contained in the Table, the sample is forwarded unchanged, or receives
+// the tags of the EnrichDefaultKey entry, if OnMissing is EnrichMissingDefault.
+type TagEnricher struct {
+	bitflow.NoopProcessor
+	KeyTag    string
+	Table     map[string]map[string]string
+	OnMissing string
+}
+
+func (e *TagEnricher) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	tags, ok := e.Table[sample.Tag(e.KeyTag)]
+	if !ok && e.OnMissing == EnrichMissingDefault {
+		tags, ok = e.Table[EnrichDefaultKey]
+	}
+	if ok {
+		for key, value := range tags {
+			sample.SetTag(key, value)
+		}
+	}
+	return e.NoopProcessor.Sample(sample, header)
+}
+
+func (e *TagEnricher) String() string {
+	return fmt.Sprintf("Enrich tags by tag '%v' (%v table entries, missing: %v)", e.KeyTag, len(e.Table), e.OnMissing)
+}
+
+// LoadEnrichmentTable loads the lookup table for the TagEnricher. Files with the extension .json must contain an object
+// mapping every key to an object of tags. All other files are parsed as CSV: the first column contains the keys, and the other
+// columns contain the tag values, where the tag names are taken from the header line. Empty CSV cells are not set as tags.
+func LoadEnrichmentTable(filename string) (map[string]map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // Drop error
+
+	table := make(map[string]map[string]string)
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		if err := json.NewDecoder(file).Decode(&table); err != nil {
+			return nil, fmt.Errorf("Failed to parse %v: %v", filename, err)
+		}
+		return table, nil
+	}
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %v: %v", filename, err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("Missing header line in " + filename)
+	}
+	columns := records[0]
+	for _, record := range records[1:] {
+		if _, ok := table[record[0]]; ok {
+			return nil, fmt.Errorf("Duplicate key '%v' in %v", record[0], filename)
+		}
+		tags := make(map[string]string, len(columns)-1)
+		for i, value := range record[1:] {
+			if value != "" {
+				tags[columns[i+1]] = value
+			}
+		}
+		table[record[0]] = tags
+	}
+	return table, nil
+}
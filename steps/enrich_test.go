@@ -0,0 +1,77 @@
+package steps
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _writeEnrichmentTable(t *testing.T, dir, name, content string) map[string]map[string]string {
+	assert := testAssert.New(t)
+	filename := filepath.Join(dir, name)
+	assert.NoError(ioutil.WriteFile(filename, []byte(content), 0644))
+	table, err := LoadEnrichmentTable(filename)
+	assert.NoError(err)
+	return table
+}
+
+func TestLoadEnrichmentTable(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-enrich")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	expected := map[string]map[string]string{
+		"host1": {"rack": "r1", "zone": "eu"},
+		"host2": {"rack": "r2"},
+	}
+	assert.Equal(expected, _writeEnrichmentTable(t, dir, "table.csv", "host,rack,zone\nhost1,r1,eu\nhost2,r2,\n"))
+	assert.Equal(expected, _writeEnrichmentTable(t, dir, "table.json", `{"host1": {"rack": "r1", "zone": "eu"}, "host2": {"rack": "r2"}}`))
+
+	duplicate := filepath.Join(dir, "duplicate.csv")
+	assert.NoError(ioutil.WriteFile(duplicate, []byte("host,rack\nhost1,r1\nhost1,r2\n"), 0644))
+	_, err = LoadEnrichmentTable(duplicate)
+	assert.Error(err)
+	_, err = LoadEnrichmentTable(filepath.Join(dir, "missing.csv"))
+	assert.Error(err)
+}
+
+func TestTagEnricher(t *testing.T) {
+	assert := testAssert.New(t)
+	table := map[string]map[string]string{
+		"host1":          {"rack": "r1", "zone": "eu"},
+		"host2":          {"rack": "r2"},
+		EnrichDefaultKey: {"rack": "unknown"},
+	}
+	header := &bitflow.Header{Fields: []string{"a"}}
+
+	for _, policy := range []string{EnrichMissingSkip, EnrichMissingDefault} {
+		enricher := &TagEnricher{KeyTag: "host", Table: table, OnMissing: policy}
+		out := new(testSampleCollector)
+		enricher.SetSink(out)
+		enricher.Start(new(sync.WaitGroup))
+
+		for _, host := range []string{"host1", "host2", "host3", ""} {
+			sample := &bitflow.Sample{Values: []bitflow.Value{1}}
+			if host != "" {
+				sample.SetTag("host", host)
+			}
+			assert.NoError(enricher.Sample(sample, header))
+		}
+		assert.Len(out.samples, 4)
+		assert.Equal(map[string]string{"host": "host1", "rack": "r1", "zone": "eu"}, out.samples[0].TagMap())
+		assert.Equal(map[string]string{"host": "host2", "rack": "r2"}, out.samples[1].TagMap())
+		if policy == EnrichMissingSkip {
+			assert.Equal(map[string]string{"host": "host3"}, out.samples[2].TagMap())
+			assert.Empty(out.samples[3].TagMap())
+		} else {
+			assert.Equal(map[string]string{"host": "host3", "rack": "unknown"}, out.samples[2].TagMap())
+			assert.Equal(map[string]string{"rack": "unknown"}, out.samples[3].TagMap())
+		}
+	}
+}
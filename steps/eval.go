@@ -0,0 +1,209 @@
+package steps
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// Matches assignments like "metric = expr" or "[metric-name] = expr", but not comparisons like "a == b" or "a <= b"
+var evalAssignmentRegex = regexp.MustCompile(`^\s*(\[[^\]]+\]|[\w.]+)\s*=([^=].*)$`)
+
+func RegisterEvalStep(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("eval",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			proc, err := NewEvalProcessor(params["script"])
+			if err != nil {
+				return reg.ParameterError("script", err)
+			}
+			p.Add(proc)
+			return nil
+		},
+		"Evaluate a script of ';'-separated statements on every sample. A statement like 'metric = <expression>' assigns the result to the metric "+
+			"(new metrics are appended to the header). Other statements are evaluated for their side effects, e.g. set_tag(). "+
+			"Expressions can access metric values by name and use the same functions as the 'do' step, e.g. tag(), timestamp().",
+		reg.RequiredParams("script"))
+}
+
+// EvalStatement is one statement of the script executed by EvalProcessor. If Target is non-empty,
+// the result of the expression is stored in the metric with that name.
+type EvalStatement struct {
+	Target string
+	expr   *Expression
+}
+
+func (s *EvalStatement) String() string {
+	if s.Target == "" {
+		return s.expr.expr.String()
+	}
+	return s.Target + " = " + s.expr.expr.String()
+}
+
+// EvalProcessor executes a list of EvalStatements on every sample, in the given order. Assignments are visible
+// to the following statements. Panics during the evaluation are turned into errors.
+// The evaluation has no timeout: the expression language has no loops or recursion, and all functions available
+// to the expressions return immediately, so the evaluation time is bounded by the length of the script. A timeout
+// would require an additional goroutine per sample, which could keep modifying the sample after the timeout.
+type EvalProcessor struct {
+	bitflow.NoopProcessor
+	Statements []*EvalStatement
+
+	checker       bitflow.HeaderChecker
+	outHeader     *bitflow.Header
+	targetIndices []int
+}
+
+func NewEvalProcessor(script string) (*EvalProcessor, error) {
+	proc := new(EvalProcessor)
+	for _, statement := range splitEvalStatements(script) {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if err := proc.AddStatement(statement); err != nil {
+			return nil, err
+		}
+	}
+	if len(proc.Statements) == 0 {
+		return nil, fmt.Errorf("Script does not contain any statements: %q", script)
+	}
+	return proc, nil
+}
+
+// splitEvalStatements splits the script at all semicolons that are not part of a quoted string or a bracketed metric name.
+func splitEvalStatements(script string) []string {
+	var statements []string
+	var quote rune
+	escaped, brackets := false, false
+	start := 0
+	for i, char := range script {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if char == '\\' {
+				escaped = true
+			} else if char == quote {
+				quote = 0
+			}
+		case brackets:
+			brackets = char != ']'
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '[':
+			brackets = true
+		case char == ';':
+			statements = append(statements, script[start:i])
+			start = i + 1
+		}
+	}
+	return append(statements, script[start:])
+}
+
+func (p *EvalProcessor) AddStatement(statement string) error {
+	var target string
+	if match := evalAssignmentRegex.FindStringSubmatch(statement); match != nil {
+		target = strings.TrimSuffix(strings.TrimPrefix(match[1], "["), "]")
+		statement = match[2]
+	}
+	expr, err := NewExpression(statement)
+	if err != nil {
+		return err
+	}
+	p.Statements = append(p.Statements, &EvalStatement{Target: target, expr: expr})
+	return nil
+}
+
+func (p *EvalProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		if err := p.updateHeader(header); err != nil {
+			return err
+		}
+	}
+	if len(sample.Values) < len(p.outHeader.Fields) {
+		values := sample.Values
+		if !sample.Resize(len(p.outHeader.Fields)) {
+			copy(sample.Values, values)
+		}
+		for i := len(values); i < len(sample.Values); i++ {
+			sample.Values[i] = 0
+		}
+	}
+	if err := p.evaluate(sample); err != nil {
+		return err
+	}
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+func (p *EvalProcessor) updateHeader(header *bitflow.Header) error {
+	fields := make([]string, len(header.Fields))
+	copy(fields, header.Fields)
+	indices := make(map[string]int, len(fields))
+	for i, field := range fields {
+		indices[field] = i
+	}
+	p.targetIndices = make([]int, len(p.Statements))
+	for i, statement := range p.Statements {
+		if statement.Target == "" {
+			p.targetIndices[i] = -1
+			continue
+		}
+		index, ok := indices[statement.Target]
+		if !ok {
+			index = len(fields)
+			fields = append(fields, statement.Target)
+			indices[statement.Target] = index
+		}
+		p.targetIndices[i] = index
+	}
+	p.outHeader = &bitflow.Header{Fields: fields}
+	for _, statement := range p.Statements {
+		if err := statement.expr.UpdateHeader(p.outHeader); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *EvalProcessor) evaluate(sample *bitflow.Sample) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v: Panic during evaluation: %v", p, r)
+		}
+	}()
+	for i, statement := range p.Statements {
+		res, err := statement.expr.Evaluate(sample, p.outHeader)
+		if err != nil {
+			return fmt.Errorf("Error evaluating '%v': %v", statement, err)
+		}
+		if index := p.targetIndices[i]; index >= 0 {
+			switch value := res.(type) {
+			case float64:
+				sample.Values[index] = bitflow.Value(value)
+			case bool:
+				if value {
+					sample.Values[index] = 1
+				} else {
+					sample.Values[index] = 0
+				}
+			default:
+				return fmt.Errorf("Statement '%v' returned non-numeric result: %v (%T)", statement, res, res)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *EvalProcessor) OutputSampleSize(sampleSize int) int {
+	return sampleSize + len(p.Statements)
+}
+
+func (p *EvalProcessor) String() string {
+	statements := make([]string, len(p.Statements))
+	for i, statement := range p.Statements {
+		statements[i] = statement.String()
+	}
+	return "Eval: " + strings.Join(statements, "; ")
+}
@@ -0,0 +1,86 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _runEvalScript(t *testing.T, script string, samples []*bitflow.Sample, header *bitflow.Header) (*testSampleCollector, []error) {
+	assert := testAssert.New(t)
+	proc, err := NewEvalProcessor(script)
+	assert.NoError(err)
+	out := new(testSampleCollector)
+	proc.SetSink(out)
+	proc.Start(new(sync.WaitGroup))
+	errors := make([]error, len(samples))
+	for i, sample := range samples {
+		errors[i] = proc.Sample(sample, header)
+	}
+	return out, errors
+}
+
+func TestEvalModifyValues(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"a", "b-c"}}
+	samples := []*bitflow.Sample{
+		{Values: []bitflow.Value{1, 2}},
+		{Values: []bitflow.Value{10, 20}},
+	}
+	out, errors := _runEvalScript(t, "a = a * 2; sum = a + [b-c]; [b-c] = sum > 10", samples, header)
+	assert.Equal([]error{nil, nil}, errors)
+	assert.Len(out.samples, 2)
+	assert.Equal([]string{"a", "b-c", "sum"}, out.headers[0].Fields)
+	assert.Equal([]bitflow.Value{2, 0, 4}, out.samples[0].Values)
+	assert.Equal([]bitflow.Value{20, 1, 40}, out.samples[1].Values)
+}
+
+func TestEvalSetTags(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"cpu"}}
+	samples := []*bitflow.Sample{
+		{Values: []bitflow.Value{10}, Time: time.Unix(100, 0)},
+		{Values: []bitflow.Value{90}, Time: time.Unix(200, 0)},
+	}
+	samples[1].SetTag("host", "x")
+	out, errors := _runEvalScript(t, `set_tag("load", cpu > 50 ? "high" : "low"); set_tag("host-copy", tag("host")); ts = timestamp()`, samples, header)
+	assert.Equal([]error{nil, nil}, errors)
+	assert.Len(out.samples, 2)
+	assert.Equal("low", out.samples[0].Tag("load"))
+	assert.Equal("", out.samples[0].Tag("host-copy"))
+	assert.Equal("high", out.samples[1].Tag("load"))
+	assert.Equal("x", out.samples[1].Tag("host-copy"))
+	assert.Equal([]bitflow.Value{10, 100}, out.samples[0].Values)
+	assert.Equal([]bitflow.Value{90, 200}, out.samples[1].Values)
+}
+
+func TestEvalQuotedSemicolons(t *testing.T) {
+	assert := testAssert.New(t)
+	assert.Equal([]string{`set_tag("k", "a;b")`, ` set_tag('x', 'y;\';z')`, ` [c;d] = 1`, ``},
+		splitEvalStatements(`set_tag("k", "a;b"); set_tag('x', 'y;\';z'); [c;d] = 1;`))
+
+	header := &bitflow.Header{Fields: []string{"a"}}
+	out, errors := _runEvalScript(t, `set_tag("k", "a;b"); b = a + 1`, []*bitflow.Sample{{Values: []bitflow.Value{1}}}, header)
+	assert.Equal([]error{nil}, errors)
+	if assert.Len(out.samples, 1) {
+		assert.Equal("a;b", out.samples[0].Tag("k"))
+		assert.Equal([]bitflow.Value{1, 2}, out.samples[0].Values)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	assert := testAssert.New(t)
+	_, err := NewEvalProcessor(" ; ")
+	assert.Error(err)
+	_, err = NewEvalProcessor("x = (")
+	assert.Error(err)
+
+	header := &bitflow.Header{Fields: []string{"a"}}
+	_, errors := _runEvalScript(t, `x = str(a)`, []*bitflow.Sample{{Values: []bitflow.Value{1}}}, header)
+	assert.Error(errors[0])
+	_, errors = _runEvalScript(t, `x = missing + 1`, []*bitflow.Sample{{Values: []bitflow.Value{1}}}, header)
+	assert.Error(errors[0])
+}
@@ -0,0 +1,191 @@
+package steps
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	DefaultNormalStateValue  = "normal"
+	DefaultEwmaAlpha         = 0.1
+	DefaultEwmaThreshold     = 3
+	DefaultEwmaWarmupSamples = 10
+)
+
+func RegisterEwmaAnomalyLabeler(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("ewma_anomaly",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &EwmaAnomalyLabeler{
+				NodeTag:      reg.StrParam(params, "node-tag", "", true, &err),
+				StateTag:     reg.StrParam(params, "state-tag", DefaultAnomalyStateTag, true, &err),
+				AnomalyValue: reg.StrParam(params, "anomaly-value", DefaultAnomalyStateValue, true, &err),
+				NormalValue:  reg.StrParam(params, "normal-value", DefaultNormalStateValue, true, &err),
+				Alpha:        reg.FloatParam(params, "alpha", DefaultEwmaAlpha, true, &err),
+				Threshold:    reg.FloatParam(params, "threshold", DefaultEwmaThreshold, true, &err),
+				Warmup:       reg.IntParam(params, "warmup", DefaultEwmaWarmupSamples, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			if step.Alpha <= 0 || step.Alpha > 1 {
+				return reg.ParameterError("alpha", errors.New("Must be in the range (0, 1]"))
+			}
+			if step.Threshold <= 0 {
+				return reg.ParameterError("threshold", errors.New("Must be > 0"))
+			}
+			if step.Warmup < 0 {
+				return reg.ParameterError("warmup", errors.New("Must not be negative"))
+			}
+			p.Add(step)
+			return
+		},
+		"Label every sample as normal or anomalous by setting the state-tag (default '"+DefaultAnomalyStateTag+"') to normal-value (default '"+DefaultNormalStateValue+"') "+
+			"or anomaly-value (default '"+DefaultAnomalyStateValue+"'). Every metric has an exponentially weighted moving average and variance with the given alpha "+
+			fmt.Sprintf("(default %v) as baseline. A sample is anomalous, when any metric deviates from its baseline by more than threshold (default %v) standard deviations. ", DefaultEwmaAlpha, DefaultEwmaThreshold)+
+			fmt.Sprintf("The first warmup samples (default %v) only initialize the baselines and are labeled as normal. When node-tag is given, every value of that tag has separate baselines.", DefaultEwmaWarmupSamples),
+		reg.OptionalParams("node-tag", "state-tag", "anomaly-value", "normal-value", "alpha", "threshold", "warmup"))
+}
+
+// EwmaAnomalyLabeler sets the StateTag of every sample to AnomalyValue or NormalValue, based on exponentially weighted
+// baselines of all metrics. For every metric, the labeler maintains the exponentially weighted moving average and variance
+// with the smoothing factor Alpha. A sample is anomalous if at least one metric deviates from its average by more than
+// Threshold standard deviations. The baselines are updated with every sample, including anomalous ones, and NaN values are ignored.
+// The first Warmup samples only initialize the baselines and are labeled as normal. If NodeTag is set, every value of that
+// tag has separate baselines, so the samples of multiple nodes can be labeled in one pipeline. The resulting labels follow the
+// same conventions as the AnomalyRate step. The baselines can be persisted through the bitflow.Checkpointable interface.
+type EwmaAnomalyLabeler struct {
+	bitflow.NoopProcessor
+	NodeTag      string
+	StateTag     string
+	AnomalyValue string
+	NormalValue  string
+	Alpha        float64
+	Threshold    float64
+	Warmup       int
+
+	checker        bitflow.HeaderChecker
+	baselines      map[string]*ewmaBaseline
+	restoredFields []string // Fields of the baselines restored by LoadState
+}
+
+type ewmaBaseline struct {
+	mean     []float64
+	variance []float64
+	counts   []int
+}
+
+func (l *EwmaAnomalyLabeler) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", l, len(sample.Values), len(header.Fields))
+	}
+	if l.checker.HeaderChanged(header) || l.baselines == nil {
+		// Restored baselines are kept, if they were stored for the same fields
+		if l.baselines == nil || !golib.EqualStrings(l.restoredFields, header.Fields) {
+			l.baselines = make(map[string]*ewmaBaseline)
+		}
+		l.restoredFields = nil
+	}
+	var node string
+	if l.NodeTag != "" {
+		node = sample.Tag(l.NodeTag)
+	}
+	baseline, ok := l.baselines[node]
+	if !ok {
+		baseline = &ewmaBaseline{
+			mean:     make([]float64, len(header.Fields)),
+			variance: make([]float64, len(header.Fields)),
+			counts:   make([]int, len(header.Fields)),
+		}
+		l.baselines[node] = baseline
+	}
+	state := l.NormalValue
+	if l.update(baseline, sample.Values) {
+		state = l.AnomalyValue
+	}
+	sample.SetTag(l.StateTag, state)
+	return l.NoopProcessor.Sample(sample, header)
+}
+
+// update returns true if any value deviates from its baseline by more than Threshold standard deviations,
+// and updates the baselines afterwards.
+func (l *EwmaAnomalyLabeler) update(baseline *ewmaBaseline, values []bitflow.Value) bool {
+	anomalous := false
+	for i, val := range values {
+		value := float64(val)
+		if math.IsNaN(value) {
+			continue
+		}
+		count := baseline.counts[i]
+		baseline.counts[i]++
+		if count == 0 {
+			baseline.mean[i] = value
+			continue
+		}
+		diff := value - baseline.mean[i]
+		if count >= l.Warmup && math.Abs(diff) > l.Threshold*math.Sqrt(baseline.variance[i]) {
+			anomalous = true
+		}
+		increment := l.Alpha * diff
+		baseline.mean[i] += increment
+		baseline.variance[i] = (1 - l.Alpha) * (baseline.variance[i] + diff*increment)
+	}
+	return anomalous
+}
+
+type ewmaState struct {
+	Fields    []string
+	Baselines map[string]ewmaBaselineState
+}
+
+type ewmaBaselineState struct {
+	Mean     []float64
+	Variance []float64
+	Counts   []int
+}
+
+// SaveState implements the bitflow.Checkpointable interface by storing the baselines of all nodes.
+func (l *EwmaAnomalyLabeler) SaveState(w io.Writer) error {
+	state := ewmaState{Baselines: make(map[string]ewmaBaselineState, len(l.baselines))}
+	if l.checker.LastHeader != nil {
+		state.Fields = l.checker.LastHeader.Fields
+	}
+	for node, baseline := range l.baselines {
+		state.Baselines[node] = ewmaBaselineState{Mean: baseline.mean, Variance: baseline.variance, Counts: baseline.counts}
+	}
+	return gob.NewEncoder(w).Encode(state)
+}
+
+// LoadState implements the bitflow.Checkpointable interface by restoring the baselines of all nodes.
+// The baselines are only used, if the first received header has the same fields as the header of the stored baselines.
+func (l *EwmaAnomalyLabeler) LoadState(r io.Reader) error {
+	var state ewmaState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	l.baselines = make(map[string]*ewmaBaseline, len(state.Baselines))
+	for node, baseline := range state.Baselines {
+		if len(baseline.Mean) != len(state.Fields) || len(baseline.Variance) != len(state.Fields) || len(baseline.Counts) != len(state.Fields) {
+			return fmt.Errorf("Inconsistent state for node '%v': expected %v values per baseline", node, len(state.Fields))
+		}
+		l.baselines[node] = &ewmaBaseline{mean: baseline.Mean, variance: baseline.Variance, counts: baseline.Counts}
+	}
+	l.restoredFields = state.Fields
+	l.checker = bitflow.HeaderChecker{} // Make sure the baselines are checked against the next header
+	return nil
+}
+
+func (l *EwmaAnomalyLabeler) String() string {
+	res := fmt.Sprintf("EWMA anomaly labeler (alpha %v, threshold %v, warmup %v, tag %v=%v/%v)",
+		l.Alpha, l.Threshold, l.Warmup, l.StateTag, l.NormalValue, l.AnomalyValue)
+	if l.NodeTag != "" {
+		res += " per " + l.NodeTag
+	}
+	return res
+}
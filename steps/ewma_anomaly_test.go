@@ -0,0 +1,122 @@
+package steps
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestEwmaAnomalyLabeler(t *testing.T) {
+	assert := testAssert.New(t)
+	labeler := &EwmaAnomalyLabeler{
+		NodeTag:      "node",
+		StateTag:     DefaultAnomalyStateTag,
+		AnomalyValue: DefaultAnomalyStateValue,
+		NormalValue:  DefaultNormalStateValue,
+		Alpha:        DefaultEwmaAlpha,
+		Threshold:    DefaultEwmaThreshold,
+		Warmup:       DefaultEwmaWarmupSamples,
+	}
+	// The labels are consumed by the AnomalyRate step
+	rate := &AnomalyRate{WindowSize: 100, NodeTag: "node", StateTag: DefaultAnomalyStateTag, AnomalyValue: DefaultAnomalyStateValue, Metric: DefaultAnomalyRateMetric}
+	out := new(testSampleCollector)
+	labeler.SetSink(rate)
+	rate.SetSink(out)
+	labeler.Start(new(sync.WaitGroup))
+	rate.Start(new(sync.WaitGroup))
+
+	// Node a oscillates between 10 and 11 with one spike, node b oscillates between 100 and 102 without spikes
+	header := &bitflow.Header{Fields: []string{"x"}}
+	start := time.Unix(1000, 0)
+	for i := 0; i < 23; i++ {
+		a := bitflow.Value(10 + i%2)
+		if i == 20 {
+			a = 50
+		}
+		for _, node := range []string{"a", "b"} {
+			value := a
+			if node == "b" {
+				value = bitflow.Value(100 + 2*(i%2))
+			}
+			sample := &bitflow.Sample{Time: start.Add(time.Duration(i) * time.Second), Values: []bitflow.Value{value}}
+			sample.SetTag("node", node)
+			assert.NoError(labeler.Sample(sample, header))
+		}
+	}
+
+	assert.Len(out.samples, 46)
+	for i, sample := range out.samples {
+		expected := DefaultNormalStateValue
+		if i == 40 {
+			expected = DefaultAnomalyStateValue
+		}
+		assert.Equal(expected, sample.Tag(DefaultAnomalyStateTag), "sample %v", i)
+	}
+	last := out.samples[len(out.samples)-2:]
+	assert.Equal("a", last[0].Tag("node"))
+	assert.Equal(bitflow.Value(1.0/23), last[0].Values[1])
+	assert.Equal(bitflow.Value(0), last[1].Values[1])
+}
+
+func _runCheckpointedEwmaLabeler(t *testing.T, file string, values ...bitflow.Value) *testSampleCollector {
+	assert := testAssert.New(t)
+	labeler := &EwmaAnomalyLabeler{
+		StateTag:     DefaultAnomalyStateTag,
+		AnomalyValue: DefaultAnomalyStateValue,
+		NormalValue:  DefaultNormalStateValue,
+		Alpha:        DefaultEwmaAlpha,
+		Threshold:    DefaultEwmaThreshold,
+		Warmup:       DefaultEwmaWarmupSamples,
+	}
+	coordinator := bitflow.NewCheckpointCoordinator(file, time.Hour, []bitflow.SampleProcessor{labeler})
+	assert.Len(coordinator.Steps, 1)
+	out := new(testSampleCollector)
+	coordinator.SetSink(labeler)
+	labeler.SetSink(out)
+
+	var wg sync.WaitGroup
+	labeler.Start(&wg)
+	stopped := coordinator.Start(&wg)
+	assert.False(stopped.Stopped(), "coordinator stopped: %v", stopped.Err())
+	header := &bitflow.Header{Fields: []string{"x"}}
+	for _, value := range values {
+		assert.NoError(coordinator.Sample(&bitflow.Sample{Values: []bitflow.Value{value}}, header))
+	}
+	coordinator.Close()
+	wg.Wait()
+	return out
+}
+
+func TestEwmaAnomalyLabelerCheckpoint(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-checkpoint-")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+	file := filepath.Join(dir, "state")
+
+	warmup := make([]bitflow.Value, 2*DefaultEwmaWarmupSamples)
+	for i := range warmup {
+		warmup[i] = bitflow.Value(10 + i%2)
+	}
+	out := _runCheckpointedEwmaLabeler(t, file, warmup...)
+	assert.Len(out.samples, len(warmup))
+	_, err = os.Stat(file)
+	assert.NoError(err, "checkpoint file should be written when closing")
+
+	// The restored baseline immediately detects the spike
+	out = _runCheckpointedEwmaLabeler(t, file, 50, 10)
+	assert.Len(out.samples, 2)
+	assert.Equal(DefaultAnomalyStateValue, out.samples[0].Tag(DefaultAnomalyStateTag))
+
+	// Without the checkpoint, the first sample only initializes the baseline
+	assert.NoError(os.Remove(file))
+	out = _runCheckpointedEwmaLabeler(t, file, 50, 10)
+	assert.Equal(DefaultNormalStateValue, out.samples[0].Tag(DefaultAnomalyStateTag))
+	assert.Equal(DefaultNormalStateValue, out.samples[1].Tag(DefaultAnomalyStateTag))
+}
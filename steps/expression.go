@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"strconv"
 	"time"
 
 	"github.com/Knetic/govaluate"
@@ -144,6 +145,17 @@ func (p *Expression) makeFunctions() map[string]govaluate.ExpressionFunction {
 			}
 			return nil, fmt.Errorf("floor() needs 1 float64 parameter, but received: %v", printParamStrings(arguments))
 		},
+		"float": func(arguments ...interface{}) (interface{}, error) {
+			if len(arguments) == 1 {
+				switch arg := arguments[0].(type) {
+				case float64:
+					return arg, nil
+				case string:
+					return strconv.ParseFloat(arg, 64)
+				}
+			}
+			return nil, fmt.Errorf("float() needs 1 float64 or string parameter, but received: %v", printParamStrings(arguments))
+		},
 	}
 }
 
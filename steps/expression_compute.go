@@ -0,0 +1,129 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterComputeMetrics(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("compute",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			if len(params) == 0 {
+				return errors.New("Need at least one metric=expression parameter")
+			}
+			names := make([]string, 0, len(params))
+			for name := range params {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			proc := new(ComputeProcessor)
+			for _, name := range names {
+				if err := proc.AddMetric(name, params[name]); err != nil {
+					return reg.ParameterError(name, err)
+				}
+			}
+			p.Add(proc)
+			return nil
+		},
+		"Compute metrics from arithmetic expressions, given as metric=expression parameters, e.g. cpu_total='cpu_user + cpu_sys'. "+
+			"Expressions can use + - * /, parentheses, numeric literals, the values of other metrics, and the functions of the 'do' step. "+
+			"Tag values can be used through float(tag('name')). New metrics are appended in alphabetical order, existing metrics are overwritten. "+
+			"All expressions are evaluated on the incoming metric values.")
+}
+
+// ComputeProcessor evaluates a number of expressions for every sample and stores the results as metrics.
+// If the header does not contain a metric with the configured name, it is appended to the header,
+// otherwise the value of the existing metric is replaced. All expressions are evaluated on the incoming
+// values, so they cannot refer to the results of other expressions of the same ComputeProcessor.
+// Boolean results are converted to 1 and 0, other non-numeric results lead to an error.
+type ComputeProcessor struct {
+	bitflow.NoopProcessor
+
+	names       []string
+	expressions []*Expression
+	checker     bitflow.HeaderChecker
+	outHeader   *bitflow.Header
+	indices     []int // Output index for every expression
+}
+
+// AddMetric parses the given expression and adds its result as a metric with the given name.
+func (p *ComputeProcessor) AddMetric(name string, expressionString string) error {
+	expr, err := NewExpression(expressionString)
+	if err != nil {
+		return err
+	}
+	p.names = append(p.names, name)
+	p.expressions = append(p.expressions, expr)
+	return nil
+}
+
+func (p *ComputeProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		if err := p.updateHeader(header); err != nil {
+			p.checker.LastHeader = nil // Retry with the next sample
+			return err
+		}
+	}
+	results := make([]bitflow.Value, len(p.expressions))
+	for i, expr := range p.expressions {
+		res, err := expr.Evaluate(sample, header)
+		if err != nil {
+			return fmt.Errorf("%v: Error computing metric %v: %v", p, p.names[i], err)
+		}
+		switch val := res.(type) {
+		case float64:
+			results[i] = bitflow.Value(val)
+		case bool:
+			if val {
+				results[i] = 1
+			}
+		default:
+			return fmt.Errorf("%v: Non-numeric result for metric %v: %v (%T)", p, p.names[i], res, res)
+		}
+	}
+	for len(sample.Values) < len(p.outHeader.Fields) {
+		sample.Values = append(sample.Values, 0)
+	}
+	for i, index := range p.indices {
+		sample.Values[index] = results[i]
+	}
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+func (p *ComputeProcessor) updateHeader(header *bitflow.Header) error {
+	fields := make([]string, len(header.Fields), len(header.Fields)+len(p.names))
+	copy(fields, header.Fields)
+	p.indices = make([]int, len(p.names))
+	fieldIndices := header.BuildIndex()
+	for i, expr := range p.expressions {
+		if err := expr.UpdateHeader(header); err != nil {
+			return fmt.Errorf("%v: Cannot compute metric %v: %v", p, p.names[i], err)
+		}
+		index, ok := fieldIndices[p.names[i]]
+		if !ok {
+			index = len(fields)
+			fieldIndices[p.names[i]] = index
+			fields = append(fields, p.names[i])
+		}
+		p.indices[i] = index
+	}
+	p.outHeader = header.Clone(fields)
+	return nil
+}
+
+func (p *ComputeProcessor) OutputSampleSize(sampleSize int) int {
+	return sampleSize + len(p.names)
+}
+
+func (p *ComputeProcessor) String() string {
+	metrics := make([]string, len(p.names))
+	for i, name := range p.names {
+		metrics[i] = name + " = " + p.expressions[i].expr.String()
+	}
+	return "Compute metrics: " + strings.Join(metrics, "; ")
+}
@@ -0,0 +1,40 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestComputeProcessor(t *testing.T) {
+	assert := testAssert.New(t)
+	p := new(ComputeProcessor)
+	assert.NoError(p.AddMetric("a", "a * 2"))
+	assert.NoError(p.AddMetric("total", "(a + b) / 2 - 1"))
+	assert.NoError(p.AddMetric("scaled", "b * float(tag('factor'))"))
+	assert.Error(p.AddMetric("invalid", "a + * b"))
+	out := new(testSampleCollector)
+	p.SetSink(out)
+	p.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	sample := &bitflow.Sample{Values: []bitflow.Value{1, 5}}
+	sample.SetTag("factor", "0.5")
+	assert.NoError(p.Sample(sample, header))
+	assert.Len(out.samples, 1)
+	assert.Equal([]string{"a", "b", "total", "scaled"}, out.headers[0].Fields)
+	assert.Equal([]bitflow.Value{2, 5, 2, 2.5}, out.samples[0].Values)
+
+	// Missing field after a header change
+	err := p.Sample(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"a"}})
+	assert.Error(err)
+	assert.Contains(err.Error(), "Variable b cannot be resolved")
+	assert.Contains(err.Error(), "metric total")
+
+	// Missing tag
+	err = p.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, header)
+	assert.Error(err)
+	p.Close()
+}
@@ -0,0 +1,103 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/antongulenko/go-onlinestats"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultOutlierThreshold = 3.0
+
+func RegisterOutlierFilter(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("filter_outliers",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			threshold := reg.FloatParam(params, "threshold", DefaultOutlierThreshold, true, &err)
+			metrics := reg.StrParam(params, "metrics", "", true, &err)
+			if err != nil {
+				return
+			}
+			if threshold <= 0 {
+				return reg.ParameterError("threshold", errors.New("Must be > 0"))
+			}
+			step := &OutlierFilter{Threshold: threshold}
+			if metrics != "" {
+				if step.Metrics, err = regexp.Compile(metrics); err != nil {
+					return reg.ParameterError("metrics", err)
+				}
+			}
+			p.Batch(step)
+			return
+		},
+		fmt.Sprintf("Drop all samples of a batch, where the value of any metric has a z-score (distance from the batch mean in standard deviations) above the threshold (default %v). ", DefaultOutlierThreshold)+
+			"The metrics regex optionally selects the checked metrics (all metrics by default). NaN values are ignored.",
+		reg.OptionalParams("threshold", "metrics"), reg.SupportBatch())
+}
+
+// OutlierFilter drops samples from a batch, if the value of at least one selected metric deviates from the mean of
+// that metric by more than Threshold standard deviations. The mean and standard deviation are computed over the entire
+// batch. If Metrics is set, only the matching metrics are checked, otherwise all metrics. NaN values are ignored, and metrics
+// with a standard deviation of zero never contain outliers. The header is not changed.
+type OutlierFilter struct {
+	Threshold float64
+	Metrics   *regexp.Regexp
+}
+
+func (f *OutlierFilter) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	var indices []int
+	for i, field := range header.Fields {
+		if f.Metrics == nil || f.Metrics.MatchString(field) {
+			indices = append(indices, i)
+		}
+	}
+	stats := make([]onlinestats.Running, len(indices))
+	for _, sample := range samples {
+		if len(sample.Values) != len(header.Fields) {
+			return nil, nil, fmt.Errorf("%v: Sample has %v values, but header has %v fields", f, len(sample.Values), len(header.Fields))
+		}
+		for i, index := range indices {
+			if val := float64(sample.Values[index]); !math.IsNaN(val) {
+				stats[i].Push(val)
+			}
+		}
+	}
+	means := make([]float64, len(indices))
+	limits := make([]float64, len(indices))
+	for i := range stats {
+		means[i] = stats[i].Mean()
+		limits[i] = f.Threshold * stats[i].Stddev()
+	}
+
+	result := samples[:0]
+	for _, sample := range samples {
+		if !f.isOutlier(sample, indices, means, limits) {
+			result = append(result, sample)
+		}
+	}
+	if removed := len(samples) - len(result); removed > 0 {
+		log.Printf("%v: Removed %v of %v samples", f, removed, len(samples))
+	}
+	return header, result, nil
+}
+
+func (f *OutlierFilter) isOutlier(sample *bitflow.Sample, indices []int, means, limits []float64) bool {
+	for i, index := range indices {
+		if limits[i] > 0 && math.Abs(float64(sample.Values[index])-means[i]) > limits[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *OutlierFilter) String() string {
+	res := fmt.Sprintf("Filter outliers with z-score > %v", f.Threshold)
+	if f.Metrics != nil {
+		res += fmt.Sprintf(" (metrics matching %v)", f.Metrics)
+	}
+	return res
+}
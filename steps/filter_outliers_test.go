@@ -0,0 +1,56 @@
+package steps
+
+import (
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _outlierSamples() []*bitflow.Sample {
+	samples := make([]*bitflow.Sample, 21)
+	for i := range samples {
+		x, y := bitflow.Value(10+i%2), bitflow.Value(i%2)
+		switch i {
+		case 5:
+			y = 1000 // Outlier in y
+		case 7:
+			x = bitflow.Value(math.NaN())
+		case 20:
+			x = 100 // Outlier in x
+		}
+		samples[i] = &bitflow.Sample{Values: []bitflow.Value{x, y}}
+	}
+	return samples
+}
+
+func TestOutlierFilter(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"x", "y"}}
+
+	filter := &OutlierFilter{Threshold: DefaultOutlierThreshold}
+	input := _outlierSamples()
+	outHeader, out, err := filter.ProcessBatch(header, input)
+	assert.NoError(err)
+	assert.Equal(header, outHeader)
+	assert.Len(out, 19)
+	for _, sample := range out {
+		assert.NotEqual(bitflow.Value(100), sample.Values[0])
+		assert.NotEqual(bitflow.Value(1000), sample.Values[1])
+	}
+
+	// Only check the x metric
+	filter.Metrics = regexp.MustCompile("^x$")
+	_, out, err = filter.ProcessBatch(header, _outlierSamples())
+	assert.NoError(err)
+	assert.Len(out, 20)
+	assert.Equal(bitflow.Value(1000), out[5].Values[1])
+
+	// A higher threshold keeps all samples
+	filter.Threshold = 5
+	_, out, err = filter.ProcessBatch(header, _outlierSamples())
+	assert.NoError(err)
+	assert.Len(out, 21)
+}
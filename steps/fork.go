@@ -1,6 +1,7 @@
 package steps
 
 import (
+	"flag"
 	"fmt"
 	"sort"
 	"strconv"
@@ -10,11 +11,44 @@ import (
 	"github.com/bitflow-stream/go-bitflow/script/reg"
 )
 
+// DefaultForkCardinalityGuard contains the defaults for the -fork-max-keys and -fork-max-keys-policy flags.
+// It limits the number of distinct keys in the fork_tag, fork_tag_template and output_files steps, which can be
+// overridden through the max-keys and max-keys-policy parameters of these steps.
+var DefaultForkCardinalityGuard = fork.CardinalityGuard{Policy: fork.CardinalityPolicySkip}
+
 // This function is placed in this package to avoid circular dependency between the fork and the query package.
 func RegisterForks(b reg.ProcessorRegistry) {
+	b.Endpoints.CustomGeneralFlags = append(b.Endpoints.CustomGeneralFlags, func(f *flag.FlagSet) {
+		f.IntVar(&DefaultForkCardinalityGuard.Limit, "fork-max-keys", DefaultForkCardinalityGuard.Limit,
+			"Maximum number of distinct tag values or file names in tag-based forks and output_files. Zero means unlimited.")
+		f.StringVar(&DefaultForkCardinalityGuard.Policy, "fork-max-keys-policy", DefaultForkCardinalityGuard.Policy,
+			fmt.Sprintf("Handling of new tag values or file names exceeding -fork-max-keys: '%v' bypasses the subpipelines, "+
+				"'%v' routes them to a shared subpipeline or file, where the tags are replaced by '%v'.",
+				fork.CardinalityPolicySkip, fork.CardinalityPolicyOverflow, fork.CardinalityOverflowValue))
+	})
 	b.RegisterFork("rr", fork_round_robin, "The round-robin fork distributes the samples to the subpipelines based on weights. The pipeline selector keys must be positive integers denoting the weight of the respective pipeline.")
-	b.RegisterFork("fork_tag", fork_tag, "Fork based on the values of the given tag", reg.RequiredParams("tag"), reg.OptionalParams("regex", "exact"))
-	b.RegisterFork("fork_tag_template", fork_tag_template, "Fork based on a template string, placeholders like ${xxx} are replaced by tag values.", reg.RequiredParams("template"), reg.OptionalParams("regex", "exact"))
+	b.RegisterFork("fork_tag", fork_tag, "Fork based on the values of the given tag. The number of distinct tag values can be limited with max-keys and max-keys-policy (see -fork-max-keys). "+
+		"Samples with tag values that match no subpipeline key bypass the fork, unless the default parameter names the key of a subpipeline receiving them. "+
+		"To drop these samples, use a default subpipeline containing drop().",
+		reg.RequiredParams("tag"), reg.OptionalParams("regex", "exact", "default", "max-keys", "max-keys-policy"))
+	b.RegisterFork("fork_tag_template", fork_tag_template, "Fork based on a template string, placeholders like ${xxx} are replaced by tag values. "+
+		"The number of distinct keys can be limited with max-keys and max-keys-policy (see -fork-max-keys). The default parameter works like in fork_tag.",
+		reg.RequiredParams("template"), reg.OptionalParams("regex", "exact", "default", "max-keys", "max-keys-policy"))
+}
+
+// parseCardinalityGuard reads the max-keys and max-keys-policy parameters, using DefaultForkCardinalityGuard as defaults
+func parseCardinalityGuard(params map[string]string) (fork.CardinalityGuard, error) {
+	var err error
+	guard := fork.CardinalityGuard{
+		Limit:  reg.IntParam(params, "max-keys", DefaultForkCardinalityGuard.Limit, true, &err),
+		Policy: reg.StrParam(params, "max-keys-policy", DefaultForkCardinalityGuard.Policy, true, &err),
+	}
+	if err == nil {
+		if policyErr := fork.CheckCardinalityPolicy(guard.Policy); policyErr != nil {
+			err = reg.ParameterError("max-keys-policy", policyErr)
+		}
+	}
+	return guard, err
 }
 
 func fork_round_robin(subpipelines []reg.Subpipeline, _ map[string]string) (fork.Distributor, error) {
@@ -74,8 +108,12 @@ func fork_tag_template(subpipelines []reg.Subpipeline, params map[string]string)
 			Pipelines:  wildcardPipelines,
 			ExactMatch: reg.BoolParam(params, "exact", false, true, &err),
 			RegexMatch: reg.BoolParam(params, "regex", false, true, &err),
+			DefaultKey: reg.StrParam(params, "default", "", true, &err),
 		},
 	}
+	if err == nil {
+		dist.Guard, err = parseCardinalityGuard(params)
+	}
 	if err == nil {
 		err = dist.Init()
 	}
@@ -0,0 +1,197 @@
+package grpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+)
+
+// Message is one message of the Bitflow.Stream RPC, see bitflow.proto. Exactly one of Header and Sample is set.
+type Message struct {
+	Header *bitflow.Header
+	Sample *bitflow.Sample
+}
+
+// Codec marshals and unmarshals Message instances in the protobuf wire format defined in bitflow.proto.
+// It implements the encoding.Codec interface of the gRPC library.
+type Codec struct{}
+
+const (
+	protoVarint          = 0
+	protoFixed64         = 1
+	protoLengthDelimited = 2
+	protoFixed32         = 5
+)
+
+func (Codec) Name() string {
+	return "bitflow-proto"
+}
+
+func (c Codec) String() string {
+	return c.Name()
+}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(*Message)
+	if !ok {
+		return nil, fmt.Errorf("Cannot marshal %T, expected *grpc.Message", v)
+	}
+	var buf []byte
+	switch {
+	case msg.Header != nil:
+		for _, field := range msg.Header.Fields {
+			buf = appendProtoBytes(buf, 1, []byte(field))
+		}
+		return appendProtoBytes(nil, 1, buf), nil
+	case msg.Sample != nil:
+		sample := msg.Sample
+		buf = appendProtoKey(buf, 1, protoVarint)
+		buf = appendUvarint(buf, uint64(sample.Time.UnixNano()))
+		if len(sample.Values) > 0 {
+			values := make([]byte, 0, len(sample.Values)*8)
+			for _, value := range sample.Values {
+				values = appendFixed64(values, math.Float64bits(float64(value)))
+			}
+			buf = appendProtoBytes(buf, 2, values)
+		}
+		var entry []byte
+		for _, tag := range sample.SortedTags() {
+			entry = appendProtoBytes(entry[:0], 1, []byte(tag.Key))
+			entry = appendProtoBytes(entry, 2, []byte(tag.Value))
+			buf = appendProtoBytes(buf, 3, entry)
+		}
+		return appendProtoBytes(nil, 2, buf), nil
+	default:
+		return nil, errors.New("Cannot marshal empty message")
+	}
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(*Message)
+	if !ok {
+		return fmt.Errorf("Cannot unmarshal into %T, expected *grpc.Message", v)
+	}
+	*msg = Message{}
+	return parseProto(data, func(field int, wireType int, value uint64, content []byte) (err error) {
+		switch {
+		case field == 1 && wireType == protoLengthDelimited:
+			msg.Header, msg.Sample = new(bitflow.Header), nil
+			err = parseProto(content, func(field int, wireType int, _ uint64, content []byte) error {
+				if field == 1 && wireType == protoLengthDelimited {
+					msg.Header.Fields = append(msg.Header.Fields, string(content))
+				}
+				return nil
+			})
+		case field == 2 && wireType == protoLengthDelimited:
+			msg.Header, msg.Sample = nil, new(bitflow.Sample)
+			err = parseSample(content, msg.Sample)
+		}
+		return
+	})
+}
+
+func parseSample(data []byte, sample *bitflow.Sample) error {
+	return parseProto(data, func(field int, wireType int, value uint64, content []byte) error {
+		switch {
+		case field == 1 && wireType == protoVarint:
+			sample.Time = time.Unix(0, int64(value))
+		case field == 2 && wireType == protoFixed64:
+			sample.Values = append(sample.Values, bitflow.Value(math.Float64frombits(value)))
+		case field == 2 && wireType == protoLengthDelimited: // Packed encoding
+			if len(content)%8 != 0 {
+				return fmt.Errorf("Invalid length of packed sample values: %v", len(content))
+			}
+			for i := 0; i < len(content); i += 8 {
+				sample.Values = append(sample.Values, bitflow.Value(math.Float64frombits(binary.LittleEndian.Uint64(content[i:]))))
+			}
+		case field == 3 && wireType == protoLengthDelimited:
+			var key, val string
+			err := parseProto(content, func(field int, wireType int, _ uint64, content []byte) error {
+				if wireType == protoLengthDelimited {
+					if field == 1 {
+						key = string(content)
+					} else if field == 2 {
+						val = string(content)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			sample.SetTag(key, val)
+		}
+		return nil
+	})
+}
+
+// parseProto calls the handler for every field in the given protobuf message. For varint and fixed fields, the value
+// is passed in the value parameter, for length-delimited fields the data is passed in the content parameter.
+func parseProto(data []byte, handler func(field int, wireType int, value uint64, content []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return io.ErrUnexpectedEOF
+		}
+		data = data[n:]
+		field, wireType := int(key>>3), int(key&7)
+		var value uint64
+		var content []byte
+		switch wireType {
+		case protoVarint:
+			if value, n = binary.Uvarint(data); n <= 0 {
+				return io.ErrUnexpectedEOF
+			}
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return io.ErrUnexpectedEOF
+			}
+			value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return io.ErrUnexpectedEOF
+			}
+			value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case protoLengthDelimited:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return io.ErrUnexpectedEOF
+			}
+			content, data = data[n:n+int(length)], data[n+int(length):]
+		default:
+			return fmt.Errorf("Unsupported protobuf wire type %v (field %v)", wireType, field)
+		}
+		if err := handler(field, wireType, value, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendProtoKey(buf []byte, field int, wireType int) []byte {
+	return appendUvarint(buf, uint64(field<<3|wireType))
+}
+
+func appendProtoBytes(buf []byte, field int, data []byte) []byte {
+	buf = appendProtoKey(buf, field, protoLengthDelimited)
+	buf = appendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func appendUvarint(buf []byte, val uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], val)
+	return append(buf, tmp[:n]...)
+}
+
+func appendFixed64(buf []byte, val uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], val)
+	return append(buf, tmp[:]...)
+}
@@ -0,0 +1,293 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	GrpcEndpoint = bitflow.EndpointType("grpc")
+
+	bitflowStreamMethod = "/bitflow.Bitflow/Stream"
+)
+
+// bitflowServiceDesc describes the Bitflow service defined in bitflow.proto
+var bitflowServiceDesc = grpc.ServiceDesc{
+	ServiceName: "bitflow.Bitflow",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName: "Stream",
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(*GrpcSource).handleStream(stream)
+		},
+		ServerStreams: true,
+		ClientStreams: true,
+	}},
+	Metadata: "bitflow.proto",
+}
+
+// GrpcConfig configures the TLS settings of the gRPC data sources and sinks. Without certificates, the connections are not encrypted.
+type GrpcConfig struct {
+	// CertFile and KeyFile contain the certificate and private key of the gRPC server (the grpc:// data source), and enable TLS.
+	CertFile string
+	KeyFile  string
+
+	// CaFile contains the certificates for verifying the gRPC server, and enables TLS for the grpc:// data sink.
+	CaFile string
+}
+
+// RegisterGrpcEndpoints registers the 'grpc' data source and sink, which exchange samples through the Bitflow.Stream RPC defined in bitflow.proto.
+// The data source is a gRPC server listening on the endpoint, e.g. grpc://:7000, and the data sink connects to a server, e.g. grpc://host:7000.
+// Both use the flow control of gRPC streams, so slow receivers block the sending pipeline. The TLS settings are configured through the -grpc-* flags.
+func RegisterGrpcEndpoints(b reg.ProcessorRegistry) {
+	var config GrpcConfig
+	b.Endpoints.CustomGeneralFlags = append(b.Endpoints.CustomGeneralFlags, func(f *flag.FlagSet) {
+		f.StringVar(&config.CertFile, "grpc-tls-cert", config.CertFile, "Certificate file for accepting TLS connections in grpc:// inputs. Requires -grpc-tls-key.")
+		f.StringVar(&config.KeyFile, "grpc-tls-key", config.KeyFile, "Private key file for accepting TLS connections in grpc:// inputs")
+		f.StringVar(&config.CaFile, "grpc-tls-ca", config.CaFile, "CA certificate file for verifying the servers of grpc:// outputs. Enables TLS for outgoing connections.")
+	})
+	b.Endpoints.CustomDataSources[GrpcEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		if err := checkGrpcEndpoint(target); err != nil {
+			return nil, err
+		}
+		if (config.CertFile == "") != (config.KeyFile == "") {
+			return nil, errors.New("The -grpc-tls-cert and -grpc-tls-key flags must be defined together")
+		}
+		return &GrpcSource{Endpoint: target, Config: config}, nil
+	}
+	b.Endpoints.CustomDataSinks[GrpcEndpoint] = func(target string) (bitflow.SampleProcessor, error) {
+		if err := checkGrpcEndpoint(target); err != nil {
+			return nil, err
+		}
+		return &GrpcSink{Endpoint: target, Config: config}, nil
+	}
+}
+
+func checkGrpcEndpoint(target string) error {
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return fmt.Errorf("gRPC endpoint must have the form host:port, received: %v", target)
+	}
+	return nil
+}
+
+// GrpcSink connects to a gRPC server (usually a GrpcSource) and sends all samples through one Bitflow.Stream RPC.
+// The header is sent before the first sample and whenever it changes. The stream is opened with the first sample. If sending fails,
+// the stream is discarded and a new stream is opened for the next sample, which allows reconnecting when output errors are dropped.
+type GrpcSink struct {
+	bitflow.AbstractSampleOutput
+	Endpoint string
+	Config   GrpcConfig
+
+	conn    *grpc.ClientConn
+	stream  grpc.ClientStream
+	cancel  context.CancelFunc
+	checker bitflow.HeaderChecker
+}
+
+func (sink *GrpcSink) String() string {
+	return fmt.Sprintf("gRPC sink to %v", sink.Endpoint)
+}
+
+func (sink *GrpcSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
+	opts := []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.ForceCodec(Codec{}))}
+	if sink.Config.CaFile != "" {
+		creds, err := credentials.NewClientTLSFromFile(sink.Config.CaFile, "")
+		if err != nil {
+			return golib.NewStoppedChan(fmt.Errorf("%v: Failed to load TLS certificates: %v", sink, err))
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	conn, err := grpc.Dial(sink.Endpoint, opts...)
+	if err != nil {
+		return golib.NewStoppedChan(fmt.Errorf("%v: %v", sink, err))
+	}
+	sink.conn = conn
+	log.Println("Sending samples to gRPC server", sink.Endpoint)
+	return
+}
+
+func (sink *GrpcSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	err := sink.send(sample, header)
+	if err != nil {
+		sink.closeStream()
+		err = fmt.Errorf("%v: %v", sink, err)
+	}
+	return sink.AbstractSampleOutput.Sample(err, sample, header)
+}
+
+func (sink *GrpcSink) send(sample *bitflow.Sample, header *bitflow.Header) error {
+	if sink.stream == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := sink.conn.NewStream(ctx, &bitflowServiceDesc.Streams[0], bitflowStreamMethod)
+		if err != nil {
+			cancel()
+			return err
+		}
+		sink.stream, sink.cancel = stream, cancel
+		sink.checker = bitflow.HeaderChecker{}
+	}
+	var err error
+	if sink.checker.HeaderChanged(header) {
+		err = sink.stream.SendMsg(&Message{Header: header})
+	}
+	if err == nil {
+		err = sink.stream.SendMsg(&Message{Sample: sample})
+	}
+	if err == io.EOF {
+		// The stream was aborted, the actual error is returned by RecvMsg
+		err = sink.stream.RecvMsg(new(Message))
+	}
+	return err
+}
+
+// closeStream ends the current stream and waits for the server to process all samples.
+func (sink *GrpcSink) closeStream() error {
+	if sink.stream == nil {
+		return nil
+	}
+	defer sink.cancel()
+	err := sink.stream.CloseSend()
+	if err == nil {
+		if err = sink.stream.RecvMsg(new(Message)); err == io.EOF {
+			err = nil
+		}
+	}
+	sink.stream = nil
+	return err
+}
+
+func (sink *GrpcSink) Close() {
+	if err := sink.closeStream(); err != nil {
+		log.Errorf("%v: Error closing stream: %v", sink, err)
+	}
+	if sink.conn != nil {
+		if err := sink.conn.Close(); err != nil {
+			log.Errorf("%v: Error closing connection: %v", sink, err)
+		}
+	}
+	sink.CloseSink()
+}
+
+// GrpcSource runs a gRPC server, which implements the Bitflow.Stream RPC and forwards the samples of all streams
+// to the subsequent processing steps. Multiple clients can send samples at the same time, the samples are forwarded sequentially.
+type GrpcSource struct {
+	bitflow.AbstractSampleSource
+	Endpoint string
+	Config   GrpcConfig
+
+	listener net.Listener
+	server   *grpc.Server
+	stopped  golib.StopChan
+	lock     sync.Mutex // Serializes the samples of all streams
+	closed   bool
+	streams  sync.WaitGroup
+}
+
+func (source *GrpcSource) String() string {
+	return fmt.Sprintf("gRPC source on %v", source.Endpoint)
+}
+
+func (source *GrpcSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(Codec{})}
+	if source.Config.CertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(source.Config.CertFile, source.Config.KeyFile)
+		if err != nil {
+			source.CloseSinkParallel(wg)
+			return golib.NewStoppedChan(fmt.Errorf("%v: Failed to load TLS certificate: %v", source, err))
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	listener, err := net.Listen("tcp", source.Endpoint)
+	if err != nil {
+		source.CloseSinkParallel(wg)
+		return golib.NewStoppedChan(fmt.Errorf("%v: Failed to listen: %v", source, err))
+	}
+	source.listener = listener
+	source.stopped = golib.NewStopChan()
+	source.server = grpc.NewServer(opts...)
+	source.server.RegisterService(&bitflowServiceDesc, source)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := source.server.Serve(listener); err != nil {
+			source.stopped.StopErr(fmt.Errorf("%v: %v", source, err))
+		}
+	}()
+	log.Println("Receiving samples through gRPC on", listener.Addr())
+
+	return golib.WaitErrFunc(wg, func() error {
+		defer source.CloseSinkParallel(wg)
+		source.stopped.Wait()
+		source.lock.Lock()
+		source.closed = true
+		source.lock.Unlock()
+		source.server.Stop()
+		source.streams.Wait()
+		return source.stopped.Err()
+	})
+}
+
+func (source *GrpcSource) handleStream(stream grpc.ServerStream) error {
+	source.lock.Lock()
+	if source.closed {
+		source.lock.Unlock()
+		return status.Error(codes.Unavailable, "The server is shutting down")
+	}
+	source.streams.Add(1)
+	source.lock.Unlock()
+	defer source.streams.Done()
+
+	var header *bitflow.Header
+	for {
+		var msg Message
+		if err := stream.RecvMsg(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Header != nil {
+			header = msg.Header
+			continue
+		}
+		if header == nil {
+			return status.Error(codes.InvalidArgument, "The stream must start with a header")
+		}
+		if len(msg.Sample.Values) != len(header.Fields) {
+			return status.Errorf(codes.InvalidArgument, "Sample has %v values, but header has %v fields", len(msg.Sample.Values), len(header.Fields))
+		}
+		if err := source.forward(msg.Sample, header); err != nil {
+			source.stopped.StopErr(err)
+			return status.Error(codes.Aborted, err.Error())
+		}
+	}
+}
+
+func (source *GrpcSource) forward(sample *bitflow.Sample, header *bitflow.Header) error {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	if source.closed {
+		return errors.New("The server is shutting down")
+	}
+	return source.GetSink().Sample(sample, header)
+}
+
+func (source *GrpcSource) Close() {
+	source.stopped.Stop()
+}
@@ -0,0 +1,91 @@
+package grpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+type testSampleCollector struct {
+	bitflow.DroppingSampleProcessor
+	lock    sync.Mutex
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+}
+
+func (c *testSampleCollector) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.samples = append(c.samples, sample)
+	c.headers = append(c.headers, header)
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	assert := testAssert.New(t)
+	var codec Codec
+	data, err := codec.Marshal(&Message{Header: &bitflow.Header{Fields: []string{"a", "b"}}})
+	assert.NoError(err)
+	var msg Message
+	assert.NoError(codec.Unmarshal(data, &msg))
+	assert.Nil(msg.Sample)
+	assert.Equal([]string{"a", "b"}, msg.Header.Fields)
+
+	sample := &bitflow.Sample{Time: time.Unix(1000, 123), Values: []bitflow.Value{1.5, -2}}
+	sample.SetTag("host", "x")
+	sample.SetTag("empty", "")
+	data, err = codec.Marshal(&Message{Sample: sample})
+	assert.NoError(err)
+	assert.NoError(codec.Unmarshal(data, &msg))
+	assert.Nil(msg.Header)
+	assert.True(sample.Time.Equal(msg.Sample.Time))
+	assert.Equal(sample.Values, msg.Sample.Values)
+	assert.Equal(sample.TagMap(), msg.Sample.TagMap())
+
+	_, err = codec.Marshal(&Message{})
+	assert.Error(err)
+	assert.Error(codec.Unmarshal(data[:len(data)-1], &msg))
+}
+
+func TestGrpcRoundTrip(t *testing.T) {
+	assert := testAssert.New(t)
+	source := &GrpcSource{Endpoint: "127.0.0.1:0"}
+	out := new(testSampleCollector)
+	source.SetSink(out)
+	var wg sync.WaitGroup
+	stopped := source.Start(&wg)
+
+	sink := &GrpcSink{Endpoint: source.listener.Addr().String()}
+	sink.SetSink(new(bitflow.DroppingSampleProcessor))
+	sink.Start(&wg)
+
+	header1 := &bitflow.Header{Fields: []string{"a", "b"}}
+	header2 := &bitflow.Header{Fields: []string{"c"}}
+	start := time.Unix(1000, 0)
+	for i := 0; i < 10; i++ {
+		sample := &bitflow.Sample{Time: start.Add(time.Duration(i) * time.Second), Values: []bitflow.Value{bitflow.Value(i), bitflow.Value(-i)}}
+		sample.SetTag("index", string(rune('a'+i)))
+		assert.NoError(sink.Sample(sample, header1))
+	}
+	assert.NoError(sink.Sample(&bitflow.Sample{Time: start, Values: []bitflow.Value{42}}, header2))
+	sink.Close() // Waits until the server has received all samples
+
+	out.lock.Lock()
+	assert.Len(out.samples, 11)
+	for i, sample := range out.samples[:10] {
+		assert.Equal([]string{"a", "b"}, out.headers[i].Fields)
+		assert.Equal([]bitflow.Value{bitflow.Value(i), bitflow.Value(-i)}, sample.Values)
+		assert.Equal(string(rune('a'+i)), sample.Tag("index"))
+		assert.True(start.Add(time.Duration(i) * time.Second).Equal(sample.Time))
+	}
+	assert.Equal([]string{"c"}, out.headers[10].Fields)
+	assert.Equal([]bitflow.Value{42}, out.samples[10].Values)
+	out.lock.Unlock()
+
+	source.Close()
+	wg.Wait()
+	assert.NoError(stopped.Err())
+}
@@ -0,0 +1,100 @@
+package steps
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultHeartbeatTag = "heartbeat"
+
+func RegisterHeartbeat(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("heartbeat",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			interval := reg.DurationParam(params, "interval", 0, false, &err)
+			tag := reg.StrParam(params, "tag", DefaultHeartbeatTag, true, &err)
+			if err == nil && interval <= 0 {
+				err = reg.ParameterError("interval", fmt.Errorf("Must be positive"))
+			}
+			if err == nil {
+				p.Add(&HeartbeatProcessor{Interval: interval, Tag: tag})
+			}
+			return
+		},
+		"Forward all samples and additionally send a heartbeat sample in the given interval, even if no samples are received. "+
+			"Heartbeat samples have the tag "+DefaultHeartbeatTag+"=true (the tag name can be changed) and can be filtered out by later steps. "+
+			"They use the header of the last received sample with all values set to NaN, or an empty header, if no sample was received yet.",
+		reg.RequiredParams("interval"), reg.OptionalParams("tag"))
+}
+
+// HeartbeatProcessor forwards all samples and additionally sends a heartbeat sample every Interval, so that
+// later steps can distinguish a running pipeline without data from a stopped pipeline. Heartbeat samples
+// are tagged with Tag=true. To avoid unnecessary header changes, they use the header of the last received
+// sample, with all values set to NaN. Before the first sample is received, heartbeat samples have no metrics.
+type HeartbeatProcessor struct {
+	bitflow.NoopProcessor
+	Interval time.Duration
+	Tag      string
+
+	lock    sync.Mutex
+	header  *bitflow.Header
+	stopper golib.StopChan
+}
+
+func (p *HeartbeatProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
+	p.stopper = golib.NewStopChan()
+	wg.Add(1)
+	go p.loop(wg)
+	return p.NoopProcessor.Start(wg)
+}
+
+func (p *HeartbeatProcessor) loop(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for p.stopper.WaitTimeout(p.Interval) {
+		p.stopper.IfNotStopped(func() {
+			if err := p.sendHeartbeat(); err != nil {
+				log.Errorf("%v: Error sending heartbeat sample: %v", p, err)
+			}
+		})
+	}
+}
+
+func (p *HeartbeatProcessor) sendHeartbeat() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	header := p.header
+	if header == nil {
+		header = new(bitflow.Header)
+	}
+	sample := &bitflow.Sample{
+		Time:   time.Now(),
+		Values: make([]bitflow.Value, len(header.Fields)),
+	}
+	for i := range sample.Values {
+		sample.Values[i] = bitflow.Value(math.NaN())
+	}
+	sample.SetTag(p.Tag, "true")
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *HeartbeatProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.header = header
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *HeartbeatProcessor) Close() {
+	p.stopper.Stop()
+	p.NoopProcessor.Close()
+}
+
+func (p *HeartbeatProcessor) String() string {
+	return fmt.Sprintf("Heartbeat every %v (tag: %v)", p.Interval, p.Tag)
+}
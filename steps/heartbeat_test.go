@@ -0,0 +1,58 @@
+package steps
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeatProcessor(t *testing.T) {
+	assert := testAssert.New(t)
+	const interval = 50 * time.Millisecond
+	p := &HeartbeatProcessor{Interval: interval, Tag: DefaultHeartbeatTag}
+	out := new(testSampleCollector)
+	p.SetSink(out)
+	var wg sync.WaitGroup
+	p.Start(&wg)
+
+	// No samples flow: heartbeats without metrics are sent in the configured interval
+	time.Sleep(4*interval + interval/2)
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	assert.NoError(p.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, header))
+	time.Sleep(2 * interval)
+	p.Close()
+	wg.Wait()
+
+	var heartbeats, withHeader int
+	var real []*bitflow.Sample
+	for i, sample := range out.samples {
+		if sample.Tag(DefaultHeartbeatTag) != "true" {
+			real = append(real, sample)
+			continue
+		}
+		heartbeats++
+		if len(out.headers[i].Fields) > 0 {
+			// Heartbeats after the real sample reuse its header
+			withHeader++
+			assert.Equal(header, out.headers[i])
+			assert.Len(sample.Values, 2)
+			assert.True(math.IsNaN(float64(sample.Values[0])))
+		} else {
+			assert.Empty(sample.Values)
+		}
+	}
+	assert.Len(real, 1)
+	assert.Equal([]bitflow.Value{1, 2}, real[0].Values)
+	assert.True(heartbeats >= 5 && heartbeats <= 7, "unexpected number of heartbeats: %v", heartbeats)
+	assert.True(withHeader >= 1 && withHeader <= 3, "unexpected number of heartbeats after the real sample: %v", withHeader)
+
+	// The heartbeats before the real sample are sent in the configured interval
+	for i := 2; i < 4; i++ {
+		diff := out.samples[i].Time.Sub(out.samples[i-1].Time)
+		assert.InDelta(float64(interval), float64(diff), float64(interval/2), "interval between heartbeats %v and %v", i-1, i)
+	}
+}
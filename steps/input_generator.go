@@ -0,0 +1,229 @@
+package steps
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	GeneratorEndpoint = bitflow.EndpointType("gen")
+
+	GeneratorRandomWalk = "random-walk"
+	GeneratorSine       = "sine"
+	GeneratorConstant   = "constant"
+	GeneratorGaussian   = "gaussian"
+
+	// GeneratorSinePeriod is the number of samples in one period of the sine generator
+	GeneratorSinePeriod = 100
+)
+
+// DefaultMetricSourceConfig contains the defaults for the -gen-* command line flags.
+var DefaultMetricSourceConfig = MetricSourceConfig{
+	Fields:    10,
+	Rate:      1000,
+	Generator: GeneratorRandomWalk,
+}
+
+// MetricSourceConfig configures the synthetic samples generated by a MetricSource.
+type MetricSourceConfig struct {
+	Fields    int
+	Rate      float64       // Samples per second. If <= 0, samples are generated as fast as possible.
+	Count     int           // Stop after generating the given number of samples, if > 0
+	Duration  time.Duration // Stop after the given duration, if > 0
+	Generator string
+	Seed      int64 // Seed for the random generators. If 0, the current time is used.
+}
+
+// RegisterMetricSource registers the 'gen' data source, which generates synthetic samples for benchmarking.
+// The endpoints have the form gen://fields?rate=1000&count=0&duration=0&generator=random-walk&seed=0.
+// The number of fields and all query parameters are optional and override the values set through the -gen-* command line flags.
+func RegisterMetricSource(b reg.ProcessorRegistry) {
+	config := DefaultMetricSourceConfig
+	b.Endpoints.CustomInputFlags = append(b.Endpoints.CustomInputFlags, func(f *flag.FlagSet) {
+		f.IntVar(&config.Fields, "gen-fields", config.Fields, "Number of metrics generated by gen:// inputs")
+		f.Float64Var(&config.Rate, "gen-rate", config.Rate, "Samples per second generated by gen:// inputs. Zero or negative means as fast as possible.")
+		f.IntVar(&config.Count, "gen-count", config.Count, "Number of samples generated by gen:// inputs. Zero means unlimited.")
+		f.DurationVar(&config.Duration, "gen-duration", config.Duration, "Duration of generating samples in gen:// inputs. Zero means unlimited.")
+		f.StringVar(&config.Generator, "gen-generator", config.Generator, fmt.Sprintf("Value generator for gen:// inputs (%v, %v, %v, %v)",
+			GeneratorRandomWalk, GeneratorSine, GeneratorConstant, GeneratorGaussian))
+	})
+	b.Endpoints.CustomDataSources[GeneratorEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		endpointConfig, err := ParseMetricSourceEndpoint(target, config)
+		if err != nil {
+			return nil, err
+		}
+		return &MetricSource{Config: endpointConfig}, nil
+	}
+}
+
+// ParseMetricSourceEndpoint parses an endpoint of the form fields?key=value&..., where all parts are optional,
+// and applies the contained values to the given config.
+func ParseMetricSourceEndpoint(target string, config MetricSourceConfig) (_ MetricSourceConfig, err error) {
+	fields := target
+	if index := strings.IndexByte(target, '?'); index >= 0 {
+		fields = target[:index]
+		var query url.Values
+		query, err = url.ParseQuery(target[index+1:])
+		if err != nil {
+			return
+		}
+		for key, values := range query {
+			value := values[len(values)-1]
+			switch key {
+			case "rate":
+				config.Rate, err = strconv.ParseFloat(value, 64)
+			case "count":
+				config.Count, err = strconv.Atoi(value)
+			case "duration":
+				config.Duration, err = time.ParseDuration(value)
+			case "generator":
+				config.Generator = value
+			case "seed":
+				config.Seed, err = strconv.ParseInt(value, 10, 64)
+			default:
+				err = fmt.Errorf("Unknown %v endpoint parameter: %v", GeneratorEndpoint, key)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	if fields != "" {
+		if config.Fields, err = strconv.Atoi(fields); err != nil {
+			return
+		}
+	}
+	switch {
+	case config.Fields < 1:
+		err = fmt.Errorf("The number of generated fields must be positive, received %v", config.Fields)
+	case config.Count < 0:
+		err = fmt.Errorf("The number of generated samples must not be negative, received %v", config.Count)
+	case config.Duration < 0:
+		err = fmt.Errorf("The duration of generating samples must not be negative, received %v", config.Duration)
+	}
+	switch config.Generator {
+	case GeneratorRandomWalk, GeneratorSine, GeneratorConstant, GeneratorGaussian:
+	default:
+		if err == nil {
+			err = fmt.Errorf("Unknown value generator '%v', must be one of %v, %v, %v, %v",
+				config.Generator, GeneratorRandomWalk, GeneratorSine, GeneratorConstant, GeneratorGaussian)
+		}
+	}
+	return config, err
+}
+
+// MetricSource generates synthetic samples without reading any input, which is useful for benchmarking
+// subsequent steps and data sinks. The samples contain Config.Fields metrics named metric0, metric1, ...,
+// and are generated at the rate Config.Rate, until Config.Count samples were generated or Config.Duration has passed.
+// The values depend on Config.Generator. With GeneratorRandomWalk, every metric starts at 0 and changes by a standard
+// normally distributed step in every sample. GeneratorSine produces sine waves with a period of GeneratorSinePeriod samples,
+// where every metric has a different phase. With GeneratorConstant, every metric has the value of its index, and
+// GeneratorGaussian produces independent standard normally distributed values.
+type MetricSource struct {
+	bitflow.AbstractSampleSource
+	Config MetricSourceConfig
+
+	task      *golib.LoopTask
+	rnd       *rand.Rand
+	header    *bitflow.Header
+	values    []float64
+	start     time.Time
+	generated int
+}
+
+func (source *MetricSource) String() string {
+	c := source.Config
+	res := fmt.Sprintf("Generate %v metrics (%v)", c.Fields, c.Generator)
+	if c.Rate > 0 {
+		res += fmt.Sprintf(", %v samples/s", c.Rate)
+	}
+	if c.Count > 0 {
+		res += fmt.Sprintf(", %v samples", c.Count)
+	}
+	if c.Duration > 0 {
+		res += fmt.Sprintf(", for %v", c.Duration)
+	}
+	return res
+}
+
+func (source *MetricSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	seed := source.Config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	source.rnd = rand.New(rand.NewSource(seed))
+	source.header = &bitflow.Header{Fields: make([]string, source.Config.Fields)}
+	for i := range source.header.Fields {
+		source.header.Fields[i] = "metric" + strconv.Itoa(i)
+	}
+	source.values = make([]float64, source.Config.Fields)
+	source.start = time.Now()
+	source.task = &golib.LoopTask{
+		Description: source.String(),
+		StopHook: func() {
+			log.Println(source, "generated", source.generated, "samples in", time.Since(source.start))
+			source.CloseSinkParallel(wg)
+		},
+		Loop: source.generate,
+	}
+	return source.task.Start(wg)
+}
+
+func (source *MetricSource) Close() {
+	source.task.Stop()
+}
+
+func (source *MetricSource) generate(stop golib.StopChan) error {
+	c := source.Config
+	if c.Count > 0 && source.generated >= c.Count {
+		return golib.StopLoopTask
+	}
+	if c.Rate > 0 {
+		offset := time.Duration(float64(source.generated) / c.Rate * float64(time.Second))
+		if c.Duration > 0 && offset >= c.Duration {
+			return golib.StopLoopTask
+		}
+		if wait := time.Until(source.start.Add(offset)); wait > 0 && !stop.WaitTimeout(wait) {
+			return golib.StopLoopTask
+		}
+	} else if c.Duration > 0 && time.Since(source.start) >= c.Duration {
+		return golib.StopLoopTask
+	}
+
+	sample := &bitflow.Sample{
+		Time:   time.Now(),
+		Values: make([]bitflow.Value, len(source.values)),
+	}
+	for i := range source.values {
+		sample.Values[i] = bitflow.Value(source.nextValue(i))
+	}
+	source.generated++
+	return source.GetSink().Sample(sample, source.header)
+}
+
+func (source *MetricSource) nextValue(i int) float64 {
+	switch source.Config.Generator {
+	case GeneratorRandomWalk:
+		source.values[i] += source.rnd.NormFloat64()
+		return source.values[i]
+	case GeneratorSine:
+		phase := float64(source.generated)/GeneratorSinePeriod + float64(i)/float64(len(source.values))
+		return math.Sin(2 * math.Pi * phase)
+	case GeneratorGaussian:
+		return source.rnd.NormFloat64()
+	default: // GeneratorConstant
+		return float64(i)
+	}
+}
@@ -0,0 +1,95 @@
+package steps
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _runMetricSource(t *testing.T, config MetricSourceConfig) (*testSampleCollector, time.Duration) {
+	out := new(testSampleCollector)
+	source := &MetricSource{Config: config}
+	source.SetSink(out)
+	var wg sync.WaitGroup
+	start := time.Now()
+	stopped := source.Start(&wg)
+	stopped.Wait()
+	elapsed := time.Since(start)
+	wg.Wait()
+	testAssert.NoError(t, stopped.Err())
+	return out, elapsed
+}
+
+func TestParseMetricSourceEndpoint(t *testing.T) {
+	assert := testAssert.New(t)
+	config, err := ParseMetricSourceEndpoint("", DefaultMetricSourceConfig)
+	assert.NoError(err)
+	assert.Equal(DefaultMetricSourceConfig, config)
+
+	config, err = ParseMetricSourceEndpoint("3?rate=50.5&count=10&duration=2s&generator=sine&seed=7", DefaultMetricSourceConfig)
+	assert.NoError(err)
+	assert.Equal(MetricSourceConfig{Fields: 3, Rate: 50.5, Count: 10, Duration: 2 * time.Second, Generator: GeneratorSine, Seed: 7}, config)
+
+	for _, invalid := range []string{"x", "0", "?rate=x", "?count=-1", "?duration=-1s", "?generator=square", "?unknown=1"} {
+		_, err = ParseMetricSourceEndpoint(invalid, DefaultMetricSourceConfig)
+		assert.Error(err, "endpoint %v", invalid)
+	}
+}
+
+func TestMetricSourceCount(t *testing.T) {
+	assert := testAssert.New(t)
+	out, elapsed := _runMetricSource(t, MetricSourceConfig{Fields: 3, Rate: 200, Count: 40, Generator: GeneratorConstant})
+	assert.Len(out.samples, 40)
+	// 40 samples at 200 samples/s take 195ms, since the first sample is generated immediately
+	assert.True(elapsed >= 190*time.Millisecond && elapsed < time.Second, "elapsed: %v", elapsed)
+	assert.Equal([]string{"metric0", "metric1", "metric2"}, out.headers[0].Fields)
+	for _, sample := range out.samples {
+		assert.Equal([]bitflow.Value{0, 1, 2}, sample.Values)
+	}
+}
+
+func TestMetricSourceDuration(t *testing.T) {
+	assert := testAssert.New(t)
+	out, elapsed := _runMetricSource(t, MetricSourceConfig{Fields: 2, Rate: 500, Duration: 200 * time.Millisecond, Generator: GeneratorGaussian, Seed: 1})
+	assert.Len(out.samples, 100)
+	assert.True(elapsed >= 190*time.Millisecond && elapsed < time.Second, "elapsed: %v", elapsed)
+	first, last := out.samples[0].Time, out.samples[len(out.samples)-1].Time
+	rate := float64(len(out.samples)-1) / last.Sub(first).Seconds()
+	assert.InDelta(500, rate, 50, "rate: %v", rate)
+}
+
+func TestMetricSourceGenerators(t *testing.T) {
+	assert := testAssert.New(t)
+	out, _ := _runMetricSource(t, MetricSourceConfig{Fields: 4, Count: GeneratorSinePeriod, Generator: GeneratorSine})
+	assert.Len(out.samples, GeneratorSinePeriod)
+	assert.InDelta(0, float64(out.samples[0].Values[0]), 1e-9)
+	assert.InDelta(1, float64(out.samples[0].Values[1]), 1e-9) // Shifted by a quarter period
+	assert.InDelta(1, float64(out.samples[GeneratorSinePeriod/4].Values[0]), 1e-9)
+
+	walk, _ := _runMetricSource(t, MetricSourceConfig{Fields: 1, Count: 100, Generator: GeneratorRandomWalk, Seed: 3})
+	walkAgain, _ := _runMetricSource(t, MetricSourceConfig{Fields: 1, Count: 100, Generator: GeneratorRandomWalk, Seed: 3})
+	assert.Len(walk.samples, 100)
+	for i := range walk.samples {
+		assert.Equal(walk.samples[i].Values, walkAgain.samples[i].Values, "the same seed should produce the same values")
+		assert.False(math.IsNaN(float64(walk.samples[i].Values[0])))
+	}
+	assert.NotEqual(walk.samples[0].Values, walk.samples[99].Values)
+}
+
+func TestMetricSourceClose(t *testing.T) {
+	assert := testAssert.New(t)
+	source := &MetricSource{Config: MetricSourceConfig{Fields: 1, Rate: 10, Generator: GeneratorConstant}}
+	out := new(testSampleCollector)
+	source.SetSink(out)
+	var wg sync.WaitGroup
+	stopped := source.Start(&wg)
+	time.Sleep(50 * time.Millisecond)
+	source.Close()
+	assert.False(stopped.WaitTimeout(time.Second), "the source should stop when closed")
+	wg.Wait()
+	assert.Len(out.samples, 1)
+}
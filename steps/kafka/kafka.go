@@ -0,0 +1,444 @@
+package kafka
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	KafkaEndpoint    = bitflow.EndpointType("kafka")
+	DefaultKafkaPort = "9092"
+
+	KafkaOffsetNewest = "newest"
+	KafkaOffsetOldest = "oldest"
+
+	// KafkaAllPartitions makes the KafkaSource consume all partitions of a topic, when no consumer group is used.
+	KafkaAllPartitions = -1
+)
+
+// DefaultKafkaConfig contains the defaults for the Kafka command line flags.
+var DefaultKafkaConfig = KafkaConfig{
+	Format:    bitflow.BinaryFormat,
+	Partition: KafkaAllPartitions,
+	Offset:    KafkaOffsetNewest,
+}
+
+// KafkaConfig configures the Kafka consumer and producer. Every message contains one marshalled header
+// and one sample, so that consumers can parse every message individually.
+type KafkaConfig struct {
+	Format bitflow.MarshallingFormat
+
+	// Consumer settings. If Group is set, the topic is consumed as a member of the consumer group and the
+	// partitions are assigned by the brokers. Otherwise, the given Partition is consumed, or all partitions
+	// of the topic, if Partition is KafkaAllPartitions.
+	Group     string
+	Partition int
+	Offset    string
+
+	// Producer settings. If KeyTag is set, messages are keyed by the value of the given tag.
+	// Samples without the tag are produced without a key.
+	KeyTag string
+}
+
+// RegisterKafkaEndpoints registers the 'kafka' data source and sink. The endpoints have the form
+// kafka://broker1:port,broker2:port/topic?group=g&partition=0&offset=oldest&key-tag=host&format=bin.
+// All query parameters are optional and override the values set through the -kafka-* command line flags.
+func RegisterKafkaEndpoints(b reg.ProcessorRegistry) {
+	config := DefaultKafkaConfig
+	b.Endpoints.CustomGeneralFlags = append(b.Endpoints.CustomGeneralFlags, func(f *flag.FlagSet) {
+		f.StringVar((*string)(&config.Format), "kafka-format", string(config.Format), "Data format for marshalling samples in Kafka messages")
+	})
+	b.Endpoints.CustomInputFlags = append(b.Endpoints.CustomInputFlags, func(f *flag.FlagSet) {
+		f.StringVar(&config.Group, "kafka-group", config.Group, "Consume Kafka topics as a member of the given consumer group")
+		f.IntVar(&config.Partition, "kafka-partition", config.Partition, "Without -kafka-group, consume only the given partition of Kafka topics. -1 means all partitions.")
+		f.StringVar(&config.Offset, "kafka-offset", config.Offset, "Initial offset for consuming Kafka topics ('"+KafkaOffsetNewest+"' or '"+KafkaOffsetOldest+"')")
+	})
+	b.Endpoints.CustomOutputFlags = append(b.Endpoints.CustomOutputFlags, func(f *flag.FlagSet) {
+		f.StringVar(&config.KeyTag, "kafka-key-tag", config.KeyTag, "Use the value of the given tag as key of produced Kafka messages")
+	})
+
+	b.Endpoints.CustomDataSources[KafkaEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		brokers, topic, endpointConfig, err := ParseKafkaEndpoint(target, config)
+		if err != nil {
+			return nil, err
+		}
+		marshaller, err := b.Endpoints.CreateMarshaller(endpointConfig.Format)
+		if err != nil {
+			return nil, err
+		}
+		unmarshaller, ok := marshaller.(bitflow.Unmarshaller)
+		if !ok {
+			return nil, fmt.Errorf("Format '%v' cannot be used for reading Kafka messages", endpointConfig.Format)
+		}
+		return &KafkaSource{
+			Brokers:      brokers,
+			Topic:        topic,
+			Config:       endpointConfig,
+			Unmarshaller: unmarshaller,
+		}, nil
+	}
+	b.Endpoints.CustomDataSinks[KafkaEndpoint] = func(target string) (bitflow.SampleProcessor, error) {
+		brokers, topic, endpointConfig, err := ParseKafkaEndpoint(target, config)
+		if err != nil {
+			return nil, err
+		}
+		marshaller, err := b.Endpoints.CreateMarshaller(endpointConfig.Format)
+		if err != nil {
+			return nil, err
+		}
+		return &KafkaSink{
+			Brokers:    brokers,
+			Topic:      topic,
+			Config:     endpointConfig,
+			Marshaller: marshaller,
+		}, nil
+	}
+}
+
+// ParseKafkaEndpoint parses an endpoint target in the form broker1:port,broker2:port/topic?param=value. The ports are optional.
+// The query parameters group, partition, offset, key-tag and format override the values in the given config.
+func ParseKafkaEndpoint(target string, config KafkaConfig) (brokers []string, topic string, _ KafkaConfig, err error) {
+	index := strings.IndexByte(target, '/')
+	if index <= 0 || index == len(target)-1 {
+		return nil, "", config, fmt.Errorf("Kafka endpoint must have the form broker:port/topic, received: %v", target)
+	}
+	for _, broker := range strings.Split(target[:index], ",") {
+		if broker == "" {
+			return nil, "", config, fmt.Errorf("Empty broker in Kafka endpoint: %v", target)
+		}
+		if !strings.Contains(broker, ":") {
+			broker += ":" + DefaultKafkaPort
+		}
+		brokers = append(brokers, broker)
+	}
+	topic = target[index+1:]
+	if index = strings.LastIndexByte(topic, '?'); index >= 0 {
+		var query url.Values
+		query, err = url.ParseQuery(topic[index+1:])
+		if err != nil {
+			return
+		}
+		topic = topic[:index]
+		for key, values := range query {
+			value := values[len(values)-1]
+			switch key {
+			case "group":
+				config.Group = value
+			case "partition":
+				config.Partition, err = strconv.Atoi(value)
+			case "offset":
+				config.Offset = value
+			case "key-tag":
+				config.KeyTag = value
+			case "format":
+				config.Format = bitflow.MarshallingFormat(value)
+			default:
+				err = fmt.Errorf("Unknown Kafka endpoint parameter: %v", key)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	if config.Partition < KafkaAllPartitions {
+		err = fmt.Errorf("Kafka partition must be >= 0, or %v for all partitions, received %v", KafkaAllPartitions, config.Partition)
+	} else if config.Offset != KafkaOffsetNewest && config.Offset != KafkaOffsetOldest {
+		err = fmt.Errorf("Kafka offset must be '%v' or '%v', received '%v'", KafkaOffsetNewest, KafkaOffsetOldest, config.Offset)
+	}
+	return brokers, topic, config, err
+}
+
+func (config *KafkaConfig) saramaConfig() *sarama.Config {
+	cfg := sarama.NewConfig()
+	cfg.ClientID = "bitflow"
+	cfg.Version = sarama.V1_0_0_0 // Required for consumer groups
+	cfg.Producer.Return.Successes = true
+	cfg.Consumer.Return.Errors = true
+	if config.Offset == KafkaOffsetOldest {
+		cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	} else {
+		cfg.Consumer.Offsets.Initial = sarama.OffsetNewest
+	}
+	return cfg
+}
+
+// KafkaSink produces one message for every sample, containing the marshalled header and sample.
+type KafkaSink struct {
+	bitflow.AbstractSampleOutput
+	Brokers    []string
+	Topic      string
+	Config     KafkaConfig
+	Marshaller bitflow.Marshaller
+
+	// Producer is created in Start(), if it is not set.
+	Producer sarama.SyncProducer
+}
+
+func (sink *KafkaSink) String() string {
+	return fmt.Sprintf("Kafka producer (%v, topic %v, format %v)", strings.Join(sink.Brokers, ","), sink.Topic, sink.Config.Format)
+}
+
+func (sink *KafkaSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
+	if sink.Producer == nil {
+		producer, err := sarama.NewSyncProducer(sink.Brokers, sink.Config.saramaConfig())
+		if err != nil {
+			return golib.NewStoppedChan(fmt.Errorf("Failed to connect to Kafka brokers %v: %v", sink.Brokers, err))
+		}
+		sink.Producer = producer
+	}
+	log.WithField("format", sink.Config.Format).Println("Producing to Kafka topic", sink.Topic, "on", strings.Join(sink.Brokers, ","))
+	return
+}
+
+func (sink *KafkaSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	var buf bytes.Buffer
+	err := sink.Marshaller.WriteHeader(header, true, &buf)
+	if err == nil {
+		err = sink.Marshaller.WriteSample(sample, header, true, &buf)
+	}
+	if err == nil {
+		msg := &sarama.ProducerMessage{
+			Topic: sink.Topic,
+			Value: sarama.ByteEncoder(buf.Bytes()),
+		}
+		if sink.Config.KeyTag != "" && sample.HasTag(sink.Config.KeyTag) {
+			msg.Key = sarama.StringEncoder(sample.Tag(sink.Config.KeyTag))
+		}
+		if _, _, err = sink.Producer.SendMessage(msg); err != nil {
+			err = fmt.Errorf("%v: Failed to produce message: %v", sink, err)
+		}
+	}
+	return sink.AbstractSampleOutput.Sample(err, sample, header)
+}
+
+func (sink *KafkaSink) Close() {
+	if sink.Producer != nil {
+		if err := sink.Producer.Close(); err != nil {
+			log.Errorf("%v: Error closing producer: %v", sink, err)
+		}
+	}
+	sink.CloseSink()
+}
+
+// KafkaSource consumes a Kafka topic and parses every message into a sample. Every message
+// must contain a marshalled header followed by one marshalled sample, as produced by KafkaSink.
+// Messages of different partitions are forwarded sequentially, but their relative order is not defined.
+type KafkaSource struct {
+	bitflow.AbstractSampleSource
+	Brokers      []string
+	Topic        string
+	Config       KafkaConfig
+	Unmarshaller bitflow.Unmarshaller
+
+	stopped    golib.StopChan
+	lock       sync.Mutex
+	lastHeader *bitflow.Header
+}
+
+func (source *KafkaSource) String() string {
+	return fmt.Sprintf("Kafka consumer (%v, topic %v, format %v)", strings.Join(source.Brokers, ","), source.Topic, source.Config.Format)
+}
+
+func (source *KafkaSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	source.stopped = golib.NewStopChan()
+	var consume func(*sync.WaitGroup)
+	var err error
+	if source.Config.Group != "" {
+		consume, err = source.startGroupConsumer()
+	} else {
+		consume, err = source.startPartitionConsumer()
+	}
+	if err != nil {
+		source.CloseSinkParallel(wg)
+		return golib.NewStoppedChan(err)
+	}
+	log.WithField("format", source.Config.Format).Println("Consuming Kafka topic", source.Topic, "on", strings.Join(source.Brokers, ","))
+
+	return golib.WaitErrFunc(wg, func() error {
+		defer source.CloseSinkParallel(wg)
+		var consumerWg sync.WaitGroup
+		consume(&consumerWg)
+		source.stopped.Wait()
+		consumerWg.Wait()
+		return source.stopped.Err()
+	})
+}
+
+// startPartitionConsumer consumes the configured partition, or all partitions, without committing offsets.
+func (source *KafkaSource) startPartitionConsumer() (func(*sync.WaitGroup), error) {
+	cfg := source.Config.saramaConfig()
+	consumer, err := sarama.NewConsumer(source.Brokers, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to Kafka brokers %v: %v", source.Brokers, err)
+	}
+	partitions := []int32{int32(source.Config.Partition)}
+	if source.Config.Partition == KafkaAllPartitions {
+		if partitions, err = consumer.Partitions(source.Topic); err != nil {
+			_ = consumer.Close() // Drop error
+			return nil, fmt.Errorf("Failed to query partitions of Kafka topic %v: %v", source.Topic, err)
+		}
+	}
+	var partitionConsumers []sarama.PartitionConsumer
+	for _, partition := range partitions {
+		pc, err := consumer.ConsumePartition(source.Topic, partition, cfg.Consumer.Offsets.Initial)
+		if err != nil {
+			for _, pc := range partitionConsumers {
+				pc.AsyncClose()
+			}
+			_ = consumer.Close() // Drop error
+			return nil, fmt.Errorf("Failed to consume partition %v of Kafka topic %v: %v", partition, source.Topic, err)
+		}
+		partitionConsumers = append(partitionConsumers, pc)
+	}
+
+	return func(wg *sync.WaitGroup) {
+		for _, pc := range partitionConsumers {
+			wg.Add(2)
+			go source.forwardMessages(wg, pc.Messages(), nil)
+			go func(errs <-chan *sarama.ConsumerError) {
+				defer wg.Done()
+				for err := range errs {
+					log.Errorf("%v: %v", source, err)
+				}
+			}(pc.Errors())
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			source.stopped.Wait()
+			for _, pc := range partitionConsumers {
+				pc.AsyncClose()
+			}
+			if err := consumer.Close(); err != nil {
+				log.Errorf("%v: Error closing consumer: %v", source, err)
+			}
+		}()
+	}, nil
+}
+
+// startGroupConsumer joins the configured consumer group and commits the offsets of all forwarded messages.
+func (source *KafkaSource) startGroupConsumer() (func(*sync.WaitGroup), error) {
+	group, err := sarama.NewConsumerGroup(source.Brokers, source.Config.Group, source.Config.saramaConfig())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to join Kafka consumer group %v: %v", source.Config.Group, err)
+	}
+	return func(wg *sync.WaitGroup) {
+		ctx, cancel := context.WithCancel(context.Background())
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for err := range group.Errors() {
+				log.Errorf("%v: %v", source, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// Consume() returns when the group is rebalanced, so it is called in a loop
+			for !source.stopped.Stopped() {
+				if err := group.Consume(ctx, []string{source.Topic}, &kafkaGroupHandler{source}); err != nil && !source.stopped.Stopped() {
+					source.stopped.StopErr(fmt.Errorf("%v: %v", source, err))
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			source.stopped.Wait()
+			cancel()
+			if err := group.Close(); err != nil {
+				log.Errorf("%v: Error closing consumer group: %v", source, err)
+			}
+		}()
+	}, nil
+}
+
+type kafkaGroupHandler struct {
+	source *KafkaSource
+}
+
+func (h *kafkaGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *kafkaGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (h *kafkaGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	h.source.forwardMessages(&wg, claim.Messages(), session)
+	return nil
+}
+
+func (source *KafkaSource) forwardMessages(wg *sync.WaitGroup, messages <-chan *sarama.ConsumerMessage, session sarama.ConsumerGroupSession) {
+	defer wg.Done()
+	for msg := range messages {
+		if source.stopped.Stopped() {
+			return
+		}
+		source.handleMessage(msg)
+		if session != nil {
+			session.MarkMessage(msg, "")
+		}
+	}
+}
+
+// handleMessage can be called from multiple goroutines, the samples are forwarded sequentially.
+func (source *KafkaSource) handleMessage(msg *sarama.ConsumerMessage) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	if source.stopped.Stopped() {
+		return
+	}
+	sample, header, err := source.parseMessage(msg.Value)
+	if err != nil {
+		log.WithField("topic", msg.Topic).WithField("partition", msg.Partition).WithField("offset", msg.Offset).Warnln("Dropping invalid Kafka message:", err)
+		return
+	}
+	if err := source.GetSink().Sample(sample, header); err != nil {
+		source.stopped.StopErr(err)
+	}
+}
+
+func (source *KafkaSource) parseMessage(payload []byte) (*bitflow.Sample, *bitflow.Header, error) {
+	reader := bufio.NewReader(bytes.NewReader(payload))
+	header, _, err := source.Unmarshaller.Read(reader, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header == nil {
+		return nil, nil, errors.New("Message does not start with a header")
+	}
+	_, data, err := source.Unmarshaller.Read(reader, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	sample, err := source.Unmarshaller.ParseSample(header, bitflow.RequiredValues(len(header.Fields), source.GetSink()), data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Reuse the previous header object, so that subsequent processing steps do not have to handle a changed header for every sample
+	if source.lastHeader == nil || !source.lastHeader.Equals(&header.Header) {
+		source.lastHeader = &bitflow.Header{Fields: header.Fields}
+	}
+	return sample, source.lastHeader, nil
+}
+
+func (source *KafkaSource) Close() {
+	source.stopped.Stop()
+}
@@ -0,0 +1,122 @@
+package kafka
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.samples = append(s.samples, sample)
+	s.headers = append(s.headers, header)
+	return nil
+}
+
+// testProducer stores all produced messages instead of sending them to a broker
+type testProducer struct {
+	messages []*sarama.ProducerMessage
+	closed   bool
+}
+
+func (p *testProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.messages = append(p.messages, msg)
+	return 0, int64(len(p.messages) - 1), nil
+}
+
+func (p *testProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	p.messages = append(p.messages, msgs...)
+	return nil
+}
+
+func (p *testProducer) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestParseKafkaEndpoint(t *testing.T) {
+	assert := testAssert.New(t)
+	brokers, topic, config, err := ParseKafkaEndpoint("broker1,broker2:1234/samples", DefaultKafkaConfig)
+	assert.NoError(err)
+	assert.Equal([]string{"broker1:" + DefaultKafkaPort, "broker2:1234"}, brokers)
+	assert.Equal("samples", topic)
+	assert.Equal(DefaultKafkaConfig, config)
+
+	_, topic, config, err = ParseKafkaEndpoint("broker/samples?group=g&partition=2&offset=oldest&key-tag=host&format=csv", DefaultKafkaConfig)
+	assert.NoError(err)
+	assert.Equal("samples", topic)
+	assert.Equal(KafkaConfig{Format: bitflow.CsvFormat, Group: "g", Partition: 2, Offset: KafkaOffsetOldest, KeyTag: "host"}, config)
+
+	for _, invalid := range []string{"broker", "/topic", "broker/", ",broker/topic", "broker/topic?partition=-2",
+		"broker/topic?partition=x", "broker/topic?offset=middle", "broker/topic?unknown=1"} {
+		_, _, _, err = ParseKafkaEndpoint(invalid, DefaultKafkaConfig)
+		assert.Error(err, "endpoint %v", invalid)
+	}
+}
+
+func TestKafkaRoundTrip(t *testing.T) {
+	for _, format := range []bitflow.MarshallingFormat{bitflow.CsvFormat, bitflow.BinaryFormat} {
+		testKafkaRoundTrip(t, format)
+	}
+}
+
+func testKafkaRoundTrip(t *testing.T, format bitflow.MarshallingFormat) {
+	assert := testAssert.New(t)
+	endpoints := bitflow.NewEndpointFactory()
+	marshaller, err := endpoints.CreateMarshaller(format)
+	assert.NoError(err)
+	config := DefaultKafkaConfig
+	config.Format = format
+	config.KeyTag = "host"
+
+	producer := new(testProducer)
+	sink := &KafkaSink{Topic: "samples", Config: config, Marshaller: marshaller, Producer: producer}
+	sink.SetSink(new(bitflow.DroppingSampleProcessor))
+	sink.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	timestamp := time.Date(2019, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		sample := &bitflow.Sample{Time: timestamp.Add(time.Duration(i) * time.Second), Values: []bitflow.Value{bitflow.Value(i), 10}}
+		if i > 0 {
+			sample.SetTag("host", "h"+strconv.Itoa(i))
+		}
+		assert.NoError(sink.Sample(sample, header))
+	}
+	sink.Close()
+	assert.True(producer.closed)
+	assert.Len(producer.messages, 3)
+	assert.Nil(producer.messages[0].Key)
+	assert.Equal(sarama.StringEncoder("h2"), producer.messages[2].Key)
+
+	unmarshaller := marshaller.(bitflow.Unmarshaller)
+	received := new(collectingSink)
+	source := &KafkaSource{Topic: "samples", Config: config, Unmarshaller: unmarshaller, stopped: golib.NewStopChan()}
+	source.SetSink(received)
+	for _, msg := range producer.messages {
+		value, err := msg.Value.Encode()
+		assert.NoError(err)
+		source.handleMessage(&sarama.ConsumerMessage{Topic: msg.Topic, Value: value})
+	}
+	source.handleMessage(&sarama.ConsumerMessage{Topic: "samples", Value: []byte("invalid")}) // Dropped with a warning
+
+	assert.Len(received.samples, 3, "format %v", format)
+	for i, sample := range received.samples {
+		assert.Equal([]bitflow.Value{bitflow.Value(i), 10}, sample.Values)
+		assert.True(timestamp.Add(time.Duration(i) * time.Second).Equal(sample.Time))
+		assert.Equal([]string{"a", "b"}, received.headers[i].Fields)
+	}
+	assert.Equal("h1", received.samples[1].Tag("host"))
+	assert.True(received.headers[0] == received.headers[2], "the header object should be reused")
+}
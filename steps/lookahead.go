@@ -0,0 +1,123 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+func RegisterLookahead(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("lookahead",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			n := reg.IntParam(params, "n", 1, true, &err)
+			if err != nil {
+				return
+			}
+			if n < 1 {
+				return reg.ParameterError("n", errors.New("Must be > 0"))
+			}
+			p.Add(&Lookahead{N: n})
+			return
+		},
+		"Delay every sample by n samples (default 1) and append the values of the sample n positions ahead as additional fields, named <metric>_next "+
+			"(or <metric>_next<n> for n > 1). Samples without a following sample, e.g. at the end of the stream or before a header change, receive NaN values.",
+		reg.OptionalParams("n"))
+}
+
+// Lookahead holds back the last N samples and appends the values of the sample N positions ahead to every emitted sample.
+// This gives subsequent steps access to the near future of the stream, at the cost of delaying every sample by N samples.
+// The additional fields are named <metric>_next, or <metric>_next<N> if N > 1. When the header changes, and when the step
+// is closed, the buffered samples are flushed and the missing lookahead values are filled with NaN.
+type Lookahead struct {
+	bitflow.NoopProcessor
+	N int
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	buffer    []*bitflow.Sample
+}
+
+func (l *Lookahead) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", l, len(sample.Values), len(header.Fields))
+	}
+	if l.checker.HeaderChanged(header) {
+		// The buffered samples cannot be combined with samples of the new header
+		if err := l.flush(); err != nil {
+			return err
+		}
+		l.outHeader = l.buildHeader(header)
+	}
+	l.buffer = append(l.buffer, sample)
+	if len(l.buffer) <= l.N {
+		return nil
+	}
+	first := l.buffer[0]
+	copy(l.buffer, l.buffer[1:])
+	l.buffer[len(l.buffer)-1] = nil
+	l.buffer = l.buffer[:len(l.buffer)-1]
+	return l.emit(first, sample.Values)
+}
+
+func (l *Lookahead) buildHeader(header *bitflow.Header) *bitflow.Header {
+	suffix := "_next"
+	if l.N > 1 {
+		suffix += strconv.Itoa(l.N)
+	}
+	fields := make([]string, len(header.Fields), len(header.Fields)*2)
+	copy(fields, header.Fields)
+	for _, field := range header.Fields {
+		fields = append(fields, field+suffix)
+	}
+	return header.Clone(fields)
+}
+
+// emit appends the given future values to the sample and forwards it. A nil slice results in NaN values.
+func (l *Lookahead) emit(sample *bitflow.Sample, next []bitflow.Value) error {
+	size := len(sample.Values)
+	values := make([]bitflow.Value, size, size*2)
+	copy(values, sample.Values)
+	if next == nil {
+		for i := 0; i < size; i++ {
+			values = append(values, bitflow.Value(math.NaN()))
+		}
+	} else {
+		values = append(values, next[:size]...)
+	}
+	sample.Values = values
+	return l.NoopProcessor.Sample(sample, l.outHeader)
+}
+
+func (l *Lookahead) flush() error {
+	for i, sample := range l.buffer {
+		l.buffer[i] = nil
+		if err := l.emit(sample, nil); err != nil {
+			l.buffer = l.buffer[i+1:]
+			return err
+		}
+	}
+	l.buffer = l.buffer[:0]
+	return nil
+}
+
+func (l *Lookahead) Close() {
+	defer l.CloseSink()
+	if err := l.flush(); err != nil {
+		err = fmt.Errorf("Error flushing lookahead samples: %v", err)
+		log.Errorln(err)
+		l.Error(err)
+	}
+}
+
+func (l *Lookahead) OutputSampleSize(sampleSize int) int {
+	return sampleSize * 2
+}
+
+func (l *Lookahead) String() string {
+	return fmt.Sprintf("Lookahead %v sample(s)", l.N)
+}
@@ -0,0 +1,43 @@
+package steps
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestLookahead(t *testing.T) {
+	assert := testAssert.New(t)
+	l := &Lookahead{N: 2}
+	out := new(testSampleCollector)
+	l.SetSink(out)
+	l.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	for i := 0; i < 5; i++ {
+		assert.NoError(l.Sample(&bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i), bitflow.Value(10 * i)}}, header))
+	}
+	// Nothing is emitted until the sample n positions ahead has arrived
+	assert.Len(out.samples, 3)
+	// A header change flushes the buffered samples
+	assert.NoError(l.Sample(&bitflow.Sample{Values: []bitflow.Value{7}}, &bitflow.Header{Fields: []string{"c"}}))
+	assert.Len(out.samples, 5)
+	l.Close()
+	assert.Len(out.samples, 6)
+
+	assert.Equal([]string{"a", "b", "a_next2", "b_next2"}, out.headers[0].Fields)
+	for i := 0; i < 3; i++ {
+		assert.Equal([]bitflow.Value{bitflow.Value(i), bitflow.Value(10 * i), bitflow.Value(i + 2), bitflow.Value(10 * (i + 2))}, out.samples[i].Values)
+	}
+	for i := 3; i < 5; i++ {
+		values := out.samples[i].Values
+		assert.Equal([]bitflow.Value{bitflow.Value(i), bitflow.Value(10 * i)}, values[:2])
+		assert.True(math.IsNaN(float64(values[2])) && math.IsNaN(float64(values[3])))
+	}
+	assert.Equal([]string{"c", "c_next2"}, out.headers[5].Fields)
+	assert.Equal(bitflow.Value(7), out.samples[5].Values[0])
+	assert.True(math.IsNaN(float64(out.samples[5].Values[1])))
+}
@@ -2,7 +2,9 @@ package math
 
 import (
 	"container/list"
+	"encoding/gob"
 	"fmt"
+	"io"
 	"strconv"
 	"time"
 
@@ -136,6 +138,51 @@ func (agg *FeatureAggregator) flushWindow(stats *FeatureWindowStats) {
 	}
 }
 
+type featureWindowState struct {
+	Values     []bitflow.Value
+	Timestamps []time.Time
+}
+
+// SaveState implements the bitflow.Checkpointable interface by storing the contents of all windows.
+func (agg *FeatureAggregator) SaveState(w io.Writer) error {
+	state := make(map[string]featureWindowState, len(agg.allStats))
+	for field, stats := range agg.allStats {
+		window := featureWindowState{
+			Values:     make([]bitflow.Value, 0, stats.num),
+			Timestamps: make([]time.Time, 0, stats.num),
+		}
+		for link := stats.values.Front(); link != nil; link = link.Next() {
+			window.Values = append(window.Values, link.Value.(bitflow.Value))
+		}
+		for link := stats.timestamps.Front(); link != nil; link = link.Next() {
+			window.Timestamps = append(window.Timestamps, link.Value.(time.Time))
+		}
+		state[field] = window
+	}
+	return gob.NewEncoder(w).Encode(state)
+}
+
+// LoadState implements the bitflow.Checkpointable interface by restoring the contents of all windows.
+func (agg *FeatureAggregator) LoadState(r io.Reader) error {
+	var state map[string]featureWindowState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	agg.allStats = make(map[string]*FeatureWindowStats, len(state))
+	for field, window := range state {
+		if len(window.Values) != len(window.Timestamps) {
+			return fmt.Errorf("Inconsistent state for metric %v: %v values, but %v timestamps", field, len(window.Values), len(window.Timestamps))
+		}
+		stats := new(FeatureWindowStats)
+		for i, value := range window.Values {
+			stats.Push(value, window.Timestamps[i])
+		}
+		agg.allStats[field] = stats
+	}
+	agg.checker = bitflow.HeaderChecker{} // Make sure the windows are assigned to the header fields again
+	return nil
+}
+
 func (agg *FeatureAggregator) String() string {
 	desc := "Feature Aggregator"
 	if agg.WindowSize > 0 {
@@ -0,0 +1,79 @@
+package math
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.samples = append(s.samples, sample)
+	s.headers = append(s.headers, header)
+	return nil
+}
+
+func _runCheckpointedAggregator(t *testing.T, file string, start time.Time, values ...bitflow.Value) *collectingSink {
+	assert := testAssert.New(t)
+	agg := (&FeatureAggregator{WindowSize: 3}).AddAvg("_avg")
+	coordinator := bitflow.NewCheckpointCoordinator(file, time.Hour, []bitflow.SampleProcessor{agg})
+	assert.Len(coordinator.Steps, 1)
+	out := new(collectingSink)
+	coordinator.SetSink(agg)
+	agg.SetSink(out)
+
+	var wg sync.WaitGroup
+	agg.Start(&wg)
+	stopped := coordinator.Start(&wg)
+	assert.False(stopped.Stopped(), "coordinator stopped: %v", stopped.Err())
+	header := &bitflow.Header{Fields: []string{"x"}}
+	for i, value := range values {
+		sample := &bitflow.Sample{
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Values: []bitflow.Value{value},
+		}
+		assert.NoError(coordinator.Sample(sample, header))
+	}
+	coordinator.Close()
+	wg.Wait()
+	return out
+}
+
+func TestFeatureAggregatorCheckpoint(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-checkpoint-")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+	file := filepath.Join(dir, "state")
+	start := time.Unix(1000, 0)
+
+	out := _runCheckpointedAggregator(t, file, start, 1, 2)
+	assert.Len(out.samples, 2)
+	assert.Equal(bitflow.Value(1.5), out.samples[1].Values[1])
+	_, err = os.Stat(file)
+	assert.NoError(err, "checkpoint file should be written when closing")
+
+	// The restored window continues with the previous values
+	out = _runCheckpointedAggregator(t, file, start.Add(2*time.Second), 3, 4)
+	assert.Len(out.samples, 2)
+	assert.Equal([]string{"x", "x_avg"}, out.headers[0].Fields)
+	assert.Equal(bitflow.Value(2), out.samples[0].Values[1])
+	assert.Equal(bitflow.Value(3), out.samples[1].Values[1])
+
+	// Without the checkpoint, the aggregator starts with empty windows
+	assert.NoError(os.Remove(file))
+	out = _runCheckpointedAggregator(t, file, start, 3, 4)
+	assert.Equal(bitflow.Value(3), out.samples[0].Values[1])
+	assert.Equal(bitflow.Value(3.5), out.samples[1].Values[1])
+}
@@ -0,0 +1,79 @@
+package math
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterCumulativeSum(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("cumsum",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			metrics := reg.StrParam(params, "metrics", ".*", true, &err)
+			resetTag := reg.StrParam(params, "reset-tag", "", true, &err)
+			if err != nil {
+				return
+			}
+			regex, err := regexp.Compile(metrics)
+			if err != nil {
+				return reg.ParameterError("metrics", err)
+			}
+			p.Add(&CumulativeSum{Metrics: regex, ResetTag: resetTag})
+			return
+		},
+		"Replace the values of all metrics matching the given regex (all metrics by default) with their running total. "+
+			"NaN values are skipped. The totals are reset when the header changes, or when the value of the given reset-tag changes.",
+		reg.OptionalParams("metrics", "reset-tag"))
+}
+
+// CumulativeSum replaces the values of all metrics matching the Metrics regex with the sum of all values received so far.
+// NaN values do not change the sum. The sums are reset when the header changes, or when the value of ResetTag
+// (if not empty) changes.
+type CumulativeSum struct {
+	bitflow.NoopProcessor
+	Metrics  *regexp.Regexp
+	ResetTag string
+
+	checker   bitflow.HeaderChecker
+	indices   []int
+	totals    []float64
+	lastReset string
+}
+
+func (c *CumulativeSum) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if c.checker.HeaderChanged(header) {
+		c.indices = c.indices[:0]
+		for i, field := range header.Fields {
+			if c.Metrics == nil || c.Metrics.MatchString(field) {
+				c.indices = append(c.indices, i)
+			}
+		}
+		c.totals = make([]float64, len(c.indices))
+		c.lastReset = sample.Tag(c.ResetTag)
+	} else if c.ResetTag != "" {
+		if value := sample.Tag(c.ResetTag); value != c.lastReset {
+			c.lastReset = value
+			for i := range c.totals {
+				c.totals[i] = 0
+			}
+		}
+	}
+	for i, index := range c.indices {
+		if val := float64(sample.Values[index]); !math.IsNaN(val) {
+			c.totals[i] += val
+		}
+		sample.Values[index] = bitflow.Value(c.totals[i])
+	}
+	return c.NoopProcessor.Sample(sample, header)
+}
+
+func (c *CumulativeSum) String() string {
+	res := fmt.Sprintf("Cumulative sum of metrics matching %v", c.Metrics)
+	if c.ResetTag != "" {
+		res += fmt.Sprintf(" (reset when tag '%v' changes)", c.ResetTag)
+	}
+	return res
+}
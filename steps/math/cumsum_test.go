@@ -0,0 +1,53 @@
+package math
+
+import (
+	"math"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestCumulativeSum(t *testing.T) {
+	assert := testAssert.New(t)
+	c := &CumulativeSum{Metrics: regexp.MustCompile("^rate"), ResetTag: "run"}
+	out := new(collectingSink)
+	c.SetSink(out)
+	c.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"rate_a", "other", "rate_b"}}
+	nan := bitflow.Value(math.NaN())
+	inputs := []struct {
+		run    string
+		values []bitflow.Value
+	}{
+		{"1", []bitflow.Value{1, 10, 2}},
+		{"1", []bitflow.Value{2, 20, nan}},
+		{"1", []bitflow.Value{3, 30, 4}},
+		{"2", []bitflow.Value{5, 40, 1}}, // Reset
+		{"2", []bitflow.Value{nan, 50, 1}},
+	}
+	for _, input := range inputs {
+		sample := &bitflow.Sample{Values: input.values}
+		sample.SetTag("run", input.run)
+		assert.NoError(c.Sample(sample, header))
+	}
+	// Header change also resets the totals
+	assert.NoError(c.Sample(&bitflow.Sample{Values: []bitflow.Value{7}}, &bitflow.Header{Fields: []string{"rate_a"}}))
+	c.Close()
+
+	expected := [][]bitflow.Value{
+		{1, 10, 2},
+		{3, 20, 2},
+		{6, 30, 6},
+		{5, 40, 1},
+		{5, 50, 2},
+		{7},
+	}
+	assert.Len(out.samples, len(expected))
+	for i, sample := range out.samples {
+		assert.Equal(expected[i], sample.Values, "sample %v", i)
+	}
+}
@@ -0,0 +1,159 @@
+package math
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	DecompositionTrendSuffix    = "_trend"
+	DecompositionSeasonalSuffix = "_seasonal"
+	DecompositionResidualSuffix = "_residual"
+)
+
+func RegisterSeasonalDecomposition(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("decompose",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			period := reg.IntParam(params, "period", 0, false, &err)
+			metrics := reg.StrParam(params, "metrics", ".*", true, &err)
+			if err != nil {
+				return
+			}
+			if period < 2 {
+				return reg.ParameterError("period", errors.New("Must be >= 2"))
+			}
+			regex, err := regexp.Compile(metrics)
+			if err != nil {
+				return reg.ParameterError("metrics", err)
+			}
+			p.Batch(&SeasonalDecomposition{Period: period, Metrics: regex})
+			return
+		},
+		"Perform a classic additive decomposition of every metric matching the given regex (all metrics by default) into trend, seasonal and residual components. "+
+			"The period is given as number of samples. The trend is a centered moving average over one period, the seasonal component is the average "+
+			"deviation from the trend at each position within the period, and the residual is the remainder. The components are appended as new metrics with "+
+			"the suffixes '"+DecompositionTrendSuffix+"', '"+DecompositionSeasonalSuffix+"' and '"+DecompositionResidualSuffix+"'. "+
+			"The batch must contain at least two periods. The trend and residual are NaN for the first and last half period.",
+		reg.RequiredParams("period"), reg.OptionalParams("metrics"), reg.SupportBatch())
+}
+
+// SeasonalDecomposition performs a classic additive decomposition of the metrics matching the Metrics regex.
+// The samples are expected to be evenly spaced, in order, and Period is the number of samples in one season.
+// The trend is the centered moving average over Period samples (for an even Period, a 2xPeriod moving average
+// is used, so that the window stays centered). The trend cannot be computed for the first and last Period/2 samples,
+// so it is NaN there. The seasonal component is the average of the detrended values at each position within the period,
+// shifted so that it sums up to zero over one period. The residual is the value minus trend and seasonal component.
+// For every matching metric, the three components are appended as new metrics, named after the metric with the suffixes
+// DecompositionTrendSuffix, DecompositionSeasonalSuffix and DecompositionResidualSuffix.
+// Batches with less than two periods result in an error.
+type SeasonalDecomposition struct {
+	Period  int
+	Metrics *regexp.Regexp
+}
+
+func (d *SeasonalDecomposition) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	if len(samples) < 2*d.Period {
+		return nil, nil, fmt.Errorf("%v: Need at least two periods (%v samples), but batch contains %v samples", d, 2*d.Period, len(samples))
+	}
+	for _, sample := range samples {
+		if len(sample.Values) != len(header.Fields) {
+			return nil, nil, fmt.Errorf("%v: Sample has %v values, but header has %v fields", d, len(sample.Values), len(header.Fields))
+		}
+	}
+
+	var indices []int
+	fields := make([]string, len(header.Fields))
+	copy(fields, header.Fields)
+	for i, field := range header.Fields {
+		if d.Metrics == nil || d.Metrics.MatchString(field) {
+			indices = append(indices, i)
+			fields = append(fields, field+DecompositionTrendSuffix, field+DecompositionSeasonalSuffix, field+DecompositionResidualSuffix)
+		}
+	}
+	if len(indices) == 0 {
+		return header, samples, nil
+	}
+
+	numFields := len(header.Fields)
+	values := make([]float64, len(samples))
+	for _, sample := range samples {
+		if old := sample.Values; !sample.Resize(len(fields)) {
+			copy(sample.Values, old)
+		}
+	}
+	for i, index := range indices {
+		for j, sample := range samples {
+			values[j] = float64(sample.Values[index])
+		}
+		trend, seasonal := d.decompose(values)
+		out := numFields + 3*i
+		for j, sample := range samples {
+			sample.Values[out] = bitflow.Value(trend[j])
+			sample.Values[out+1] = bitflow.Value(seasonal[j])
+			sample.Values[out+2] = bitflow.Value(values[j] - trend[j] - seasonal[j])
+		}
+	}
+	return header.Clone(fields), samples, nil
+}
+
+// decompose returns the trend and seasonal components of the given values
+func (d *SeasonalDecomposition) decompose(values []float64) (trend []float64, seasonal []float64) {
+	trend = d.movingAverage(values)
+
+	// Average deviation from the trend at every position within the period
+	sums := make([]float64, d.Period)
+	counts := make([]int, d.Period)
+	for i, value := range values {
+		if diff := value - trend[i]; !math.IsNaN(diff) {
+			sums[i%d.Period] += diff
+			counts[i%d.Period]++
+		}
+	}
+	var mean float64
+	for i := range sums {
+		sums[i] /= float64(counts[i]) // NaN if there are no valid values at this position
+		mean += sums[i]
+	}
+	mean /= float64(d.Period)
+
+	seasonal = make([]float64, len(values))
+	for i := range seasonal {
+		seasonal[i] = sums[i%d.Period] - mean
+	}
+	return
+}
+
+// movingAverage computes the centered moving average over one period, which is NaN at the edges
+func (d *SeasonalDecomposition) movingAverage(values []float64) []float64 {
+	half := d.Period / 2
+	even := d.Period%2 == 0
+	trend := make([]float64, len(values))
+	for i := range trend {
+		if i < half || i >= len(values)-half {
+			trend[i] = math.NaN()
+			continue
+		}
+		var sum float64
+		for j := i - half; j <= i+half; j++ {
+			if even && (j == i-half || j == i+half) {
+				sum += values[j] / 2
+			} else {
+				sum += values[j]
+			}
+		}
+		trend[i] = sum / float64(d.Period)
+	}
+	return trend
+}
+
+func (d *SeasonalDecomposition) String() string {
+	return fmt.Sprintf("Seasonal decomposition (period %v, metrics %v)", d.Period, d.Metrics)
+}
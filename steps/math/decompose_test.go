@@ -0,0 +1,64 @@
+package math
+
+import (
+	"math"
+	"math/rand"
+	"regexp"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestSeasonalDecomposition(t *testing.T) {
+	assert := testAssert.New(t)
+	const period = 12
+	season := make([]float64, period)
+	for i := range season {
+		season[i] = 3 * math.Sin(2*math.Pi*float64(i)/period)
+	}
+	rnd := rand.New(rand.NewSource(42))
+	header := &bitflow.Header{Fields: []string{"val", "other"}}
+	samples := make([]*bitflow.Sample, 10*period)
+	for i := range samples {
+		value := 10 + 0.5*float64(i) + season[i%period] + (rnd.Float64()-0.5)*0.2
+		samples[i] = &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(value), 1}}
+	}
+
+	step := &SeasonalDecomposition{Period: period, Metrics: regexp.MustCompile("^val$")}
+	outHeader, outSamples, err := step.ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal([]string{"val", "other", "val_trend", "val_seasonal", "val_residual"}, outHeader.Fields)
+	assert.Len(outSamples, len(samples))
+	for i, sample := range outSamples {
+		assert.Len(sample.Values, 5)
+		assert.Equal(bitflow.Value(1), sample.Values[1])
+		assert.InDelta(season[i%period], float64(sample.Values[3]), 0.1, "sample %v", i)
+
+		trend, residual := float64(sample.Values[2]), float64(sample.Values[4])
+		if i < period/2 || i >= len(samples)-period/2 {
+			assert.True(math.IsNaN(trend), "sample %v", i)
+			assert.True(math.IsNaN(residual), "sample %v", i)
+		} else {
+			assert.InDelta(10+0.5*float64(i), trend, 0.1, "sample %v", i)
+			assert.InDelta(0, residual, 0.2, "sample %v", i)
+			assert.InDelta(float64(sample.Values[0]), trend+float64(sample.Values[3])+residual, 1e-9)
+		}
+	}
+}
+
+func TestSeasonalDecompositionShortBatch(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"val"}}
+	samples := make([]*bitflow.Sample, 7)
+	for i := range samples {
+		samples[i] = &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i)}}
+	}
+	_, _, err := (&SeasonalDecomposition{Period: 4}).ProcessBatch(header, samples)
+	assert.Error(err)
+
+	outHeader, outSamples, err := (&SeasonalDecomposition{Period: 3}).ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Len(outHeader.Fields, 4)
+	assert.Len(outSamples, 7)
+}
@@ -0,0 +1,86 @@
+package math
+
+import (
+	"fmt"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"gonum.org/v1/gonum/mat"
+)
+
+func RegisterDetrend(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("detrend",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			degree := reg.IntParam(params, "degree", 1, true, &err)
+			if err == nil {
+				if degree < 0 {
+					err = reg.ParameterError("degree", fmt.Errorf("Must not be negative: %v", degree))
+				} else {
+					p.Batch(&Detrend{Degree: degree})
+				}
+			}
+			return
+		},
+		"Fit a polynomial trend (linear by default) to every metric in a batch using least squares, and replace the values with the residuals",
+		reg.OptionalParams("degree"), reg.SupportBatch())
+}
+
+// Detrend fits a polynomial of the given degree to the values of every metric, using the sample timestamps
+// as the independent variable, and subtracts it from the values. If all samples have the same timestamp,
+// the sample indices are used instead.
+type Detrend struct {
+	Degree int
+}
+
+func (d *Detrend) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 || len(header.Fields) == 0 {
+		return header, samples, nil
+	}
+	if len(samples) <= d.Degree {
+		return nil, nil, fmt.Errorf("%v: Need more than %v samples, but batch contains %v", d, d.Degree, len(samples))
+	}
+
+	// Least squares solution of trend * coefficients = values
+	trend := d.buildTrendMatrix(samples)
+	values := SamplesToMatrix(samples)
+	var coefficients, fitted mat.Dense
+	if err := coefficients.Solve(trend, values); err != nil {
+		return nil, nil, fmt.Errorf("%v: Failed to fit trend: %v", d, err)
+	}
+	fitted.Mul(trend, &coefficients)
+
+	for i, sample := range samples {
+		for j, val := range sample.Values {
+			sample.Values[j] = val - bitflow.Value(fitted.At(i, j))
+		}
+	}
+	return header, samples, nil
+}
+
+// buildTrendMatrix returns the Vandermonde matrix for the sample positions. The positions are scaled to [0, 1]
+// to keep the matrix well-conditioned for higher degrees.
+func (d *Detrend) buildTrendMatrix(samples []*bitflow.Sample) *mat.Dense {
+	first, last := samples[0].Time, samples[len(samples)-1].Time
+	span := float64(last.Sub(first))
+	useTime := span != 0
+
+	trend := mat.NewDense(len(samples), d.Degree+1, nil)
+	for i, sample := range samples {
+		var x float64
+		if useTime {
+			x = float64(sample.Time.Sub(first)) / span
+		} else if len(samples) > 1 {
+			x = float64(i) / float64(len(samples)-1)
+		}
+		power := 1.0
+		for j := 0; j <= d.Degree; j++ {
+			trend.Set(i, j, power)
+			power *= x
+		}
+	}
+	return trend
+}
+
+func (d *Detrend) String() string {
+	return fmt.Sprintf("Detrend (polynomial degree %v)", d.Degree)
+}
@@ -0,0 +1,77 @@
+package math
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func makeTrendSamples(num int, trend func(x float64) float64, noise float64) []*bitflow.Sample {
+	rnd := rand.New(rand.NewSource(42))
+	start := time.Now()
+	samples := make([]*bitflow.Sample, num)
+	for i := range samples {
+		x := float64(i)
+		samples[i] = &bitflow.Sample{
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Values: []bitflow.Value{bitflow.Value(trend(x) + (rnd.Float64()-0.5)*noise)},
+		}
+	}
+	return samples
+}
+
+// slope computes the least squares slope of the values of the first metric over the sample indices
+func slope(samples []*bitflow.Sample) float64 {
+	n := float64(len(samples))
+	var sumX, sumY, sumXY, sumXX float64
+	for i, sample := range samples {
+		x, y := float64(i), float64(sample.Values[0])
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+}
+
+func TestDetrendLinear(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"val"}}
+	samples := makeTrendSamples(200, func(x float64) float64 { return 5 + 3*x }, 2)
+	assert.InDelta(3, slope(samples), 0.1)
+
+	outHeader, outSamples, err := (&Detrend{Degree: 1}).ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal(header, outHeader)
+	assert.Len(outSamples, 200)
+	assert.InDelta(0, slope(outSamples), 1e-6)
+
+	var mean float64
+	for _, sample := range outSamples {
+		assert.InDelta(0, float64(sample.Values[0]), 1.5) // Only the noise of up to ±1 remains, plus the fitting error
+		mean += float64(sample.Values[0]) / float64(len(outSamples))
+	}
+	assert.InDelta(0, mean, 1e-6)
+}
+
+func TestDetrendPolynomial(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"val"}}
+	samples := makeTrendSamples(50, func(x float64) float64 { return 1 - 2*x + 0.5*x*x }, 0)
+
+	_, outSamples, err := (&Detrend{Degree: 2}).ProcessBatch(header, samples)
+	assert.NoError(err)
+	for _, sample := range outSamples {
+		assert.InDelta(0, float64(sample.Values[0]), 1e-6)
+	}
+}
+
+func TestDetrendTooFewSamples(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"val"}}
+	_, _, err := (&Detrend{Degree: 3}).ProcessBatch(header, makeTrendSamples(3, func(x float64) float64 { return x }, 0))
+	assert.Error(err)
+}
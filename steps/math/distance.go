@@ -0,0 +1,188 @@
+package math
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/go-ini/ini"
+)
+
+const (
+	DistanceRefMean       = "mean"
+	DistanceRefFilePrefix = "file:"
+	DefaultDistanceMetric = "distance"
+)
+
+// VectorNorm computes the length of a vector of differences
+type VectorNorm func(diff []float64) float64
+
+var VectorNorms = map[string]VectorNorm{
+	"l1":   NormL1,
+	"l2":   NormL2,
+	"linf": NormLInf,
+}
+
+func NormL1(diff []float64) (res float64) {
+	for _, d := range diff {
+		res += math.Abs(d)
+	}
+	return
+}
+
+func NormL2(diff []float64) float64 {
+	var sum float64
+	for _, d := range diff {
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+func NormLInf(diff []float64) (res float64) {
+	for _, d := range diff {
+		res = math.Max(res, math.Abs(d))
+	}
+	return
+}
+
+func RegisterDistance(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("distance",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			ref := reg.StrParam(params, "ref", DistanceRefMean, true, &err)
+			normName := reg.StrParam(params, "norm", "l2", true, &err)
+			metric := reg.StrParam(params, "metric", DefaultDistanceMetric, true, &err)
+			if err != nil {
+				return
+			}
+			norm, ok := VectorNorms[normName]
+			if !ok {
+				return reg.ParameterError("norm", fmt.Errorf("Unknown norm '%v', must be one of l1, l2, linf", normName))
+			}
+			step := &DistanceProcessor{
+				Metric:   metric,
+				Norm:     norm,
+				NormName: normName,
+			}
+			if strings.HasPrefix(ref, DistanceRefFilePrefix) {
+				step.Reference, err = LoadReferenceVector(strings.TrimPrefix(ref, DistanceRefFilePrefix))
+				if err != nil {
+					return reg.ParameterError("ref", err)
+				}
+			} else if ref != DistanceRefMean {
+				return reg.ParameterError("ref", fmt.Errorf("Must be '%v' or '%v<path>'", DistanceRefMean, DistanceRefFilePrefix))
+			}
+			p.Add(step)
+			return
+		},
+		"Append a metric with the distance of every sample from a reference vector. The reference is either the running mean of all previous samples (ref=mean), "+
+			"or loaded from an ini-file as written by the 'stats' step, using the 'avg' values (ref=file:<path>). The norm can be l1, l2 (default) or linf. "+
+			"The running mean is reset when the header changes.",
+		reg.OptionalParams("ref", "norm", "metric"))
+}
+
+// LoadReferenceVector loads a reference value for every metric from an ini-file written by the StoreStats step.
+// Every section is a metric name, and the reference value is the 'avg' key.
+func LoadReferenceVector(file string) (map[string]float64, error) {
+	cfg, err := ini.Load(file)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]float64)
+	for _, section := range cfg.Sections() {
+		if !section.HasKey("avg") {
+			continue
+		}
+		val, err := section.Key("avg").Float64()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'avg' value for metric %v: %v", section.Name(), err)
+		}
+		res[section.Name()] = val
+	}
+	if len(res) == 0 {
+		return nil, errors.New("File does not contain any reference values: " + file)
+	}
+	return res, nil
+}
+
+// DistanceProcessor appends the distance between the sample values and a reference vector as a new metric.
+// If Reference is nil, the distance from the mean of all previously received samples is computed. In that case,
+// the first sample after every header change has distance 0.
+type DistanceProcessor struct {
+	bitflow.NoopProcessor
+	Metric    string
+	Norm      VectorNorm
+	NormName  string
+	Reference map[string]float64
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	refValues []float64
+	diff      []float64
+	count     int
+}
+
+func (p *DistanceProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		if err := p.updateHeader(header); err != nil {
+			return err
+		}
+	}
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", p, len(sample.Values), len(header.Fields))
+	}
+	if p.Reference == nil && p.count == 0 {
+		for i, val := range sample.Values {
+			p.refValues[i] = float64(val)
+		}
+	}
+	for i, val := range sample.Values {
+		p.diff[i] = float64(val) - p.refValues[i]
+	}
+	distance := p.Norm(p.diff)
+	if p.Reference == nil {
+		// Update the running mean after computing the distance
+		p.count++
+		for i, val := range sample.Values {
+			p.refValues[i] += (float64(val) - p.refValues[i]) / float64(p.count)
+		}
+	}
+
+	values := sample.Values
+	if !sample.Resize(len(values) + 1) {
+		copy(sample.Values, values)
+	}
+	sample.Values[len(values)] = bitflow.Value(distance)
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+func (p *DistanceProcessor) updateHeader(header *bitflow.Header) error {
+	p.refValues = make([]float64, len(header.Fields))
+	p.diff = make([]float64, len(header.Fields))
+	p.count = 0
+	if p.Reference != nil {
+		for i, field := range header.Fields {
+			val, ok := p.Reference[field]
+			if !ok {
+				return fmt.Errorf("%v: No reference value for metric %v", p, field)
+			}
+			p.refValues[i] = val
+		}
+	}
+	p.outHeader = header.Clone(append(header.Fields[:len(header.Fields):len(header.Fields)], p.Metric))
+	return nil
+}
+
+func (p *DistanceProcessor) OutputSampleSize(sampleSize int) int {
+	return sampleSize + 1
+}
+
+func (p *DistanceProcessor) String() string {
+	ref := "running mean"
+	if p.Reference != nil {
+		ref = fmt.Sprintf("reference vector of %v metrics", len(p.Reference))
+	}
+	return fmt.Sprintf("%v distance from %v (metric %v)", p.NormName, ref, p.Metric)
+}
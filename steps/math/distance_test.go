@@ -0,0 +1,69 @@
+package math
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _runDistance(t *testing.T, p *DistanceProcessor, header *bitflow.Header, values ...[]bitflow.Value) []bitflow.Value {
+	assert := testAssert.New(t)
+	out := new(collectingSink)
+	p.SetSink(out)
+	p.Start(new(sync.WaitGroup))
+	for _, vals := range values {
+		assert.NoError(p.Sample(&bitflow.Sample{Values: vals}, header))
+	}
+	p.Close()
+	res := make([]bitflow.Value, len(out.samples))
+	for i, sample := range out.samples {
+		assert.Equal(append(header.Fields[:len(header.Fields):len(header.Fields)], p.Metric), out.headers[i].Fields)
+		res[i] = sample.Values[len(sample.Values)-1]
+	}
+	return res
+}
+
+func TestDistanceFixedReference(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	ref := map[string]float64{"a": 1, "b": 2}
+	values := [][]bitflow.Value{{4, 6}, {1, 2}, {0, 4}}
+
+	res := _runDistance(t, &DistanceProcessor{Metric: "d", Norm: NormL2, Reference: ref}, header, values...)
+	assert.Equal([]bitflow.Value{5, 0, bitflow.Value(NormL2([]float64{1, 2}))}, res)
+	res = _runDistance(t, &DistanceProcessor{Metric: "d", Norm: NormL1, Reference: ref}, header, values...)
+	assert.Equal([]bitflow.Value{7, 0, 3}, res)
+	res = _runDistance(t, &DistanceProcessor{Metric: "d", Norm: NormLInf, Reference: ref}, header, values...)
+	assert.Equal([]bitflow.Value{4, 0, 2}, res)
+
+	missing := &DistanceProcessor{Metric: "d", Norm: NormL2, Reference: map[string]float64{"a": 1}}
+	missing.SetSink(new(collectingSink))
+	assert.Error(missing.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, header))
+}
+
+func TestDistanceRunningMean(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	// Means of the previous samples: (2,2), then (5,6)
+	res := _runDistance(t, &DistanceProcessor{Metric: "d", Norm: NormL1}, header,
+		[]bitflow.Value{2, 2}, []bitflow.Value{8, 10}, []bitflow.Value{5, 7})
+	assert.Equal([]bitflow.Value{0, 14, 1}, res)
+}
+
+func TestDistanceReferenceFile(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-distance-")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+	file := filepath.Join(dir, "stats.ini")
+	assert.NoError(ioutil.WriteFile(file, []byte("[a]\navg = 1\nstddev = 3\n\n[b]\navg = 2.5\n"), 0644))
+
+	ref, err := LoadReferenceVector(file)
+	assert.NoError(err)
+	assert.Equal(map[string]float64{"a": 1, "b": 2.5}, ref)
+}
@@ -0,0 +1,136 @@
+package math
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterEnvelope(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("envelope",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			window := reg.IntParam(params, "window", 0, false, &err)
+			if err == nil {
+				if window < 1 {
+					err = reg.ParameterError("window", errors.New("Must be > 0"))
+				} else {
+					p.Add(&Envelope{Window: window})
+				}
+			}
+			return
+		},
+		"For every metric, append the metrics <metric>_min and <metric>_max with the minimum and maximum of the last 'window' values. "+
+			"NaN values are ignored. The windows are reset when the header changes.",
+		reg.RequiredParams("window"))
+}
+
+// Envelope appends the minimum and maximum of the last Window values of every metric as new metrics, named with the
+// suffixes "_min" and "_max". The extremes are maintained through monotonic queues, which requires amortized
+// constant time per value.
+type Envelope struct {
+	bitflow.NoopProcessor
+	Window int
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	mins      []monotonicQueue
+	maxs      []monotonicQueue
+	count     int
+}
+
+func (e *Envelope) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if e.checker.HeaderChanged(header) {
+		e.updateHeader(header)
+	}
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", e, len(sample.Values), len(header.Fields))
+	}
+
+	numFields := len(header.Fields)
+	values := sample.Values
+	if !sample.Resize(numFields * 3) {
+		copy(sample.Values, values)
+	}
+	for i, val := range sample.Values[:numFields] {
+		if !math.IsNaN(float64(val)) {
+			e.mins[i].push(e.count, val, notSmaller)
+			e.maxs[i].push(e.count, val, notLarger)
+		}
+		e.mins[i].evict(e.count - e.Window)
+		e.maxs[i].evict(e.count - e.Window)
+		sample.Values[numFields+2*i] = e.mins[i].front()
+		sample.Values[numFields+2*i+1] = e.maxs[i].front()
+	}
+	e.count++
+	return e.NoopProcessor.Sample(sample, e.outHeader)
+}
+
+func (e *Envelope) updateHeader(header *bitflow.Header) {
+	fields := make([]string, len(header.Fields), len(header.Fields)*3)
+	copy(fields, header.Fields)
+	for _, field := range header.Fields {
+		fields = append(fields, field+"_min", field+"_max")
+	}
+	e.outHeader = header.Clone(fields)
+	e.mins = make([]monotonicQueue, len(header.Fields))
+	e.maxs = make([]monotonicQueue, len(header.Fields))
+	e.count = 0
+}
+
+func (e *Envelope) OutputSampleSize(sampleSize int) int {
+	return sampleSize * 3
+}
+
+func (e *Envelope) String() string {
+	return fmt.Sprintf("Rolling min/max envelope (window %v)", e.Window)
+}
+
+type monotonicEntry struct {
+	index int
+	value bitflow.Value
+}
+
+// monotonicQueue stores the candidates for the extreme value of a sliding window. The front entry is the current extreme.
+type monotonicQueue struct {
+	entries []monotonicEntry
+	head    int
+}
+
+func notSmaller(existing, newValue bitflow.Value) bool {
+	return existing >= newValue
+}
+
+func notLarger(existing, newValue bitflow.Value) bool {
+	return existing <= newValue
+}
+
+// push removes all entries from the back of the queue that can no longer become the extreme value
+// because of the new value, and appends the new value.
+func (q *monotonicQueue) push(index int, value bitflow.Value, replaced func(existing, newValue bitflow.Value) bool) {
+	end := len(q.entries)
+	for end > q.head && replaced(q.entries[end-1].value, value) {
+		end--
+	}
+	q.entries = append(q.entries[:end], monotonicEntry{index: index, value: value})
+}
+
+// evict removes all entries with an index <= the given index from the front of the queue.
+func (q *monotonicQueue) evict(index int) {
+	for q.head < len(q.entries) && q.entries[q.head].index <= index {
+		q.head++
+	}
+	if q.head > 32 && q.head > len(q.entries)/2 {
+		q.entries = q.entries[:copy(q.entries, q.entries[q.head:])]
+		q.head = 0
+	}
+}
+
+func (q *monotonicQueue) front() bitflow.Value {
+	if q.head >= len(q.entries) {
+		return bitflow.Value(math.NaN())
+	}
+	return q.entries[q.head].value
+}
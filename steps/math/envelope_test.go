@@ -0,0 +1,68 @@
+package math
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestEnvelope(t *testing.T) {
+	assert := testAssert.New(t)
+	const window = 5
+	step := &Envelope{Window: window}
+	out := new(collectingSink)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+
+	rnd := rand.New(rand.NewSource(7))
+	header := &bitflow.Header{Fields: []string{"sin", "noise"}}
+	var inputs [][]bitflow.Value
+	for i := 0; i < 200; i++ {
+		values := []bitflow.Value{bitflow.Value(math.Sin(float64(i) / 5)), bitflow.Value(rnd.Float64())}
+		inputs = append(inputs, values)
+		assert.NoError(step.Sample(&bitflow.Sample{Values: append([]bitflow.Value(nil), values...)}, header))
+	}
+
+	assert.Len(out.samples, len(inputs))
+	assert.Equal([]string{"sin", "noise", "sin_min", "sin_max", "noise_min", "noise_max"}, out.headers[0].Fields)
+	for i, sample := range out.samples {
+		assert.Len(sample.Values, 6)
+		for metric := 0; metric < 2; metric++ {
+			expectedMin, expectedMax := math.Inf(1), math.Inf(-1)
+			for j := i - window + 1; j <= i; j++ {
+				if j >= 0 {
+					expectedMin = math.Min(expectedMin, float64(inputs[j][metric]))
+					expectedMax = math.Max(expectedMax, float64(inputs[j][metric]))
+				}
+			}
+			assert.Equal(inputs[i][metric], sample.Values[metric])
+			assert.Equal(expectedMin, float64(sample.Values[2+2*metric]), "min of metric %v at %v", metric, i)
+			assert.Equal(expectedMax, float64(sample.Values[3+2*metric]), "max of metric %v at %v", metric, i)
+		}
+	}
+}
+
+func TestEnvelopeResetAndNaN(t *testing.T) {
+	assert := testAssert.New(t)
+	step := &Envelope{Window: 3}
+	out := new(collectingSink)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"x"}}
+	for _, val := range []bitflow.Value{10, 20, bitflow.Value(math.NaN()), 5} {
+		assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{val}}, header))
+	}
+	assert.Equal([]bitflow.Value{10, 20}, out.samples[2].Values[1:])
+	assert.Equal([]bitflow.Value{5, 20}, out.samples[3].Values[1:])
+
+	// A new header resets the windows
+	assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{bitflow.Value(math.NaN())}}, &bitflow.Header{Fields: []string{"y"}}))
+	last := out.samples[4]
+	assert.True(math.IsNaN(float64(last.Values[1])))
+	assert.True(math.IsNaN(float64(last.Values[2])))
+}
@@ -0,0 +1,140 @@
+package math
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const FirFilterSuffix = "_fir"
+
+func RegisterFirFilter(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("fir",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &FirFilter{
+				Replace: reg.BoolParam(params, "replace", false, true, &err),
+				Partial: reg.BoolParam(params, "partial", false, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			step.Kernel, err = ParseFirKernel(params["kernel"])
+			if err != nil {
+				return reg.ParameterError("kernel", err)
+			}
+			p.Add(step)
+			return
+		},
+		"Convolve every metric with the given kernel, a comma-separated list of weights. The first weight is applied to the current value, the second weight to the previous value, and so on. "+
+			"The results are appended as new metrics with the suffix '"+FirFilterSuffix+"', or replace the original values if replace=true. "+
+			"Until the window is filled, the result is NaN, or the weighted sum of the available values if partial=true. The windows are reset when the header changes.",
+		reg.RequiredParams("kernel"), reg.OptionalParams("replace", "partial"))
+}
+
+// ParseFirKernel parses a comma-separated list of weights.
+func ParseFirKernel(kernel string) ([]float64, error) {
+	var weights []float64
+	for _, str := range strings.Split(kernel, ",") {
+		weight, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			return nil, err
+		}
+		weights = append(weights, weight)
+	}
+	if len(weights) == 0 {
+		return nil, errors.New("The kernel must contain at least one weight")
+	}
+	return weights, nil
+}
+
+// FirFilter applies a finite impulse response filter to every metric: the output is the weighted sum of the last
+// len(Kernel) values, where Kernel[0] is the weight of the current value, Kernel[1] the weight of the previous value, and so on.
+// This allows arbitrary smoothing kernels, e.g. triangular or Gaussian weights. If Replace is true, the original values are
+// replaced with the results. Otherwise, the results are appended as new metrics named after the original metrics with FirFilterSuffix.
+// Until len(Kernel) values were received, the result is NaN, unless Partial is set: then the missing values are treated as zero.
+// All windows are reset when the header changes.
+type FirFilter struct {
+	bitflow.NoopProcessor
+	Kernel  []float64
+	Replace bool
+	Partial bool
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	windows   [][]float64 // Ring buffers of the last values of every metric
+	next      int
+	count     int
+}
+
+func (f *FirFilter) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if f.checker.HeaderChanged(header) {
+		f.windows = make([][]float64, len(header.Fields))
+		for i := range f.windows {
+			f.windows[i] = make([]float64, len(f.Kernel))
+		}
+		f.next, f.count = 0, 0
+		if f.Replace {
+			f.outHeader = header
+		} else {
+			fields := make([]string, len(header.Fields), len(header.Fields)*2)
+			copy(fields, header.Fields)
+			for _, field := range header.Fields {
+				fields = append(fields, field+FirFilterSuffix)
+			}
+			f.outHeader = header.Clone(fields)
+		}
+	}
+	if len(sample.Values) != len(f.windows) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", f, len(sample.Values), len(f.windows))
+	}
+
+	values := sample.Values
+	if !f.Replace && !sample.Resize(len(values)*2) {
+		copy(sample.Values, values)
+	}
+	if f.count < len(f.Kernel) {
+		f.count++
+	}
+	for i, value := range values {
+		window := f.windows[i]
+		window[f.next] = float64(value)
+		result := math.NaN()
+		if f.Partial || f.count == len(f.Kernel) {
+			result = 0
+			for j := 0; j < f.count; j++ {
+				index := f.next - j
+				if index < 0 {
+					index += len(window)
+				}
+				result += f.Kernel[j] * window[index]
+			}
+		}
+		if f.Replace {
+			sample.Values[i] = bitflow.Value(result)
+		} else {
+			sample.Values[len(values)+i] = bitflow.Value(result)
+		}
+	}
+	f.next = (f.next + 1) % len(f.Kernel)
+	return f.NoopProcessor.Sample(sample, f.outHeader)
+}
+
+func (f *FirFilter) OutputSampleSize(sampleSize int) int {
+	if f.Replace {
+		return sampleSize
+	}
+	return sampleSize * 2
+}
+
+func (f *FirFilter) String() string {
+	res := fmt.Sprintf("FIR filter with kernel %v", f.Kernel)
+	if f.Replace {
+		res += " (replace values)"
+	}
+	return res
+}
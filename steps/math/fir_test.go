@@ -0,0 +1,59 @@
+package math
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestFirFilter(t *testing.T) {
+	assert := testAssert.New(t)
+	kernel, err := ParseFirKernel("0.5, 0.3,0.2")
+	assert.NoError(err)
+	assert.Equal([]float64{0.5, 0.3, 0.2}, kernel)
+	_, err = ParseFirKernel("1,x")
+	assert.Error(err)
+
+	inputs := []float64{1, 2, 4, 8, 16, -3, 5}
+	run := func(f *FirFilter) *collectingSink {
+		out := new(collectingSink)
+		f.SetSink(out)
+		f.Start(new(sync.WaitGroup))
+		header := &bitflow.Header{Fields: []string{"a", "b"}}
+		for _, input := range inputs {
+			assert.NoError(f.Sample(&bitflow.Sample{Values: []bitflow.Value{bitflow.Value(input), bitflow.Value(-2 * input)}}, header))
+		}
+		return out
+	}
+
+	out := run(&FirFilter{Kernel: kernel})
+	assert.Equal([]string{"a", "b", "a_fir", "b_fir"}, out.headers[0].Fields)
+	for n, sample := range out.samples {
+		assert.Equal(bitflow.Value(inputs[n]), sample.Values[0])
+		if n < len(kernel)-1 {
+			assert.True(math.IsNaN(float64(sample.Values[2])), "sample %v", n)
+			continue
+		}
+		expected := 0.0
+		for j, weight := range kernel {
+			expected += weight * inputs[n-j]
+		}
+		assert.InDelta(expected, float64(sample.Values[2]), 1e-9, "sample %v", n)
+		assert.InDelta(-2*expected, float64(sample.Values[3]), 1e-9, "sample %v", n)
+	}
+
+	out = run(&FirFilter{Kernel: kernel, Replace: true, Partial: true})
+	assert.Equal([]string{"a", "b"}, out.headers[0].Fields)
+	assert.Equal([]bitflow.Value{0.5, -1}, out.samples[0].Values)
+	assert.InDelta(0.5*2+0.3*1, float64(out.samples[1].Values[0]), 1e-9)
+	assert.InDelta(0.5*5+0.3*-3+0.2*16, float64(out.samples[6].Values[0]), 1e-9)
+
+	// The windows are reset when the header changes
+	f := &FirFilter{Kernel: []float64{1, 1}, Partial: true}
+	out = run(f)
+	assert.NoError(f.Sample(&bitflow.Sample{Values: []bitflow.Value{3}}, &bitflow.Header{Fields: []string{"c"}}))
+	assert.Equal([]bitflow.Value{3, 3}, out.samples[len(out.samples)-1].Values)
+}
@@ -0,0 +1,165 @@
+package math
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// Output fields of ValueHistogramOverTime
+const (
+	HistogramBinLowField  = "bin_low"
+	HistogramBinHighField = "bin_high"
+	HistogramCountField   = "count"
+)
+
+func RegisterValueHistogramOverTime(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("value_histogram_over_time",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			hist := &ValueHistogramOverTime{
+				Metric:     reg.StrParam(params, "metric", "", false, &err),
+				TimeBucket: reg.DurationParam(params, "time-bucket", 0, false, &err),
+				Bins:       reg.IntParam(params, "bins", 0, false, &err),
+				Min:        reg.FloatParam(params, "min", math.NaN(), true, &err),
+				Max:        reg.FloatParam(params, "max", math.NaN(), true, &err),
+			}
+			if err != nil {
+				return
+			}
+			if hist.TimeBucket <= 0 {
+				return reg.ParameterError("time-bucket", errors.New("Must be positive"))
+			}
+			if hist.Bins <= 0 {
+				return reg.ParameterError("bins", errors.New("Must be positive"))
+			}
+			if !math.IsNaN(hist.Min) && !math.IsNaN(hist.Max) && hist.Min >= hist.Max {
+				return reg.ParameterError("max", errors.New("Must be larger than min"))
+			}
+			p.Batch(hist)
+			return
+		},
+		"For the given metric, group the samples of a batch into time buckets of the given duration, and count the values in the given number of equal-width bins. "+
+			"Outputs one sample per time bucket and bin with the fields "+HistogramBinLowField+", "+HistogramBinHighField+" and "+HistogramCountField+". "+
+			"The value range of the bins is taken from the batch, unless min and/or max are given. Values outside of the range are ignored.",
+		reg.RequiredParams("metric", "time-bucket", "bins"), reg.OptionalParams("min", "max"), reg.SupportBatch())
+}
+
+// ValueHistogramOverTime computes a histogram of the values of one metric for every time bucket of a batch.
+// The time buckets are aligned to multiples of TimeBucket since the Unix epoch. If Min or Max are NaN, the
+// respective boundary is computed from the values in the batch. Every output sample is timestamped with the
+// start of its time bucket and receives the tags of the first sample in that bucket.
+type ValueHistogramOverTime struct {
+	Metric     string
+	TimeBucket time.Duration
+	Bins       int
+	Min        float64
+	Max        float64
+}
+
+func (h *ValueHistogramOverTime) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	index := -1
+	for i, field := range header.Fields {
+		if field == h.Metric {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, nil, fmt.Errorf("%v: Metric not found in header: %v", h, h.Metric)
+	}
+	outHeader := header.Clone([]string{HistogramBinLowField, HistogramBinHighField, HistogramCountField})
+	if len(samples) == 0 {
+		return outHeader, nil, nil
+	}
+	min, max := h.valueRange(samples, index)
+	width := (max - min) / float64(h.Bins)
+
+	type timeBucket struct {
+		reference *bitflow.Sample
+		counts    []int
+	}
+	buckets := make(map[time.Time]*timeBucket)
+	var bucketTimes []time.Time
+	for _, sample := range samples {
+		start := h.bucketStart(sample.Time)
+		bucket, ok := buckets[start]
+		if !ok {
+			bucket = &timeBucket{reference: sample, counts: make([]int, h.Bins)}
+			buckets[start] = bucket
+			bucketTimes = append(bucketTimes, start)
+		}
+		val := float64(sample.Values[index])
+		if val < min || val > max || math.IsNaN(val) {
+			continue
+		}
+		bin := h.Bins - 1 // The maximum value is included in the last bin
+		if width > 0 && val < max {
+			bin = int((val - min) / width)
+			if bin >= h.Bins {
+				bin = h.Bins - 1
+			}
+		}
+		bucket.counts[bin]++
+	}
+	sort.Slice(bucketTimes, func(i, j int) bool {
+		return bucketTimes[i].Before(bucketTimes[j])
+	})
+
+	outSamples := make([]*bitflow.Sample, 0, len(bucketTimes)*h.Bins)
+	for _, start := range bucketTimes {
+		bucket := buckets[start]
+		for bin, count := range bucket.counts {
+			outSample := bucket.reference.Clone()
+			outSample.Time = start
+			outSample.Values = []bitflow.Value{
+				bitflow.Value(min + float64(bin)*width),
+				bitflow.Value(min + float64(bin+1)*width),
+				bitflow.Value(count),
+			}
+			outSamples = append(outSamples, outSample)
+		}
+	}
+	return outHeader, outSamples, nil
+}
+
+func (h *ValueHistogramOverTime) bucketStart(t time.Time) time.Time {
+	nanos := t.UnixNano()
+	offset := nanos % int64(h.TimeBucket)
+	if offset < 0 {
+		offset += int64(h.TimeBucket)
+	}
+	return time.Unix(0, nanos-offset)
+}
+
+func (h *ValueHistogramOverTime) valueRange(samples []*bitflow.Sample, index int) (min, max float64) {
+	min, max = h.Min, h.Max
+	if math.IsNaN(min) || math.IsNaN(max) {
+		batchMin, batchMax := math.Inf(1), math.Inf(-1)
+		for _, sample := range samples {
+			if val := float64(sample.Values[index]); !math.IsNaN(val) {
+				batchMin = math.Min(batchMin, val)
+				batchMax = math.Max(batchMax, val)
+			}
+		}
+		if batchMin > batchMax {
+			// No valid values
+			batchMin, batchMax = 0, 0
+		}
+		if math.IsNaN(min) {
+			min = batchMin
+		}
+		if math.IsNaN(max) {
+			max = batchMax
+		}
+	}
+	return
+}
+
+func (h *ValueHistogramOverTime) String() string {
+	return fmt.Sprintf("Histogram of %v over time (time buckets: %v, %v bins)", h.Metric, h.TimeBucket, h.Bins)
+}
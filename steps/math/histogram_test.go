@@ -0,0 +1,55 @@
+package math
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestValueHistogramOverTime(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"other", "x"}}
+	start := time.Unix(1000, 0)
+	var samples []*bitflow.Sample
+	add := func(offset time.Duration, values ...bitflow.Value) {
+		for _, val := range values {
+			samples = append(samples, &bitflow.Sample{Time: start.Add(offset), Values: []bitflow.Value{-1, val}})
+		}
+	}
+	// First bucket [1000s, 1010s), second bucket [1010s, 1020s)
+	add(0, 0, 1, 2)
+	add(5*time.Second, 9, 10)
+	add(12*time.Second, 5, 6, 7, 20)
+
+	hist := &ValueHistogramOverTime{Metric: "x", TimeBucket: 10 * time.Second, Bins: 2, Min: 0, Max: 10}
+	outHeader, out, err := hist.ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal([]string{HistogramBinLowField, HistogramBinHighField, HistogramCountField}, outHeader.Fields)
+	assert.Len(out, 4)
+	expected := [][]bitflow.Value{{0, 5, 3}, {5, 10, 2}, {0, 5, 0}, {5, 10, 3}}
+	for i, sample := range out {
+		assert.Equal(expected[i], sample.Values, "sample %v", i)
+	}
+	assert.True(start.Equal(out[0].Time))
+	assert.True(start.Equal(out[1].Time))
+	assert.True(start.Add(10 * time.Second).Equal(out[2].Time))
+	assert.True(start.Add(10 * time.Second).Equal(out[3].Time))
+
+	// Range taken from the batch: [0, 20]
+	hist = &ValueHistogramOverTime{Metric: "x", TimeBucket: 10 * time.Second, Bins: 4, Min: math.NaN(), Max: math.NaN()}
+	_, out, err = hist.ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Len(out, 8)
+	counts := make([]bitflow.Value, len(out))
+	for i, sample := range out {
+		counts[i] = sample.Values[2]
+	}
+	assert.Equal([]bitflow.Value{3, 1, 1, 0, 0, 3, 0, 1}, counts)
+
+	hist.Metric = "missing"
+	_, _, err = hist.ProcessBatch(header, samples)
+	assert.Error(err)
+}
@@ -0,0 +1,141 @@
+package math
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	ImputeMean     = "mean"
+	ImputeMedian   = "median"
+	ImputeConstant = "constant"
+
+	ImputeEmptyZero = "zero"
+	ImputeEmptyDrop = "drop"
+)
+
+func RegisterImputation(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("impute_mean",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &Imputation{
+				Strategy:     reg.StrParam(params, "strategy", ImputeMean, true, &err),
+				Value:        reg.FloatParam(params, "value", 0, true, &err),
+				EmptyColumns: reg.StrParam(params, "empty-columns", ImputeEmptyZero, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			switch step.Strategy {
+			case ImputeMean, ImputeMedian, ImputeConstant:
+			default:
+				return reg.ParameterError("strategy", fmt.Errorf("Must be one of %v, %v, %v", ImputeMean, ImputeMedian, ImputeConstant))
+			}
+			switch step.EmptyColumns {
+			case ImputeEmptyZero, ImputeEmptyDrop:
+			default:
+				return reg.ParameterError("empty-columns", fmt.Errorf("Must be one of %v, %v", ImputeEmptyZero, ImputeEmptyDrop))
+			}
+			p.Batch(step)
+			return
+		},
+		"Replace NaN values in a batch by the mean (default) or median of the finite values of the same metric, or by a constant value (strategy=constant). "+
+			"Metrics without any finite values are filled with zeros (empty-columns=zero, default) or removed (empty-columns=drop). "+
+			"With strategy=constant, such metrics are filled with the constant.",
+		reg.OptionalParams("strategy", "value", "empty-columns"), reg.SupportBatch())
+}
+
+// Imputation replaces the NaN values of every metric in a batch. With the ImputeMean or ImputeMedian Strategy, the
+// mean or median of the finite values of the metric is used, with ImputeConstant, the given Value is used.
+// Metrics without any finite values are handled according to EmptyColumns: ImputeEmptyDrop removes them
+// from the header, while ImputeEmptyZero fills them with zeros (or the Value for the ImputeConstant Strategy).
+type Imputation struct {
+	Strategy     string
+	Value        float64
+	EmptyColumns string
+}
+
+func (imp *Imputation) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	for _, sample := range samples {
+		if len(sample.Values) != len(header.Fields) {
+			return nil, nil, fmt.Errorf("%v: Sample has %v values, but header has %v fields", imp, len(sample.Values), len(header.Fields))
+		}
+	}
+
+	var keep []int
+	column := make([]float64, 0, len(samples))
+	for j := range header.Fields {
+		column = column[:0]
+		for _, sample := range samples {
+			if val := float64(sample.Values[j]); !math.IsNaN(val) && !math.IsInf(val, 0) {
+				column = append(column, val)
+			}
+		}
+		if len(column) == 0 && imp.EmptyColumns == ImputeEmptyDrop {
+			continue
+		}
+		keep = append(keep, j)
+		if len(column) == len(samples) {
+			continue // No NaN values
+		}
+		fill := imp.fillValue(column)
+		for _, sample := range samples {
+			if math.IsNaN(float64(sample.Values[j])) {
+				sample.Values[j] = bitflow.Value(fill)
+			}
+		}
+	}
+
+	if len(keep) == len(header.Fields) {
+		return header, samples, nil
+	}
+	fields := make([]string, len(keep))
+	for i, j := range keep {
+		fields[i] = header.Fields[j]
+	}
+	for _, sample := range samples {
+		for i, j := range keep {
+			sample.Values[i] = sample.Values[j]
+		}
+		sample.Values = sample.Values[:len(keep)]
+	}
+	return header.Clone(fields), samples, nil
+}
+
+// fillValue computes the replacement for NaN values based on the finite values of a metric.
+// The column slice might be reordered.
+func (imp *Imputation) fillValue(column []float64) float64 {
+	if imp.Strategy == ImputeConstant {
+		return imp.Value
+	}
+	if len(column) == 0 {
+		return 0
+	}
+	if imp.Strategy == ImputeMedian {
+		sort.Float64s(column)
+		middle := len(column) / 2
+		if len(column)%2 == 1 {
+			return column[middle]
+		}
+		return (column[middle-1] + column[middle]) / 2
+	}
+	var sum float64
+	for _, val := range column {
+		sum += val
+	}
+	return sum / float64(len(column))
+}
+
+func (imp *Imputation) String() string {
+	res := fmt.Sprintf("Impute NaN values (strategy %v", imp.Strategy)
+	if imp.Strategy == ImputeConstant {
+		res += fmt.Sprintf(" %v", imp.Value)
+	}
+	return res + fmt.Sprintf(", empty metrics: %v)", imp.EmptyColumns)
+}
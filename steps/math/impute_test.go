@@ -0,0 +1,75 @@
+package math
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func makeImputationSamples() (*bitflow.Header, []*bitflow.Sample) {
+	nan := bitflow.Value(math.NaN())
+	rows := [][]bitflow.Value{
+		{1, nan, nan, 5},
+		{nan, 2, nan, 5},
+		{3, 4, nan, nan},
+		{8, nan, nan, 5},
+		{nan, 9, nan, 5},
+	}
+	start := time.Unix(1000, 0)
+	samples := make([]*bitflow.Sample, len(rows))
+	for i, row := range rows {
+		samples[i] = &bitflow.Sample{Time: start.Add(time.Duration(i) * time.Second), Values: row}
+	}
+	return &bitflow.Header{Fields: []string{"a", "b", "empty", "c"}}, samples
+}
+
+func imputedColumn(samples []*bitflow.Sample, col int) []bitflow.Value {
+	res := make([]bitflow.Value, len(samples))
+	for i, sample := range samples {
+		res[i] = sample.Values[col]
+	}
+	return res
+}
+
+func TestImputationMean(t *testing.T) {
+	assert := testAssert.New(t)
+	header, samples := makeImputationSamples()
+	outHeader, out, err := (&Imputation{Strategy: ImputeMean, EmptyColumns: ImputeEmptyZero}).ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal(header, outHeader)
+	assert.Len(out, 5)
+	assert.Equal([]bitflow.Value{1, 4, 3, 8, 4}, imputedColumn(out, 0))
+	assert.Equal([]bitflow.Value{5, 2, 4, 5, 9}, imputedColumn(out, 1))
+	assert.Equal([]bitflow.Value{0, 0, 0, 0, 0}, imputedColumn(out, 2))
+	assert.Equal([]bitflow.Value{5, 5, 5, 5, 5}, imputedColumn(out, 3))
+	for i, sample := range out {
+		assert.Equal(time.Unix(1000+int64(i), 0), sample.Time)
+	}
+}
+
+func TestImputationMedianDrop(t *testing.T) {
+	assert := testAssert.New(t)
+	header, samples := makeImputationSamples()
+	outHeader, out, err := (&Imputation{Strategy: ImputeMedian, EmptyColumns: ImputeEmptyDrop}).ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal([]string{"a", "b", "c"}, outHeader.Fields)
+	assert.Equal([]string{"a", "b", "empty", "c"}, header.Fields, "the input header must not be modified")
+	assert.Equal([]bitflow.Value{1, 3, 3, 8, 3}, imputedColumn(out, 0))
+	assert.Equal([]bitflow.Value{4, 2, 4, 4, 9}, imputedColumn(out, 1))
+	assert.Equal([]bitflow.Value{5, 5, 5, 5, 5}, imputedColumn(out, 2))
+	for _, sample := range out {
+		assert.Len(sample.Values, 3)
+	}
+}
+
+func TestImputationConstant(t *testing.T) {
+	assert := testAssert.New(t)
+	header, samples := makeImputationSamples()
+	_, out, err := (&Imputation{Strategy: ImputeConstant, Value: -1, EmptyColumns: ImputeEmptyZero}).ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal([]bitflow.Value{1, -1, 3, 8, -1}, imputedColumn(out, 0))
+	assert.Equal([]bitflow.Value{-1, -1, -1, -1, -1}, imputedColumn(out, 2))
+}
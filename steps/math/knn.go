@@ -0,0 +1,213 @@
+package math
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultKnnLabelTag = "label"
+	DefaultKnnK        = 3
+)
+
+func RegisterKnnClassifier(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("knn",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			file := reg.StrParam(params, "reference", "", false, &err)
+			step := &KnnClassifier{
+				K:        reg.IntParam(params, "k", DefaultKnnK, true, &err),
+				LabelTag: reg.StrParam(params, "label-tag", DefaultKnnLabelTag, true, &err),
+				NormName: reg.StrParam(params, "distance", "l2", true, &err),
+			}
+			if err != nil {
+				return
+			}
+			if step.K < 1 {
+				return reg.ParameterError("k", errors.New("Must be > 0"))
+			}
+			var ok bool
+			if step.Norm, ok = VectorNorms[step.NormName]; !ok {
+				return reg.ParameterError("distance", fmt.Errorf("Unknown distance '%v', must be one of l1, l2, linf", step.NormName))
+			}
+			if step.Reference, err = LoadKnnReference(file, step.LabelTag); err != nil {
+				return reg.ParameterError("reference", err)
+			}
+			p.Add(step)
+			return
+		},
+		"Label every sample by a majority vote of its k nearest neighbors in a reference data set. The reference samples are loaded from the given file "+
+			"and must carry their label in the label-tag. The label is stored in the same tag of every incoming sample. The distance can be l1, l2 (default) or linf. "+
+			"Only the metrics contained in the reference data are used for computing distances.",
+		reg.RequiredParams("reference"), reg.OptionalParams("k", "label-tag", "distance"))
+}
+
+// KnnReference is a set of labeled samples used by the KnnClassifier. All samples must have the same Fields.
+type KnnReference struct {
+	Fields []string
+	Values [][]float64
+	Labels []string
+}
+
+// LoadKnnReference reads all samples from the given file in any of the supported formats. The label of every sample
+// is read from the given tag.
+func LoadKnnReference(filename string, labelTag string) (*KnnReference, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	reader := bitflow.SampleReader{
+		ParallelSampleHandler: bitflow.ParallelSampleHandler{ParallelParsers: 1, BufferedSamples: 1000},
+	}
+	ref := &knnReferenceLoader{labelTag: labelTag}
+	stream := reader.Open(file, ref)
+	defer stream.Close() // Drop error
+	if _, err = stream.ReadSamples(filename); err != nil {
+		return nil, err
+	}
+	if len(ref.Values) == 0 {
+		return nil, errors.New("File does not contain any reference samples: " + filename)
+	}
+	log.Printf("Loaded %v reference samples with %v metrics from %v", len(ref.Values), len(ref.Fields), filename)
+	return &ref.KnnReference, nil
+}
+
+type knnReferenceLoader struct {
+	KnnReference
+	labelTag string
+}
+
+func (l *knnReferenceLoader) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	return l.Add(sample, header, l.labelTag)
+}
+
+// Add adds the values of the given sample to the reference set, labeled with the value of the given tag.
+func (r *KnnReference) Add(sample *bitflow.Sample, header *bitflow.Header, labelTag string) error {
+	if !sample.HasTag(labelTag) {
+		return fmt.Errorf("Reference sample %v is missing the label tag '%v'", len(r.Values), labelTag)
+	}
+	if r.Fields == nil {
+		r.Fields = header.Fields
+	}
+	if !header.Equals(&bitflow.Header{Fields: r.Fields}) {
+		return fmt.Errorf("All reference samples must have the same metrics, but found %v and %v", r.Fields, header.Fields)
+	}
+	values := make([]float64, len(sample.Values))
+	for i, val := range sample.Values {
+		values[i] = float64(val)
+	}
+	r.Values = append(r.Values, values)
+	r.Labels = append(r.Labels, sample.Tag(labelTag))
+	return nil
+}
+
+// KnnClassifier sets the LabelTag of every sample to the most common label among the K nearest samples in the
+// Reference set. Ties are resolved in favor of the label with the closest neighbor. The distance is computed
+// with the given Norm, only including the metrics that are contained in the Reference. All reference metrics
+// must be present in the incoming samples, additional metrics are ignored.
+// The neighbors are found through a linear scan of the reference set.
+type KnnClassifier struct {
+	bitflow.NoopProcessor
+	Reference *KnnReference
+	K         int
+	LabelTag  string
+	Norm      VectorNorm
+	NormName  string
+
+	checker   bitflow.HeaderChecker
+	indices   []int // Indices of the reference fields in the incoming header
+	diff      []float64
+	neighbors []knnNeighbor
+}
+
+type knnNeighbor struct {
+	distance float64
+	index    int
+}
+
+func (p *KnnClassifier) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		if err := p.updateHeader(header); err != nil {
+			return err
+		}
+	}
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", p, len(sample.Values), len(header.Fields))
+	}
+	sample.SetTag(p.LabelTag, p.classify(sample))
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *KnnClassifier) updateHeader(header *bitflow.Header) error {
+	fieldIndices := make(map[string]int, len(header.Fields))
+	for i, field := range header.Fields {
+		fieldIndices[field] = i
+	}
+	var missing []string
+	p.indices = make([]int, len(p.Reference.Fields))
+	for i, field := range p.Reference.Fields {
+		index, ok := fieldIndices[field]
+		if !ok {
+			missing = append(missing, field)
+		}
+		p.indices[i] = index
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%v: Samples are missing %v metric(s) of the reference data: %v", p, len(missing), strings.Join(missing, ", "))
+	}
+	if ignored := len(header.Fields) - len(p.Reference.Fields); ignored > 0 {
+		log.Warnf("%v: Ignoring %v metric(s) that are not contained in the reference data", p, ignored)
+	}
+	p.diff = make([]float64, len(p.indices))
+	return nil
+}
+
+func (p *KnnClassifier) classify(sample *bitflow.Sample) string {
+	// Keep the K nearest neighbors sorted by their distance
+	neighbors := p.neighbors[:0]
+	for refIndex, refValues := range p.Reference.Values {
+		for i, index := range p.indices {
+			p.diff[i] = float64(sample.Values[index]) - refValues[i]
+		}
+		distance := p.Norm(p.diff)
+		if len(neighbors) == p.K && distance >= neighbors[len(neighbors)-1].distance {
+			continue
+		}
+		if len(neighbors) < p.K {
+			neighbors = append(neighbors, knnNeighbor{})
+		}
+		pos := len(neighbors) - 1
+		for ; pos > 0 && neighbors[pos-1].distance > distance; pos-- {
+			neighbors[pos] = neighbors[pos-1]
+		}
+		neighbors[pos] = knnNeighbor{distance: distance, index: refIndex}
+	}
+	p.neighbors = neighbors
+
+	counts := make(map[string]int, len(neighbors))
+	maxCount := 0
+	for _, neighbor := range neighbors {
+		label := p.Reference.Labels[neighbor.index]
+		counts[label]++
+		if counts[label] > maxCount {
+			maxCount = counts[label]
+		}
+	}
+	// The neighbors are sorted by distance, so ties are resolved in favor of the closest label
+	for _, neighbor := range neighbors {
+		if label := p.Reference.Labels[neighbor.index]; counts[label] == maxCount {
+			return label
+		}
+	}
+	return ""
+}
+
+func (p *KnnClassifier) String() string {
+	return fmt.Sprintf("%v-nearest neighbor classifier (%v distance, %v reference samples, label tag %v)",
+		p.K, p.NormName, len(p.Reference.Values), p.LabelTag)
+}
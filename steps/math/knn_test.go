@@ -0,0 +1,95 @@
+package math
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+// _writeKnnReference writes two classes of points: "low" around (0, 0) and "high" around (10, 10)
+func _writeKnnReference(t *testing.T, file string) {
+	assert := testAssert.New(t)
+	f, err := os.Create(file)
+	assert.NoError(err)
+	defer f.Close() // Drop error
+
+	m := bitflow.CsvMarshaller{}
+	header := &bitflow.Header{Fields: []string{"x", "y"}}
+	assert.NoError(m.WriteHeader(header, true, f))
+	points := []struct {
+		x, y  bitflow.Value
+		label string
+	}{
+		{0, 0, "low"}, {1, 0, "low"}, {0, 1, "low"}, {1, 1, "low"},
+		{10, 10, "high"}, {9, 10, "high"}, {10, 9, "high"}, {9, 9, "high"},
+		{4, 4, "high"}, // Outlier of the high class inside the low area
+	}
+	for _, point := range points {
+		sample := &bitflow.Sample{Values: []bitflow.Value{point.x, point.y}}
+		sample.SetTag("class", point.label)
+		assert.NoError(m.WriteSample(sample, header, true, f))
+	}
+}
+
+func TestKnnClassifier(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-knn-")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+	file := filepath.Join(dir, "reference.csv")
+	_writeKnnReference(t, file)
+
+	ref, err := LoadKnnReference(file, "class")
+	assert.NoError(err)
+	assert.Equal([]string{"x", "y"}, ref.Fields)
+	assert.Len(ref.Values, 9)
+	assert.Equal("high", ref.Labels[8])
+
+	step := &KnnClassifier{Reference: ref, K: 3, LabelTag: "class", Norm: NormL2, NormName: "l2"}
+	out := new(collectingSink)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+
+	// The stream contains an additional metric and a different order of the metrics
+	header := &bitflow.Header{Fields: []string{"y", "other", "x"}}
+	inputs := [][]bitflow.Value{
+		{0.5, 100, 0.5},
+		{8, -100, 11},
+		{3.5, 0, 3.5}, // Next to the outlier, but the majority of the 3 neighbors is low
+		{6, 0, 6},
+	}
+	for _, values := range inputs {
+		assert.NoError(step.Sample(&bitflow.Sample{Values: values}, header))
+	}
+	assert.Len(out.samples, 4)
+	expected := []string{"low", "high", "low", "high"}
+	for i, sample := range out.samples {
+		assert.Equal(expected[i], sample.Tag("class"), "sample %v", i)
+	}
+
+	// With a single neighbor, the outlier determines the label
+	step.K = 1
+	sample := &bitflow.Sample{Values: []bitflow.Value{3.5, 0, 3.5}}
+	assert.NoError(step.Sample(sample, header))
+	assert.Equal("high", sample.Tag("class"))
+
+	// Metrics of the reference data must be present in the stream
+	assert.Error(step.Sample(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"x"}}))
+}
+
+func TestKnnReferenceMissingLabel(t *testing.T) {
+	assert := testAssert.New(t)
+	ref := new(KnnReference)
+	header := &bitflow.Header{Fields: []string{"x"}}
+	assert.Error(ref.Add(&bitflow.Sample{Values: []bitflow.Value{1}}, header, "class"))
+
+	sample := &bitflow.Sample{Values: []bitflow.Value{1}}
+	sample.SetTag("class", "a")
+	assert.NoError(ref.Add(sample, header, "class"))
+	assert.Error(ref.Add(sample, &bitflow.Header{Fields: []string{"y"}}, "class"), "different metrics")
+}
@@ -0,0 +1,330 @@
+package math
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	log "github.com/sirupsen/logrus"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	DefaultMahalanobisMetric         = "mahalanobis"
+	DefaultMahalanobisRegularization = 1e-6
+
+	// Number of times the regularization is increased by a factor of 10, if the covariance matrix is still singular
+	mahalanobisRegularizationAttempts = 8
+)
+
+// MahalanobisModel contains the mean and inverse covariance matrix of a set of training samples.
+type MahalanobisModel struct {
+	Fields            []string
+	Mean              []float64
+	InverseCovariance *mat.Dense
+
+	// Regularization is the value that was added to the diagonal of the covariance matrix before inverting it.
+	Regularization float64
+}
+
+// ComputeModel computes the mean and inverse covariance of the given samples. To handle singular covariance matrices,
+// the given regularization, relative to the average variance of all metrics, is added to the diagonal of the
+// covariance matrix. If the matrix is still not invertible, the regularization is increased in steps of 10.
+func (model *MahalanobisModel) ComputeModel(header *bitflow.Header, samples []*bitflow.Sample, regularization float64) error {
+	if len(samples) < 2 {
+		return fmt.Errorf("Mahalanobis model requires at least 2 samples, got %v", len(samples))
+	}
+	matrix := SamplesToMatrix(samples)
+	_, cols := matrix.Dims()
+	if cols != len(header.Fields) {
+		return fmt.Errorf("Samples have %v values, but header has %v fields", cols, len(header.Fields))
+	}
+	model.Fields = header.Fields
+	model.Mean = make([]float64, cols)
+	for col := range model.Mean {
+		model.Mean[col] = stat.Mean(mat.Col(nil, col, matrix), nil)
+	}
+	cov := mat.NewSymDense(cols, nil)
+	stat.CovarianceMatrix(cov, matrix, nil)
+
+	var trace float64
+	for i := 0; i < cols; i++ {
+		trace += cov.At(i, i)
+	}
+	scale := trace / float64(cols)
+	if scale <= 0 || math.IsNaN(scale) {
+		scale = 1 // All metrics are constant
+	}
+	for attempt := 0; attempt < mahalanobisRegularizationAttempts; attempt++ {
+		regularized := mat.NewSymDense(cols, nil)
+		regularized.CopySym(cov)
+		added := regularization * scale
+		for i := 0; i < cols; i++ {
+			regularized.SetSym(i, i, regularized.At(i, i)+added)
+		}
+		var chol mat.Cholesky
+		if chol.Factorize(regularized) {
+			inverse := mat.NewDense(cols, cols, nil)
+			if err := inverse.Inverse(regularized); err == nil {
+				model.InverseCovariance = inverse
+				model.Regularization = added
+				return nil
+			}
+		}
+		if regularization <= 0 {
+			regularization = DefaultMahalanobisRegularization
+		} else {
+			regularization *= 10
+		}
+	}
+	return errors.New("Covariance matrix is singular, increase the regularization")
+}
+
+// Distance returns the Mahalanobis distance of the given values to the mean of the model. The values must
+// be in the order of the Fields of the model.
+func (model *MahalanobisModel) Distance(values []float64) float64 {
+	var sum float64
+	for i, diffI := range values {
+		diffI -= model.Mean[i]
+		row := model.InverseCovariance.RawRowView(i)
+		for j, valJ := range values {
+			sum += diffI * row[j] * (valJ - model.Mean[j])
+		}
+	}
+	if sum < 0 {
+		sum = 0 // Rounding errors
+	}
+	return math.Sqrt(sum)
+}
+
+// ChiSquareThreshold returns the Mahalanobis distance that is exceeded by samples of the training distribution
+// with the given probability, assuming a multivariate normal distribution.
+func (model *MahalanobisModel) ChiSquareThreshold(probability float64) float64 {
+	return math.Sqrt(distuv.ChiSquared{K: float64(len(model.Mean))}.Quantile(probability))
+}
+
+func (model *MahalanobisModel) WriteModel(writer io.Writer) error {
+	err := gob.NewEncoder(writer).Encode(model)
+	if err != nil {
+		err = fmt.Errorf("Failed to marshal *MahalanobisModel to binary gob: %v", err)
+	}
+	return err
+}
+
+func (model *MahalanobisModel) Load(filename string) (err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewDecoder(file).Decode(model); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (model *MahalanobisModel) String() string {
+	return fmt.Sprintf("Mahalanobis model (%v metrics, regularization %v)", len(model.Fields), model.Regularization)
+}
+
+// MahalanobisDistance appends the Mahalanobis distance of every sample to the Model as a new metric. If Threshold
+// is not NaN, it is interpreted as a probability of the chi-square distribution. Samples with a distance above
+// the corresponding quantile receive the StateTag with the value steps.DefaultAnomalyStateValue. All metrics of the
+// Model must be present in the incoming samples, other metrics are ignored for the distance.
+type MahalanobisDistance struct {
+	bitflow.NoopProcessor
+	Model     *MahalanobisModel
+	Threshold float64
+	Metric    string
+	StateTag  string
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	indices   []int
+	values    []float64
+	distance  float64
+}
+
+func (p *MahalanobisDistance) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		if err := p.updateHeader(header); err != nil {
+			return err
+		}
+	}
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", p, len(sample.Values), len(header.Fields))
+	}
+	p.appendDistance(sample)
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+func (p *MahalanobisDistance) updateHeader(header *bitflow.Header) error {
+	index := header.BuildIndex()
+	var missing []string
+	p.indices = make([]int, len(p.Model.Fields))
+	for i, field := range p.Model.Fields {
+		fieldIndex, ok := index[field]
+		if !ok {
+			missing = append(missing, field)
+		}
+		p.indices[i] = fieldIndex
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%v: Samples are missing %v metric(s) of the model: %v", p, len(missing), strings.Join(missing, ", "))
+	}
+	p.values = make([]float64, len(p.indices))
+	p.outHeader = header.Clone(append(header.Fields[:len(header.Fields):len(header.Fields)], p.Metric))
+	p.distance = math.NaN()
+	if !math.IsNaN(p.Threshold) {
+		p.distance = p.Model.ChiSquareThreshold(p.Threshold)
+	}
+	return nil
+}
+
+func (p *MahalanobisDistance) appendDistance(sample *bitflow.Sample) {
+	for i, index := range p.indices {
+		p.values[i] = float64(sample.Values[index])
+	}
+	distance := p.Model.Distance(p.values)
+	if !math.IsNaN(p.distance) && distance > p.distance {
+		sample.SetTag(p.StateTag, steps.DefaultAnomalyStateValue)
+	}
+	values := sample.Values
+	if !sample.Resize(len(values) + 1) {
+		copy(sample.Values, values)
+	}
+	sample.Values[len(values)] = bitflow.Value(distance)
+}
+
+func (p *MahalanobisDistance) OutputSampleSize(sampleSize int) int {
+	return sampleSize + 1
+}
+
+func (p *MahalanobisDistance) String() string {
+	res := fmt.Sprintf("Mahalanobis distance (metric %v", p.Metric)
+	if !math.IsNaN(p.Threshold) {
+		res += fmt.Sprintf(", tag %v=%v above chi-square probability %v", p.StateTag, steps.DefaultAnomalyStateValue, p.Threshold)
+	}
+	return res + ")"
+}
+
+// ComputeMahalanobisDistance computes a MahalanobisModel of every batch, and appends the distance of every
+// sample of the batch to the model.
+func ComputeMahalanobisDistance(step *MahalanobisDistance, regularization float64) bitflow.BatchProcessingStep {
+	return &bitflow.SimpleBatchProcessingStep{
+		Description:          "Compute " + step.String(),
+		OutputSampleSizeFunc: step.OutputSampleSize,
+		Process: func(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+			step.Model = new(MahalanobisModel)
+			if err := step.Model.ComputeModel(header, samples, regularization); err != nil {
+				return nil, nil, err
+			}
+			if err := step.updateHeader(header); err != nil {
+				return nil, nil, err
+			}
+			for _, sample := range samples {
+				step.appendDistance(sample)
+			}
+			return step.outHeader, samples, nil
+		},
+	}
+}
+
+func StoreMahalanobisModel(filename string, regularization float64) bitflow.BatchProcessingStep {
+	var counter int
+	group := bitflow.NewFileGroup(filename)
+
+	return &bitflow.SimpleBatchProcessingStep{
+		Description: fmt.Sprintf("Compute & store Mahalanobis model to %v", filename),
+		Process: func(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+			var model MahalanobisModel
+			err := model.ComputeModel(header, samples, regularization)
+			if err == nil {
+				log.Println("Computed", &model)
+				var file *os.File
+				file, err = group.OpenNewFile(&counter)
+				if err == nil {
+					log.Println("Storing Mahalanobis model to", file.Name())
+					err = model.WriteModel(file)
+					if err == nil {
+						err = file.Close()
+					}
+				}
+			}
+			return header, samples, err
+		},
+	}
+}
+
+func RegisterMahalanobis(b reg.ProcessorRegistry) {
+	newStep := func(params map[string]string, err *error) *MahalanobisDistance {
+		step := &MahalanobisDistance{
+			Threshold: reg.FloatParam(params, "threshold", math.NaN(), true, err),
+			Metric:    reg.StrParam(params, "metric", DefaultMahalanobisMetric, true, err),
+			StateTag:  reg.StrParam(params, "state-tag", steps.DefaultAnomalyStateTag, true, err),
+		}
+		if *err == nil && !math.IsNaN(step.Threshold) && (step.Threshold <= 0 || step.Threshold >= 1) {
+			*err = reg.ParameterError("threshold", errors.New("Must be a probability between 0 and 1 (exclusive)"))
+		}
+		return step
+	}
+	parseRegularization := func(params map[string]string, err *error) float64 {
+		regularization := reg.FloatParam(params, "regularization", DefaultMahalanobisRegularization, true, err)
+		if *err == nil && regularization < 0 {
+			*err = reg.ParameterError("regularization", errors.New("Must not be negative"))
+		}
+		return regularization
+	}
+	const thresholdDescription = "If a threshold probability is given (e.g. 0.99), samples with a distance above the corresponding quantile of the chi-square distribution " +
+		"are tagged with state-tag=" + steps.DefaultAnomalyStateValue + ". "
+	const regularizationDescription = "Singular covariance matrices are handled by adding the regularization (relative to the average variance) to the diagonal."
+
+	b.RegisterAnalysisParamsErr("mahalanobis",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := newStep(params, &err)
+			regularization := parseRegularization(params, &err)
+			if err == nil {
+				p.Batch(ComputeMahalanobisDistance(step, regularization))
+			}
+			return
+		},
+		"Compute the mean and covariance of a batch of samples and append the Mahalanobis distance of every sample as a new metric. "+thresholdDescription+regularizationDescription,
+		reg.OptionalParams("threshold", "metric", "state-tag", "regularization"), reg.SupportBatch())
+
+	b.RegisterAnalysisParamsErr("mahalanobis_store",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			file := reg.StrParam(params, "file", "", false, &err)
+			regularization := parseRegularization(params, &err)
+			if err == nil {
+				p.Batch(StoreMahalanobisModel(file, regularization))
+			}
+			return
+		},
+		"Compute the mean and inverse covariance matrix of a batch of samples and store it to the given file. "+regularizationDescription,
+		reg.RequiredParams("file"), reg.OptionalParams("regularization"), reg.SupportBatch())
+
+	b.RegisterAnalysisParamsErr("mahalanobis_load",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			file := reg.StrParam(params, "file", "", false, &err)
+			step := newStep(params, &err)
+			if err != nil {
+				return
+			}
+			step.Model = new(MahalanobisModel)
+			if err = step.Model.Load(file); err != nil {
+				return reg.ParameterError("file", err)
+			}
+			p.Add(step)
+			return
+		},
+		"Load a model stored by mahalanobis_store from the given file and append the Mahalanobis distance of every sample as a new metric. "+thresholdDescription,
+		reg.RequiredParams("file"), reg.OptionalParams("threshold", "metric", "state-tag"))
+}
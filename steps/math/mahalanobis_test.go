@@ -0,0 +1,103 @@
+package math
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+// _correlatedSamples returns samples along the diagonal x=y, with a small deviation orthogonal to it
+func _correlatedSamples() []*bitflow.Sample {
+	var samples []*bitflow.Sample
+	for i := -50; i <= 50; i++ {
+		t := float64(i) / 10
+		noise := 0.1
+		if i%2 != 0 {
+			noise = -noise
+		}
+		samples = append(samples, &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(t + noise), bitflow.Value(t - noise)}})
+	}
+	return samples
+}
+
+func TestMahalanobisDistance(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"x", "y"}}
+	model := new(MahalanobisModel)
+	assert.NoError(model.ComputeModel(header, _correlatedSamples(), 0))
+	assert.Equal([]string{"x", "y"}, model.Fields)
+
+	// The point along the correlation is farther away in euclidean distance, but closer in Mahalanobis distance
+	along, across := []float64{3, 3}, []float64{1.5, -1.5}
+	assert.True(NormL2(along) > NormL2(across))
+	assert.True(model.Distance(across) > 10*model.Distance(along))
+
+	step := &MahalanobisDistance{
+		Model:     model,
+		Threshold: 0.99,
+		Metric:    DefaultMahalanobisMetric,
+		StateTag:  steps.DefaultAnomalyStateTag,
+	}
+	out := new(collectingSink)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+
+	// Additional metrics and a different order of the metrics are supported
+	streamHeader := &bitflow.Header{Fields: []string{"other", "y", "x"}}
+	assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{100, 3, 3}}, streamHeader))
+	assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{-100, -1.5, 1.5}}, streamHeader))
+	assert.Len(out.samples, 2)
+	assert.Equal([]string{"other", "y", "x", DefaultMahalanobisMetric}, out.headers[0].Fields)
+	assert.InDelta(model.Distance(along), float64(out.samples[0].Values[3]), 1e-9)
+	assert.InDelta(model.Distance(across), float64(out.samples[1].Values[3]), 1e-9)
+	assert.False(out.samples[0].HasTag(steps.DefaultAnomalyStateTag))
+	assert.Equal(steps.DefaultAnomalyStateValue, out.samples[1].Tag(steps.DefaultAnomalyStateTag))
+
+	assert.Error(step.Sample(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"x"}}), "missing metric")
+}
+
+func TestMahalanobisSingularCovariance(t *testing.T) {
+	assert := testAssert.New(t)
+	// The second metric duplicates the first one and the third metric is constant
+	var samples []*bitflow.Sample
+	for i := 0; i < 10; i++ {
+		samples = append(samples, &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i), bitflow.Value(i), 5}})
+	}
+	header := &bitflow.Header{Fields: []string{"a", "b", "c"}}
+
+	step := &MahalanobisDistance{Threshold: math.NaN(), Metric: DefaultMahalanobisMetric}
+	outHeader, outSamples, err := ComputeMahalanobisDistance(step, DefaultMahalanobisRegularization).ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.True(step.Model.Regularization > 0)
+	assert.Equal([]string{"a", "b", "c", DefaultMahalanobisMetric}, outHeader.Fields)
+	for _, sample := range outSamples {
+		distance := float64(sample.Values[3])
+		assert.False(math.IsNaN(distance) || math.IsInf(distance, 0), "distance %v", distance)
+	}
+	assert.True(step.Model.Distance([]float64{4.5, 4.5, 5}) < 1e-6, "the mean has distance 0")
+	assert.True(step.Model.Distance([]float64{4.5, 4.5, 6}) > step.Model.Distance([]float64{9, 9, 5}), "deviating in the constant metric")
+}
+
+func TestMahalanobisModelStore(t *testing.T) {
+	assert := testAssert.New(t)
+	file, err := ioutil.TempFile("", "bitflow-mahalanobis-")
+	assert.NoError(err)
+	defer os.Remove(file.Name()) // Drop error
+
+	model := new(MahalanobisModel)
+	assert.NoError(model.ComputeModel(&bitflow.Header{Fields: []string{"x", "y"}}, _correlatedSamples(), 0))
+	assert.NoError(model.WriteModel(file))
+	assert.NoError(file.Close())
+
+	loaded := new(MahalanobisModel)
+	assert.NoError(loaded.Load(file.Name()))
+	assert.Equal(model.Fields, loaded.Fields)
+	assert.Equal(model.Mean, loaded.Mean)
+	assert.InDelta(model.Distance([]float64{1, 2}), loaded.Distance([]float64{1, 2}), 1e-12)
+}
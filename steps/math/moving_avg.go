@@ -0,0 +1,204 @@
+package math
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const MovingAverageSuffix = "_avg"
+
+func RegisterMovingAverage(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("moving_avg",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &MovingAverageProcessor{
+				WindowSize: reg.IntParam(params, "window", 0, false, &err),
+				Replace:    reg.BoolParam(params, "replace", false, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			if step.WindowSize < 1 {
+				return reg.ParameterError("window", errors.New("Must be > 0"))
+			}
+			p.Add(step)
+			return
+		},
+		"Compute the moving average of every metric over the given number of samples. "+
+			"The averages are appended as new metrics with the suffix '"+MovingAverageSuffix+"', or replace the original values if replace=true. "+
+			"NaN and infinite values are not included in the averages. The windows are reset when the header changes.",
+		reg.RequiredParams("window"), reg.OptionalParams("replace"))
+}
+
+// MovingAverageProcessor computes the average value of every metric over the last WindowSize samples.
+// If Replace is true, the original values are replaced with the averages. Otherwise, the averages are appended
+// as new metrics named after the original metrics with MovingAverageSuffix.
+// NaN and infinite values are skipped: they occupy a slot in the window, but are not part of the average.
+// If a window contains no valid values, the average is NaN. All windows are reset when the header changes.
+// The windows can be persisted through the bitflow.Checkpointable interface.
+type MovingAverageProcessor struct {
+	bitflow.NoopProcessor
+	WindowSize int
+	Replace    bool
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	windows   []movingAverageWindow
+
+	// Window contents restored by LoadState, used if the next header has the same fields
+	restoredFields []string
+	restoredValues [][]float64
+}
+
+func (p *MovingAverageProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		p.windows = make([]movingAverageWindow, len(header.Fields))
+		restore := p.restoredValues != nil && golib.EqualStrings(p.restoredFields, header.Fields)
+		for i := range p.windows {
+			p.windows[i].values = make([]float64, p.WindowSize)
+			if restore {
+				for _, value := range p.restoredValues[i] {
+					p.windows[i].add(value)
+				}
+			}
+		}
+		p.restoredFields, p.restoredValues = nil, nil
+		if p.Replace {
+			p.outHeader = header
+		} else {
+			fields := make([]string, len(header.Fields), len(header.Fields)*2)
+			copy(fields, header.Fields)
+			for _, field := range header.Fields {
+				fields = append(fields, field+MovingAverageSuffix)
+			}
+			p.outHeader = header.Clone(fields)
+		}
+	}
+	if len(sample.Values) != len(p.windows) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", p, len(sample.Values), len(p.windows))
+	}
+
+	values := sample.Values
+	if !p.Replace && !sample.Resize(len(values)*2) {
+		copy(sample.Values, values)
+	}
+	for i, value := range values {
+		avg := p.windows[i].add(float64(value))
+		if p.Replace {
+			sample.Values[i] = bitflow.Value(avg)
+		} else {
+			sample.Values[len(values)+i] = bitflow.Value(avg)
+		}
+	}
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+type movingAverageState struct {
+	Fields []string
+	Values [][]float64 // The contents of every window, starting with the oldest value
+}
+
+// SaveState implements the bitflow.Checkpointable interface by storing the contents of all windows.
+func (p *MovingAverageProcessor) SaveState(w io.Writer) error {
+	var state movingAverageState
+	if p.checker.LastHeader != nil {
+		state.Fields = p.checker.LastHeader.Fields
+		state.Values = make([][]float64, len(p.windows))
+		for i, window := range p.windows {
+			state.Values[i] = window.contents()
+		}
+	}
+	return gob.NewEncoder(w).Encode(state)
+}
+
+// LoadState implements the bitflow.Checkpointable interface. The restored windows are only used, if the first
+// received header has the same fields as the header of the stored windows. If the WindowSize was decreased,
+// only the newest values are restored.
+func (p *MovingAverageProcessor) LoadState(r io.Reader) error {
+	var state movingAverageState
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return err
+	}
+	if len(state.Values) != len(state.Fields) {
+		return fmt.Errorf("Inconsistent state: %v windows, but %v fields", len(state.Values), len(state.Fields))
+	}
+	p.restoredFields, p.restoredValues = state.Fields, state.Values
+	p.checker = bitflow.HeaderChecker{} // Make sure the windows are assigned to the header fields again
+	return nil
+}
+
+func (p *MovingAverageProcessor) OutputSampleSize(sampleSize int) int {
+	if p.Replace {
+		return sampleSize
+	}
+	return sampleSize * 2
+}
+
+func (p *MovingAverageProcessor) String() string {
+	res := fmt.Sprintf("Moving average over %v samples", p.WindowSize)
+	if p.Replace {
+		res += " (replace values)"
+	}
+	return res
+}
+
+// movingAverageWindow is a ring buffer that maintains the sum and count of the valid values it contains
+type movingAverageWindow struct {
+	values []float64
+	next   int
+	filled bool
+	sum    float64
+	count  int
+}
+
+func (w *movingAverageWindow) add(value float64) float64 {
+	if w.filled {
+		if old := w.values[w.next]; isValidAverageValue(old) {
+			w.sum -= old
+			w.count--
+		}
+	}
+	w.values[w.next] = value
+	if isValidAverageValue(value) {
+		w.sum += value
+		w.count++
+	}
+	w.next++
+	if w.next == len(w.values) {
+		w.next = 0
+		w.filled = true
+		w.recomputeSum()
+	}
+	if w.count == 0 {
+		return math.NaN()
+	}
+	return w.sum / float64(w.count)
+}
+
+// contents returns the values in the window, starting with the oldest value
+func (w *movingAverageWindow) contents() []float64 {
+	if !w.filled {
+		return append([]float64(nil), w.values[:w.next]...)
+	}
+	return append(append([]float64(nil), w.values[w.next:]...), w.values[:w.next]...)
+}
+
+// recomputeSum prevents the accumulation of rounding errors from repeatedly adding and subtracting values
+func (w *movingAverageWindow) recomputeSum() {
+	w.sum = 0
+	for _, value := range w.values {
+		if isValidAverageValue(value) {
+			w.sum += value
+		}
+	}
+}
+
+func isValidAverageValue(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0)
+}
@@ -0,0 +1,124 @@
+package math
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestMovingAverage(t *testing.T) {
+	assert := testAssert.New(t)
+	p := &MovingAverageProcessor{WindowSize: 3}
+	out := new(collectingSink)
+	p.SetSink(out)
+	p.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	nan := bitflow.Value(math.NaN())
+	inf := bitflow.Value(math.Inf(1))
+	inputs := [][]bitflow.Value{
+		{1, nan},
+		{2, 4},
+		{3, inf},
+		{4, 6},
+		{nan, nan},
+		{6, nan},
+		{7, nan},
+	}
+	for _, values := range inputs {
+		assert.NoError(p.Sample(&bitflow.Sample{Values: values}, header))
+	}
+	// Header change resets the windows
+	assert.NoError(p.Sample(&bitflow.Sample{Values: []bitflow.Value{10}}, &bitflow.Header{Fields: []string{"a"}}))
+	p.Close()
+
+	assert.Equal([]string{"a", "b", "a_avg", "b_avg"}, out.headers[0].Fields)
+	assert.Equal([]string{"a", "a_avg"}, out.headers[len(out.headers)-1].Fields)
+	expected := [][]bitflow.Value{
+		{1, nan, 1, nan},
+		{2, 4, 1.5, 4},
+		{3, inf, 2, 4},
+		{4, 6, 3, 5},
+		{nan, nan, 3.5, 6},
+		{6, nan, 5, 6},
+		{7, nan, 6.5, nan},
+		{10, 10},
+	}
+	assert.Len(out.samples, len(expected))
+	for i, sample := range out.samples {
+		assert.Equal(len(expected[i]), len(sample.Values), "sample %v", i)
+		for j, value := range expected[i] {
+			if math.IsNaN(float64(value)) {
+				assert.True(math.IsNaN(float64(sample.Values[j])), "sample %v, value %v: %v", i, j, sample.Values[j])
+			} else {
+				assert.Equal(value, sample.Values[j], "sample %v, value %v", i, j)
+			}
+		}
+	}
+}
+
+func TestMovingAverageReplace(t *testing.T) {
+	assert := testAssert.New(t)
+	p := &MovingAverageProcessor{WindowSize: 2, Replace: true}
+	out := new(collectingSink)
+	p.SetSink(out)
+	p.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a"}}
+	for _, value := range []bitflow.Value{2, 4, 8, bitflow.Value(math.Inf(-1)), 1} {
+		assert.NoError(p.Sample(&bitflow.Sample{Values: []bitflow.Value{value}}, header))
+	}
+	assert.Error(p.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, header))
+	p.Close()
+
+	assert.True(out.headers[0] == header)
+	var results []bitflow.Value
+	for _, sample := range out.samples {
+		results = append(results, sample.Values...)
+	}
+	assert.Equal([]bitflow.Value{2, 3, 6, 8, 1}, results)
+}
+
+func TestMovingAverageCheckpoint(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"a"}}
+	run := func(p *MovingAverageProcessor, values ...bitflow.Value) []bitflow.Value {
+		out := new(collectingSink)
+		p.SetSink(out)
+		p.Start(new(sync.WaitGroup))
+		for _, value := range values {
+			assert.NoError(p.Sample(&bitflow.Sample{Values: []bitflow.Value{value}}, header))
+		}
+		p.Close()
+		var averages []bitflow.Value
+		for _, sample := range out.samples {
+			averages = append(averages, sample.Values[1])
+		}
+		return averages
+	}
+
+	var state bytes.Buffer
+	p := &MovingAverageProcessor{WindowSize: 3}
+	assert.Equal([]bitflow.Value{1, 1.5, 2, 3}, run(p, 1, 2, 3, 4))
+	assert.NoError(p.SaveState(&state))
+
+	// The restored window contains 2, 3, 4
+	p = &MovingAverageProcessor{WindowSize: 3}
+	assert.NoError(p.LoadState(bytes.NewReader(state.Bytes())))
+	assert.Equal([]bitflow.Value{4, 5}, run(p, 5, 6))
+
+	// A smaller window keeps the newest values
+	p = &MovingAverageProcessor{WindowSize: 2}
+	assert.NoError(p.LoadState(bytes.NewReader(state.Bytes())))
+	assert.Equal([]bitflow.Value{4.5}, run(p, 5))
+
+	// The restored windows are not used for a different header
+	p = &MovingAverageProcessor{WindowSize: 3}
+	assert.NoError(p.LoadState(bytes.NewReader(state.Bytes())))
+	header = &bitflow.Header{Fields: []string{"b"}}
+	assert.Equal([]bitflow.Value{5}, run(p, 5))
+}
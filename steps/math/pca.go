@@ -39,6 +39,7 @@ type PCAModel struct {
 	Vectors            *mat.Dense
 	RawVariances       []float64
 	ContainedVariances []float64
+	Mean               []float64 // Mean of every column of the training data. Might be nil for models stored by older versions.
 }
 
 func (model *PCAModel) ComputeModel(samples []*bitflow.Sample) error {
@@ -50,6 +51,11 @@ func (model *PCAModel) ComputeModel(samples []*bitflow.Sample) error {
 	}
 	pc.VarsTo(model.RawVariances)
 	pc.VectorsTo(model.Vectors)
+	_, cols := matrix.Dims()
+	model.Mean = make([]float64, cols)
+	for col := range model.Mean {
+		model.Mean[col] = stat.Mean(mat.Col(nil, col, matrix), nil)
+	}
 
 	model.ContainedVariances = make([]float64, len(model.RawVariances))
 	var sum float64
@@ -108,6 +114,31 @@ func (model *PCAModel) WriteModel(writer io.Writer) error {
 	return err
 }
 
+// Save stores the model in the given file, in the same binary gob format as WriteModel.
+func (model *PCAModel) Save(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = model.WriteModel(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LoadPCAModel loads a model stored by PCAModel.Save, PCAModel.WriteModel or the pca_store step.
+func LoadPCAModel(filename string) (*PCAModel, error) {
+	model := new(PCAModel)
+	if err := model.Load(filename); err != nil {
+		return nil, fmt.Errorf("Failed to load PCA model from %v: %v", filename, err)
+	}
+	if model.Vectors == nil || len(model.ContainedVariances) == 0 {
+		return nil, fmt.Errorf("The PCA model in %v is empty", filename)
+	}
+	return model, nil
+}
+
 func (model *PCAModel) Load(filename string) (err error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -164,6 +195,33 @@ func (model *PCAProjection) Vector(vec []float64) []float64 {
 	return matrix.RawRowView(0)
 }
 
+// Reconstruct projects the given vector of component values back into the original space by multiplying it
+// with the transpose of the projection vectors.
+func (model *PCAProjection) Reconstruct(projected []float64) []float64 {
+	var result mat.Dense
+	result.Mul(mat.NewDense(1, len(projected), projected), model.Vectors.T())
+	return result.RawRowView(0)
+}
+
+// ReconstructionError projects the given vector into the principal components and back, and returns the euclidean
+// distance between the original and the reconstructed vector. If the model contains the mean of the training data,
+// the vector is centered before the projection.
+func (model *PCAProjection) ReconstructionError(vec []float64) float64 {
+	centered := make([]float64, len(vec))
+	copy(centered, vec)
+	if mean := model.Model.Mean; len(mean) == len(vec) {
+		for i := range centered {
+			centered[i] -= mean[i]
+		}
+	}
+	reconstructed := model.Reconstruct(model.Vector(centered))
+	diff := make([]float64, len(vec))
+	for i := range diff {
+		diff[i] = centered[i] - reconstructed[i]
+	}
+	return NormL2(diff)
+}
+
 func (model *PCAProjection) Sample(sample *bitflow.Sample) (result *bitflow.Sample) {
 	values := model.Vector(steps.SampleToVector(sample))
 	result = new(bitflow.Sample)
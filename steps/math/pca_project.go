@@ -0,0 +1,90 @@
+package math
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+)
+
+func RegisterPCAProjection(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("pca_project",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			file := reg.StrParam(params, "model", "", false, &err)
+			components := reg.IntParam(params, "components", 0, true, &err)
+			if err != nil {
+				return
+			}
+			model, err := LoadPCAModel(file)
+			if err != nil {
+				return reg.ParameterError("model", err)
+			}
+			if total := len(model.ContainedVariances); components < 0 || components > total {
+				return reg.ParameterError("components", fmt.Errorf("Must not be negative or exceed the number of components in the model (%v)", total))
+			}
+			p.Add(&PCAProjectionProcessor{Model: model, Components: components})
+			return
+		},
+		"Load a PCA model from the given file (see pca_store) and project every sample into the given number of principal components, without re-fitting the model. "+
+			fmt.Sprintf("By default, the number of components is chosen to contain %v of the variance. The samples are centered with the mean of the training data, if the model contains it.", DefaultContainedVariance),
+		reg.RequiredParams("model"), reg.OptionalParams("components"))
+}
+
+// PCAProjectionProcessor projects every sample into the first Components principal components of a previously computed
+// Model, replacing the values of the sample with the component values named component0, component1, ... If Components is
+// not > 0, the number of components containing DefaultContainedVariance of the variance is used. If the Model contains the mean
+// of the training data, the values are centered before the projection. The header must have the same number of fields as the model.
+type PCAProjectionProcessor struct {
+	bitflow.NoopProcessor
+	Model      *PCAModel
+	Components int
+
+	checker    bitflow.HeaderChecker
+	outHeader  *bitflow.Header
+	projection *PCAProjection
+}
+
+func (p *PCAProjectionProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		if len(header.Fields) != len(p.Model.ContainedVariances) {
+			return fmt.Errorf("%v: PCA model contains %v columns, but samples have %v", p, len(p.Model.ContainedVariances), len(header.Fields))
+		}
+		components := p.numComponents()
+		p.projection = p.Model.Project(components)
+		outFields := make([]string, components)
+		for i := range outFields {
+			outFields[i] = "component" + strconv.Itoa(i)
+		}
+		p.outHeader = header.Clone(outFields)
+	}
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", p, len(sample.Values), len(header.Fields))
+	}
+
+	values := steps.SampleToVector(sample)
+	if mean := p.Model.Mean; len(mean) == len(values) {
+		for i := range values {
+			values[i] -= mean[i]
+		}
+	}
+	steps.FillSample(sample, p.projection.Vector(values))
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+func (p *PCAProjectionProcessor) numComponents() int {
+	if p.Components > 0 {
+		return p.Components
+	}
+	components, _ := p.Model.ComponentsContainingVariance(DefaultContainedVariance)
+	return components
+}
+
+func (p *PCAProjectionProcessor) OutputSampleSize(int) int {
+	return p.numComponents()
+}
+
+func (p *PCAProjectionProcessor) String() string {
+	return fmt.Sprintf("Project into %v PCA components", p.numComponents())
+}
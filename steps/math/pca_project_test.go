@@ -0,0 +1,57 @@
+package math
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestPCAProjectionProcessor(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-pca")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+
+	// The components are the y, x and z axes, the training data is centered around (1, 1, 5)
+	model := &PCAModel{
+		Vectors:            mat.NewDense(3, 3, []float64{0, 1, 0, 1, 0, 0, 0, 0, 1}),
+		RawVariances:       []float64{4, 2, 0.01},
+		ContainedVariances: []float64{4 / 6.01, 2 / 6.01, 0.01 / 6.01},
+		Mean:               []float64{1, 1, 5},
+	}
+	file := filepath.Join(dir, "model")
+	assert.NoError(model.Save(file))
+	loaded, err := LoadPCAModel(file)
+	assert.NoError(err)
+	assert.Equal(model.ContainedVariances, loaded.ContainedVariances)
+	assert.Equal(model.Mean, loaded.Mean)
+	assert.True(mat.Equal(model.Vectors, loaded.Vectors))
+	_, err = LoadPCAModel(filepath.Join(dir, "missing"))
+	assert.Error(err)
+
+	step := &PCAProjectionProcessor{Model: loaded, Components: 2}
+	assert.Equal(2, step.OutputSampleSize(3))
+	out := new(collectingSink)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"x", "y", "z"}}
+	assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{3, -2, 5}}, header))
+	assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{0, 4, 7}}, header))
+	assert.Error(step.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, &bitflow.Header{Fields: []string{"x", "y"}}))
+
+	assert.Len(out.samples, 2)
+	assert.Equal([]string{"component0", "component1"}, out.headers[0].Fields)
+	assert.Equal([]bitflow.Value{-3, 2}, out.samples[0].Values)
+	assert.Equal([]bitflow.Value{3, -1}, out.samples[1].Values)
+
+	// By default, the components containing DefaultContainedVariance are used, which are the first two
+	step = &PCAProjectionProcessor{Model: loaded}
+	assert.Equal(2, step.OutputSampleSize(3))
+}
@@ -0,0 +1,91 @@
+package math
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/bitflow-stream/go-bitflow/steps"
+)
+
+const DefaultReconstructionErrorMetric = "reconstruction_error"
+
+func RegisterPCAReconstructionError(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("pca_reconstruction_error",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			file := reg.StrParam(params, "file", "", false, &err)
+			step := &PCAReconstructionError{
+				Components: reg.IntParam(params, "components", 0, false, &err),
+				Threshold:  reg.FloatParam(params, "threshold", math.NaN(), true, &err),
+				Metric:     reg.StrParam(params, "metric", DefaultReconstructionErrorMetric, true, &err),
+				StateTag:   reg.StrParam(params, "state-tag", steps.DefaultAnomalyStateTag, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			step.Model = new(PCAModel)
+			if err = step.Model.Load(file); err != nil {
+				return reg.ParameterError("file", err)
+			}
+			if total := len(step.Model.ContainedVariances); step.Components <= 0 || step.Components > total {
+				return reg.ParameterError("components", fmt.Errorf("Must be between 1 and the number of components in the model (%v)", total))
+			}
+			p.Add(step)
+			return
+		},
+		"Load a PCA model from the given file, project every sample into the given number of principal components and back, and append the distance between "+
+			"the original and the reconstructed values as a new metric. If a threshold is given, samples with a larger reconstruction error are tagged with "+
+			"state-tag="+steps.DefaultAnomalyStateValue+".",
+		reg.RequiredParams("file", "components"), reg.OptionalParams("threshold", "metric", "state-tag"))
+}
+
+// PCAReconstructionError appends the reconstruction error of every sample as a new metric. The reconstruction error
+// is the distance between the sample values and their projection into the first Components principal components
+// of the Model, projected back into the original space. If Threshold is not NaN, samples with a larger reconstruction
+// error receive the StateTag with the value steps.DefaultAnomalyStateValue.
+type PCAReconstructionError struct {
+	bitflow.NoopProcessor
+	Model      *PCAModel
+	Components int
+	Threshold  float64
+	Metric     string
+	StateTag   string
+
+	checker    bitflow.HeaderChecker
+	outHeader  *bitflow.Header
+	projection *PCAProjection
+}
+
+func (p *PCAReconstructionError) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		if len(header.Fields) != len(p.Model.ContainedVariances) {
+			return fmt.Errorf("%v: PCA model contains %v columns, but samples have %v", p, len(p.Model.ContainedVariances), len(header.Fields))
+		}
+		p.projection = p.Model.Project(p.Components)
+		p.outHeader = header.Clone(append(header.Fields[:len(header.Fields):len(header.Fields)], p.Metric))
+	}
+	reconstructionError := p.projection.ReconstructionError(steps.SampleToVector(sample))
+	if !math.IsNaN(p.Threshold) && reconstructionError > p.Threshold {
+		sample.SetTag(p.StateTag, steps.DefaultAnomalyStateValue)
+	}
+
+	values := sample.Values
+	if !sample.Resize(len(values) + 1) {
+		copy(sample.Values, values)
+	}
+	sample.Values[len(values)] = bitflow.Value(reconstructionError)
+	return p.NoopProcessor.Sample(sample, p.outHeader)
+}
+
+func (p *PCAReconstructionError) OutputSampleSize(sampleSize int) int {
+	return sampleSize + 1
+}
+
+func (p *PCAReconstructionError) String() string {
+	res := fmt.Sprintf("PCA reconstruction error with %v components (metric %v", p.Components, p.Metric)
+	if !math.IsNaN(p.Threshold) {
+		res += fmt.Sprintf(", tag %v=%v above %v", p.StateTag, steps.DefaultAnomalyStateValue, p.Threshold)
+	}
+	return res + ")"
+}
@@ -0,0 +1,69 @@
+package math
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/steps"
+	testAssert "github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestPCAReconstructionError(t *testing.T) {
+	assert := testAssert.New(t)
+
+	// The training data is centered around (1, 1, 5) and only varies in the x/y plane
+	model := &PCAModel{
+		Vectors:            mat.NewDense(3, 3, []float64{1, 0, 0, 0, 1, 0, 0, 0, 1}),
+		RawVariances:       []float64{4, 2, 0},
+		ContainedVariances: []float64{4.0 / 6, 2.0 / 6, 0},
+		Mean:               []float64{1, 1, 5},
+	}
+	step := &PCAReconstructionError{
+		Model:      model,
+		Components: 2,
+		Threshold:  1,
+		Metric:     DefaultReconstructionErrorMetric,
+		StateTag:   steps.DefaultAnomalyStateTag,
+	}
+	out := new(collectingSink)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"x", "y", "z"}}
+	inputs := [][]bitflow.Value{
+		{3, -2, 5},
+		{0, 4, 5.5},
+		{2, 2, 15}, // Outlier
+		{-1, 0, 5},
+	}
+	for _, values := range inputs {
+		assert.NoError(step.Sample(&bitflow.Sample{Values: values}, header))
+	}
+
+	assert.Len(out.samples, 4)
+	assert.Equal([]string{"x", "y", "z", DefaultReconstructionErrorMetric}, out.headers[0].Fields)
+	expected := []float64{0, 0.5, 10, 0}
+	for i, sample := range out.samples {
+		assert.Len(sample.Values, 4)
+		assert.InDelta(expected[i], float64(sample.Values[3]), 1e-9)
+		assert.Equal(inputs[i][:3], sample.Values[:3])
+	}
+	assert.False(out.samples[1].HasTag(steps.DefaultAnomalyStateTag))
+	assert.Equal(steps.DefaultAnomalyStateValue, out.samples[2].Tag(steps.DefaultAnomalyStateTag))
+}
+
+func TestPCAReconstruct(t *testing.T) {
+	assert := testAssert.New(t)
+	// Rotated 2D basis, projecting onto the first component and back
+	s := math.Sqrt(0.5)
+	model := &PCAModel{
+		Vectors:            mat.NewDense(2, 2, []float64{s, -s, s, s}),
+		ContainedVariances: []float64{0.9, 0.1},
+	}
+	projection := model.Project(1)
+	assert.InDeltaSlice([]float64{2, 2}, projection.Reconstruct(projection.Vector([]float64{2, 2})), 1e-9)
+	assert.InDelta(math.Sqrt(0.5), projection.ReconstructionError([]float64{2, 1}), 1e-9)
+}
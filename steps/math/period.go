@@ -0,0 +1,214 @@
+package math
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PeriodDetectionFFT             = "fft"
+	PeriodDetectionAutocorrelation = "autocorr"
+
+	DefaultPeriodTag       = "period"
+	DefaultPeriodThreshold = 0.3
+
+	// PeriodNone is the tag value for batches without a detectable period
+	PeriodNone = "none"
+
+	// PeriodTimeTagSuffix is appended to the period tag to form the tag containing the period as a duration
+	PeriodTimeTagSuffix = "-time"
+)
+
+func RegisterPeriodDetection(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("detect_period",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			detector := &PeriodDetector{
+				Metric:    reg.StrParam(params, "metric", "", false, &err),
+				Method:    reg.StrParam(params, "method", PeriodDetectionFFT, true, &err),
+				Tag:       reg.StrParam(params, "tag", DefaultPeriodTag, true, &err),
+				Threshold: reg.FloatParam(params, "threshold", DefaultPeriodThreshold, true, &err),
+			}
+			if err == nil && detector.Method != PeriodDetectionFFT && detector.Method != PeriodDetectionAutocorrelation {
+				err = reg.ParameterError("method", fmt.Errorf("Must be '%v' or '%v'", PeriodDetectionFFT, PeriodDetectionAutocorrelation))
+			}
+			if err == nil && detector.Tag == "" {
+				err = reg.ParameterError("tag", errors.New("Must not be empty"))
+			}
+			if err == nil {
+				p.Batch(detector)
+			}
+			return
+		},
+		"Detect the dominant period of the given metric within the batch, using an FFT (method=fft, default) or the autocorrelation (method=autocorr). "+
+			"All samples of the batch are tagged with the period as number of samples (tag '"+DefaultPeriodTag+"' by default, usable as period of the decompose step), "+
+			"and with the period as duration, based on the average distance of the sample timestamps (tag name with the suffix '"+PeriodTimeTagSuffix+"'). "+
+			"If no period is detected, the tag is set to '"+PeriodNone+"'. The threshold (0..1) defines the minimum strength of the period, "+
+			"see the documentation of the PeriodDetector type. The batch must contain at least two periods.",
+		reg.RequiredParams("metric"), reg.OptionalParams("method", "tag", "threshold"), reg.SupportBatch())
+}
+
+// PeriodDetector detects the dominant period of one metric in a batch of evenly spaced samples and tags all samples
+// with the result. The period is given as number of samples in the tag Tag, and as duration in the tag Tag + PeriodTimeTagSuffix.
+// The duration is computed from the average distance of the sample timestamps and is omitted if the timestamps do not increase.
+// If no period is detected, the Tag is set to PeriodNone. A period is only detected, if it occurs at least twice in the batch.
+//
+// Two methods are supported. PeriodDetectionFFT computes the power spectrum of the metric (filled with zeros to the next power of two)
+// and uses the frequency with the highest power. The period is rejected, if that frequency contains less than the
+// Threshold fraction of the total power. PeriodDetectionAutocorrelation computes the autocorrelation of the metric and
+// uses the lag of the highest local maximum. The period is rejected, if the autocorrelation at that lag is below Threshold.
+type PeriodDetector struct {
+	Metric    string
+	Method    string
+	Tag       string
+	Threshold float64
+}
+
+func (d *PeriodDetector) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	index := -1
+	for i, field := range header.Fields {
+		if field == d.Metric {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, nil, fmt.Errorf("%v: Metric %v not found in header", d, d.Metric)
+	}
+	values := make([]float64, len(samples))
+	for i, sample := range samples {
+		values[i] = float64(sample.Values[index])
+	}
+
+	var period int
+	var err error
+	if d.Method == PeriodDetectionAutocorrelation {
+		period = d.detectAutocorrelation(values)
+	} else if period, err = d.detectFFT(values); err != nil {
+		return nil, nil, err
+	}
+	d.tagSamples(samples, period)
+	return header, samples, nil
+}
+
+func (d *PeriodDetector) tagSamples(samples []*bitflow.Sample, period int) {
+	periodStr, periodTime := PeriodNone, PeriodNone
+	if period > 0 {
+		periodStr, periodTime = strconv.Itoa(period), ""
+		if len(samples) > 1 {
+			interval := samples[len(samples)-1].Time.Sub(samples[0].Time) / time.Duration(len(samples)-1)
+			if interval > 0 {
+				periodTime = (time.Duration(period) * interval).String()
+			}
+		}
+	}
+	log.Debugf("%v: Detected period %v in batch of %v samples", d, periodStr, len(samples))
+	for _, sample := range samples {
+		sample.SetTag(d.Tag, periodStr)
+		if periodTime != "" {
+			sample.SetTag(d.Tag+PeriodTimeTagSuffix, periodTime)
+		}
+	}
+}
+
+// centered returns the values minus their mean, and false if all values are equal or not valid numbers.
+func (d *PeriodDetector) centered(values []float64) ([]float64, bool) {
+	var sum float64
+	for _, val := range values {
+		sum += val
+	}
+	mean := sum / float64(len(values))
+	res := make([]float64, len(values))
+	nonZero := false
+	for i, val := range values {
+		res[i] = val - mean
+		if math.IsNaN(res[i]) || math.IsInf(res[i], 0) {
+			return nil, false
+		}
+		nonZero = nonZero || res[i] != 0
+	}
+	return res, nonZero
+}
+
+func (d *PeriodDetector) detectFFT(values []float64) (int, error) {
+	centered, ok := d.centered(values)
+	if !ok {
+		return 0, nil
+	}
+	// Fill up with zeros to the next power of two
+	size := 1
+	for size < len(values) {
+		size *= 2
+	}
+	f, err := getFft(size)
+	if err != nil {
+		return 0, err
+	}
+	input := make([]complex128, f.N)
+	for i, val := range centered {
+		input[i] = complex(val, 0)
+	}
+	spectrum := f.Transform(input)
+
+	var total, maxPower float64
+	maxIndex := 0
+	for k := 1; k <= f.N/2; k++ {
+		power := cmplx.Abs(spectrum[k])
+		power *= power
+		total += power
+		if power > maxPower {
+			maxPower, maxIndex = power, k
+		}
+	}
+	if maxIndex == 0 || total == 0 || maxPower/total < d.Threshold {
+		return 0, nil
+	}
+	period := int(math.Round(float64(f.N) / float64(maxIndex)))
+	if period < 2 || period > len(values)/2 {
+		return 0, nil
+	}
+	return period, nil
+}
+
+func (d *PeriodDetector) detectAutocorrelation(values []float64) int {
+	centered, ok := d.centered(values)
+	if !ok {
+		return 0
+	}
+	var variance float64
+	for _, val := range centered {
+		variance += val * val
+	}
+	maxLag := len(values) / 2
+	corr := make([]float64, maxLag+2)
+	for lag := range corr {
+		var sum float64
+		for i := 0; i+lag < len(centered); i++ {
+			sum += centered[i] * centered[i+lag]
+		}
+		corr[lag] = sum / variance
+	}
+
+	period := 0
+	for lag := 2; lag <= maxLag; lag++ {
+		isPeak := corr[lag] > corr[lag-1] && corr[lag] >= corr[lag+1]
+		if isPeak && corr[lag] >= d.Threshold && (period == 0 || corr[lag] > corr[period]) {
+			period = lag
+		}
+	}
+	return period
+}
+
+func (d *PeriodDetector) String() string {
+	return fmt.Sprintf("Detect period of %v (method: %v, threshold: %v, tag: %v)", d.Metric, d.Method, d.Threshold, d.Tag)
+}
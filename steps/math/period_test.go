@@ -0,0 +1,57 @@
+package math
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func makePeriodTestSamples(num int, value func(i int) float64) []*bitflow.Sample {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := make([]*bitflow.Sample, num)
+	for i := range samples {
+		samples[i] = &bitflow.Sample{
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Values: []bitflow.Value{1, bitflow.Value(value(i))},
+		}
+	}
+	return samples
+}
+
+func TestPeriodDetection(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"other", "val"}}
+	periodic := func(period int) func(i int) float64 {
+		return func(i int) float64 {
+			x := 2 * math.Pi * float64(i) / float64(period)
+			return 5 + 3*math.Sin(x) + 0.5*math.Sin(2*x)
+		}
+	}
+
+	for _, method := range []string{PeriodDetectionFFT, PeriodDetectionAutocorrelation} {
+		step := &PeriodDetector{Metric: "val", Method: method, Tag: DefaultPeriodTag, Threshold: DefaultPeriodThreshold}
+		check := func(samples []*bitflow.Sample, expectedPeriod, expectedTime string) {
+			outHeader, outSamples, err := step.ProcessBatch(header, samples)
+			assert.NoError(err)
+			assert.Equal(header, outHeader)
+			assert.Len(outSamples, len(samples))
+			for i, sample := range outSamples {
+				assert.Equal(expectedPeriod, sample.Tag(DefaultPeriodTag), "method %v, sample %v", method, i)
+				assert.Equal(expectedTime, sample.Tag(DefaultPeriodTag+PeriodTimeTagSuffix), "method %v, sample %v", method, i)
+			}
+		}
+
+		check(makePeriodTestSamples(128, periodic(16)), "16", "16s")
+		check(makePeriodTestSamples(120, periodic(10)), "10", "10s")
+
+		// Aperiodic signals
+		check(makePeriodTestSamples(128, func(i int) float64 { return float64(i) }), PeriodNone, PeriodNone)
+		check(makePeriodTestSamples(128, func(i int) float64 { return 3 }), PeriodNone, PeriodNone)
+	}
+
+	_, _, err := (&PeriodDetector{Metric: "missing"}).ProcessBatch(header, makePeriodTestSamples(10, func(i int) float64 { return 0 }))
+	assert.Error(err)
+}
@@ -0,0 +1,77 @@
+package math
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterRowStandardization(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("row_standardize",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			metrics := reg.StrParam(params, "metrics", ".*", true, &err)
+			if err != nil {
+				return
+			}
+			regex, err := regexp.Compile(metrics)
+			if err != nil {
+				return reg.ParameterError("metrics", err)
+			}
+			p.Add(&RowStandardization{Metrics: regex})
+			return
+		},
+		"Replace the values of all metrics matching the given regex (all metrics by default) with their z-score, computed from the mean and standard deviation "+
+			"of these values within each individual sample. Useful for comparable metrics, like the utilization of individual CPU cores. "+
+			"If all values of a sample are equal, they are replaced with zeros.",
+		reg.OptionalParams("metrics"))
+}
+
+// RowStandardization replaces the values of all metrics matching the Metrics regex with their z-score inside each sample.
+// The mean and the (population) standard deviation are computed across the selected values of every individual sample,
+// not across time. Samples with zero variance in the selected values produce zeros.
+type RowStandardization struct {
+	bitflow.NoopProcessor
+	Metrics *regexp.Regexp
+
+	checker bitflow.HeaderChecker
+	indices []int
+}
+
+func (r *RowStandardization) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if r.checker.HeaderChanged(header) {
+		r.indices = r.indices[:0]
+		for i, field := range header.Fields {
+			if r.Metrics == nil || r.Metrics.MatchString(field) {
+				r.indices = append(r.indices, i)
+			}
+		}
+	}
+	if len(r.indices) > 0 {
+		var sum float64
+		for _, index := range r.indices {
+			sum += float64(sample.Values[index])
+		}
+		mean := sum / float64(len(r.indices))
+		var squares float64
+		for _, index := range r.indices {
+			diff := float64(sample.Values[index]) - mean
+			squares += diff * diff
+		}
+		stddev := math.Sqrt(squares / float64(len(r.indices)))
+		for _, index := range r.indices {
+			res := 0.0
+			if stddev > 0 {
+				res = (float64(sample.Values[index]) - mean) / stddev
+			}
+			sample.Values[index] = bitflow.Value(res)
+		}
+	}
+	return r.NoopProcessor.Sample(sample, header)
+}
+
+func (r *RowStandardization) String() string {
+	return fmt.Sprintf("Row-wise standardization of metrics matching %v", r.Metrics)
+}
@@ -0,0 +1,53 @@
+package math
+
+import (
+	"math"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestRowStandardization(t *testing.T) {
+	assert := testAssert.New(t)
+	r := &RowStandardization{Metrics: regexp.MustCompile("^cpu")}
+	out := new(collectingSink)
+	r.SetSink(out)
+	r.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"cpu0", "cpu1", "mem", "cpu2", "cpu3"}}
+	inputs := [][]bitflow.Value{
+		{10, 20, 1000, 30, 40},
+		{0.5, 0.1, -3, 0.9, 0.7},
+		{-7, 100, 5, 3, 12},
+		{5, 5, 42, 5, 5}, // Zero variance
+	}
+	for _, values := range inputs {
+		assert.NoError(r.Sample(&bitflow.Sample{Values: append([]bitflow.Value(nil), values...)}, header))
+	}
+	r.Close()
+
+	assert.Len(out.samples, len(inputs))
+	for i, sample := range out.samples {
+		assert.Equal(inputs[i][2], sample.Values[2], "non-matching metric must not change (sample %v)", i)
+		row := []float64{float64(sample.Values[0]), float64(sample.Values[1]), float64(sample.Values[3]), float64(sample.Values[4])}
+		var sum, squares float64
+		for _, val := range row {
+			sum += val
+		}
+		mean := sum / float64(len(row))
+		for _, val := range row {
+			squares += (val - mean) * (val - mean)
+		}
+		stddev := math.Sqrt(squares / float64(len(row)))
+
+		assert.InDelta(0, mean, 1e-9, "mean of sample %v", i)
+		if i == len(inputs)-1 {
+			assert.Equal([]float64{0, 0, 0, 0}, row)
+		} else {
+			assert.InDelta(1, stddev, 1e-9, "stddev of sample %v", i)
+		}
+	}
+}
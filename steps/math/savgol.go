@@ -0,0 +1,115 @@
+package math
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"gonum.org/v1/gonum/mat"
+)
+
+func RegisterSavitzkyGolay(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("savgol",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			filter := &SavitzkyGolay{
+				Window: reg.IntParam(params, "window", 0, false, &err),
+				Order:  reg.IntParam(params, "order", 0, false, &err),
+			}
+			if err != nil {
+				return
+			}
+			if filter.Window <= 0 || filter.Window%2 == 0 {
+				return reg.ParameterError("window", fmt.Errorf("Must be a positive odd number: %v", filter.Window))
+			}
+			if filter.Order < 0 || filter.Order >= filter.Window {
+				return reg.ParameterError("order", errors.New("Must not be negative and must be smaller than the window"))
+			}
+			p.Batch(filter)
+			return
+		},
+		"Smooth every metric in a batch with a Savitzky-Golay filter: a polynomial of the given order is fitted to the window around every sample using least squares, "+
+			"and the value is replaced by the fitted value. The window size must be odd. At the edges of the batch, the windows are truncated.",
+		reg.RequiredParams("window", "order"), reg.SupportBatch())
+}
+
+// SavitzkyGolay smooths the values of every metric by fitting a polynomial of degree Order to the Window samples
+// centered around every sample, and replacing the value with the value of the polynomial at the center. The samples
+// are assumed to be evenly spaced. At the edges of the batch, the window is truncated and the degree of the polynomial
+// is reduced, if the truncated window is too small.
+type SavitzkyGolay struct {
+	Window int
+	Order  int
+
+	weights map[[2]int][]float64
+}
+
+func (f *SavitzkyGolay) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 || len(header.Fields) == 0 {
+		return header, samples, nil
+	}
+	values := SamplesToMatrix(samples)
+	half := f.Window / 2
+	for i, sample := range samples {
+		left, right := half, half
+		if i < left {
+			left = i
+		}
+		if last := len(samples) - 1 - i; last < right {
+			right = last
+		}
+		weights, err := f.getWeights(left, right)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v: %v", f, err)
+		}
+		for j := range sample.Values {
+			var smoothed float64
+			for k, weight := range weights {
+				smoothed += weight * values.At(i-left+k, j)
+			}
+			sample.Values[j] = bitflow.Value(smoothed)
+		}
+	}
+	return header, samples, nil
+}
+
+// getWeights returns the coefficients that, multiplied with the values in the window [-left, right], yield the fitted value
+// at position 0. They are the first row of the pseudo-inverse of the Vandermonde matrix of the window.
+func (f *SavitzkyGolay) getWeights(left, right int) ([]float64, error) {
+	key := [2]int{left, right}
+	if weights, ok := f.weights[key]; ok {
+		return weights, nil
+	}
+	size := left + right + 1
+	degree := f.Order
+	if degree >= size {
+		degree = size - 1
+	}
+	vandermonde := mat.NewDense(size, degree+1, nil)
+	for row := 0; row < size; row++ {
+		x := float64(row - left)
+		power := 1.0
+		for col := 0; col <= degree; col++ {
+			vandermonde.Set(row, col, power)
+			power *= x
+		}
+	}
+	identity := mat.NewDense(size, size, nil)
+	for i := 0; i < size; i++ {
+		identity.Set(i, i, 1)
+	}
+	var pseudoInverse mat.Dense
+	if err := pseudoInverse.Solve(vandermonde, identity); err != nil {
+		return nil, fmt.Errorf("Failed to compute filter coefficients: %v", err)
+	}
+	weights := mat.Row(nil, 0, &pseudoInverse)
+	if f.weights == nil {
+		f.weights = make(map[[2]int][]float64)
+	}
+	f.weights[key] = weights
+	return weights, nil
+}
+
+func (f *SavitzkyGolay) String() string {
+	return fmt.Sprintf("Savitzky-Golay filter (window %v, order %v)", f.Window, f.Order)
+}
@@ -0,0 +1,65 @@
+package math
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func makeSignalSamples(num int, signal func(x float64) float64) []*bitflow.Sample {
+	start := time.Unix(1000, 0)
+	samples := make([]*bitflow.Sample, num)
+	for i := range samples {
+		samples[i] = &bitflow.Sample{
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Values: []bitflow.Value{bitflow.Value(signal(float64(i)))},
+		}
+	}
+	return samples
+}
+
+func TestSavitzkyGolayPolynomial(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"val"}}
+	quadratic := func(x float64) float64 { return 2 + 0.5*x - 0.3*x*x }
+	samples := makeSignalSamples(20, quadratic)
+	times := make([]time.Time, len(samples))
+	for i, sample := range samples {
+		times[i] = sample.Time
+	}
+
+	// A polynomial of the filter order is reproduced exactly, including the truncated windows at the edges
+	outHeader, outSamples, err := (&SavitzkyGolay{Window: 7, Order: 2}).ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal(header, outHeader)
+	assert.Len(outSamples, 20)
+	for i, sample := range outSamples {
+		assert.InDelta(quadratic(float64(i)), float64(sample.Values[0]), 1e-6)
+		assert.Equal(times[i], sample.Time)
+	}
+}
+
+func TestSavitzkyGolayPeak(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"val"}}
+	peak := func(x float64) float64 { return 10 * math.Exp(-(x-20)*(x-20)/8) }
+	const window = 7
+
+	_, smoothed, err := (&SavitzkyGolay{Window: window, Order: 4}).ProcessBatch(header, makeSignalSamples(41, peak))
+	assert.NoError(err)
+
+	// Moving average over the same window for comparison
+	original := makeSignalSamples(41, peak)
+	var movingAvg float64
+	for i := 20 - window/2; i <= 20+window/2; i++ {
+		movingAvg += float64(original[i].Values[0]) / window
+	}
+
+	savgolPeak := float64(smoothed[20].Values[0])
+	assert.True(math.Abs(10-savgolPeak) < math.Abs(10-movingAvg),
+		"Savitzky-Golay peak %v should be closer to 10 than moving average peak %v", savgolPeak, movingAvg)
+	assert.InDelta(10, savgolPeak, 0.5)
+}
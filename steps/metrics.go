@@ -34,7 +34,7 @@ func (helper *MetricMapperHelper) incomingHeader(header *bitflow.Header, descrip
 	} else {
 		log.Println(description, "changes metrics", len(header.Fields), "->", len(outFields))
 	}
-	helper.outHeader = header.Clone(outFields)
+	helper.outHeader = header.CloneMapped(outFields, helper.outIndices)
 	return nil
 }
 
@@ -102,6 +102,15 @@ func (filter *AbstractMetricFilter) constructIndices(header *bitflow.Header) ([]
 
 type MetricFilter struct {
 	AbstractMetricFilter
+
+	// CaseInsensitive makes the regexes added through IncludeRegex, ExcludeRegex, IncludeStr and ExcludeStr
+	// case-insensitive. It must be set before adding the regexes.
+	CaseInsensitive bool
+
+	// InvertInclude changes the semantics of the include regexes: instead of keeping only the metrics matching at least
+	// one include regex, all metrics matching any include regex are removed. The exclude regexes are not affected.
+	InvertInclude bool
+
 	exclude []*regexp.Regexp
 	include []*regexp.Regexp
 }
@@ -116,26 +125,47 @@ func NewMetricFilter() *MetricFilter {
 
 func RegisterIncludeMetricsFilter(b reg.ProcessorRegistry) {
 	b.RegisterAnalysisParamsErr("include",
-		func(p *bitflow.SamplePipeline, params map[string]string) error {
-			filter, err := NewMetricFilter().IncludeRegex(params["m"])
-			if err == nil {
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			filter, invert := newMetricFilterFromParams(params, &err)
+			if err != nil {
+				return
+			}
+			filter.InvertInclude = invert
+			if _, err = filter.IncludeRegex(params["m"]); err == nil {
 				p.Add(filter)
 			}
-			return err
+			return
 		},
-		"Match every metric with the given regex and only include the matched metrics", reg.RequiredParams("m"))
+		"Match every metric with the given regex and only include the matched metrics. With invert=true, all metrics except the matched ones are included.",
+		reg.RequiredParams("m"), reg.OptionalParams("case-insensitive", "invert"))
 }
 
 func RegisterExcludeMetricsFilter(b reg.ProcessorRegistry) {
 	b.RegisterAnalysisParamsErr("exclude",
-		func(p *bitflow.SamplePipeline, params map[string]string) error {
-			filter, err := NewMetricFilter().ExcludeRegex(params["m"])
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			filter, invert := newMetricFilterFromParams(params, &err)
+			if err != nil {
+				return
+			}
+			if invert {
+				_, err = filter.IncludeRegex(params["m"])
+			} else {
+				_, err = filter.ExcludeRegex(params["m"])
+			}
 			if err == nil {
 				p.Add(filter)
 			}
-			return err
+			return
 		},
-		"Match every metric with the given regex and exclude the matched metrics", reg.RequiredParams("m"))
+		"Match every metric with the given regex and exclude the matched metrics. With invert=true, all metrics except the matched ones are excluded.",
+		reg.RequiredParams("m"), reg.OptionalParams("case-insensitive", "invert"))
+}
+
+func newMetricFilterFromParams(params map[string]string, err *error) (*MetricFilter, bool) {
+	filter := NewMetricFilter()
+	filter.CaseInsensitive = reg.BoolParam(params, "case-insensitive", false, true, err)
+	invert := reg.BoolParam(params, "invert", false, true, err)
+	return filter, invert
 }
 
 func (filter *MetricFilter) Exclude(regex *regexp.Regexp) *MetricFilter {
@@ -152,7 +182,7 @@ func (filter *MetricFilter) ExcludeStr(substr string) *MetricFilter {
 }
 
 func (filter *MetricFilter) ExcludeRegex(regexStr string) (*MetricFilter, error) {
-	regex, err := regexp.Compile(regexStr)
+	regex, err := filter.compile(regexStr)
 	if err != nil {
 		return nil, err
 	}
@@ -173,13 +203,20 @@ func (filter *MetricFilter) IncludeStr(substr string) *MetricFilter {
 }
 
 func (filter *MetricFilter) IncludeRegex(regexStr string) (*MetricFilter, error) {
-	regex, err := regexp.Compile(regexStr)
+	regex, err := filter.compile(regexStr)
 	if err != nil {
 		return nil, err
 	}
 	return filter.Include(regex), nil
 }
 
+func (filter *MetricFilter) compile(regexStr string) (*regexp.Regexp, error) {
+	if filter.CaseInsensitive {
+		regexStr = "(?i)" + regexStr
+	}
+	return regexp.Compile(regexStr)
+}
+
 func (filter *MetricFilter) filter(name string) bool {
 	excluded := false
 	for _, regex := range filter.exclude {
@@ -187,7 +224,13 @@ func (filter *MetricFilter) filter(name string) bool {
 			break
 		}
 	}
-	if !excluded && len(filter.include) > 0 {
+	if !excluded && filter.InvertInclude {
+		for _, regex := range filter.include {
+			if excluded = regex.MatchString(name); excluded {
+				break
+			}
+		}
+	} else if !excluded && len(filter.include) > 0 {
 		excluded = true
 		for _, regex := range filter.include {
 			if excluded = !regex.MatchString(name); !excluded {
@@ -199,17 +242,30 @@ func (filter *MetricFilter) filter(name string) bool {
 }
 
 func (filter *MetricFilter) MergeProcessor(other bitflow.SampleProcessor) bool {
-	if otherFilter, ok := other.(*MetricFilter); !ok {
+	if otherFilter, ok := other.(*MetricFilter); !ok || !filter.compatible(otherFilter) {
 		return false
 	} else {
+		if len(filter.include) == 0 {
+			filter.InvertInclude = otherFilter.InvertInclude
+		}
 		filter.exclude = append(filter.exclude, otherFilter.exclude...)
 		filter.include = append(filter.include, otherFilter.include...)
 		return true
 	}
 }
 
+// compatible returns true, if the include regexes of both filters have the same semantics. The CaseInsensitive flag
+// does not matter, since it only affects how the regexes are compiled.
+func (filter *MetricFilter) compatible(other *MetricFilter) bool {
+	return filter.InvertInclude == other.InvertInclude || len(filter.include) == 0 || len(other.include) == 0
+}
+
 func (filter *MetricFilter) String() string {
-	return fmt.Sprintf("MetricFilter(%v exclude filters, %v include filters)", len(filter.exclude), len(filter.include))
+	includes := "include"
+	if filter.InvertInclude {
+		includes = "inverted include"
+	}
+	return fmt.Sprintf("MetricFilter(%v exclude filters, %v %v filters)", len(filter.exclude), len(filter.include), includes)
 }
 
 type MetricMapper struct {
@@ -426,3 +482,97 @@ func (f indexedFields) Less(i, j int) bool {
 func (f indexedFields) Swap(i, j int) {
 	f[i], f[j] = f[j], f[i]
 }
+
+const (
+	FieldNamesMismatchKeep  = "keep"
+	FieldNamesMismatchError = "error"
+)
+
+func RegisterFieldNamer(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("name_fields",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			names := reg.StrParam(params, "names", "", false, &err)
+			policy := reg.StrParam(params, "on-mismatch", FieldNamesMismatchKeep, true, &err)
+			if err != nil {
+				return
+			}
+			if policy != FieldNamesMismatchKeep && policy != FieldNamesMismatchError {
+				return reg.ParameterError("on-mismatch", fmt.Errorf("Must be %v or %v", FieldNamesMismatchKeep, FieldNamesMismatchError))
+			}
+			namer := &FieldNamer{
+				Names:           strings.Split(names, ","),
+				ErrorOnMismatch: policy == FieldNamesMismatchError,
+			}
+			if err = namer.checkNames(); err != nil {
+				return reg.ParameterError("names", err)
+			}
+			p.Add(namer)
+			return
+		},
+		"Rename the header fields by position, using the given comma-separated list of names. "+
+			"If the number of names and fields differs, the remaining fields keep their names (on-mismatch=keep), or an error is raised (on-mismatch=error)",
+		reg.RequiredParams("names"), reg.OptionalParams("on-mismatch"))
+}
+
+// FieldNamer renames the header fields by their position. Names[i] is assigned to header.Fields[i].
+// If the number of fields and names differs and ErrorOnMismatch is not set, the remaining fields keep
+// their names and the remaining names are ignored. Headers where a new name clashes with one of the
+// remaining field names result in an error.
+type FieldNamer struct {
+	bitflow.NoopProcessor
+	Names           []string
+	ErrorOnMismatch bool
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+}
+
+func (n *FieldNamer) checkNames() error {
+	seen := make(map[string]bool, len(n.Names))
+	for _, name := range n.Names {
+		if name == "" {
+			return errors.New("Field names cannot be empty")
+		}
+		if seen[name] {
+			return fmt.Errorf("Duplicate field name: %v", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+func (n *FieldNamer) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if n.checker.HeaderChanged(header) {
+		if err := n.updateHeader(header); err != nil {
+			n.checker.LastHeader = nil // Fail again for the following samples with the same header
+			return err
+		}
+	}
+	return n.NoopProcessor.Sample(sample, n.outHeader)
+}
+
+func (n *FieldNamer) updateHeader(header *bitflow.Header) error {
+	if len(header.Fields) != len(n.Names) {
+		if n.ErrorOnMismatch {
+			return fmt.Errorf("%v: Received header with %v fields", n, len(header.Fields))
+		}
+		log.Warnf("%v: Received header with %v fields, naming only the first %v", n, len(header.Fields), len(n.Names))
+	}
+	fields := make([]string, len(header.Fields))
+	copy(fields, header.Fields)
+	copy(fields, n.Names)
+	for i := len(n.Names); i < len(fields); i++ {
+		for _, name := range n.Names {
+			if fields[i] == name {
+				return fmt.Errorf("%v: Renaming the fields results in the duplicate field %v", n, name)
+			}
+		}
+	}
+	n.outHeader = header.Clone(fields)
+	n.outHeader.FieldIds, n.outHeader.OriginalFields = header.FieldIds, header.OriginalFields
+	return nil
+}
+
+func (n *FieldNamer) String() string {
+	return fmt.Sprintf("Name fields by position (%v)", strings.Join(n.Names, ", "))
+}
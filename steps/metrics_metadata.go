@@ -0,0 +1,73 @@
+package steps
+
+import (
+	"fmt"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterMetadataFilter(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("filter_metadata",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			key := reg.StrParam(params, "key", "", false, &err)
+			value, hasValue := params["value"]
+			exclude := reg.BoolParam(params, "exclude", false, true, &err)
+			if err == nil {
+				p.Add(NewMetadataFilter(key, value, hasValue, exclude))
+			}
+			return
+		},
+		"Select metrics based on their metadata attributes, e.g. key=unit and value=bytes. Without the value parameter, all metrics with the given attribute are selected. "+
+			"The selected metrics are included, or excluded if exclude=true. Metrics without metadata are never selected.",
+		reg.RequiredParams("key"), reg.OptionalParams("value", "exclude"))
+}
+
+// MetadataFilter includes or excludes the header fields based on their metadata attributes (see bitflow.Header.FieldMetadata).
+// A field is selected, if it has the attribute Key and, if MatchValue is set, the attribute has the given Value.
+// Only the selected fields are kept, or, if Exclude is set, all other fields. Since the metadata is passed through the
+// pipeline with the headers, it must not be removed by previous steps, e.g. by steps that create new headers using Header.Clone.
+type MetadataFilter struct {
+	AbstractMetricMapper
+	Key        string
+	Value      string
+	MatchValue bool
+	Exclude    bool
+}
+
+func NewMetadataFilter(key, value string, matchValue bool, exclude bool) *MetadataFilter {
+	filter := &MetadataFilter{
+		Key:        key,
+		Value:      value,
+		MatchValue: matchValue,
+		Exclude:    exclude,
+	}
+	filter.Description = filter
+	filter.ConstructIndices = filter.constructIndices
+	return filter
+}
+
+func (filter *MetadataFilter) constructIndices(header *bitflow.Header) ([]int, []string) {
+	outFields := make([]string, 0, len(header.Fields))
+	outIndices := make([]int, 0, len(header.Fields))
+	for index, field := range header.Fields {
+		value, ok := header.GetFieldMetadata(index, filter.Key)
+		selected := ok && (!filter.MatchValue || value == filter.Value)
+		if selected != filter.Exclude {
+			outFields = append(outFields, field)
+			outIndices = append(outIndices, index)
+		}
+	}
+	return outIndices, outFields
+}
+
+func (filter *MetadataFilter) String() string {
+	action := "Include"
+	if filter.Exclude {
+		action = "Exclude"
+	}
+	if filter.MatchValue {
+		return fmt.Sprintf("%v metrics with metadata %v=%v", action, filter.Key, filter.Value)
+	}
+	return fmt.Sprintf("%v metrics with metadata %v", action, filter.Key)
+}
@@ -0,0 +1,51 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestMetadataFilter(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"mem", "cpu", "net", "disk"}}
+	assert.True(header.SetFieldMetadata("mem", "unit", "bytes"))
+	assert.True(header.SetFieldMetadata("mem", "type", "gauge"))
+	assert.True(header.SetFieldMetadata("cpu", "unit", "percent"))
+	assert.True(header.SetFieldMetadata("net", "unit", "bytes"))
+	assert.False(header.SetFieldMetadata("missing", "unit", "bytes"))
+
+	run := func(filter *MetadataFilter) ([]string, []bitflow.Value) {
+		out := new(testSampleCollector)
+		filter.SetSink(out)
+		filter.Start(new(sync.WaitGroup))
+		assert.NoError(filter.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2, 3, 4}}, header))
+		assert.Len(out.samples, 1)
+		return out.headers[0].Fields, out.samples[0].Values
+	}
+
+	fields, values := run(NewMetadataFilter("unit", "bytes", true, false))
+	assert.Equal([]string{"mem", "net"}, fields)
+	assert.Equal([]bitflow.Value{1, 3}, values)
+	fields, values = run(NewMetadataFilter("unit", "bytes", true, true))
+	assert.Equal([]string{"cpu", "disk"}, fields)
+	assert.Equal([]bitflow.Value{2, 4}, values)
+	fields, _ = run(NewMetadataFilter("unit", "", false, false))
+	assert.Equal([]string{"mem", "cpu", "net"}, fields)
+
+	// The metadata is passed on to the following steps
+	filter := NewMetadataFilter("type", "", false, true)
+	out := new(testSampleCollector)
+	next := NewMetadataFilter("unit", "bytes", true, false)
+	filter.SetSink(next)
+	next.SetSink(out)
+	filter.Start(new(sync.WaitGroup))
+	next.Start(new(sync.WaitGroup))
+	assert.NoError(filter.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2, 3, 4}}, header))
+	assert.Equal([]string{"net"}, out.headers[0].Fields)
+	unit, ok := out.headers[0].GetFieldMetadata(0, "unit")
+	assert.True(ok)
+	assert.Equal("bytes", unit)
+}
@@ -0,0 +1,166 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// MetricPartitionDefaultParam is the parameter of the partition_metrics step that configures the output
+// for metrics that are not matched by any pattern.
+const MetricPartitionDefaultParam = "default"
+
+func RegisterMetricPartitioner(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("partition_metrics",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			defaultEndpoint, hasDefault := params[MetricPartitionDefaultParam]
+			delete(params, MetricPartitionDefaultParam)
+			if len(params) == 0 {
+				return errors.New("Need at least one regex=endpoint parameter")
+			}
+
+			// Sort the patterns to make the order of the partitions deterministic
+			patterns := make([]string, 0, len(params))
+			for pattern := range params {
+				patterns = append(patterns, pattern)
+			}
+			sort.Strings(patterns)
+
+			distributor := new(MetricPartitionDistributor)
+			for _, pattern := range patterns {
+				regex, err := regexp.Compile(pattern)
+				if err != nil {
+					return reg.ParameterError(pattern, err)
+				}
+				output, err := bitflow.DefaultEndpointFactory.CreateOutput(params[pattern])
+				if err != nil {
+					return reg.ParameterError(pattern, err)
+				}
+				distributor.AddPartition(regex, params[pattern], output)
+			}
+			if hasDefault {
+				output, err := bitflow.DefaultEndpointFactory.CreateOutput(defaultEndpoint)
+				if err != nil {
+					return reg.ParameterError(MetricPartitionDefaultParam, err)
+				}
+				distributor.SetDefault(defaultEndpoint, output)
+			}
+			p.Add(&fork.SampleFork{Distributor: distributor})
+			return nil
+		},
+		"Demultiplex the stream by metric: every parameter maps a metric regex to an output endpoint, which receives the samples projected to the matching metrics. "+
+			"A metric can be matched by multiple patterns. Metrics not matched by any pattern are sent to the '"+MetricPartitionDefaultParam+"' endpoint, or dropped if it is not given.")
+}
+
+// MetricPartition is one output of a MetricPartitionDistributor. The Pipeline projects the incoming samples
+// to the metrics matched by the Metrics regex and forwards them to the configured output.
+// For the default partition, Metrics is nil and the Pipeline receives all metrics not matched by any other partition.
+type MetricPartition struct {
+	Metrics  *regexp.Regexp
+	Output   string
+	Pipeline *bitflow.SamplePipeline
+}
+
+func (p *MetricPartition) String() string {
+	if p.Metrics == nil {
+		return fmt.Sprintf("Unmatched metrics -> %v", p.Output)
+	}
+	return fmt.Sprintf("Metrics matching %v -> %v", p.Metrics, p.Output)
+}
+
+// MetricPartitionDistributor distributes every sample to the partitions that match at least one metric
+// of the current header. The projection to the matched metrics is done by an AbstractMetricFilter at the start
+// of every partition pipeline.
+type MetricPartitionDistributor struct {
+	Partitions []*MetricPartition
+	Default    *MetricPartition // Optional, receives the unmatched metrics
+
+	checker bitflow.HeaderChecker
+	active  []fork.Subpipeline
+}
+
+// AddPartition adds a partition that receives the metrics matching the given regex. The name of the output is used for printing.
+func (d *MetricPartitionDistributor) AddPartition(metrics *regexp.Regexp, outputName string, output bitflow.SampleProcessor) {
+	partition := &MetricPartition{Metrics: metrics, Output: outputName}
+	partition.Pipeline = d.makePipeline(partition, metrics.MatchString, output)
+	d.Partitions = append(d.Partitions, partition)
+	d.checker.LastHeader = nil // Force recomputing the active partitions
+}
+
+// SetDefault configures the output for metrics that are not matched by any partition.
+func (d *MetricPartitionDistributor) SetDefault(outputName string, output bitflow.SampleProcessor) {
+	partition := &MetricPartition{Output: outputName}
+	partition.Pipeline = d.makePipeline(partition, func(name string) bool {
+		return !d.matchesAnyPartition(name)
+	}, output)
+	d.Default = partition
+	d.checker.LastHeader = nil
+}
+
+func (d *MetricPartitionDistributor) makePipeline(partition *MetricPartition, include func(name string) bool, output bitflow.SampleProcessor) *bitflow.SamplePipeline {
+	filter := new(AbstractMetricFilter)
+	filter.Description = partition
+	filter.ConstructIndices = filter.constructIndices
+	filter.IncludeFilter = include
+	return new(bitflow.SamplePipeline).Add(filter).Add(output)
+}
+
+func (d *MetricPartitionDistributor) matchesAnyPartition(name string) bool {
+	for _, partition := range d.Partitions {
+		if partition.Metrics.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *MetricPartitionDistributor) Distribute(_ *bitflow.Sample, header *bitflow.Header) ([]fork.Subpipeline, error) {
+	if d.checker.HeaderChanged(header) {
+		d.active = d.active[:0]
+		for _, partition := range d.allPartitions() {
+			for _, field := range header.Fields {
+				matched := partition.Metrics == nil && !d.matchesAnyPartition(field) ||
+					partition.Metrics != nil && partition.Metrics.MatchString(field)
+				if matched {
+					d.active = append(d.active, fork.Subpipeline{Pipe: partition.Pipeline, Key: partition.Output})
+					break
+				}
+			}
+		}
+	}
+	return d.active, nil
+}
+
+func (d *MetricPartitionDistributor) allPartitions() []*MetricPartition {
+	if d.Default == nil {
+		return d.Partitions
+	}
+	return append(d.Partitions[:len(d.Partitions):len(d.Partitions)], d.Default)
+}
+
+func (d *MetricPartitionDistributor) String() string {
+	res := fmt.Sprintf("partition metrics (%v partitions", len(d.Partitions))
+	if d.Default == nil {
+		res += ", drop unmatched metrics)"
+	} else {
+		res += ", default output " + d.Default.Output + ")"
+	}
+	return res
+}
+
+func (d *MetricPartitionDistributor) ContainedStringers() []fmt.Stringer {
+	partitions := d.allPartitions()
+	res := make([]fmt.Stringer, len(partitions))
+	for i, partition := range partitions {
+		res[i] = &bitflow.TitledSamplePipeline{
+			SamplePipeline: partition.Pipeline,
+			Title:          partition.String(),
+		}
+	}
+	return res
+}
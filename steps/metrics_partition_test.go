@@ -0,0 +1,65 @@
+package steps
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestMetricPartitioner(t *testing.T) {
+	assert := testAssert.New(t)
+	cpuOut := new(testSampleCollector)
+	memOut := new(testSampleCollector)
+	distributor := new(MetricPartitionDistributor)
+	distributor.AddPartition(regexp.MustCompile("^cpu"), "cpu", cpuOut)
+	distributor.AddPartition(regexp.MustCompile("^mem"), "mem", memOut)
+
+	partitioner := &fork.SampleFork{Distributor: distributor}
+	partitioner.SetSink(new(bitflow.DroppingSampleProcessor))
+	var wg sync.WaitGroup
+	partitioner.Start(&wg)
+
+	header := &bitflow.Header{Fields: []string{"cpu_user", "mem_used", "cpu_system", "mem_free"}}
+	for i := 0; i < 3; i++ {
+		sample := &bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i), bitflow.Value(i + 10), bitflow.Value(i + 20), bitflow.Value(i + 30)}}
+		sample.SetTag("host", "a")
+		assert.NoError(partitioner.Sample(sample, header))
+	}
+	partitioner.Close()
+	wg.Wait()
+
+	assert.Len(cpuOut.samples, 3)
+	assert.Len(memOut.samples, 3)
+	for i := 0; i < 3; i++ {
+		assert.Equal([]string{"cpu_user", "cpu_system"}, cpuOut.headers[i].Fields)
+		assert.Equal([]bitflow.Value{bitflow.Value(i), bitflow.Value(i + 20)}, cpuOut.samples[i].Values)
+		assert.Equal([]string{"mem_used", "mem_free"}, memOut.headers[i].Fields)
+		assert.Equal([]bitflow.Value{bitflow.Value(i + 10), bitflow.Value(i + 30)}, memOut.samples[i].Values)
+		assert.Equal("a", memOut.samples[i].Tag("host"))
+	}
+}
+
+func TestMetricPartitionerDefault(t *testing.T) {
+	assert := testAssert.New(t)
+	distributor := new(MetricPartitionDistributor)
+	distributor.AddPartition(regexp.MustCompile("^cpu"), "cpu", new(testSampleCollector))
+
+	header := &bitflow.Header{Fields: []string{"cpu", "mem"}}
+	pipes, err := distributor.Distribute(new(bitflow.Sample), header)
+	assert.NoError(err)
+	assert.Len(pipes, 1, "unmatched metrics should be dropped without default output")
+
+	distributor.SetDefault("rest", new(testSampleCollector))
+	pipes, err = distributor.Distribute(new(bitflow.Sample), &bitflow.Header{Fields: []string{"cpu", "mem"}})
+	assert.NoError(err)
+	assert.Len(pipes, 2)
+	assert.Equal("rest", pipes[1].Key)
+
+	pipes, err = distributor.Distribute(new(bitflow.Sample), &bitflow.Header{Fields: []string{"cpu"}})
+	assert.NoError(err)
+	assert.Len(pipes, 1, "the default output should not receive samples without unmatched metrics")
+}
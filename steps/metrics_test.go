@@ -0,0 +1,96 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestMetricFilterModes(t *testing.T) {
+	assert := testAssert.New(t)
+	fields := []string{"cpu", "CPU_user", "mem", "disk_io", "net_io"}
+	filtered := func(filter *MetricFilter) []string {
+		var res []string
+		for _, field := range fields {
+			if filter.filter(field) {
+				res = append(res, field)
+			}
+		}
+		return res
+	}
+
+	assert.Equal([]string{"cpu"}, filtered(NewMetricFilter().IncludeStr("cpu")))
+	filter := NewMetricFilter()
+	filter.CaseInsensitive = true
+	assert.Equal([]string{"cpu", "CPU_user"}, filtered(filter.IncludeStr("cpu")))
+
+	filter = NewMetricFilter()
+	filter.InvertInclude = true
+	filter.IncludeStr("_io").ExcludeStr("mem")
+	assert.Equal([]string{"cpu", "CPU_user"}, filtered(filter))
+
+	// Include filters are only merged with filters of the same mode
+	normal := NewMetricFilter().IncludeStr("cpu")
+	assert.False(normal.MergeProcessor(filter))
+	assert.True(normal.MergeProcessor(NewMetricFilter().ExcludeStr("mem")))
+	assert.False(normal.InvertInclude)
+	inverted := NewMetricFilter().IncludeStr("disk")
+	inverted.InvertInclude = true
+	assert.True(filter.MergeProcessor(inverted))
+	assert.Equal([]string{"cpu", "CPU_user"}, filtered(filter))
+
+	excludes := NewMetricFilter().ExcludeStr("cpu")
+	assert.True(excludes.MergeProcessor(inverted))
+	assert.True(excludes.InvertInclude)
+	assert.Equal([]string{"CPU_user", "mem", "net_io"}, filtered(excludes))
+}
+
+func TestFieldNamer(t *testing.T) {
+	assert := testAssert.New(t)
+	namer := &FieldNamer{Names: []string{"x", "y", "z"}}
+	out := new(testSampleCollector)
+	namer.SetSink(out)
+	namer.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b", "c", "d"}}
+	values := []bitflow.Value{1, 2, 3, 4}
+	assert.NoError(namer.Sample(&bitflow.Sample{Values: values}, header))
+	assert.NoError(namer.Sample(&bitflow.Sample{Values: values}, header))
+	namer.Close()
+
+	assert.Len(out.samples, 2)
+	assert.Equal([]string{"x", "y", "z", "d"}, out.headers[0].Fields)
+	assert.True(out.headers[0] == out.headers[1], "the output header should only be created once")
+	assert.Equal(values, out.samples[0].Values)
+	assert.Equal([]string{"a", "b", "c", "d"}, header.Fields, "the input header must not be modified")
+}
+
+func TestFieldNamerMismatch(t *testing.T) {
+	assert := testAssert.New(t)
+	namer := &FieldNamer{Names: []string{"x", "y", "z"}, ErrorOnMismatch: true}
+	namer.SetSink(new(testSampleCollector))
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	assert.Error(namer.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, header))
+
+	namer = &FieldNamer{Names: []string{"x", "y", "x"}}
+	assert.Error(namer.checkNames())
+}
+
+func TestFieldNamerDuplicateFields(t *testing.T) {
+	assert := testAssert.New(t)
+	namer := &FieldNamer{Names: []string{"x", "c"}}
+	out := new(testSampleCollector)
+	namer.SetSink(out)
+	header := &bitflow.Header{Fields: []string{"a", "b", "c"}}
+	assert.Error(namer.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2, 3}}, header))
+	assert.Error(namer.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2, 3}}, header))
+	assert.Empty(out.samples)
+
+	// Renaming all fields cannot produce duplicates
+	assert.NoError(namer.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, &bitflow.Header{Fields: []string{"c", "x"}}))
+	if assert.Len(out.samples, 1) {
+		assert.Equal([]string{"x", "c"}, out.headers[0].Fields)
+	}
+}
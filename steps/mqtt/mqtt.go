@@ -0,0 +1,326 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	MqttEndpoint    = bitflow.EndpointType("mqtt")
+	DefaultMqttPort = "1883"
+)
+
+// DefaultMqttConfig contains the defaults for the MQTT command line flags.
+var DefaultMqttConfig = MqttConfig{
+	Qos:            1,
+	Format:         bitflow.CsvFormat,
+	ConnectTimeout: 5 * time.Second,
+}
+
+var mqttClientCounter uint64
+
+// MqttConfig configures the connection to an MQTT broker. Every published message contains one
+// marshalled header and one sample, so that subscribers can parse every message individually.
+type MqttConfig struct {
+	Qos            int
+	ClientID       string // A unique client ID is generated, if this is empty
+	Username       string
+	Password       string
+	Format         bitflow.MarshallingFormat
+	ConnectTimeout time.Duration
+}
+
+// RegisterMqttEndpoints registers the 'mqtt' data source and sink. The endpoints have the form
+// mqtt://broker:port/topic?qos=1&client-id=abc&user=u&password=p&format=csv. All query parameters are
+// optional and override the values set through the -mqtt-* command line flags.
+func RegisterMqttEndpoints(b reg.ProcessorRegistry) {
+	config := DefaultMqttConfig
+	b.Endpoints.CustomGeneralFlags = append(b.Endpoints.CustomGeneralFlags, func(f *flag.FlagSet) {
+		f.IntVar(&config.Qos, "mqtt-qos", config.Qos, "QoS level (0, 1 or 2) for publishing and subscribing to MQTT topics")
+		f.StringVar(&config.ClientID, "mqtt-client-id", config.ClientID, "Client ID for MQTT connections. By default, a unique ID is generated.")
+		f.StringVar(&config.Username, "mqtt-user", config.Username, "Username for MQTT connections")
+		f.StringVar(&config.Password, "mqtt-password", config.Password, "Password for MQTT connections")
+		f.StringVar((*string)(&config.Format), "mqtt-format", string(config.Format), "Data format for marshalling samples in MQTT messages")
+		f.DurationVar(&config.ConnectTimeout, "mqtt-connect-timeout", config.ConnectTimeout, "Timeout for connecting to MQTT brokers")
+	})
+
+	b.Endpoints.CustomDataSources[MqttEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		broker, topic, endpointConfig, err := ParseMqttEndpoint(target, config)
+		if err != nil {
+			return nil, err
+		}
+		marshaller, err := b.Endpoints.CreateMarshaller(endpointConfig.Format)
+		if err != nil {
+			return nil, err
+		}
+		unmarshaller, ok := marshaller.(bitflow.Unmarshaller)
+		if !ok {
+			return nil, fmt.Errorf("Format '%v' cannot be used for reading MQTT messages", endpointConfig.Format)
+		}
+		return &MqttSource{
+			Broker:       broker,
+			Topic:        topic,
+			Config:       endpointConfig,
+			Unmarshaller: unmarshaller,
+		}, nil
+	}
+	b.Endpoints.CustomDataSinks[MqttEndpoint] = func(target string) (bitflow.SampleProcessor, error) {
+		broker, topic, endpointConfig, err := ParseMqttEndpoint(target, config)
+		if err != nil {
+			return nil, err
+		}
+		marshaller, err := b.Endpoints.CreateMarshaller(endpointConfig.Format)
+		if err != nil {
+			return nil, err
+		}
+		return &MqttSink{
+			Broker:     broker,
+			Topic:      topic,
+			Config:     endpointConfig,
+			Marshaller: marshaller,
+		}, nil
+	}
+}
+
+// ParseMqttEndpoint parses an endpoint target in the form broker:port/topic?param=value. The port is optional.
+// The query parameters qos, client-id, user, password and format override the values in the given config.
+func ParseMqttEndpoint(target string, config MqttConfig) (broker string, topic string, _ MqttConfig, err error) {
+	index := strings.IndexByte(target, '/')
+	if index <= 0 || index == len(target)-1 {
+		return "", "", config, fmt.Errorf("MQTT endpoint must have the form broker:port/topic, received: %v", target)
+	}
+	broker, topic = target[:index], target[index+1:]
+	if !strings.Contains(broker, ":") {
+		broker += ":" + DefaultMqttPort
+	}
+	if index = strings.LastIndexByte(topic, '?'); index >= 0 {
+		var query url.Values
+		query, err = url.ParseQuery(topic[index+1:])
+		if err != nil {
+			return
+		}
+		topic = topic[:index]
+		for key, values := range query {
+			value := values[len(values)-1]
+			switch key {
+			case "qos":
+				config.Qos, err = strconv.Atoi(value)
+			case "client-id":
+				config.ClientID = value
+			case "user":
+				config.Username = value
+			case "password":
+				config.Password = value
+			case "format":
+				config.Format = bitflow.MarshallingFormat(value)
+			default:
+				err = fmt.Errorf("Unknown MQTT endpoint parameter: %v", key)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	if config.Qos < 0 || config.Qos > 2 {
+		err = fmt.Errorf("MQTT QoS must be 0, 1 or 2, received %v", config.Qos)
+	}
+	return broker, topic, config, err
+}
+
+func (config *MqttConfig) connect(broker string, onConnect paho.OnConnectHandler) (paho.Client, error) {
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = fmt.Sprintf("bitflow-%v-%v", os.Getpid(), atomic.AddUint64(&mqttClientCounter, 1))
+	}
+	opts := paho.NewClientOptions().
+		AddBroker("tcp://" + broker).
+		SetClientID(clientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetConnectTimeout(config.ConnectTimeout).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.WithField("broker", broker).Warnln("Lost MQTT connection, reconnecting:", err)
+		})
+	client := paho.NewClient(opts)
+	if err := waitToken(client.Connect(), config.ConnectTimeout); err != nil {
+		return nil, fmt.Errorf("Failed to connect to MQTT broker %v: %v", broker, err)
+	}
+	return client, nil
+}
+
+func waitToken(token paho.Token, timeout time.Duration) error {
+	if timeout > 0 {
+		if !token.WaitTimeout(timeout) {
+			return fmt.Errorf("Timed out after %v", timeout)
+		}
+	} else {
+		token.Wait()
+	}
+	return token.Error()
+}
+
+// MqttSink publishes every sample, together with its header, as one message to an MQTT topic.
+type MqttSink struct {
+	bitflow.AbstractSampleOutput
+	Broker     string
+	Topic      string
+	Config     MqttConfig
+	Marshaller bitflow.Marshaller
+
+	client paho.Client
+}
+
+func (sink *MqttSink) String() string {
+	return fmt.Sprintf("MQTT publisher (%v, topic %v, format %v)", sink.Broker, sink.Topic, sink.Config.Format)
+}
+
+func (sink *MqttSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
+	client, err := sink.Config.connect(sink.Broker, nil)
+	if err != nil {
+		return golib.NewStoppedChan(err)
+	}
+	sink.client = client
+	log.WithField("format", sink.Config.Format).Println("Publishing to MQTT topic", sink.Topic, "on", sink.Broker)
+	return
+}
+
+func (sink *MqttSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	var buf bytes.Buffer
+	err := sink.Marshaller.WriteHeader(header, true, &buf)
+	if err == nil {
+		err = sink.Marshaller.WriteSample(sample, header, true, &buf)
+	}
+	if err == nil {
+		err = waitToken(sink.client.Publish(sink.Topic, byte(sink.Config.Qos), false, buf.Bytes()), sink.Config.ConnectTimeout)
+		if err != nil {
+			err = fmt.Errorf("%v: Failed to publish sample: %v", sink, err)
+		}
+	}
+	return sink.AbstractSampleOutput.Sample(err, sample, header)
+}
+
+func (sink *MqttSink) Close() {
+	if sink.client != nil {
+		sink.client.Disconnect(uint(sink.Config.ConnectTimeout / time.Millisecond))
+	}
+	sink.CloseSink()
+}
+
+// MqttSource subscribes to an MQTT topic and parses every received message into a sample. Every message
+// must contain a marshalled header followed by one marshalled sample, as published by MqttSink.
+// The subscription is renewed automatically after reconnecting to the broker.
+type MqttSource struct {
+	bitflow.AbstractSampleSource
+	Broker       string
+	Topic        string
+	Config       MqttConfig
+	Unmarshaller bitflow.Unmarshaller
+
+	client     paho.Client
+	stopped    golib.StopChan
+	lastHeader *bitflow.Header
+}
+
+func (source *MqttSource) String() string {
+	return fmt.Sprintf("MQTT subscription (%v, topic %v, format %v)", source.Broker, source.Topic, source.Config.Format)
+}
+
+func (source *MqttSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	source.stopped = golib.NewStopChan()
+	client, err := source.Config.connect(source.Broker, func(client paho.Client) {
+		// Renew the subscription after reconnecting
+		if err := source.subscribe(client); err != nil {
+			source.stopped.StopErr(err)
+		}
+	})
+	if err == nil {
+		// The initial subscription is done synchronously, so that no messages are missed after Start() returns
+		err = source.subscribe(client)
+	}
+	if err != nil {
+		if client != nil {
+			client.Disconnect(0)
+		}
+		source.CloseSinkParallel(wg)
+		return golib.NewStoppedChan(err)
+	}
+	source.client = client
+	log.WithField("format", source.Config.Format).Println("Subscribed to MQTT topic", source.Topic, "on", source.Broker)
+
+	return golib.WaitErrFunc(wg, func() error {
+		defer source.CloseSinkParallel(wg)
+		source.stopped.Wait()
+		source.client.Disconnect(uint(source.Config.ConnectTimeout / time.Millisecond))
+		return source.stopped.Err()
+	})
+}
+
+func (source *MqttSource) subscribe(client paho.Client) error {
+	token := client.Subscribe(source.Topic, byte(source.Config.Qos), source.handleMessage)
+	if err := waitToken(token, source.Config.ConnectTimeout); err != nil {
+		return fmt.Errorf("Failed to subscribe to MQTT topic %v: %v", source.Topic, err)
+	}
+	return nil
+}
+
+// handleMessage is called sequentially by the MQTT client, since the ordering of messages is enabled by default.
+func (source *MqttSource) handleMessage(_ paho.Client, msg paho.Message) {
+	if source.stopped.Stopped() {
+		return
+	}
+	sample, header, err := source.parseMessage(msg.Payload())
+	if err != nil {
+		log.WithField("topic", msg.Topic()).Warnln("Dropping invalid MQTT message:", err)
+		return
+	}
+	if err := source.GetSink().Sample(sample, header); err != nil {
+		source.stopped.StopErr(err)
+	}
+}
+
+func (source *MqttSource) parseMessage(payload []byte) (*bitflow.Sample, *bitflow.Header, error) {
+	reader := bufio.NewReader(bytes.NewReader(payload))
+	header, _, err := source.Unmarshaller.Read(reader, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header == nil {
+		return nil, nil, errors.New("Message does not start with a header")
+	}
+	_, data, err := source.Unmarshaller.Read(reader, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	sample, err := source.Unmarshaller.ParseSample(header, bitflow.RequiredValues(len(header.Fields), source.GetSink()), data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Reuse the previous header object, so that subsequent processing steps do not have to handle a changed header for every sample
+	if source.lastHeader == nil || !source.lastHeader.Equals(&header.Header) {
+		source.lastHeader = &bitflow.Header{Fields: header.Fields}
+	}
+	return sample, source.lastHeader, nil
+}
+
+func (source *MqttSource) Close() {
+	source.stopped.Stop()
+}
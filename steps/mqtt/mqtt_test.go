@@ -0,0 +1,133 @@
+package mqtt
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	broker "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+const testBrokerAddr = "localhost:18830"
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	lock    sync.Mutex
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.samples = append(s.samples, sample)
+	s.headers = append(s.headers, header)
+	return nil
+}
+
+func (s *collectingSink) numSamples() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.samples)
+}
+
+func TestMqttRoundTrip(t *testing.T) {
+	for _, format := range []bitflow.MarshallingFormat{bitflow.CsvFormat, bitflow.BinaryFormat} {
+		testMqttRoundTrip(t, format)
+	}
+}
+
+func testMqttRoundTrip(t *testing.T, format bitflow.MarshallingFormat) {
+	assert := testAssert.New(t)
+	server := broker.New()
+	assert.NoError(server.AddListener(listeners.NewTCP("test", testBrokerAddr), nil))
+	assert.NoError(server.Serve())
+	defer server.Close() // Drop error
+
+	endpoints := bitflow.NewEndpointFactory()
+	marshaller, err := endpoints.CreateMarshaller(format)
+	assert.NoError(err)
+	config := DefaultMqttConfig
+	config.Format = format
+
+	received := new(collectingSink)
+	source := &MqttSource{
+		Broker:       testBrokerAddr,
+		Topic:        "bitflow/test",
+		Config:       config,
+		Unmarshaller: marshaller.(bitflow.Unmarshaller),
+	}
+	source.SetSink(received)
+	var wg sync.WaitGroup
+	sourceStopped := source.Start(&wg)
+	assert.False(sourceStopped.Stopped(), "source stopped: %v", sourceStopped.Err())
+
+	sink := &MqttSink{
+		Broker:     testBrokerAddr,
+		Topic:      "bitflow/test",
+		Config:     config,
+		Marshaller: marshaller,
+	}
+	sink.SetSink(new(bitflow.DroppingSampleProcessor))
+	sink.Start(&wg)
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	now := time.Unix(1550000000, 0)
+	samples := make([]*bitflow.Sample, 3)
+	for i := range samples {
+		samples[i] = &bitflow.Sample{
+			Values: []bitflow.Value{bitflow.Value(i), bitflow.Value(i * 10)},
+			Time:   now.Add(time.Duration(i) * time.Second),
+		}
+		samples[i].SetTag("index", string(rune('a'+i)))
+		assert.NoError(sink.Sample(samples[i], header))
+	}
+
+	for start := time.Now(); received.numSamples() < len(samples) && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sink.Close()
+	source.Close()
+	wg.Wait()
+	assert.NoError(sourceStopped.Err())
+
+	assert.Len(received.samples, len(samples))
+	for i, sample := range received.samples {
+		assert.Equal(header.Fields, received.headers[i].Fields)
+		assert.Equal(samples[i].Values, sample.Values)
+		assert.True(samples[i].Time.Equal(sample.Time))
+		assert.Equal(samples[i].TagMap(), sample.TagMap())
+	}
+	if len(received.headers) == len(samples) {
+		assert.True(received.headers[0] == received.headers[2], "unchanged headers should be reused")
+	}
+}
+
+func TestParseMqttEndpoint(t *testing.T) {
+	assert := testAssert.New(t)
+	broker, topic, config, err := ParseMqttEndpoint("host/sensors/+/cpu?qos=2&client-id=abc&user=u&password=p&format=bin", DefaultMqttConfig)
+	assert.NoError(err)
+	assert.Equal("host:"+DefaultMqttPort, broker)
+	assert.Equal("sensors/+/cpu", topic)
+	assert.Equal(2, config.Qos)
+	assert.Equal("abc", config.ClientID)
+	assert.Equal("u", config.Username)
+	assert.Equal("p", config.Password)
+	assert.Equal(bitflow.BinaryFormat, config.Format)
+
+	broker, topic, config, err = ParseMqttEndpoint("host:1234/sensors/#", DefaultMqttConfig)
+	assert.NoError(err)
+	assert.Equal("host:1234", broker)
+	assert.Equal("sensors/#", topic)
+	assert.Equal(DefaultMqttConfig, config)
+
+	_, _, _, err = ParseMqttEndpoint("host", DefaultMqttConfig)
+	assert.Error(err)
+	_, _, _, err = ParseMqttEndpoint("host/topic?qos=3", DefaultMqttConfig)
+	assert.Error(err)
+	_, _, _, err = ParseMqttEndpoint("host/topic?unknown=1", DefaultMqttConfig)
+	assert.Error(err)
+}
@@ -3,6 +3,7 @@ package steps
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/bitflow-stream/go-bitflow/bitflow"
 	"github.com/bitflow-stream/go-bitflow/bitflow/fork"
@@ -19,14 +20,34 @@ func RegisterOutputFiles(b reg.ProcessorRegistry) {
 
 		var err error
 		parallelize := reg.IntParam(params, "parallelize", 0, true, &err)
+		formatTag := reg.StrParam(params, "format-tag", "", true, &err)
+		formatRules := reg.StrParam(params, "format-rules", "", true, &err)
+		maxOpenFiles := reg.IntParam(params, "max-open-files", 0, true, &err)
+		if err != nil {
+			return err
+		}
+		guard, err := parseCardinalityGuard(params)
 		if err != nil {
 			return err
 		}
 		delete(params, "parallelize")
+		delete(params, "format-tag")
+		delete(params, "format-rules")
+		delete(params, "max-open-files")
+		delete(params, "max-keys")
+		delete(params, "max-keys-policy")
+		rules, err := _parse_file_format_rules(formatRules)
+		if err != nil {
+			return reg.ParameterError("format-rules", err)
+		}
 
 		distributor, err := _make_multi_file_pipeline_builder(params)
 		if err == nil {
 			distributor.Template = filename
+			distributor.FormatTag = formatTag
+			distributor.Rules = rules
+			distributor.Guard = guard
+			distributor.MaxOpenFiles = maxOpenFiles
 			if parallelize > 0 {
 				distributor.ExtendSubpipelines = func(fileName string, pipe *bitflow.SamplePipeline) {
 					pipe.Add(&DecouplingProcessor{ChannelBuffer: parallelize})
@@ -37,7 +58,29 @@ func RegisterOutputFiles(b reg.ProcessorRegistry) {
 		return err
 	}
 
-	b.RegisterAnalysisParamsErr("output_files", create, "Output samples to multiple files, filenames are built from the given template, where placeholders like ${xxx} will be replaced with tag values")
+	b.RegisterAnalysisParamsErr("output_files", create, "Output samples to multiple files, filenames are built from the given template, where placeholders like ${xxx} will be replaced with tag values. "+
+		"The format of every file is derived from the file name, unless it matches one of the format-rules (glob1=format1,glob2=format2,...), or the samples have the format-tag. "+
+		"The number of files can be limited with max-keys and max-keys-policy (see -fork-max-keys). "+
+		"With max-open-files, at most the given number of files is kept open, the least recently used files are closed and reopened in append mode when needed.")
+}
+
+func _parse_file_format_rules(rules string) ([]fork.FileOutputRule, error) {
+	if rules == "" {
+		return nil, nil
+	}
+	var result []fork.FileOutputRule
+	for _, rule := range strings.Split(rules, ",") {
+		parts := strings.Split(rule, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("Format must be 'glob1=format1,glob2=format2,...'. Received: " + rules)
+		}
+		format := bitflow.MarshallingFormat(parts[1])
+		if _, err := bitflow.DefaultEndpointFactory.CreateMarshaller(format); err != nil {
+			return nil, err
+		}
+		result = append(result, fork.FileOutputRule{Pattern: parts[0], Format: format})
+	}
+	return result, nil
 }
 
 func _make_multi_file_pipeline_builder(params map[string]string) (*fork.MultiFileDistributor, error) {
@@ -0,0 +1,345 @@
+package steps
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultPrometheusBatchSize     = 500
+	DefaultPrometheusFlushInterval = 5 * time.Second
+	DefaultPrometheusTimeout       = 10 * time.Second
+)
+
+func RegisterPrometheusRemoteWrite(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("prometheus",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &PrometheusRemoteWrite{
+				Url:           reg.StrParam(params, "url", "", false, &err),
+				BatchSize:     reg.IntParam(params, "batch-size", DefaultPrometheusBatchSize, true, &err),
+				FlushInterval: reg.DurationParam(params, "flush-interval", DefaultPrometheusFlushInterval, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			if step.BatchSize < 1 {
+				return reg.ParameterError("batch-size", errors.New("Must be > 0"))
+			}
+			p.Add(step)
+			return
+		},
+		"Send metrics to the given Prometheus remote-write url. Every metric becomes a time series named after the header field, the sample tags become labels. "+
+			"Illegal characters in metric and label names are replaced with underscores. Samples are sent in batches of the given size (default "+fmt.Sprint(DefaultPrometheusBatchSize)+"), "+
+			"or after the flush-interval (default "+DefaultPrometheusFlushInterval.String()+"), whichever comes first. All samples are forwarded unchanged.",
+		reg.RequiredParams("url"), reg.OptionalParams("batch-size", "flush-interval"))
+}
+
+var (
+	prometheusIllegalMetricChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+	prometheusIllegalLabelChars  = regexp.MustCompile("[^a-zA-Z0-9_]")
+)
+
+const prometheusNameLabel = "__name__"
+
+// PrometheusRemoteWrite sends all metrics of the incoming samples to a Prometheus remote-write endpoint. The samples
+// are collected and sent as a single snappy-compressed protobuf WriteRequest when BatchSize samples are collected,
+// or when FlushInterval passed since the last request. Errors of requests triggered by the interval are only logged.
+type PrometheusRemoteWrite struct {
+	bitflow.NoopProcessor
+	Url           string
+	BatchSize     int
+	FlushInterval time.Duration
+	Client        http.Client
+
+	lock      sync.Mutex
+	series    []prometheusTimeSeries
+	numBuffer int
+	loop      golib.StopChan
+}
+
+type prometheusLabel struct {
+	name  string
+	value string
+}
+
+type prometheusTimeSeries struct {
+	labels    []prometheusLabel
+	value     float64
+	timestamp int64 // Milliseconds since the Unix epoch
+}
+
+func (p *PrometheusRemoteWrite) Start(wg *sync.WaitGroup) golib.StopChan {
+	if p.Client.Timeout == 0 {
+		p.Client.Timeout = DefaultPrometheusTimeout
+	}
+	p.loop = golib.NewStopChan()
+	if p.FlushInterval > 0 {
+		wg.Add(1)
+		go p.flushPeriodically(wg)
+	}
+	return p.NoopProcessor.Start(wg)
+}
+
+func (p *PrometheusRemoteWrite) flushPeriodically(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for p.loop.WaitTimeout(p.FlushInterval) {
+		p.lock.Lock()
+		err := p.flush()
+		p.lock.Unlock()
+		if err != nil {
+			log.Errorln(err)
+		}
+	}
+}
+
+func (p *PrometheusRemoteWrite) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", p, len(sample.Values), len(header.Fields))
+	}
+	p.lock.Lock()
+	p.add(sample, header)
+	var err error
+	if p.numBuffer >= p.BatchSize {
+		err = p.flush()
+	}
+	p.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *PrometheusRemoteWrite) Close() {
+	p.loop.Stop()
+	p.lock.Lock()
+	err := p.flush()
+	p.lock.Unlock()
+	if err != nil {
+		p.Error(err)
+	}
+	p.NoopProcessor.Close()
+}
+
+func (p *PrometheusRemoteWrite) add(sample *bitflow.Sample, header *bitflow.Header) {
+	tagLabels := prometheusTagLabels(sample)
+	timestamp := sample.Time.UnixNano() / int64(time.Millisecond)
+	for i, field := range header.Fields {
+		labels := make([]prometheusLabel, 0, len(tagLabels)+1)
+		labels = append(labels, prometheusLabel{name: prometheusNameLabel, value: PrometheusMetricName(field)})
+		labels = append(labels, tagLabels...)
+		sort.Slice(labels, func(a, b int) bool {
+			return labels[a].name < labels[b].name
+		})
+		p.series = append(p.series, prometheusTimeSeries{
+			labels:    labels,
+			value:     float64(sample.Values[i]),
+			timestamp: timestamp,
+		})
+	}
+	p.numBuffer++
+}
+
+func prometheusTagLabels(sample *bitflow.Sample) []prometheusLabel {
+	tags := sample.SortedTags()
+	labels := make([]prometheusLabel, 0, len(tags))
+	names := map[string]bool{prometheusNameLabel: true}
+	for _, tag := range tags {
+		name := PrometheusLabelName(tag.Key)
+		if names[name] {
+			continue // Drop tags that collide with another label after sanitizing the name
+		}
+		names[name] = true
+		labels = append(labels, prometheusLabel{name: name, value: tag.Value})
+	}
+	return labels
+}
+
+// PrometheusMetricName replaces all characters that are not allowed in Prometheus metric names with underscores.
+func PrometheusMetricName(name string) string {
+	return prometheusName(prometheusIllegalMetricChars.ReplaceAllLiteralString(name, "_"))
+}
+
+// PrometheusLabelName replaces all characters that are not allowed in Prometheus label names with underscores.
+func PrometheusLabelName(name string) string {
+	return prometheusName(prometheusIllegalLabelChars.ReplaceAllLiteralString(name, "_"))
+}
+
+func prometheusName(name string) string {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// flush must be called while holding the lock
+func (p *PrometheusRemoteWrite) flush() error {
+	if len(p.series) == 0 {
+		return nil
+	}
+	series, numSamples := p.series, p.numBuffer
+	p.series = nil
+	p.numBuffer = 0
+
+	body := snappyEncode(encodePrometheusWriteRequest(series))
+	req, err := http.NewRequest(http.MethodPost, p.Url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%v: %v", p, err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%v: Failed to send %v sample(s): %v", p, numSamples, err)
+	}
+	defer resp.Body.Close() // Drop error
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512)) // Drop error
+		return fmt.Errorf("%v: Failed to send %v sample(s): %v %s", p, numSamples, resp.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body) // Drop error, allow reusing the connection
+	return nil
+}
+
+func (p *PrometheusRemoteWrite) String() string {
+	return fmt.Sprintf("Prometheus remote-write to %v (batch size %v, flush interval %v)", p.Url, p.BatchSize, p.FlushInterval)
+}
+
+// ======================================= protobuf encoding =======================================
+
+// The following functions encode the WriteRequest message of the Prometheus remote-write protocol:
+//   WriteRequest { repeated TimeSeries timeseries = 1; }
+//   TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//   Label { string name = 1; string value = 2; }
+//   Sample { double value = 1; int64 timestamp = 2; }
+
+const (
+	protoVarint          = 0
+	protoFixed64         = 1
+	protoLengthDelimited = 2
+)
+
+func encodePrometheusWriteRequest(series []prometheusTimeSeries) []byte {
+	var res, seriesBuf, buf []byte
+	for _, s := range series {
+		seriesBuf = seriesBuf[:0]
+		for _, label := range s.labels {
+			buf = buf[:0]
+			buf = appendProtoBytes(buf, 1, []byte(label.name))
+			buf = appendProtoBytes(buf, 2, []byte(label.value))
+			seriesBuf = appendProtoBytes(seriesBuf, 1, buf)
+		}
+		buf = buf[:0]
+		buf = appendProtoKey(buf, 1, protoFixed64)
+		buf = appendFixed64(buf, math.Float64bits(s.value))
+		buf = appendProtoKey(buf, 2, protoVarint)
+		buf = appendUvarint(buf, uint64(s.timestamp))
+		seriesBuf = appendProtoBytes(seriesBuf, 2, buf)
+		res = appendProtoBytes(res, 1, seriesBuf)
+	}
+	return res
+}
+
+func appendProtoKey(buf []byte, field int, wireType int) []byte {
+	return appendUvarint(buf, uint64(field<<3|wireType))
+}
+
+func appendProtoBytes(buf []byte, field int, data []byte) []byte {
+	buf = appendProtoKey(buf, field, protoLengthDelimited)
+	buf = appendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func appendUvarint(buf []byte, val uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], val)
+	return append(buf, tmp[:n]...)
+}
+
+func appendFixed64(buf []byte, val uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], val)
+	return append(buf, tmp[:]...)
+}
+
+// ======================================= snappy encoding =======================================
+
+const (
+	snappyTableBits  = 14
+	snappyMaxOffset  = 1<<16 - 1
+	snappyMaxCopyLen = 64
+)
+
+// snappyEncode compresses the data in the snappy block format. Matches are found through a hash table
+// of 4-byte sequences and are encoded with 2-byte offsets.
+func snappyEncode(src []byte) []byte {
+	dst := appendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+	var table [1 << snappyTableBits]int32 // Positions of 4-byte sequences, +1 to distinguish empty entries
+	literalStart := 0
+	for i := 0; i+4 <= len(src); {
+		current := binary.LittleEndian.Uint32(src[i:])
+		hash := (current * 0x1e35a7bd) >> (32 - snappyTableBits)
+		candidate := int(table[hash]) - 1
+		table[hash] = int32(i + 1)
+		if candidate < 0 || i-candidate > snappyMaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != current {
+			i++
+			continue
+		}
+		length := 4
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = appendSnappyLiteral(dst, src[literalStart:i])
+		dst = appendSnappyCopy(dst, i-candidate, length)
+		i += length
+		literalStart = i
+	}
+	return appendSnappyLiteral(dst, src[literalStart:])
+}
+
+func appendSnappyLiteral(dst []byte, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+	n := uint32(len(literal) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n<<2))
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, literal...)
+}
+
+func appendSnappyCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > snappyMaxCopyLen {
+			n = snappyMaxCopyLen
+		}
+		dst = append(dst, byte((n-1)<<2|2), byte(offset), byte(offset>>8))
+		length -= n
+	}
+	return dst
+}
@@ -0,0 +1,281 @@
+package steps
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+// PrometheusScrapeEndpoint is the custom data sink type of PrometheusScrapeSink. The built-in 'http' endpoint type
+// already denotes the bitflow.HttpServerSink, which serves marshalled samples instead of the latest values.
+const PrometheusScrapeEndpoint = bitflow.EndpointType("prometheus")
+
+// DefaultPrometheusScrapeConfig contains the defaults for the -prometheus-* command line flags.
+var DefaultPrometheusScrapeConfig = PrometheusScrapeConfig{
+	Staleness: 5 * time.Minute,
+}
+
+// PrometheusScrapeConfig configures a PrometheusScrapeSink.
+type PrometheusScrapeConfig struct {
+	// IdentityTag selects the tag that identifies the entries of the exposed set: every new sample replaces the values
+	// of the previous sample with the same value of this tag. If empty, every distinct combination of tags is one entry.
+	IdentityTag string
+
+	// Entries that did not receive a sample within the Staleness duration are dropped from the exposed set. Zero disables the timeout.
+	Staleness time.Duration
+}
+
+// RegisterPrometheusScrapeEndpoint registers the 'prometheus' data sink. The endpoints have the form
+// prometheus://host:port/path?identity=tag&staleness=5m, where the host, the path (default /metrics) and the query parameters are optional.
+// The query parameters override the values set through the -prometheus-* command line flags.
+func RegisterPrometheusScrapeEndpoint(b reg.ProcessorRegistry) {
+	config := DefaultPrometheusScrapeConfig
+	b.Endpoints.CustomOutputFlags = append(b.Endpoints.CustomOutputFlags, func(f *flag.FlagSet) {
+		f.StringVar(&config.IdentityTag, "prometheus-identity", config.IdentityTag, "Tag identifying the series exposed by prometheus:// outputs. By default, every distinct tag combination is exposed.")
+		f.DurationVar(&config.Staleness, "prometheus-staleness", config.Staleness, "Drop series from prometheus:// outputs that did not receive samples for the given duration. Zero disables the timeout.")
+	})
+	b.Endpoints.CustomDataSinks[PrometheusScrapeEndpoint] = func(target string) (bitflow.SampleProcessor, error) {
+		endpoint, path, endpointConfig, err := ParsePrometheusScrapeEndpoint(target, config)
+		if err != nil {
+			return nil, err
+		}
+		return &PrometheusScrapeSink{
+			Endpoint: endpoint,
+			Path:     path,
+			Config:   endpointConfig,
+		}, nil
+	}
+}
+
+// ParsePrometheusScrapeEndpoint parses an endpoint target in the form host:port/path?param=value. The host and path are optional.
+// The query parameters identity and staleness override the values in the given config.
+func ParsePrometheusScrapeEndpoint(target string, config PrometheusScrapeConfig) (endpoint string, path string, _ PrometheusScrapeConfig, err error) {
+	endpoint, path = target, "/metrics"
+	if index := strings.IndexByte(target, '?'); index >= 0 {
+		var query url.Values
+		query, err = url.ParseQuery(target[index+1:])
+		if err != nil {
+			return
+		}
+		endpoint = target[:index]
+		for key, values := range query {
+			value := values[len(values)-1]
+			switch key {
+			case "identity":
+				config.IdentityTag = value
+			case "staleness":
+				config.Staleness, err = time.ParseDuration(value)
+			default:
+				err = fmt.Errorf("Unknown %v endpoint parameter: %v", PrometheusScrapeEndpoint, key)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	if index := strings.IndexByte(endpoint, '/'); index >= 0 {
+		endpoint, path = endpoint[:index], endpoint[index:]
+	}
+	if _, _, splitErr := net.SplitHostPort(endpoint); splitErr != nil {
+		err = fmt.Errorf("Prometheus endpoint must have the form host:port/path, received: %v", target)
+	} else if config.Staleness < 0 {
+		err = fmt.Errorf("The Prometheus staleness timeout must not be negative, received %v", config.Staleness)
+	}
+	return endpoint, path, config, err
+}
+
+// PrometheusScrapeSink starts an HTTP server on Endpoint, which serves the latest values of the incoming samples on Path
+// in the Prometheus text exposition format, so it can be used as a Prometheus scrape target. The sink keeps the last sample
+// of every identity (see PrometheusScrapeConfig.IdentityTag). Every header field becomes a metric named after the field, and the
+// tags of the sample become labels, with illegal characters replaced like in PrometheusRemoteWrite. Entries that did not
+// receive a sample within Config.Staleness are removed. The exposed values do not carry timestamps, so Prometheus assigns the scrape time.
+type PrometheusScrapeSink struct {
+	bitflow.AbstractSampleOutput
+	Endpoint string
+	Path     string
+	Config   PrometheusScrapeConfig
+
+	listener net.Listener
+	server   *http.Server
+	stopped  golib.StopChan
+
+	lock        sync.Mutex
+	entries     map[string]*prometheusScrapeEntry
+	lastExpired time.Time
+}
+
+type prometheusScrapeEntry struct {
+	labels  []prometheusLabel
+	fields  []string
+	values  []bitflow.Value
+	updated time.Time
+}
+
+func (sink *PrometheusScrapeSink) String() string {
+	res := fmt.Sprintf("Prometheus scrape target on %v%v", sink.Endpoint, sink.Path)
+	if sink.Config.IdentityTag != "" {
+		res += fmt.Sprintf(" (identity tag %v)", sink.Config.IdentityTag)
+	}
+	return res
+}
+
+func (sink *PrometheusScrapeSink) Start(wg *sync.WaitGroup) golib.StopChan {
+	listener, err := net.Listen("tcp", sink.Endpoint)
+	if err != nil {
+		return golib.NewStoppedChan(fmt.Errorf("%v: Failed to listen: %v", sink, err))
+	}
+	sink.listener = listener
+	sink.stopped = golib.NewStopChan()
+	sink.entries = make(map[string]*prometheusScrapeEntry)
+	mux := http.NewServeMux()
+	mux.HandleFunc(sink.Path, sink.handleScrape)
+	sink.server = &http.Server{Handler: mux}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := sink.server.Serve(listener); err != http.ErrServerClosed {
+			sink.stopped.StopErr(fmt.Errorf("%v: %v", sink, err))
+		}
+	}()
+	log.Println("Serving Prometheus metrics on", listener.Addr(), "path", sink.Path)
+	return sink.stopped
+}
+
+func (sink *PrometheusScrapeSink) Close() {
+	sink.stopped.StopFunc(func() {
+		if err := sink.server.Close(); err != nil {
+			log.Errorf("%v: Error closing HTTP server: %v", sink, err)
+		}
+		sink.CloseSink()
+	})
+}
+
+func (sink *PrometheusScrapeSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	var err error
+	if len(sample.Values) != len(header.Fields) {
+		err = fmt.Errorf("%v: Sample has %v values, but header has %v fields", sink, len(sample.Values), len(header.Fields))
+	} else {
+		sink.update(sample, header)
+	}
+	return sink.AbstractSampleOutput.Sample(err, sample, header)
+}
+
+func (sink *PrometheusScrapeSink) update(sample *bitflow.Sample, header *bitflow.Header) {
+	labels := prometheusTagLabels(sample)
+	var key string
+	if sink.Config.IdentityTag != "" {
+		key = sample.Tag(sink.Config.IdentityTag)
+	} else {
+		key = formatPrometheusLabels(labels)
+	}
+	values := make([]bitflow.Value, len(sample.Values))
+	copy(values, sample.Values)
+	now := time.Now()
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	sink.entries[key] = &prometheusScrapeEntry{
+		labels:  labels,
+		fields:  header.Fields,
+		values:  values,
+		updated: now,
+	}
+	if sink.Config.Staleness > 0 && now.Sub(sink.lastExpired) >= sink.Config.Staleness {
+		// Also expire entries here, so the entries do not accumulate if the metrics are never scraped
+		sink.expire(now)
+	}
+}
+
+// expire must be called while holding the lock
+func (sink *PrometheusScrapeSink) expire(now time.Time) {
+	sink.lastExpired = now
+	if sink.Config.Staleness <= 0 {
+		return
+	}
+	for key, entry := range sink.entries {
+		if now.Sub(entry.updated) > sink.Config.Staleness {
+			delete(sink.entries, key)
+		}
+	}
+}
+
+func (sink *PrometheusScrapeSink) handleScrape(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET requests are supported", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := w.Write(sink.render()); err != nil {
+		log.Warnf("%v: Error sending metrics to %v: %v", sink, r.RemoteAddr, err)
+	}
+}
+
+// render formats the current entries in the Prometheus text exposition format. All series of one metric are
+// grouped under one TYPE line, and both the metrics and the series are sorted for a deterministic output.
+func (sink *PrometheusScrapeSink) render() []byte {
+	sink.lock.Lock()
+	sink.expire(time.Now())
+	keys := make([]string, 0, len(sink.entries))
+	for key := range sink.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	series := make(map[string][]string)
+	for _, key := range keys {
+		entry := sink.entries[key]
+		labels := formatPrometheusLabels(entry.labels)
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		names := make(map[string]bool, len(entry.fields))
+		for i, field := range entry.fields {
+			name := PrometheusMetricName(field)
+			if names[name] {
+				continue // Drop fields that collide with another field after sanitizing the name
+			}
+			names[name] = true
+			value := strconv.FormatFloat(float64(entry.values[i]), 'g', -1, 64)
+			series[name] = append(series[name], name+labels+" "+value)
+		}
+	}
+	sink.lock.Unlock()
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "# TYPE %v untyped\n", name)
+		for _, line := range series[name] {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+var prometheusLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatPrometheusLabels(labels []prometheusLabel) string {
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = label.name + `="` + prometheusLabelValueEscaper.Replace(label.value) + `"`
+	}
+	return strings.Join(parts, ",")
+}
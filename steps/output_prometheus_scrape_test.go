@@ -0,0 +1,93 @@
+package steps
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestParsePrometheusScrapeEndpoint(t *testing.T) {
+	assert := testAssert.New(t)
+	endpoint, path, config, err := ParsePrometheusScrapeEndpoint(":9100", DefaultPrometheusScrapeConfig)
+	assert.NoError(err)
+	assert.Equal(":9100", endpoint)
+	assert.Equal("/metrics", path)
+	assert.Equal(DefaultPrometheusScrapeConfig, config)
+
+	endpoint, path, config, err = ParsePrometheusScrapeEndpoint("localhost:9100/data?identity=host&staleness=10s", DefaultPrometheusScrapeConfig)
+	assert.NoError(err)
+	assert.Equal("localhost:9100", endpoint)
+	assert.Equal("/data", path)
+	assert.Equal(PrometheusScrapeConfig{IdentityTag: "host", Staleness: 10 * time.Second}, config)
+
+	for _, invalid := range []string{"", "/metrics", "localhost/metrics", ":9100?staleness=-1s", ":9100?unknown=1"} {
+		_, _, _, err = ParsePrometheusScrapeEndpoint(invalid, DefaultPrometheusScrapeConfig)
+		assert.Error(err, "endpoint %v", invalid)
+	}
+}
+
+func TestPrometheusScrapeSink(t *testing.T) {
+	assert := testAssert.New(t)
+	sink := &PrometheusScrapeSink{
+		Endpoint: "127.0.0.1:0",
+		Path:     "/metrics",
+		Config:   PrometheusScrapeConfig{IdentityTag: "host", Staleness: time.Minute},
+	}
+	sink.SetSink(new(bitflow.DroppingSampleProcessor))
+	var wg sync.WaitGroup
+	stopped := sink.Start(&wg)
+	assert.NoError(stopped.Err())
+
+	scrape := func() string {
+		resp, err := http.Get("http://" + sink.listener.Addr().String() + "/metrics")
+		assert.NoError(err)
+		defer resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(err)
+		return string(body)
+	}
+	sample := func(host string, values ...bitflow.Value) *bitflow.Sample {
+		s := &bitflow.Sample{Time: time.Now(), Values: values}
+		s.SetTag("host", host)
+		s.SetTag("job", `a"b`)
+		return s
+	}
+	assert.Equal("", scrape())
+
+	header := &bitflow.Header{Fields: []string{"cpu", "mem.used"}}
+	assert.NoError(sink.Sample(sample("h1", 1, 2), header))
+	assert.NoError(sink.Sample(sample("h2", 3, 4), header))
+	assert.NoError(sink.Sample(sample("h1", 5, 6), header)) // Replaces the first sample
+	assert.Equal(`# TYPE cpu untyped
+cpu{host="h1",job="a\"b"} 5
+cpu{host="h2",job="a\"b"} 3
+# TYPE mem_used untyped
+mem_used{host="h1",job="a\"b"} 6
+mem_used{host="h2",job="a\"b"} 4
+`, scrape())
+
+	// Stale entries are dropped
+	sink.lock.Lock()
+	sink.entries["h2"].updated = time.Now().Add(-2 * time.Minute)
+	sink.lock.Unlock()
+	assert.Equal(`# TYPE cpu untyped
+cpu{host="h1",job="a\"b"} 5
+# TYPE mem_used untyped
+mem_used{host="h1",job="a\"b"} 6
+`, scrape())
+
+	resp, err := http.Post("http://"+sink.listener.Addr().String()+"/metrics", "text/plain", nil)
+	assert.NoError(err)
+	assert.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.NoError(resp.Body.Close())
+
+	sink.Close()
+	wg.Wait()
+	assert.NoError(stopped.Err())
+}
@@ -0,0 +1,155 @@
+package steps
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+// _snappyDecode decodes the subset of the snappy block format produced by snappyEncode
+func _snappyDecode(t *testing.T, src []byte) []byte {
+	assert := testAssert.New(t)
+	size, n := binary.Uvarint(src)
+	assert.True(n > 0)
+	src = src[n:]
+	var dst []byte
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 3 {
+		case 0:
+			length := int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				numBytes := length - 59
+				length = 0
+				for i := 0; i < numBytes; i++ {
+					length |= int(src[i]) << (8 * uint(i))
+				}
+				src = src[numBytes:]
+			}
+			length++
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+		case 2:
+			length := int(tag>>2) + 1
+			offset := int(src[1]) | int(src[2])<<8
+			assert.True(offset > 0 && offset <= len(dst), "invalid offset %v", offset)
+			for i := 0; i < length; i++ {
+				dst = append(dst, dst[len(dst)-offset])
+			}
+			src = src[3:]
+		default:
+			assert.Fail("Unexpected snappy tag", "%v", tag)
+			return nil
+		}
+	}
+	assert.Equal(int(size), len(dst))
+	return dst
+}
+
+func TestSnappyEncode(t *testing.T) {
+	assert := testAssert.New(t)
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	repeated := make([]byte, 0, 70000)
+	for len(repeated) < 70000 {
+		repeated = append(repeated, "cpu_usage{host=\"a\"} "...)
+	}
+	inputs := [][]byte{nil, []byte("abc"), random, repeated, append(append(random, repeated...), random...)}
+	for i, input := range inputs {
+		encoded := snappyEncode(input)
+		assert.Equal(string(input), string(_snappyDecode(t, encoded)), "input %v", i)
+	}
+	assert.True(len(snappyEncode(repeated)) < len(repeated)/10)
+}
+
+func TestPrometheusWriteRequestEncoding(t *testing.T) {
+	assert := testAssert.New(t)
+	encoded := encodePrometheusWriteRequest([]prometheusTimeSeries{
+		{labels: []prometheusLabel{{name: "a", value: "b"}}, value: 1, timestamp: 2},
+	})
+	assert.Equal([]byte{
+		0x0a, 21, // WriteRequest.timeseries
+		0x0a, 6, 0x0a, 1, 'a', 0x12, 1, 'b', // TimeSeries.labels
+		0x12, 11, 0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, 0x10, 2, // TimeSeries.samples
+	}, encoded)
+}
+
+func TestPrometheusNames(t *testing.T) {
+	assert := testAssert.New(t)
+	assert.Equal("cpu_usage:total", PrometheusMetricName("cpu/usage:total"))
+	assert.Equal("_1xx", PrometheusMetricName("1xx"))
+	assert.Equal("net_in_eth0", PrometheusLabelName("net-in:eth0"))
+	assert.Equal("_", PrometheusLabelName(""))
+}
+
+func TestPrometheusRemoteWrite(t *testing.T) {
+	assert := testAssert.New(t)
+	var lock sync.Mutex
+	var requests [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal("application/x-protobuf", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(err)
+		lock.Lock()
+		requests = append(requests, _snappyDecode(t, body))
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	step := &PrometheusRemoteWrite{Url: server.URL, BatchSize: 2}
+	out := new(testSampleCollector)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"cpu/total", "mem"}}
+	timestamp := time.Unix(100, 5e6)
+	for i := 0; i < 3; i++ {
+		sample := &bitflow.Sample{Time: timestamp, Values: []bitflow.Value{bitflow.Value(i), 10}}
+		sample.SetTag("host-name", "a")
+		sample.SetTag("host_name", "b") // Collides with the previous tag after sanitizing
+		assert.NoError(step.Sample(sample, header))
+	}
+	assert.Len(out.samples, 3)
+	assert.Len(requests, 1)
+	step.Close()
+	assert.Len(requests, 2, "the remaining sample should be sent when closing")
+
+	series := func(name string, value float64) prometheusTimeSeries {
+		return prometheusTimeSeries{
+			labels:    []prometheusLabel{{name: "__name__", value: name}, {name: "host_name", value: "a"}},
+			value:     value,
+			timestamp: 100005,
+		}
+	}
+	assert.Equal(encodePrometheusWriteRequest([]prometheusTimeSeries{
+		series("cpu_total", 0), series("mem", 10), series("cpu_total", 1), series("mem", 10),
+	}), requests[0])
+	assert.Equal(encodePrometheusWriteRequest([]prometheusTimeSeries{
+		series("cpu_total", 2), series("mem", 10),
+	}), requests[1])
+}
+
+func TestPrometheusRemoteWriteError(t *testing.T) {
+	assert := testAssert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	step := &PrometheusRemoteWrite{Url: server.URL, BatchSize: 1}
+	step.SetSink(new(testSampleCollector))
+	step.Start(new(sync.WaitGroup))
+	err := step.Sample(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"a"}})
+	assert.Error(err)
+	assert.Contains(err.Error(), "out of order sample")
+}
@@ -0,0 +1,257 @@
+package parquet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+const (
+	ParquetEndpoint = bitflow.EndpointType("parquet")
+
+	// The first two columns of every Parquet file store the timestamp (nanoseconds since the Unix epoch) and the
+	// tags (as returned by Sample.TagString()). All following columns store the metric values.
+	TimeColumn = "time"
+	TagsColumn = "tags"
+
+	DefaultParallelWriters = 4
+)
+
+func RegisterParquetEndpoints(b reg.ProcessorRegistry) {
+	b.Endpoints.CustomDataSources[ParquetEndpoint] = func(target string) (bitflow.SampleSource, error) {
+		return &ParquetSource{FileNames: []string{target}}, nil
+	}
+	b.Endpoints.CustomDataSinks[ParquetEndpoint] = func(target string) (bitflow.SampleProcessor, error) {
+		return new(bitflow.BatchProcessor).Add(&ParquetBatchWriter{Filename: target}), nil
+	}
+}
+
+func RegisterParquetOutput(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("parquet",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			w := &ParquetBatchWriter{
+				Filename:        reg.StrParam(params, "file", "", false, &err),
+				ParallelWriters: reg.IntParam(params, "parallel", DefaultParallelWriters, true, &err),
+			}
+			if err == nil {
+				p.Batch(w)
+			}
+			return
+		},
+		"Write every batch of samples to a separate Parquet file. The file names are built by adding an incrementing suffix to the given file name. The samples are forwarded unchanged.",
+		reg.RequiredParams("file"), reg.OptionalParams("parallel"), reg.EnforceBatch())
+}
+
+// ParquetBatchWriter is a BatchProcessingStep that writes every batch into a new Parquet file.
+// Every metric is stored in a DOUBLE column, the timestamp and the tags are stored in two additional leading
+// columns. The first file is named like the Filename field, the following files receive an incrementing
+// suffix (see bitflow.FileGroup).
+type ParquetBatchWriter struct {
+	Filename        string
+	ParallelWriters int
+
+	group     *bitflow.FileGroup
+	fileNum   int
+	groupLock sync.Mutex
+}
+
+func (w *ParquetBatchWriter) String() string {
+	return fmt.Sprintf("Parquet batch output (%v)", w.Filename)
+}
+
+func (w *ParquetBatchWriter) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	file, err := w.openNextFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = WriteParquet(file, header, samples, w.ParallelWriters)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error writing Parquet file %v: %v", file.Name(), err)
+	}
+	log.Printf("Wrote %v samples with %v metrics to %v", len(samples), len(header.Fields), file.Name())
+	return header, samples, nil
+}
+
+func (w *ParquetBatchWriter) openNextFile() (*os.File, error) {
+	w.groupLock.Lock()
+	defer w.groupLock.Unlock()
+	if w.group == nil {
+		group := bitflow.NewFileGroup(w.Filename)
+		w.group = &group
+	}
+	return w.group.OpenNewFile(&w.fileNum)
+}
+
+// WriteParquet writes the given samples to the given file in the Parquet format.
+func WriteParquet(file *os.File, header *bitflow.Header, samples []*bitflow.Sample, parallelWriters int) error {
+	if parallelWriters <= 0 {
+		parallelWriters = 1
+	}
+	schema := make([]string, 0, len(header.Fields)+2)
+	schema = append(schema,
+		"name="+TimeColumn+", type=INT64",
+		"name="+TagsColumn+", type=UTF8")
+	for _, field := range header.Fields {
+		schema = append(schema, "name="+field+", type=DOUBLE")
+	}
+
+	pw, err := writer.NewCSVWriter(schema, writerfile.NewWriterFile(file), int64(parallelWriters))
+	if err != nil {
+		return err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, sample := range samples {
+		if len(sample.Values) != len(header.Fields) {
+			return fmt.Errorf("Sample has %v values, but the header has %v fields", len(sample.Values), len(header.Fields))
+		}
+		row := make([]interface{}, 0, len(sample.Values)+2)
+		row = append(row, sample.Time.UnixNano(), sample.TagString())
+		for _, value := range sample.Values {
+			row = append(row, float64(value))
+		}
+		if err := pw.Write(row); err != nil {
+			return err
+		}
+	}
+	return pw.WriteStop()
+}
+
+// ReadParquet reads all samples from a Parquet file written by WriteParquet.
+func ReadParquet(filename string) (*bitflow.Header, []*bitflow.Sample, error) {
+	file, err := local.NewLocalFileReader(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close() // Drop error
+
+	pr, err := reader.NewParquetColumnReader(file, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer pr.ReadStop()
+
+	// The schema elements are renamed to Go identifiers, the original column names are stored as external names.
+	// The first element is the schema root.
+	columns := make([]string, len(pr.SchemaHandler.Infos)-1)
+	for i, info := range pr.SchemaHandler.Infos[1:] {
+		columns[i] = info.ExName
+	}
+	if len(columns) < 2 || columns[0] != TimeColumn || columns[1] != TagsColumn {
+		return nil, nil, fmt.Errorf("Parquet file %v does not start with the columns '%v' and '%v'", filename, TimeColumn, TagsColumn)
+	}
+	header := &bitflow.Header{Fields: columns[2:]}
+
+	numRows := pr.GetNumRows()
+	samples := make([]*bitflow.Sample, numRows)
+	for i := range samples {
+		samples[i] = &bitflow.Sample{Values: make([]bitflow.Value, len(header.Fields))}
+	}
+	for col := range columns {
+		values, _, _, err := pr.ReadColumnByIndex(int64(col), numRows)
+		if err != nil {
+			return nil, nil, err
+		}
+		if int64(len(values)) != numRows {
+			return nil, nil, fmt.Errorf("Column %v contains %v values instead of %v", columns[col], len(values), numRows)
+		}
+		for row, value := range values {
+			if err := setParquetValue(samples[row], col, value); err != nil {
+				return nil, nil, fmt.Errorf("Column %v, row %v: %v", columns[col], row, err)
+			}
+		}
+	}
+	return header, samples, nil
+}
+
+func setParquetValue(sample *bitflow.Sample, col int, value interface{}) error {
+	switch col {
+	case 0:
+		nanos, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("Expected int64 timestamp, but received %T", value)
+		}
+		sample.Time = time.Unix(0, nanos)
+	case 1:
+		tags, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("Expected string tags, but received %T", value)
+		}
+		return sample.ParseTagString(tags)
+	default:
+		val, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("Expected float64 value, but received %T", value)
+		}
+		sample.Values[col-2] = bitflow.Value(val)
+	}
+	return nil
+}
+
+// ParquetSource reads all samples from a list of Parquet files, one file after the other.
+type ParquetSource struct {
+	bitflow.AbstractSampleSource
+	FileNames []string
+
+	closed golib.StopChan
+}
+
+func (source *ParquetSource) String() string {
+	if len(source.FileNames) == 1 {
+		return fmt.Sprintf("ParquetSource(%v)", source.FileNames[0])
+	} else {
+		return fmt.Sprintf("ParquetSource(%v files)", len(source.FileNames))
+	}
+}
+
+func (source *ParquetSource) Start(wg *sync.WaitGroup) golib.StopChan {
+	source.closed = golib.NewStopChan()
+	if len(source.FileNames) == 0 {
+		source.CloseSinkParallel(wg)
+		return golib.NewStoppedChan(errors.New("No files specified for ParquetSource"))
+	}
+	return golib.WaitErrFunc(wg, func() error {
+		defer source.CloseSinkParallel(wg)
+		for _, filename := range source.FileNames {
+			if err := source.readFile(filename); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (source *ParquetSource) readFile(filename string) error {
+	header, samples, err := ReadParquet(filename)
+	if err != nil {
+		return fmt.Errorf("Error reading Parquet file %v: %v", filename, err)
+	}
+	log.WithField("file", filename).Println("Read", len(samples), "samples with", len(header.Fields), "metrics")
+	for _, sample := range samples {
+		if source.closed.Stopped() {
+			return nil
+		}
+		if err := source.GetSink().Sample(sample, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (source *ParquetSource) Close() {
+	source.closed.Stop()
+}
@@ -0,0 +1,69 @@
+package parquet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-parquet-test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+
+	header := &bitflow.Header{Fields: []string{"cpu", "mem", "net-in"}}
+	now := time.Unix(1550000000, 123456789)
+	samples := make([]*bitflow.Sample, 10)
+	for i := range samples {
+		samples[i] = &bitflow.Sample{
+			Time:   now.Add(time.Duration(i) * time.Second),
+			Values: []bitflow.Value{bitflow.Value(i), bitflow.Value(i) * 1.5, -bitflow.Value(i)},
+		}
+		samples[i].SetTag("index", strconv.Itoa(i))
+		if i%2 == 0 {
+			samples[i].SetTag("even", "yes")
+		}
+	}
+
+	w := &ParquetBatchWriter{Filename: filepath.Join(dir, "batch.parquet")}
+	outHeader, outSamples, err := w.ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal(header, outHeader)
+	assert.Equal(samples, outSamples)
+	_, _, err = w.ProcessBatch(header, samples[:3])
+	assert.NoError(err)
+
+	readHeader, readSamples, err := ReadParquet(filepath.Join(dir, "batch.parquet"))
+	assert.NoError(err)
+	assert.Equal(header.Fields, readHeader.Fields)
+	assert.Len(readSamples, len(samples))
+	for i, sample := range readSamples {
+		assert.True(samples[i].Time.Equal(sample.Time))
+		assert.Equal(samples[i].Values, sample.Values)
+		assert.Equal(samples[i].TagMap(), sample.TagMap())
+	}
+
+	// The second batch was written to a separate file
+	_, readSamples, err = ReadParquet(filepath.Join(dir, "batch-1.parquet"))
+	assert.NoError(err)
+	assert.Len(readSamples, 3)
+}
+
+func TestParquetWrongValueCount(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-parquet-test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+
+	w := &ParquetBatchWriter{Filename: filepath.Join(dir, "batch.parquet")}
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	_, _, err = w.ProcessBatch(header, []*bitflow.Sample{{Values: []bitflow.Value{1}}})
+	assert.Error(err)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"image/color"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,6 +32,9 @@ const (
 	PlotWidth  = 20 * vg.Centimeter
 	PlotHeight = PlotWidth
 
+	// PairwiseTileSize is the width and height of every plot in the grid created by PlotProcessor.PairwiseMatrix
+	PairwiseTileSize = 8 * vg.Centimeter
+
 	numColors      = 100
 	plotTimeFormat = "02.01.2006 15:04:05"
 	plotTimeLabel  = "time"
@@ -45,6 +50,29 @@ const (
 
 type PlotType uint
 
+const (
+	// ScatterMode plots the metrics selected by the X and Y axis configuration against each other.
+	ScatterMode = PlotMode(iota)
+
+	// LineMode plots every selected metric as a line over the sample timestamps.
+	LineMode
+	InvalidPlotMode
+)
+
+type PlotMode uint
+
+// ParsePlotMode parses the name of a PlotMode, which is either 'scatter' or 'line'.
+func ParsePlotMode(mode string) (PlotMode, error) {
+	switch mode {
+	case "scatter":
+		return ScatterMode, nil
+	case "line":
+		return LineMode, nil
+	default:
+		return InvalidPlotMode, fmt.Errorf("Unknown plot mode '%v', must be either 'scatter' or 'line'", mode)
+	}
+}
+
 type PlotProcessor struct {
 	bitflow.NoopProcessor
 	checker bitflow.HeaderChecker
@@ -58,6 +86,20 @@ type PlotProcessor struct {
 	ColorTag        string
 	SeparatePlots   bool // If true, every ColorTag value will create a new plot
 
+	// If not empty, select the metrics for the respective axis by name, overriding AxisX and AxisY
+	AxisXName string
+	AxisYName string
+
+	// If true, a grid of plots for all pairs of metrics is created in a single image. The diagonal of the grid
+	// plots every metric over time. The axis configuration is ignored in this mode.
+	PairwiseMatrix bool
+
+	// In LineMode, every metric in Metrics (or every metric in the header, if empty) is plotted as a line over time.
+	// Every value of ColorTag creates a separate line for every metric. With SeparatePlots, every value of ColorTag
+	// creates a new plot containing the lines of all metrics. The axis configuration is ignored in this mode.
+	Mode    PlotMode
+	Metrics []string
+
 	// If not nil, will override the automatically suggested bounds for the respective axis
 	ForceXmin *float64
 	ForceXmax *float64
@@ -68,6 +110,13 @@ type PlotProcessor struct {
 	radiuses     map[string][]float64
 	x, y, radius int
 	xName, yName string
+
+	matrix       map[string][][]float64
+	matrixFields []string
+
+	lines       map[string]map[string]plotter.XYs
+	lineIndices []int
+	lineMetrics []string
 }
 
 func (p *PlotProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
@@ -80,11 +129,22 @@ func (p *PlotProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
 	if p.AxisX < minAxis || p.AxisY < minAxis {
 		return golib.NewStoppedChan(fmt.Errorf("Invalid plot axis values: X=%v Y=%v", p.AxisX, p.AxisY))
 	}
+	if p.Mode >= InvalidPlotMode {
+		return golib.NewStoppedChan(fmt.Errorf("Invalid PlotMode: %v", p.Mode))
+	}
+	if p.Mode == LineMode && (p.Type == ClusterPlot || p.Type == BoxPlot || p.PairwiseMatrix) {
+		return golib.NewStoppedChan(errors.New("Line mode plots cannot be combined with cluster, box or pairwise matrix plots"))
+	}
+	if p.PairwiseMatrix && (p.Type == ClusterPlot || p.Type == BoxPlot || p.SeparatePlots) {
+		return golib.NewStoppedChan(errors.New("Pairwise matrix plots can only be combined with scatter and line plots, and not with separate plots"))
+	}
 	if p.needsRadius() && (p.RadiusDimension < 0 || p.RadiusDimension == p.AxisX || p.RadiusDimension == p.AxisY) {
 		return golib.NewStoppedChan(fmt.Errorf("Invalid cluster plot axis values: X=%v Y=%v Radius=%v", p.AxisX, p.AxisY, p.RadiusDimension))
 	}
 	p.data = make(map[string]plotter.XYs)
 	p.radiuses = make(map[string][]float64)
+	p.matrix = make(map[string][][]float64)
+	p.lines = make(map[string]map[string]plotter.XYs)
 
 	if file, err := os.Create(p.OutputFile); err != nil {
 		// Check if file can be created to quickly fail
@@ -106,9 +166,33 @@ func (p *PlotProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) e
 }
 
 func (p *PlotProcessor) headerChanged(header *bitflow.Header) error {
+	if p.Mode == LineMode {
+		return p.lineHeaderChanged(header)
+	}
+	if p.PairwiseMatrix {
+		if p.matrixFields == nil {
+			p.matrixFields = header.Fields
+		} else if !golib.EqualStrings(p.matrixFields, header.Fields) {
+			return fmt.Errorf("%v: Header updated and changed the metrics from %v -> %v", p, p.matrixFields, header.Fields)
+		}
+		return nil
+	}
+
 	p.x = p.AxisX
 	p.y = p.AxisY
 	p.radius = p.RadiusDimension
+	if p.AxisXName != "" {
+		p.x = p.fieldIndex(header, p.AxisXName)
+		if p.x < 0 {
+			return fmt.Errorf("%v: Metric for the X axis not found in header: %v", p, p.AxisXName)
+		}
+	}
+	if p.AxisYName != "" {
+		p.y = p.fieldIndex(header, p.AxisYName)
+		if p.y < 0 {
+			return fmt.Errorf("%v: Metric for the Y axis not found in header: %v", p, p.AxisYName)
+		}
+	}
 	if p.x == PlotAxisAuto {
 		if len(header.Fields) > 1 {
 			p.x = 0
@@ -123,6 +207,10 @@ func (p *PlotProcessor) headerChanged(header *bitflow.Header) error {
 			p.y = 0
 		}
 	}
+	if p.x >= 0 && p.x == p.y {
+		// Plotting a metric against itself is not useful, plot its values over time instead
+		p.x = PlotAxisTime
+	}
 
 	max := p.x
 	if p.y > p.x {
@@ -144,7 +232,7 @@ func (p *PlotProcessor) headerChanged(header *bitflow.Header) error {
 		xName = plotTimeLabel
 	}
 	if p.y == PlotAxisNum {
-		xName = plotNumLabel
+		yName = plotNumLabel
 	} else if p.y >= 0 {
 		yName = header.Fields[p.y]
 	} else {
@@ -160,6 +248,30 @@ func (p *PlotProcessor) headerChanged(header *bitflow.Header) error {
 	return nil
 }
 
+func (p *PlotProcessor) lineHeaderChanged(header *bitflow.Header) error {
+	p.lineMetrics = p.Metrics
+	if len(p.lineMetrics) == 0 {
+		p.lineMetrics = header.Fields
+	}
+	p.lineIndices = make([]int, len(p.lineMetrics))
+	for i, metric := range p.lineMetrics {
+		p.lineIndices[i] = p.fieldIndex(header, metric)
+		if p.lineIndices[i] < 0 {
+			return fmt.Errorf("%v: Metric for line plot not found in header: %v", p, metric)
+		}
+	}
+	return nil
+}
+
+func (p *PlotProcessor) fieldIndex(header *bitflow.Header, name string) int {
+	for i, field := range header.Fields {
+		if field == name {
+			return i
+		}
+	}
+	return -1
+}
+
 func (p *PlotProcessor) needsRadius() bool {
 	return p.Type == ClusterPlot
 }
@@ -172,6 +284,29 @@ func (p *PlotProcessor) storeSample(sample *bitflow.Sample) {
 			key = "(none)"
 		}
 	}
+	if p.Mode == LineMode {
+		lines, ok := p.lines[key]
+		if !ok {
+			lines = make(map[string]plotter.XYs)
+			p.lines[key] = lines
+		}
+		timestamp := float64(sample.Time.Unix())
+		for i, index := range p.lineIndices {
+			metric := p.lineMetrics[i]
+			lines[metric] = append(lines[metric], struct{ X, Y float64 }{timestamp, float64(sample.Values[index])})
+		}
+		return
+	}
+	if p.PairwiseMatrix {
+		// Store all values, followed by the timestamp
+		row := make([]float64, len(sample.Values)+1)
+		for i, value := range sample.Values {
+			row[i] = float64(value)
+		}
+		row[len(sample.Values)] = float64(sample.Time.Unix())
+		p.matrix[key] = append(p.matrix[key], row)
+		return
+	}
 	x := p.getVal(p.x, key, sample)
 	y := p.getVal(p.y, key, sample)
 	p.data[key] = append(p.data[key], struct{ X, Y float64 }{x, y})
@@ -208,7 +343,11 @@ func (p *PlotProcessor) Close() {
 		NoLegend: p.NoLegend,
 	}
 	var err error
-	if p.SeparatePlots {
+	if p.Mode == LineMode {
+		err = p.saveLines()
+	} else if p.PairwiseMatrix {
+		err = p.savePairwiseMatrix()
+	} else if p.SeparatePlots {
 		_ = os.Remove(p.OutputFile) // Delete file created in Start(), drop error.
 		err = plot.saveSeparatePlots(p.data, p.radiuses, p.OutputFile, p.ForceXmin, p.ForceXmax, p.ForceYmin, p.ForceYmax)
 	} else {
@@ -219,6 +358,119 @@ func (p *PlotProcessor) Close() {
 	}
 }
 
+// saveLines plots the lines collected in LineMode. The time is plotted on the X axis, and the lines are named
+// after the metric and the value of the ColorTag.
+func (p *PlotProcessor) saveLines() error {
+	plotType := p.Type
+	if plotType == ScatterPlot {
+		plotType = LinePlot
+	}
+	plot := Plot{
+		LabelX:   plotTimeLabel,
+		LabelY:   strings.Join(p.lineMetrics, ", "),
+		Type:     plotType,
+		NoLegend: p.NoLegend,
+	}
+	groups := make(map[string]map[string]plotter.XYs, len(p.lines))
+	for key, lines := range p.lines {
+		group := make(map[string]plotter.XYs, len(lines))
+		for metric, data := range lines {
+			name := metric
+			if key != "" {
+				if len(lines) == 1 {
+					name = key
+				} else {
+					name = fmt.Sprintf("%v (%v)", metric, key)
+				}
+			} else if len(lines) == 1 {
+				name = "" // A single line does not need a legend
+			}
+			group[name] = data
+		}
+		groups[key] = group
+	}
+
+	if p.SeparatePlots {
+		_ = os.Remove(p.OutputFile) // Delete file created in Start(), drop error.
+		return plot.savePlotGroups(groups, nil, p.OutputFile, p.ForceXmin, p.ForceXmax, p.ForceYmin, p.ForceYmax)
+	}
+	allLines := make(map[string]plotter.XYs)
+	for _, group := range groups {
+		for name, data := range group {
+			allLines[name] = data
+		}
+	}
+	return plot.savePlot(allLines, nil, p.OutputFile, p.ForceXmin, p.ForceXmax, p.ForceYmin, p.ForceYmax)
+}
+
+// savePairwiseMatrix plots all pairs of metrics into a grid, where the metric of every row is plotted on the
+// Y axis, and the metric of every column on the X axis. The plots on the diagonal show the values over time.
+func (p *PlotProcessor) savePairwiseMatrix() error {
+	numFields := len(p.matrixFields)
+	if numFields == 0 {
+		return fmt.Errorf("%v: Header contains no metrics, cannot create pairwise plots", p)
+	}
+	plots := make([][]*plotLib.Plot, numFields)
+	for row := range plots {
+		plots[row] = make([]*plotLib.Plot, numFields)
+		for col := range plots[row] {
+			xIndex, xLabel := col, p.matrixFields[col]
+			if row == col {
+				xIndex, xLabel = numFields, plotTimeLabel
+			}
+			data := make(map[string]plotter.XYs, len(p.matrix))
+			for key, values := range p.matrix {
+				xys := make(plotter.XYs, len(values))
+				for i, value := range values {
+					xys[i].X = value[xIndex]
+					xys[i].Y = value[row]
+				}
+				data[key] = xys
+			}
+			plot := Plot{
+				LabelX:   xLabel,
+				LabelY:   p.matrixFields[row],
+				Type:     p.Type,
+				NoLegend: p.NoLegend || row > 0 || col > 0, // Only show the legend once
+			}
+			var err error
+			plots[row][col], err = plot.createPlot(data, nil, nil, nil, nil, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	format := strings.ToLower(filepath.Ext(p.OutputFile))
+	if len(format) > 0 {
+		format = format[1:]
+	}
+	size := PairwiseTileSize * vg.Length(numFields)
+	canvas, err := draw.NewFormattedCanvas(size, size, format)
+	if err != nil {
+		return errors.New("Error creating pairwise plot: " + err.Error())
+	}
+	tiles := plotLib.Align(plots, draw.Tiles{Rows: numFields, Cols: numFields}, draw.New(canvas))
+	for row := range plots {
+		for col, plot := range plots[row] {
+			plot.Draw(tiles[row][col])
+		}
+	}
+
+	file, err := os.Create(p.OutputFile)
+	if err != nil {
+		return err
+	}
+	_, err = canvas.WriteTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = errors.New("Error saving plot: " + err.Error())
+	}
+	return err
+}
+
 func (p *PlotProcessor) String() string {
 	colorTag := "not colored"
 	if p.ColorTag != "" {
@@ -230,6 +482,12 @@ func (p *PlotProcessor) String() string {
 	} else {
 		file = "file: " + file
 	}
+	if p.PairwiseMatrix {
+		file = "pairwise matrix, " + file
+	}
+	if p.Mode == LineMode {
+		file = "lines, " + file
+	}
 	return fmt.Sprintf("Plotter (%s)(%s)", colorTag, file)
 }
 
@@ -242,8 +500,23 @@ type Plot struct {
 }
 
 func (p *Plot) saveSeparatePlots(plotData map[string]plotter.XYs, radiuses map[string][]float64, targetFile string, xMin, xMax, yMin, yMax *float64) error {
+	groups := make(map[string]map[string]plotter.XYs, len(plotData))
+	for name, data := range plotData {
+		groups[name] = map[string]plotter.XYs{name: data}
+	}
+	return p.savePlotGroups(groups, radiuses, targetFile, xMin, xMax, yMin, yMax)
+}
+
+// savePlotGroups creates one plot file for every group of plot data. All plots share the same bounds.
+func (p *Plot) savePlotGroups(groups map[string]map[string]plotter.XYs, radiuses map[string][]float64, targetFile string, xMin, xMax, yMin, yMax *float64) error {
 	if xMin == nil || xMax == nil || yMin == nil || yMax == nil {
-		bounds, err := p.createPlot(plotData, radiuses, xMin, xMax, yMin, yMax)
+		allData := make(map[string]plotter.XYs)
+		for _, plotData := range groups {
+			for name, data := range plotData {
+				allData[name] = data
+			}
+		}
+		bounds, err := p.createPlot(allData, radiuses, xMin, xMax, yMin, yMax)
 		if err != nil {
 			return err
 		}
@@ -254,9 +527,8 @@ func (p *Plot) saveSeparatePlots(plotData map[string]plotter.XYs, radiuses map[s
 	}
 
 	group := bitflow.NewFileGroup(targetFile)
-	for name, data := range plotData {
-		plotData := map[string]plotter.XYs{name: data}
-		plotFile := group.BuildFilenameStr(name)
+	for groupName, plotData := range groups {
+		plotFile := group.BuildFilenameStr(groupName)
 		if err := p.savePlot(plotData, radiuses, plotFile, xMin, xMax, yMin, yMax); err != nil {
 			return err
 		}
@@ -318,7 +590,14 @@ func (p *Plot) fillPlot(plot *plotLib.Plot, plotData map[string]plotter.XYs, rad
 		return p.fillBoxPlot(plot, plotData)
 	}
 
-	for name, data := range plotData {
+	// Sort the keys, so that the colors are assigned deterministically
+	names := make([]string, 0, len(plotData))
+	for name := range plotData {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data := plotData[name]
 		plotColor := shape.Colors.Next()
 		legend := name != "" && !p.NoLegend
 
@@ -556,6 +835,8 @@ func RegisterPlot(b reg.ProcessorRegistry) {
 		plot := &PlotProcessor{
 			AxisX:      PlotAxisAuto,
 			AxisY:      PlotAxisAuto,
+			AxisXName:  params["x"],
+			AxisYName:  params["y"],
 			OutputFile: params["file"],
 			Type:       ScatterPlot,
 		}
@@ -567,6 +848,15 @@ func RegisterPlot(b reg.ProcessorRegistry) {
 		setPlotBoundParam(&err, params, "xMax", &plot.ForceXmax)
 		setPlotBoundParam(&err, params, "yMin", &plot.ForceYmin)
 		setPlotBoundParam(&err, params, "yMax", &plot.ForceYmax)
+		if modeStr, hasMode := params["mode"]; hasMode && err == nil {
+			plot.Mode, err = ParsePlotMode(modeStr)
+			if err != nil {
+				err = reg.ParameterError("mode", err)
+			}
+		}
+		if metrics, hasMetrics := params["metrics"]; hasMetrics && metrics != "" {
+			plot.Metrics = strings.Split(metrics, ",")
+		}
 		if err != nil {
 			return err
 		}
@@ -599,8 +889,10 @@ func RegisterPlot(b reg.ProcessorRegistry) {
 				case "force_time":
 					plot.AxisX = PlotAxisTime
 					plot.AxisY = 0
+				case "pairwise":
+					plot.PairwiseMatrix = true
 				default:
-					all_flags := []string{"nolegend", "line", "linepoint", "cluster", "separate", "force_scatter", "force_time"}
+					all_flags := []string{"nolegend", "line", "linepoint", "cluster", "box", "separate", "force_scatter", "force_time", "pairwise"}
 					return fmt.Errorf("Unkown flag: '%v'. The 'flags' parameter is a comma-separated list of flags: %v", part, all_flags)
 				}
 			}
@@ -609,5 +901,9 @@ func RegisterPlot(b reg.ProcessorRegistry) {
 		return nil
 	}
 
-	b.RegisterAnalysisParamsErr("plot", create, "Plot a batch of samples to a given filename. The file ending denotes the file type", reg.RequiredParams("file"), reg.OptionalParams("color", "flags", "xMin", "xMax", "yMin", "yMax"))
+	b.RegisterAnalysisParamsErr("plot", create, "Plot a batch of samples to a given filename. The file ending denotes the file type. "+
+		"The x and y parameters select the plotted metrics by name (default: the first two metrics, or the first metric over time). "+
+		"The 'pairwise' flag plots all pairs of metrics into a grid of plots. "+
+		"With mode=line, every metric (or the comma-separated list of metrics) is plotted as a line over time, instead of the default mode=scatter",
+		reg.RequiredParams("file"), reg.OptionalParams("color", "flags", "x", "y", "mode", "metrics", "xMin", "xMax", "yMin", "yMax"))
 }
@@ -0,0 +1,97 @@
+package steps
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+func RegisterDerivative(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("rate",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			metrics := reg.StrParam(params, "metrics", ".*", true, &err)
+			counter := reg.BoolParam(params, "counter", false, true, &err)
+			if err != nil {
+				return
+			}
+			regex, err := regexp.Compile(metrics)
+			if err != nil {
+				return reg.ParameterError("metrics", err)
+			}
+			p.Add(&DerivativeProcessor{Metrics: regex, Counter: counter})
+			return
+		},
+		"Replace the values of all metrics matching the given regex (all metrics by default) with their rate of change per second, "+
+			"computed from the previous value and timestamp. Other metrics are forwarded unchanged. The first value after a header change is replaced with 0. "+
+			"With counter=true, negative differences (counter resets) result in a rate of 0.",
+		reg.OptionalParams("metrics", "counter"))
+}
+
+// DerivativeProcessor replaces the values of all metrics matching the Metrics regex with their rate of change per
+// second, based on the previous value and the timestamps of the samples. The first value of every metric after a
+// header change results in a rate of 0, since there is no previous value. Values that are NaN result in a NaN rate and
+// are not used as previous value. If the timestamp did not advance since the previous value, the rate is 0.
+// If Counter is set, the metrics are treated as monotonically increasing counters: a negative difference is interpreted
+// as a counter reset and results in a rate of 0.
+type DerivativeProcessor struct {
+	bitflow.NoopProcessor
+	Metrics *regexp.Regexp
+	Counter bool
+
+	checker       bitflow.HeaderChecker
+	indices       []int
+	previous      []float64
+	previousTimes []time.Time
+}
+
+func (p *DerivativeProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if p.checker.HeaderChanged(header) {
+		p.indices = p.indices[:0]
+		for i, field := range header.Fields {
+			if p.Metrics == nil || p.Metrics.MatchString(field) {
+				p.indices = append(p.indices, i)
+			}
+		}
+		p.previous = make([]float64, len(p.indices))
+		p.previousTimes = make([]time.Time, len(p.indices))
+		for i := range p.previous {
+			p.previous[i] = math.NaN()
+		}
+	}
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", p, len(sample.Values), len(header.Fields))
+	}
+	for i, index := range p.indices {
+		sample.Values[index] = bitflow.Value(p.rate(i, float64(sample.Values[index]), sample.Time))
+	}
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *DerivativeProcessor) rate(i int, value float64, timestamp time.Time) float64 {
+	if math.IsNaN(value) {
+		return value
+	}
+	previous, previousTime := p.previous[i], p.previousTimes[i]
+	p.previous[i], p.previousTimes[i] = value, timestamp
+	if math.IsNaN(previous) {
+		return 0
+	}
+	duration := timestamp.Sub(previousTime)
+	diff := value - previous
+	if duration <= 0 || (p.Counter && diff < 0) {
+		return 0
+	}
+	return diff / duration.Seconds()
+}
+
+func (p *DerivativeProcessor) String() string {
+	res := fmt.Sprintf("Rate per second of metrics matching %v", p.Metrics)
+	if p.Counter {
+		res += " (counters)"
+	}
+	return res
+}
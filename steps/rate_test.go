@@ -0,0 +1,64 @@
+package steps
+
+import (
+	"math"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _runDerivative(t *testing.T, p *DerivativeProcessor, header *bitflow.Header, inputs [][]bitflow.Value) [][]bitflow.Value {
+	assert := testAssert.New(t)
+	out := new(testSampleCollector)
+	p.SetSink(out)
+	p.Start(new(sync.WaitGroup))
+	start := time.Unix(1000, 0)
+	for i, values := range inputs {
+		sample := &bitflow.Sample{Time: start.Add(time.Duration(i) * 2 * time.Second), Values: values}
+		assert.NoError(p.Sample(sample, header))
+	}
+	p.Close()
+	var result [][]bitflow.Value
+	for _, sample := range out.samples {
+		result = append(result, sample.Values)
+	}
+	return result
+}
+
+func TestDerivative(t *testing.T) {
+	assert := testAssert.New(t)
+	p := &DerivativeProcessor{Metrics: regexp.MustCompile("^bytes")}
+	header := &bitflow.Header{Fields: []string{"bytes_in", "load", "bytes_out"}}
+	nan := bitflow.Value(math.NaN())
+	result := _runDerivative(t, p, header, [][]bitflow.Value{
+		{100, 1, 0},
+		{200, 2, 10},
+		{150, 3, nan},
+		{150, 4, 30}, // Previous value of bytes_out is 4 seconds old
+	})
+	assert.Len(result, 4)
+	assert.Equal([]bitflow.Value{0, 1, 0}, result[0])
+	assert.Equal([]bitflow.Value{50, 2, 5}, result[1])
+	assert.Equal(bitflow.Value(-25), result[2][0])
+	assert.True(math.IsNaN(float64(result[2][2])))
+	assert.Equal([]bitflow.Value{0, 4, 5}, result[3])
+
+	// A header change resets the previous values
+	out := new(testSampleCollector)
+	p.SetSink(out)
+	assert.NoError(p.Sample(&bitflow.Sample{Values: []bitflow.Value{500}}, &bitflow.Header{Fields: []string{"bytes_in"}}))
+	assert.Equal([]bitflow.Value{0}, out.samples[0].Values)
+}
+
+func TestDerivativeCounter(t *testing.T) {
+	assert := testAssert.New(t)
+	p := &DerivativeProcessor{Metrics: regexp.MustCompile(".*"), Counter: true}
+	result := _runDerivative(t, p, &bitflow.Header{Fields: []string{"packets"}}, [][]bitflow.Value{
+		{10}, {30}, {5}, {9},
+	})
+	assert.Equal([][]bitflow.Value{{0}, {10}, {0}, {2}}, result)
+}
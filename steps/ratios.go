@@ -0,0 +1,116 @@
+package steps
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+func RegisterMetricRatios(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("ratios",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			pairs := reg.StrParam(params, "pairs", "", false, &err)
+			if err != nil {
+				return
+			}
+			step, err := NewMetricRatios(strings.Split(pairs, ","))
+			if err != nil {
+				return reg.ParameterError("pairs", err)
+			}
+			p.Add(step)
+			return
+		},
+		"Append the ratio of metric pairs as new metrics. The pairs parameter is a comma-separated list of pairs in the form a/b, "+
+			"every pair appends the metric <a>_over_<b> with the value a/b. Division by zero and missing metrics result in NaN.",
+		reg.RequiredParams("pairs"))
+}
+
+// MetricRatioPair defines the numerator and denominator metric of one ratio computed by MetricRatios.
+type MetricRatioPair struct {
+	Numerator   string
+	Denominator string
+}
+
+// Name returns the name of the appended metric: <numerator>_over_<denominator>
+func (pair MetricRatioPair) Name() string {
+	return pair.Numerator + "_over_" + pair.Denominator
+}
+
+// MetricRatios appends one metric for every entry in Pairs, containing the value of the numerator metric divided
+// by the value of the denominator metric. A denominator of zero results in NaN. The indices of the metrics are resolved
+// whenever the header changes. Metrics missing in the header are logged as warning and result in NaN ratios.
+type MetricRatios struct {
+	bitflow.NoopProcessor
+	Pairs []MetricRatioPair
+
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+	indices   [][2]int // -1 for missing metrics
+}
+
+// NewMetricRatios parses the given pairs in the form numerator/denominator.
+func NewMetricRatios(pairs []string) (*MetricRatios, error) {
+	res := &MetricRatios{Pairs: make([]MetricRatioPair, 0, len(pairs))}
+	for _, pair := range pairs {
+		parts := strings.Split(strings.TrimSpace(pair), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Ratios must have the form a/b, received: '%v'", pair)
+		}
+		res.Pairs = append(res.Pairs, MetricRatioPair{Numerator: parts[0], Denominator: parts[1]})
+	}
+	return res, nil
+}
+
+func (r *MetricRatios) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if r.checker.HeaderChanged(header) {
+		r.headerChanged(header)
+	}
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", r, len(sample.Values), len(header.Fields))
+	}
+	ratios := make([]float64, len(r.indices))
+	for i, pair := range r.indices {
+		ratios[i] = math.NaN()
+		if pair[0] >= 0 && pair[1] >= 0 {
+			if denominator := float64(sample.Values[pair[1]]); denominator != 0 {
+				ratios[i] = float64(sample.Values[pair[0]]) / denominator
+			}
+		}
+	}
+	AppendToSample(sample, ratios)
+	return r.NoopProcessor.Sample(sample, r.outHeader)
+}
+
+func (r *MetricRatios) headerChanged(header *bitflow.Header) {
+	fieldIndices := header.BuildIndex()
+	lookup := func(metric string) int {
+		if index, ok := fieldIndices[metric]; ok {
+			return index
+		}
+		log.Warnf("%v: Metric %v not found in header", r, metric)
+		return -1
+	}
+	fields := header.Fields[:len(header.Fields):len(header.Fields)]
+	r.indices = make([][2]int, len(r.Pairs))
+	for i, pair := range r.Pairs {
+		r.indices[i] = [2]int{lookup(pair.Numerator), lookup(pair.Denominator)}
+		fields = append(fields, pair.Name())
+	}
+	r.outHeader = header.Clone(fields)
+}
+
+func (r *MetricRatios) OutputSampleSize(sampleSize int) int {
+	return sampleSize + len(r.Pairs)
+}
+
+func (r *MetricRatios) String() string {
+	names := make([]string, len(r.Pairs))
+	for i, pair := range r.Pairs {
+		names[i] = pair.Numerator + "/" + pair.Denominator
+	}
+	return "Metric ratios: " + strings.Join(names, ", ")
+}
@@ -0,0 +1,52 @@
+package steps
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestMetricRatios(t *testing.T) {
+	assert := testAssert.New(t)
+	step, err := NewMetricRatios([]string{"a/b", " b/c", "c/a", "a/missing"})
+	assert.NoError(err)
+	out := new(testSampleCollector)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b", "c"}}
+	assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{6, 3, 0}}, header))
+	assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 4, 2}}, header))
+
+	// The indices are resolved again after a header change
+	header2 := &bitflow.Header{Fields: []string{"c", "a", "b"}}
+	assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{5, 10, 2}}, header2))
+
+	assert.Len(out.samples, 3)
+	assert.Equal([]string{"a", "b", "c", "a_over_b", "b_over_c", "c_over_a", "a_over_missing"}, out.headers[0].Fields)
+	assert.Equal([]string{"c", "a", "b", "a_over_b", "b_over_c", "c_over_a", "a_over_missing"}, out.headers[2].Fields)
+
+	expected := [][]float64{
+		{6, 3, 0, 2, math.NaN(), 0, math.NaN()},
+		{1, 4, 2, 0.25, 2, 2, math.NaN()},
+		{5, 10, 2, 5, 0.4, 0.5, math.NaN()},
+	}
+	for i, sample := range out.samples {
+		assert.Len(sample.Values, len(expected[i]))
+		for j, value := range expected[i] {
+			if math.IsNaN(value) {
+				assert.True(math.IsNaN(float64(sample.Values[j])), "sample %v value %v", i, j)
+			} else {
+				assert.Equal(value, float64(sample.Values[j]), "sample %v value %v", i, j)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"a", "a/", "/b", "a/b/c"} {
+		_, err = NewMetricRatios([]string{invalid})
+		assert.Error(err, "pair %v", invalid)
+	}
+}
@@ -0,0 +1,227 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultReferenceDiffTag = "diff-exceeded"
+
+	// ReferenceMissing is the value of the flag tag for samples without a matching reference sample
+	ReferenceMissing = "missing-reference"
+)
+
+func RegisterReferenceDiff(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("diff_reference",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			file := reg.StrParam(params, "reference", "", false, &err)
+			diff := &ReferenceDiff{
+				Tolerance: reg.FloatParam(params, "tolerance", 0, true, &err),
+				MaxOffset: reg.DurationParam(params, "max-offset", 0, true, &err),
+				FlagTag:   reg.StrParam(params, "tag", DefaultReferenceDiffTag, true, &err),
+			}
+			tags := reg.StrParam(params, "match-tags", "", true, &err)
+			if err != nil {
+				return
+			}
+			if diff.Tolerance < 0 {
+				return reg.ParameterError("tolerance", errors.New("Must be >= 0"))
+			}
+			if diff.MaxOffset < 0 {
+				return reg.ParameterError("max-offset", errors.New("Must be >= 0"))
+			}
+			if diff.FlagTag == "" {
+				return reg.ParameterError("tag", errors.New("Must not be empty"))
+			}
+			if tags != "" {
+				diff.MatchTags = strings.Split(tags, ",")
+			}
+			samples, headers, err := LoadReferenceSamples(file)
+			if err != nil {
+				return reg.ParameterError("reference", err)
+			}
+			diff.SetReference(samples, headers)
+			p.Add(diff)
+			return
+		},
+		"Compare the samples to a reference recording, loaded from the given file. Every sample is aligned to the reference sample with the nearest timestamp "+
+			"(at most max-offset apart, default: only equal timestamps), and optionally the same values of the comma-separated match-tags. "+
+			"The values are replaced with the difference between the sample and the reference (sample minus reference), metrics missing in the reference are set to NaN. "+
+			"Samples with differences larger than the tolerance (absolute value, default 0) receive the tag '"+DefaultReferenceDiffTag+"' (configurable through the tag parameter), "+
+			"containing the names of the exceeding metrics. Samples without a reference receive the tag value '"+ReferenceMissing+"'.",
+		reg.RequiredParams("reference"), reg.OptionalParams("tolerance", "max-offset", "match-tags", "tag"))
+}
+
+// ReferenceDiff replaces the values of every sample with the difference to an aligned reference sample,
+// for example to compare a recording of a new software version with a baseline recording.
+// A sample is aligned to the reference sample with the nearest timestamp, if both timestamps are at most
+// MaxOffset apart, and if both have the same values for all MatchTags. The metrics are matched by name,
+// metrics missing in the reference sample are set to NaN.
+//
+// Samples where the absolute difference of at least one metric exceeds the Tolerance receive the FlagTag,
+// containing the comma-separated names of the exceeding metrics. Missing metrics do not exceed the Tolerance.
+// Samples without a reference sample are forwarded with NaN values and the FlagTag set to ReferenceMissing.
+type ReferenceDiff struct {
+	bitflow.NoopProcessor
+	MatchTags []string
+	MaxOffset time.Duration
+	Tolerance float64
+	FlagTag   string
+
+	reference map[string][]referenceSample
+	checker   bitflow.HeaderChecker
+	indices   map[*bitflow.Header][]int // For every reference header, the indices of the fields of the current header
+}
+
+type referenceSample struct {
+	sample *bitflow.Sample
+	header *bitflow.Header
+}
+
+// SetReference configures the reference samples and their headers. Both slices must have the same length.
+func (d *ReferenceDiff) SetReference(samples []*bitflow.Sample, headers []*bitflow.Header) {
+	d.reference = make(map[string][]referenceSample)
+	for i, sample := range samples {
+		key := d.key(sample)
+		d.reference[key] = append(d.reference[key], referenceSample{sample: sample, header: headers[i]})
+	}
+	for _, group := range d.reference {
+		sort.SliceStable(group, func(a, b int) bool {
+			return group[a].sample.Time.Before(group[b].sample.Time)
+		})
+	}
+}
+
+func (d *ReferenceDiff) key(sample *bitflow.Sample) string {
+	if len(d.MatchTags) == 0 {
+		return ""
+	}
+	values := make([]string, len(d.MatchTags))
+	for i, tag := range d.MatchTags {
+		values[i] = sample.Tag(tag)
+	}
+	return strings.Join(values, "\x00")
+}
+
+func (d *ReferenceDiff) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if d.checker.HeaderChanged(header) {
+		d.indices = make(map[*bitflow.Header][]int)
+	}
+	ref, ok := d.findReference(sample)
+	if !ok {
+		for i := range sample.Values {
+			sample.Values[i] = bitflow.Value(math.NaN())
+		}
+		sample.SetTag(d.FlagTag, ReferenceMissing)
+		return d.NoopProcessor.Sample(sample, header)
+	}
+
+	indices := d.referenceIndices(header, ref.header)
+	var exceeded []string
+	for i, refIndex := range indices {
+		if refIndex < 0 || refIndex >= len(ref.sample.Values) {
+			sample.Values[i] = bitflow.Value(math.NaN())
+			continue
+		}
+		diff := sample.Values[i] - ref.sample.Values[refIndex]
+		sample.Values[i] = diff
+		if math.Abs(float64(diff)) > d.Tolerance {
+			exceeded = append(exceeded, header.Fields[i])
+		}
+	}
+	if len(exceeded) > 0 {
+		sample.SetTag(d.FlagTag, strings.Join(exceeded, ","))
+	}
+	return d.NoopProcessor.Sample(sample, header)
+}
+
+func (d *ReferenceDiff) findReference(sample *bitflow.Sample) (referenceSample, bool) {
+	group := d.reference[d.key(sample)]
+	index := sort.Search(len(group), func(i int) bool {
+		return !group[i].sample.Time.Before(sample.Time)
+	})
+	best, bestOffset := -1, d.MaxOffset
+	for _, candidate := range []int{index - 1, index} {
+		if candidate >= 0 && candidate < len(group) {
+			offset := sample.Time.Sub(group[candidate].sample.Time)
+			if offset < 0 {
+				offset = -offset
+			}
+			if offset <= bestOffset {
+				best, bestOffset = candidate, offset
+			}
+		}
+	}
+	if best < 0 {
+		return referenceSample{}, false
+	}
+	return group[best], true
+}
+
+// referenceIndices returns the index of every field of the given header in the reference header, or -1.
+func (d *ReferenceDiff) referenceIndices(header, refHeader *bitflow.Header) []int {
+	indices, ok := d.indices[refHeader]
+	if !ok {
+		refIndex := refHeader.BuildIndex()
+		indices = make([]int, len(header.Fields))
+		for i, field := range header.Fields {
+			if index, ok := refIndex[field]; ok {
+				indices[i] = index
+			} else {
+				indices[i] = -1
+			}
+		}
+		d.indices[refHeader] = indices
+	}
+	return indices
+}
+
+func (d *ReferenceDiff) String() string {
+	res := fmt.Sprintf("Difference to reference (tolerance %v, max offset %v", d.Tolerance, d.MaxOffset)
+	if len(d.MatchTags) > 0 {
+		res += fmt.Sprintf(", match tags %v", d.MatchTags)
+	}
+	return res + ")"
+}
+
+// LoadReferenceSamples reads all samples from the given file, auto-detecting its format. It returns the samples
+// and the header of every sample.
+func LoadReferenceSamples(filename string) ([]*bitflow.Sample, []*bitflow.Header, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	collector := new(referenceCollector)
+	reader := bitflow.DefaultEndpointFactory.Reader(nil)
+	stream := reader.Open(file, collector)
+	num, err := stream.ReadSamples(filename)
+	if closeErr := stream.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read %v: %v", filename, err)
+	}
+	log.Debugf("Read %v reference samples from %v", num, filename)
+	return collector.samples, collector.headers, nil
+}
+
+type referenceCollector struct {
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+}
+
+func (c *referenceCollector) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	c.samples = append(c.samples, sample)
+	c.headers = append(c.headers, header)
+	return nil
+}
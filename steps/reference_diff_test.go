@@ -0,0 +1,87 @@
+package steps
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestReferenceDiff(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-reference-diff")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// The reference is a copy of the stream, shifted by 100ms, with a different value of metric b in the third sample
+	start := time.Unix(1000, 0)
+	makeSample := func(i int, offset time.Duration) *bitflow.Sample {
+		sample := &bitflow.Sample{Time: start.Add(time.Duration(i)*time.Second + offset), Values: []bitflow.Value{bitflow.Value(i), 10}}
+		sample.SetTag("host", "h1")
+		return sample
+	}
+	refHeader := &bitflow.Header{Fields: []string{"b", "a"}}
+	var buf bytes.Buffer
+	var m bitflow.CsvMarshaller
+	assert.NoError(m.WriteHeader(refHeader, true, &buf))
+	for i := 0; i < 4; i++ {
+		ref := makeSample(i, 100*time.Millisecond)
+		ref.Values[0], ref.Values[1] = ref.Values[1], ref.Values[0]
+		if i == 2 {
+			ref.Values[0] = 12
+		}
+		assert.NoError(m.WriteSample(ref, refHeader, true, &buf))
+	}
+	refFile := filepath.Join(dir, "reference.csv")
+	assert.NoError(ioutil.WriteFile(refFile, buf.Bytes(), 0644))
+
+	samples, headers, err := LoadReferenceSamples(refFile)
+	assert.NoError(err)
+	assert.Len(samples, 4)
+	assert.Len(headers, 4)
+	_, _, err = LoadReferenceSamples(filepath.Join(dir, "missing.csv"))
+	assert.Error(err)
+
+	diff := &ReferenceDiff{MaxOffset: 500 * time.Millisecond, Tolerance: 0.5, FlagTag: DefaultReferenceDiffTag, MatchTags: []string{"host"}}
+	diff.SetReference(samples, headers)
+	out := new(testSampleCollector)
+	diff.SetSink(out)
+	diff.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a", "b", "c"}}
+	for i := 0; i < 5; i++ {
+		sample := makeSample(i, 0)
+		sample.Values = append(sample.Values, 1)
+		assert.NoError(diff.Sample(sample, header))
+	}
+	otherHost := makeSample(0, 0)
+	otherHost.Values = append(otherHost.Values, 1)
+	otherHost.SetTag("host", "h2")
+	assert.NoError(diff.Sample(otherHost, header))
+	diff.Close()
+
+	assert.Len(out.samples, 6)
+	for i, sample := range out.samples[:4] {
+		assert.Equal(bitflow.Value(0), sample.Values[0], "sample %v", i)
+		if i != 2 {
+			assert.Equal(bitflow.Value(0), sample.Values[1], "sample %v", i)
+		}
+		assert.True(math.IsNaN(float64(sample.Values[2])), "metric c is not in the reference")
+	}
+	assert.False(out.samples[0].HasTag(DefaultReferenceDiffTag))
+	assert.Equal(bitflow.Value(-2), out.samples[2].Values[1])
+	assert.Equal("b", out.samples[2].Tag(DefaultReferenceDiffTag))
+
+	// The fifth sample is too far from the last reference sample, the last sample has a different host tag
+	for _, sample := range out.samples[4:] {
+		assert.Equal(ReferenceMissing, sample.Tag(DefaultReferenceDiffTag))
+		assert.True(math.IsNaN(float64(sample.Values[0])))
+	}
+}
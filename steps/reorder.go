@@ -0,0 +1,150 @@
+package steps
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+func RegisterReorderBuffer(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("reorder",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			window := reg.DurationParam(params, "window", 0, false, &err)
+			maxBuffer := reg.IntParam(params, "max-buffer", 0, true, &err)
+			if err == nil {
+				p.Add(&ReorderBuffer{
+					Window:    window,
+					MaxBuffer: maxBuffer,
+				})
+			}
+			return
+		},
+		"Buffer samples and emit them ordered by their timestamps. Samples are emitted when they are older than the newest received timestamp minus the given window, "+
+			"or when the buffer exceeds max-buffer samples (unlimited by default). Samples arriving after newer samples were already emitted are forwarded immediately. "+
+			"A larger window tolerates more disorder, but delays all samples by up to the window and buffers all samples received within the window.",
+		reg.RequiredParams("window"), reg.OptionalParams("max-buffer"))
+}
+
+// ReorderBuffer resequences slightly out-of-order samples, e.g. when merging the inputs of multiple TCP sources.
+// Every sample is held back until the newest received timestamp is more than Window ahead of it. This bounds the added
+// latency (in terms of sample timestamps) to Window. If MaxBuffer > 0, the oldest samples are emitted early to keep
+// the buffer within that size.
+//
+// The Window controls a tradeoff between latency, memory and ordering: the buffer holds all samples received within
+// the last Window, so the memory usage grows with Window multiplied with the sample rate, and every sample is delayed
+// by up to Window. A smaller Window reduces both, but samples delayed by more than Window are forwarded unordered.
+// MaxBuffer limits the memory usage in case of bursts, at the expense of ordering.
+type ReorderBuffer struct {
+	bitflow.NoopProcessor
+	Window    time.Duration
+	MaxBuffer int
+
+	buffer      reorderHeap
+	counter     uint64
+	newest      time.Time
+	lastEmitted time.Time
+	numLate     int
+}
+
+func (r *ReorderBuffer) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if !r.lastEmitted.IsZero() && sample.Time.Before(r.lastEmitted) {
+		// Too late to be ordered correctly, forward immediately
+		r.numLate++
+		if r.numLate == 1 {
+			log.Warnf("%v: Received sample older than the reorder window (%v is before already emitted %v). Forwarding late samples unordered.",
+				r, sample.Time, r.lastEmitted)
+		}
+		return r.NoopProcessor.Sample(sample, header)
+	}
+
+	r.counter++
+	heap.Push(&r.buffer, &reorderedSample{
+		SampleAndHeader: bitflow.SampleAndHeader{Sample: sample, Header: header},
+		seq:             r.counter,
+	})
+	if sample.Time.After(r.newest) {
+		r.newest = sample.Time
+	}
+
+	watermark := r.newest.Add(-r.Window)
+	for len(r.buffer) > 0 {
+		oldest := r.buffer[0]
+		if !oldest.Sample.Time.Before(watermark) && (r.MaxBuffer <= 0 || len(r.buffer) <= r.MaxBuffer) {
+			break
+		}
+		if err := r.emitOldest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ReorderBuffer) emitOldest() error {
+	oldest := heap.Pop(&r.buffer).(*reorderedSample)
+	r.lastEmitted = oldest.Sample.Time
+	return r.NoopProcessor.Sample(oldest.Sample, oldest.Header)
+}
+
+func (r *ReorderBuffer) Close() {
+	defer r.CloseSink()
+	if r.numLate > 0 {
+		log.Warnf("%v: Forwarded %v late sample(s) unordered", r, r.numLate)
+	}
+	for len(r.buffer) > 0 {
+		if err := r.emitOldest(); err != nil {
+			err = fmt.Errorf("Error flushing reordered samples: %v", err)
+			log.Errorln(err)
+			r.Error(err)
+			return
+		}
+	}
+}
+
+func (r *ReorderBuffer) String() string {
+	res := fmt.Sprintf("Reorder samples (window %v", r.Window)
+	if r.MaxBuffer > 0 {
+		res += fmt.Sprintf(", max buffer %v", r.MaxBuffer)
+	}
+	return res + ")"
+}
+
+type reorderedSample struct {
+	bitflow.SampleAndHeader
+	seq uint64 // Keeps samples with equal timestamps in their arrival order
+}
+
+// reorderHeap implements heap.Interface, the oldest sample is at the root
+type reorderHeap []*reorderedSample
+
+func (h reorderHeap) Len() int {
+	return len(h)
+}
+
+func (h reorderHeap) Less(i, j int) bool {
+	ti, tj := h[i].Sample.Time, h[j].Sample.Time
+	if ti.Equal(tj) {
+		return h[i].seq < h[j].seq
+	}
+	return ti.Before(tj)
+}
+
+func (h reorderHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *reorderHeap) Push(x interface{}) {
+	*h = append(*h, x.(*reorderedSample))
+}
+
+func (h *reorderHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
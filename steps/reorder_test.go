@@ -0,0 +1,58 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _runReorderBuffer(t *testing.T, r *ReorderBuffer, offsets []int) []int {
+	assert := testAssert.New(t)
+	out := new(testSampleCollector)
+	r.SetSink(out)
+	r.Start(new(sync.WaitGroup))
+	start := time.Unix(1000, 0)
+	header := &bitflow.Header{Fields: []string{"offset"}}
+	for _, offset := range offsets {
+		sample := &bitflow.Sample{
+			Time:   start.Add(time.Duration(offset) * time.Second),
+			Values: []bitflow.Value{bitflow.Value(offset)},
+		}
+		assert.NoError(r.Sample(sample, header))
+	}
+	r.Close()
+	result := make([]int, len(out.samples))
+	for i, sample := range out.samples {
+		result[i] = int(sample.Values[0])
+	}
+	return result
+}
+
+func TestReorderBufferWindow(t *testing.T) {
+	assert := testAssert.New(t)
+	// Every sample is at most 2 seconds late
+	offsets := []int{1, 0, 3, 2, 4, 6, 5, 8, 7, 9, 11, 10}
+	r := &ReorderBuffer{Window: 2 * time.Second}
+	assert.Equal([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, _runReorderBuffer(t, r, offsets))
+}
+
+func TestReorderBufferLateSamples(t *testing.T) {
+	assert := testAssert.New(t)
+	// Sample 2 arrives too late to be ordered and is forwarded immediately
+	offsets := []int{1, 3, 5, 7, 2, 6, 8}
+	r := &ReorderBuffer{Window: 1 * time.Second}
+	assert.Equal([]int{1, 3, 5, 2, 6, 7, 8}, _runReorderBuffer(t, r, offsets))
+	assert.Equal(1, r.numLate)
+}
+
+func TestReorderBufferMaxBuffer(t *testing.T) {
+	assert := testAssert.New(t)
+	offsets := []int{4, 3, 2, 1, 0}
+	r := &ReorderBuffer{Window: time.Hour, MaxBuffer: 2}
+	// The buffer holds at most 2 samples, so sample 2 is emitted early and the following samples are late
+	assert.Equal([]int{2, 1, 0, 3, 4}, _runReorderBuffer(t, r, offsets))
+	assert.Equal(2, r.numLate)
+}
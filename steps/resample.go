@@ -0,0 +1,151 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// DefaultResampleMaxSamples is the default limit for the number of samples that the LinearResampler produces for one batch.
+const DefaultResampleMaxSamples = 1000000
+
+func RegisterLinearResampler(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("resample",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &LinearResampler{
+				Interval:   reg.DurationParam(params, "interval", 0, false, &err),
+				MaxSamples: reg.IntParam(params, "max-samples", DefaultResampleMaxSamples, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			if step.Interval <= 0 {
+				return reg.ParameterError("interval", errors.New("Must be > 0"))
+			}
+			if step.MaxSamples <= 0 {
+				return reg.ParameterError("max-samples", errors.New("Must be > 0"))
+			}
+			p.Batch(step)
+			return
+		},
+		"Resample a batch to evenly spaced timestamps at multiples of the given interval since the Unix epoch, covering the time range of the batch. "+
+			"The values are linearly interpolated between the two surrounding samples. Timestamps before the first or after the last sample receive "+
+			"the values of the first or last sample, respectively. The tags are taken from the nearest sample. "+
+			fmt.Sprintf("Batches that would result in more than max-samples (default %v) samples fail, e.g. due to an outlier timestamp.", DefaultResampleMaxSamples),
+		reg.RequiredParams("interval"), reg.OptionalParams("max-samples"), reg.SupportBatch())
+}
+
+// LinearResampler produces samples at regular timestamps, which are multiples of Interval since the Unix epoch.
+// The output covers the time range of the batch: it starts at the last grid timestamp not after the first sample, and
+// ends at the first grid timestamp not before the last sample. The values of each output sample are linearly interpolated
+// between the two surrounding input samples, where the value is NaN if one of the two input values is NaN. Output
+// samples outside the time range of the input are clamped to the values of the first or last input sample. Every output
+// sample receives the tags of the input sample that is closest in time. The input batch does not need to be sorted.
+// To protect against outlier timestamps, batches that would result in more than MaxSamples output samples
+// (DefaultResampleMaxSamples, if not set) result in an error.
+type LinearResampler struct {
+	Interval   time.Duration
+	MaxSamples int
+}
+
+func (r *LinearResampler) ProcessBatch(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
+	if len(samples) == 0 {
+		return header, samples, nil
+	}
+	for _, sample := range samples {
+		if len(sample.Values) != len(header.Fields) {
+			return nil, nil, fmt.Errorf("%v: Sample has %v values, but header has %v fields", r, len(sample.Values), len(header.Fields))
+		}
+	}
+	sorted := make([]*bitflow.Sample, len(samples))
+	copy(sorted, samples)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	maxSamples := r.MaxSamples
+	if maxSamples <= 0 {
+		maxSamples = DefaultResampleMaxSamples
+	}
+	// Check the span first, since the nanosecond timestamps overflow for extreme outliers (the duration saturates instead)
+	span := sorted[len(sorted)-1].Time.Sub(sorted[0].Time)
+	if span/r.Interval >= time.Duration(maxSamples) {
+		return nil, nil, fmt.Errorf("%v: The batch spans %v and would result in more than %v samples", r, span, maxSamples)
+	}
+
+	interval := int64(r.Interval)
+	first, last := sorted[0].Time.UnixNano(), sorted[len(sorted)-1].Time.UnixNano()
+	start := first - floorMod(first, interval)
+	end := last
+	if remainder := floorMod(last, interval); remainder != 0 {
+		end += interval - remainder
+	}
+
+	numSamples := (end-start)/interval + 1
+	if numSamples > int64(maxSamples) {
+		return nil, nil, fmt.Errorf("%v: The batch spans %v and would result in %v samples, more than %v", r, span, numSamples, maxSamples)
+	}
+	result := make([]*bitflow.Sample, 0, numSamples)
+	next := 0 // Index of the first input sample after the current timestamp
+	for t := start; t <= end; t += interval {
+		timestamp := time.Unix(0, t)
+		for next < len(sorted) && !sorted[next].Time.After(timestamp) {
+			next++
+		}
+		result = append(result, r.interpolate(sorted, next, timestamp))
+	}
+	return header, result, nil
+}
+
+func (r *LinearResampler) interpolate(sorted []*bitflow.Sample, next int, timestamp time.Time) *bitflow.Sample {
+	var nearest *bitflow.Sample
+	values := make([]bitflow.Value, len(sorted[0].Values))
+	switch {
+	case next == 0:
+		nearest = sorted[0]
+		copy(values, nearest.Values)
+	case next == len(sorted):
+		nearest = sorted[len(sorted)-1]
+		copy(values, nearest.Values)
+	default:
+		before, after := sorted[next-1], sorted[next]
+		nearest = before
+		if after.Time.Sub(timestamp) < timestamp.Sub(before.Time) {
+			nearest = after
+		}
+		fraction := float64(timestamp.Sub(before.Time)) / float64(after.Time.Sub(before.Time))
+		if fraction == 0 {
+			copy(values, before.Values)
+			break
+		}
+		for i := range values {
+			a, b := float64(before.Values[i]), float64(after.Values[i])
+			if math.IsNaN(a) || math.IsNaN(b) {
+				values[i] = bitflow.Value(math.NaN())
+			} else {
+				values[i] = bitflow.Value(a + (b-a)*fraction)
+			}
+		}
+	}
+	sample := &bitflow.Sample{Values: values}
+	sample.CopyMetadataFrom(nearest)
+	sample.Time = timestamp
+	return sample
+}
+
+func (r *LinearResampler) String() string {
+	return fmt.Sprintf("Linear resampling with interval %v", r.Interval)
+}
+
+func floorMod(value, divisor int64) int64 {
+	res := value % divisor
+	if res < 0 {
+		res += divisor
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+package steps
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestLinearResampler(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	sample := func(millis int, tag string, values ...bitflow.Value) *bitflow.Sample {
+		s := &bitflow.Sample{Time: time.Unix(0, int64(millis)*int64(time.Millisecond)), Values: values}
+		s.SetTag("src", tag)
+		return s
+	}
+	samples := []*bitflow.Sample{ // Unsorted input
+		sample(4500, "second", 6, 10),
+		sample(1500, "first", 0, 10),
+		sample(5000, "third", 7, bitflow.Value(math.NaN())),
+	}
+
+	outHeader, out, err := (&LinearResampler{Interval: 2 * time.Second}).ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Equal(header, outHeader)
+	assert.Len(out, 4)
+	expected := []struct {
+		seconds int64
+		tag     string
+		a, b    float64
+	}{
+		{0, "first", 0, 10}, // Clamped to the first sample
+		{2, "first", 1, 10},
+		{4, "second", 5, 10},
+		{6, "third", 7, math.NaN()}, // Clamped to the last sample
+	}
+	for i, exp := range expected {
+		assert.True(time.Unix(exp.seconds, 0).Equal(out[i].Time), "sample %v: %v", i, out[i].Time)
+		assert.Equal(exp.tag, out[i].Tag("src"), "sample %v", i)
+		assert.InDelta(exp.a, float64(out[i].Values[0]), 1e-9, "sample %v", i)
+		if math.IsNaN(exp.b) {
+			assert.True(math.IsNaN(float64(out[i].Values[1])), "sample %v", i)
+		} else {
+			assert.InDelta(exp.b, float64(out[i].Values[1]), 1e-9, "sample %v", i)
+		}
+	}
+
+	// Timestamps on the grid keep the original values
+	_, out, err = (&LinearResampler{Interval: time.Second}).ProcessBatch(header, []*bitflow.Sample{sample(1000, "x", 1, 2), sample(3000, "y", 3, 4)})
+	assert.NoError(err)
+	assert.Len(out, 3)
+	assert.Equal([]bitflow.Value{1, 2}, out[0].Values)
+	assert.Equal([]bitflow.Value{2, 3}, out[1].Values)
+	assert.Equal([]bitflow.Value{3, 4}, out[2].Values)
+
+	_, out, err = (&LinearResampler{Interval: time.Second}).ProcessBatch(header, nil)
+	assert.NoError(err)
+	assert.Empty(out)
+}
+
+func TestLinearResamplerMaxSamples(t *testing.T) {
+	assert := testAssert.New(t)
+	header := &bitflow.Header{Fields: []string{"a"}}
+	samples := []*bitflow.Sample{
+		{Time: time.Unix(1000, 0), Values: []bitflow.Value{1}},
+		{Time: time.Unix(1004, 0), Values: []bitflow.Value{2}},
+	}
+	_, out, err := (&LinearResampler{Interval: time.Second, MaxSamples: 5}).ProcessBatch(header, samples)
+	assert.NoError(err)
+	assert.Len(out, 5)
+
+	_, _, err = (&LinearResampler{Interval: time.Second, MaxSamples: 4}).ProcessBatch(header, samples)
+	assert.Error(err)
+
+	// An outlier with the zero timestamp must not allocate the samples for the entire time range
+	samples = append(samples, &bitflow.Sample{Values: []bitflow.Value{3}})
+	_, _, err = (&LinearResampler{Interval: time.Second}).ProcessBatch(header, samples)
+	assert.Error(err)
+}
@@ -0,0 +1,292 @@
+package s3
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/antongulenko/golib"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	S3Endpoint = bitflow.EndpointType("s3")
+
+	binaryKeySuffix = ".bin"
+	csvKeySuffix    = ".csv"
+)
+
+// S3Config configures the connection to S3. Credentials are loaded through the standard AWS mechanisms
+// (environment variables, shared config and credentials files, instance roles).
+type S3Config struct {
+	Region    string
+	Endpoint  string // Can be set to use an S3-compatible service, e.g. minio
+	PathStyle bool
+}
+
+// NewClient creates an S3 client based on the shared AWS configuration, overridden by the fields of the S3Config.
+func (config S3Config) NewClient() (*s3.S3, error) {
+	awsConfig := aws.Config{
+		S3ForcePathStyle: aws.Bool(config.PathStyle),
+	}
+	if config.Region != "" {
+		awsConfig.Region = aws.String(config.Region)
+	}
+	if config.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create AWS session: %v", err)
+	}
+	return s3.New(sess), nil
+}
+
+// RegisterS3Endpoints registers the 's3' data source and sink. Both take endpoints in the form s3://bucket/key.
+// The data format is chosen based on the suffix of the key: '.bin' selects the binary format, '.csv' the CSV format.
+// For other keys, the format is auto-detected when reading, and CSV is used when writing.
+func RegisterS3Endpoints(b reg.ProcessorRegistry) {
+	var config S3Config
+	b.Endpoints.CustomGeneralFlags = append(b.Endpoints.CustomGeneralFlags, func(f *flag.FlagSet) {
+		f.StringVar(&config.Region, "s3-region", "", "AWS region for S3 endpoints. By default, the region of the shared AWS configuration is used.")
+		f.StringVar(&config.Endpoint, "s3-endpoint", "", "Custom URL for S3 endpoints, e.g. for S3-compatible services")
+		f.BoolVar(&config.PathStyle, "s3-path-style", false, "Use path-style addressing for S3 endpoints (bucket name in the URL path instead of the host name)")
+	})
+
+	b.Endpoints.CustomDataSources[S3Endpoint] = func(target string) (bitflow.SampleSource, error) {
+		bucket, key, err := ParseS3Target(target)
+		if err != nil {
+			return nil, err
+		}
+		var unmarshaller bitflow.Unmarshaller // nil makes the reader auto-detect the format
+		if format := keyFormat(key); format != bitflow.UndefinedFormat {
+			marshaller, err := b.Endpoints.CreateMarshaller(format)
+			if err != nil {
+				return nil, err
+			}
+			unmarshaller, _ = marshaller.(bitflow.Unmarshaller)
+		}
+		client, err := config.NewClient()
+		if err != nil {
+			return nil, err
+		}
+		source := &S3Source{
+			Bucket: bucket,
+			Key:    key,
+			Client: client,
+		}
+		source.Reader = b.Endpoints.Reader(unmarshaller)
+		return source, nil
+	}
+	b.Endpoints.CustomDataSinks[S3Endpoint] = func(target string) (bitflow.SampleProcessor, error) {
+		bucket, key, err := ParseS3Target(target)
+		if err != nil {
+			return nil, err
+		}
+		format := keyFormat(key)
+		if format == bitflow.UndefinedFormat {
+			format = bitflow.CsvFormat
+		}
+		marshaller, err := b.Endpoints.CreateMarshaller(format)
+		if err != nil {
+			return nil, err
+		}
+		client, err := config.NewClient()
+		if err != nil {
+			return nil, err
+		}
+		sink := &S3Sink{
+			Bucket:   bucket,
+			Key:      key,
+			Client:   client,
+			IoBuffer: b.Endpoints.FlagIoBuffer,
+		}
+		sink.SetMarshaller(marshaller)
+		sink.Writer = b.Endpoints.Writer()
+		return sink, nil
+	}
+}
+
+// ParseS3Target splits an endpoint target in the form bucket/key.
+func ParseS3Target(target string) (bucket string, key string, err error) {
+	parts := strings.SplitN(target, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("S3 endpoint must have the form bucket/key, received: %v", target)
+	}
+	return parts[0], parts[1], nil
+}
+
+func keyFormat(key string) bitflow.MarshallingFormat {
+	switch {
+	case strings.HasSuffix(key, binaryKeySuffix):
+		return bitflow.BinaryFormat
+	case strings.HasSuffix(key, csvKeySuffix):
+		return bitflow.CsvFormat
+	default:
+		return bitflow.UndefinedFormat
+	}
+}
+
+// S3Source reads samples from a single S3 object. The object is downloaded as a stream while parsing.
+type S3Source struct {
+	bitflow.AbstractUnmarshallingSampleSource
+	Bucket string
+	Key    string
+	Client s3iface.S3API
+
+	streamLock sync.Mutex
+	stream     *bitflow.SampleInputStream
+	closed     bool
+}
+
+func (source *S3Source) String() string {
+	return fmt.Sprintf("S3 source (s3://%v/%v)", source.Bucket, source.Key)
+}
+
+func (source *S3Source) Start(wg *sync.WaitGroup) golib.StopChan {
+	return golib.WaitErrFunc(wg, func() error {
+		defer source.CloseSinkParallel(wg)
+		obj, err := source.Client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(source.Bucket),
+			Key:    aws.String(source.Key),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to download s3://%v/%v: %v", source.Bucket, source.Key, err)
+		}
+		stream := source.openStream(obj.Body)
+		if stream == nil {
+			return nil
+		}
+		log.Println("Reading samples from", source)
+		err = stream.ReadNamedSamples("s3://" + source.Bucket + "/" + source.Key)
+		if source.isClosed() {
+			err = nil
+		}
+		return err
+	})
+}
+
+func (source *S3Source) openStream(body io.ReadCloser) *bitflow.SampleInputStream {
+	source.streamLock.Lock()
+	defer source.streamLock.Unlock()
+	if source.closed {
+		_ = body.Close() // Drop error
+		return nil
+	}
+	source.stream = source.Reader.Open(body, source.GetSink())
+	return source.stream
+}
+
+func (source *S3Source) isClosed() bool {
+	source.streamLock.Lock()
+	defer source.streamLock.Unlock()
+	return source.closed
+}
+
+func (source *S3Source) Close() {
+	source.streamLock.Lock()
+	defer source.streamLock.Unlock()
+	source.closed = true
+	if source.stream != nil {
+		if err := source.stream.Close(); err != nil {
+			log.Errorf("%v: Error closing stream: %v", source, err)
+		}
+	}
+}
+
+// S3Sink writes all samples to a temporary file and uploads it to S3 when the sink is closed.
+// Large files are uploaded in multiple parts.
+type S3Sink struct {
+	bitflow.AbstractMarshallingSampleOutput
+	Bucket   string
+	Key      string
+	Client   s3iface.S3API
+	IoBuffer int
+
+	// TempDir is the directory for the temporary file. The default temporary directory is used if this is empty.
+	TempDir string
+
+	file    *os.File
+	stream  *bitflow.SampleOutputStream
+	stopped golib.StopChan
+}
+
+func (sink *S3Sink) String() string {
+	return fmt.Sprintf("S3 sink (s3://%v/%v)", sink.Bucket, sink.Key)
+}
+
+// Start creates the temporary file. The returned StopChan is stopped when the sink is closed and
+// contains the error of the upload, if it failed.
+func (sink *S3Sink) Start(wg *sync.WaitGroup) golib.StopChan {
+	file, err := ioutil.TempFile(sink.TempDir, "bitflow-s3-")
+	if err != nil {
+		return golib.NewStoppedChan(fmt.Errorf("Failed to create temporary file for %v: %v", sink, err))
+	}
+	sink.file = file
+	sink.stream = sink.Writer.OpenBuffered(file, sink.Marshaller, sink.IoBuffer)
+	sink.stopped = golib.NewStopChan()
+	log.WithField("format", sink.Marshaller).Println("Writing samples to", sink.file.Name(), "for uploading to", sink)
+	return sink.stopped
+}
+
+func (sink *S3Sink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	err := sink.stream.Sample(sample, header)
+	return sink.AbstractMarshallingSampleOutput.Sample(err, sample, header)
+}
+
+func (sink *S3Sink) Close() {
+	defer sink.CloseSink()
+	if sink.stream == nil {
+		return
+	}
+	defer func() {
+		if err := os.Remove(sink.file.Name()); err != nil {
+			log.Warnf("%v: Failed to remove temporary file: %v", sink, err)
+		}
+	}()
+	err := sink.stream.Close() // Also closes the temporary file
+	if err == nil {
+		err = sink.upload()
+	}
+	if err != nil {
+		err = fmt.Errorf("%v: Upload failed: %v", sink, err)
+	}
+	sink.stopped.StopErr(err)
+}
+
+func (sink *S3Sink) upload() error {
+	file, err := os.Open(sink.file.Name())
+	if err != nil {
+		return err
+	}
+	defer file.Close() // Drop error
+	uploader := s3manager.NewUploaderWithClient(sink.Client)
+	res, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(sink.Bucket),
+		Key:    aws.String(sink.Key),
+		Body:   file,
+	})
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		return errors.New("Received empty upload result")
+	}
+	log.Println("Uploaded samples to", res.Location)
+	return nil
+}
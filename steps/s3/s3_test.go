@@ -0,0 +1,137 @@
+package s3
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+// fakeS3 stores objects in memory and supports the minimal PUT and GET requests used by path-style S3 clients
+type fakeS3 struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.objects[r.URL.Path] = data
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data) // Drop error
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type collectingSink struct {
+	bitflow.DroppingSampleProcessor
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+}
+
+func (s *collectingSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	s.samples = append(s.samples, sample)
+	s.headers = append(s.headers, header)
+	return nil
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	assert := testAssert.New(t)
+	server := httptest.NewServer(&fakeS3{objects: make(map[string][]byte)})
+	defer server.Close()
+	client, err := S3Config{
+		Region:    "us-east-1",
+		Endpoint:  server.URL,
+		PathStyle: true,
+	}.NewClient()
+	assert.NoError(err)
+	client.Config.Credentials = credentials.NewStaticCredentials("id", "secret", "")
+
+	endpoints := bitflow.NewEndpointFactory()
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	now := time.Unix(1550000000, 0)
+	samples := make([]*bitflow.Sample, 3)
+	for i := range samples {
+		samples[i] = &bitflow.Sample{
+			Values: []bitflow.Value{bitflow.Value(i), bitflow.Value(i * 10)},
+			Time:   now.Add(time.Duration(i) * time.Second),
+		}
+		samples[i].SetTag("index", string(rune('a'+i)))
+	}
+
+	for _, key := range []string{"data/test.csv", "data/test.bin"} {
+		marshaller, err := endpoints.CreateMarshaller(keyFormat(key))
+		assert.NoError(err)
+		sink := &S3Sink{
+			Bucket: "bucket",
+			Key:    key,
+			Client: client,
+		}
+		sink.SetMarshaller(marshaller)
+		sink.Writer = endpoints.Writer()
+		sink.SetSink(new(bitflow.DroppingSampleProcessor))
+		var wg sync.WaitGroup
+		stopped := sink.Start(&wg)
+		for _, sample := range samples {
+			assert.NoError(sink.Sample(sample, header))
+		}
+		sink.Close()
+		wg.Wait()
+		assert.True(stopped.Stopped())
+		assert.NoError(stopped.Err())
+
+		received := new(collectingSink)
+		source := &S3Source{
+			Bucket: "bucket",
+			Key:    key,
+			Client: client,
+		}
+		source.Reader = endpoints.Reader(nil)
+		source.SetSink(received)
+		sourceStopped := source.Start(&wg)
+		wg.Wait()
+		assert.NoError(sourceStopped.Err())
+
+		assert.Len(received.samples, len(samples))
+		for i, sample := range received.samples {
+			assert.Equal(header.Fields, received.headers[i].Fields)
+			assert.Equal(samples[i].Values, sample.Values)
+			assert.True(samples[i].Time.Equal(sample.Time))
+			assert.Equal(samples[i].TagMap(), sample.TagMap())
+		}
+	}
+}
+
+func TestParseS3Target(t *testing.T) {
+	assert := testAssert.New(t)
+	bucket, key, err := ParseS3Target("bucket/path/to/data.bin")
+	assert.NoError(err)
+	assert.Equal("bucket", bucket)
+	assert.Equal("path/to/data.bin", key)
+	assert.Equal(bitflow.BinaryFormat, keyFormat(key))
+
+	_, _, err = ParseS3Target("bucket")
+	assert.Error(err)
+	_, _, err = ParseS3Target("bucket/")
+	assert.Error(err)
+}
@@ -0,0 +1,115 @@
+package steps
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+// The following steps preserve the field ids of the schema-stable mode (see bitflow.Header.FieldIds):
+// all steps that forward the header unchanged, the steps based on MetricMapperHelper
+// (include, exclude, remap, rename, filter_variance), and name_fields.
+// Steps that add, aggregate or transform metrics (e.g. pca, moving_avg, aggregate) create new headers
+// without field ids, which makes the restore_schema step fail.
+const schemaStableStepsDescription = "Field ids are preserved by steps that do not modify the header, " +
+	"by include, exclude, remap, rename, filter_variance and name_fields. Other steps that change the header discard the field ids."
+
+func RegisterSchemaStableMode(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParams("stable_schema",
+		func(p *bitflow.SamplePipeline, _ map[string]string) {
+			p.Add(new(FieldIdAssigner))
+		},
+		"Enable the schema-stable mode: assign a stable internal id to every metric, which is preserved when metrics are renamed, reordered or removed, "+
+			"so that the restore_schema step can restore the current order and names. "+schemaStableStepsDescription)
+	b.RegisterAnalysisParams("restore_schema",
+		func(p *bitflow.SamplePipeline, _ map[string]string) {
+			p.Add(new(FieldIdRestorer))
+		},
+		"Restore the order and names of the metrics at the time the stable_schema step was executed. Removed metrics stay removed. "+
+			"Fails if the schema-stable mode was not enabled, or if a previous step discarded the field ids. "+schemaStableStepsDescription)
+}
+
+// FieldIdAssigner enables the schema-stable mode by assigning field ids to every incoming header,
+// based on the position of the fields. Existing field ids are overwritten.
+type FieldIdAssigner struct {
+	bitflow.NoopProcessor
+	checker   bitflow.HeaderChecker
+	outHeader *bitflow.Header
+}
+
+func (a *FieldIdAssigner) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if a.checker.HeaderChanged(header) {
+		a.outHeader = header.Clone(header.Fields)
+		a.outHeader.OriginalFields = header.Fields
+		a.outHeader.FieldIds = make([]int, len(header.Fields))
+		for i := range a.outHeader.FieldIds {
+			a.outHeader.FieldIds[i] = i
+		}
+	}
+	return a.NoopProcessor.Sample(sample, a.outHeader)
+}
+
+func (a *FieldIdAssigner) String() string {
+	return "Assign stable field ids"
+}
+
+// FieldIdRestorer uses the field ids of the schema-stable mode to sort the fields by their original
+// position and reset them to their original names. Fields that were removed after the ids were assigned
+// are not restored. Headers without field ids result in an error. The output header has no field ids.
+type FieldIdRestorer struct {
+	bitflow.NoopProcessor
+	helper MetricMapperHelper
+}
+
+func (r *FieldIdRestorer) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if !header.HasFieldIds() {
+		return fmt.Errorf("%v: Received header without field ids, the stable_schema step must be executed first "+
+			"and all intermediate steps must preserve the field ids", r)
+	}
+	var err error
+	if headerErr := r.helper.incomingHeader(header, r, func(header *bitflow.Header) ([]int, []string) {
+		var indices []int
+		indices, err = r.constructIndices(header)
+		fields := make([]string, len(indices))
+		for i, index := range indices {
+			fields[i] = header.OriginalFields[header.FieldIds[index]]
+		}
+		return indices, fields
+	}); headerErr != nil {
+		return headerErr
+	}
+	if err != nil {
+		r.helper.LastHeader = nil // Check the header again with the next sample
+		return err
+	}
+	// The restored fields are the original fields, so the output header does not carry the field ids further
+	r.helper.outHeader.FieldIds = nil
+	r.helper.outHeader.OriginalFields = nil
+	sample = r.helper.convertSample(sample)
+	return r.NoopProcessor.Sample(sample, r.helper.outHeader)
+}
+
+func (r *FieldIdRestorer) constructIndices(header *bitflow.Header) ([]int, error) {
+	indices := make([]int, len(header.Fields))
+	seen := make(map[int]bool, len(header.Fields))
+	for i, id := range header.FieldIds {
+		if id < 0 || id >= len(header.OriginalFields) {
+			return nil, fmt.Errorf("%v: Invalid field id %v of field %v (%v original fields)", r, id, header.Fields[i], len(header.OriginalFields))
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("%v: Duplicate field id %v of field %v", r, id, header.Fields[i])
+		}
+		seen[id] = true
+		indices[i] = i
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return header.FieldIds[indices[i]] < header.FieldIds[indices[j]]
+	})
+	return indices, nil
+}
+
+func (r *FieldIdRestorer) String() string {
+	return "Restore fields by stable field ids"
+}
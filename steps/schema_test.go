@@ -0,0 +1,54 @@
+package steps
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestSchemaStableRenameAndRemap(t *testing.T) {
+	assert := testAssert.New(t)
+	assigner := new(FieldIdAssigner)
+	renamer := NewMetricRenamer([]*regexp.Regexp{regexp.MustCompile("^(.*)_in$")}, []string{"z_$1"})
+	mapper := NewMetricMapper([]string{"z_c", "b", "z_a"})
+	restorer := new(FieldIdRestorer)
+	out := new(testSampleCollector)
+	assigner.SetSink(renamer)
+	renamer.SetSink(mapper)
+	mapper.SetSink(restorer)
+	restorer.SetSink(out)
+	for _, step := range []bitflow.SampleProcessor{assigner, renamer, mapper, restorer} {
+		step.Start(new(sync.WaitGroup))
+	}
+
+	header := &bitflow.Header{Fields: []string{"a_in", "d", "c_in", "b"}}
+	assert.NoError(assigner.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2, 3, 4}}, header))
+	assert.NoError(assigner.Sample(&bitflow.Sample{Values: []bitflow.Value{5, 6, 7, 8}}, header))
+
+	// The renamer sorts the fields, the mapper reorders them and removes d
+	assert.Equal([]string{"z_c", "b", "z_a"}, mapper.helper.outHeader.Fields)
+	assert.Equal([]int{2, 3, 0}, mapper.helper.outHeader.FieldIds)
+
+	assert.Len(out.samples, 2)
+	assert.Equal([]string{"a_in", "c_in", "b"}, out.headers[0].Fields)
+	assert.False(out.headers[0].HasFieldIds())
+	assert.True(out.headers[0] == out.headers[1])
+	assert.Equal([]bitflow.Value{1, 3, 4}, out.samples[0].Values)
+	assert.Equal([]bitflow.Value{5, 7, 8}, out.samples[1].Values)
+	assert.Equal([]string{"a_in", "d", "c_in", "b"}, header.Fields, "the input header must not be modified")
+}
+
+func TestSchemaStableMissingIds(t *testing.T) {
+	assert := testAssert.New(t)
+	restorer := new(FieldIdRestorer)
+	restorer.SetSink(new(testSampleCollector))
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	assert.Error(restorer.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, header))
+
+	header.FieldIds = []int{0, 0}
+	header.OriginalFields = []string{"a", "b"}
+	assert.Error(restorer.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, header))
+}
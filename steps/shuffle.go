@@ -10,7 +10,8 @@ import (
 
 func NewSampleShuffler() *bitflow.SimpleBatchProcessingStep {
 	return &bitflow.SimpleBatchProcessingStep{
-		Description: "sample shuffler",
+		Description:  "sample shuffler",
+		EmptyBatches: true,
 		Process: func(header *bitflow.Header, samples []*bitflow.Sample) (*bitflow.Header, []*bitflow.Sample, error) {
 			log.Println("Shuffling", len(samples), "samples")
 			for i := range samples {
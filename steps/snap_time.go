@@ -0,0 +1,140 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	SnapKeepAll   = "keep-all"
+	SnapKeepFirst = "keep-first"
+	SnapAggregate = "aggregate"
+)
+
+func RegisterTimeSnapper(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("snap_time",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &TimeSnapper{
+				Interval:    reg.DurationParam(params, "interval", 0, false, &err),
+				OnCollision: reg.StrParam(params, "on-collision", SnapKeepAll, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			if step.Interval <= 0 {
+				return reg.ParameterError("interval", errors.New("Must be > 0"))
+			}
+			switch step.OnCollision {
+			case SnapKeepAll, SnapKeepFirst, SnapAggregate:
+			default:
+				return reg.ParameterError("on-collision", fmt.Errorf("Must be one of %v, %v, %v", SnapKeepAll, SnapKeepFirst, SnapAggregate))
+			}
+			p.Add(step)
+			return
+		},
+		"Round the timestamp of every sample to the nearest multiple of the interval, counted from the Unix epoch. "+
+			"Consecutive samples that snap to the same timestamp are all forwarded (on-collision="+SnapKeepAll+", default), "+
+			"only the first one is forwarded ("+SnapKeepFirst+"), or they are merged into one sample with the mean of their values and the tags of the first sample ("+SnapAggregate+").",
+		reg.RequiredParams("interval"), reg.OptionalParams("on-collision"))
+}
+
+// SnapTime rounds the given timestamp to the nearest multiple of the interval, counted from the Unix epoch.
+// In contrast to time.Time.Round(), the grid is independent of the zero time.
+func SnapTime(t time.Time, interval time.Duration) time.Time {
+	nanos := t.UnixNano()
+	remainder := nanos % int64(interval)
+	if remainder < 0 {
+		remainder += int64(interval)
+	}
+	if remainder*2 >= int64(interval) {
+		nanos += int64(interval) - remainder
+	} else {
+		nanos -= remainder
+	}
+	return time.Unix(0, nanos).In(t.Location())
+}
+
+// TimeSnapper rounds the timestamps of all samples to a grid defined by Interval. Consecutive samples with the
+// same resulting timestamp are handled according to OnCollision: SnapKeepAll forwards all of them, SnapKeepFirst
+// only forwards the first one, and SnapAggregate forwards a single sample with the mean values of all samples,
+// ignoring NaN values. Aggregated samples are forwarded when a sample with a different timestamp or header arrives.
+type TimeSnapper struct {
+	bitflow.NoopProcessor
+	Interval    time.Duration
+	OnCollision string
+
+	checker       bitflow.HeaderChecker
+	lastTime      time.Time
+	pending       *bitflow.Sample
+	pendingHeader *bitflow.Header
+	sums          []float64
+	counts        []int
+}
+
+func (s *TimeSnapper) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	sample.Time = SnapTime(sample.Time, s.Interval)
+	headerChanged := s.checker.HeaderChanged(header)
+	switch s.OnCollision {
+	case SnapKeepFirst:
+		if !headerChanged && sample.Time.Equal(s.lastTime) {
+			return nil
+		}
+		s.lastTime = sample.Time
+	case SnapAggregate:
+		if s.pending != nil && (headerChanged || !sample.Time.Equal(s.pending.Time)) {
+			if err := s.flush(); err != nil {
+				return err
+			}
+		}
+		s.aggregate(sample, header)
+		return nil
+	}
+	return s.NoopProcessor.Sample(sample, header)
+}
+
+func (s *TimeSnapper) aggregate(sample *bitflow.Sample, header *bitflow.Header) {
+	if s.pending == nil {
+		s.pending = sample
+		s.pendingHeader = header
+		s.sums = make([]float64, len(sample.Values))
+		s.counts = make([]int, len(sample.Values))
+	}
+	for i, val := range sample.Values {
+		if i < len(s.sums) && !math.IsNaN(float64(val)) {
+			s.sums[i] += float64(val)
+			s.counts[i]++
+		}
+	}
+}
+
+func (s *TimeSnapper) flush() error {
+	sample, header := s.pending, s.pendingHeader
+	s.pending, s.pendingHeader = nil, nil
+	if sample == nil {
+		return nil
+	}
+	for i, sum := range s.sums {
+		if s.counts[i] == 0 {
+			sample.Values[i] = bitflow.Value(math.NaN())
+		} else {
+			sample.Values[i] = bitflow.Value(sum / float64(s.counts[i]))
+		}
+	}
+	return s.NoopProcessor.Sample(sample, header)
+}
+
+func (s *TimeSnapper) Close() {
+	if err := s.flush(); err != nil {
+		s.Error(err)
+	}
+	s.NoopProcessor.Close()
+}
+
+func (s *TimeSnapper) String() string {
+	return fmt.Sprintf("Snap timestamps to %v (on collision: %v)", s.Interval, s.OnCollision)
+}
@@ -0,0 +1,76 @@
+package steps
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestSnapTime(t *testing.T) {
+	assert := testAssert.New(t)
+	base := time.Unix(1000, 0)
+	assert.Equal(base, SnapTime(base.Add(400*time.Millisecond), time.Second))
+	assert.Equal(base.Add(time.Second), SnapTime(base.Add(500*time.Millisecond), time.Second))
+	assert.Equal(base.Add(-time.Second), SnapTime(base.Add(-999*time.Millisecond), time.Second))
+
+	// The grid starts at the Unix epoch, also for intervals that do not divide a day
+	assert.Equal(time.Unix(1001, 0), SnapTime(time.Unix(1000, 0), 7*time.Second))
+	assert.Equal(time.Unix(-7, 0), SnapTime(time.Unix(-5, 0), 7*time.Second))
+}
+
+func _snapSamples(t *testing.T, policy string) *testSampleCollector {
+	assert := testAssert.New(t)
+	step := &TimeSnapper{Interval: 10 * time.Second, OnCollision: policy}
+	out := new(testSampleCollector)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a"}}
+	base := time.Unix(1000, 0)
+	jitter := []time.Duration{3 * time.Microsecond, 9999 * time.Millisecond, 10*time.Second + 12*time.Microsecond, 30*time.Second - 5*time.Microsecond}
+	values := []bitflow.Value{1, 2, 6, 4}
+	for i, offset := range jitter {
+		assert.NoError(step.Sample(&bitflow.Sample{Time: base.Add(offset), Values: []bitflow.Value{values[i]}}, header))
+	}
+	assert.NoError(step.Sample(&bitflow.Sample{Time: base.Add(30 * time.Second), Values: []bitflow.Value{bitflow.Value(math.NaN())}}, header))
+	step.Close()
+	return out
+}
+
+func _sampleTimesAndValues(out *testSampleCollector) ([]int64, []bitflow.Value) {
+	var times []int64
+	var values []bitflow.Value
+	for _, sample := range out.samples {
+		times = append(times, sample.Time.Unix())
+		values = append(values, sample.Values[0])
+	}
+	return times, values
+}
+
+func TestTimeSnapperKeepAll(t *testing.T) {
+	assert := testAssert.New(t)
+	times, values := _sampleTimesAndValues(_snapSamples(t, SnapKeepAll))
+	assert.Equal([]int64{1000, 1010, 1010, 1030, 1030}, times)
+	assert.Equal([]bitflow.Value{1, 2, 6, 4}, values[:4])
+	for _, sample := range _snapSamples(t, SnapKeepAll).samples {
+		assert.Equal(0, sample.Time.Nanosecond())
+	}
+}
+
+func TestTimeSnapperKeepFirst(t *testing.T) {
+	assert := testAssert.New(t)
+	times, values := _sampleTimesAndValues(_snapSamples(t, SnapKeepFirst))
+	assert.Equal([]int64{1000, 1010, 1030}, times)
+	assert.Equal([]bitflow.Value{1, 2, 4}, values)
+}
+
+func TestTimeSnapperAggregate(t *testing.T) {
+	assert := testAssert.New(t)
+	times, values := _sampleTimesAndValues(_snapSamples(t, SnapAggregate))
+	assert.Equal([]int64{1000, 1010, 1030}, times)
+	assert.Equal([]bitflow.Value{1, 4, 4}, values, "NaN values are ignored")
+}
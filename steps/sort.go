@@ -47,6 +47,10 @@ func (sorter *SampleSorter) ProcessBatch(header *bitflow.Header, samples []*bitf
 	return header, samples, nil
 }
 
+func (sorter *SampleSorter) AcceptsEmptyBatches() bool {
+	return true
+}
+
 func (sorter *SampleSorter) String() string {
 	all := make([]string, len(sorter.Tags)+1)
 	copy(all, sorter.Tags)
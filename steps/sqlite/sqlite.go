@@ -0,0 +1,244 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SqliteEndpoint = bitflow.EndpointType("sqlite")
+
+	DefaultSqliteTable     = "samples"
+	DefaultSqliteBatchSize = 1000
+
+	// SqliteTimeFormat is used for the time column. It is understood by the date and time functions
+	// of SQLite and sorts lexicographically.
+	SqliteTimeFormat = "2006-01-02 15:04:05.000000000"
+
+	SqliteTimeColumn = "time"
+	SqliteTagsColumn = "tags"
+)
+
+// RegisterSqliteEndpoints registers the 'sqlite' data sink. The endpoint target is the path of the database file,
+// e.g. sqlite://data/samples.db. The file is created, if it does not exist.
+func RegisterSqliteEndpoints(b reg.ProcessorRegistry) {
+	table := DefaultSqliteTable
+	batchSize := DefaultSqliteBatchSize
+	b.Endpoints.CustomOutputFlags = append(b.Endpoints.CustomOutputFlags, func(f *flag.FlagSet) {
+		f.StringVar(&table, "sqlite-table", table, "Name of the table that SQLite outputs write samples into")
+		f.IntVar(&batchSize, "sqlite-batch", batchSize, "Number of samples that SQLite outputs insert within one transaction")
+	})
+	b.Endpoints.CustomDataSinks[SqliteEndpoint] = func(target string) (bitflow.SampleProcessor, error) {
+		if target == "" {
+			return nil, fmt.Errorf("The %v endpoint requires the path of the database file", SqliteEndpoint)
+		}
+		if table == "" {
+			return nil, fmt.Errorf("The SQLite table name must not be empty")
+		}
+		if batchSize < 1 {
+			return nil, fmt.Errorf("The SQLite batch size must be positive (was %v)", batchSize)
+		}
+		return &SqliteSink{
+			File:      target,
+			Table:     table,
+			BatchSize: batchSize,
+		}, nil
+	}
+}
+
+// SqliteSink inserts samples into a table of an SQLite database. The table contains one column for the
+// timestamp (see SqliteTimeFormat), one column with the tags of the sample encoded as JSON object, and one REAL column
+// for every metric. The table is created, if it does not exist, and columns for new metrics are added when the header changes.
+// Metrics that are not part of the current header are stored as NULL, as well as NaN values.
+// Samples are inserted in batches of BatchSize samples, each within one transaction. Remaining samples are inserted
+// when the sink is closed.
+type SqliteSink struct {
+	bitflow.AbstractSampleOutput
+	File      string
+	Table     string
+	BatchSize int
+
+	db          *sql.DB
+	columns     map[string]bool // Lower-case names of the existing columns, since SQLite column names are case-insensitive
+	checker     bitflow.HeaderChecker
+	insertQuery string
+	rows        [][]interface{}
+}
+
+func (sink *SqliteSink) String() string {
+	return fmt.Sprintf("SQLite output (%v, table %v, batch size %v)", sink.File, sink.Table, sink.BatchSize)
+}
+
+func (sink *SqliteSink) Start(wg *sync.WaitGroup) (_ golib.StopChan) {
+	db, err := sql.Open("sqlite3", sink.File)
+	if err == nil {
+		sink.db = db
+		err = sink.createTable()
+	}
+	if err != nil {
+		return golib.NewStoppedChan(fmt.Errorf("%v: Failed to initialize database: %v", sink, err))
+	}
+	log.Println("Writing samples to SQLite table", sink.Table, "in", sink.File)
+	return
+}
+
+func (sink *SqliteSink) createTable() error {
+	_, err := sink.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (%v TEXT NOT NULL, %v TEXT NOT NULL)",
+		quoteIdentifier(sink.Table), quoteIdentifier(SqliteTimeColumn), quoteIdentifier(SqliteTagsColumn)))
+	if err != nil {
+		return err
+	}
+	rows, err := sink.db.Query(fmt.Sprintf("PRAGMA table_info(%v)", quoteIdentifier(sink.Table)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // Drop error
+	sink.columns = make(map[string]bool)
+	for rows.Next() {
+		var (
+			index, notNull, primaryKey int
+			name, columnType           string
+			defaultValue               interface{}
+		)
+		if err := rows.Scan(&index, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return err
+		}
+		sink.columns[strings.ToLower(name)] = true
+	}
+	return rows.Err()
+}
+
+func (sink *SqliteSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	err := sink.insert(sample, header)
+	return sink.AbstractSampleOutput.Sample(err, sample, header)
+}
+
+func (sink *SqliteSink) insert(sample *bitflow.Sample, header *bitflow.Header) error {
+	if sink.checker.HeaderChanged(header) {
+		// The buffered rows were prepared for the previous insert statement
+		if err := sink.flush(); err != nil {
+			return err
+		}
+		if err := sink.updateSchema(header); err != nil {
+			return err
+		}
+	}
+	tags, err := sink.encodeTags(sample)
+	if err != nil {
+		return err
+	}
+	row := make([]interface{}, 0, len(header.Fields)+2)
+	row = append(row, sample.Time.UTC().Format(SqliteTimeFormat), tags)
+	for _, value := range sample.Values {
+		if math.IsNaN(float64(value)) {
+			row = append(row, nil)
+		} else {
+			row = append(row, float64(value))
+		}
+	}
+	sink.rows = append(sink.rows, row)
+	if len(sink.rows) >= sink.BatchSize {
+		return sink.flush()
+	}
+	return nil
+}
+
+func (sink *SqliteSink) encodeTags(sample *bitflow.Sample) (string, error) {
+	tags := make(map[string]string)
+	for _, tag := range sample.SortedTags() {
+		tags[tag.Key] = tag.Value
+	}
+	data, err := json.Marshal(tags)
+	return string(data), err
+}
+
+func (sink *SqliteSink) updateSchema(header *bitflow.Header) error {
+	columns := []string{quoteIdentifier(SqliteTimeColumn), quoteIdentifier(SqliteTagsColumn)}
+	seen := map[string]bool{SqliteTimeColumn: true, SqliteTagsColumn: true}
+	for _, field := range header.Fields {
+		lower := strings.ToLower(field)
+		if seen[lower] {
+			return fmt.Errorf("%v: Metric '%v' collides with another column (SQLite column names are case-insensitive)", sink, field)
+		}
+		seen[lower] = true
+		column := quoteIdentifier(field)
+		if !sink.columns[lower] {
+			_, err := sink.db.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v REAL", quoteIdentifier(sink.Table), column))
+			if err != nil {
+				return fmt.Errorf("%v: Failed to add column for metric '%v': %v", sink, field, err)
+			}
+			sink.columns[lower] = true
+		}
+		columns = append(columns, column)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	sink.insertQuery = fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v)",
+		quoteIdentifier(sink.Table), strings.Join(columns, ", "), placeholders)
+	return nil
+}
+
+// flush inserts all buffered rows within one transaction
+func (sink *SqliteSink) flush() error {
+	if len(sink.rows) == 0 {
+		return nil
+	}
+	rows := sink.rows
+	sink.rows = nil
+	tx, err := sink.db.Begin()
+	if err != nil {
+		return fmt.Errorf("%v: Failed to begin transaction: %v", sink, err)
+	}
+	err = sink.insertRows(tx, rows)
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Errorf("%v: Failed to roll back transaction: %v", sink, rollbackErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%v: Failed to insert %v sample(s): %v", sink, len(rows), err)
+	}
+	return nil
+}
+
+func (sink *SqliteSink) insertRows(tx *sql.Tx, rows [][]interface{}) error {
+	stmt, err := tx.Prepare(sink.insertQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close() // Drop error
+	for _, row := range rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sink *SqliteSink) Close() {
+	if sink.db != nil {
+		if err := sink.flush(); err != nil {
+			log.Errorln(err)
+		}
+		if err := sink.db.Close(); err != nil {
+			log.Errorf("%v: Error closing database: %v", sink, err)
+		}
+	}
+	sink.CloseSink()
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
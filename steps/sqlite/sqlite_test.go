@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+type sqliteRow struct {
+	time    string
+	tags    string
+	a, b, c sql.NullFloat64
+}
+
+func _querySamples(t *testing.T, file string) []sqliteRow {
+	assert := testAssert.New(t)
+	db, err := sql.Open("sqlite3", file)
+	assert.NoError(err)
+	defer db.Close() // Drop error
+	rows, err := db.Query(`SELECT "time", "tags", "a", "b", "c" FROM "samples" ORDER BY rowid`)
+	assert.NoError(err)
+	defer rows.Close() // Drop error
+	var result []sqliteRow
+	for rows.Next() {
+		var row sqliteRow
+		assert.NoError(rows.Scan(&row.time, &row.tags, &row.a, &row.b, &row.c))
+		result = append(result, row)
+	}
+	assert.NoError(rows.Err())
+	return result
+}
+
+// _countSamples does not select any metric columns, because SQLite interprets the quoted name of a missing column as a string
+func _countSamples(t *testing.T, file string) int {
+	assert := testAssert.New(t)
+	db, err := sql.Open("sqlite3", file)
+	assert.NoError(err)
+	defer db.Close() // Drop error
+	var count int
+	assert.NoError(db.QueryRow(`SELECT COUNT(*) FROM "samples"`).Scan(&count))
+	return count
+}
+
+func TestSqliteSink(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-sqlite-")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+	file := filepath.Join(dir, "samples.db")
+
+	sink := &SqliteSink{File: file, Table: DefaultSqliteTable, BatchSize: 2}
+	sink.SetSink(new(bitflow.DroppingSampleProcessor))
+	sink.Start(new(sync.WaitGroup))
+	assert.NotNil(sink.columns, "the table should have been initialized")
+
+	timestamp := time.Date(2019, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	for i := 0; i < 3; i++ {
+		sample := &bitflow.Sample{Time: timestamp.Add(time.Duration(i) * time.Second), Values: []bitflow.Value{bitflow.Value(i) + 0.5, -1}}
+		if i == 1 {
+			sample.SetTag("host", "h1")
+			sample.SetTag("cls", `"quoted"`)
+		}
+		assert.NoError(sink.Sample(sample, header))
+	}
+	assert.Equal(2, _countSamples(t, file), "the third sample should still be buffered")
+
+	// The changed header adds a column, the removed metric 'a' is stored as NULL
+	assert.NoError(sink.Sample(&bitflow.Sample{Time: timestamp, Values: []bitflow.Value{bitflow.Value(math.NaN()), 42}}, &bitflow.Header{Fields: []string{"b", "c"}}))
+	assert.Equal(3, _countSamples(t, file), "the header change should flush the buffered sample")
+	sink.Close()
+
+	rows := _querySamples(t, file)
+	assert.Len(rows, 4)
+	assert.Equal("2019-03-01 12:30:00.123456789", rows[0].time)
+	assert.Equal("2019-03-01 12:30:02.123456789", rows[2].time)
+	assert.Equal("{}", rows[0].tags)
+	assert.Equal(`{"cls":"\"quoted\"","host":"h1"}`, rows[1].tags)
+	for i := 0; i < 3; i++ {
+		assert.Equal(sql.NullFloat64{Float64: float64(i) + 0.5, Valid: true}, rows[i].a)
+		assert.Equal(sql.NullFloat64{Float64: -1, Valid: true}, rows[i].b)
+		assert.False(rows[i].c.Valid)
+	}
+	assert.False(rows[3].a.Valid)
+	assert.False(rows[3].b.Valid, "NaN is stored as NULL")
+	assert.Equal(sql.NullFloat64{Float64: 42, Valid: true}, rows[3].c)
+
+	// A new sink reuses the existing table
+	sink = &SqliteSink{File: file, Table: DefaultSqliteTable, BatchSize: 10}
+	sink.SetSink(new(bitflow.DroppingSampleProcessor))
+	sink.Start(new(sync.WaitGroup))
+	assert.NotNil(sink.columns, "the table should have been initialized")
+	assert.NoError(sink.Sample(&bitflow.Sample{Time: timestamp, Values: []bitflow.Value{1, 2, 3}}, &bitflow.Header{Fields: []string{"C", "b", "a"}}))
+	sink.Close()
+	rows = _querySamples(t, file)
+	assert.Len(rows, 5)
+	assert.Equal(sqliteRow{
+		time: "2019-03-01 12:30:00.123456789",
+		tags: "{}",
+		a:    sql.NullFloat64{Float64: 3, Valid: true},
+		b:    sql.NullFloat64{Float64: 2, Valid: true},
+		c:    sql.NullFloat64{Float64: 1, Valid: true},
+	}, rows[4])
+}
+
+func TestSqliteSinkColumnCollision(t *testing.T) {
+	assert := testAssert.New(t)
+	dir, err := ioutil.TempDir("", "bitflow-sqlite-")
+	assert.NoError(err)
+	defer os.RemoveAll(dir) // Drop error
+
+	sink := &SqliteSink{File: filepath.Join(dir, "samples.db"), Table: DefaultSqliteTable, BatchSize: 1}
+	sink.SetSink(new(bitflow.DroppingSampleProcessor))
+	sink.Start(new(sync.WaitGroup))
+	defer sink.Close()
+	assert.Error(sink.Sample(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"Time"}}))
+	assert.Error(sink.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, &bitflow.Header{Fields: []string{"x", "X"}}))
+}
@@ -0,0 +1,109 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	CrossingRising  = "rising"
+	CrossingFalling = "falling"
+	CrossingBoth    = "both"
+
+	DefaultCrossingTag = "crossing"
+)
+
+func RegisterThresholdCrossing(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("crossing",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &ThresholdCrossing{
+				Metric:     reg.StrParam(params, "metric", "", false, &err),
+				Threshold:  reg.FloatParam(params, "threshold", 0, false, &err),
+				Edge:       reg.StrParam(params, "edge", CrossingBoth, true, &err),
+				Hysteresis: reg.FloatParam(params, "hysteresis", 0, true, &err),
+				Tag:        reg.StrParam(params, "tag", DefaultCrossingTag, true, &err),
+			}
+			if err != nil {
+				return
+			}
+			switch step.Edge {
+			case CrossingRising, CrossingFalling, CrossingBoth:
+			default:
+				return reg.ParameterError("edge", fmt.Errorf("Must be one of %v, %v, %v", CrossingRising, CrossingFalling, CrossingBoth))
+			}
+			if step.Hysteresis < 0 {
+				return reg.ParameterError("hysteresis", errors.New("Must not be negative"))
+			}
+			p.Add(step)
+			return
+		},
+		"Forward only the samples where the given metric crosses the threshold, dropping all other samples. The edge can be rising, falling or both (default). "+
+			"With a hysteresis, the metric must exceed threshold+hysteresis for a rising edge, and fall below threshold-hysteresis for a falling edge. "+
+			"The forwarded samples receive the tag (default '"+DefaultCrossingTag+"', empty to disable) with the value rising or falling.",
+		reg.RequiredParams("metric", "threshold"), reg.OptionalParams("edge", "hysteresis", "tag"))
+}
+
+// ThresholdCrossing forwards only those samples where the value of Metric crosses the Threshold in the direction
+// defined by Edge. A rising edge occurs when the value exceeds Threshold+Hysteresis after previously being below
+// the Threshold-Hysteresis, and vice versa for a falling edge. The first sample only initializes the state and is
+// not forwarded. Samples with a NaN value do not change the state. If Tag is set, forwarded samples receive it with
+// the value CrossingRising or CrossingFalling.
+type ThresholdCrossing struct {
+	bitflow.NoopProcessor
+	Metric     string
+	Threshold  float64
+	Edge       string
+	Hysteresis float64
+	Tag        string
+
+	checker bitflow.HeaderChecker
+	index   int
+	state   int // 1 when above the threshold, -1 when below, 0 when unknown
+}
+
+func (c *ThresholdCrossing) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if c.checker.HeaderChanged(header) {
+		var ok bool
+		if c.index, ok = header.BuildIndex()[c.Metric]; !ok {
+			return fmt.Errorf("%v: Metric %v not found in header", c, c.Metric)
+		}
+	}
+	if c.index >= len(sample.Values) {
+		return fmt.Errorf("%v: Sample has %v values, but metric %v has index %v", c, len(sample.Values), c.Metric, c.index)
+	}
+
+	val := float64(sample.Values[c.index])
+	var edge string
+	switch {
+	case math.IsNaN(val):
+	case val > c.Threshold+c.Hysteresis && c.state != 1:
+		if c.state != 0 {
+			edge = CrossingRising
+		}
+		c.state = 1
+	case val < c.Threshold-c.Hysteresis && c.state != -1:
+		if c.state != 0 {
+			edge = CrossingFalling
+		}
+		c.state = -1
+	}
+	if edge == "" || (c.Edge != CrossingBoth && c.Edge != edge) {
+		return nil
+	}
+	if c.Tag != "" {
+		sample.SetTag(c.Tag, edge)
+	}
+	return c.NoopProcessor.Sample(sample, header)
+}
+
+func (c *ThresholdCrossing) String() string {
+	res := fmt.Sprintf("Forward %v crossings of %v over threshold %v", c.Edge, c.Metric, c.Threshold)
+	if c.Hysteresis > 0 {
+		res += fmt.Sprintf(" (hysteresis %v)", c.Hysteresis)
+	}
+	return res
+}
@@ -0,0 +1,66 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func _runCrossing(t *testing.T, step *ThresholdCrossing, values ...bitflow.Value) *testSampleCollector {
+	assert := testAssert.New(t)
+	out := new(testSampleCollector)
+	step.SetSink(out)
+	step.Start(new(sync.WaitGroup))
+	header := &bitflow.Header{Fields: []string{"other", "signal"}}
+	for i, val := range values {
+		assert.NoError(step.Sample(&bitflow.Sample{Values: []bitflow.Value{bitflow.Value(i), val}}, header))
+	}
+	return out
+}
+
+func _crossingIndices(out *testSampleCollector) (indices []bitflow.Value, edges []string) {
+	for _, sample := range out.samples {
+		indices = append(indices, sample.Values[0])
+		edges = append(edges, sample.Tag(DefaultCrossingTag))
+	}
+	return
+}
+
+func TestThresholdCrossingHysteresis(t *testing.T) {
+	assert := testAssert.New(t)
+	// The signal oscillates slightly around the threshold 10, with two genuine crossings
+	signal := []bitflow.Value{5, 9.8, 10.2, 9.9, 10.1, 9.7, 12, 10.3, 9.9, 10.2, 9.6, 8, 10.4, 9.5}
+	out := _runCrossing(t, &ThresholdCrossing{Metric: "signal", Threshold: 10, Edge: CrossingBoth, Hysteresis: 1, Tag: DefaultCrossingTag}, signal...)
+	indices, edges := _crossingIndices(out)
+	assert.Equal([]bitflow.Value{6, 11}, indices)
+	assert.Equal([]string{CrossingRising, CrossingFalling}, edges)
+
+	// Without hysteresis, every oscillation is a crossing
+	out = _runCrossing(t, &ThresholdCrossing{Metric: "signal", Threshold: 10, Edge: CrossingBoth, Tag: DefaultCrossingTag}, signal...)
+	indices, _ = _crossingIndices(out)
+	assert.Equal([]bitflow.Value{2, 3, 4, 5, 6, 8, 9, 10, 12, 13}, indices)
+}
+
+func TestThresholdCrossingEdges(t *testing.T) {
+	assert := testAssert.New(t)
+	signal := []bitflow.Value{0, 2, 0, 2, 0}
+	out := _runCrossing(t, &ThresholdCrossing{Metric: "signal", Threshold: 1, Edge: CrossingRising, Tag: DefaultCrossingTag}, signal...)
+	indices, edges := _crossingIndices(out)
+	assert.Equal([]bitflow.Value{1, 3}, indices)
+	assert.Equal([]string{CrossingRising, CrossingRising}, edges)
+
+	out = _runCrossing(t, &ThresholdCrossing{Metric: "signal", Threshold: 1, Edge: CrossingFalling}, signal...)
+	indices, edges = _crossingIndices(out)
+	assert.Equal([]bitflow.Value{2, 4}, indices)
+	assert.Equal([]string{"", ""}, edges, "no tag configured")
+
+	// The first sample only initializes the state
+	out = _runCrossing(t, &ThresholdCrossing{Metric: "signal", Threshold: 1, Edge: CrossingBoth}, 2, 3, 4)
+	assert.Empty(out.samples)
+
+	step := &ThresholdCrossing{Metric: "missing", Threshold: 1, Edge: CrossingBoth}
+	step.SetSink(new(testSampleCollector))
+	assert.Error(step.Sample(&bitflow.Sample{Values: []bitflow.Value{1}}, &bitflow.Header{Fields: []string{"x"}}))
+}
@@ -0,0 +1,202 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	// RateLimitDrop makes the RateLimitProcessor drop all samples exceeding the rate limit.
+	RateLimitDrop = "drop"
+
+	// RateLimitMean makes the RateLimitProcessor average all samples exceeding the rate limit
+	// into the next forwarded sample.
+	RateLimitMean = "mean"
+)
+
+func RegisterRateLimiter(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("throttle",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &RateLimitProcessor{
+				Rate: reg.FloatParam(params, "rate", 0, false, &err),
+				Tag:  reg.StrParam(params, "tag", "", true, &err),
+				Mode: reg.StrParam(params, "mode", RateLimitDrop, true, &err),
+			}
+			if err == nil && (step.Rate <= 0 || math.IsInf(step.Rate, 0) || math.IsNaN(step.Rate)) {
+				err = reg.ParameterError("rate", errors.New("Must be a positive number"))
+			}
+			if err == nil && step.Mode != RateLimitDrop && step.Mode != RateLimitMean {
+				err = reg.ParameterError("mode", fmt.Errorf("Must be either '%v' or '%v'", RateLimitDrop, RateLimitMean))
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		"Forward at most the given rate of samples per second (measured in wall-clock time). When tag is given, every value of that tag is limited separately. "+
+			"With mode=drop (default), the samples exceeding the rate are dropped. With mode=mean, they are averaged into the next forwarded sample.",
+		reg.RequiredParams("rate"), reg.OptionalParams("tag", "mode"))
+}
+
+// RateLimitProcessor forwards at most Rate samples per second, measured in wall-clock time. The rate is enforced
+// with a token bucket that holds at most one token, so forwarded samples are at least 1/Rate seconds apart.
+// If Tag is set, every value of that tag has its own token bucket. In the RateLimitDrop mode, samples arriving
+// without an available token are dropped. In the RateLimitMean mode, the values of these samples are accumulated
+// and averaged into the next forwarded sample. All token buckets are reset when the header changes.
+// Buckets of tag values that did not receive samples for 1/Rate seconds are removed, after forwarding their
+// pending averaged values.
+type RateLimitProcessor struct {
+	bitflow.NoopProcessor
+	Rate float64
+	Tag  string
+	Mode string
+
+	header    *bitflow.Header
+	buckets   map[string]*rateLimitBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type rateLimitBucket struct {
+	tokens float64
+	last   time.Time
+
+	// Accumulated values of the samples that exceeded the rate, used in the RateLimitMean mode
+	sum    []float64
+	count  int
+	sample *bitflow.Sample
+}
+
+func (r *RateLimitProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	now := r.currentTime()
+	if r.buckets == nil || !header.Equals(r.header) {
+		if err := r.flushAll(); err != nil {
+			return err
+		}
+		r.header = header
+		r.buckets = make(map[string]*rateLimitBucket)
+		r.lastSweep = now
+	}
+	var key string
+	if r.Tag != "" {
+		key = sample.Tag(r.Tag)
+	}
+	if err := r.sweep(now, key); err != nil {
+		return err
+	}
+
+	bucket, ok := r.buckets[key]
+	if !ok {
+		bucket = &rateLimitBucket{tokens: 1, last: now}
+		r.buckets[key] = bucket
+	}
+	if !bucket.take(now, r.Rate) {
+		if r.Mode == RateLimitMean {
+			bucket.add(sample)
+		}
+		return nil
+	}
+	if bucket.count > 0 {
+		bucket.add(sample)
+		sample = bucket.mean()
+	}
+	return r.NoopProcessor.Sample(sample, header)
+}
+
+func (r *RateLimitProcessor) currentTime() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+func (r *RateLimitProcessor) interval() time.Duration {
+	return time.Duration(float64(time.Second) / r.Rate)
+}
+
+// sweep removes the buckets that were idle for at least one interval, so that the state of tag values that stop
+// appearing does not accumulate. A bucket idle for that long is full, so forwarding its pending values does not exceed the rate.
+func (r *RateLimitProcessor) sweep(now time.Time, currentKey string) error {
+	interval := r.interval()
+	if now.Sub(r.lastSweep) < interval {
+		return nil
+	}
+	r.lastSweep = now
+	for key, bucket := range r.buckets {
+		if key == currentKey || now.Sub(bucket.last) < interval {
+			continue
+		}
+		delete(r.buckets, key)
+		if bucket.count > 0 {
+			if err := r.NoopProcessor.Sample(bucket.mean(), r.header); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *RateLimitProcessor) flushAll() error {
+	for key, bucket := range r.buckets {
+		delete(r.buckets, key)
+		if bucket.count > 0 {
+			if err := r.NoopProcessor.Sample(bucket.mean(), r.header); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *RateLimitProcessor) Close() {
+	if err := r.flushAll(); err != nil {
+		r.Error(err)
+	}
+	r.NoopProcessor.Close()
+}
+
+func (r *RateLimitProcessor) String() string {
+	res := fmt.Sprintf("Throttle to %v samples/s (%v)", r.Rate, r.Mode)
+	if r.Tag != "" {
+		res += " per " + r.Tag
+	}
+	return res
+}
+
+func (b *rateLimitBucket) take(now time.Time, rate float64) bool {
+	b.tokens = math.Min(1, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+func (b *rateLimitBucket) add(sample *bitflow.Sample) {
+	if b.sum == nil {
+		b.sum = make([]float64, len(sample.Values))
+	}
+	for i, value := range sample.Values {
+		b.sum[i] += float64(value)
+	}
+	b.count++
+	b.sample = sample
+}
+
+// mean returns a sample with the average of the accumulated values and the metadata of the last accumulated sample,
+// and clears the accumulated values.
+func (b *rateLimitBucket) mean() *bitflow.Sample {
+	result := b.sample.Clone()
+	result.Values = make([]bitflow.Value, len(b.sum))
+	for i, sum := range b.sum {
+		result.Values[i] = bitflow.Value(sum / float64(b.count))
+	}
+	b.sum, b.count, b.sample = nil, 0, nil
+	return result
+}
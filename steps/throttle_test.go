@@ -0,0 +1,77 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitProcessor(t *testing.T) {
+	assert := testAssert.New(t)
+	now := time.Unix(1000, 0)
+	r := &RateLimitProcessor{Rate: 2, Tag: "host", Mode: RateLimitMean, now: func() time.Time { return now }}
+	out := new(testSampleCollector)
+	r.SetSink(out)
+	r.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a"}}
+	send := func(offset time.Duration, host string, value float64) {
+		now = time.Unix(1000, 0).Add(offset)
+		sample := &bitflow.Sample{Time: now, Values: []bitflow.Value{bitflow.Value(value)}}
+		sample.SetTag("host", host)
+		assert.NoError(r.Sample(sample, header))
+	}
+
+	send(0, "x", 1)                    // Forwarded
+	send(0, "y", 10)                   // Forwarded, other identity
+	send(100*time.Millisecond, "x", 2) // Accumulated
+	send(200*time.Millisecond, "x", 3) // Accumulated
+	assert.Len(r.buckets, 2)
+
+	// Bucket y is removed, since it was idle for longer than 1/rate
+	send(550*time.Millisecond, "x", 4) // Forwarded with the mean of 2, 3, 4
+	assert.Len(r.buckets, 1)
+	send(600*time.Millisecond, "x", 5)  // Accumulated
+	send(1250*time.Millisecond, "x", 6) // Forwarded with the mean of 5, 6
+	send(1300*time.Millisecond, "x", 7) // Accumulated, flushed when closing
+	r.Close()
+
+	assert.Len(r.buckets, 0)
+	if assert.Len(out.samples, 5) {
+		values := make([]bitflow.Value, len(out.samples))
+		for i, sample := range out.samples {
+			values[i] = sample.Values[0]
+		}
+		assert.Equal([]bitflow.Value{1, 10, 3, 5.5, 7}, values)
+		assert.Equal("x", out.samples[2].Tag("host"))
+		assert.Equal(time.Unix(1000, 0).Add(550*time.Millisecond), out.samples[2].Time)
+	}
+}
+
+func TestRateLimitProcessorDrop(t *testing.T) {
+	assert := testAssert.New(t)
+	now := time.Unix(1000, 0)
+	r := &RateLimitProcessor{Rate: 10, Mode: RateLimitDrop, now: func() time.Time { return now }}
+	out := new(testSampleCollector)
+	r.SetSink(out)
+	r.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a"}}
+	for i := 0; i < 20; i++ {
+		now = time.Unix(1000, 0).Add(time.Duration(i) * 30 * time.Millisecond)
+		if i == 10 {
+			header = &bitflow.Header{Fields: []string{"b"}} // Resets the bucket
+		}
+		assert.NoError(r.Sample(&bitflow.Sample{Time: now, Values: []bitflow.Value{bitflow.Value(i)}}, header))
+	}
+	r.Close()
+
+	values := make([]bitflow.Value, len(out.samples))
+	for i, sample := range out.samples {
+		values[i] = sample.Values[0]
+	}
+	assert.Equal([]bitflow.Value{0, 4, 8, 10, 14, 18}, values)
+}
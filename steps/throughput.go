@@ -0,0 +1,145 @@
+package steps
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultThroughputInterval = time.Second
+	DefaultThroughputTag      = "throughput"
+)
+
+// ThroughputFields are the metrics of the samples emitted by the ThroughputProcessor.
+var ThroughputFields = []string{"samples_per_sec", "values_per_sec", "fields_per_sample"}
+
+var throughputInstances int32
+
+func RegisterThroughputMonitor(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("throughput",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			step := &ThroughputProcessor{
+				Interval: reg.DurationParam(params, "interval", DefaultThroughputInterval, true, &err),
+				Emit:     reg.BoolParam(params, "emit", false, true, &err),
+				Name:     reg.StrParam(params, "name", "", true, &err),
+				Tag:      reg.StrParam(params, "tag", DefaultThroughputTag, true, &err),
+			}
+			if err == nil && step.Interval <= 0 {
+				err = reg.ParameterError("interval", fmt.Errorf("Must be positive"))
+			}
+			if err == nil {
+				p.Add(step)
+			}
+			return
+		},
+		fmt.Sprintf("Forward all samples and log the number of samples and metric values per second in the given interval (default %v). ", DefaultThroughputInterval)+
+			"The log messages contain the given name, or a sequence number identifying the step in the pipeline. "+
+			fmt.Sprintf("With emit=true, the measurements are additionally sent as samples with the metrics %v and the tag %v=<name> (the tag name can be changed).", ThroughputFields, DefaultThroughputTag),
+		reg.OptionalParams("interval", "emit", "name", "tag"))
+}
+
+// ThroughputProcessor forwards all samples and logs the number of samples and metric values per second every Interval.
+// The log messages contain the Name of the processor, so that multiple ThroughputProcessors can be used to locate
+// bottlenecks in a pipeline. If Name is empty, a sequence number is used. If Emit is true, the measurements are
+// additionally forwarded as samples with the ThroughputFields metrics, tagged with Tag=Name. The header of these
+// samples differs from the header of the forwarded samples, which causes header changes in the following steps.
+// Without Emit, the processor only counts the samples, without any locking.
+type ThroughputProcessor struct {
+	// Accessed atomically, placed first for 64-bit alignment
+	samples int64
+	values  int64
+
+	bitflow.NoopProcessor
+	Interval time.Duration
+	Emit     bool
+	Name     string
+	Tag      string
+
+	lock       sync.Mutex
+	header     *bitflow.Header
+	lastReport time.Time
+	stopper    golib.StopChan
+}
+
+func (p *ThroughputProcessor) Start(wg *sync.WaitGroup) golib.StopChan {
+	if p.Name == "" {
+		p.Name = fmt.Sprintf("#%v", atomic.AddInt32(&throughputInstances, 1))
+	}
+	p.header = &bitflow.Header{Fields: ThroughputFields}
+	p.lastReport = time.Now()
+	p.stopper = golib.NewStopChan()
+	wg.Add(1)
+	go p.loop(wg)
+	return p.NoopProcessor.Start(wg)
+}
+
+func (p *ThroughputProcessor) loop(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for p.stopper.WaitTimeout(p.Interval) {
+		p.stopper.IfNotStopped(func() {
+			if err := p.report(); err != nil {
+				log.Errorf("%v: Error sending throughput sample: %v", p, err)
+			}
+		})
+	}
+}
+
+func (p *ThroughputProcessor) report() error {
+	now := time.Now()
+	seconds := now.Sub(p.lastReport).Seconds()
+	p.lastReport = now
+	samples := atomic.SwapInt64(&p.samples, 0)
+	values := atomic.SwapInt64(&p.values, 0)
+
+	var samplesPerSec, valuesPerSec, fieldsPerSample float64
+	if seconds > 0 {
+		samplesPerSec = float64(samples) / seconds
+		valuesPerSec = float64(values) / seconds
+	}
+	if samples > 0 {
+		fieldsPerSample = float64(values) / float64(samples)
+	}
+	log.Printf("%v: %.2f samples/s, %.2f values/s, %.2f fields per sample", p, samplesPerSec, valuesPerSec, fieldsPerSample)
+
+	if !p.Emit {
+		return nil
+	}
+	sample := &bitflow.Sample{
+		Time:   now,
+		Values: []bitflow.Value{bitflow.Value(samplesPerSec), bitflow.Value(valuesPerSec), bitflow.Value(fieldsPerSample)},
+	}
+	sample.SetTag(p.Tag, p.Name)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.NoopProcessor.Sample(sample, p.header)
+}
+
+func (p *ThroughputProcessor) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	atomic.AddInt64(&p.samples, 1)
+	atomic.AddInt64(&p.values, int64(len(sample.Values)))
+	if p.Emit {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+	}
+	return p.NoopProcessor.Sample(sample, header)
+}
+
+func (p *ThroughputProcessor) Close() {
+	p.stopper.Stop()
+	p.NoopProcessor.Close()
+}
+
+func (p *ThroughputProcessor) String() string {
+	res := fmt.Sprintf("Throughput %v every %v", p.Name, p.Interval)
+	if p.Emit {
+		res += " (emit samples)"
+	}
+	return res
+}
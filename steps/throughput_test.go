@@ -0,0 +1,47 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestThroughputProcessor(t *testing.T) {
+	assert := testAssert.New(t)
+	const interval = 100 * time.Millisecond
+	p := &ThroughputProcessor{Interval: interval, Emit: true, Name: "test", Tag: DefaultThroughputTag}
+	out := new(testSampleCollector)
+	p.SetSink(out)
+	var wg sync.WaitGroup
+	p.Start(&wg)
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	for i := 0; i < 10; i++ {
+		assert.NoError(p.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, header))
+	}
+	time.Sleep(interval + interval/2)
+	p.Close()
+	wg.Wait()
+
+	var real []*bitflow.Sample
+	var measurements []*bitflow.Sample
+	for i, sample := range out.samples {
+		if sample.Tag(DefaultThroughputTag) == "" {
+			real = append(real, sample)
+		} else {
+			assert.Equal("test", sample.Tag(DefaultThroughputTag))
+			assert.Equal(ThroughputFields, out.headers[i].Fields)
+			measurements = append(measurements, sample)
+		}
+	}
+	assert.Len(real, 10)
+	if assert.Len(measurements, 1) {
+		values := measurements[0].Values
+		assert.InDelta(10/interval.Seconds(), float64(values[0]), 10/interval.Seconds()/3)
+		assert.InDelta(20/interval.Seconds(), float64(values[1]), 20/interval.Seconds()/3)
+		assert.Equal(bitflow.Value(2), values[2])
+	}
+}
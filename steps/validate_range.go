@@ -0,0 +1,201 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RangeViolationDrop  = "drop"
+	RangeViolationTag   = "tag"
+	RangeViolationError = "error"
+
+	DefaultRangeViolationTag = "range-violation"
+)
+
+func RegisterRangeValidator(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("validate_range",
+		func(p *bitflow.SamplePipeline, params map[string]string) error {
+			var err error
+			policy := reg.StrParam(params, "on-violation", RangeViolationDrop, true, &err)
+			tag := reg.StrParam(params, "tag", DefaultRangeViolationTag, true, &err)
+			if err != nil {
+				return err
+			}
+			delete(params, "on-violation")
+			delete(params, "tag")
+			if len(params) == 0 {
+				return errors.New("Need at least one metric=min:max parameter")
+			}
+
+			validator := &RangeValidator{
+				OnViolation:  policy,
+				ViolationTag: tag,
+			}
+			// Add the rules in a fixed order, so that the list of violated metrics is deterministic
+			metrics := make([]string, 0, len(params))
+			for metric := range params {
+				metrics = append(metrics, metric)
+			}
+			sort.Strings(metrics)
+			for _, metric := range metrics {
+				if err := validator.AddRule(metric, params[metric]); err != nil {
+					return reg.ParameterError(metric, err)
+				}
+			}
+			if err := validator.checkPolicy(); err != nil {
+				return reg.ParameterError("on-violation", err)
+			}
+			p.Add(validator)
+			return nil
+		},
+		"Check that metric values (matched by the regex keys) are within the given min:max ranges (either bound can be empty). "+
+			"Violating samples are dropped, tagged with the violated metrics, or make the pipeline fail, based on on-violation=drop|tag|error")
+}
+
+type ValueRangeRule struct {
+	Metric *regexp.Regexp
+	Min    float64
+	Max    float64
+}
+
+func (rule ValueRangeRule) Matches(val bitflow.Value) bool {
+	v := float64(val)
+	return v >= rule.Min && v <= rule.Max
+}
+
+func (rule ValueRangeRule) String() string {
+	return fmt.Sprintf("%v in [%v, %v]", rule.Metric, rule.Min, rule.Max)
+}
+
+// RangeValidator checks the values of every sample against a set of ValueRangeRules.
+// Samples that violate one of the rules are handled according to the OnViolation policy.
+type RangeValidator struct {
+	bitflow.NoopProcessor
+	Rules        []ValueRangeRule
+	OnViolation  string // One of RangeViolationDrop, RangeViolationTag, RangeViolationError
+	ViolationTag string // The tag that is set for RangeViolationTag, defaults to DefaultRangeViolationTag
+
+	checker       bitflow.HeaderChecker
+	ruleIndices   [][]int // For each rule, the indices of the matching header fields
+	warnedMissing map[int]bool
+}
+
+// AddRule parses the given value range in the form min:max and adds a rule for metrics matching the given regex.
+// Both min and max can be left empty to leave that side of the range unbounded.
+func (v *RangeValidator) AddRule(metricRegex string, valueRange string) error {
+	regex, err := regexp.Compile(metricRegex)
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(valueRange, ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("Value range must be in the form min:max, received: %v", valueRange)
+	}
+	rule := ValueRangeRule{
+		Metric: regex,
+		Min:    math.Inf(-1),
+		Max:    math.Inf(1),
+	}
+	if parts[0] != "" {
+		if rule.Min, err = strconv.ParseFloat(parts[0], 64); err != nil {
+			return err
+		}
+	}
+	if parts[1] != "" {
+		if rule.Max, err = strconv.ParseFloat(parts[1], 64); err != nil {
+			return err
+		}
+	}
+	if rule.Min > rule.Max {
+		return fmt.Errorf("Minimum value %v is larger than maximum value %v", rule.Min, rule.Max)
+	}
+	v.Rules = append(v.Rules, rule)
+	return nil
+}
+
+func (v *RangeValidator) checkPolicy() error {
+	switch v.OnViolation {
+	case RangeViolationDrop, RangeViolationTag, RangeViolationError:
+		return nil
+	default:
+		return fmt.Errorf("Unknown policy '%v', expected one of %v, %v, %v", v.OnViolation, RangeViolationDrop, RangeViolationTag, RangeViolationError)
+	}
+}
+
+func (v *RangeValidator) Start(wg *sync.WaitGroup) golib.StopChan {
+	if v.ViolationTag == "" {
+		v.ViolationTag = DefaultRangeViolationTag
+	}
+	if err := v.checkPolicy(); err != nil {
+		return golib.NewStoppedChan(err)
+	}
+	return v.NoopProcessor.Start(wg)
+}
+
+func (v *RangeValidator) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if v.checker.HeaderChanged(header) {
+		v.updateHeader(header)
+	}
+	if len(sample.Values) != len(header.Fields) {
+		return fmt.Errorf("%v: Sample has %v values, but header has %v fields", v, len(sample.Values), len(header.Fields))
+	}
+	var violated []string
+	var violatedIndices map[int]bool // Fields matched by multiple rules are only reported once
+	for ruleIndex, indices := range v.ruleIndices {
+		rule := v.Rules[ruleIndex]
+		for _, fieldIndex := range indices {
+			if !rule.Matches(sample.Values[fieldIndex]) && !violatedIndices[fieldIndex] {
+				if violatedIndices == nil {
+					violatedIndices = make(map[int]bool)
+				}
+				violatedIndices[fieldIndex] = true
+				violated = append(violated, header.Fields[fieldIndex])
+			}
+		}
+	}
+	if len(violated) > 0 {
+		switch v.OnViolation {
+		case RangeViolationTag:
+			sample.SetTag(v.ViolationTag, strings.Join(violated, "|"))
+		case RangeViolationError:
+			return fmt.Errorf("%v: Sample at %v violates the value range of metric(s) %v", v, sample.Time, violated)
+		default:
+			return nil
+		}
+	}
+	return v.NoopProcessor.Sample(sample, header)
+}
+
+func (v *RangeValidator) updateHeader(header *bitflow.Header) {
+	if v.warnedMissing == nil {
+		v.warnedMissing = make(map[int]bool)
+	}
+	v.ruleIndices = make([][]int, len(v.Rules))
+	for ruleIndex, rule := range v.Rules {
+		for fieldIndex, field := range header.Fields {
+			if rule.Metric.MatchString(field) {
+				v.ruleIndices[ruleIndex] = append(v.ruleIndices[ruleIndex], fieldIndex)
+			}
+		}
+		if len(v.ruleIndices[ruleIndex]) == 0 && !v.warnedMissing[ruleIndex] {
+			v.warnedMissing[ruleIndex] = true
+			log.Warnf("%v: No metric matches %v, skipping the rule. This warning is printed once per rule.", v, rule.Metric)
+		}
+	}
+}
+
+func (v *RangeValidator) String() string {
+	return fmt.Sprintf("Validate value ranges (%v rule(s), on violation: %v)", len(v.Rules), v.OnViolation)
+}
@@ -0,0 +1,121 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+// testSampleCollector stores all received samples and headers
+type testSampleCollector struct {
+	bitflow.DroppingSampleProcessor
+	samples []*bitflow.Sample
+	headers []*bitflow.Header
+}
+
+func (c *testSampleCollector) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	c.samples = append(c.samples, sample)
+	c.headers = append(c.headers, header)
+	return nil
+}
+
+func _makeRangeValidator(t *testing.T, policy string) (*RangeValidator, *testSampleCollector) {
+	assert := testAssert.New(t)
+	validator := &RangeValidator{OnViolation: policy}
+	assert.NoError(validator.AddRule("^cpu", "0:100"))
+	assert.NoError(validator.AddRule("^mem$", ":50"))
+	assert.NoError(validator.AddRule("^missing$", "1:"))
+	out := new(testSampleCollector)
+	validator.SetSink(out)
+	validator.Start(new(sync.WaitGroup))
+	return validator, out
+}
+
+func _validateSamples(validator *RangeValidator) []error {
+	header := &bitflow.Header{Fields: []string{"cpu1", "cpu2", "mem"}}
+	values := [][]bitflow.Value{
+		{10, 20, 30},  // valid
+		{10, 200, 30}, // cpu2 too large
+		{-1, 20, 60},  // cpu1 too small, mem too large
+		{0, 100, 50},  // valid, boundaries included
+	}
+	errors := make([]error, len(values))
+	for i, vals := range values {
+		errors[i] = validator.Sample(&bitflow.Sample{Values: vals}, header)
+	}
+	return errors
+}
+
+func TestRangeValidatorDrop(t *testing.T) {
+	assert := testAssert.New(t)
+	validator, out := _makeRangeValidator(t, RangeViolationDrop)
+	for _, err := range _validateSamples(validator) {
+		assert.NoError(err)
+	}
+	assert.Len(out.samples, 2)
+	assert.Equal([]bitflow.Value{10, 20, 30}, out.samples[0].Values)
+	assert.Equal([]bitflow.Value{0, 100, 50}, out.samples[1].Values)
+}
+
+func TestRangeValidatorTag(t *testing.T) {
+	assert := testAssert.New(t)
+	validator, out := _makeRangeValidator(t, RangeViolationTag)
+	for _, err := range _validateSamples(validator) {
+		assert.NoError(err)
+	}
+	assert.Len(out.samples, 4)
+	assert.False(out.samples[0].HasTag(DefaultRangeViolationTag))
+	assert.Equal("cpu2", out.samples[1].Tag(DefaultRangeViolationTag))
+	assert.Equal("cpu1|mem", out.samples[2].Tag(DefaultRangeViolationTag))
+	assert.False(out.samples[3].HasTag(DefaultRangeViolationTag))
+}
+
+func TestRangeValidatorError(t *testing.T) {
+	assert := testAssert.New(t)
+	validator, out := _makeRangeValidator(t, RangeViolationError)
+	errors := _validateSamples(validator)
+	assert.NoError(errors[0])
+	assert.Error(errors[1])
+	assert.Error(errors[2])
+	assert.NoError(errors[3])
+	assert.Len(out.samples, 2)
+}
+
+func TestRangeValidatorRules(t *testing.T) {
+	assert := testAssert.New(t)
+	validator := new(RangeValidator)
+	assert.Error(validator.AddRule("x", "10"))
+	assert.Error(validator.AddRule("x", "a:10"))
+	assert.Error(validator.AddRule("x", "10:1"))
+	assert.Error(validator.AddRule("(", "1:10"))
+	assert.Empty(validator.Rules)
+
+	validator.OnViolation = "unknown"
+	assert.Error(validator.checkPolicy())
+}
+
+func TestRangeValidatorDuplicateViolations(t *testing.T) {
+	assert := testAssert.New(t)
+	registry := reg.NewProcessorRegistry()
+	RegisterRangeValidator(registry)
+	analysis, ok := registry.GetAnalysis("validate_range")
+	assert.True(ok)
+	var pipe bitflow.SamplePipeline
+	params := map[string]string{"on-violation": RangeViolationTag, "^mem$": ":50", "^cpu1$": "0:5", "^cpu": "0:100"}
+	assert.NoError(analysis.Func(&pipe, params))
+	validator := pipe.Processors[0].(*RangeValidator)
+	assert.Equal([]string{"^cpu", "^cpu1$", "^mem$"}, []string{validator.Rules[0].Metric.String(), validator.Rules[1].Metric.String(), validator.Rules[2].Metric.String()})
+
+	out := new(testSampleCollector)
+	validator.SetSink(out)
+	validator.Start(new(sync.WaitGroup))
+	header := &bitflow.Header{Fields: []string{"cpu1", "cpu2", "mem"}}
+	assert.NoError(validator.Sample(&bitflow.Sample{Values: []bitflow.Value{200, 200, 60}}, header))
+	if assert.Len(out.samples, 1) {
+		assert.Equal("cpu1|cpu2|mem", out.samples[0].Tag(DefaultRangeViolationTag))
+	}
+	assert.Error(validator.Sample(&bitflow.Sample{Values: []bitflow.Value{1, 2}}, header))
+}
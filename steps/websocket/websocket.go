@@ -0,0 +1,351 @@
+package websocket
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antongulenko/golib"
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	WebSocketEndpoint = bitflow.EndpointType("ws")
+
+	// WebSocketWriteTimeout limits the time for sending one message to a client. Clients that do not
+	// receive the message in time are disconnected.
+	WebSocketWriteTimeout = 10 * time.Second
+)
+
+// DefaultWebSocketConfig contains the defaults for the -ws-* command line flags.
+var DefaultWebSocketConfig = WebSocketConfig{
+	Format:       bitflow.JsonFormat,
+	ClientBuffer: 1000,
+}
+
+// WebSocketConfig configures a WebSocketSink.
+type WebSocketConfig struct {
+	Format bitflow.MarshallingFormat
+
+	// If BufferedSamples is > 0, the given number of samples is kept in a ring buffer. New clients first receive
+	// all samples currently in the buffer, and afterwards continue receiving live samples, like in bitflow.TCPListenerSink.
+	BufferedSamples uint
+
+	// ClientBuffer is the number of messages that are queued for every client. Clients that do not keep up with
+	// the incoming samples and exceed the queue are disconnected, so that they do not block the pipeline.
+	ClientBuffer int
+}
+
+// RegisterWebSocketEndpoints registers the 'ws' data sink. The endpoints have the form ws://host:port/path?format=json&buffer=0&client-buffer=1000,
+// where the host and path are optional. The query parameters override the values set through the -ws-* command line flags.
+func RegisterWebSocketEndpoints(b reg.ProcessorRegistry) {
+	config := DefaultWebSocketConfig
+	b.Endpoints.CustomOutputFlags = append(b.Endpoints.CustomOutputFlags, func(f *flag.FlagSet) {
+		f.StringVar((*string)(&config.Format), "ws-format", string(config.Format), "Data format for marshalling samples sent to WebSocket clients")
+		f.UintVar(&config.BufferedSamples, "ws-buffer", config.BufferedSamples, "Number of samples buffered by WebSocket outputs and sent to newly connected clients")
+		f.IntVar(&config.ClientBuffer, "ws-client-buffer", config.ClientBuffer, "Number of messages queued for every WebSocket client. Slower clients are disconnected.")
+	})
+	b.Endpoints.CustomDataSinks[WebSocketEndpoint] = func(target string) (bitflow.SampleProcessor, error) {
+		endpoint, path, endpointConfig, err := ParseWebSocketEndpoint(target, config)
+		if err != nil {
+			return nil, err
+		}
+		marshaller, err := b.Endpoints.CreateMarshaller(endpointConfig.Format)
+		if err != nil {
+			return nil, err
+		}
+		return &WebSocketSink{
+			Endpoint:   endpoint,
+			Path:       path,
+			Config:     endpointConfig,
+			Marshaller: marshaller,
+		}, nil
+	}
+}
+
+// ParseWebSocketEndpoint parses an endpoint target in the form host:port/path?param=value. The host and path are optional.
+// The query parameters format, buffer and client-buffer override the values in the given config.
+func ParseWebSocketEndpoint(target string, config WebSocketConfig) (endpoint string, path string, _ WebSocketConfig, err error) {
+	endpoint, path = target, "/"
+	if index := strings.IndexByte(target, '?'); index >= 0 {
+		var query url.Values
+		query, err = url.ParseQuery(target[index+1:])
+		if err != nil {
+			return
+		}
+		endpoint = target[:index]
+		for key, values := range query {
+			value := values[len(values)-1]
+			switch key {
+			case "format":
+				config.Format = bitflow.MarshallingFormat(value)
+			case "buffer":
+				var buffer uint64
+				buffer, err = strconv.ParseUint(value, 10, 64)
+				config.BufferedSamples = uint(buffer)
+			case "client-buffer":
+				config.ClientBuffer, err = strconv.Atoi(value)
+			default:
+				err = fmt.Errorf("Unknown %v endpoint parameter: %v", WebSocketEndpoint, key)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	if index := strings.IndexByte(endpoint, '/'); index >= 0 {
+		endpoint, path = endpoint[:index], endpoint[index:]
+	}
+	if _, _, splitErr := net.SplitHostPort(endpoint); splitErr != nil {
+		err = fmt.Errorf("WebSocket endpoint must have the form host:port/path, received: %v", target)
+	} else if config.ClientBuffer < 1 {
+		err = fmt.Errorf("The WebSocket client buffer must be positive, received %v", config.ClientBuffer)
+	}
+	return endpoint, path, config, err
+}
+
+// WebSocketSink starts an HTTP server on Endpoint, which accepts WebSocket connections on Path and streams the
+// marshalled samples to all connected clients. Every message contains either one marshalled header or one marshalled sample.
+// New clients first receive the current header, followed by the samples in the buffer (see WebSocketConfig.BufferedSamples),
+// and afterwards the live samples. Whenever the header changes, the new header is sent before the next sample.
+// Samples are marshalled once and queued for every client. Clients whose queue is full are disconnected, so slow or
+// disconnected clients never block the pipeline.
+type WebSocketSink struct {
+	bitflow.AbstractSampleOutput
+	Endpoint   string
+	Path       string
+	Config     WebSocketConfig
+	Marshaller bitflow.Marshaller
+
+	listener net.Listener
+	server   *http.Server
+	upgrader websocket.Upgrader
+	stopped  golib.StopChan
+	wg       *sync.WaitGroup
+
+	lock    sync.Mutex
+	closed  bool
+	clients map[*webSocketClient]bool
+	checker bitflow.HeaderChecker
+	header  *webSocketMessage
+	buffer  []*webSocketMessage // Ring buffer of the last samples
+	next    int
+}
+
+// webSocketMessage contains a marshalled sample together with the marshalled header it belongs to.
+// For clients that only receive the current header, sample is nil.
+type webSocketMessage struct {
+	header     *bitflow.Header
+	headerData []byte
+	sample     []byte
+}
+
+type webSocketClient struct {
+	conn        *websocket.Conn
+	messageType int
+	queue       chan *webSocketMessage
+	header      *bitflow.Header
+	failed      bool
+}
+
+func (sink *WebSocketSink) String() string {
+	return fmt.Sprintf("WebSocket sink on %v%v (format %v)", sink.Endpoint, sink.Path, sink.Config.Format)
+}
+
+func (sink *WebSocketSink) Start(wg *sync.WaitGroup) golib.StopChan {
+	listener, err := net.Listen("tcp", sink.Endpoint)
+	if err != nil {
+		return golib.NewStoppedChan(fmt.Errorf("%v: Failed to listen: %v", sink, err))
+	}
+	sink.listener = listener
+	sink.wg = wg
+	sink.stopped = golib.NewStopChan()
+	sink.clients = make(map[*webSocketClient]bool)
+	if sink.Config.BufferedSamples > 0 {
+		sink.buffer = make([]*webSocketMessage, 0, sink.Config.BufferedSamples)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(sink.Path, sink.handleConnection)
+	sink.server = &http.Server{Handler: mux}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := sink.server.Serve(listener); err != http.ErrServerClosed {
+			sink.stopped.StopErr(fmt.Errorf("%v: %v", sink, err))
+		}
+	}()
+	log.WithField("format", sink.Config.Format).Println("Listening for WebSocket connections on", listener.Addr(), "path", sink.Path)
+	return sink.stopped
+}
+
+func (sink *WebSocketSink) Close() {
+	sink.stopped.StopFunc(func() {
+		sink.lock.Lock()
+		sink.closed = true
+		for client := range sink.clients {
+			sink.removeClient(client)
+		}
+		sink.lock.Unlock()
+		if err := sink.server.Close(); err != nil {
+			log.Errorf("%v: Error closing HTTP server: %v", sink, err)
+		}
+		sink.CloseSink()
+	})
+}
+
+func (sink *WebSocketSink) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	err := sink.enqueue(sample, header)
+	return sink.AbstractSampleOutput.Sample(err, sample, header)
+}
+
+func (sink *WebSocketSink) enqueue(sample *bitflow.Sample, header *bitflow.Header) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.checker.HeaderChanged(header) {
+		var buf bytes.Buffer
+		if err := sink.Marshaller.WriteHeader(header, true, &buf); err != nil {
+			return err
+		}
+		sink.header = &webSocketMessage{header: header, headerData: buf.Bytes()}
+	}
+	if len(sink.clients) == 0 && sink.buffer == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := sink.Marshaller.WriteSample(sample, header, true, &buf); err != nil {
+		return err
+	}
+	msg := &webSocketMessage{header: sink.header.header, headerData: sink.header.headerData, sample: buf.Bytes()}
+	if sink.buffer != nil {
+		if len(sink.buffer) < cap(sink.buffer) {
+			sink.buffer = append(sink.buffer, msg)
+		} else {
+			sink.buffer[sink.next] = msg
+			sink.next = (sink.next + 1) % len(sink.buffer)
+		}
+	}
+	for client := range sink.clients {
+		select {
+		case client.queue <- msg:
+		default:
+			log.WithField("remote", client.conn.RemoteAddr()).Warnf("%v: Disconnecting slow client, %v messages are queued", sink, len(client.queue))
+			sink.removeClient(client)
+		}
+	}
+	return nil
+}
+
+func (sink *WebSocketSink) handleConnection(w http.ResponseWriter, r *http.Request) {
+	conn, err := sink.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.WithField("remote", r.RemoteAddr).Warnf("%v: WebSocket handshake failed: %v", sink, err)
+		return
+	}
+	client := &webSocketClient{
+		conn:        conn,
+		messageType: websocket.TextMessage,
+		queue:       make(chan *webSocketMessage, sink.Config.ClientBuffer+len(sink.buffer)+1),
+	}
+	if sink.Config.Format == bitflow.BinaryFormat {
+		client.messageType = websocket.BinaryMessage
+	}
+
+	sink.lock.Lock()
+	if sink.closed {
+		sink.lock.Unlock()
+		_ = conn.Close() // Drop error
+		return
+	}
+	if len(sink.buffer) > 0 {
+		for i := range sink.buffer {
+			client.queue <- sink.buffer[(sink.next+i)%len(sink.buffer)]
+		}
+	} else if sink.header != nil {
+		client.queue <- sink.header
+	}
+	sink.clients[client] = true
+	sink.wg.Add(1)
+	sink.lock.Unlock()
+
+	log.WithField("remote", conn.RemoteAddr()).Debugln(sink, "accepted WebSocket client")
+	go sink.sendMessages(client)
+	sink.receiveMessages(client)
+}
+
+// receiveMessages discards all incoming messages. It returns when the connection is closed, and removes the client.
+func (sink *WebSocketSink) receiveMessages(client *webSocketClient) {
+	for {
+		if _, _, err := client.conn.NextReader(); err != nil {
+			sink.lock.Lock()
+			sink.removeClient(client)
+			sink.lock.Unlock()
+			return
+		}
+	}
+}
+
+func (sink *WebSocketSink) sendMessages(client *webSocketClient) {
+	defer sink.wg.Done()
+	for msg := range client.queue {
+		if client.failed {
+			continue // Drain the queue until it is closed
+		}
+		if err := client.send(msg); err != nil {
+			log.WithField("remote", client.conn.RemoteAddr()).Debugf("%v: Failed to send message: %v", sink, err)
+			client.failed = true
+			sink.lock.Lock()
+			sink.removeClient(client)
+			sink.lock.Unlock()
+		}
+	}
+	if !client.failed {
+		_ = client.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(WebSocketWriteTimeout)) // Drop error
+	}
+	_ = client.conn.Close() // Drop error
+}
+
+// removeClient must be called while holding the lock. The queue is closed, which stops the sending goroutine.
+func (sink *WebSocketSink) removeClient(client *webSocketClient) {
+	if sink.clients[client] {
+		delete(sink.clients, client)
+		close(client.queue)
+	}
+}
+
+func (sink *WebSocketSink) numClients() int {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return len(sink.clients)
+}
+
+func (client *webSocketClient) send(msg *webSocketMessage) error {
+	if client.header != msg.header {
+		client.header = msg.header
+		if err := client.write(msg.headerData); err != nil {
+			return err
+		}
+	}
+	if msg.sample == nil {
+		return nil
+	}
+	return client.write(msg.sample)
+}
+
+func (client *webSocketClient) write(data []byte) error {
+	if err := client.conn.SetWriteDeadline(time.Now().Add(WebSocketWriteTimeout)); err != nil {
+		return err
+	}
+	return client.conn.WriteMessage(client.messageType, data)
+}
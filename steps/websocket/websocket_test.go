@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/gorilla/websocket"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestParseWebSocketEndpoint(t *testing.T) {
+	assert := testAssert.New(t)
+	endpoint, path, config, err := ParseWebSocketEndpoint(":8080/metrics", DefaultWebSocketConfig)
+	assert.NoError(err)
+	assert.Equal(":8080", endpoint)
+	assert.Equal("/metrics", path)
+	assert.Equal(DefaultWebSocketConfig, config)
+
+	endpoint, path, config, err = ParseWebSocketEndpoint("localhost:1234?format=csv&buffer=10&client-buffer=5", DefaultWebSocketConfig)
+	assert.NoError(err)
+	assert.Equal("localhost:1234", endpoint)
+	assert.Equal("/", path)
+	assert.Equal(WebSocketConfig{Format: bitflow.CsvFormat, BufferedSamples: 10, ClientBuffer: 5}, config)
+
+	for _, invalid := range []string{"", "/metrics", "localhost/metrics", ":80?buffer=-1", ":80?client-buffer=0", ":80?unknown=1"} {
+		_, _, _, err = ParseWebSocketEndpoint(invalid, DefaultWebSocketConfig)
+		assert.Error(err, "endpoint %v", invalid)
+	}
+}
+
+func TestWebSocketSink(t *testing.T) {
+	assert := testAssert.New(t)
+	sink := &WebSocketSink{
+		Endpoint:   "127.0.0.1:0",
+		Path:       "/metrics",
+		Config:     WebSocketConfig{Format: bitflow.CsvFormat, BufferedSamples: 2, ClientBuffer: 10},
+		Marshaller: new(bitflow.CsvMarshaller),
+	}
+	sink.SetSink(new(bitflow.DroppingSampleProcessor))
+	var wg sync.WaitGroup
+	stopped := sink.Start(&wg)
+	assert.NoError(stopped.Err())
+
+	header := &bitflow.Header{Fields: []string{"a", "b"}}
+	sample := func(i int) *bitflow.Sample {
+		return &bitflow.Sample{Time: time.Unix(int64(1000+i), 0), Values: []bitflow.Value{bitflow.Value(i), 0}}
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(sink.Sample(sample(i), header))
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+sink.listener.Addr().String()+"/metrics", nil)
+	assert.NoError(err)
+	for sink.numClients() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(sink.Sample(sample(3), header))
+
+	// The header, followed by the two buffered samples and the live sample
+	var messages []string
+	for i := 0; i < 4; i++ {
+		assert.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		_, data, err := conn.ReadMessage()
+		assert.NoError(err)
+		messages = append(messages, string(data))
+	}
+	assert.True(strings.HasPrefix(messages[0], "time,tags,a,b"), "unexpected header: %v", messages[0])
+	for i, value := range []string{",1,0", ",2,0", ",3,0"} {
+		assert.True(strings.HasSuffix(messages[i+1], value+"\n"), "unexpected sample: %v", messages[i+1])
+	}
+
+	// Disconnected clients are removed without blocking the pipeline
+	assert.NoError(conn.Close())
+	for sink.numClients() > 0 {
+		assert.NoError(sink.Sample(sample(4), header))
+		time.Sleep(time.Millisecond)
+	}
+
+	sink.Close()
+	wg.Wait()
+	assert.NoError(stopped.Err())
+}
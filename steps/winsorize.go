@@ -0,0 +1,118 @@
+package steps
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	"github.com/bitflow-stream/go-bitflow/script/reg"
+)
+
+const (
+	DefaultWinsorizeLower  = 5
+	DefaultWinsorizeUpper  = 95
+	DefaultWinsorizeWindow = 1000
+	DefaultWinsorizeWarmup = 100
+)
+
+func RegisterWinsorizer(b reg.ProcessorRegistry) {
+	b.RegisterAnalysisParamsErr("winsorize",
+		func(p *bitflow.SamplePipeline, params map[string]string) (err error) {
+			lower := reg.FloatParam(params, "lower", DefaultWinsorizeLower, true, &err)
+			upper := reg.FloatParam(params, "upper", DefaultWinsorizeUpper, true, &err)
+			window := reg.IntParam(params, "window", DefaultWinsorizeWindow, true, &err)
+			warmup := reg.IntParam(params, "warmup", DefaultWinsorizeWarmup, true, &err)
+			if err != nil {
+				return
+			}
+			if lower < 0 || upper > 100 || lower > upper {
+				return reg.ParameterError("lower", errors.New("lower and upper must be percentiles in the range 0..100, and lower must not be larger than upper"))
+			}
+			if window < 1 {
+				return reg.ParameterError("window", errors.New("Must be positive"))
+			}
+			if warmup < 1 || warmup > window {
+				return reg.ParameterError("warmup", errors.New("Must be positive and not larger than the window"))
+			}
+			p.Add(&Winsorizer{Lower: lower / 100, Upper: upper / 100, Window: window, Warmup: warmup})
+			return
+		},
+		"Limit every metric value to the range between the given lower and upper percentiles (0..100) of the previous values of that metric. "+
+			"The percentiles are computed over a sliding window of values, values are not limited before the window contains the given number of warmup values. "+
+			"The windows are reset when the header changes.",
+		reg.OptionalParams("lower", "upper", "window", "warmup"))
+}
+
+// Winsorizer limits the values of every metric to the range between the Lower and Upper percentiles (in the range [0, 1])
+// of the previous values of the metric. The percentiles are computed over the last Window valid values of each metric,
+// not including the current value. Before Warmup values have been received, values are forwarded unchanged.
+// The original values are added to the windows, so that the percentiles can follow changes in the value distribution,
+// while single spikes only have a small effect. NaN values are ignored, infinite values are limited, but not added to the windows.
+// All windows are reset when the header changes.
+type Winsorizer struct {
+	bitflow.NoopProcessor
+	Lower  float64
+	Upper  float64
+	Window int
+	Warmup int
+
+	checker bitflow.HeaderChecker
+	windows []*runningPercentiles
+}
+
+func (w *Winsorizer) Sample(sample *bitflow.Sample, header *bitflow.Header) error {
+	if w.checker.HeaderChanged(header) {
+		w.windows = make([]*runningPercentiles, len(header.Fields))
+		for i := range w.windows {
+			w.windows[i] = &runningPercentiles{size: w.Window}
+		}
+	}
+	for i, val := range sample.Values {
+		value := float64(val)
+		if math.IsNaN(value) || i >= len(w.windows) {
+			continue
+		}
+		window := w.windows[i]
+		if len(window.sorted) >= w.Warmup {
+			if lower := sortedPercentile(window.sorted, w.Lower); value < lower {
+				sample.Values[i] = bitflow.Value(lower)
+			} else if upper := sortedPercentile(window.sorted, w.Upper); value > upper {
+				sample.Values[i] = bitflow.Value(upper)
+			}
+		}
+		if !math.IsInf(value, 0) {
+			window.add(value)
+		}
+	}
+	return w.NoopProcessor.Sample(sample, header)
+}
+
+func (w *Winsorizer) String() string {
+	return fmt.Sprintf("Winsorize to percentiles [%v, %v] (window: %v, warmup: %v)", w.Lower*100, w.Upper*100, w.Window, w.Warmup)
+}
+
+// runningPercentiles stores the last values in insertion order and in sorted order, to compute exact percentiles over a sliding window.
+type runningPercentiles struct {
+	size   int
+	ring   []float64
+	next   int
+	sorted []float64
+}
+
+func (r *runningPercentiles) add(val float64) {
+	if len(r.ring) < r.size {
+		r.ring = append(r.ring, val)
+	} else {
+		old := r.ring[r.next]
+		r.ring[r.next] = val
+		r.next = (r.next + 1) % r.size
+		i := sort.SearchFloat64s(r.sorted, old)
+		r.sorted = append(r.sorted[:i], r.sorted[i+1:]...)
+	}
+	i := sort.SearchFloat64s(r.sorted, val)
+	r.sorted = append(r.sorted, 0)
+	copy(r.sorted[i+1:], r.sorted[i:])
+	r.sorted[i] = val
+}
@@ -0,0 +1,47 @@
+package steps
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/bitflow-stream/go-bitflow/bitflow"
+	testAssert "github.com/stretchr/testify/assert"
+)
+
+func TestWinsorizer(t *testing.T) {
+	assert := testAssert.New(t)
+	w := &Winsorizer{Lower: 0.05, Upper: 0.95, Window: 100, Warmup: 50}
+	out := new(testSampleCollector)
+	w.SetSink(out)
+	w.Start(new(sync.WaitGroup))
+
+	header := &bitflow.Header{Fields: []string{"a"}}
+	spikes := map[int]bitflow.Value{20: 1e6, 150: 1e6, 250: -1e6}
+	for i := 0; i < 300; i++ {
+		value := bitflow.Value(i % 100)
+		if spike, ok := spikes[i]; ok {
+			value = spike
+		}
+		assert.NoError(w.Sample(&bitflow.Sample{Values: []bitflow.Value{value}}, header))
+	}
+	// The header change resets the windows, so the spike is not limited
+	otherHeader := &bitflow.Header{Fields: []string{"b"}}
+	assert.NoError(w.Sample(&bitflow.Sample{Values: []bitflow.Value{1e6}}, otherHeader))
+	w.Close()
+
+	assert.Len(out.samples, 301)
+	value := func(i int) float64 {
+		return float64(out.samples[i].Values[0])
+	}
+	assert.Equal(1e6, value(20), "no limits during warmup")
+	assert.Equal(float64(40), value(40))
+
+	// At index 150, the window contains the values 0..99
+	assert.InDelta(94.05, value(150), 1e-9)
+	assert.Equal(float64(60), value(160), "values within the bounds are not changed")
+
+	// Afterwards, the window contains one spike instead of the value 50
+	assert.InDelta(95.05, value(199), 1e-9, "values above the upper percentile are limited")
+	assert.InDelta(4.95, value(250), 1e-9)
+	assert.Equal(1e6, value(300))
+}